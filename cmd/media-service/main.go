@@ -2,60 +2,52 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"github.com/ondrasimku/media-service-go/internal/app"
 	"github.com/ondrasimku/media-service-go/internal/config"
-	httphandler "github.com/ondrasimku/media-service-go/internal/http"
 	"github.com/ondrasimku/media-service-go/internal/log"
-	"github.com/ondrasimku/media-service-go/internal/storage/local"
 )
 
 func main() {
-	cfg, err := config.Load()
+	configFile := flag.String("config", os.Getenv("MEDIA_CONFIG_FILE"), "YAML or TOML config file; the environment overrides its settings")
+	validate := flag.Bool("validate-config", false, "Validate the configuration and exit")
+	flag.Parse()
+
+	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
 	}
+	if *validate {
+		if cfg.File != "" {
+			fmt.Printf("Config file %s is valid\n", cfg.File)
+			for _, key := range cfg.Overridden {
+				fmt.Printf("  %s is overridden by the environment\n", key)
+			}
+		} else {
+			fmt.Println("Configuration is valid")
+		}
+		return
+	}
 
 	logger := log.NewLogger()
 
-	storage, err := local.NewLocalStorage(cfg.StorageDir, cfg.PublicBaseURL)
+	application, err := app.New(cfg, logger)
 	if err != nil {
-		logger.Error("Failed to initialize storage", "error", err)
+		logger.Error("Failed to initialize media service", "error", err)
 		os.Exit(1)
 	}
 
-	router := httphandler.NewRouter(storage, cfg.MaxFileSize, cfg, logger)
-
-	srv := &http.Server{
-		Addr:    cfg.HTTPAddr,
-		Handler: router,
-	}
-
-	go func() {
-		logger.Info("Starting media service", "addr", cfg.HTTPAddr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("Server failed to start", "error", err)
-			os.Exit(1)
-		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	logger.Info("Shutting down server")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		logger.Error("Server forced to shutdown", "error", err)
+	if err := application.Run(ctx); err != nil {
+		logger.Error("Media service failed", "error", err)
 		os.Exit(1)
 	}
 
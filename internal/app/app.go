@@ -0,0 +1,680 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/config"
+	"github.com/ondrasimku/media-service-go/internal/cron"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/drain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/geoip"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	httphandler "github.com/ondrasimku/media-service-go/internal/http"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/integrity"
+	"github.com/ondrasimku/media-service-go/internal/jobs"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metadata/jsonfile"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/qc"
+	"github.com/ondrasimku/media-service-go/internal/replication"
+	"github.com/ondrasimku/media-service-go/internal/rpc"
+	"github.com/ondrasimku/media-service-go/internal/scan"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+	"github.com/ondrasimku/media-service-go/internal/signedurl"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/storage/local"
+	"github.com/ondrasimku/media-service-go/internal/tracing"
+	"github.com/ondrasimku/media-service-go/internal/transcode"
+	"github.com/ondrasimku/media-service-go/internal/tuning"
+	"github.com/ondrasimku/media-service-go/internal/video"
+	"github.com/ondrasimku/media-service-go/internal/webhook"
+	"google.golang.org/grpc"
+)
+
+const (
+	// shutdownTimeout bounds how long requests in flight may take to finish
+	// once the service is stopped.
+	shutdownTimeout = 5 * time.Second
+
+	// pushTimeout bounds putting the final metrics to the Pushgateway.
+	pushTimeout = 5 * time.Second
+)
+
+// App is the assembled service. New builds it layer by layer, from the
+// stores up to the HTTP server, and Run starts the background workers and
+// serves until stopped. New subsystems get a field and are built in the
+// layer they belong to.
+type App struct {
+	cfg    *config.Config
+	logger *slog.Logger
+
+	stopTracing func(context.Context) error
+
+	storage  storage.Storage
+	replica  storage.Storage         // May be nil
+	blobs    integrity.Lister        // The primary backend, unwrapped
+	metadata metadata.Backend        // Records writes for replication when it is on
+	replicas *replication.Replicator // Nil when replication is off
+	events   *events.Emitter         // Nil when publishing and organization webhooks are off
+
+	auditor  *integrity.Auditor
+	scrubber *integrity.Scrubber
+	gc       *integrity.Collector
+	cron     *cron.Scheduler
+
+	stats      *stats.Recorder
+	metering   *metering.Recorder
+	governor   *governor.Governor
+	plans      *plans.Resolver
+	transcodes *transcode.Queue
+	jobs       jobs.Queue // Nil when jobs run inline
+
+	audit     *audit.Trail
+	brandings *media.BrandingService
+	feeds     *media.FeedService
+	quotas    *media.QuotaService
+	geo       *media.GeoService
+	orgs      *media.OrgService
+	settings  *media.SettingsService
+	presets   *media.PresetService
+	files     *media.FileService
+	uploads   *media.UploadService
+
+	jwks     *auth.JWKSClient   // Shared by the HTTP and gRPC APIs
+	tokens   *auth.Introspector // Likewise; nil when introspection is off
+	inFlight *drain.Tracker     // Requests of both APIs and jobs, for the shutdown report
+	server   *http.Server
+	redirect *http.Server // Redirects plain HTTP to HTTPS; nil when off
+	grpc     *grpc.Server // Nil when the gRPC API is off
+}
+
+func New(cfg *config.Config, logger *slog.Logger) (*App, error) {
+	a := &App{cfg: cfg, logger: logger, inFlight: drain.NewTracker()}
+
+	a.tuneRuntime()
+	if err := a.setupTracing(); err != nil {
+		return nil, err
+	}
+	if err := a.openStores(); err != nil {
+		return nil, err
+	}
+	if err := a.connectEvents(); err != nil {
+		return nil, err
+	}
+	a.buildIntegrity()
+	a.buildProcessing()
+	a.buildServices()
+	a.buildCron()
+	a.buildServer()
+	if err := a.setupTLS(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// tuneRuntime configures the Go runtime before anything sized from
+// GOMAXPROCS is built.
+func (a *App) tuneRuntime() {
+	tuning.Apply(tuning.Config{
+		MaxProcs:    a.cfg.Runtime.MaxProcs,
+		GCPercent:   a.cfg.Runtime.GCPercent,
+		MemoryLimit: a.cfg.Runtime.MemoryLimit,
+	}, a.logger)
+	if a.cfg.Processing.MaxCPU < 0 {
+		a.cfg.Processing.MaxCPU = runtime.GOMAXPROCS(0)
+	}
+}
+
+// setupTracing installs the tracer provider before the instrumented
+// subsystems are built.
+func (a *App) setupTracing() error {
+	// The SDK reads its settings from the environment only.
+	for key, value := range a.cfg.Tracing.Env {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to initialize tracing: %w", err)
+		}
+	}
+	var err error
+	a.stopTracing, err = tracing.Setup(context.Background(), a.cfg.Tracing.Enabled, a.cfg.Tracing.ServiceName)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	if a.cfg.Tracing.Enabled {
+		a.logger.Info("Exporting traces", "serviceName", a.cfg.Tracing.ServiceName)
+	}
+	return nil
+}
+
+func (a *App) openStores() error {
+	var err error
+	primary, err := local.NewLocalStorage(a.cfg.StorageDir, a.cfg.PublicBaseURL, a.cfg.Integrity.ChecksumAlgorithms, a.cfg.Directories)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	a.storage = storage.Traced(primary)
+	a.blobs = primary
+
+	// The replica is only read from when repairing corrupt blobs, so it
+	// needs no checksum algorithms. It mirrors the directories of the
+	// primary.
+	if dir := a.cfg.Integrity.ReplicaDir; dir != "" {
+		a.replica, err = local.NewLocalStorage(dir, a.cfg.PublicBaseURL, nil, a.cfg.Directories)
+		if err != nil {
+			return fmt.Errorf("failed to initialize replica storage: %w", err)
+		}
+	}
+
+	a.metadata, err = jsonfile.NewStore(a.cfg.MetadataDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize metadata store: %w", err)
+	}
+
+	if a.cfg.Replication.Enabled {
+		a.replicas = replication.NewReplicator(a.metadata, a.storage, replication.Config{
+			Region:    a.cfg.Region.Name,
+			Peers:     a.cfg.Region.Peers,
+			Secret:    a.cfg.Replication.Secret,
+			Conflicts: a.cfg.Replication.Conflicts,
+			Interval:  a.cfg.Replication.Interval,
+			MaxLag:    a.cfg.Replication.MaxLag,
+		}, a.logger)
+		a.metadata = a.replicas.Store()
+		a.logger.Info("Replicating files", "region", a.cfg.Region.Name, "peers", a.replicas.Peers(), "conflicts", a.cfg.Replication.Conflicts)
+	}
+	return nil
+}
+
+func (a *App) connectEvents() error {
+	if a.cfg.Events.NATSURL == "" {
+		// Organizations may still have events delivered to their webhooks.
+		if slices.Contains(a.cfg.Orgs.Settings, media.SettingWebhooks) {
+			a.events = events.NewEmitter(nil, a.logger)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	publisher, err := events.NewNATSPublisher(ctx, a.cfg.Events.NATSURL, a.cfg.Events.Stream, a.cfg.Events.SubjectPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to initialize event publishing: %w", err)
+	}
+	a.events = events.NewEmitter(publisher, a.logger)
+	return nil
+}
+
+func (a *App) buildIntegrity() {
+	a.auditor = integrity.NewAuditor(a.storage, a.metadata, a.cfg.Integrity.ManifestSigningKey, a.logger)
+	a.scrubber = integrity.NewScrubber(a.storage, a.replica, a.metadata, integrity.ScrubConfig{
+		BytesPerSecond: int64(a.cfg.Integrity.ScrubRate),
+	}, a.logger)
+	a.gc = integrity.NewCollector(a.storage, a.blobs, a.metadata, integrity.GCConfig{
+		MinAge: a.cfg.Integrity.GCMinAge,
+		DryRun: a.cfg.Integrity.GCDryRun,
+	}, a.logger)
+}
+
+func (a *App) buildProcessing() {
+	a.stats = stats.NewRecorder(a.cfg.Stats.Retention)
+	a.metering = metering.NewRecorder(a.metadata, a.metadata, a.logger)
+	a.governor = governor.New(governor.Config{
+		MaxMemory: a.cfg.Processing.MaxMemory,
+		MaxCPU:    a.cfg.Processing.MaxCPU,
+		MaxWait:   a.cfg.Processing.MaxWait,
+		MaxQueued: a.cfg.Processing.MaxQueued,
+	})
+
+	a.plans = plans.NewResolver(a.cfg.Plans.Plans, a.cfg.Jobs.Tiers, a.cfg.Plans.Default,
+		plans.NewEntitlements(a.cfg.Plans.EntitlementsURL, a.cfg.Plans.EntitlementsCacheTTL), a.logger)
+
+	transcoder := video.NewTranscoder(a.cfg.Video.FFmpegPath, a.cfg.Video.TranscodeThreads)
+	a.presets = media.NewPresetService(a.metadata, a.logger)
+	a.transcodes = transcode.NewQueue(a.storage, a.metadata, transcoder, transcode.Config{
+		Heights: a.cfg.Video.TranscodeHeights,
+		Workers: a.cfg.Video.TranscodeWorkers,
+
+		PosterOffset:    a.cfg.Video.PosterOffset,
+		TeaserFormat:    a.cfg.Video.TeaserFormat,
+		Teaser:          teaserOptions(a.cfg.Video),
+		SegmentDuration: a.cfg.Video.HLSSegmentDuration,
+		Presets:         a.presets,
+		Metering:        a.metering,
+	}, a.governor, a.stats, a.events, a.logger)
+	if !a.transcodes.Enabled() && a.cfg.Video.MaxSize > 0 {
+		a.logger.Warn("Video transcoding disabled, videos are served as uploaded", "ffmpeg", a.cfg.Video.FFmpegPath)
+	}
+
+	// Without workers the queue is left out and its work runs inline.
+	pool := jobs.NewPool(a.metadata, jobs.Config{
+		Workers:     a.cfg.Jobs.Workers,
+		MaxAttempts: a.cfg.Jobs.MaxAttempts,
+		Backoff:     a.cfg.Jobs.Backoff,
+		MaxBackoff:  a.cfg.Jobs.MaxBackoff,
+		Retention:   a.cfg.Jobs.Retention,
+		Metering:    a.metering,
+
+		Priorities:     a.cfg.Jobs.Priorities,
+		Tier:           a.plans.Tier,
+		TierPriorities: a.cfg.Jobs.TierPriorities,
+		Files:          a.metadata,
+		Aging:          a.cfg.Jobs.Aging,
+
+		Worker:    a.cfg.Jobs.Worker,
+		Heartbeat: a.cfg.Jobs.Heartbeat,
+
+		Drain: a.inFlight,
+	}, a.logger)
+	if pool.Enabled() {
+		a.jobs = pool
+	}
+}
+
+// buildServices builds the media services, which every way of getting
+// files in or out of the service goes through, and registers their job
+// handlers.
+func (a *App) buildServices() {
+	cfg, logger := a.cfg, a.logger
+
+	var watermark *imaging.Watermark
+	if wm := cfg.Imaging.Watermark; wm.Path != "" {
+		position, ok := imaging.ParsePosition(wm.Position)
+		if !ok {
+			logger.Warn("Unknown watermark position, using bottom-right", "position", wm.Position)
+			position = imaging.PositionBottomRight
+		}
+		var err error
+		if watermark, err = imaging.LoadWatermark(wm.Path, position, wm.Opacity); err != nil {
+			logger.Error("Failed to load watermark, serving images without it", "path", wm.Path, "error", err)
+		}
+	}
+	a.audit = audit.NewTrail(a.metadata, logger)
+	a.brandings = media.NewBrandingService(a.storage, a.metadata, a.metadata, logger)
+	a.feeds = media.NewFeedService(a.metadata, a.metadata, a.metadata, a.metadata, cfg.PublicBaseURL, cfg.Feeds.CacheTTL, logger)
+	a.quotas = media.NewQuotaService(a.metadata, a.metadata, a.metadata, media.QuotaLimits{
+		MaxBytes: cfg.Quotas.MaxBytes,
+		MaxFiles: cfg.Quotas.MaxFiles,
+	}, a.plans, cfg.Quotas.WarnAt, cfg.Quotas.Grace, a.events, webhook.NewNotifier(cfg.Webhook.URL, cfg.Webhook.Secret, logger), logger)
+
+	locator, err := geoip.Open(cfg.Geo.DatabasePath)
+	if err != nil {
+		logger.Error("Failed to load GeoIP database, locating clients by header only", "path", cfg.Geo.DatabasePath, "error", err)
+	}
+	a.geo = media.NewGeoService(a.metadata, locator, logger)
+
+	var normalizer *audio.Normalizer
+	if cfg.Audio.Normalize {
+		normalizer = audio.NewNormalizer(cfg.Video.FFmpegPath, audio.LoudnessTarget{
+			Integrated: cfg.Audio.TargetLUFS,
+			TruePeak:   cfg.Audio.TargetTruePeak,
+			Range:      cfg.Audio.TargetRange,
+		})
+	}
+	a.files = media.NewFileService(a.storage, a.metadata, media.FileConfig{
+		Watermark:     watermark,
+		WatermarkDirs: cfg.Imaging.Watermark.Directories,
+		Brandings:     a.brandings,
+		Converter: imaging.NewConverter(imaging.ConverterConfig{
+			CWebPPath:   cfg.Imaging.CWebPPath,
+			AVIFEncPath: cfg.Imaging.AVIFEncPath,
+			WebPQuality: cfg.Imaging.WebPQuality,
+			AVIFQuality: cfg.Imaging.AVIFQuality,
+		}),
+		Previews:               imaging.NewPDFRenderer(cfg.Imaging.PDFToPPMPath, cfg.Imaging.PreviewWidth),
+		Waveforms:              audio.NewPeakExtractor(cfg.Video.FFmpegPath, cfg.Audio.WaveformPoints),
+		Normalizer:             normalizer,
+		Recognizer:             imaging.NewTextRecognizer(cfg.Imaging.TesseractPath, cfg.Imaging.OCRLanguages),
+		Interlacer:             imaging.NewInterlacer(cfg.Imaging.JPEGTranPath, cfg.Imaging.OptiPNGPath),
+		ProgressiveCollections: cfg.Imaging.ProgressiveCollections,
+		Posters:                video.NewTranscoder(cfg.Video.FFmpegPath, 1),
+		PosterOffset:           cfg.Video.PosterOffset,
+		TeaserFormat:           cfg.Video.TeaserFormat,
+		Teaser:                 teaserOptions(cfg.Video),
+		Governor:               a.governor,
+		Degrade: media.DegradeConfig{
+			Jobs:          a.jobs,
+			MaxJobs:       cfg.Processing.DegradedJobDepth,
+			Transcodes:    a.transcodes,
+			MaxTranscodes: cfg.Processing.DegradedTranscodeDepth,
+			MaxDimension:  cfg.Processing.DegradedMaxDimension,
+		},
+		StreamSigner: signedurl.NewSigner(cfg.Video.StreamSigningKey),
+		StreamURLTTL: cfg.Video.StreamURLTTL,
+
+		DownloadSigner: signedurl.NewSigner(cfg.Visibility.SigningKey),
+		DownloadURLTTL: cfg.Visibility.URLTTL,
+		Plans:          a.plans,
+		Stats:          a.stats,
+		Events:         a.events,
+		Geo:            a.geo,
+
+		LicenseExpiry: media.LicenseAction(cfg.Licenses.ExpiryAction),
+		Audit:         a.audit,
+		Retention:     cfg.Retention.Rules,
+		Region:        cfg.Region.Name,
+		Annotations:   a.metadata,
+		Aliases:       a.metadata,
+		ShortLinks:    a.metadata,
+	}, logger)
+
+	backend, err := imaging.NewBackend(cfg.Imaging.Backend)
+	if err != nil {
+		logger.Error("Failed to initialize imaging backend, using go", "error", err)
+		backend, _ = imaging.NewBackend("go")
+	}
+	var qcChecker *qc.Checker
+	if len(cfg.QC.Collections) > 0 {
+		qcChecker = qc.NewChecker(cfg.Video.FFmpegPath, qc.Thresholds{
+			MaxSilence:   cfg.QC.MaxSilence,
+			SilenceNoise: cfg.QC.SilenceNoise,
+			MaxBlack:     cfg.QC.MaxBlack,
+		})
+	}
+	a.uploads = media.NewUploadService(a.files, a.metadata, media.UploadConfig{
+		MaxSize:             cfg.MaxFileSize,
+		StripMetadata:       cfg.Imaging.StripMetadata,
+		PreserveOrientation: cfg.Imaging.PreserveOrientation,
+		ReviewUploads:       cfg.ReviewUploads,
+		VerifyDecode:        cfg.Imaging.VerifyDecode,
+		ExtractColors:       cfg.Imaging.ExtractColors,
+		AltTextCollections:  cfg.Imaging.AltTextCollections,
+		SourcePresets:       cfg.Imaging.UploadSources,
+		CompressQuality:     cfg.Imaging.CompressQuality,
+		AvatarSizes:         cfg.Imaging.AvatarSizes,
+		AvatarHistory:       cfg.Imaging.AvatarHistory,
+		Backend:             backend,
+		MaxVideoSize:        cfg.Video.MaxSize,
+		VideoTypes:          cfg.Video.Types,
+		MaxAudioSize:        cfg.Audio.MaxSize,
+		AudioTypes:          cfg.Audio.Types,
+		Jobs:                a.jobs,
+		Transcodes:          a.transcodes,
+		Events:              a.events,
+		Quotas:              a.quotas,
+		Orgs:                a.metadata,
+		DefaultVisibility:   domain.Visibility(cfg.Visibility.Default),
+		Scanner:             scan.NewClamd(cfg.Scan.ClamdAddress, cfg.Scan.Timeout),
+		ScanSyncMaxSize:     cfg.Scan.SyncMaxSize,
+		ValidationCacheTTL:  cfg.Scan.CacheTTL,
+		QC:                  qcChecker,
+		QCCollections:       cfg.QC.Collections,
+		QCPolicy:            cfg.QC.Policy,
+		DirectoryTTLs:       cfg.Expiry.DirectoryTTLs,
+		MaxTTL:              cfg.Expiry.MaxTTL,
+		MaxVersions:         cfg.MaxVersions,
+		Metering:            a.metering,
+		Limits: imaging.Limits{
+			MaxWidth:  cfg.Imaging.MaxWidth,
+			MaxHeight: cfg.Imaging.MaxHeight,
+			MaxPixels: int64(cfg.Imaging.MaxMegapixels) * 1_000_000,
+
+			MaxFrames:      cfg.Imaging.MaxGIFFrames,
+			MaxTotalPixels: int64(cfg.Imaging.MaxGIFMegapixels) * 1_000_000,
+		},
+	}, logger)
+	a.orgs = media.NewOrgService(a.metadata, a.metadata, a.files, a.quotas, a.feeds, a.geo, a.brandings, cfg.Orgs.OffboardingGrace, logger)
+	a.settings = media.NewSettingsService(a.metadata, a.metadata, a.brandings, a.uploads, cfg.Orgs.Settings, a.audit, logger)
+	if a.events != nil && slices.Contains(cfg.Orgs.Settings, media.SettingWebhooks) {
+		a.events.Hook(a.settings.Deliver)
+	}
+	if a.jobs != nil {
+		a.jobs.Register(domain.JobWaveform, a.uploads.WaveformJob)
+		a.jobs.Register(domain.JobLoudness, a.uploads.LoudnessJob)
+		a.jobs.Register(domain.JobOCR, a.uploads.OCRJob)
+		a.jobs.Register(domain.JobScan, a.uploads.ScanJob)
+		a.jobs.Register(domain.JobQC, a.uploads.QCJob)
+	}
+}
+
+// teaserOptions shapes video teasers as configured.
+func teaserOptions(cfg config.VideoConfig) video.TeaserOptions {
+	return video.TeaserOptions{
+		Length:    cfg.TeaserLength,
+		Width:     cfg.TeaserWidth,
+		FPS:       cfg.TeaserFPS,
+		Highlight: cfg.TeaserStart == "highlight",
+	}
+}
+
+// buildCron schedules the periodic tasks. Tasks with nothing to do in this
+// deployment are left out.
+func (a *App) buildCron() {
+	a.cron = cron.NewScheduler(a.logger)
+	tasks := map[string]func(context.Context) error{
+		"audit":        a.auditor.AuditAll,
+		"scrub":        a.scrubber.Pass,
+		"availability": a.files.AnnounceAvailability,
+		"expiry":       a.files.Expire,
+		"offboarding":  a.orgs.PurgeOffboarded,
+	}
+	if a.gc.Supported() {
+		tasks["gc"] = func(ctx context.Context) error {
+			_, err := a.gc.Collect(ctx, false)
+			return err
+		}
+	}
+	if a.files.Retains() {
+		tasks["retention"] = a.files.PurgeRetained
+	}
+	for name, run := range tasks {
+		if schedule, ok := a.cfg.Cron.Schedules[name]; ok {
+			a.cron.Add(name, schedule, run)
+		}
+	}
+}
+
+func (a *App) buildServer() {
+	a.jwks = auth.NewJWKSClient(a.cfg.Auth.JWKSUrl, a.cfg.Auth.JWKSCacheTTL)
+	if a.cfg.Auth.Introspection.URL != "" {
+		introspection := a.cfg.Auth.Introspection
+		a.tokens = auth.NewIntrospector(auth.IntrospectionConfig{
+			URL:          introspection.URL,
+			ClientID:     introspection.ClientID,
+			ClientSecret: introspection.ClientSecret,
+			CacheTTL:     introspection.CacheTTL,
+			FailOpen:     introspection.FailOpen,
+		})
+	}
+	router := httphandler.NewRouter(httphandler.Deps{
+		Storage:   a.storage,
+		Metadata:  a.metadata,
+		Auditor:   a.auditor,
+		Scrubber:  a.scrubber,
+		Collector: a.gc,
+		Cron:      a.cron,
+		Jobs:      a.jobs,
+		Events:    a.events,
+		Stats:     a.stats,
+		Uploads:   a.uploads,
+		Files:     a.files,
+		Brandings: a.brandings,
+		Feeds:     a.feeds,
+		Quotas:    a.quotas,
+		Geo:       a.geo,
+		Orgs:      a.orgs,
+		Settings:  a.settings,
+		Presets:   a.presets,
+		Audit:     a.audit,
+		Replicas:  a.replicas,
+		Config:    a.cfg,
+		Logger:    a.logger,
+
+		JWKS:         a.jwks,
+		Introspector: a.tokens,
+		InFlight:     a.inFlight,
+	})
+	a.server = &http.Server{
+		Addr:    a.cfg.HTTPAddr,
+		Handler: router,
+	}
+
+	if a.cfg.GRPCAddr != "" {
+		a.grpc = rpc.NewServer(a.uploads, a.files, a.jwks, auth.Config{
+			JWKSUrl:      a.cfg.Auth.JWKSUrl,
+			Issuer:       a.cfg.Auth.Issuer,
+			Audience:     a.cfg.Auth.Audience,
+			JWKSCacheTTL: a.cfg.Auth.JWKSCacheTTL,
+			Algorithms:   a.cfg.Auth.Algorithms,
+
+			Leeway:            a.cfg.Auth.Leeway,
+			RequireExpiration: a.cfg.Auth.RequireExpiration,
+			RequireNotBefore:  a.cfg.Auth.RequireNotBefore,
+			ScopePermissions:  a.cfg.Auth.ScopePermissions,
+			AdminRole:         a.cfg.Auth.AdminRole,
+			Introspector:      a.tokens,
+		}, a.inFlight, a.logger)
+	}
+}
+
+// Run starts the background workers and serves HTTP, or HTTPS, and gRPC
+// when enabled, until ctx is cancelled, then shuts the servers down gracefully.
+// The workers stop with ctx. Once the requests drained, or the shutdown
+// timed out, the requests and jobs that finished and those cut off are
+// reported.
+func (a *App) Run(ctx context.Context) error {
+	go a.cron.Run(ctx)
+	go a.transcodes.Run(ctx)
+	go a.jwks.Run(ctx, a.logger)
+	if a.replicas != nil {
+		go a.replicas.Run(ctx)
+	}
+	jobsDone := make(chan struct{})
+	if a.jobs != nil {
+		go func() {
+			defer close(jobsDone)
+			a.jobs.Run(ctx)
+		}()
+	} else {
+		close(jobsDone)
+	}
+
+	serveErr := make(chan error, 3)
+	go func() {
+		a.logger.Info("Starting media service", "addr", a.cfg.HTTPAddr, "tls", a.cfg.TLS.Enabled())
+		var err error
+		if a.cfg.TLS.Enabled() {
+			// The certificate comes from the TLS config.
+			err = a.server.ListenAndServeTLS("", "")
+		} else {
+			err = a.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+	if a.redirect != nil {
+		go func() {
+			a.logger.Info("Redirecting HTTP to HTTPS", "addr", a.redirect.Addr)
+			if err := a.redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+	if a.grpc != nil {
+		listener, err := net.Listen("tcp", a.cfg.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("gRPC server failed to start: %w", err)
+		}
+		// The gRPC API is served with the certificate of the HTTPS server,
+		// so clients must dial one of its host names.
+		if a.server.TLSConfig != nil {
+			tlsConfig := a.server.TLSConfig.Clone()
+			tlsConfig.NextProtos = []string{"h2"}
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		go func() {
+			a.logger.Info("Starting gRPC API", "addr", a.cfg.GRPCAddr)
+			if err := a.grpc.Serve(listener); err != nil {
+				serveErr <- err
+			}
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server failed to start: %w", err)
+	case <-ctx.Done():
+	}
+
+	a.logger.Info("Shutting down server")
+	a.inFlight.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if a.grpc != nil {
+		a.stopGRPC(shutdownCtx)
+	}
+	if a.redirect != nil {
+		a.redirect.Shutdown(shutdownCtx)
+	}
+	shutdownErr := a.server.Shutdown(shutdownCtx)
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+	}
+	a.reportDrain()
+	if shutdownErr != nil {
+		return fmt.Errorf("server forced to shutdown: %w", shutdownErr)
+	}
+	if err := a.events.Close(); err != nil {
+		a.logger.Warn("Failed to flush events", "error", err)
+	}
+	if err := a.stopTracing(shutdownCtx); err != nil {
+		a.logger.Warn("Failed to flush traces", "error", err)
+	}
+	return nil
+}
+
+// reportDrain logs which requests and jobs finished during the shutdown
+// and which were cut off, and puts the final metrics to the Pushgateway
+// when one is configured.
+func (a *App) reportDrain() {
+	report := a.inFlight.Report()
+	level := slog.LevelInfo
+	if report.Requests.Aborted > 0 || report.Jobs.Aborted > 0 {
+		level = slog.LevelWarn
+	}
+	a.logger.Log(context.Background(), level, "Shutdown drain report",
+		"duration", report.Duration, "requests", report.Requests, "jobs", report.Jobs)
+
+	if a.cfg.Metrics.PushURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	if err := metrics.Push(ctx, a.cfg.Metrics.PushURL); err != nil {
+		a.logger.Warn("Failed to push final metrics", "error", err)
+	}
+}
+
+// stopGRPC waits for calls in flight to finish until ctx is done, then
+// cancels the remaining ones.
+func (a *App) stopGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		a.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		a.grpc.Stop()
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// setupTLS has the server serve HTTPS when configured, and builds the
+// server redirecting plain HTTP to it.
+func (a *App) setupTLS() error {
+	cfg := a.cfg.TLS
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	// Requests that are not ACME challenges are redirected.
+	challenges := func(fallback http.Handler) http.Handler { return fallback }
+	if cfg.Autocert {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertHosts...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		if cfg.AutocertDirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: cfg.AutocertDirectoryURL}
+		}
+		a.server.TLSConfig = manager.TLSConfig()
+		challenges = manager.HTTPHandler
+	} else {
+		certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile, a.logger)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		a.server.TLSConfig = &tls.Config{GetCertificate: certs.get}
+	}
+	a.server.TLSConfig.MinVersion = tls.VersionTLS12
+
+	if cfg.RedirectAddr != "" {
+		a.redirect = &http.Server{
+			Addr:              cfg.RedirectAddr,
+			Handler:           challenges(redirectToHTTPS(a.cfg.HTTPAddr)),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return nil
+}
+
+// redirectToHTTPS redirects requests to the same URL on the HTTPS server
+// listening on httpsAddr.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, e.g. renewals by certbot.
+const certCheckInterval = time.Minute
+
+// certReloader serves the certificate in a pair of files, loading it anew
+// once the files change.
+type certReloader struct {
+	certFile, keyFile string
+	logger            *slog.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime, r.checkedAt = &cert, info.ModTime(), time.Now()
+	return nil
+}
+
+func (r *certReloader) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+	if info, err := os.Stat(r.certFile); err != nil || info.ModTime().Equal(r.modTime) {
+		return r.cert, nil
+	}
+	// The previous certificate is kept if the new one does not load, e.g.
+	// while only one of the files has been written.
+	if err := r.load(); err != nil {
+		r.logger.Warn("Failed to reload TLS certificate", "certFile", r.certFile, "error", err)
+		return r.cert, nil
+	}
+	r.logger.Info("Reloaded TLS certificate", "certFile", r.certFile)
+	return r.cert, nil
+}
@@ -0,0 +1,13 @@
+package audio
+
+const (
+	MPEG = "audio/mpeg"
+	Ogg  = "audio/ogg"
+	WAV  = "audio/wav"
+)
+
+// Supported reports whether contentType is an audio format the service
+// accepts.
+func Supported(contentType string) bool {
+	return contentType == MPEG || contentType == Ogg || contentType == WAV
+}
@@ -0,0 +1,203 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// LoudnessTarget is what Normalize aims for, after EBU R128.
+type LoudnessTarget struct {
+	Integrated float64 // LUFS
+	TruePeak   float64 // dBTP
+	Range      float64 // LU
+}
+
+// Loudness is the EBU R128 measurement of a recording.
+type Loudness struct {
+	Integrated float64 // LUFS
+	TruePeak   float64 // dBTP
+	Range      float64 // LU
+}
+
+// Normalizer brings audio to a target loudness with ffmpeg's loudnorm
+// filter. When the tool is not installed normalization is reported as
+// unsupported.
+type Normalizer struct {
+	path   string
+	target LoudnessTarget
+}
+
+// NewNormalizer looks up ffmpeg at path.
+func NewNormalizer(path string, target LoudnessTarget) *Normalizer {
+	n := &Normalizer{target: target}
+	if p, err := exec.LookPath(path); err == nil {
+		n.path = p
+	}
+	return n
+}
+
+func (n *Normalizer) Supported() bool {
+	return n != nil && n.path != ""
+}
+
+func (n *Normalizer) Target() LoudnessTarget {
+	return n.target
+}
+
+// Cost estimates the memory a pass holds: ffmpeg's decoder and encoder
+// plus loudnorm's three second lookahead, upsampled to 192kHz.
+func (n *Normalizer) Cost() int64 {
+	return 96 << 20
+}
+
+// outputs are the encoders of normalized audio, by content type. Audio is
+// kept in the format it was uploaded in.
+var outputs = map[string][]string{
+	MPEG: {"-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3"},
+	Ogg:  {"-c:a", "libvorbis", "-q:a", "6", "-f", "ogg"},
+	WAV:  {"-c:a", "pcm_s16le", "-f", "wav"},
+}
+
+// Normalize measures the loudness of the contentType audio read from src
+// and writes a copy brought to the target to dst. It returns the loudness
+// measured before normalizing. The measurement is taken in a first pass so
+// the second can apply a single linear gain where the target allows it,
+// which keeps the dynamics of speech intact.
+func (n *Normalizer) Normalize(ctx context.Context, src io.Reader, contentType string, dst io.Writer) (Loudness, error) {
+	if !n.Supported() {
+		return Loudness{}, fmt.Errorf("audio normalization not supported")
+	}
+	output, ok := outputs[contentType]
+	if !ok {
+		return Loudness{}, fmt.Errorf("unsupported audio type %q", contentType)
+	}
+
+	workDir, err := os.MkdirTemp("", "media-loudness-*")
+	if err != nil {
+		return Loudness{}, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return Loudness{}, err
+	}
+
+	m, err := n.measure(ctx, in)
+	if err != nil {
+		return Loudness{}, err
+	}
+
+	out := filepath.Join(workDir, "out")
+	filter := n.filter() + fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset)
+	args := []string{"-nostdin", "-y", "-loglevel", "error",
+		"-i", in,
+		"-map", "0:a:0", "-vn",
+		"-af", filter,
+		// loudnorm works at 192kHz; resample to a rate every format takes.
+		"-ar", "48000",
+	}
+	cmd := exec.CommandContext(ctx, n.path, append(append(args, output...), out)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return Loudness{}, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		return Loudness{}, fmt.Errorf("failed to open normalized audio: %w", err)
+	}
+	defer f.Close()
+	if _, err := bufpool.Copy(dst, f); err != nil {
+		return Loudness{}, fmt.Errorf("failed to write normalized audio: %w", err)
+	}
+	return m.loudness()
+}
+
+func (n *Normalizer) filter() string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", n.target.Integrated, n.target.TruePeak, n.target.Range)
+}
+
+// measurement is the JSON loudnorm prints after a pass. Values are strings,
+// as they are passed back to the second pass verbatim.
+type measurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+func (m measurement) loudness() (Loudness, error) {
+	var l Loudness
+	var err error
+	// Silence measures as -inf and cannot be normalized.
+	if l.Integrated, err = strconv.ParseFloat(m.InputI, 64); err != nil || math.IsInf(l.Integrated, 0) {
+		return Loudness{}, fmt.Errorf("invalid integrated loudness %q", m.InputI)
+	}
+	if l.TruePeak, err = strconv.ParseFloat(m.InputTP, 64); err != nil {
+		return Loudness{}, fmt.Errorf("invalid true peak %q", m.InputTP)
+	}
+	if l.Range, err = strconv.ParseFloat(m.InputLRA, 64); err != nil {
+		return Loudness{}, fmt.Errorf("invalid loudness range %q", m.InputLRA)
+	}
+	return l, nil
+}
+
+// measure runs the first loudnorm pass over the audio at path.
+func (n *Normalizer) measure(ctx context.Context, path string) (measurement, error) {
+	cmd := exec.CommandContext(ctx, n.path, "-nostdin", "-hide_banner", "-nostats",
+		"-i", path,
+		"-map", "0:a:0", "-vn",
+		"-af", n.filter()+":print_format=json",
+		"-f", "null", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return measurement{}, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	// The JSON is the last thing loudnorm logs.
+	log := stderr.Bytes()
+	start := bytes.LastIndexByte(log, '{')
+	end := bytes.LastIndexByte(log, '}')
+	if start < 0 || end < start {
+		return measurement{}, fmt.Errorf("no loudness measured")
+	}
+	var m measurement
+	if err := json.Unmarshal(log[start:end+1], &m); err != nil {
+		return measurement{}, fmt.Errorf("failed to read loudness measurement: %w", err)
+	}
+	if _, err := m.loudness(); err != nil {
+		return measurement{}, fmt.Errorf("failed to measure loudness: %w", err)
+	}
+	return m, nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = bufpool.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+const (
+	// Audio is decoded to mono at this rate; waveforms need no more.
+	sampleRate = 8000
+
+	// Peaks are collected per block of samples (10ms) while decoding and
+	// merged into the requested number of points at the end, when the
+	// length is known.
+	blockSamples = sampleRate / 100
+)
+
+// Waveform holds min/max peak pairs in the JSON format of BBC's
+// audiowaveform tool, which waveform players such as peaks.js read.
+type Waveform struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+
+	// Duration is the decoded length in seconds.
+	Duration float64 `json:"-"`
+}
+
+// PeakExtractor computes waveforms by decoding audio with ffmpeg. When the
+// tool is not installed waveforms are reported as unsupported.
+type PeakExtractor struct {
+	path   string
+	points int
+}
+
+// NewPeakExtractor looks up ffmpeg at path. Waveforms have up to points
+// peak pairs.
+func NewPeakExtractor(path string, points int) *PeakExtractor {
+	e := &PeakExtractor{points: max(points, 1)}
+	if p, err := exec.LookPath(path); err == nil {
+		e.path = p
+	}
+	return e
+}
+
+func (e *PeakExtractor) Supported() bool {
+	return e != nil && e.path != ""
+}
+
+// Cost estimates the memory a decode holds: ffmpeg's decoder and buffers
+// plus a peak pair per block for up to a few hours of audio.
+func (e *PeakExtractor) Cost() int64 {
+	return 64 << 20
+}
+
+// Peaks decodes the audio read from src and returns its waveform.
+func (e *PeakExtractor) Peaks(ctx context.Context, src io.Reader) (Waveform, error) {
+	if !e.Supported() {
+		return Waveform{}, fmt.Errorf("audio decoding not supported")
+	}
+
+	cmd := exec.CommandContext(ctx, e.path, "-nostdin", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(sampleRate),
+		"-f", "s16le", "-c:a", "pcm_s16le",
+		"pipe:1")
+	cmd.Stdin = src
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Waveform{}, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return Waveform{}, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	blocks, samples, readErr := readBlocks(bufio.NewReader(stdout))
+	err = cmd.Wait()
+	metering.Record(ctx, cmd)
+	if err != nil {
+		return Waveform{}, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if readErr != nil {
+		return Waveform{}, fmt.Errorf("failed to read decoded audio: %w", readErr)
+	}
+	if samples == 0 {
+		return Waveform{}, fmt.Errorf("no audio decoded")
+	}
+	return e.waveform(blocks, samples), nil
+}
+
+// readBlocks reads 16-bit PCM and returns the min/max pair of every block
+// and the number of samples.
+func readBlocks(r io.Reader) ([][2]int16, int, error) {
+	var (
+		blocks  [][2]int16
+		samples int
+		buf     [2]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return blocks, samples, nil
+			}
+			return nil, 0, err
+		}
+		v := int16(binary.LittleEndian.Uint16(buf[:]))
+		if samples%blockSamples == 0 {
+			blocks = append(blocks, [2]int16{v, v})
+		}
+		b := &blocks[len(blocks)-1]
+		b[0], b[1] = min(b[0], v), max(b[1], v)
+		samples++
+	}
+}
+
+// waveform merges whole blocks into at most e.points peak pairs, so every
+// pair covers the same number of samples.
+func (e *PeakExtractor) waveform(blocks [][2]int16, samples int) Waveform {
+	perPixel := (len(blocks) + e.points - 1) / e.points
+	length := (len(blocks) + perPixel - 1) / perPixel
+
+	data := make([]int8, 0, 2*length)
+	for i := 0; i < len(blocks); i += perPixel {
+		peak := blocks[i]
+		for _, b := range blocks[i+1 : min(i+perPixel, len(blocks))] {
+			peak[0], peak[1] = min(peak[0], b[0]), max(peak[1], b[1])
+		}
+		data = append(data, int8(peak[0]>>8), int8(peak[1]>>8))
+	}
+
+	return Waveform{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      sampleRate,
+		SamplesPerPixel: perPixel * blockSamples,
+		Bits:            8,
+		Length:          length,
+		Data:            data,
+		Duration:        float64(samples) / sampleRate,
+	}
+}
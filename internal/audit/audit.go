@@ -0,0 +1,92 @@
+// Package audit keeps the trail of changes made to files for compliance
+// reviews: who uploaded, changed, shared, moderated or deleted which file,
+// when and from where. The caller and their address are taken from the
+// context of the request making the change.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/geoip"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"google.golang.org/grpc/peer"
+)
+
+var failures = metrics.NewCounter("media_audit_failures_total",
+	"Changes that could not be recorded in the audit trail.", "action")
+
+// Trail records changes in an append-only metadata.AuditStore. A nil Trail
+// records nothing.
+type Trail struct {
+	store  metadata.AuditStore
+	logger *slog.Logger
+}
+
+func NewTrail(store metadata.AuditStore, logger *slog.Logger) *Trail {
+	return &Trail{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Record adds an entry for action on a file. Empty details are left out.
+// The change has already been made by then, so failures are logged and
+// counted rather than returned.
+func (t *Trail) Record(ctx context.Context, action, fileID string, details map[string]string) {
+	if t == nil {
+		return
+	}
+	maps.DeleteFunc(details, func(_, v string) bool { return v == "" })
+	entry := domain.AuditEntry{
+		ID:       uuid.New().String(),
+		Action:   action,
+		FileID:   fileID,
+		ClientIP: ClientIP(ctx),
+		Details:  details,
+		At:       time.Now().UTC(),
+	}
+	if caller, ok := auth.FromContext(ctx); ok {
+		entry.ActorID = caller.UserID
+	}
+	if err := t.store.AppendAudit(context.WithoutCancel(ctx), entry); err != nil {
+		failures.Inc(action)
+		t.logger.Error("Failed to record audit entry", "action", action, "fileId", fileID,
+			"actorId", entry.ActorID, "clientIp", entry.ClientIP, "error", err)
+	}
+}
+
+// List returns up to limit matching entries, newest first.
+func (t *Trail) List(ctx context.Context, filter metadata.AuditFilter, limit int) ([]domain.AuditEntry, error) {
+	entries, err := t.store.ListAudit(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// ClientIP is the address of the HTTP client or gRPC peer of ctx.
+func ClientIP(ctx context.Context) string {
+	if client, ok := geoip.ClientFrom(ctx); ok && client.Addr.IsValid() {
+		return client.Addr.String()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if addr, err := netip.ParseAddrPort(p.Addr.String()); err == nil {
+			return addr.Addr().Unmap().String()
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
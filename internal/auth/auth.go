@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,13 +16,22 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/ondrasimku/media-service-go/internal/jwa"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/ondrasimku/media-service-go/internal/auth")
+
 type AuthContext struct {
 	UserID      string
 	OrgID       *string
 	Roles       []string
-	Permissions []string
+	Permissions []string // Of the permissions claim, and granted by Scopes or the admin role
+	Scopes      []string // OAuth scopes of the scope or scp claim
 	Email       *string
 	Name        *string
 }
@@ -29,24 +41,49 @@ type Config struct {
 	Issuer       string
 	Audience     string
 	JWKSCacheTTL int
+	Algorithms   []string // Signing algorithms tokens are accepted with; jwa.Default if empty
+
+	// Leeway is the clock skew tolerated between the issuer and this
+	// service when checking the exp, nbf and iat claims.
+	Leeway            time.Duration
+	RequireExpiration bool // Refuse tokens without an exp claim
+	RequireNotBefore  bool // Refuse tokens without an nbf claim
+
+	// ScopePermissions maps OAuth scopes to the permissions they grant, so
+	// access tokens with a scope claim instead of permissions work.
+	// Unmapped scopes grant none.
+	ScopePermissions map[string][]string
+
+	// AdminRole is a role granting files:admin, so its holders may see,
+	// list and delete the files of any user or organization.
+	AdminRole string
+
+	// Introspector checks that verified tokens were not revoked. Optional.
+	Introspector *Introspector
 }
 
 type cachedJWKS struct {
 	set       jwk.Set
+	fetchedAt time.Time
 	expiresAt time.Time
 }
 
+// refetchInterval is how often at most the key set is fetched again for
+// tokens signed with a key missing from it.
+const refetchInterval = 30 * time.Second
+
 type JWKSClient struct {
-	url        string
-	cache      *cachedJWKS
-	cacheTTL   time.Duration
-	mu         sync.RWMutex
-	httpClient *http.Client
+	url         string
+	cache       *cachedJWKS
+	cacheTTL    time.Duration
+	refetchedAt time.Time // Last fetch for a missing key
+	mu          sync.RWMutex
+	httpClient  *http.Client
 }
 
 func NewJWKSClient(url string, cacheTTLSeconds int) *JWKSClient {
 	ttl := time.Duration(cacheTTLSeconds) * time.Second
-	if ttl == 0 {
+	if ttl <= 0 {
 		ttl = 15 * time.Minute
 	}
 
@@ -73,40 +110,141 @@ func (c *JWKSClient) GetKeySet(ctx context.Context) (jwk.Set, error) {
 		return c.cache.set, nil
 	}
 
+	return c.refresh(ctx)
+}
+
+// refresh fetches the key set and caches it, falling back to the cached
+// one if that fails. c.mu must be held.
+func (c *JWKSClient) refresh(ctx context.Context) (jwk.Set, error) {
+	set, err := c.fetch(ctx)
+	if err != nil {
+		if c.cache != nil {
+			return c.cache.set, nil
+		}
+		return nil, err
+	}
+	c.store(set)
+	return set, nil
+}
+
+func (c *JWKSClient) store(set jwk.Set) {
+	now := time.Now()
+	c.cache = &cachedJWKS{
+		set:       set,
+		fetchedAt: now,
+		expiresAt: now.Add(c.cacheTTL),
+	}
+}
+
+// LookupKey returns the key of kid. A key missing from the cached key set
+// may have just been rotated in by the identity provider, so the set is
+// fetched again, at most once per refetchInterval, before giving up.
+func (c *JWKSClient) LookupKey(ctx context.Context, kid string) (jwk.Key, error) {
+	set, err := c.GetKeySet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWKS: %w", err)
+	}
+	if key, found := set.LookupKeyID(kid); found {
+		return key, nil
+	}
+
+	c.mu.Lock()
+	if time.Since(c.refetchedAt) >= refetchInterval {
+		c.refetchedAt = time.Now()
+		set, err = c.refresh(ctx)
+	} else if c.cache != nil {
+		set = c.cache.set
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWKS: %w", err)
+	}
+	if key, found := set.LookupKeyID(kid); found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key not found for kid: %s", kid)
+}
+
+// Run refreshes the key set every half cache TTL until ctx is done, so
+// that keys the identity provider rotates in are known before tokens
+// signed with them arrive, and requests do not wait for the key set to be
+// fetched. The key set in use is kept while refreshing fails.
+func (c *JWKSClient) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(c.cacheTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		set, err := c.fetch(ctx)
+		if err != nil {
+			logger.Warn("Failed to refresh JWKS", "url", c.url, "error", err)
+			continue
+		}
+		c.mu.Lock()
+		c.store(set)
+		c.mu.Unlock()
+	}
+}
+
+// Check refreshes the key set if due and reports whether it is fresh
+// enough to verify tokens. A key set that could not be refreshed keeps
+// being used for another cache TTL before it is considered stale.
+func (c *JWKSClient) Check(ctx context.Context) error {
+	if _, err := c.GetKeySet(ctx); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cache == nil {
+		return errors.New("no key set fetched")
+	}
+	if time.Since(c.cache.expiresAt) > c.cacheTTL {
+		return fmt.Errorf("key set fetched %s ago could not be refreshed", time.Since(c.cache.fetchedAt).Round(time.Second))
+	}
+	return nil
+}
+
+// fetch downloads the key set, passing the trace context on to the
+// identity service.
+func (c *JWKSClient) fetch(ctx context.Context) (jwk.Set, error) {
+	ctx, span := tracer.Start(ctx, "GET JWKS", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(http.MethodGet), semconv.URLFull(c.url)))
+	defer span.End()
+
+	set, err := c.download(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return set, err
+}
+
+func (c *JWKSClient) download(ctx context.Context) (jwk.Set, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", c.url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		if c.cache != nil {
-			return c.cache.set, nil
-		}
 		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
 	}
 	defer resp.Body.Close()
 
+	trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
 	if resp.StatusCode != http.StatusOK {
-		if c.cache != nil {
-			return c.cache.set, nil
-		}
 		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
 	}
 
 	set, err := jwk.ParseReader(resp.Body)
 	if err != nil {
-		if c.cache != nil {
-			return c.cache.set, nil
-		}
 		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
 	}
-
-	c.cache = &cachedJWKS{
-		set:       set,
-		expiresAt: time.Now().Add(c.cacheTTL),
-	}
-
 	return set, nil
 }
 
@@ -131,14 +269,24 @@ func VerifyToken(ctx context.Context, tokenString string, jwksClient *JWKSClient
 		return nil, fmt.Errorf("token missing kid in header")
 	}
 
-	keySet, err := jwksClient.GetKeySet(ctx)
+	algorithms := config.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = jwa.Default
+	}
+	alg, _ := header["alg"].(string)
+	if !slices.Contains(algorithms, alg) {
+		return nil, fmt.Errorf("unexpected signing method: %v", header["alg"])
+	}
+
+	key, err := jwksClient.LookupKey(ctx, kid)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get JWKS: %w", err)
+		return nil, err
 	}
 
-	key, found := keySet.LookupKeyID(kid)
-	if !found {
-		return nil, fmt.Errorf("key not found for kid: %s", kid)
+	// A key published for one algorithm must not verify tokens signed
+	// with another.
+	if keyAlg := key.Algorithm().String(); keyAlg != "" && keyAlg != alg {
+		return nil, fmt.Errorf("key %s is for %s, not %s", kid, keyAlg, alg)
 	}
 
 	var publicKey interface{}
@@ -146,12 +294,15 @@ func VerifyToken(ctx context.Context, tokenString string, jwksClient *JWKSClient
 		return nil, fmt.Errorf("failed to get public key: %w", err)
 	}
 
+	// The key type is checked against the algorithm by its verification,
+	// so an RSA key cannot verify an ES256 token or the other way round.
+	options := []jwt.ParserOption{jwt.WithValidMethods(algorithms), jwt.WithLeeway(config.Leeway)}
+	if config.RequireExpiration {
+		options = append(options, jwt.WithExpirationRequired())
+	}
 	verifiedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
 		return publicKey, nil
-	})
+	}, options...)
 
 	if err != nil {
 		return nil, fmt.Errorf("token verification failed: %w", err)
@@ -162,6 +313,11 @@ func VerifyToken(ctx context.Context, tokenString string, jwksClient *JWKSClient
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	// The parser checks nbf when present, but has no option to require it.
+	if _, ok := claims["nbf"]; config.RequireNotBefore && !ok {
+		return nil, fmt.Errorf("token missing nbf claim")
+	}
+
 	if iss, ok := claims["iss"].(string); !ok || iss != config.Issuer {
 		return nil, fmt.Errorf("invalid issuer")
 	}
@@ -186,6 +342,14 @@ func VerifyToken(ctx context.Context, tokenString string, jwksClient *JWKSClient
 		return nil, fmt.Errorf("token missing sub claim")
 	}
 
+	var expiresAt time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
+	}
+	if err := config.Introspector.Check(ctx, tokenString, expiresAt); err != nil {
+		return nil, err
+	}
+
 	var orgIDPtr *string
 	if orgID, ok := claims["org_id"].(string); ok && orgID != "" {
 		orgIDPtr = &orgID
@@ -209,6 +373,29 @@ func VerifyToken(ctx context.Context, tokenString string, jwksClient *JWKSClient
 		}
 	}
 
+	// scope is a space-separated string (RFC 9068); some issuers send an
+	// scp array instead.
+	var scopesList []string
+	if scope, ok := claims["scope"].(string); ok {
+		scopesList = strings.Fields(scope)
+	} else if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if sStr, ok := s.(string); ok {
+				scopesList = append(scopesList, sStr)
+			}
+		}
+	}
+	for _, scope := range scopesList {
+		for _, permission := range config.ScopePermissions[scope] {
+			if !slices.Contains(permissionsList, permission) {
+				permissionsList = append(permissionsList, permission)
+			}
+		}
+	}
+	if config.AdminRole != "" && slices.Contains(rolesList, config.AdminRole) && !slices.Contains(permissionsList, "files:admin") {
+		permissionsList = append(permissionsList, "files:admin")
+	}
+
 	var emailPtr *string
 	if email, ok := claims["email"].(string); ok && email != "" {
 		emailPtr = &email
@@ -224,77 +411,70 @@ func VerifyToken(ctx context.Context, tokenString string, jwksClient *JWKSClient
 		OrgID:       orgIDPtr,
 		Roles:       rolesList,
 		Permissions: permissionsList,
+		Scopes:      scopesList,
 		Email:       emailPtr,
 		Name:        namePtr,
 	}, nil
 }
 
-func AuthMiddleware(jwksClient *JWKSClient, config Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid authorization header"})
-			c.Abort()
-			return
-		}
-
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-
-		authContext, err := VerifyToken(c.Request.Context(), token, jwksClient, config)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "details": err.Error()})
-			c.Abort()
-			return
-		}
+var (
+	// ErrMissingToken is returned for requests without a bearer token.
+	ErrMissingToken = errors.New("missing or invalid authorization header")
+	// ErrNotAuthenticated is returned when a request has no verified token.
+	ErrNotAuthenticated = errors.New("not authenticated")
+)
 
-		c.Set("auth", authContext)
-		c.Next()
+// BearerToken returns the token of an Authorization header.
+func BearerToken(header string) (string, error) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return "", ErrMissingToken
 	}
+	return token, nil
 }
 
-func RequirePermissions(requiredPermissions []string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authContext, exists := c.Get("auth")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-			c.Abort()
-			return
-		}
+// SetAuthContext stores the verified token of the caller on the request.
+func SetAuthContext(c *gin.Context, authContext *AuthContext) {
+	c.Set("auth", authContext)
+	c.Request = c.Request.WithContext(NewContext(c.Request.Context(), authContext))
+}
 
-		ctx, ok := authContext.(*AuthContext)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid auth context"})
-			c.Abort()
-			return
-		}
+// HasScope reports whether the token was granted scope.
+func (a *AuthContext) HasScope(scope string) bool {
+	return slices.Contains(a.Scopes, scope)
+}
 
-		hasAll := true
-		for _, required := range requiredPermissions {
-			found := false
-			for _, perm := range ctx.Permissions {
-				if perm == required {
-					found = true
-					break
-				}
-			}
-			if !found {
-				hasAll = false
-				break
-			}
+// HasPermission reports whether the token grants permission.
+func (a *AuthContext) HasPermission(permission string) bool {
+	for _, perm := range a.Permissions {
+		if perm == permission {
+			return true
 		}
+	}
+	return false
+}
 
-		if !hasAll {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":    "Insufficient permissions",
-				"required": requiredPermissions,
-				"has":      ctx.Permissions,
-			})
-			c.Abort()
-			return
+// MissingPermissions returns the permissions of required the token does
+// not grant.
+func (a *AuthContext) MissingPermissions(required []string) []string {
+	var missing []string
+	for _, permission := range required {
+		if !a.HasPermission(permission) {
+			missing = append(missing, permission)
 		}
+	}
+	return missing
+}
 
-		c.Next()
+// MissingScopes returns the scopes of required the token was not granted.
+func (a *AuthContext) MissingScopes(required []string) []string {
+	var missing []string
+	for _, scope := range required {
+		if !a.HasScope(scope) {
+			missing = append(missing, scope)
+		}
 	}
+	return missing
 }
 
 func GetAuthContext(c *gin.Context) (*AuthContext, bool) {
@@ -306,3 +486,17 @@ func GetAuthContext(c *gin.Context) (*AuthContext, bool) {
 	ctx, ok := authContext.(*AuthContext)
 	return ctx, ok
 }
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the verified token of the
+// caller, for services that record who made a change.
+func NewContext(ctx context.Context, authContext *AuthContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, authContext)
+}
+
+// FromContext returns the verified token of the caller carried by ctx.
+func FromContext(ctx context.Context) (*AuthContext, bool) {
+	authContext, ok := ctx.Value(contextKey{}).(*AuthContext)
+	return authContext, ok
+}
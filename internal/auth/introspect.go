@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var introspections = metrics.NewCounter("media_auth_introspections_total",
+	"Tokens checked with the introspection endpoint, by result: active, inactive, or error when it could not be asked.", "result")
+
+var (
+	// ErrTokenInactive is returned for tokens the authorization server
+	// reports as not active, e.g. revoked ones.
+	ErrTokenInactive = errors.New("token is not active")
+	// ErrIntrospectionUnavailable is returned when the introspection
+	// endpoint cannot be asked and failing open is off.
+	ErrIntrospectionUnavailable = errors.New("token introspection unavailable")
+)
+
+const (
+	// maxIntrospectionCache bounds the answers kept before expired ones are
+	// dropped.
+	maxIntrospectionCache = 10000
+
+	introspectionTimeout = 5 * time.Second
+)
+
+type IntrospectionConfig struct {
+	// URL is the RFC 7662 introspection endpoint of the authorization
+	// server, called with the client credentials as basic auth.
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	// CacheTTL is how long an answer is reused, bounded by the expiry of
+	// the token; a token revoked meanwhile is accepted until then. Zero
+	// asks for every request.
+	CacheTTL time.Duration
+
+	// FailOpen accepts tokens that verify when the endpoint cannot be
+	// asked, instead of refusing them.
+	FailOpen bool
+}
+
+type introspection struct {
+	active bool
+	until  time.Time
+}
+
+// Introspector asks the authorization server whether verified tokens are
+// still active, so that revoked tokens are refused before they expire. A
+// nil Introspector takes every token for active.
+type Introspector struct {
+	cfg        IntrospectionConfig
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspection
+}
+
+func NewIntrospector(cfg IntrospectionConfig) *Introspector {
+	return &Introspector{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: introspectionTimeout},
+		cache:      make(map[[sha256.Size]byte]introspection),
+	}
+}
+
+// Check returns ErrTokenInactive unless the token, expiring at expiresAt
+// (zero if it does not), is active.
+func (i *Introspector) Check(ctx context.Context, token string, expiresAt time.Time) error {
+	if i == nil {
+		return nil
+	}
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	i.mu.Lock()
+	cached, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && now.Before(cached.until) {
+		if !cached.active {
+			return ErrTokenInactive
+		}
+		return nil
+	}
+
+	active, err := i.introspect(ctx, token)
+	if err != nil {
+		introspections.Inc("error")
+		if i.cfg.FailOpen {
+			return nil
+		}
+		return fmt.Errorf("%w: %v", ErrIntrospectionUnavailable, err)
+	}
+	if active {
+		introspections.Inc("active")
+	} else {
+		introspections.Inc("inactive")
+	}
+
+	if i.cfg.CacheTTL > 0 {
+		until := now.Add(i.cfg.CacheTTL)
+		if !expiresAt.IsZero() && expiresAt.Before(until) {
+			until = expiresAt
+		}
+		i.mu.Lock()
+		if len(i.cache) >= maxIntrospectionCache {
+			for k, v := range i.cache {
+				if !now.Before(v.until) {
+					delete(i.cache, k)
+				}
+			}
+		}
+		if len(i.cache) < maxIntrospectionCache {
+			i.cache[key] = introspection{active: active, until: until}
+		}
+		i.mu.Unlock()
+	}
+
+	if !active {
+		return ErrTokenInactive
+	}
+	return nil
+}
+
+// introspect asks the endpoint about token, passing the trace context on
+// to the authorization server.
+func (i *Introspector) introspect(ctx context.Context, token string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "POST token introspection", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(http.MethodPost), semconv.URLFull(i.cfg.URL)))
+	defer span.End()
+
+	active, err := i.post(ctx, token)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return active, err
+}
+
+func (i *Introspector) post(ctx context.Context, token string) (bool, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.cfg.ClientID), url.QueryEscape(i.cfg.ClientSecret))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return false, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	return body.Active, nil
+}
@@ -0,0 +1,74 @@
+package bufpool
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Buffer size classes. Small is what io.Copy would allocate; Large suits
+// streaming whole uploads to disk.
+const (
+	Small = 32 << 10
+	Large = 1 << 20
+)
+
+var classes = [...]struct {
+	size int
+	pool sync.Pool
+}{
+	{size: Small},
+	{size: Large},
+}
+
+func init() {
+	for i := range classes {
+		size := classes[i].size
+		classes[i].pool.New = func() any {
+			b := make([]byte, size)
+			return &b
+		}
+	}
+}
+
+// Get returns a buffer of exactly size bytes, from a pool when size is one
+// of the classes. Return it with Put once nothing refers to it any more.
+func Get(size int) *[]byte {
+	for i := range classes {
+		if classes[i].size == size {
+			return classes[i].pool.Get().(*[]byte)
+		}
+	}
+	b := make([]byte, size)
+	return &b
+}
+
+// Put returns a buffer obtained from Get to its pool.
+func Put(b *[]byte) {
+	for i := range classes {
+		if classes[i].size == cap(*b) {
+			*b = (*b)[:classes[i].size]
+			classes[i].pool.Put(b)
+			return
+		}
+	}
+}
+
+// Copy is io.Copy through a pooled Small buffer. File to file copies are
+// left to the kernel; otherwise the reader's WriteTo and the writer's
+// ReadFrom are bypassed, as most of them fall back to io.Copy, which
+// allocates a buffer for every call.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if _, ok := src.(*os.File); ok {
+		if f, ok := dst.(*os.File); ok {
+			return f.ReadFrom(src)
+		}
+	}
+	buf := Get(Small)
+	defer Put(buf)
+	return io.CopyBuffer(writerOnly{dst}, readerOnly{src}, *buf)
+}
+
+type writerOnly struct{ io.Writer }
+
+type readerOnly struct{ io.Reader }
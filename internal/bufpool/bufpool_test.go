@@ -0,0 +1,129 @@
+package bufpool_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+)
+
+// The benchmarks compare pooled buffers with a fresh one per copy, as
+// io.Copy allocates, on the paths that use them: uploads read in Large
+// chunks for hashing and streamed to a temporary file, and derivatives
+// streamed from an encoder to the response. They run in parallel, as
+// requests do.
+
+const (
+	uploadSize     = 8 << 20
+	derivativeSize = 256 << 10
+)
+
+// stream hides WriteTo and ReadFrom the way multipart parts, pipes and
+// response writers do, so that copies go through a buffer.
+type stream struct{ io.Reader }
+
+type sink struct{ io.Writer }
+
+func BenchmarkUploadChunks(b *testing.B) {
+	payload := make([]byte, uploadSize)
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkChunks(b, payload, func() *[]byte { return bufpool.Get(bufpool.Large) }, bufpool.Put)
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		benchmarkChunks(b, payload, func() *[]byte {
+			buf := make([]byte, bufpool.Large)
+			return &buf
+		}, func(*[]byte) {})
+	})
+}
+
+// benchmarkChunks reads an upload in Large chunks and hashes them, as
+// storage.Fanout does for every writer.
+func benchmarkChunks(b *testing.B, payload []byte, get func() *[]byte, put func(*[]byte)) {
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r := stream{bytes.NewReader(payload)}
+			h := sha256.New()
+			for {
+				buf := get()
+				n, err := io.ReadFull(r, *buf)
+				h.Write((*buf)[:n])
+				put(buf)
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					break
+				}
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkUploadToDisk(b *testing.B) {
+	payload := make([]byte, uploadSize)
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkToDisk(b, payload, bufpool.Copy)
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		benchmarkToDisk(b, payload, io.Copy)
+	})
+}
+
+// benchmarkToDisk streams an upload to a temporary file, as the local
+// storage does before renaming it into place.
+func benchmarkToDisk(b *testing.B, payload []byte, copyFn func(io.Writer, io.Reader) (int64, error)) {
+	dir := b.TempDir()
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		f, err := os.CreateTemp(dir, "upload-*")
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer f.Close()
+		for pb.Next() {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err := copyFn(f, stream{bytes.NewReader(payload)}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkDerivative(b *testing.B) {
+	payload := make([]byte, derivativeSize)
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkDerivative(b, payload, bufpool.Copy)
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		benchmarkDerivative(b, payload, io.Copy)
+	})
+}
+
+// benchmarkDerivative streams a converted image from its encoder to the
+// response, as the converter, interlacer and PDF renderer do.
+func benchmarkDerivative(b *testing.B, payload []byte, copyFn func(io.Writer, io.Reader) (int64, error)) {
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := copyFn(sink{io.Discard}, stream{bytes.NewReader(payload)}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,399 @@
+// Package cdn prewarms the CDN in front of the service, so that the first
+// visitors of files launched at once are not all served by the origin.
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var prewarmed = metrics.NewCounter("media_cdn_prewarmed_urls_total",
+	"URLs prewarmed at the CDN, by target (an edge host or prefetch) and result.", "target", "result")
+
+const (
+	// prefetchBatch is the number of URLs sent in one call to the prefetch
+	// API of the provider.
+	prefetchBatch = 100
+
+	// maxRuns is the number of runs kept for reporting, the running ones
+	// included.
+	maxRuns = 20
+
+	// maxRunErrors bounds the failures a run reports individually.
+	maxRunErrors = 20
+
+	requestTimeout = 5 * time.Minute
+)
+
+// States of a prewarm run.
+const (
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateCancelled = "cancelled"
+)
+
+var (
+	// ErrNothingToPrewarm is returned for requests naming no files.
+	ErrNothingToPrewarm = errors.New("no files to prewarm")
+	// ErrRunNotFound is returned for unknown runs.
+	ErrRunNotFound = errors.New("prewarm run not found")
+)
+
+type Config struct {
+	// Edges are base URLs the CDN serves the service under, e.g. one per
+	// point of presence; files are fetched through each of them.
+	Edges []*url.URL
+
+	// PrefetchURL is the prefetch API of the CDN provider, called with a
+	// JSON body of {"urls": [...]} holding the public URLs of the files
+	// and PrefetchToken as bearer token.
+	PrefetchURL   string
+	PrefetchToken string
+
+	// Concurrency bounds the requests in flight of a run.
+	Concurrency int
+}
+
+// Request names the files to prewarm: the listed ones and those of a
+// collection. Variants are paths under the URL of each file to prewarm as
+// well, e.g. preview or renditions/720p.
+type Request struct {
+	FileIDs    []string
+	Collection string
+	Variants   []string
+}
+
+// URLError is a URL that failed to prewarm.
+type URLError struct {
+	URL    string `json:"url"`
+	Target string `json:"target"`
+	Error  string `json:"error"`
+}
+
+// Run is the progress of prewarming a set of files. Every URL is warmed
+// once per target: each edge, and the prefetch API.
+type Run struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Files      int        `json:"files"`   // Files prewarmed
+	Skipped    int        `json:"skipped"` // Files not found, or not public and so not cached
+	Total      int        `json:"total"`   // URLs times targets
+	Warmed     int        `json:"warmed"`
+	Failed     int        `json:"failed"`
+	Errors     []URLError `json:"errors,omitempty"` // The first failures
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Prewarmer fetches files through the configured CDN edges, or has the
+// CDN provider prefetch them, in the background.
+type Prewarmer struct {
+	files      metadata.Store
+	fileURL    func(id string) string
+	cfg        Config
+	httpClient *http.Client
+	logger     *slog.Logger
+
+	mu   sync.Mutex
+	runs []*Run // Oldest first
+}
+
+// NewPrewarmer prewarms the public URLs fileURL returns for files, which
+// must be those the CDN caches.
+func NewPrewarmer(files metadata.Store, fileURL func(id string) string, cfg Config, logger *slog.Logger) *Prewarmer {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	return &Prewarmer{
+		files:      files,
+		fileURL:    fileURL,
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		logger:     logger,
+	}
+}
+
+// Enabled reports whether any edge or prefetch API is configured.
+func (p *Prewarmer) Enabled() bool {
+	return len(p.cfg.Edges) > 0 || p.cfg.PrefetchURL != ""
+}
+
+// Start resolves the files of req and prewarms them in the background,
+// detached from ctx but for its values. It returns the run reporting the
+// progress.
+func (p *Prewarmer) Start(ctx context.Context, req Request) (Run, error) {
+	files, skipped, err := p.resolve(ctx, req)
+	if err != nil {
+		return Run{}, err
+	}
+	if len(files) == 0 && skipped == 0 {
+		return Run{}, ErrNothingToPrewarm
+	}
+
+	var urls []string
+	for _, f := range files {
+		base := p.fileURL(f.ID)
+		urls = append(urls, base)
+		for _, variant := range req.Variants {
+			urls = append(urls, strings.TrimSuffix(base, "/")+"/"+strings.TrimPrefix(variant, "/"))
+		}
+	}
+	targets := len(p.cfg.Edges)
+	if p.cfg.PrefetchURL != "" {
+		targets++
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	run := &Run{
+		ID:        uuid.New().String(),
+		State:     StateRunning,
+		Files:     len(files),
+		Skipped:   skipped,
+		Total:     len(urls) * targets,
+		StartedAt: time.Now().UTC(),
+		cancel:    cancel,
+	}
+	p.mu.Lock()
+	p.runs = append(p.runs, run)
+	p.trim()
+	snapshot := *run
+	p.mu.Unlock()
+
+	p.logger.Info("Prewarming CDN", "runId", run.ID, "files", run.Files, "urls", len(urls), "targets", targets)
+	go p.run(ctx, run, urls)
+	return snapshot, nil
+}
+
+// resolve returns the public, servable files of req, and the number of
+// those left out.
+func (p *Prewarmer) resolve(ctx context.Context, req Request) ([]domain.FileMetadata, int, error) {
+	var files []domain.FileMetadata
+	skipped := 0
+	seen := make(map[string]bool)
+	add := func(f domain.FileMetadata) {
+		if seen[f.ID] {
+			return
+		}
+		seen[f.ID] = true
+		if !f.Public() || !f.Servable() {
+			skipped++
+			return
+		}
+		files = append(files, f)
+	}
+
+	for _, id := range req.FileIDs {
+		f, err := p.files.Get(ctx, id)
+		if errors.Is(err, metadata.ErrNotFound) {
+			seen[id] = true
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		add(f)
+	}
+	if req.Collection != "" {
+		inCollection, err := p.files.List(ctx, metadata.Filter{Collection: req.Collection})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, f := range inCollection {
+			add(f)
+		}
+	}
+	return files, skipped, nil
+}
+
+func (p *Prewarmer) run(ctx context.Context, run *Run, urls []string) {
+	defer run.cancel()
+
+	sem := make(chan struct{}, p.cfg.Concurrency)
+	var wg sync.WaitGroup
+	do := func(f func()) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			f()
+		}()
+	}
+
+	for _, edge := range p.cfg.Edges {
+		for _, u := range urls {
+			do(func() { p.record(run, u, edge.Host, p.fetch(ctx, edge, u)) })
+		}
+	}
+	if p.cfg.PrefetchURL != "" {
+		for batch := range slices.Chunk(urls, prefetchBatch) {
+			do(func() {
+				err := p.prefetch(ctx, batch)
+				for _, u := range batch {
+					p.record(run, u, "prefetch", err)
+				}
+			})
+		}
+	}
+	wg.Wait()
+
+	now := time.Now().UTC()
+	p.mu.Lock()
+	run.FinishedAt = &now
+	run.State = StateDone
+	if ctx.Err() != nil {
+		run.State = StateCancelled
+	}
+	warmed, failed := run.Warmed, run.Failed
+	p.mu.Unlock()
+	p.logger.Info("CDN prewarm finished", "runId", run.ID, "warmed", warmed, "failed", failed, "cancelled", ctx.Err() != nil)
+}
+
+func (p *Prewarmer) record(run *Run, u, target string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		prewarmed.Inc(target, "ok")
+		run.Warmed++
+		return
+	}
+	prewarmed.Inc(target, "failed")
+	run.Failed++
+	if len(run.Errors) < maxRunErrors {
+		run.Errors = append(run.Errors, URLError{URL: u, Target: target, Error: err.Error()})
+	}
+}
+
+// fetch requests the public URL u through edge, reading the response
+// whole so that the edge caches all of it.
+func (p *Prewarmer) fetch(ctx context.Context, edge *url.URL, u string) error {
+	public, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	target := edge.JoinPath(public.Path)
+	target.RawQuery = public.RawQuery
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "media-service-prewarm")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("edge answered %s", resp.Status)
+	}
+	return nil
+}
+
+// prefetch asks the CDN provider to fetch urls into its caches.
+func (p *Prewarmer) prefetch(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(map[string][]string{"urls": urls})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.PrefetchURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.cfg.PrefetchToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.PrefetchToken)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("prefetch API answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// Runs returns the recent runs, newest first.
+func (p *Prewarmer) Runs() []Run {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	runs := make([]Run, 0, len(p.runs))
+	for i := len(p.runs) - 1; i >= 0; i-- {
+		runs = append(runs, p.snapshot(p.runs[i]))
+	}
+	return runs
+}
+
+// Get returns the progress of a run.
+func (p *Prewarmer) Get(id string) (Run, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, run := range p.runs {
+		if run.ID == id {
+			return p.snapshot(run), nil
+		}
+	}
+	return Run{}, ErrRunNotFound
+}
+
+// Cancel stops a run; the URLs in flight finish.
+func (p *Prewarmer) Cancel(id string) (Run, error) {
+	p.mu.Lock()
+	var found *Run
+	for _, run := range p.runs {
+		if run.ID == id {
+			found = run
+		}
+	}
+	p.mu.Unlock()
+	if found == nil {
+		return Run{}, ErrRunNotFound
+	}
+	found.cancel()
+	return p.Get(id)
+}
+
+// snapshot copies run; p.mu must be held.
+func (p *Prewarmer) snapshot(run *Run) Run {
+	s := *run
+	s.Errors = slices.Clone(run.Errors)
+	return s
+}
+
+// trim drops the oldest finished runs beyond maxRuns; p.mu must be held.
+func (p *Prewarmer) trim() {
+	for i := 0; len(p.runs) > maxRuns && i < len(p.runs); {
+		if p.runs[i].State != StateRunning {
+			p.runs = slices.Delete(p.runs, i, i+1)
+			continue
+		}
+		i++
+	}
+}
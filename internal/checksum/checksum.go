@@ -0,0 +1,117 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"lukechampine.com/blake3"
+)
+
+// Algorithm names, used as keys of recorded checksums.
+const (
+	SHA256 = "sha256"
+	BLAKE3 = "blake3"
+)
+
+// multicodec codes of the algorithms, for multihash encoding.
+var codes = map[string]uint64{
+	SHA256: 0x12,
+	BLAKE3: 0x1e,
+}
+
+// New returns a hash for algorithm.
+func New(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(32, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+}
+
+func Supported(algorithm string) bool {
+	_, ok := codes[algorithm]
+	return ok
+}
+
+// Multi computes checksums with several algorithms in a single pass over
+// the data written to it.
+type Multi struct {
+	hashes map[string]hash.Hash
+	w      io.Writer
+	parts  []io.Writer
+}
+
+// NewMulti returns a Multi for algorithms. Unsupported algorithms are an
+// error.
+func NewMulti(algorithms []string) (*Multi, error) {
+	m := &Multi{hashes: make(map[string]hash.Hash, len(algorithms))}
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, a := range algorithms {
+		if _, dup := m.hashes[a]; dup {
+			continue
+		}
+		h, err := New(a)
+		if err != nil {
+			return nil, err
+		}
+		m.hashes[a] = h
+		writers = append(writers, h)
+	}
+	m.w = io.MultiWriter(writers...)
+	m.parts = writers
+	return m, nil
+}
+
+// Writers returns the individual hashes, so they can be fed concurrently
+// instead of through Write.
+func (m *Multi) Writers() []io.Writer {
+	return m.parts
+}
+
+func (m *Multi) Write(p []byte) (int, error) {
+	return m.w.Write(p)
+}
+
+// Sums returns the hex digest per algorithm.
+func (m *Multi) Sums() map[string]string {
+	sums := make(map[string]string, len(m.hashes))
+	for a, h := range m.hashes {
+		sums[a] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// Multihash encodes a hex digest as a multihash (multicodec code, digest
+// length, digest) in base16 multibase form, e.g. "f1220…" for SHA-256.
+func Multihash(algorithm, digest string) (string, error) {
+	code, ok := codes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest: %w", err)
+	}
+	buf := binary.AppendUvarint(nil, code)
+	buf = binary.AppendUvarint(buf, uint64(len(raw)))
+	return "f" + hex.EncodeToString(append(buf, raw...)), nil
+}
+
+// Multihashes encodes every checksum of a supported algorithm, sorted.
+func Multihashes(sums map[string]string) []string {
+	var out []string
+	for a, digest := range sums {
+		if mh, err := Multihash(a, digest); err == nil {
+			out = append(out, mh)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -2,60 +2,1701 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/cron"
+	"github.com/ondrasimku/media-service-go/internal/jwa"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/region"
+	"github.com/ondrasimku/media-service-go/internal/replication"
 )
 
 type Config struct {
 	HTTPAddr      string
+	TLS           TLSConfig
+	GRPCAddr      string // Listen address of the gRPC API; disabled when empty
 	StorageDir    string
+	Directories   []string // Storage directories files can be copied and moved to besides files and avatars, e.g. tmp
+	MetadataDir   string
 	PublicBaseURL string
 	MaxFileSize   int64
+	Video         VideoConfig
+	Audio         AudioConfig
+	Processing    ProcessingConfig
+	Jobs          JobsConfig
+	Cron          CronConfig
+	Runtime       RuntimeConfig
+	ReviewUploads bool // Quarantine uploads until a moderator approves them
+	MaxVersions   int  // Superseded contents kept per replaced file; 0 keeps all
 	Auth          AuthConfig
+	Errors        ErrorsConfig
+	API           APIConfig
+	CORS          CORSConfig
+	Imaging       ImagingConfig
+	Webhook       WebhookConfig
+	Events        EventsConfig
+	Integrity     IntegrityConfig
+	Widget        WidgetConfig
+	Stats         StatsConfig
+	Licenses      LicensesConfig
+	Expiry        ExpiryConfig
+	Retention     RetentionConfig
+	Feeds         FeedsConfig
+	Quotas        QuotasConfig
+	Orgs          OrgsConfig
+	Visibility    VisibilityConfig
+	Plans         PlansConfig
+	Scan          ScanConfig
+	QC            QCConfig
+	Geo           GeoConfig
+	CDN           CDNConfig
+	Region        RegionConfig
+	Replication   ReplicationConfig
+	Tracing       TracingConfig
+	Metrics       MetricsConfig
+
+	// File is the config file the settings were read from besides the
+	// environment, and Overridden its settings the environment overrides.
+	File       string
+	Overridden []string
 }
 
 type AuthConfig struct {
 	JWKSUrl      string
 	Issuer       string
 	Audience     string
-	JWKSCacheTTL int // Cache TTL in seconds
+	JWKSCacheTTL int      // Cache TTL in seconds
+	Algorithms   []string // Signing algorithms tokens are accepted with, see jwa.Supported
+
+	Leeway            time.Duration // Clock skew tolerated when checking exp, nbf and iat
+	RequireExpiration bool          // Refuse tokens without an exp claim
+	RequireNotBefore  bool          // Refuse tokens without an nbf claim
+
+	ScopePermissions map[string][]string // Permissions granted by OAuth scopes, by scope
+	AdminScopes      []string            // OAuth scopes the /v1/admin routes require besides files:admin
+	AdminRole        string              // Role granting files:admin; none when empty
+
+	Introspection IntrospectionConfig // Revocation check of tokens; off when its URL is empty
+}
+
+// IntrospectionConfig asks the RFC 7662 introspection endpoint of the
+// authorization server whether tokens were revoked.
+type IntrospectionConfig struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	CacheTTL     time.Duration // How long an answer is reused; zero asks for every request
+	FailOpen     bool          // Accept tokens when the endpoint cannot be asked
+}
+
+type ErrorsConfig struct {
+	TypeBaseURL string // Prefix for problem+json type URIs
+	Legacy      bool   // Keep the pre-RFC 7807 {"error","details"} shape
+}
+
+type APIConfig struct {
+	LegacySunset   time.Time // Advertised removal date of the unversioned routes
+	DisabledRoutes []string  // Route groups left unregistered, see RouteGroups
+	SwaggerUI      bool      // Serve Swagger UI for the OpenAPI description at /docs
+	Debug          bool      // Serve pprof and runtime statistics to admins under /v1/admin/debug
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string      // Origins browsers may call the API from, "*" for any; CORS is off when empty
+	AllowedMethods   []string      // Methods allowed in preflights
+	AllowedHeaders   []string      // Request headers allowed in preflights, "*" for any
+	ExposedHeaders   []string      // Response headers the browser may read
+	MaxAge           time.Duration // How long browsers may cache a preflight
+	AllowCredentials bool          // Let browsers send cookies and read responses to them
+}
+
+// RouteGroups are the optional route groups that can be disabled. Uploads
+// and downloads are always served.
+var RouteGroups = []string{
+	"admin", "aliases", "annotations", "avatars", "collections", "feeds", "hls", "jobs",
+	"legacy", "moderation", "openapi", "orgs", "quotas", "settings", "shortlinks", "slugs",
+	"takedowns", "versions", "widgets",
+}
+
+type ImagingConfig struct {
+	StripMetadata       bool   // Remove EXIF/GPS/XMP data from uploaded images
+	PreserveOrientation bool   // Bake EXIF rotation into pixels when stripping
+	VerifyDecode        bool   // Fully decode JPEG/PNG uploads to validate them
+	ExtractColors       bool   // Record dominant/average colors of JPEG/PNG uploads
+	CWebPPath           string // cwebp binary used for WebP conversion
+	AVIFEncPath         string // avifenc binary used for AVIF conversion
+	PDFToPPMPath        string // pdftoppm binary used for PDF previews
+	PreviewWidth        int    // Longest side of PDF previews in pixels
+	WebPQuality         int
+	AVIFQuality         int
+	MaxWidth            int      // Maximum image width in pixels; 0 disables the check
+	MaxHeight           int      // Maximum image height in pixels; 0 disables the check
+	MaxMegapixels       int      // Maximum width*height in millions of pixels; 0 disables the check
+	MaxGIFFrames        int      // Maximum frames of an animated GIF; 0 disables the check
+	MaxGIFMegapixels    int      // Maximum pixels across all GIF frames in millions; 0 disables the check
+	AvatarSizes         []int    // Square sizes avatars are stored at; empty disables the avatar pipeline
+	AvatarHistory       bool     // Keep replaced avatars as versions instead of deleting them
+	Backend             string   // Decoder for full image processing: "go", or "vips" in builds with -tags vips
+	AltTextCollections  []string // Collections whose images must have alt text
+	Watermark           WatermarkConfig
+
+	// UploadSources maps X-Upload-Source hints to the UploadPresets
+	// their uploads are processed with.
+	UploadSources   map[string]string
+	CompressQuality int    // JPEG quality of the compress preset
+	TesseractPath   string // tesseract binary used by the ocr preset
+	OCRLanguages    string // tesseract languages, joined by '+'
+
+	// Generated variants of images in ProgressiveCollections, or requested
+	// with ?progressive=true, are served as progressive JPEGs and
+	// interlaced PNGs.
+	ProgressiveCollections []string
+	JPEGTranPath           string // jpegtran binary used for progressive JPEGs
+	OptiPNGPath            string // optipng binary used for interlaced PNGs
+}
+
+// UploadPresets are the processing presets upload sources can select:
+// default processing, heavier JPEG compression, text recognition, and
+// keeping image metadata.
+var UploadPresets = []string{"default", "compress", "ocr", "original"}
+
+type WatermarkConfig struct {
+	Path        string   // PNG overlay; watermarking is disabled when empty
+	Position    string   // top-left, top-right, bottom-left, bottom-right or center
+	Opacity     float64  // 0..1
+	Directories []string // Storage directories whose images are watermarked when served
+}
+
+type WebhookConfig struct {
+	URL    string
+	Secret string // HMAC-SHA256 key for the X-Media-Signature header
+}
+
+type EventsConfig struct {
+	NATSURL       string // NATS server file events are published to via JetStream; publishing is off when empty
+	Stream        string // JetStream stream created or updated to capture the events; empty leaves provisioning to operators
+	SubjectPrefix string // Prepended to event types to form subjects, e.g. "prod." for "prod.media.created"
+}
+
+type IntegrityConfig struct {
+	ManifestSigningKey string        // HMAC-SHA256 key for the X-Manifest-Signature header
+	ScrubRate          int           // Scrubber read limit in bytes per second; 0 is unthrottled
+	GCMinAge           time.Duration // Blobs younger than this are never taken for orphans
+	GCDryRun           bool          // Only report orphaned blobs instead of removing them
+	ReplicaDir         string        // Local mirror of StorageDir used to repair corrupt blobs
+	ChecksumAlgorithms []string      // Checksums recorded for new uploads besides sha256, which always is: blake3
+}
+
+type WidgetConfig struct {
+	Secret        string        // HMAC-SHA256 key for widget tokens; the widget endpoints are disabled when empty
+	RatePerMinute int           // Sustained widget uploads per origin per minute; 0 disables the limit
+	Burst         int           // Widget uploads an origin may make back to back
+	MaxTokenTTL   time.Duration // Longest lifetime a widget token can be issued with
+}
+
+type VideoConfig struct {
+	MaxSize int64    // Maximum video upload size in bytes; 0 disables video uploads
+	Types   []string // Accepted video types; only video/mp4 and video/webm are understood
+
+	FFmpegPath       string // ffmpeg binary used to transcode videos and decode audio; both are off when it is missing
+	TranscodeHeights []int  // Heights of the H.264/AAC MP4 renditions made of every video
+	TranscodeWorkers int    // Videos transcoded concurrently; 0 disables transcoding
+	TranscodeThreads int    // CPU threads per transcode
+
+	PosterOffset time.Duration // Where in a video its poster frame is taken; shorter videos use their middle
+
+	TeaserFormat string        // Format of the looping previews made while transcoding, one of TeaserFormats; empty makes them on request only
+	TeaserStart  string        // Where teasers start, one of TeaserStarts
+	TeaserLength time.Duration // How much of a video its teaser shows
+	TeaserWidth  int           // Width of teasers in pixels
+	TeaserFPS    int           // Frame rate of teasers
+
+	HLSSegmentDuration time.Duration // Target length of HLS segments; 0 disables HLS packaging
+	StreamSigningKey   string        // HMAC-SHA256 key for signed HLS URLs; streams are public when empty
+	StreamURLTTL       time.Duration // Longest lifetime of a signed HLS URL
+}
+
+// TeaserFormats are the accepted values of VideoConfig.TeaserFormat.
+var TeaserFormats = []string{"gif", "webp"}
+
+// TeaserStarts are the accepted values of VideoConfig.TeaserStart: the
+// start of the video, or its strongest scene change.
+var TeaserStarts = []string{"start", "highlight"}
+
+type AudioConfig struct {
+	MaxSize        int64    // Maximum audio upload size in bytes; 0 disables audio uploads
+	Types          []string // Accepted audio types; only audio/mpeg, audio/ogg and audio/wav are understood
+	WaveformPoints int      // Peak pairs in a waveform
+
+	// Normalize makes a copy of every audio upload brought to an EBU R128
+	// loudness target, as podcast platforms expect.
+	Normalize      bool
+	TargetLUFS     float64 // Integrated loudness of normalized copies
+	TargetTruePeak float64 // Highest true peak of normalized copies in dBTP
+	TargetRange    float64 // Loudness range normalized copies are kept within in LU
+}
+
+type ProcessingConfig struct {
+	MaxMemory int64         // Estimated bytes running processing jobs may hold together; 0 disables the limit
+	MaxCPU    int           // CPU cores running processing jobs may claim together; 0 disables the limit, negative follows GOMAXPROCS
+	MaxWait   time.Duration // How long a request waits for capacity before it is answered with 429
+	MaxQueued int           // Requests waiting for capacity at once; more are answered with 429 right away
+
+	// Serving degrades to originals while more jobs or videos than these
+	// are waiting to be processed; 0 never degrades.
+	DegradedJobDepth       int
+	DegradedTranscodeDepth int
+	DegradedMaxDimension   int // Originals served in place of image variants are downscaled to fit this many pixels a side
+}
+
+type JobsConfig struct {
+	Workers     int           // Background jobs run concurrently; 0 disables the queue and runs their work inline
+	MaxAttempts int           // Runs of a failing job before it is marked failed
+	Backoff     time.Duration // Delay before the first retry; doubles with every further attempt
+	MaxBackoff  time.Duration // Longest delay between retries
+	Retention   time.Duration // How long finished jobs are kept; 0 keeps them forever
+
+	Priorities     map[string]int    // Priority by job kind; higher runs first
+	Tiers          map[string]string // Tier by organization ID, which also names its plan
+	TierPriorities map[string]int    // Priority added to the jobs of organizations in a tier
+	Aging          time.Duration     // Waiting time that raises the priority of a due job by one; 0 never does
+
+	// Replicas sharing the job store run the jobs of a file on the one its
+	// ID hashes to when Worker is set, see jobs.Config.
+	Worker    string
+	Heartbeat time.Duration
+}
+
+// CronConfig schedules the periodic tasks: audit, scrub, gc, availability,
+// expiry, retention and offboarding. Each is set by MEDIA_CRON_<TASK> to a cron
+// expression, a descriptor such as @daily, or "off"; unset, the task runs
+// at the interval of its older MEDIA_*_INTERVAL setting, offboarding
+// hourly.
+type CronConfig struct {
+	Schedules map[string]cron.Schedule // Schedule by task; tasks missing are not run
+}
+
+type RuntimeConfig struct {
+	MaxProcs    int   // GOMAXPROCS; 0 derives it from the container CPU quota
+	GCPercent   int   // GC target percentage; 0 keeps GOGC, negative collects only at MemoryLimit
+	MemoryLimit int64 // Soft memory limit in bytes; 0 keeps GOMEMLIMIT
+}
+
+type StatsConfig struct {
+	Retention time.Duration // How far back GET /admin/stats can look
+}
+
+type LicensesConfig struct {
+	ExpiryAction string        // What happens to downloads of files whose license expired: block or flag
+	ReportWindow time.Duration // Default look-ahead of the expiring licenses report
+}
+
+// LicenseExpiryActions are the accepted values of LicensesConfig.ExpiryAction.
+var LicenseExpiryActions = []string{"block", "flag"}
+
+type ExpiryConfig struct {
+	MaxTTL        time.Duration            // Longest TTL uploads may ask for; 0 is no limit
+	DirectoryTTLs map[string]time.Duration // TTL of uploads to each storage directory that ask for none
+}
+
+type RetentionConfig struct {
+	Rules map[string]time.Duration // How long files are kept by storage directory, "*" for the rest; negative keeps them forever
+}
+
+type FeedsConfig struct {
+	CacheTTL time.Duration // How long rendered sitemaps and feeds are served before being rebuilt
+}
+
+type OrgsConfig struct {
+	OffboardingGrace time.Duration // How long the files of an offboarded organization are kept before deletion, and its export after that
+	Settings         []string      // Settings organization admins may change themselves, see OrgSettings
+}
+
+// OrgSettings are the settings MEDIA_ORG_SETTINGS may permit organization
+// admins to change, all of them unless set; "none" permits none.
+var OrgSettings = []string{"branding", "types", "visibility", "webhooks"}
+
+type VisibilityConfig struct {
+	Default    string        // Visibility of uploads that ask for none, one of Visibilities
+	SigningKey string        // HMAC-SHA256 key for signed file URLs; none are issued when empty
+	URLTTL     time.Duration // Longest lifetime of a signed file URL
+}
+
+// PlansConfig defines the plans organizations are on. An organization is on
+// the plan named by its tier in MEDIA_ORG_TIERS, else by the entitlement
+// service, else on the default plan.
+type PlansConfig struct {
+	Plans                map[string]plans.Plan // By name, each set by MEDIA_PLAN_<NAME>
+	Default              string                // Plan of organizations without one
+	EntitlementsURL      string                // Service naming the plans of organizations; disabled when empty
+	EntitlementsCacheTTL time.Duration         // How long the plans it names are cached
+}
+
+// Visibilities are the accepted values of VisibilityConfig.Default.
+var Visibilities = []string{"public", "org", "private"}
+
+type QuotasConfig struct {
+	MaxBytes int64 // Default limit on the bytes of originals an organization stores; 0 disables the limit
+	MaxFiles int64 // Default limit on the files an organization stores; 0 disables the limit
+	WarnAt   []int // Percentages of a limit at which uploads crossing them emit a quota warning event
+	Grace    int   // Percentage of a limit an upload begun under it may take an organization over it by
+}
+
+// ScanConfig turns on scanning uploads for malware with clamd.
+type ScanConfig struct {
+	ClamdAddress string        // unix:///path/to/clamd.sock, tcp://host:port or host:port; scanning is off when empty
+	SyncMaxSize  int64         // Uploads up to this size are scanned before the upload returns, larger ones by a job
+	Timeout      time.Duration // Limit on a single scan
+
+	// CacheTTL is how long the sniffed type and scan of an uploaded
+	// content are reused for uploads of the same content; 0 is off.
+	CacheTTL time.Duration
+}
+
+// QCConfig turns on quality checks of partner deliveries: video and audio
+// uploaded to Collections are checked for silence at either end, black
+// stretches and streams that do not decode.
+type QCConfig struct {
+	Collections  []string      // Collections whose video and audio are checked; checks are off when empty
+	Policy       string        // What happens to files failing a check, see QCPolicies
+	MaxSilence   time.Duration // Leading or trailing silence at least this long fails
+	SilenceNoise float64       // dBFS below which audio counts as silent
+	MaxBlack     time.Duration // Black stretches at least this long fail
+}
+
+// QCPolicies are the accepted values of QCConfig.Policy: only record the
+// findings, also quarantine failing files for review, or refuse their
+// upload, which checks them before the upload returns.
+var QCPolicies = []string{"record", "flag", "reject"}
+
+type GeoConfig struct {
+	DatabasePath  string // MaxMind GeoLite2/GeoIP2 Country database clients are located with
+	CountryHeader string // Header a trusted CDN sets to the client's country, e.g. CF-IPCountry; preferred over the database
+}
+
+// CDNConfig lets operators prewarm the CDN in front of the service ahead
+// of launches, through its edges or the prefetch API of its provider.
+type CDNConfig struct {
+	Edges              []*url.URL // Base URLs of the CDN edges files are fetched through
+	PrefetchURL        string     // Prefetch API of the provider, called with {"urls": [...]}
+	PrefetchToken      string     // Bearer token of the prefetch API
+	PrewarmConcurrency int        // Requests in flight of a prewarm run
+}
+
+// RegionConfig places the deployment in a region among the deployments of
+// other regions. Files record the region they were uploaded in, whose
+// storage holds them; downloads of files homed in a region with a peer are
+// sent there.
+type RegionConfig struct {
+	Name  string              // Region of this deployment; empty outside multi-region setups
+	Peers map[string]*url.URL // Base URLs of the deployments of other regions, by region
+	Mode  string              // How downloads reach their peer, see region.Modes
+}
+
+// ReplicationConfig turns on replicating files asynchronously to the peers
+// of the region, so that every region serves every file, and downloads are
+// no longer sent to the home region.
+type ReplicationConfig struct {
+	Enabled   bool
+	Secret    string        // Shared by all regions; signs their requests to each other
+	Conflicts string        // Rule for conflicting writes, see replication.ConflictRules
+	Interval  time.Duration // How often writes that failed to replicate are retried
+	MaxLag    time.Duration // Replication lag beyond which a peer is reported unhealthy
+}
+
+// TracingConfig turns on exporting OpenTelemetry traces. The exporter,
+// sampler and resource are configured with the standard OTEL_* variables.
+type TracingConfig struct {
+	Enabled     bool              // Set when an OTLP endpoint is configured and OTEL_SDK_DISABLED is not true
+	ServiceName string            // OTEL_SERVICE_NAME, media-service by default
+	Env         map[string]string // OTEL_* settings of the config file the environment does not override
+}
+
+// TLSConfig serves HTTPS on HTTPAddr, and the gRPC API with TLS, with a
+// certificate from files or obtained from an ACME CA such as Let's
+// Encrypt. Plain HTTP is served when neither is set.
+type TLSConfig struct {
+	CertFile string // PEM certificate chain; reloaded when the file changes
+	KeyFile  string
+
+	Autocert             bool     // Obtain and renew certificates from the ACME CA
+	AutocertHosts        []string // Host names certificates are obtained for; the host of PublicBaseURL by default
+	AutocertEmail        string   // Contact of the ACME account, told about expiring certificates
+	AutocertCacheDir     string   // Where the account key and certificates are kept across restarts
+	AutocertDirectoryURL string   // ACME directory; Let's Encrypt when empty
+
+	// RedirectAddr is a listen address, usually :80, redirecting plain
+	// HTTP to HTTPS and answering ACME HTTP-01 challenges; none when empty.
+	RedirectAddr string
+}
+
+// Enabled reports whether HTTPS is served.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.Autocert
+}
+
+type MetricsConfig struct {
+	PushURL string // Pushgateway group the final metrics are put to on shutdown; none when empty
 }
 
-func Load() (*Config, error) {
-	httpAddr := getEnv("MEDIA_HTTP_ADDR", ":8080")
-	storageDir := getEnv("MEDIA_STORAGE_DIR", "/var/media")
-	publicBaseURL := getEnv("MEDIA_PUBLIC_BASE_URL", "http://localhost:8080")
-	maxFileSizeStr := getEnv("MEDIA_MAX_FILE_SIZE", "10485760")
+func load(e env) (*Config, error) {
+	httpAddr := e.getEnv("MEDIA_HTTP_ADDR", ":8080")
+	storageDir := e.getEnv("MEDIA_STORAGE_DIR", "/var/media")
+	publicBaseURL := e.getEnv("MEDIA_PUBLIC_BASE_URL", "http://localhost:8080")
+	maxFileSizeStr := e.getEnv("MEDIA_MAX_FILE_SIZE", "10485760")
 
 	maxFileSize, err := strconv.ParseInt(maxFileSizeStr, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid MEDIA_MAX_FILE_SIZE: %w", err)
+		return nil, settingError("MEDIA_MAX_FILE_SIZE", "%w", err)
+	}
+
+	jwksCacheTTL, err := e.getEnvInt("AUTH_JWKS_CACHE_TTL", 900) // 15 minutes
+	if err != nil {
+		return nil, err
+	}
+	if jwksCacheTTL < 0 {
+		return nil, settingError("AUTH_JWKS_CACHE_TTL", "%d, expected seconds of at least 0", jwksCacheTTL)
 	}
 
-	jwksCacheTTL := 900 // 15 minutes default
-	if ttlStr := getEnv("AUTH_JWKS_CACHE_TTL", ""); ttlStr != "" {
-		if ttl, err := strconv.Atoi(ttlStr); err == nil {
-			jwksCacheTTL = ttl
+	authAlgorithms := e.getEnvList("AUTH_ALGORITHMS")
+	if len(authAlgorithms) == 0 {
+		authAlgorithms = jwa.Default
+	}
+	for _, alg := range authAlgorithms {
+		if !slices.Contains(jwa.Supported, alg) {
+			return nil, settingError("AUTH_ALGORITHMS", "unknown algorithm %q, expected one of %s", alg, strings.Join(jwa.Supported, ", "))
 		}
 	}
 
-	return &Config{
-		HTTPAddr:      httpAddr,
-		StorageDir:    storageDir,
-		PublicBaseURL: publicBaseURL,
-		MaxFileSize:   maxFileSize,
-		Auth: AuthConfig{
-			JWKSUrl:      getEnv("AUTH_JWKS_URL", "http://user-service:3000/.well-known/jwks.json"),
-			Issuer:       getEnv("AUTH_ISSUER", "http://user-service:3000"),
-			Audience:     getEnv("AUTH_AUDIENCE", "backboard"),
-			JWKSCacheTTL: jwksCacheTTL,
-		},
-	}, nil
-}
+	authLeeway, err := e.getEnvInt("AUTH_LEEWAY_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if authLeeway < 0 || authLeeway > 300 {
+		return nil, settingError("AUTH_LEEWAY_SECONDS", "must be between 0 and 300")
+	}
+	authRequireExp, err := e.getEnvBool("AUTH_REQUIRE_EXP", false)
+	if err != nil {
+		return nil, err
+	}
+	authRequireNbf, err := e.getEnvBool("AUTH_REQUIRE_NBF", false)
+	if err != nil {
+		return nil, err
+	}
+	// Permissions a scope grants are separated by spaces, e.g.
+	// "media.write=files:upload files:delete,media.admin=files:admin".
+	scopePermissions := make(map[string][]string)
+	for _, item := range e.getEnvList("AUTH_SCOPE_PERMISSIONS") {
+		scope, permissions, ok := strings.Cut(item, "=")
+		scope = strings.TrimSpace(scope)
+		if !ok || scope == "" || len(strings.Fields(permissions)) == 0 {
+			return nil, settingError("AUTH_SCOPE_PERMISSIONS", "%q, expected scope=permission permission", item)
+		}
+		scopePermissions[scope] = append(scopePermissions[scope], strings.Fields(permissions)...)
+	}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	introspectionURL := e.getEnv("AUTH_INTROSPECTION_URL", "")
+	if introspectionURL != "" {
+		if u, err := url.Parse(introspectionURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, settingError("AUTH_INTROSPECTION_URL", "%q, expected an http(s) URL", introspectionURL)
+		}
 	}
-	return defaultValue
+	introspectionCache, err := e.getEnvInt("AUTH_INTROSPECTION_CACHE_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if introspectionCache < 0 || introspectionCache > 3600 {
+		return nil, settingError("AUTH_INTROSPECTION_CACHE_SECONDS", "must be between 0 and 3600")
+	}
+	introspectionFailOpen, err := e.getEnvBool("AUTH_INTROSPECTION_FAIL_OPEN", false)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsPushURL := e.getEnv("MEDIA_METRICS_PUSH_URL", "")
+	if metricsPushURL != "" {
+		if u, err := url.Parse(metricsPushURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, settingError("MEDIA_METRICS_PUSH_URL", "%q, expected an http(s) URL", metricsPushURL)
+		}
+	}
+
+	// Rejected uploads and other moderation decisions are posted here.
+	webhookURL := e.getEnv("MEDIA_WEBHOOK_URL", "")
+	if webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, settingError("MEDIA_WEBHOOK_URL", "%q, expected an http(s) URL", webhookURL)
+		}
+	}
+
+	tlsCfg := TLSConfig{
+		CertFile:             e.getEnv("MEDIA_TLS_CERT_FILE", ""),
+		KeyFile:              e.getEnv("MEDIA_TLS_KEY_FILE", ""),
+		AutocertHosts:        e.getEnvList("MEDIA_TLS_AUTOCERT_HOSTS"),
+		AutocertEmail:        e.getEnv("MEDIA_TLS_AUTOCERT_EMAIL", ""),
+		AutocertCacheDir:     e.getEnv("MEDIA_TLS_AUTOCERT_CACHE_DIR", filepath.Join(storageDir, ".autocert")),
+		AutocertDirectoryURL: e.getEnv("MEDIA_TLS_AUTOCERT_DIRECTORY_URL", ""),
+		RedirectAddr:         e.getEnv("MEDIA_TLS_REDIRECT_ADDR", ""),
+	}
+	if tlsCfg.Autocert, err = e.getEnvBool("MEDIA_TLS_AUTOCERT", false); err != nil {
+		return nil, err
+	}
+	if (tlsCfg.CertFile == "") != (tlsCfg.KeyFile == "") {
+		return nil, settingError("MEDIA_TLS_CERT_FILE", "MEDIA_TLS_CERT_FILE and MEDIA_TLS_KEY_FILE must be set together")
+	}
+	if tlsCfg.CertFile != "" && tlsCfg.Autocert {
+		return nil, settingError("MEDIA_TLS_AUTOCERT", "certificates are either obtained or read from MEDIA_TLS_CERT_FILE, not both")
+	}
+	if tlsCfg.Autocert && len(tlsCfg.AutocertHosts) == 0 {
+		u, err := url.Parse(publicBaseURL)
+		if err != nil || u.Hostname() == "" || u.Hostname() == "localhost" {
+			return nil, settingError("MEDIA_TLS_AUTOCERT_HOSTS", "required unless MEDIA_PUBLIC_BASE_URL names the public host")
+		}
+		tlsCfg.AutocertHosts = []string{u.Hostname()}
+	}
+	if tlsCfg.RedirectAddr != "" && !tlsCfg.Enabled() {
+		return nil, settingError("MEDIA_TLS_REDIRECT_ADDR", "redirecting to HTTPS requires MEDIA_TLS_CERT_FILE or MEDIA_TLS_AUTOCERT")
+	}
+	// The CA connects to port 443 for TLS-ALPN-01 challenges, or to port
+	// 80 for HTTP-01 ones, which the redirect server answers.
+	if _, port, _ := net.SplitHostPort(httpAddr); tlsCfg.Autocert && tlsCfg.RedirectAddr == "" && port != "443" {
+		return nil, settingError("MEDIA_TLS_REDIRECT_ADDR", "required with MEDIA_TLS_AUTOCERT unless MEDIA_HTTP_ADDR listens on port 443, or ACME challenges cannot be answered")
+	}
+
+	legacyErrors, err := e.getEnvBool("MEDIA_LEGACY_ERRORS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	swaggerUI, err := e.getEnvBool("MEDIA_SWAGGER_UI", false)
+	if err != nil {
+		return nil, err
+	}
+
+	debugRoutes, err := e.getEnvBool("MEDIA_DEBUG_ENDPOINTS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var legacySunset time.Time
+	if sunsetStr := e.getEnv("MEDIA_LEGACY_API_SUNSET", ""); sunsetStr != "" {
+		legacySunset, err = time.Parse(time.DateOnly, sunsetStr)
+		if err != nil {
+			return nil, settingError("MEDIA_LEGACY_API_SUNSET", "%w", err)
+		}
+	}
+
+	disabledRoutes := e.getEnvList("MEDIA_DISABLED_ROUTES")
+	for _, group := range disabledRoutes {
+		if !slices.Contains(RouteGroups, group) {
+			return nil, settingError("MEDIA_DISABLED_ROUTES", "unknown route group %q, expected one of %s", group, strings.Join(RouteGroups, ", "))
+		}
+	}
+
+	corsOrigins := e.getEnvList("MEDIA_CORS_ALLOWED_ORIGINS")
+	for _, origin := range corsOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, settingError("MEDIA_CORS_ALLOWED_ORIGINS", "%q is not an origin such as https://app.example.com", origin)
+		}
+	}
+	corsMethods := e.getEnvList("MEDIA_CORS_ALLOWED_METHODS")
+	if e("MEDIA_CORS_ALLOWED_METHODS") == "" {
+		corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	corsHeaders := e.getEnvList("MEDIA_CORS_ALLOWED_HEADERS")
+	if e("MEDIA_CORS_ALLOWED_HEADERS") == "" {
+		corsHeaders = []string{"Authorization", "Content-Type", "Content-Digest", "X-Checksum-SHA256", "X-Upload-Source", "X-Widget-Token"}
+	}
+	corsExposed := e.getEnvList("MEDIA_CORS_EXPOSED_HEADERS")
+	if e("MEDIA_CORS_EXPOSED_HEADERS") == "" {
+		corsExposed = []string{"Location", "ETag", "Content-Digest", "Retry-After"}
+	}
+	corsMaxAge, err := e.getEnvDuration("MEDIA_CORS_MAX_AGE", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	corsCredentials, err := e.getEnvBool("MEDIA_CORS_ALLOW_CREDENTIALS", false)
+	if err != nil {
+		return nil, err
+	}
+	if corsCredentials && slices.Contains(corsOrigins, "*") {
+		return nil, settingError("MEDIA_CORS_ALLOW_CREDENTIALS", "credentials cannot be allowed for any origin")
+	}
+
+	webpQuality, err := e.getEnvInt("MEDIA_WEBP_QUALITY", 80)
+	if err != nil {
+		return nil, err
+	}
+	avifQuality, err := e.getEnvInt("MEDIA_AVIF_QUALITY", 60)
+	if err != nil {
+		return nil, err
+	}
+	previewWidth, err := e.getEnvInt("MEDIA_PREVIEW_WIDTH", 800)
+	if err != nil {
+		return nil, err
+	}
+	compressQuality, err := e.getEnvInt("MEDIA_COMPRESS_JPEG_QUALITY", 72)
+	if err != nil {
+		return nil, err
+	}
+	if compressQuality < 1 || compressQuality > 100 {
+		return nil, settingError("MEDIA_COMPRESS_JPEG_QUALITY", "%d, expected 1 to 100", compressQuality)
+	}
+
+	uploadSources := make(map[string]string)
+	sourcePresets := e.getEnvList("MEDIA_UPLOAD_SOURCE_PRESETS")
+	if e("MEDIA_UPLOAD_SOURCE_PRESETS") == "" {
+		sourcePresets = []string{"mobile-camera=compress", "scanner=ocr", "export=original"}
+	}
+	for _, item := range sourcePresets {
+		source, preset, ok := strings.Cut(item, "=")
+		source, preset = strings.ToLower(strings.TrimSpace(source)), strings.TrimSpace(preset)
+		if !ok || source == "" {
+			return nil, settingError("MEDIA_UPLOAD_SOURCE_PRESETS", "%q, expected source=preset", item)
+		}
+		if !slices.Contains(UploadPresets, preset) {
+			return nil, settingError("MEDIA_UPLOAD_SOURCE_PRESETS", "unknown preset %q, expected one of %s", preset, strings.Join(UploadPresets, ", "))
+		}
+		uploadSources[source] = preset
+	}
+
+	maxWidth, err := e.getEnvInt("MEDIA_MAX_IMAGE_WIDTH", 16384)
+	if err != nil {
+		return nil, err
+	}
+	maxHeight, err := e.getEnvInt("MEDIA_MAX_IMAGE_HEIGHT", 16384)
+	if err != nil {
+		return nil, err
+	}
+	maxMegapixels, err := e.getEnvInt("MEDIA_MAX_IMAGE_MEGAPIXELS", 50)
+	if err != nil {
+		return nil, err
+	}
+
+	stripMetadata, err := e.getEnvBool("MEDIA_STRIP_METADATA", true)
+	if err != nil {
+		return nil, err
+	}
+	preserveOrientation, err := e.getEnvBool("MEDIA_PRESERVE_ORIENTATION", true)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyDecode, err := e.getEnvBool("MEDIA_VERIFY_IMAGE_DECODE", true)
+	if err != nil {
+		return nil, err
+	}
+	extractColors, err := e.getEnvBool("MEDIA_EXTRACT_COLORS", true)
+	if err != nil {
+		return nil, err
+	}
+
+	maxGIFFrames, err := e.getEnvInt("MEDIA_MAX_GIF_FRAMES", 500)
+	if err != nil {
+		return nil, err
+	}
+	maxGIFMegapixels, err := e.getEnvInt("MEDIA_MAX_GIF_MEGAPIXELS", 250)
+	if err != nil {
+		return nil, err
+	}
+
+	avatarPipeline, err := e.getEnvBool("MEDIA_AVATAR_PIPELINE", true)
+	if err != nil {
+		return nil, err
+	}
+	var avatarSizes []int
+	if avatarPipeline {
+		for _, item := range e.getEnvList("MEDIA_AVATAR_SIZES") {
+			size, err := strconv.Atoi(item)
+			if err != nil || size <= 0 {
+				return nil, settingError("MEDIA_AVATAR_SIZES", "%q is not a positive integer", item)
+			}
+			avatarSizes = append(avatarSizes, size)
+		}
+		if len(avatarSizes) == 0 {
+			avatarSizes = []int{64, 128, 256, 512}
+		}
+	}
+
+	avatarHistory, err := e.getEnvBool("MEDIA_AVATAR_HISTORY", false)
+	if err != nil {
+		return nil, err
+	}
+
+	watermarkOpacity, err := e.getEnvFloat("MEDIA_WATERMARK_OPACITY", 0.5)
+	if err != nil {
+		return nil, err
+	}
+	reviewUploads, err := e.getEnvBool("MEDIA_REVIEW_UPLOADS", false)
+	if err != nil {
+		return nil, err
+	}
+	maxVersions, err := e.getEnvInt("MEDIA_MAX_FILE_VERSIONS", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	auditInterval, err := e.getEnvDuration("MEDIA_MANIFEST_AUDIT_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	scrubInterval, err := e.getEnvDuration("MEDIA_SCRUB_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	scrubRate, err := e.getEnvInt("MEDIA_SCRUB_RATE", 4<<20)
+	if err != nil {
+		return nil, err
+	}
+	gcInterval, err := e.getEnvDuration("MEDIA_GC_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	gcMinAge, err := e.getEnvDuration("MEDIA_GC_MIN_AGE", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if gcMinAge < time.Hour {
+		return nil, settingError("MEDIA_GC_MIN_AGE", "must be at least 1h so uploads in flight are kept")
+	}
+	gcDryRun, err := e.getEnvBool("MEDIA_GC_DRY_RUN", false)
+	if err != nil {
+		return nil, err
+	}
+
+	widgetRate, err := e.getEnvInt("MEDIA_WIDGET_RATE_PER_MINUTE", 30)
+	if err != nil {
+		return nil, err
+	}
+	widgetBurst, err := e.getEnvInt("MEDIA_WIDGET_BURST", 10)
+	if err != nil {
+		return nil, err
+	}
+	widgetTokenTTL, err := e.getEnvDuration("MEDIA_WIDGET_MAX_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	maxVideoSize, err := strconv.ParseInt(e.getEnv("MEDIA_MAX_VIDEO_SIZE", "1073741824"), 10, 64)
+	if err != nil {
+		return nil, settingError("MEDIA_MAX_VIDEO_SIZE", "%w", err)
+	}
+	videoTypes := e.getEnvList("MEDIA_VIDEO_TYPES")
+	if e("MEDIA_VIDEO_TYPES") == "" {
+		videoTypes = []string{"video/mp4", "video/webm"}
+	}
+	var transcodeHeights []int
+	for _, item := range e.getEnvList("MEDIA_TRANSCODE_HEIGHTS") {
+		height, err := strconv.Atoi(item)
+		if err != nil || height <= 0 || height%2 != 0 {
+			return nil, settingError("MEDIA_TRANSCODE_HEIGHTS", "%q is not a positive even integer", item)
+		}
+		transcodeHeights = append(transcodeHeights, height)
+	}
+	if len(transcodeHeights) == 0 {
+		transcodeHeights = []int{360, 720, 1080}
+	}
+	transcodeWorkers, err := e.getEnvInt("MEDIA_TRANSCODE_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+	transcodeThreads, err := e.getEnvInt("MEDIA_TRANSCODE_THREADS", 2)
+	if err != nil {
+		return nil, err
+	}
+	posterOffset, err := e.getEnvDuration("MEDIA_POSTER_OFFSET", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if posterOffset < 0 {
+		return nil, settingError("MEDIA_POSTER_OFFSET", "must not be negative")
+	}
+	// "none" leaves teasers to be made on request.
+	teaserFormat := e.getEnv("MEDIA_VIDEO_TEASER_FORMAT", "webp")
+	if teaserFormat == "none" {
+		teaserFormat = ""
+	} else if !slices.Contains(TeaserFormats, teaserFormat) {
+		return nil, settingError("MEDIA_VIDEO_TEASER_FORMAT", "%q, expected none or one of %s", teaserFormat, strings.Join(TeaserFormats, ", "))
+	}
+	teaserStart := e.getEnv("MEDIA_VIDEO_TEASER_START", "start")
+	if !slices.Contains(TeaserStarts, teaserStart) {
+		return nil, settingError("MEDIA_VIDEO_TEASER_START", "%q, expected one of %s", teaserStart, strings.Join(TeaserStarts, ", "))
+	}
+	teaserLength, err := e.getEnvDuration("MEDIA_VIDEO_TEASER_LENGTH", 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if teaserLength <= 0 {
+		return nil, settingError("MEDIA_VIDEO_TEASER_LENGTH", "must be positive")
+	}
+	teaserWidth, err := e.getEnvInt("MEDIA_VIDEO_TEASER_WIDTH", 320)
+	if err != nil {
+		return nil, err
+	}
+	if teaserWidth < 16 || teaserWidth%2 != 0 {
+		return nil, settingError("MEDIA_VIDEO_TEASER_WIDTH", "must be an even number of at least 16")
+	}
+	teaserFPS, err := e.getEnvInt("MEDIA_VIDEO_TEASER_FPS", 10)
+	if err != nil {
+		return nil, err
+	}
+	if teaserFPS < 1 || teaserFPS > 30 {
+		return nil, settingError("MEDIA_VIDEO_TEASER_FPS", "must be between 1 and 30")
+	}
+	hlsSegmentDuration, err := e.getEnvDuration("MEDIA_HLS_SEGMENT_DURATION", 6*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	streamURLTTL, err := e.getEnvDuration("MEDIA_STREAM_URL_TTL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAudioSize, err := strconv.ParseInt(e.getEnv("MEDIA_MAX_AUDIO_SIZE", "104857600"), 10, 64)
+	if err != nil {
+		return nil, settingError("MEDIA_MAX_AUDIO_SIZE", "%w", err)
+	}
+	audioTypes := e.getEnvList("MEDIA_AUDIO_TYPES")
+	if e("MEDIA_AUDIO_TYPES") == "" {
+		audioTypes = []string{"audio/mpeg", "audio/ogg", "audio/wav"}
+	}
+	normalizeAudio, err := e.getEnvBool("MEDIA_AUDIO_NORMALIZE", false)
+	if err != nil {
+		return nil, err
+	}
+	targetLUFS, err := e.getEnvFloat("MEDIA_AUDIO_TARGET_LUFS", -16)
+	if err != nil {
+		return nil, err
+	}
+	if targetLUFS < -70 || targetLUFS > -5 {
+		return nil, settingError("MEDIA_AUDIO_TARGET_LUFS", "must be between -70 and -5")
+	}
+	targetTruePeak, err := e.getEnvFloat("MEDIA_AUDIO_TARGET_TRUE_PEAK", -1.5)
+	if err != nil {
+		return nil, err
+	}
+	if targetTruePeak < -9 || targetTruePeak > 0 {
+		return nil, settingError("MEDIA_AUDIO_TARGET_TRUE_PEAK", "must be between -9 and 0")
+	}
+	targetRange, err := e.getEnvFloat("MEDIA_AUDIO_TARGET_LRA", 11)
+	if err != nil {
+		return nil, err
+	}
+	if targetRange < 1 || targetRange > 50 {
+		return nil, settingError("MEDIA_AUDIO_TARGET_LRA", "must be between 1 and 50")
+	}
+	waveformPoints, err := e.getEnvInt("MEDIA_WAVEFORM_POINTS", 1000)
+	if err != nil {
+		return nil, err
+	}
+	if waveformPoints <= 0 {
+		return nil, settingError("MEDIA_WAVEFORM_POINTS", "must be positive")
+	}
+
+	processingMemory, err := strconv.ParseInt(e.getEnv("MEDIA_PROCESSING_MEMORY_LIMIT", "1073741824"), 10, 64)
+	if err != nil {
+		return nil, settingError("MEDIA_PROCESSING_MEMORY_LIMIT", "%w", err)
+	}
+	// GOMAXPROCS is only known once the runtime is configured.
+	processingCPU, err := e.getEnvInt("MEDIA_PROCESSING_CPU_LIMIT", -1)
+	if err != nil {
+		return nil, err
+	}
+	processingWait, err := e.getEnvDuration("MEDIA_PROCESSING_MAX_WAIT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	processingQueued, err := e.getEnvInt("MEDIA_PROCESSING_MAX_QUEUED", 64)
+	if err != nil {
+		return nil, err
+	}
+	degradedJobDepth, err := e.getEnvInt("MEDIA_DEGRADED_JOB_DEPTH", 0)
+	if err != nil {
+		return nil, err
+	}
+	degradedTranscodeDepth, err := e.getEnvInt("MEDIA_DEGRADED_TRANSCODE_DEPTH", 0)
+	if err != nil {
+		return nil, err
+	}
+	degradedMaxDimension, err := e.getEnvInt("MEDIA_DEGRADED_MAX_DIMENSION", 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	jobWorkers, err := e.getEnvInt("MEDIA_JOB_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	jobMaxAttempts, err := e.getEnvInt("MEDIA_JOB_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	if jobMaxAttempts <= 0 {
+		return nil, settingError("MEDIA_JOB_MAX_ATTEMPTS", "must be positive")
+	}
+	jobBackoff, err := e.getEnvDuration("MEDIA_JOB_BACKOFF", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	jobMaxBackoff, err := e.getEnvDuration("MEDIA_JOB_MAX_BACKOFF", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	jobRetention, err := e.getEnvDuration("MEDIA_JOB_RETENTION", 7*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	jobPriorities, err := e.getEnvPriorities("MEDIA_JOB_PRIORITIES", "kind")
+	if err != nil {
+		return nil, err
+	}
+	if e("MEDIA_JOB_PRIORITIES") == "" {
+		// Scans and QC hold new uploads back from serving.
+		jobPriorities = map[string]int{"scan": 20, "qc": 10}
+	}
+	jobTierPriorities, err := e.getEnvPriorities("MEDIA_JOB_TIER_PRIORITIES", "tier")
+	if err != nil {
+		return nil, err
+	}
+	orgTiers := make(map[string]string)
+	for _, item := range e.getEnvList("MEDIA_ORG_TIERS") {
+		orgID, tier, ok := strings.Cut(item, "=")
+		orgID, tier = strings.TrimSpace(orgID), strings.TrimSpace(tier)
+		if !ok || orgID == "" || tier == "" {
+			return nil, settingError("MEDIA_ORG_TIERS", "%q, expected org=tier", item)
+		}
+		orgTiers[orgID] = tier
+	}
+	jobAging, err := e.getEnvDuration("MEDIA_JOB_AGING", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	jobAffinity, err := e.getEnvBool("MEDIA_JOB_AFFINITY", false)
+	if err != nil {
+		return nil, err
+	}
+	var jobWorker string
+	if jobAffinity {
+		hostname, _ := os.Hostname()
+		jobWorker = e.getEnv("MEDIA_JOB_WORKER_ID", hostname)
+		// The ID names the record of the worker in the job store.
+		invalid := func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+		}
+		if jobWorker == "" || strings.ContainsFunc(jobWorker, invalid) {
+			return nil, settingError("MEDIA_JOB_WORKER_ID", "%q, expected letters, digits, dots, dashes and underscores", jobWorker)
+		}
+	}
+	jobHeartbeat, err := e.getEnvDuration("MEDIA_JOB_HEARTBEAT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if jobHeartbeat <= 0 {
+		return nil, settingError("MEDIA_JOB_HEARTBEAT", "must be positive")
+	}
+
+	maxProcs, err := e.getEnvInt("MEDIA_GOMAXPROCS", 0)
+	if err != nil {
+		return nil, err
+	}
+	gcPercent, err := e.getEnvInt("MEDIA_GC_PERCENT", 0)
+	if err != nil {
+		return nil, err
+	}
+	memoryLimit, err := strconv.ParseInt(e.getEnv("MEDIA_MEMORY_LIMIT", "0"), 10, 64)
+	if err != nil {
+		return nil, settingError("MEDIA_MEMORY_LIMIT", "%w", err)
+	}
+
+	statsRetention, err := e.getEnvDuration("MEDIA_STATS_RETENTION", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	licenseExpiryAction := e.getEnv("MEDIA_LICENSE_EXPIRY_ACTION", "block")
+	if !slices.Contains(LicenseExpiryActions, licenseExpiryAction) {
+		return nil, settingError("MEDIA_LICENSE_EXPIRY_ACTION", "%q, expected one of %s", licenseExpiryAction, strings.Join(LicenseExpiryActions, ", "))
+	}
+	licenseReportWindow, err := e.getEnvDuration("MEDIA_LICENSE_REPORT_WINDOW", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	availabilityInterval, err := e.getEnvDuration("MEDIA_AVAILABILITY_CHECK_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryInterval, err := e.getEnvDuration("MEDIA_EXPIRY_CHECK_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	maxTTL, err := e.getEnvDuration("MEDIA_MAX_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+	directoryTTLs := make(map[string]time.Duration)
+	for _, item := range e.getEnvList("MEDIA_DIRECTORY_TTLS") {
+		directory, value, ok := strings.Cut(item, "=")
+		directory = strings.TrimSpace(directory)
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || directory == "" || err != nil || ttl <= 0 {
+			return nil, settingError("MEDIA_DIRECTORY_TTLS", "%q, expected directory=duration", item)
+		}
+		directoryTTLs[directory] = ttl
+	}
+
+	retentionInterval, err := e.getEnvDuration("MEDIA_RETENTION_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	retentionRules := make(map[string]time.Duration)
+	for _, item := range e.getEnvList("MEDIA_RETENTION_RULES") {
+		directory, value, ok := strings.Cut(item, "=")
+		directory = strings.TrimSuffix(strings.TrimSpace(directory), "/")
+		value = strings.TrimSpace(value)
+		keep := time.Duration(-1)
+		if value != "forever" {
+			keep, err = time.ParseDuration(value)
+			if err != nil || keep <= 0 {
+				ok = false
+			}
+		}
+		if !ok || directory == "" {
+			return nil, settingError("MEDIA_RETENTION_RULES", "%q, expected directory=duration or directory=forever", item)
+		}
+		retentionRules[directory] = keep
+	}
+
+	orgMaxBytes, err := strconv.ParseInt(e.getEnv("MEDIA_ORG_MAX_BYTES", "0"), 10, 64)
+	if err != nil || orgMaxBytes < 0 {
+		return nil, settingError("MEDIA_ORG_MAX_BYTES", "must be a non-negative integer")
+	}
+	orgMaxFiles, err := strconv.ParseInt(e.getEnv("MEDIA_ORG_MAX_FILES", "0"), 10, 64)
+	if err != nil || orgMaxFiles < 0 {
+		return nil, settingError("MEDIA_ORG_MAX_FILES", "must be a non-negative integer")
+	}
+	var quotaWarnAt []int
+	for _, item := range e.getEnvList("MEDIA_QUOTA_WARN_PERCENTS") {
+		percent, err := strconv.Atoi(item)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, settingError("MEDIA_QUOTA_WARN_PERCENTS", "%q is not a percentage between 1 and 100", item)
+		}
+		quotaWarnAt = append(quotaWarnAt, percent)
+	}
+	if e("MEDIA_QUOTA_WARN_PERCENTS") == "" {
+		quotaWarnAt = []int{80, 95}
+	}
+	quotaGrace, err := e.getEnvInt("MEDIA_QUOTA_GRACE_PERCENT", 0)
+	if err != nil || quotaGrace < 0 || quotaGrace > 100 {
+		return nil, settingError("MEDIA_QUOTA_GRACE_PERCENT", "must be a percentage between 0 and 100")
+	}
+	scanSyncMaxSize, err := strconv.ParseInt(e.getEnv("MEDIA_SCAN_SYNC_MAX_SIZE", "10485760"), 10, 64)
+	if err != nil || scanSyncMaxSize < 0 {
+		return nil, settingError("MEDIA_SCAN_SYNC_MAX_SIZE", "must be a non-negative integer")
+	}
+	scanTimeout, err := e.getEnvDuration("MEDIA_SCAN_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if scanTimeout <= 0 {
+		return nil, settingError("MEDIA_SCAN_TIMEOUT", "must be positive")
+	}
+	validationCacheTTL, err := e.getEnvDuration("MEDIA_VALIDATION_CACHE_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if validationCacheTTL < 0 {
+		return nil, settingError("MEDIA_VALIDATION_CACHE_TTL", "must not be negative")
+	}
+	qcPolicy := e.getEnv("MEDIA_QC_POLICY", "flag")
+	if !slices.Contains(QCPolicies, qcPolicy) {
+		return nil, settingError("MEDIA_QC_POLICY", "%q, expected one of %s", qcPolicy, strings.Join(QCPolicies, ", "))
+	}
+	qcMaxSilence, err := e.getEnvDuration("MEDIA_QC_MAX_SILENCE", 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if qcMaxSilence <= 0 {
+		return nil, settingError("MEDIA_QC_MAX_SILENCE", "must be positive")
+	}
+	qcSilenceNoise, err := e.getEnvFloat("MEDIA_QC_SILENCE_NOISE", -50)
+	if err != nil {
+		return nil, err
+	}
+	if qcSilenceNoise >= 0 {
+		return nil, settingError("MEDIA_QC_SILENCE_NOISE", "must be negative")
+	}
+	qcMaxBlack, err := e.getEnvDuration("MEDIA_QC_MAX_BLACK", 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if qcMaxBlack <= 0 {
+		return nil, settingError("MEDIA_QC_MAX_BLACK", "must be positive")
+	}
+	feedCacheTTL, err := e.getEnvDuration("MEDIA_FEED_CACHE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	offboardingGrace, err := e.getEnvDuration("MEDIA_OFFBOARDING_GRACE", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if offboardingGrace <= 0 {
+		return nil, settingError("MEDIA_OFFBOARDING_GRACE", "must be positive")
+	}
+	defaultVisibility := e.getEnv("MEDIA_DEFAULT_VISIBILITY", "public")
+	if !slices.Contains(Visibilities, defaultVisibility) {
+		return nil, settingError("MEDIA_DEFAULT_VISIBILITY", "expected one of %s", strings.Join(Visibilities, ", "))
+	}
+	downloadURLTTL, err := e.getEnvDuration("MEDIA_DOWNLOAD_URL_TTL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if downloadURLTTL <= 0 {
+		return nil, settingError("MEDIA_DOWNLOAD_URL_TTL", "must be positive")
+	}
+	plansByName := make(map[string]plans.Plan)
+	for _, name := range e.getEnvList("MEDIA_PLANS") {
+		plan, err := e.getEnvPlan(name)
+		if err != nil {
+			return nil, err
+		}
+		plansByName[name] = plan
+	}
+	defaultPlan := e.getEnv("MEDIA_DEFAULT_PLAN", "")
+	if _, ok := plansByName[defaultPlan]; defaultPlan != "" && !ok {
+		return nil, settingError("MEDIA_DEFAULT_PLAN", "%q is not in MEDIA_PLANS", defaultPlan)
+	}
+	entitlementsCacheTTL, err := e.getEnvDuration("MEDIA_ENTITLEMENTS_CACHE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	orgSettings := OrgSettings
+	if e("MEDIA_ORG_SETTINGS") != "" {
+		orgSettings = nil
+		for _, setting := range e.getEnvList("MEDIA_ORG_SETTINGS") {
+			if setting == "none" {
+				continue
+			}
+			if !slices.Contains(OrgSettings, setting) {
+				return nil, settingError("MEDIA_ORG_SETTINGS", "unknown setting %q, expected one of %s", setting, strings.Join(OrgSettings, ", "))
+			}
+			orgSettings = append(orgSettings, setting)
+		}
+	}
+
+	var cdnEdges []*url.URL
+	for _, item := range e.getEnvList("MEDIA_CDN_EDGES") {
+		edge, err := url.Parse(item)
+		if err != nil || (edge.Scheme != "http" && edge.Scheme != "https") || edge.Host == "" {
+			return nil, settingError("MEDIA_CDN_EDGES", "%q, expected https://host", item)
+		}
+		cdnEdges = append(cdnEdges, edge)
+	}
+	prewarmConcurrency, err := e.getEnvInt("MEDIA_CDN_PREWARM_CONCURRENCY", 8)
+	if err != nil {
+		return nil, err
+	}
+	if prewarmConcurrency < 1 {
+		return nil, settingError("MEDIA_CDN_PREWARM_CONCURRENCY", "must be at least 1")
+	}
+
+	regionName := e.getEnv("MEDIA_REGION", "")
+	regionPeers := make(map[string]*url.URL)
+	for _, item := range e.getEnvList("MEDIA_REGION_PEERS") {
+		name, base, ok := strings.Cut(item, "=")
+		name, base = strings.TrimSpace(name), strings.TrimSpace(base)
+		peer, err := url.Parse(base)
+		if !ok || name == "" || err != nil || (peer.Scheme != "http" && peer.Scheme != "https") || peer.Host == "" {
+			return nil, settingError("MEDIA_REGION_PEERS", "%q, expected region=https://host", item)
+		}
+		if name == regionName {
+			return nil, settingError("MEDIA_REGION_PEERS", "%q is the region of this deployment", name)
+		}
+		regionPeers[name] = peer
+	}
+	if len(regionPeers) > 0 && regionName == "" {
+		return nil, settingError("MEDIA_REGION_PEERS", "requires MEDIA_REGION")
+	}
+	regionMode := e.getEnv("MEDIA_REGION_MODE", region.ModeRedirect)
+	if !slices.Contains(region.Modes, regionMode) {
+		return nil, settingError("MEDIA_REGION_MODE", "%q, expected one of %s", regionMode, strings.Join(region.Modes, ", "))
+	}
+
+	replicationEnabled, err := e.getEnvBool("MEDIA_REPLICATION", false)
+	if err != nil {
+		return nil, err
+	}
+	replicationSecret := e.getEnv("MEDIA_REPLICATION_SECRET", "")
+	if replicationEnabled && (regionName == "" || len(regionPeers) == 0) {
+		return nil, settingError("MEDIA_REPLICATION", "requires MEDIA_REGION and MEDIA_REGION_PEERS")
+	}
+	if replicationEnabled && replicationSecret == "" {
+		return nil, settingError("MEDIA_REPLICATION", "requires MEDIA_REPLICATION_SECRET")
+	}
+	replicationConflicts := e.getEnv("MEDIA_REPLICATION_CONFLICTS", replication.LastWriterWins)
+	if !slices.Contains(replication.ConflictRules, replicationConflicts) {
+		return nil, settingError("MEDIA_REPLICATION_CONFLICTS", "%q, expected one of %s", replicationConflicts, strings.Join(replication.ConflictRules, ", "))
+	}
+	replicationInterval, err := e.getEnvDuration("MEDIA_REPLICATION_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if replicationInterval <= 0 {
+		return nil, settingError("MEDIA_REPLICATION_INTERVAL", "must be positive")
+	}
+	replicationMaxLag, err := e.getEnvDuration("MEDIA_REPLICATION_MAX_LAG", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	// The intervals above are the default schedules of their tasks.
+	cronSchedules := make(map[string]cron.Schedule)
+	for task, interval := range map[string]time.Duration{
+		"audit":        auditInterval,
+		"scrub":        scrubInterval,
+		"gc":           gcInterval,
+		"availability": availabilityInterval,
+		"expiry":       expiryInterval,
+		"retention":    retentionInterval,
+		"offboarding":  time.Hour,
+	} {
+		schedule, err := e.getEnvSchedule("MEDIA_CRON_"+strings.ToUpper(task), interval)
+		if err != nil {
+			return nil, err
+		}
+		if schedule != nil {
+			cronSchedules[task] = schedule
+		}
+	}
+
+	tracingEnabled := (e("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || e("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "") &&
+		!strings.EqualFold(e("OTEL_SDK_DISABLED"), "true")
+
+	return &Config{
+		HTTPAddr:      httpAddr,
+		TLS:           tlsCfg,
+		GRPCAddr:      e.getEnv("MEDIA_GRPC_ADDR", ""),
+		StorageDir:    storageDir,
+		Directories:   e.getEnvList("MEDIA_STORAGE_DIRECTORIES"),
+		MetadataDir:   e.getEnv("MEDIA_METADATA_DIR", filepath.Join(storageDir, ".metadata")),
+		PublicBaseURL: publicBaseURL,
+		MaxFileSize:   maxFileSize,
+		Video: VideoConfig{
+			MaxSize: maxVideoSize,
+			Types:   videoTypes,
+
+			FFmpegPath:       e.getEnv("MEDIA_FFMPEG_PATH", "ffmpeg"),
+			TranscodeHeights: transcodeHeights,
+			TranscodeWorkers: transcodeWorkers,
+			TranscodeThreads: transcodeThreads,
+
+			PosterOffset: posterOffset,
+
+			TeaserFormat: teaserFormat,
+			TeaserStart:  teaserStart,
+			TeaserLength: teaserLength,
+			TeaserWidth:  teaserWidth,
+			TeaserFPS:    teaserFPS,
+
+			HLSSegmentDuration: hlsSegmentDuration,
+			StreamSigningKey:   e.getEnv("MEDIA_STREAM_SIGNING_KEY", ""),
+			StreamURLTTL:       streamURLTTL,
+		},
+		Audio: AudioConfig{
+			MaxSize:        maxAudioSize,
+			Types:          audioTypes,
+			WaveformPoints: waveformPoints,
+
+			Normalize:      normalizeAudio,
+			TargetLUFS:     targetLUFS,
+			TargetTruePeak: targetTruePeak,
+			TargetRange:    targetRange,
+		},
+		Processing: ProcessingConfig{
+			MaxMemory: processingMemory,
+			MaxCPU:    processingCPU,
+			MaxWait:   processingWait,
+			MaxQueued: processingQueued,
+
+			DegradedJobDepth:       degradedJobDepth,
+			DegradedTranscodeDepth: degradedTranscodeDepth,
+			DegradedMaxDimension:   degradedMaxDimension,
+		},
+		Jobs: JobsConfig{
+			Workers:     jobWorkers,
+			MaxAttempts: jobMaxAttempts,
+			Backoff:     jobBackoff,
+			MaxBackoff:  jobMaxBackoff,
+			Retention:   jobRetention,
+
+			Priorities:     jobPriorities,
+			Tiers:          orgTiers,
+			TierPriorities: jobTierPriorities,
+			Aging:          jobAging,
+
+			Worker:    jobWorker,
+			Heartbeat: jobHeartbeat,
+		},
+		Runtime: RuntimeConfig{
+			MaxProcs:    maxProcs,
+			GCPercent:   gcPercent,
+			MemoryLimit: memoryLimit,
+		},
+		ReviewUploads: reviewUploads,
+		MaxVersions:   maxVersions,
+		Auth: AuthConfig{
+			JWKSUrl:      e.getEnv("AUTH_JWKS_URL", "http://user-service:3000/.well-known/jwks.json"),
+			Issuer:       e.getEnv("AUTH_ISSUER", "http://user-service:3000"),
+			Audience:     e.getEnv("AUTH_AUDIENCE", "backboard"),
+			JWKSCacheTTL: jwksCacheTTL,
+			Algorithms:   authAlgorithms,
+
+			Leeway:            time.Duration(authLeeway) * time.Second,
+			RequireExpiration: authRequireExp,
+			RequireNotBefore:  authRequireNbf,
+			ScopePermissions:  scopePermissions,
+			AdminScopes:       e.getEnvList("AUTH_ADMIN_SCOPES"),
+			AdminRole:         e.getEnv("AUTH_ADMIN_ROLE", ""),
+
+			Introspection: IntrospectionConfig{
+				URL:          introspectionURL,
+				ClientID:     e.getEnv("AUTH_INTROSPECTION_CLIENT_ID", ""),
+				ClientSecret: e.getEnv("AUTH_INTROSPECTION_CLIENT_SECRET", ""),
+				CacheTTL:     time.Duration(introspectionCache) * time.Second,
+				FailOpen:     introspectionFailOpen,
+			},
+		},
+		Errors: ErrorsConfig{
+			TypeBaseURL: e.getEnv("MEDIA_PROBLEM_TYPE_BASE_URL", publicBaseURL+"/problems"),
+			Legacy:      legacyErrors,
+		},
+		API: APIConfig{
+			LegacySunset:   legacySunset,
+			DisabledRoutes: disabledRoutes,
+			SwaggerUI:      swaggerUI,
+			Debug:          debugRoutes,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   corsOrigins,
+			AllowedMethods:   corsMethods,
+			AllowedHeaders:   corsHeaders,
+			ExposedHeaders:   corsExposed,
+			MaxAge:           corsMaxAge,
+			AllowCredentials: corsCredentials,
+		},
+		Imaging: ImagingConfig{
+			StripMetadata:          stripMetadata,
+			PreserveOrientation:    preserveOrientation,
+			VerifyDecode:           verifyDecode,
+			ExtractColors:          extractColors,
+			CWebPPath:              e.getEnv("MEDIA_CWEBP_PATH", "cwebp"),
+			AVIFEncPath:            e.getEnv("MEDIA_AVIFENC_PATH", "avifenc"),
+			PDFToPPMPath:           e.getEnv("MEDIA_PDFTOPPM_PATH", "pdftoppm"),
+			PreviewWidth:           previewWidth,
+			UploadSources:          uploadSources,
+			CompressQuality:        compressQuality,
+			TesseractPath:          e.getEnv("MEDIA_TESSERACT_PATH", "tesseract"),
+			OCRLanguages:           e.getEnv("MEDIA_OCR_LANGUAGES", "eng"),
+			ProgressiveCollections: e.getEnvList("MEDIA_PROGRESSIVE_COLLECTIONS"),
+			JPEGTranPath:           e.getEnv("MEDIA_JPEGTRAN_PATH", "jpegtran"),
+			OptiPNGPath:            e.getEnv("MEDIA_OPTIPNG_PATH", "optipng"),
+			WebPQuality:            webpQuality,
+			AVIFQuality:            avifQuality,
+			MaxWidth:               maxWidth,
+			MaxHeight:              maxHeight,
+			MaxMegapixels:          maxMegapixels,
+			MaxGIFFrames:           maxGIFFrames,
+			MaxGIFMegapixels:       maxGIFMegapixels,
+			AvatarSizes:            avatarSizes,
+			AvatarHistory:          avatarHistory,
+			Backend:                e.getEnv("MEDIA_IMAGING_BACKEND", "go"),
+			AltTextCollections:     e.getEnvList("MEDIA_ALT_TEXT_COLLECTIONS"),
+			Watermark: WatermarkConfig{
+				Path:        e.getEnv("MEDIA_WATERMARK_PATH", ""),
+				Position:    e.getEnv("MEDIA_WATERMARK_POSITION", "bottom-right"),
+				Opacity:     watermarkOpacity,
+				Directories: e.getEnvList("MEDIA_WATERMARK_DIRECTORIES"),
+			},
+		},
+		Webhook: WebhookConfig{
+			URL:    webhookURL,
+			Secret: e.getEnv("MEDIA_WEBHOOK_SECRET", ""),
+		},
+		Events: EventsConfig{
+			NATSURL:       e.getEnv("MEDIA_EVENTS_NATS_URL", ""),
+			Stream:        e.getEnv("MEDIA_EVENTS_STREAM", ""),
+			SubjectPrefix: e.getEnv("MEDIA_EVENTS_SUBJECT_PREFIX", ""),
+		},
+		Integrity: IntegrityConfig{
+			ManifestSigningKey: e.getEnv("MEDIA_MANIFEST_SIGNING_KEY", ""),
+			ScrubRate:          scrubRate,
+			GCMinAge:           gcMinAge,
+			GCDryRun:           gcDryRun,
+			ReplicaDir:         e.getEnv("MEDIA_REPLICA_DIR", ""),
+			ChecksumAlgorithms: e.getEnvList("MEDIA_CHECKSUM_ALGORITHMS"),
+		},
+		Widget: WidgetConfig{
+			Secret:        e.getEnv("MEDIA_WIDGET_SECRET", ""),
+			RatePerMinute: widgetRate,
+			Burst:         widgetBurst,
+			MaxTokenTTL:   widgetTokenTTL,
+		},
+		Stats: StatsConfig{
+			Retention: statsRetention,
+		},
+		Licenses: LicensesConfig{
+			ExpiryAction: licenseExpiryAction,
+			ReportWindow: licenseReportWindow,
+		},
+		Expiry: ExpiryConfig{
+			MaxTTL:        maxTTL,
+			DirectoryTTLs: directoryTTLs,
+		},
+		Retention: RetentionConfig{
+			Rules: retentionRules,
+		},
+		Feeds: FeedsConfig{
+			CacheTTL: feedCacheTTL,
+		},
+		Cron: CronConfig{
+			Schedules: cronSchedules,
+		},
+		Quotas: QuotasConfig{
+			MaxBytes: orgMaxBytes,
+			MaxFiles: orgMaxFiles,
+			WarnAt:   quotaWarnAt,
+			Grace:    quotaGrace,
+		},
+		Orgs: OrgsConfig{
+			OffboardingGrace: offboardingGrace,
+			Settings:         orgSettings,
+		},
+		Plans: PlansConfig{
+			Plans:                plansByName,
+			Default:              defaultPlan,
+			EntitlementsURL:      e.getEnv("MEDIA_ENTITLEMENTS_URL", ""),
+			EntitlementsCacheTTL: entitlementsCacheTTL,
+		},
+		Visibility: VisibilityConfig{
+			Default:    defaultVisibility,
+			SigningKey: e.getEnv("MEDIA_DOWNLOAD_SIGNING_KEY", ""),
+			URLTTL:     downloadURLTTL,
+		},
+		Scan: ScanConfig{
+			ClamdAddress: e.getEnv("MEDIA_CLAMD_ADDRESS", ""),
+			SyncMaxSize:  scanSyncMaxSize,
+			Timeout:      scanTimeout,
+			CacheTTL:     validationCacheTTL,
+		},
+		QC: QCConfig{
+			Collections:  e.getEnvList("MEDIA_QC_COLLECTIONS"),
+			Policy:       qcPolicy,
+			MaxSilence:   qcMaxSilence,
+			SilenceNoise: qcSilenceNoise,
+			MaxBlack:     qcMaxBlack,
+		},
+		Geo: GeoConfig{
+			DatabasePath:  e.getEnv("MEDIA_GEOIP_DATABASE", ""),
+			CountryHeader: e.getEnv("MEDIA_GEOIP_COUNTRY_HEADER", ""),
+		},
+		CDN: CDNConfig{
+			Edges:              cdnEdges,
+			PrefetchURL:        e.getEnv("MEDIA_CDN_PREFETCH_URL", ""),
+			PrefetchToken:      e.getEnv("MEDIA_CDN_PREFETCH_TOKEN", ""),
+			PrewarmConcurrency: prewarmConcurrency,
+		},
+		Region: RegionConfig{
+			Name:  regionName,
+			Peers: regionPeers,
+			Mode:  regionMode,
+		},
+		Replication: ReplicationConfig{
+			Enabled:   replicationEnabled,
+			Secret:    replicationSecret,
+			Conflicts: replicationConflicts,
+			Interval:  replicationInterval,
+			MaxLag:    replicationMaxLag,
+		},
+		Tracing: TracingConfig{
+			Enabled:     tracingEnabled,
+			ServiceName: e.getEnv("OTEL_SERVICE_NAME", "media-service"),
+		},
+		Metrics: MetricsConfig{
+			PushURL: metricsPushURL,
+		},
+	}, nil
+}
+
+// SettingError reports an invalid value of a setting.
+type SettingError struct {
+	Key string
+	Err error
+}
+
+func (e *SettingError) Error() string {
+	return "invalid " + e.Key + ": " + e.Err.Error()
+}
+
+func (e *SettingError) Unwrap() error {
+	return e.Err
+}
+
+func settingError(key, format string, args ...any) error {
+	return &SettingError{Key: key, Err: fmt.Errorf(format, args...)}
+}
+
+// env looks up the value of a setting by its name; "" when unset.
+type env func(key string) string
+
+func (e env) getEnv(key, defaultValue string) string {
+	if value := e(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (e env) getEnvInt(key string, defaultValue int) (int, error) {
+	value := e(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, settingError(key, "%w", err)
+	}
+	return n, nil
+}
+
+func (e env) getEnvBool(key string, defaultValue bool) (bool, error) {
+	value := e(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, settingError(key, "%w", err)
+	}
+	return b, nil
+}
+
+func (e env) getEnvFloat(key string, defaultValue float64) (float64, error) {
+	value := e(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, settingError(key, "%w", err)
+	}
+	return f, nil
+}
+
+// getEnvList splits a comma-separated variable, dropping empty items.
+func (e env) getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(e(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvPriorities parses a list of name=priority pairs.
+func (e env) getEnvPriorities(key, name string) (map[string]int, error) {
+	priorities := make(map[string]int)
+	for _, item := range e.getEnvList(key) {
+		k, value, ok := strings.Cut(item, "=")
+		k = strings.TrimSpace(k)
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || k == "" || err != nil {
+			return nil, settingError(key, "%q, expected %s=priority", item, name)
+		}
+		priorities[k] = priority
+	}
+	return priorities, nil
+}
+
+// getEnvPlan parses the plan name from MEDIA_PLAN_<NAME>, a list of
+// maxBytes, maxFiles, maxFileSize, features, rate and burst settings such
+// as "maxBytes=1073741824,features=video ocr,rate=600". Features are
+// separated by spaces.
+func (e env) getEnvPlan(name string) (plans.Plan, error) {
+	key := "MEDIA_PLAN_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	plan := plans.Plan{Name: name}
+	for _, item := range e.getEnvList(key) {
+		k, value, ok := strings.Cut(item, "=")
+		k, value = strings.TrimSpace(k), strings.TrimSpace(value)
+		if !ok {
+			return plans.Plan{}, settingError(key, "%q, expected setting=value", item)
+		}
+		var err error
+		switch k {
+		case "maxBytes":
+			plan.MaxBytes, err = strconv.ParseInt(value, 10, 64)
+		case "maxFiles":
+			plan.MaxFiles, err = strconv.ParseInt(value, 10, 64)
+		case "maxFileSize":
+			plan.MaxFileSize, err = strconv.ParseInt(value, 10, 64)
+		case "rate":
+			plan.RatePerMinute, err = strconv.Atoi(value)
+		case "burst":
+			plan.Burst, err = strconv.Atoi(value)
+		case "features":
+			plan.Features = []string{}
+			for _, feature := range strings.Fields(value) {
+				if !slices.Contains(plans.Features, feature) {
+					return plans.Plan{}, settingError(key, "unknown feature %q, expected one of %s", feature, strings.Join(plans.Features, ", "))
+				}
+				plan.Features = append(plan.Features, feature)
+			}
+		default:
+			return plans.Plan{}, settingError(key, "unknown setting %q", k)
+		}
+		if err != nil {
+			return plans.Plan{}, settingError(key, "%s: %w", k, err)
+		}
+	}
+	return plan, nil
+}
+
+// getEnvSchedule parses a cron schedule, or "off". Unset, the task runs
+// every defaultInterval, or not at all if that is zero.
+func (e env) getEnvSchedule(key string, defaultInterval time.Duration) (cron.Schedule, error) {
+	value := strings.TrimSpace(e(key))
+	switch {
+	case value == "" && defaultInterval > 0:
+		return cron.Every(defaultInterval), nil
+	case value == "" || value == "off":
+		return nil, nil
+	}
+	schedule, err := cron.Parse(value)
+	if err != nil {
+		return nil, settingError(key, "%w", err)
+	}
+	return schedule, nil
+}
+
+func (e env) getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := e(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, settingError(key, "%w", err)
+	}
+	return d, nil
 }
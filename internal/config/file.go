@@ -0,0 +1,215 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the configuration from the environment, and from the config
+// file MEDIA_CONFIG_FILE names, if any; see LoadFile.
+func Load() (*Config, error) {
+	return LoadFile(os.Getenv("MEDIA_CONFIG_FILE"))
+}
+
+// LoadFile reads the configuration from a YAML or TOML file, by its
+// extension, and the environment, which overrides the file; an empty path
+// reads the environment only. The file holds the same settings as the
+// environment, either by name or nested by the parts of their name:
+//
+//	media:
+//	  http_addr: ":8443"
+//	  cors:
+//	    allowed_origins: [https://app.example.com]
+//	AUTH_JWKS_URL: https://auth.example.com/.well-known/jwks.json
+//
+// Lists are joined with commas. OTEL_* settings of the file are kept in
+// Tracing.Env for the OpenTelemetry SDK, which reads only the environment.
+// Settings the service does not know are refused, as are invalid values.
+func LoadFile(path string) (*Config, error) {
+	var settings map[string]string
+	var overridden []string
+	if path != "" {
+		var err error
+		if settings, err = readFile(path); err != nil {
+			return nil, err
+		}
+		var unknown []string
+		for _, key := range slices.Sorted(maps.Keys(settings)) {
+			if !knownSetting(key) {
+				unknown = append(unknown, key)
+			}
+			if os.Getenv(key) != "" {
+				overridden = append(overridden, key)
+			}
+		}
+		if len(unknown) > 0 {
+			return nil, unknownSettingsError(path, unknown)
+		}
+	}
+
+	cfg, err := load(func(key string) string {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return settings[key]
+	})
+	if err != nil {
+		var settingErr *SettingError
+		if errors.As(err, &settingErr) && settings[settingErr.Key] != "" && !slices.Contains(overridden, settingErr.Key) {
+			return nil, fmt.Errorf("%w (set in %s)", err, path)
+		}
+		return nil, err
+	}
+
+	for key, value := range settings {
+		if strings.HasPrefix(key, "OTEL_") && !slices.Contains(overridden, key) {
+			if cfg.Tracing.Env == nil {
+				cfg.Tracing.Env = make(map[string]string)
+			}
+			cfg.Tracing.Env[key] = value
+		}
+	}
+	cfg.File, cfg.Overridden = path, overridden
+	return cfg, nil
+}
+
+// readFile reads the settings of a config file by the names of the
+// environment variables they stand for.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("invalid config file %s: unknown format %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	if err := flatten("", tree, settings); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// flatten adds the settings of tree to settings, naming nested ones by
+// their path: media.http_addr is MEDIA_HTTP_ADDR.
+func flatten(prefix string, tree map[string]any, settings map[string]string) error {
+	for name, v := range tree {
+		key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		if nested, ok := v.(map[string]any); ok {
+			if err := flatten(key, nested, settings); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := settingValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if _, ok := settings[key]; ok {
+			return fmt.Errorf("%s is set more than once", key)
+		}
+		settings[key] = value
+	}
+	return nil
+}
+
+func settingValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := item.([]any); nested {
+				return "", errors.New("lists cannot be nested")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v, expected a string, number, boolean or list", v)
+}
+
+// unknownSettingsError lists settings of a config file the service does
+// not know, suggesting the known ones they are closest to.
+func unknownSettingsError(path string, unknown []string) error {
+	items := make([]string, 0, len(unknown))
+	for _, key := range unknown {
+		item := key
+		if suggestion := closestSetting(key); suggestion != "" {
+			item += " (did you mean " + suggestion + "?)"
+		}
+		items = append(items, item)
+	}
+	return fmt.Errorf("invalid config file %s: unknown settings %s", path, strings.Join(items, ", "))
+}
+
+// closestSetting returns the known setting closest to key, if it differs
+// by a few characters at most.
+func closestSetting(key string) string {
+	best, bestDistance := "", 4
+	for _, known := range slices.Sorted(maps.Keys(knownSettings)) {
+		if d := editDistance(key, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,217 @@
+package config
+
+import "strings"
+
+// knownSettings are the names of the settings the service reads, which config
+// files may set whether or not the features using them are on.
+var knownSettings = map[string]bool{
+	"AUTH_ADMIN_ROLE":                   true,
+	"AUTH_ADMIN_SCOPES":                 true,
+	"AUTH_ALGORITHMS":                   true,
+	"AUTH_AUDIENCE":                     true,
+	"AUTH_INTROSPECTION_CACHE_SECONDS":  true,
+	"AUTH_INTROSPECTION_CLIENT_ID":      true,
+	"AUTH_INTROSPECTION_CLIENT_SECRET":  true,
+	"AUTH_INTROSPECTION_FAIL_OPEN":      true,
+	"AUTH_INTROSPECTION_URL":            true,
+	"AUTH_ISSUER":                       true,
+	"AUTH_JWKS_CACHE_TTL":               true,
+	"AUTH_JWKS_URL":                     true,
+	"AUTH_LEEWAY_SECONDS":               true,
+	"AUTH_REQUIRE_EXP":                  true,
+	"AUTH_REQUIRE_NBF":                  true,
+	"AUTH_SCOPE_PERMISSIONS":            true,
+	"MEDIA_ALT_TEXT_COLLECTIONS":        true,
+	"MEDIA_AUDIO_NORMALIZE":             true,
+	"MEDIA_AUDIO_TARGET_LRA":            true,
+	"MEDIA_AUDIO_TARGET_LUFS":           true,
+	"MEDIA_AUDIO_TARGET_TRUE_PEAK":      true,
+	"MEDIA_AUDIO_TYPES":                 true,
+	"MEDIA_AVAILABILITY_CHECK_INTERVAL": true,
+	"MEDIA_AVATAR_HISTORY":              true,
+	"MEDIA_AVATAR_PIPELINE":             true,
+	"MEDIA_AVATAR_SIZES":                true,
+	"MEDIA_AVIFENC_PATH":                true,
+	"MEDIA_AVIF_QUALITY":                true,
+	"MEDIA_CDN_EDGES":                   true,
+	"MEDIA_CDN_PREFETCH_TOKEN":          true,
+	"MEDIA_CDN_PREFETCH_URL":            true,
+	"MEDIA_CDN_PREWARM_CONCURRENCY":     true,
+	"MEDIA_CHECKSUM_ALGORITHMS":         true,
+	"MEDIA_CLAMD_ADDRESS":               true,
+	"MEDIA_COMPRESS_JPEG_QUALITY":       true,
+	"MEDIA_CORS_ALLOWED_HEADERS":        true,
+	"MEDIA_CORS_ALLOWED_METHODS":        true,
+	"MEDIA_CORS_ALLOWED_ORIGINS":        true,
+	"MEDIA_CORS_ALLOW_CREDENTIALS":      true,
+	"MEDIA_CORS_EXPOSED_HEADERS":        true,
+	"MEDIA_CORS_MAX_AGE":                true,
+	"MEDIA_CRON_AUDIT":                  true,
+	"MEDIA_CRON_AVAILABILITY":           true,
+	"MEDIA_CRON_EXPIRY":                 true,
+	"MEDIA_CRON_GC":                     true,
+	"MEDIA_CRON_OFFBOARDING":            true,
+	"MEDIA_CRON_RETENTION":              true,
+	"MEDIA_CRON_SCRUB":                  true,
+	"MEDIA_CWEBP_PATH":                  true,
+	"MEDIA_DEBUG_ENDPOINTS":             true,
+	"MEDIA_DEFAULT_PLAN":                true,
+	"MEDIA_DEFAULT_VISIBILITY":          true,
+	"MEDIA_DEGRADED_JOB_DEPTH":          true,
+	"MEDIA_DEGRADED_MAX_DIMENSION":      true,
+	"MEDIA_DEGRADED_TRANSCODE_DEPTH":    true,
+	"MEDIA_DIRECTORY_TTLS":              true,
+	"MEDIA_DISABLED_ROUTES":             true,
+	"MEDIA_DOWNLOAD_SIGNING_KEY":        true,
+	"MEDIA_DOWNLOAD_URL_TTL":            true,
+	"MEDIA_ENTITLEMENTS_CACHE_TTL":      true,
+	"MEDIA_ENTITLEMENTS_URL":            true,
+	"MEDIA_EVENTS_NATS_URL":             true,
+	"MEDIA_EVENTS_STREAM":               true,
+	"MEDIA_EVENTS_SUBJECT_PREFIX":       true,
+	"MEDIA_EXPIRY_CHECK_INTERVAL":       true,
+	"MEDIA_EXTRACT_COLORS":              true,
+	"MEDIA_FEED_CACHE_TTL":              true,
+	"MEDIA_FFMPEG_PATH":                 true,
+	"MEDIA_GC_DRY_RUN":                  true,
+	"MEDIA_GC_INTERVAL":                 true,
+	"MEDIA_GC_MIN_AGE":                  true,
+	"MEDIA_GC_PERCENT":                  true,
+	"MEDIA_GEOIP_COUNTRY_HEADER":        true,
+	"MEDIA_GEOIP_DATABASE":              true,
+	"MEDIA_GOMAXPROCS":                  true,
+	"MEDIA_GRPC_ADDR":                   true,
+	"MEDIA_HLS_SEGMENT_DURATION":        true,
+	"MEDIA_HTTP_ADDR":                   true,
+	"MEDIA_IMAGING_BACKEND":             true,
+	"MEDIA_JOB_AFFINITY":                true,
+	"MEDIA_JOB_AGING":                   true,
+	"MEDIA_JOB_BACKOFF":                 true,
+	"MEDIA_JOB_HEARTBEAT":               true,
+	"MEDIA_JOB_MAX_ATTEMPTS":            true,
+	"MEDIA_JOB_MAX_BACKOFF":             true,
+	"MEDIA_JOB_PRIORITIES":              true,
+	"MEDIA_JOB_RETENTION":               true,
+	"MEDIA_JOB_TIER_PRIORITIES":         true,
+	"MEDIA_JOB_WORKERS":                 true,
+	"MEDIA_JOB_WORKER_ID":               true,
+	"MEDIA_JPEGTRAN_PATH":               true,
+	"MEDIA_LEGACY_API_SUNSET":           true,
+	"MEDIA_LEGACY_ERRORS":               true,
+	"MEDIA_LICENSE_EXPIRY_ACTION":       true,
+	"MEDIA_LICENSE_REPORT_WINDOW":       true,
+	"MEDIA_MANIFEST_AUDIT_INTERVAL":     true,
+	"MEDIA_MANIFEST_SIGNING_KEY":        true,
+	"MEDIA_MAX_AUDIO_SIZE":              true,
+	"MEDIA_MAX_FILE_SIZE":               true,
+	"MEDIA_MAX_FILE_VERSIONS":           true,
+	"MEDIA_MAX_GIF_FRAMES":              true,
+	"MEDIA_MAX_GIF_MEGAPIXELS":          true,
+	"MEDIA_MAX_IMAGE_HEIGHT":            true,
+	"MEDIA_MAX_IMAGE_MEGAPIXELS":        true,
+	"MEDIA_MAX_IMAGE_WIDTH":             true,
+	"MEDIA_MAX_TTL":                     true,
+	"MEDIA_MAX_VIDEO_SIZE":              true,
+	"MEDIA_MEMORY_LIMIT":                true,
+	"MEDIA_METADATA_DIR":                true,
+	"MEDIA_METRICS_PUSH_URL":            true,
+	"MEDIA_OCR_LANGUAGES":               true,
+	"MEDIA_OFFBOARDING_GRACE":           true,
+	"MEDIA_OPTIPNG_PATH":                true,
+	"MEDIA_ORG_MAX_BYTES":               true,
+	"MEDIA_ORG_MAX_FILES":               true,
+	"MEDIA_ORG_SETTINGS":                true,
+	"MEDIA_ORG_TIERS":                   true,
+	"MEDIA_PDFTOPPM_PATH":               true,
+	"MEDIA_PLANS":                       true,
+	"MEDIA_POSTER_OFFSET":               true,
+	"MEDIA_PRESERVE_ORIENTATION":        true,
+	"MEDIA_PREVIEW_WIDTH":               true,
+	"MEDIA_PROBLEM_TYPE_BASE_URL":       true,
+	"MEDIA_PROCESSING_CPU_LIMIT":        true,
+	"MEDIA_PROCESSING_MAX_QUEUED":       true,
+	"MEDIA_PROCESSING_MAX_WAIT":         true,
+	"MEDIA_PROCESSING_MEMORY_LIMIT":     true,
+	"MEDIA_PROGRESSIVE_COLLECTIONS":     true,
+	"MEDIA_PUBLIC_BASE_URL":             true,
+	"MEDIA_QC_COLLECTIONS":              true,
+	"MEDIA_QC_MAX_BLACK":                true,
+	"MEDIA_QC_MAX_SILENCE":              true,
+	"MEDIA_QC_POLICY":                   true,
+	"MEDIA_QC_SILENCE_NOISE":            true,
+	"MEDIA_QUOTA_GRACE_PERCENT":         true,
+	"MEDIA_QUOTA_WARN_PERCENTS":         true,
+	"MEDIA_REGION":                      true,
+	"MEDIA_REGION_MODE":                 true,
+	"MEDIA_REGION_PEERS":                true,
+	"MEDIA_REPLICATION":                 true,
+	"MEDIA_REPLICATION_CONFLICTS":       true,
+	"MEDIA_REPLICATION_INTERVAL":        true,
+	"MEDIA_REPLICATION_MAX_LAG":         true,
+	"MEDIA_REPLICATION_SECRET":          true,
+	"MEDIA_REPLICA_DIR":                 true,
+	"MEDIA_RETENTION_INTERVAL":          true,
+	"MEDIA_RETENTION_RULES":             true,
+	"MEDIA_REVIEW_UPLOADS":              true,
+	"MEDIA_SCAN_SYNC_MAX_SIZE":          true,
+	"MEDIA_SCAN_TIMEOUT":                true,
+	"MEDIA_SCRUB_INTERVAL":              true,
+	"MEDIA_SCRUB_RATE":                  true,
+	"MEDIA_STATS_RETENTION":             true,
+	"MEDIA_STORAGE_DIR":                 true,
+	"MEDIA_STORAGE_DIRECTORIES":         true,
+	"MEDIA_STREAM_SIGNING_KEY":          true,
+	"MEDIA_STREAM_URL_TTL":              true,
+	"MEDIA_STRIP_METADATA":              true,
+	"MEDIA_SWAGGER_UI":                  true,
+	"MEDIA_TESSERACT_PATH":              true,
+	"MEDIA_TLS_AUTOCERT":                true,
+	"MEDIA_TLS_AUTOCERT_CACHE_DIR":      true,
+	"MEDIA_TLS_AUTOCERT_DIRECTORY_URL":  true,
+	"MEDIA_TLS_AUTOCERT_EMAIL":          true,
+	"MEDIA_TLS_AUTOCERT_HOSTS":          true,
+	"MEDIA_TLS_CERT_FILE":               true,
+	"MEDIA_TLS_KEY_FILE":                true,
+	"MEDIA_TLS_REDIRECT_ADDR":           true,
+	"MEDIA_TRANSCODE_HEIGHTS":           true,
+	"MEDIA_TRANSCODE_THREADS":           true,
+	"MEDIA_TRANSCODE_WORKERS":           true,
+	"MEDIA_UPLOAD_SOURCE_PRESETS":       true,
+	"MEDIA_VALIDATION_CACHE_TTL":        true,
+	"MEDIA_VERIFY_IMAGE_DECODE":         true,
+	"MEDIA_VIDEO_TEASER_FORMAT":         true,
+	"MEDIA_VIDEO_TEASER_FPS":            true,
+	"MEDIA_VIDEO_TEASER_LENGTH":         true,
+	"MEDIA_VIDEO_TEASER_START":          true,
+	"MEDIA_VIDEO_TEASER_WIDTH":          true,
+	"MEDIA_VIDEO_TYPES":                 true,
+	"MEDIA_WATERMARK_DIRECTORIES":       true,
+	"MEDIA_WATERMARK_OPACITY":           true,
+	"MEDIA_WATERMARK_PATH":              true,
+	"MEDIA_WATERMARK_POSITION":          true,
+	"MEDIA_WAVEFORM_POINTS":             true,
+	"MEDIA_WEBHOOK_SECRET":              true,
+	"MEDIA_WEBHOOK_URL":                 true,
+	"MEDIA_WEBP_QUALITY":                true,
+	"MEDIA_WIDGET_BURST":                true,
+	"MEDIA_WIDGET_MAX_TOKEN_TTL":        true,
+	"MEDIA_WIDGET_RATE_PER_MINUTE":      true,
+	"MEDIA_WIDGET_SECRET":               true,
+}
+
+// settingPrefixes name settings by a prefix: plans by their name, and the
+// OpenTelemetry SDK, which reads its settings itself.
+var settingPrefixes = []string{"MEDIA_PLAN_", "OTEL_"}
+
+// knownSetting reports whether key names a setting of the service.
+func knownSetting(key string) bool {
+	if knownSettings[key] {
+		return true
+	}
+	for _, prefix := range settingPrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
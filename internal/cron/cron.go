@@ -0,0 +1,161 @@
+// Package cron runs the periodic maintenance tasks of the service on their
+// schedules and reports when they last and next run.
+package cron
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var (
+	runsTotal = metrics.NewCounter("media_cron_runs_total",
+		"Scheduled task runs by result: done, failed, or skipped while the previous run was still going.", "task", "result")
+	runDuration = metrics.NewHistogram("media_cron_duration_seconds",
+		"Time taken by scheduled task runs.",
+		[]float64{1, 10, 60, 300, 1800, 3600, 4 * 3600}, "task")
+)
+
+// Status reports the runs of a task.
+type Status struct {
+	Task           string     `json:"task"`
+	Schedule       string     `json:"schedule"`
+	Running        bool       `json:"running"`
+	NextRunAt      *time.Time `json:"nextRunAt,omitempty"`
+	LastStartedAt  *time.Time `json:"lastStartedAt,omitempty"`
+	LastFinishedAt *time.Time `json:"lastFinishedAt,omitempty"`
+	LastDuration   float64    `json:"lastDurationSeconds"`
+	LastError      string     `json:"lastError,omitempty"`
+	Runs           int        `json:"runs"`
+	Failures       int        `json:"failures"`
+	Skipped        int        `json:"skipped"` // Due while the previous run was still going
+}
+
+type task struct {
+	schedule Schedule
+	run      func(context.Context) error
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler runs tasks on their schedules. A task never overlaps itself: a
+// run due while the previous one is still going is skipped.
+type Scheduler struct {
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	tasks map[string]*task
+}
+
+func NewScheduler(logger *slog.Logger) *Scheduler {
+	return &Scheduler{logger: logger, tasks: make(map[string]*task)}
+}
+
+// Add schedules run under name. Tasks must be added before Run.
+func (s *Scheduler) Add(name string, schedule Schedule, run func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[name] = &task{
+		schedule: schedule,
+		run:      run,
+		status:   Status{Task: name, Schedule: schedule.String()},
+	}
+}
+
+// Run runs the tasks when due until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	var wg sync.WaitGroup
+	for _, t := range s.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, t)
+		}()
+	}
+	s.mu.Unlock()
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	for {
+		next := t.schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Warn("Scheduled task never due", "task", t.status.Task, "schedule", t.status.Schedule)
+			return
+		}
+		t.mu.Lock()
+		t.status.NextRunAt = &next
+		t.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.start(ctx, t)
+	}
+}
+
+// start runs t in the background unless its previous run is still going.
+func (s *Scheduler) start(ctx context.Context, t *task) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name := t.status.Task
+	if t.status.Running {
+		t.status.Skipped++
+		runsTotal.Inc(name, "skipped")
+		s.logger.Warn("Scheduled task still running, skipping run", "task", name, "startedAt", t.status.LastStartedAt)
+		return
+	}
+	started := time.Now().UTC()
+	t.status.Running = true
+	t.status.LastStartedAt = &started
+
+	go func() {
+		err := t.run(ctx)
+		finished := time.Now().UTC()
+		elapsed := finished.Sub(started)
+		runDuration.Observe(elapsed.Seconds(), name)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.status.Running = false
+		t.status.LastFinishedAt = &finished
+		t.status.LastDuration = elapsed.Seconds()
+		t.status.Runs++
+		t.status.LastError = ""
+		if err != nil {
+			t.status.Failures++
+			t.status.LastError = err.Error()
+			runsTotal.Inc(name, "failed")
+			if ctx.Err() == nil {
+				s.logger.Error("Scheduled task failed", "task", name, "duration", elapsed, "error", err)
+			}
+			return
+		}
+		runsTotal.Inc(name, "done")
+		s.logger.Debug("Scheduled task done", "task", name, "duration", elapsed)
+	}()
+}
+
+// Status reports the tasks by name.
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		t.mu.Lock()
+		statuses = append(statuses, t.status)
+		t.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Task < statuses[j].Task })
+	return statuses
+}
@@ -0,0 +1,175 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule yields the times a task is due.
+type Schedule interface {
+	// Next returns the first time the task is due after t.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Every returns a schedule due every d, counted from the previous run.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return "@every " + time.Duration(e).String()
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a schedule: a five-field cron expression (minute, hour, day
+// of month, month, day of week) evaluated in UTC, one of the descriptors
+// @hourly, @daily, @weekly, @monthly and @yearly, or @every followed by a
+// duration.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if value, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: @every takes a positive duration", spec)
+		}
+		return every(d), nil
+	}
+	expr := spec
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		if expr, ok = descriptors[spec]; !ok {
+			return nil, fmt.Errorf("invalid schedule %q: unknown descriptor", spec)
+		}
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	c := &cronSchedule{spec: spec}
+	for i, f := range []struct {
+		dst      *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	} {
+		bits, err := parseField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		*f.dst = bits
+	}
+	// Sunday is both 0 and 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+// parseField reads a comma-separated list of *, n and n-m, each optionally
+// followed by /step, into a bit set.
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", item)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", item)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+type cronSchedule struct {
+	spec                     string
+	minute, hour, dom, month uint64
+	dow                      uint64
+	domAny, dowAny           bool
+}
+
+// Next searches minute by minute, skipping whole months, days and hours
+// that cannot match. Expressions that never match, such as 0 0 30 2 *,
+// give up after five years and return the zero time.
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: when both day fields are restricted, a day
+// matching either is due.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cronSchedule) String() string {
+	return c.spec
+}
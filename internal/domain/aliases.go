@@ -0,0 +1,29 @@
+package domain
+
+import "time"
+
+// Alias keeps an old file ID resolving after the file was merged into
+// another one or migrated under a new ID. Requests for the alias are
+// redirected to TargetID.
+//
+// Named aliases are stable keys such as avatar:user-123 instead, repointed
+// to the latest file they stand for, so that clients need not keep track
+// of file IDs. Only their owner may repoint or delete them.
+type Alias struct {
+	ID        string
+	TargetID  string
+	Permanent bool // 301 instead of 302
+	Named     bool
+	Reason    string
+	CreatedBy string
+	CreatedAt time.Time
+
+	// Of named aliases.
+	OwnerID string
+	OrgID   string
+
+	// Set when a named alias is repointed.
+	PreviousTargetID string
+	UpdatedBy        string
+	UpdatedAt        time.Time
+}
@@ -0,0 +1,29 @@
+package domain
+
+import "time"
+
+// Annotation is a review comment attached to a file, optionally pointing
+// at a region of an image.
+type Annotation struct {
+	ID        string
+	FileID    string
+	AuthorID  string
+	Text      string
+	Region    *Region
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Region is a rectangle in coordinates normalized to the image size, so it
+// stays valid for any rendition of the image.
+type Region struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+func (r Region) Valid() bool {
+	return r.X >= 0 && r.Y >= 0 && r.Width > 0 && r.Height > 0 &&
+		r.X+r.Width <= 1 && r.Y+r.Height <= 1
+}
@@ -0,0 +1,49 @@
+package domain
+
+import "time"
+
+// AuditEntry records a change made to a file: what was done, by whom, when
+// and from where. Entries are never changed or removed once recorded.
+type AuditEntry struct {
+	ID       string
+	Action   string
+	FileID   string
+	ActorID  string // Empty for changes not made by a signed-in user, such as widget uploads
+	ClientIP string
+	Details  map[string]string
+	At       time.Time
+}
+
+// Audited actions.
+const (
+	AuditUpload  = "file.upload"
+	AuditReplace = "file.replace" // Content replaced, the old one kept as a version
+	AuditUpdate  = "file.update"  // Details changed, see AuditEntry.Details
+	AuditCopy    = "file.copy"    // Recorded for the copy
+	AuditMove    = "file.move"    // Moved to another storage directory
+	AuditDelete  = "file.delete"
+	AuditFlag    = "file.flag"
+	AuditApprove = "file.approve"
+	AuditReject  = "file.reject"
+
+	AuditTakedown      = "file.takedown"
+	AuditCounterNotice = "file.counter_notice"
+	AuditRestore       = "file.restore"
+
+	AuditAliasCreate      = "alias.create"
+	AuditAliasDelete      = "alias.delete"
+	AuditAliasSet         = "alias.set"
+	AuditShortLinkCreate  = "shortlink.create"
+	AuditShortLinkDelete  = "shortlink.delete"
+	AuditSlugSet          = "slug.set"
+	AuditSlugDelete       = "slug.delete"
+	AuditAnnotationCreate = "annotation.create"
+	AuditAnnotationUpdate = "annotation.update"
+	AuditAnnotationDelete = "annotation.delete"
+	AuditOrgSettings      = "org.settings" // Recorded without a file, see AuditEntry.Details
+
+	// Access of an administrator to files of other users or organizations,
+	// which only the admin role or permission allows. Details hold the kind
+	// of access: read, list (recorded without a file) or delete.
+	AuditAdminAccess = "file.admin_access"
+)
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// Branding is an organization's look, applied to the assets the service
+// generates for the organization's files. Logo is the ID of a stored PNG
+// that replaces the default watermark. Palette and Fonts are for generators
+// that render colors or text.
+type Branding struct {
+	OrgID        string
+	Logo         string
+	LogoPosition string
+	LogoOpacity  float64
+	Palette      []string // Hex colors, primary first
+	Fonts        []string // Font families, preferred first
+	UpdatedBy    string
+	UpdatedAt    time.Time
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// Feed publishes the public collections of an organization as a sitemap
+// and Atom and RSS feeds, so galleries are crawlable and subscribers learn
+// of new and updated assets. Organizations without an enabled feed publish
+// nothing.
+type Feed struct {
+	OrgID       string
+	Enabled     bool
+	Title       string
+	Collections []string
+	UpdatedBy   string
+	UpdatedAt   time.Time
+}
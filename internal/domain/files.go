@@ -1,6 +1,38 @@
 package domain
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+type FileStatus string
+
+const (
+	FileStatusActive      FileStatus = "active"
+	FileStatusQuarantined FileStatus = "quarantined"
+	FileStatusTakenDown   FileStatus = "taken_down"
+)
+
+// Availability is whether a file is within its availability window.
+type Availability string
+
+const (
+	FileAvailable Availability = "available"
+	FileEmbargoed Availability = "embargoed" // Before AvailableFrom
+	FileWithdrawn Availability = "withdrawn" // From AvailableUntil on
+)
+
+// Visibility is who a file is served to.
+type Visibility string
+
+const (
+	VisibilityPublic  Visibility = "public"  // Anyone, without credentials
+	VisibilityOrg     Visibility = "org"     // Callers of the organization owning the file
+	VisibilityPrivate Visibility = "private" // Its owner
+)
+
+// Visibilities are the visibilities files may have.
+var Visibilities = []Visibility{VisibilityPublic, VisibilityOrg, VisibilityPrivate}
 
 type FileMetadata struct {
 	ID           string
@@ -8,5 +40,369 @@ type FileMetadata struct {
 	ContentType  string
 	Size         int64
 	Path         string
+	Directory    string
+	Collection   string
+	Checksums    map[string]string
+	OwnerID      string
+	OrgID        string
+	Status       FileStatus
 	CreatedAt    time.Time
+
+	// Visibility is who the file is served to; empty for files uploaded
+	// before files had one, which are public. Signed URLs grant access to
+	// files of any visibility.
+	Visibility Visibility
+
+	// Region is the home region of the file, whose deployment stores its
+	// content; empty for files stored outside multi-region setups.
+	Region string
+
+	// Revision is the last write to the file when it is replicated
+	// between regions; nil for files never written with replication on.
+	Revision *Revision
+
+	// Source is the upload source the client declared, such as
+	// "mobile-camera" or "scanner", when it selected a processing preset.
+	Source string
+
+	// Provenance is how the file entered the service; nil for files
+	// stored before it was recorded.
+	Provenance *Provenance
+
+	// Set by the uploader for the pages showing the file: alt text and a
+	// longer description for people who cannot see it, and the credit and
+	// license to show wherever it is reused.
+	AltText     string
+	Description string
+	Credit      string
+	License     string
+
+	// Rights of licensed stock and partner media: who holds them and when
+	// the license to use the file runs out. License names the license.
+	RightsHolder     string
+	LicenseExpiresAt *time.Time
+
+	// Embargoed files are served from AvailableFrom on, time-limited ones
+	// until AvailableUntil. Availability is the state of the window at
+	// upload or as last announced in an event; empty for files that never
+	// had a window.
+	AvailableFrom  *time.Time
+	AvailableUntil *time.Time
+	Availability   Availability
+
+	// ExpiresAt is when temporary files, such as exports, are deleted; nil
+	// for files kept until deleted by hand.
+	ExpiresAt *time.Time
+
+	// Chapters divide video and audio into titled parts for players,
+	// ordered by start.
+	Chapters []Chapter
+
+	Image      *ImageMetadata
+	Video      *VideoMetadata
+	Audio      *AudioMetadata
+	Quarantine *Quarantine
+	Takedown   *Takedown
+
+	// Scan is the outcome of the malware scan of the file; nil when
+	// scanning is off.
+	Scan *Scan
+
+	// QC is the outcome of the quality checks of video and audio delivered
+	// to collections that are checked; nil for other files.
+	QC *QualityCheck
+
+	// Versions lists superseded contents of the file, oldest first. The
+	// live content is always the version after the last entry. Pruned
+	// versions leave gaps in the numbering.
+	Versions []FileVersion
+}
+
+// Channels files enter the service through.
+const (
+	ChannelHTTP        = "http"
+	ChannelWidget      = "widget"
+	ChannelGRPC        = "grpc"
+	ChannelCopy        = "copy"        // Copied from another file, see Provenance.From
+	ChannelReplication = "replication" // Received from a peer region, see Provenance.From
+)
+
+// Provenance records how a file entered the service: through which
+// channel, by whom, from what address and client, and with which of the
+// headers of the request. Replacing the content keeps it.
+type Provenance struct {
+	Channel  string
+	ActorID  string // Empty for uploads without a signed-in user, such as widget ones
+	ClientIP string
+	Client   string            // User agent of the HTTP client or gRPC peer
+	Headers  map[string]string // Headers of the upload request worth keeping, such as Origin and Referer
+	From     string            // The file copied, or the region replicated from
+	At       time.Time
+}
+
+// FileVersion is a previous content of a file, kept in storage as the
+// derivative named by VersionDerivative.
+type FileVersion struct {
+	Number       int
+	OriginalName string
+	ContentType  string
+	Size         int64
+	Checksums    map[string]string
+	CreatedAt    time.Time
+}
+
+// CurrentVersion is the version number of the live content.
+func (m FileMetadata) CurrentVersion() int {
+	if n := len(m.Versions); n > 0 {
+		return m.Versions[n-1].Number + 1
+	}
+	return 1
+}
+
+// Version returns a superseded version of the file, if it is kept.
+func (m FileMetadata) Version(number int) (FileVersion, bool) {
+	for _, v := range m.Versions {
+		if v.Number == number {
+			return v, true
+		}
+	}
+	return FileVersion{}, false
+}
+
+func VersionDerivative(number int) string {
+	return fmt.Sprintf("version-%d", number)
+}
+
+// ImageMetadata is probed from uploaded images. Width and height are the
+// displayed dimensions, i.e. after applying the EXIF orientation.
+type ImageMetadata struct {
+	Width       int
+	Height      int
+	Orientation int
+	Exif        map[string]string
+	Colors      *ImageColors
+	Renditions  []int // Square avatar sizes stored as derivatives
+	Frames      int   // Number of animation frames; 1 for still images
+}
+
+// ImageColors holds #rrggbb colors extracted from an image at upload.
+type ImageColors struct {
+	Dominant string
+	Average  string
+	Palette  []string
+}
+
+// VideoMetadata is read from the container headers of uploaded videos.
+type VideoMetadata struct {
+	Duration   float64 // Seconds
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec string
+
+	Transcode *TranscodeJob
+}
+
+type TranscodeStatus string
+
+const (
+	TranscodePending    TranscodeStatus = "pending"
+	TranscodeProcessing TranscodeStatus = "processing"
+	TranscodeDone       TranscodeStatus = "done"
+	TranscodeFailed     TranscodeStatus = "failed"
+)
+
+// TranscodeJob tracks the conversion of an uploaded video into H.264/AAC
+// MP4 renditions, stored as derivatives named by RenditionDerivative.
+type TranscodeJob struct {
+	Status     TranscodeStatus
+	Error      string
+	UpdatedAt  time.Time
+	Renditions []VideoRendition // Set once the job is done, smallest first
+	HLS        bool             // Renditions were also packaged for HLS, see HLSDerivative
+
+	// Preset and PresetVersion name the transcode preset the renditions
+	// were encoded with; empty for the configured defaults.
+	Preset        string
+	PresetVersion int
+
+	// Processing the job used, set once it finished.
+	CPUSeconds   float64
+	ScratchBytes int64
+}
+
+type VideoRendition struct {
+	Width  int
+	Height int
+	Size   int64
+}
+
+// RenditionName is how a rendition is addressed in URLs, e.g. "720p".
+func RenditionName(height int) string {
+	return fmt.Sprintf("%dp", height)
+}
+
+func RenditionDerivative(height int) string {
+	return "video-" + RenditionName(height)
+}
+
+// HLSDerivative names a file of a video's HLS packaging: a rendition's
+// media playlist ("720p.m3u8") or one of its segments ("720p_00000.ts").
+func HLSDerivative(name string) string {
+	return "hls-" + name
+}
+
+// Chapter is a titled part of a video or audio file. It runs until the
+// next chapter starts, the last one until the end of the file.
+type Chapter struct {
+	Start float64 // Seconds
+	Title string
+}
+
+// AudioMetadata describes uploaded audio. It is filled in when the
+// waveform is computed, as that decodes the whole file.
+type AudioMetadata struct {
+	Duration float64 // Seconds; 0 until decoded
+
+	// Loudness is set once a normalized copy has been made.
+	Loudness *Loudness
+}
+
+// Loudness is the EBU R128 measurement of uploaded audio, and the
+// integrated loudness its normalized copy was brought to.
+type Loudness struct {
+	Integrated float64 // LUFS
+	TruePeak   float64 // dBTP
+	Range      float64 // LU
+	Target     float64 // LUFS of the normalized copy
+}
+
+// WaveformDerivative names the peaks JSON of an audio file.
+const WaveformDerivative = "waveform"
+
+// NormalizedDerivative names the loudness-normalized copy of an audio
+// file, in the format of the original.
+const NormalizedDerivative = "normalized"
+
+// TextDerivative names the plain text recognized in a scanned image.
+const TextDerivative = "text"
+
+// PosterDerivative names the JPEG frame extracted from a video for
+// previews.
+const PosterDerivative = "poster"
+
+// TeaserDerivative names the looping animated preview of a video in
+// format, "gif" or "webp".
+func TeaserDerivative(format string) string {
+	return "teaser-" + format
+}
+
+type ScanStatus string
+
+const (
+	ScanPending  ScanStatus = "pending" // Queued for the background scan
+	ScanClean    ScanStatus = "clean"
+	ScanInfected ScanStatus = "infected" // The file is quarantined
+	ScanFailed   ScanStatus = "failed"   // The scanner could not be reached or gave up
+)
+
+// Scan is the malware scan of a file. Files are served while their scan
+// is pending; infected files are quarantined.
+type Scan struct {
+	Status    ScanStatus
+	Signature string // The malware found in an infected file
+	Error     string // Why a scan failed
+	ScannedAt time.Time
+
+	// ReusedFrom is the file whose scan of the same content was taken
+	// instead of scanning again; empty if the file was scanned itself.
+	ReusedFrom string
+}
+
+type QCStatus string
+
+const (
+	QCPending QCStatus = "pending" // Queued for a background check
+	QCPassed  QCStatus = "passed"
+	QCFailed  QCStatus = "failed" // See the findings
+	QCError   QCStatus = "error"  // The checks could not be run
+)
+
+// QualityCheck is the outcome of the automated quality checks of a video
+// or audio file.
+type QualityCheck struct {
+	Status    QCStatus
+	Findings  []QCFinding
+	Error     string // Why the checks could not be run
+	CheckedAt time.Time
+}
+
+// QCFinding is a check a file failed, e.g. "leading_silence" or
+// "black_frames", and where in the file; Start and End are seconds, both 0
+// for findings about the whole file.
+type QCFinding struct {
+	Check  string
+	Start  float64
+	End    float64
+	Detail string
+}
+
+// Quarantine records why a file was withheld from serving pending review.
+type Quarantine struct {
+	Reason    string
+	FlaggedBy string
+	FlaggedAt time.Time
+}
+
+// Takedown records a copyright claim that got a file taken down, and the
+// counter-notice of the uploader once one is received.
+type Takedown struct {
+	Claimant      string
+	Contact       string // How to reach the claimant
+	Work          string // The work the file is claimed to infringe
+	Reason        string
+	RequestedBy   string // Who filed the claim with the service
+	RequestedAt   time.Time
+	CounterNotice *CounterNotice
+}
+
+// CounterNotice is the uploader's response to a takedown, disputing the
+// claim.
+type CounterNotice struct {
+	Statement string
+	FiledBy   string
+	FiledAt   time.Time
+}
+
+// Servable reports whether the file may be delivered to regular clients.
+func (m FileMetadata) Servable() bool {
+	return m.Status == "" || m.Status == FileStatusActive
+}
+
+// Public reports whether the file is served to anyone.
+func (m FileMetadata) Public() bool {
+	return m.Visibility == "" || m.Visibility == VisibilityPublic
+}
+
+// LicenseExpired reports whether the license of the file ran out by now.
+func (m FileMetadata) LicenseExpired(now time.Time) bool {
+	return m.LicenseExpiresAt != nil && !now.Before(*m.LicenseExpiresAt)
+}
+
+// Expired reports whether the file is past its expiry at now and due to
+// be deleted.
+func (m FileMetadata) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// AvailabilityAt reports whether the file is within its availability
+// window at now.
+func (m FileMetadata) AvailabilityAt(now time.Time) Availability {
+	switch {
+	case m.AvailableFrom != nil && now.Before(*m.AvailableFrom):
+		return FileEmbargoed
+	case m.AvailableUntil != nil && !now.Before(*m.AvailableUntil):
+		return FileWithdrawn
+	}
+	return FileAvailable
 }
@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// GeoRule restricts the countries the files of a collection are served to.
+// Countries are ISO 3166-1 alpha-2 codes. With Allowed set, only those
+// countries are served; Blocked countries never are.
+type GeoRule struct {
+	Collection string
+	Allowed    []string
+	Blocked    []string
+	UpdatedBy  string
+	UpdatedAt  time.Time
+}
+
+// Permits reports whether files may be served to country. An unknown
+// country, "", is only served when no countries are allowed explicitly.
+func (r GeoRule) Permits(country string) bool {
+	if slices.Contains(r.Blocked, country) {
+		return false
+	}
+	return len(r.Allowed) == 0 || slices.Contains(r.Allowed, country)
+}
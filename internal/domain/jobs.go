@@ -0,0 +1,61 @@
+package domain
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending" // Waiting to run, possibly for a retry
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed" // Out of attempts, or failed permanently
+)
+
+// Job is a unit of background processing for a file. Kind selects the
+// handler that runs it; Params carries whatever else the handler needs.
+type Job struct {
+	ID       string
+	FileID   string
+	Kind     string
+	Params   map[string]string
+	Status   JobStatus
+	Attempts int
+	Error    string    // Last failure, kept while retrying
+	RunAt    time.Time // When the job is next due; zero once finished
+	Priority int       // Jobs due at the same time run highest first
+	Worker   string    // Replica running, or that last ran, the job; empty without affinity
+
+	// Processing used by all attempts so far.
+	CPUSeconds   float64
+	ScratchBytes int64
+
+	// Trace carries the trace context of the request that enqueued the job,
+	// so its runs are traced as part of that request.
+	Trace map[string]string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Worker is a replica sharing the job queue, as it last announced itself.
+// Replicas not seen for a while are taken for gone and their jobs handed
+// to the others.
+type Worker struct {
+	ID        string
+	StartedAt time.Time
+	SeenAt    time.Time
+}
+
+// Finished reports whether the job will not run again.
+func (j Job) Finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
+
+// Job kinds.
+const (
+	JobWaveform = "waveform"
+	JobLoudness = "loudness"
+	JobOCR      = "ocr"
+	JobScan     = "scan"
+	JobQC       = "qc"
+)
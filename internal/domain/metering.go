@@ -0,0 +1,19 @@
+package domain
+
+import "time"
+
+// UsageMonth is the layout of ProcessingUsage.Month.
+const UsageMonth = "2006-01"
+
+// ProcessingUsage totals the processing spent on the files of an
+// organization in a month on jobs of a kind, for billing. Kind is a job
+// kind, "transcode" or "upload".
+type ProcessingUsage struct {
+	OrgID        string
+	Month        string // UTC, see UsageMonth
+	Kind         string
+	Jobs         int64
+	CPUSeconds   float64
+	ScratchBytes int64
+	UpdatedAt    time.Time
+}
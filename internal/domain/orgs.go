@@ -0,0 +1,46 @@
+package domain
+
+import "time"
+
+type OrgStatus string
+
+const (
+	OrgActive      OrgStatus = "active"
+	OrgOffboarding OrgStatus = "offboarding" // Uploads refused; files deleted once the grace period ends
+	OrgOffboarded  OrgStatus = "offboarded"  // Files and settings deleted
+)
+
+// Org records an organization provisioned through the service. Orgs set
+// up by hand before have no record and are treated as active.
+type Org struct {
+	ID          string
+	Name        string
+	Status      OrgStatus
+	Collections []string // Default collections set up at provisioning
+	CreatedBy   string
+	CreatedAt   time.Time
+	Offboarding *Offboarding
+}
+
+// Offboarding is the staged deletion of an organization. Its files are
+// exported to an archive, kept until DeleteAt, when they are deleted with
+// the settings of the organization unless offboarding is cancelled first.
+type Offboarding struct {
+	RequestedBy  string
+	RequestedAt  time.Time
+	DeleteAt     time.Time
+	ExportFileID string // The archive of the organization's files, itself a file
+	DeletedAt    *time.Time
+}
+
+// OrgSettings are the settings organization admins change themselves,
+// within what the platform permits.
+type OrgSettings struct {
+	OrgID             string
+	WebhookURLs       []string   // Endpoints the file events of the organization are delivered to
+	WebhookSecret     string     // Signs the webhook deliveries; generated with the first URL
+	AllowedTypes      []string   // Content types the organization accepts; empty for all the service accepts
+	DefaultVisibility Visibility // Of uploads that ask for none; empty for the default of the service
+	UpdatedBy         string
+	UpdatedAt         time.Time
+}
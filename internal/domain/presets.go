@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+// TranscodePreset is a named set of encoding settings that the videos of
+// the collections listing it are transcoded with. Every change stores a
+// new version; earlier versions are kept, so renditions can be traced to
+// the settings they were made with.
+type TranscodePreset struct {
+	Name         string
+	Version      int
+	Codec        string       // "h264" or "h265"
+	Ladder       []LadderRung // Smallest first
+	AudioBitrate int          // kbit/s
+	Collections  []string
+	Deleted      bool // This version retired the preset
+	UpdatedBy    string
+	UpdatedAt    time.Time
+}
+
+// LadderRung is a rendition of a preset: its height in pixels and video
+// bit rate in kbit/s, 0 for constant quality.
+type LadderRung struct {
+	Height  int
+	Bitrate int
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// Quota overrides the default limits of an organization. A zero limit
+// falls back to the default; a negative one lifts it.
+type Quota struct {
+	OrgID     string
+	MaxBytes  int64 // Bytes of stored originals
+	MaxFiles  int64
+	UpdatedBy string
+	UpdatedAt time.Time
+}
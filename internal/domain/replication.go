@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"maps"
+	"time"
+)
+
+// Revision identifies a write to the metadata of a file replicated between
+// regions. Vector counts the writes each region made to the file that the
+// write followed, so that writes made in different regions without seeing
+// each other can be told from those made one after the other.
+type Revision struct {
+	ModifiedAt time.Time
+	ModifiedIn string            // Region of the write
+	Vector     map[string]uint64 // Writes by region
+}
+
+// RevisionOrder is how two revisions of a file relate.
+type RevisionOrder int
+
+const (
+	RevisionEqual      RevisionOrder = iota
+	RevisionBefore                   // The other revision followed this one
+	RevisionAfter                    // This revision followed the other one
+	RevisionConcurrent               // Neither saw the other
+)
+
+// Compare orders r against other by their vectors.
+func (r Revision) Compare(other Revision) RevisionOrder {
+	var before, after bool
+	for region, n := range r.Vector {
+		if n > other.Vector[region] {
+			after = true
+		}
+	}
+	for region, n := range other.Vector {
+		if n > r.Vector[region] {
+			before = true
+		}
+	}
+	switch {
+	case before && after:
+		return RevisionConcurrent
+	case before:
+		return RevisionBefore
+	case after:
+		return RevisionAfter
+	}
+	return RevisionEqual
+}
+
+// Later reports whether r was written after other by the clock of their
+// regions, ties going to the region that sorts last, for the last writer
+// to win.
+func (r Revision) Later(other Revision) bool {
+	if !r.ModifiedAt.Equal(other.ModifiedAt) {
+		return r.ModifiedAt.After(other.ModifiedAt)
+	}
+	return r.ModifiedIn > other.ModifiedIn
+}
+
+// Equal reports whether r and other are the same write.
+func (r Revision) Equal(other Revision) bool {
+	return r.ModifiedAt.Equal(other.ModifiedAt) && r.ModifiedIn == other.ModifiedIn && maps.Equal(r.Vector, other.Vector)
+}
+
+// Merge returns the revision following both r and other: the greater
+// count of each region, and the time and region of the later write.
+func (r Revision) Merge(other Revision) Revision {
+	merged := r
+	if other.Later(r) {
+		merged.ModifiedAt, merged.ModifiedIn = other.ModifiedAt, other.ModifiedIn
+	}
+	merged.Vector = maps.Clone(r.Vector)
+	if merged.Vector == nil {
+		merged.Vector = make(map[string]uint64, len(other.Vector))
+	}
+	for region, n := range other.Vector {
+		merged.Vector[region] = max(merged.Vector[region], n)
+	}
+	return merged
+}
+
+// ReplicationTask is a write to a file waiting to be replicated to a peer
+// region. A file has at most one task per region: later writes replace
+// the waiting one, as replicating the last write is enough.
+type ReplicationTask struct {
+	Region   string // Peer region
+	FileID   string
+	Deleted  bool      // The write deleted the file
+	Revision Revision  // Of the write
+	QueuedAt time.Time // Of the oldest write waiting, for the replication lag
+}
+
+// Tombstone records the deletion of a file replicated between regions, so
+// that older writes to it arriving late do not bring it back.
+type Tombstone struct {
+	FileID   string
+	Revision Revision
+}
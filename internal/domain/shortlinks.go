@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+// ShortLink maps a compact code to a file, e.g. for links printed or sent
+// by SMS. Query holds optional rendition parameters (size, format) added
+// to the redirect target. Signed links redirect to a URL signed on each
+// visit, so they share files that are not public.
+type ShortLink struct {
+	Code      string
+	FileID    string
+	Query     string
+	Signed    bool
+	Hits      int64
+	ExpiresAt *time.Time
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+func (l ShortLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// Slug is a human-readable public address of a file, such as
+// "press-kit-2025.zip", unique within the organization owning the file.
+// Pointing a slug at a new upload keeps published URLs stable across
+// re-uploads; PreviousFileID is the file it pointed at before.
+type Slug struct {
+	OrgID          string
+	Name           string
+	FileID         string
+	PreviousFileID string
+	CreatedBy      string
+	CreatedAt      time.Time
+	UpdatedBy      string
+	UpdatedAt      time.Time
+}
@@ -0,0 +1,219 @@
+// Package drain accounts for the requests and jobs in flight while the
+// service shuts down: which of them finished and which were cut off, so
+// that the impact of a deploy can be told from its report.
+package drain
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+// Kinds of work tracked.
+const (
+	KindRequest = "request" // An HTTP request or gRPC call
+	KindJob     = "job"
+)
+
+// maxAbortedFiles bounds the file IDs a tally lists.
+const maxAbortedFiles = 100
+
+var (
+	inFlight = metrics.NewGauge("media_inflight",
+		"Requests and jobs in progress, by kind.", "kind")
+	drainedTotal = metrics.NewCounter("media_shutdown_drained_total",
+		"Requests and jobs that finished while the service shut down, by kind.", "kind")
+	abortedTotal = metrics.NewCounter("media_shutdown_aborted_total",
+		"Requests and jobs cut off by the shutdown of the service, by kind.", "kind")
+	drainedBytes = metrics.NewCounter("media_shutdown_drained_bytes_total",
+		"Bytes transferred by requests, or written to scratch by jobs, that finished while the service shut down, by kind.", "kind")
+	abortedBytes = metrics.NewCounter("media_shutdown_aborted_bytes_total",
+		"Bytes transferred by requests, or written to scratch by jobs, cut off by the shutdown of the service, by kind.", "kind")
+	drainSeconds = metrics.NewGauge("media_shutdown_drain_seconds",
+		"How long the last shutdown took to drain.")
+)
+
+// Tracker keeps the requests and jobs in flight. A nil Tracker tracks
+// nothing.
+type Tracker struct {
+	mu       sync.Mutex
+	next     uint64
+	inFlight map[uint64]*Op
+	drainAt  time.Time // Zero until Drain
+	reported bool
+	tallies  map[string]*Tally
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		inFlight: make(map[uint64]*Op),
+		tallies:  map[string]*Tally{KindRequest: {}, KindJob: {}},
+	}
+}
+
+// Op is a request or job in flight. The methods of a nil Op do nothing.
+type Op struct {
+	t      *Tracker
+	id     uint64
+	kind   string
+	fileID string
+	bytes  atomic.Int64
+}
+
+// Begin tracks work of kind on a file, which may be empty, until End.
+func (t *Tracker) Begin(kind, fileID string) *Op {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	op := &Op{t: t, id: t.next, kind: kind, fileID: fileID}
+	t.inFlight[op.id] = op
+	inFlight.Add(1, kind)
+	return op
+}
+
+// Add counts n bytes transferred or written.
+func (o *Op) Add(n int64) {
+	if o != nil {
+		o.bytes.Add(n)
+	}
+}
+
+// End stops tracking the work, which aborted unless it finished. Work
+// ending while the service drains is tallied in the report.
+func (o *Op) End(aborted bool) {
+	if o == nil {
+		return
+	}
+	t := o.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.inFlight[o.id]; !ok {
+		// Already reported as cut off.
+		return
+	}
+	delete(t.inFlight, o.id)
+	inFlight.Add(-1, o.kind)
+	if !t.drainAt.IsZero() {
+		t.tally(o, aborted)
+	}
+}
+
+// tally counts an op ending during the drain; t.mu must be held.
+func (t *Tracker) tally(o *Op, aborted bool) {
+	tally, ok := t.tallies[o.kind]
+	if !ok {
+		tally = &Tally{}
+		t.tallies[o.kind] = tally
+	}
+	bytes := o.bytes.Load()
+	if !aborted {
+		tally.Drained++
+		tally.DrainedBytes += bytes
+		drainedTotal.Inc(o.kind)
+		drainedBytes.Add(float64(bytes), o.kind)
+		return
+	}
+	tally.Aborted++
+	tally.AbortedBytes += bytes
+	if o.fileID != "" && !slices.Contains(tally.AbortedFiles, o.fileID) {
+		if len(tally.AbortedFiles) < maxAbortedFiles {
+			tally.AbortedFiles = append(tally.AbortedFiles, o.fileID)
+		} else {
+			tally.MoreAbortedFiles++
+		}
+	}
+	abortedTotal.Inc(o.kind)
+	abortedBytes.Add(float64(bytes), o.kind)
+}
+
+// Drain marks the start of the shutdown; the work ending from then on is
+// tallied.
+func (t *Tracker) Drain() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drainAt.IsZero() {
+		t.drainAt = time.Now()
+	}
+}
+
+// Tally is the work of a kind that ended while the service drained.
+type Tally struct {
+	Drained      int
+	Aborted      int
+	DrainedBytes int64
+	AbortedBytes int64
+
+	// AbortedFiles are the files of the aborted work, up to 100 of them;
+	// MoreAbortedFiles counts those left out.
+	AbortedFiles     []string
+	MoreAbortedFiles int
+}
+
+// LogValue logs a tally as a group.
+func (t Tally) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("drained", t.Drained),
+		slog.Int("aborted", t.Aborted),
+		slog.Int64("drainedBytes", t.DrainedBytes),
+		slog.Int64("abortedBytes", t.AbortedBytes),
+	}
+	if len(t.AbortedFiles) > 0 {
+		attrs = append(attrs, slog.Any("abortedFileIds", t.AbortedFiles))
+	}
+	if t.MoreAbortedFiles > 0 {
+		attrs = append(attrs, slog.Int("moreAbortedFiles", t.MoreAbortedFiles))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Report is the outcome of draining the service.
+type Report struct {
+	Duration time.Duration
+	Requests Tally
+	Jobs     Tally
+}
+
+// Report ends the drain: work still in flight is counted as aborted, as
+// the service stops with it. Work ending afterwards is not tallied.
+func (t *Tracker) Report() Report {
+	if t == nil {
+		return Report{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drainAt.IsZero() {
+		t.drainAt = time.Now()
+	}
+	if !t.reported {
+		t.reported = true
+		for id, o := range t.inFlight {
+			delete(t.inFlight, id)
+			inFlight.Add(-1, o.kind)
+			t.tally(o, true)
+		}
+	}
+
+	report := Report{
+		Duration: time.Since(t.drainAt),
+		Requests: t.tallies[KindRequest].clone(),
+		Jobs:     t.tallies[KindJob].clone(),
+	}
+	drainSeconds.Set(report.Duration.Seconds())
+	return report
+}
+
+func (t *Tally) clone() Tally {
+	c := *t
+	c.AbortedFiles = slices.Clone(t.AbortedFiles)
+	return c
+}
@@ -0,0 +1,176 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+// Event types.
+const (
+	Created   = "media.created"
+	Deleted   = "media.deleted"
+	Processed = "media.processed" // Background processing of a file finished, see Event.Data
+
+	// Replaced is published when the content of a file is replaced, with
+	// the number of the superseded version as "version" in Event.Data.
+	Replaced = "media.replaced"
+
+	// Moved is published when a file is moved to another storage
+	// directory, with the new and previous directory as "directory" and
+	// "previous" in Event.Data. Copies are published as Created, with the
+	// ID of the copied file as "copiedFrom".
+	Moved = "media.moved"
+
+	// AvailabilityChanged is published when a file enters or leaves its
+	// availability window, with the new and previous domain.Availability
+	// as "availability" and "previous" in Event.Data.
+	AvailabilityChanged = "media.availability_changed"
+
+	// QuotaWarning is published when an upload takes an organization past
+	// one of the configured percentages of a quota, with "orgId",
+	// "resource" ("bytes" or "files"), "percent", "used" and "limit" in
+	// Event.Data.
+	QuotaWarning = "media.quota_warning"
+
+	// QuotaExceeded is published when an upload takes an organization to
+	// or past a quota, which makes it read-only until it is back under,
+	// with "orgId", "resource", "used" and "limit" in Event.Data.
+	QuotaExceeded = "media.quota_exceeded"
+)
+
+var published = metrics.NewCounter("media_events_published_total",
+	"File events handed to the event broker.", "type", "result")
+
+// Event describes a change to a file. File is the metadata after the
+// change, or the last known metadata of a deleted file.
+type Event struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	FileID     string         `json:"fileId"`
+	File       *File          `json:"file,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+	OccurredAt time.Time      `json:"occurredAt"`
+}
+
+// File is the metadata of a file carried by events.
+type File struct {
+	ID           string            `json:"id"`
+	OriginalName string            `json:"originalName,omitempty"`
+	ContentType  string            `json:"contentType"`
+	Size         int64             `json:"size"`
+	Directory    string            `json:"directory,omitempty"`
+	Collection   string            `json:"collection,omitempty"`
+	Checksums    map[string]string `json:"checksums,omitempty"`
+	OwnerID      string            `json:"ownerId,omitempty"`
+	OrgID        string            `json:"orgId,omitempty"`
+	Status       domain.FileStatus `json:"status,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	ExpiresAt    *time.Time        `json:"expiresAt,omitempty"`
+}
+
+func NewFile(meta domain.FileMetadata) *File {
+	return &File{
+		ID:           meta.ID,
+		OriginalName: meta.OriginalName,
+		ContentType:  meta.ContentType,
+		Size:         meta.Size,
+		Directory:    meta.Directory,
+		Collection:   meta.Collection,
+		Checksums:    meta.Checksums,
+		OwnerID:      meta.OwnerID,
+		OrgID:        meta.OrgID,
+		Status:       meta.Status,
+		CreatedAt:    meta.CreatedAt,
+		ExpiresAt:    meta.ExpiresAt,
+	}
+}
+
+// Publisher delivers events to a message broker.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+
+	// Close flushes events not yet delivered and disconnects.
+	Close() error
+}
+
+// Emitter publishes events in the background so callers never wait on the
+// broker. Publishing is retried a few times; failures are only logged. A
+// nil Emitter drops events; one without a publisher only runs its hooks.
+type Emitter struct {
+	publisher Publisher // May be nil
+	hooks     []func(Event)
+	logger    *slog.Logger
+	wg        sync.WaitGroup
+}
+
+func NewEmitter(publisher Publisher, logger *slog.Logger) *Emitter {
+	return &Emitter{publisher: publisher, logger: logger}
+}
+
+// Hook has fn called in the background with every event emitted. Hooks
+// are added before the first event is.
+func (e *Emitter) Hook(fn func(Event)) {
+	e.hooks = append(e.hooks, fn)
+}
+
+func (e *Emitter) Emit(event Event) {
+	if e == nil {
+		return
+	}
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	for _, fn := range e.hooks {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			fn(event)
+		}()
+	}
+	if e.publisher == nil {
+		return
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		backoff := time.Second
+		for attempt := 1; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := e.publisher.Publish(ctx, event)
+			cancel()
+			if err == nil {
+				published.Inc(event.Type, "ok")
+				return
+			}
+			e.logger.Warn("Event publishing failed", "type", event.Type, "fileId", event.FileID, "attempt", attempt, "error", err)
+			if attempt == 3 {
+				published.Inc(event.Type, "failed")
+				return
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+// Close waits for events being published and closes the publisher.
+func (e *Emitter) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.wg.Wait()
+	if e.publisher == nil {
+		return nil
+	}
+	return e.publisher.Close()
+}
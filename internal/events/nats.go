@@ -0,0 +1,62 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSPublisher publishes events to NATS JetStream on the subject
+// prefix+type, e.g. "media.created". The JetStream message ID is the event
+// ID, so the stream drops duplicates of retried publishes.
+type NATSPublisher struct {
+	conn   *nats.Conn
+	js     jetstream.JetStream
+	prefix string
+}
+
+// NewNATSPublisher connects to the NATS server at url. With stream set,
+// the stream is created or updated to capture all event subjects;
+// otherwise it must be provisioned separately.
+func NewNATSPublisher(ctx context.Context, url, stream, prefix string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("media-service"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+
+	if stream != "" {
+		_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:     stream,
+			Subjects: []string{prefix + "media.>"},
+		})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set up stream %s: %w", stream, err)
+		}
+	}
+
+	return &NATSPublisher{conn: conn, js: js, prefix: prefix}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if _, err := p.js.Publish(ctx, p.prefix+event.Type, data, jetstream.WithMsgID(event.ID)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
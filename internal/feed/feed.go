@@ -0,0 +1,202 @@
+// Package feed renders lists of published files as a sitemap, an Atom feed
+// or an RSS 2.0 feed. It only formats; choosing the files is up to the
+// caller.
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+const (
+	// MaxSitemapURLs is the most URLs a sitemap may list.
+	MaxSitemapURLs = 50000
+
+	atomNamespace    = "http://www.w3.org/2005/Atom"
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// Channel is a feed: its title, its own URL and the page it describes, and
+// the entries, newest first.
+type Channel struct {
+	Title   string
+	SelfURL string
+	Link    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// Entry is a published file. URL is both its address and its permanent ID.
+type Entry struct {
+	Title       string
+	Summary     string
+	URL         string
+	ContentType string
+	Size        int64
+	Author      string
+	Published   time.Time
+	Updated     time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Sitemap lists the entries for crawlers. Entries beyond MaxSitemapURLs
+// are left out.
+func Sitemap(entries []Entry) ([]byte, error) {
+	if len(entries) > MaxSitemapURLs {
+		entries = entries[:MaxSitemapURLs]
+	}
+	set := urlSet{Xmlns: sitemapNamespace, URLs: make([]sitemapURL, 0, len(entries))}
+	for _, e := range entries {
+		set.URLs = append(set.URLs, sitemapURL{Loc: e.URL, LastMod: e.Updated.UTC().Format(time.RFC3339)})
+	}
+	return marshal(set)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Summary   string      `xml:"summary,omitempty"`
+	Author    *atomAuthor `xml:"author"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// Atom renders the channel as an Atom 1.0 feed.
+func Atom(ch Channel) ([]byte, error) {
+	feed := atomFeed{
+		Xmlns:   atomNamespace,
+		ID:      ch.SelfURL,
+		Title:   ch.Title,
+		Updated: ch.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: ch.SelfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: ch.Link, Rel: "alternate"},
+		},
+		Entries: make([]atomEntry, 0, len(ch.Entries)),
+	}
+	for _, e := range ch.Entries {
+		entry := atomEntry{
+			ID:        e.URL,
+			Title:     e.Title,
+			Summary:   e.Summary,
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: e.URL, Rel: "alternate"},
+				{Href: e.URL, Rel: "enclosure", Type: e.ContentType, Length: e.Size},
+			},
+		}
+		// Atom requires an author on entries when the feed names none.
+		author := e.Author
+		if author == "" {
+			author = ch.Title
+		}
+		entry.Author = &atomAuthor{Name: author}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshal(feed)
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Xmlns   string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description,omitempty"`
+	GUID        string       `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// RSS renders the channel as an RSS 2.0 feed. RSS has no notion of updated
+// items, so items are dated by their last update to resurface in readers.
+func RSS(ch Channel) ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		Xmlns:   atomNamespace,
+		Channel: rssChannel{
+			Title:         ch.Title,
+			Link:          ch.Link,
+			Description:   ch.Title,
+			LastBuildDate: ch.Updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Href: ch.SelfURL, Rel: "self", Type: "application/rss+xml"},
+			Items:         make([]rssItem, 0, len(ch.Entries)),
+		},
+	}
+	for _, e := range ch.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.URL,
+			Description: e.Summary,
+			GUID:        e.URL,
+			PubDate:     e.Updated.UTC().Format(time.RFC1123Z),
+			Enclosure:   rssEnclosure{URL: e.URL, Length: e.Size, Type: e.ContentType},
+		})
+	}
+	return marshal(doc)
+}
+
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+// Package geoip tells which country a client connects from, by looking
+// its address up in a MaxMind country database or by trusting a header a
+// CDN in front of the service sets.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Locator looks up the country of IP addresses in a GeoLite2 or GeoIP2
+// Country (or City) database.
+type Locator struct {
+	db *maxminddb.Reader
+}
+
+// Open loads the database at path, or returns nil when path is empty. A
+// nil locator knows no countries.
+func Open(path string) (*Locator, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return &Locator{db: db}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country addr is
+// registered in, or "" if it is unknown.
+func (l *Locator) Country(addr netip.Addr) (string, error) {
+	if l == nil || !addr.IsValid() {
+		return "", nil
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := l.db.Lookup(net.IP(addr.Unmap().AsSlice()), &record); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(record.Country.ISOCode), nil
+}
+
+// Client is the party a request is served to. Country is set when a
+// trusted proxy told it, otherwise it is looked up from Addr.
+type Client struct {
+	Addr    netip.Addr
+	Country string
+}
+
+type clientKey struct{}
+
+// WithClient returns ctx carrying the client of the request.
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom returns the client carried by ctx. ok is false for work not
+// done on behalf of a client, such as background jobs.
+func ClientFrom(ctx context.Context) (client Client, ok bool) {
+	client, ok = ctx.Value(clientKey{}).(Client)
+	return client, ok
+}
+
+// ValidCountry reports whether code looks like an ISO 3166-1 alpha-2 code.
+func ValidCountry(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
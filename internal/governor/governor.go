@@ -0,0 +1,242 @@
+package governor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+// ErrTooLarge is returned for a job that needs more memory than the limit
+// even with nothing else running.
+var ErrTooLarge = errors.New("job exceeds the processing limits")
+
+// SaturatedError is returned when a job is not admitted because the node
+// is busy. RetryAfter estimates when capacity frees up.
+type SaturatedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SaturatedError) Error() string {
+	return fmt.Sprintf("processing capacity exhausted, retry in %s", e.RetryAfter)
+}
+
+var (
+	admitted = metrics.NewCounter("media_governor_admitted_total",
+		"Processing jobs admitted by the resource governor.", "kind")
+	rejected = metrics.NewCounter("media_governor_rejected_total",
+		"Processing jobs turned away by the resource governor.", "kind", "reason")
+	waitTime = metrics.NewHistogram("media_governor_wait_seconds",
+		"Time processing jobs waited for capacity.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}, "kind")
+)
+
+// Cost is the estimated footprint of a running job: bytes of memory and
+// CPU cores.
+type Cost struct {
+	Memory int64
+	CPU    int
+}
+
+type Config struct {
+	// MaxMemory and MaxCPU bound the summed cost of running jobs. Zero
+	// disables the respective limit.
+	MaxMemory int64
+	MaxCPU    int
+
+	// MaxWait is how long Admit waits for capacity before rejecting a
+	// job; zero rejects at once when the node is busy.
+	MaxWait time.Duration
+
+	// MaxQueued bounds the jobs waiting at once. Jobs beyond it are
+	// rejected without waiting.
+	MaxQueued int
+}
+
+type waiter struct {
+	cost  Cost
+	ready chan struct{}
+}
+
+// Governor admits processing jobs while their estimated cost fits the
+// node's limits and queues them otherwise, so upload bursts are smoothed
+// out or turned away instead of running the process out of memory. Jobs
+// are admitted first come, first served, so a large job is not starved by
+// a stream of small ones.
+type Governor struct {
+	cfg Config
+
+	mu      sync.Mutex
+	memory  int64
+	cpu     int
+	queue   []*waiter
+	holdAvg time.Duration // Moving average of how long jobs hold capacity
+}
+
+func New(cfg Config) *Governor {
+	g := &Governor{cfg: cfg}
+
+	metrics.NewGaugeFunc("media_governor_memory_bytes", "Estimated memory held by running processing jobs.", func() float64 {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return float64(g.memory)
+	})
+	metrics.NewGaugeFunc("media_governor_cpu", "CPU cores claimed by running processing jobs.", func() float64 {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return float64(g.cpu)
+	})
+	metrics.NewGaugeFunc("media_governor_queued", "Processing jobs waiting for capacity.", func() float64 {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return float64(len(g.queue))
+	})
+
+	return g
+}
+
+// Admit reserves cost for a job of kind serving a request. It waits up to
+// the configured MaxWait for capacity and returns a *SaturatedError if
+// none frees up, or ErrTooLarge if the job can never run. The returned
+// function releases the reservation. A nil governor admits everything.
+func (g *Governor) Admit(ctx context.Context, kind string, cost Cost) (func(), error) {
+	return g.acquire(ctx, kind, cost, true)
+}
+
+// Acquire is Admit for background jobs: it waits for capacity as long as
+// ctx allows and is never turned away for saturation.
+func (g *Governor) Acquire(ctx context.Context, kind string, cost Cost) (func(), error) {
+	return g.acquire(ctx, kind, cost, false)
+}
+
+func (g *Governor) acquire(ctx context.Context, kind string, cost Cost, bounded bool) (func(), error) {
+	if g == nil {
+		return func() {}, nil
+	}
+	if g.cfg.MaxMemory > 0 && cost.Memory > g.cfg.MaxMemory {
+		rejected.Inc(kind, "too_large")
+		return nil, fmt.Errorf("%w: needs %d of %d bytes", ErrTooLarge, cost.Memory, g.cfg.MaxMemory)
+	}
+	// A job wanting more cores than there are still runs, just alone.
+	if g.cfg.MaxCPU > 0 {
+		cost.CPU = min(cost.CPU, g.cfg.MaxCPU)
+	}
+
+	start := time.Now()
+	g.mu.Lock()
+	if len(g.queue) == 0 && g.fits(cost) {
+		g.take(cost)
+		g.mu.Unlock()
+		return g.admitted(kind, cost, start), nil
+	}
+	if bounded && (g.cfg.MaxWait <= 0 || len(g.queue) >= g.cfg.MaxQueued) {
+		err := g.saturated()
+		g.mu.Unlock()
+		rejected.Inc(kind, "saturated")
+		return nil, err
+	}
+	w := &waiter{cost: cost, ready: make(chan struct{})}
+	g.queue = append(g.queue, w)
+	g.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if bounded {
+		timer := time.NewTimer(g.cfg.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-w.ready:
+		return g.admitted(kind, cost, start), nil
+	case <-ctx.Done():
+		g.abandon(w)
+		return nil, ctx.Err()
+	case <-timeout:
+		if !g.abandon(w) {
+			return g.admitted(kind, cost, start), nil
+		}
+		g.mu.Lock()
+		err := g.saturated()
+		g.mu.Unlock()
+		rejected.Inc(kind, "timeout")
+		return nil, err
+	}
+}
+
+// abandon removes w from the queue and reports true, or reports false if
+// it had been admitted meanwhile, in which case the caller owns the
+// reservation.
+func (g *Governor) abandon(w *waiter) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-w.ready:
+		return false
+	default:
+	}
+	for i, q := range g.queue {
+		if q == w {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			break
+		}
+	}
+	// The head may have been blocking jobs behind it that fit.
+	g.dispatch()
+	return true
+}
+
+func (g *Governor) admitted(kind string, cost Cost, start time.Time) func() {
+	admitted.Inc(kind)
+	waitTime.Observe(time.Since(start).Seconds(), kind)
+
+	held := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.memory -= cost.Memory
+			g.cpu -= cost.CPU
+			g.holdAvg += (time.Since(held) - g.holdAvg) / 8
+			g.dispatch()
+		})
+	}
+}
+
+// dispatch admits queued jobs in order while they fit. The caller holds
+// g.mu.
+func (g *Governor) dispatch() {
+	for len(g.queue) > 0 && g.fits(g.queue[0].cost) {
+		w := g.queue[0]
+		g.queue = g.queue[1:]
+		g.take(w.cost)
+		close(w.ready)
+	}
+}
+
+// fits reports whether cost can run now. The caller holds g.mu.
+func (g *Governor) fits(cost Cost) bool {
+	if g.cfg.MaxMemory > 0 && g.memory+cost.Memory > g.cfg.MaxMemory {
+		return false
+	}
+	if g.cfg.MaxCPU > 0 && g.cpu+cost.CPU > g.cfg.MaxCPU {
+		return false
+	}
+	return true
+}
+
+// take reserves cost. The caller holds g.mu.
+func (g *Governor) take(cost Cost) {
+	g.memory += cost.Memory
+	g.cpu += cost.CPU
+}
+
+// saturated estimates the wait from the average job duration and the
+// queue ahead. The caller holds g.mu.
+func (g *Governor) saturated() *SaturatedError {
+	retry := g.holdAvg * time.Duration(len(g.queue)+1) / time.Duration(max(g.cfg.MaxCPU, 1))
+	return &SaturatedError{RetryAfter: max(retry.Round(time.Second), time.Second)}
+}
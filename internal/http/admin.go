@@ -0,0 +1,179 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/cdn"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/middleware"
+	"github.com/ondrasimku/media-service-go/internal/moderation"
+	"github.com/ondrasimku/media-service-go/internal/takedown"
+	"github.com/ondrasimku/media-service-go/internal/webhook"
+)
+
+// adminFeature serves operators: collection audits, the moderation queue,
+// takedowns, scrub status, garbage collection, scheduled tasks, statistics, the branding,
+// feeds, quotas, usage and settings of organizations, the geo rules of collections,
+// transcode presets, the report of expiring licenses, the audit log, CDN
+// prewarming and, when enabled, the profiler.
+type adminFeature struct{}
+
+func (adminFeature) Register(router *gin.Engine, deps *Deps) {
+	cfg, logger := deps.Config, deps.Logger
+	v1 := router.Group("/v1")
+
+	if deps.Enabled("collections") {
+		collectionHandler := handler.NewCollectionHandler(deps.Auditor, logger)
+		collectionRoutes := v1.Group("/collections/:collectionId")
+		collectionRoutes.Use(deps.Auth)
+		{
+			collectionRoutes.GET("/manifest", collectionHandler.Manifest)
+			collectionRoutes.GET("/audit", middleware.RequirePermissions([]string{"files:audit"}), collectionHandler.LastAudit)
+			collectionRoutes.POST("/audit", middleware.RequirePermissions([]string{"files:audit"}), collectionHandler.Audit)
+		}
+	}
+
+	notifier := webhook.NewNotifier(cfg.Webhook.URL, cfg.Webhook.Secret, logger)
+	if deps.Enabled("moderation") {
+		moderationQueue := moderation.NewQueue(deps.Files, deps.Metadata, notifier, deps.Audit, logger)
+		moderationHandler := handler.NewModerationHandler(moderationQueue, deps.Storage, logger)
+		moderationRoutes := v1.Group("/moderation")
+		moderationRoutes.Use(deps.Auth, middleware.RequirePermissions([]string{"files:moderate"}))
+		{
+			moderationRoutes.GET("/queue", moderationHandler.Queue)
+			moderationRoutes.GET("/files/:fileId/preview", moderationHandler.Preview)
+			moderationRoutes.POST("/files/:fileId/flag", moderationHandler.Flag)
+			moderationRoutes.POST("/files/:fileId/approve", moderationHandler.Approve)
+			moderationRoutes.POST("/files/:fileId/reject", moderationHandler.Reject)
+		}
+	}
+
+	if deps.Enabled("takedowns") {
+		takedownDesk := takedown.NewDesk(deps.Metadata, notifier, deps.Audit, logger)
+		takedownHandler := handler.NewTakedownHandler(takedownDesk, logger)
+		takedownRoutes := v1.Group("/takedowns")
+		takedownRoutes.Use(deps.Auth)
+		{
+			takedownRoutes.GET("", middleware.RequirePermissions([]string{"files:moderate"}), takedownHandler.List)
+			takedownRoutes.GET("/files/:fileId", middleware.RequirePermissions([]string{"files:moderate"}), takedownHandler.Get)
+			takedownRoutes.POST("/files/:fileId", middleware.RequirePermissions([]string{"files:moderate"}), takedownHandler.Create)
+			takedownRoutes.POST("/files/:fileId/counter-notice", middleware.RequirePermissions([]string{"files:admin"}), takedownHandler.CounterNotice)
+			takedownRoutes.POST("/files/:fileId/restore", middleware.RequirePermissions([]string{"files:admin"}), takedownHandler.Restore)
+		}
+	}
+
+	if deps.Enabled("admin") {
+		adminHandler := handler.NewAdminHandler(deps.Scrubber, deps.Collector, deps.Cron, deps.Stats, logger)
+		// OAuth tokens may be held to admin scopes on top of the permission.
+		adminRoutes := v1.Group("/admin")
+		adminRoutes.Use(deps.Auth, middleware.RequirePermissions([]string{"files:admin"}), middleware.RequireScopes(cfg.Auth.AdminScopes))
+		{
+			adminRoutes.GET("/scrub", adminHandler.ScrubStatus)
+			adminRoutes.GET("/gc", adminHandler.LastGC)
+			adminRoutes.POST("/gc", adminHandler.CollectGarbage)
+			adminRoutes.GET("/cron", adminHandler.Cron)
+			adminRoutes.GET("/stats", adminHandler.Stats)
+		}
+
+		brandingHandler := handler.NewBrandingHandler(deps.Brandings, logger)
+		brandingRoutes := adminRoutes.Group("/brandings")
+		{
+			brandingRoutes.GET("", brandingHandler.List)
+			brandingRoutes.GET("/:orgId", brandingHandler.Get)
+			brandingRoutes.PUT("/:orgId", brandingHandler.Put)
+			brandingRoutes.DELETE("/:orgId", brandingHandler.Delete)
+		}
+
+		geoRuleHandler := handler.NewGeoRuleHandler(deps.Geo, logger)
+		geoRuleRoutes := adminRoutes.Group("/geo-rules")
+		{
+			geoRuleRoutes.GET("", geoRuleHandler.List)
+			geoRuleRoutes.GET("/:collectionId", geoRuleHandler.Get)
+			geoRuleRoutes.PUT("/:collectionId", geoRuleHandler.Put)
+			geoRuleRoutes.DELETE("/:collectionId", geoRuleHandler.Delete)
+		}
+
+		presetHandler := handler.NewPresetHandler(deps.Presets, logger)
+		presetRoutes := adminRoutes.Group("/presets")
+		{
+			presetRoutes.GET("", presetHandler.List)
+			presetRoutes.GET("/:name", presetHandler.Get)
+			presetRoutes.PUT("/:name", presetHandler.Put)
+			presetRoutes.DELETE("/:name", presetHandler.Delete)
+			presetRoutes.GET("/:name/versions", presetHandler.Versions)
+			presetRoutes.GET("/:name/versions/:version", presetHandler.Version)
+		}
+
+		if deps.Enabled("feeds") {
+			feedHandler := handler.NewFeedHandler(deps.Feeds, cfg.Feeds.CacheTTL, logger)
+			feedRoutes := adminRoutes.Group("/feeds")
+			{
+				feedRoutes.GET("", feedHandler.List)
+				feedRoutes.GET("/:orgId", feedHandler.Get)
+				feedRoutes.PUT("/:orgId", feedHandler.Put)
+				feedRoutes.DELETE("/:orgId", feedHandler.Delete)
+			}
+		}
+
+		if deps.Enabled("quotas") {
+			quotaHandler := handler.NewQuotaHandler(deps.Quotas, logger)
+			quotaRoutes := adminRoutes.Group("/quotas")
+			{
+				quotaRoutes.GET("", quotaHandler.List)
+				quotaRoutes.GET("/:orgId", quotaHandler.Get)
+				quotaRoutes.PUT("/:orgId", quotaHandler.Put)
+				quotaRoutes.DELETE("/:orgId", quotaHandler.Delete)
+			}
+			v1.GET("/orgs/:orgId/usage", deps.Auth, middleware.RequirePermissions([]string{"files:admin"}), quotaHandler.Usage)
+		}
+
+		if deps.Enabled("orgs") {
+			orgHandler := handler.NewOrgHandler(deps.Orgs, logger)
+			orgRoutes := adminRoutes.Group("/orgs")
+			{
+				orgRoutes.GET("", orgHandler.List)
+				orgRoutes.POST("", orgHandler.Create)
+				orgRoutes.GET("/:orgId", orgHandler.Get)
+				orgRoutes.POST("/:orgId/offboard", orgHandler.Offboard)
+				orgRoutes.DELETE("/:orgId/offboard", orgHandler.CancelOffboarding)
+			}
+		}
+
+		if deps.Enabled("settings") {
+			settingsHandler := handler.NewSettingsHandler(deps.Settings, logger)
+			settingsRoutes := v1.Group("/orgs/:orgId/settings")
+			settingsRoutes.Use(deps.Auth)
+			{
+				settingsRoutes.GET("", settingsHandler.Get)
+				settingsRoutes.PATCH("", settingsHandler.Update)
+			}
+		}
+
+		licenseHandler := handler.NewLicenseHandler(deps.Files, cfg.Licenses.ReportWindow, logger)
+		adminRoutes.GET("/licenses/expiring", licenseHandler.Expiring)
+
+		auditLogHandler := handler.NewAuditLogHandler(deps.Audit, logger)
+		adminRoutes.GET("/audit-log", auditLogHandler.List)
+
+		prewarmer := cdn.NewPrewarmer(deps.Metadata, deps.Storage.URL, cdn.Config{
+			Edges:         cfg.CDN.Edges,
+			PrefetchURL:   cfg.CDN.PrefetchURL,
+			PrefetchToken: cfg.CDN.PrefetchToken,
+			Concurrency:   cfg.CDN.PrewarmConcurrency,
+		}, logger)
+		prewarmHandler := handler.NewPrewarmHandler(prewarmer, logger)
+		prewarmRoutes := adminRoutes.Group("/prewarm")
+		{
+			prewarmRoutes.GET("", prewarmHandler.List)
+			prewarmRoutes.POST("", prewarmHandler.Start)
+			prewarmRoutes.GET("/:runId", prewarmHandler.Get)
+			prewarmRoutes.DELETE("/:runId", prewarmHandler.Cancel)
+		}
+
+		if cfg.API.Debug {
+			debugHandler := handler.NewDebugHandler()
+			adminRoutes.GET("/debug/runtime", debugHandler.Runtime)
+			adminRoutes.GET("/debug/pprof/*profile", debugHandler.Profile)
+			adminRoutes.POST("/debug/pprof/*profile", debugHandler.Profile) // symbol lookups
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+)
+
+// annotationsFeature manages region annotations on files.
+type annotationsFeature struct{}
+
+func (annotationsFeature) Register(router *gin.Engine, deps *Deps) {
+	if !deps.Enabled("annotations") {
+		return
+	}
+
+	annotationHandler := handler.NewAnnotationHandler(deps.Files, deps.Metadata, deps.Audit, deps.Logger)
+	annotationRoutes := router.Group("/v1/files/:fileId/annotations")
+	annotationRoutes.Use(deps.Auth)
+	{
+		annotationRoutes.GET("", annotationHandler.List)
+		annotationRoutes.POST("", annotationHandler.Create)
+		annotationRoutes.GET("/:annotationId", annotationHandler.Get)
+		annotationRoutes.PUT("/:annotationId", annotationHandler.Update)
+		annotationRoutes.DELETE("/:annotationId", annotationHandler.Delete)
+	}
+}
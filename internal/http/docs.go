@@ -0,0 +1,487 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/cdn"
+	"github.com/ondrasimku/media-service-go/internal/cron"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/openapi"
+	"github.com/ondrasimku/media-service-go/internal/integrity"
+	"github.com/ondrasimku/media-service-go/internal/replication"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+	"github.com/ondrasimku/media-service-go/internal/tuning"
+)
+
+// docsFeature serves the OpenAPI description of the API at /openapi.json
+// and, when enabled, Swagger UI at /docs. It describes the routes the
+// router ends up with, so it covers whatever the other features register.
+type docsFeature struct{}
+
+func (docsFeature) Register(router *gin.Engine, deps *Deps) {
+	if !deps.Enabled("openapi") {
+		return
+	}
+
+	docsHandler := handler.NewDocsHandler(func() *openapi.Document {
+		info := openapi.Info{
+			Title:   "Media service",
+			Version: "v1",
+			Description: "Uploads, serves and processes media files. Errors are RFC 7807 problem details. " +
+				"Unversioned paths are deprecated aliases of the /v1 paths.",
+		}
+		return openapi.Build(info, router.Routes(), apiSpecs())
+	}, deps.Logger)
+	router.GET("/openapi.json", docsHandler.OpenAPI)
+	if deps.Config.API.SwaggerUI {
+		router.GET("/docs", docsHandler.SwaggerUI)
+	}
+}
+
+// sourceHeader selects the processing preset of an upload.
+var sourceHeader = openapi.Parameter{
+	Name: handler.UploadSourceHeader, In: "header", Schema: &openapi.Schema{Type: "string"},
+	Description: "Where the file comes from, e.g. mobile-camera, scanner or export; selects the processing preset configured for it",
+}
+
+// checksumHeaders declare the SHA-256 an upload must match.
+var checksumHeaders = []openapi.Parameter{
+	{Name: handler.ChecksumHeader, In: "header", Schema: &openapi.Schema{Type: "string"},
+		Description: "SHA-256 of the uploaded file in hex or base64; uploads that do not match are refused"},
+	{Name: "Content-Digest", In: "header", Schema: &openapi.Schema{Type: "string"},
+		Description: "RFC 9530 digest of the uploaded file, e.g. sha-256=:base64:; only sha-256 is checked"},
+}
+
+// feedQuery narrows sitemaps and feeds to one collection.
+var feedQuery = []openapi.Parameter{
+	{Name: "collection", In: "query", Description: "One of the public collections; all of them by default", Schema: &openapi.Schema{Type: "string"}},
+}
+
+// fileForm is the multipart body of uploads.
+var fileForm = &openapi.Schema{
+	Type: "object",
+	Properties: map[string]*openapi.Schema{
+		"file":        {Type: "string", Format: "binary"},
+		"collection":  {Type: "string", Description: "Collection to file the upload in"},
+		"fileId":      {Type: "string", Description: "ID to store the file under; requires the files:custom_id permission"},
+		"cropX":       {Type: "integer", Description: "Crop of avatars; all four crop fields or none"},
+		"cropY":       {Type: "integer"},
+		"cropWidth":   {Type: "integer"},
+		"cropHeight":  {Type: "integer"},
+		"altText":     {Type: "string", Description: "Required for images in some collections"},
+		"description": {Type: "string"},
+		"credit":      {Type: "string"},
+		"license":     {Type: "string"},
+
+		"rightsHolder":     {Type: "string"},
+		"licenseExpiresAt": {Type: "string", Description: "RFC 3339 time or date the license expires at"},
+		"availableFrom":    {Type: "string", Description: "RFC 3339 time or date the file is embargoed until"},
+		"availableUntil":   {Type: "string", Description: "RFC 3339 time or date the file is withdrawn at"},
+		"ttl":              {Type: "string", Description: "How long the file is kept before it is deleted, e.g. 24h or a number of seconds"},
+		"visibility":       {Type: "string", Description: "public, org or private; by default that of the organization, or MEDIA_DEFAULT_VISIBILITY"},
+	},
+	Required: []string{"file"},
+}
+
+var (
+	sizeQuery        = openapi.Parameter{Name: "size", In: "query", Description: "Avatar rendition size in pixels", Schema: &openapi.Schema{Type: "integer"}}
+	formatQuery      = openapi.Parameter{Name: "format", In: "query", Description: "Image format to convert to, e.g. webp or avif", Schema: &openapi.Schema{Type: "string"}}
+	progressiveQuery = openapi.Parameter{Name: "progressive", In: "query", Description: "Serve renditions and watermarked images as progressive JPEGs or interlaced PNGs", Schema: &openapi.Schema{Type: "boolean"}}
+	fieldsQuery      = openapi.Parameter{Name: "fields", In: "query", Description: "Comma-separated fields to return", Schema: &openapi.Schema{Type: "string"}}
+)
+
+// apiSpecs documents the operations by method and route path. Routes left
+// out are still described, without schemas.
+func apiSpecs() map[string]openapi.Spec {
+	specs := map[string]openapi.Spec{
+		"GET /healthz": {Summary: "Health check", Tags: []string{"health"}},
+		"GET /readyz":  {Summary: "Readiness of storage, metadata and JWKS", Tags: []string{"health"}, Response: handler.ReadinessResponse{}},
+		"GET /metrics": {Summary: "Prometheus metrics", Tags: []string{"health"}, Content: "text/plain"},
+
+		"GET /v1/files": {
+			Summary: "List the caller's files, newest first", Tags: []string{"files"}, Auth: true,
+			Query: []openapi.Parameter{
+				{Name: "ownerId", In: "query", Description: "Owner of the files; admins only, who list all files without it", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "orgId", In: "query", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "collection", In: "query", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "limit", In: "query", Description: "At most 1000; 100 by default", Schema: &openapi.Schema{Type: "integer"}},
+			},
+			Response: handler.UploadResponse{}, List: true,
+			Description: "Admins, with files:admin or the role of AUTH_ADMIN_ROLE, may list the files of other users and organizations; each such listing is recorded in the audit log as file.admin_access.",
+		},
+		"DELETE /v1/files/:fileId": {
+			Summary: "Delete a file", Tags: []string{"files"}, Auth: true, Status: http.StatusNoContent,
+			Description: "Owners may delete their own files, admins any file, which is recorded in the audit log as file.admin_access besides file.delete.",
+		},
+		"POST /v1/files": {
+			Summary: "Upload a file", Tags: []string{"files"}, Auth: true,
+			Description: "Uploads of an organization are held to its plan: its file size limit and quota, the features it includes (video, audio, and text recognition for the ocr upload source) and its rate limit, which is answered with 429. An organization that reached its quota is read-only: its uploads are answered with 403 until it deletes files or its quota is raised, while its files are still served. An upload begun under the storage quota may take it over by MEDIA_QUOTA_GRACE_PERCENT.",
+			Query:       append([]openapi.Parameter{fieldsQuery, sourceHeader}, checksumHeaders...), Form: fileForm, Response: handler.UploadResponse{},
+		},
+		"GET /v1/files/:fileId": {
+			Summary: "Download a file", Tags: []string{"files"},
+			Description: "Public files are served to anyone. Files visible to their organization are served to its tokens, private files to their owner; both also to files:admin and with a URL signed by POST /v1/files/{fileId}/sign. The same applies to the metadata and derivatives of a file. Anonymous requests for other files are answered with 401, others with 403. Downloads of the files of an organization over the rate limit of its plan are answered with 429. Files homed in another region (MEDIA_REGION_PEERS), and their metadata, derivatives and HLS streams, are redirected with 307 to the deployment of that region or proxied from it, per MEDIA_REGION_MODE; 502 when a proxied region cannot be reached. With MEDIA_REPLICATION on, every region serves every file instead.",
+			Query:       []openapi.Parameter{sizeQuery, formatQuery, progressiveQuery}, Content: "application/octet-stream",
+		},
+		"PATCH /v1/files/:fileId": {
+			Summary: "Update the alt text, description, credit, license, availability or visibility of a file", Tags: []string{"files"}, Auth: true,
+			Query: []openapi.Parameter{fieldsQuery}, Body: handler.DetailsRequest{}, Response: handler.UploadResponse{},
+		},
+		"POST /v1/files/:fileId/sign": {
+			Summary: "Sign the URL of a file", Tags: []string{"files"}, Auth: true,
+			Description: "The URL grants access to the file and its derivatives whatever its visibility until it expires, at most MEDIA_DOWNLOAD_URL_TTL from now. Only callers who may see the file get one. Answered with 404 unless MEDIA_DOWNLOAD_SIGNING_KEY is set.",
+			Body:        handler.SignedURLRequest{}, Response: handler.SignedURLResponse{},
+		},
+		"POST /v1/files/:fileId/copy": {
+			Summary: "Copy a file to a storage directory as a new file", Tags: []string{"files"}, Auth: true,
+			Description: "The storage backend copies the content, with the derivatives of the file; superseded versions are not copied. The copy takes the TTL of its directory. Directories besides files and avatars are set by MEDIA_STORAGE_DIRECTORIES.",
+			Query:       []openapi.Parameter{fieldsQuery}, Body: handler.CopyRequest{}, Response: handler.UploadResponse{},
+		},
+		"POST /v1/files/:fileId/move": {
+			Summary: "Move a file to another storage directory", Tags: []string{"files"}, Auth: true,
+			Description: "The file keeps its ID and URLs and takes the TTL of its new directory, counted from the move, e.g. to promote an upload from tmp to permanent storage.",
+			Query:       []openapi.Parameter{fieldsQuery}, Body: handler.MoveRequest{}, Response: handler.UploadResponse{},
+		},
+		"GET /v1/capabilities": {
+			Summary: "Describe what the caller's organization may upload and what the service makes of it", Tags: []string{"files"},
+			Description: "API version, accepted types and size limits, enabled processing features, image formats, video renditions and the optional routes served, " +
+				"as the deployment, the plan and the settings of the organization allow. Anonymous callers get the limits of files outside organizations.",
+			Response: handler.CapabilitiesResponse{},
+		},
+		"GET /v1/files/:fileId/info": {
+			Summary: "Get the metadata of a file", Tags: []string{"files"},
+			Description: "Administrators also get the provenance of the file: the channel it came through, by whom, from what address and client, and the headers of the upload.",
+			Query:       []openapi.Parameter{fieldsQuery}, Response: handler.UploadResponse{},
+		},
+		"GET /v1/files/:fileId/preview":  {Summary: "Preview of the first page of a PDF", Tags: []string{"files"}, Content: "image/png"},
+		"GET /v1/files/:fileId/poster":   {Summary: "Poster frame of a video", Tags: []string{"files"}, Content: "image/jpeg"},
+		"GET /v1/files/:fileId/waveform": {Summary: "Waveform peaks of an audio file", Tags: []string{"files"}, Content: "application/json"},
+		"GET /v1/files/:fileId/normalized": {
+			Summary: "Loudness-normalized copy of an audio file", Tags: []string{"files"}, Content: "application/octet-stream",
+			Description: "Brought to the configured EBU R128 target, in the format of the original.",
+		},
+		"GET /v1/files/:fileId/text":     {Summary: "Text recognized in a scanned image", Tags: []string{"files"}, Content: "text/plain"},
+		"GET /v1/files/:fileId/chapters": {Summary: "Chapters of a video or audio file, for players", Tags: []string{"files"}, Response: handler.ChapterResponse{}, List: true},
+		"PUT /v1/files/:fileId/chapters": {
+			Summary: "Replace the chapters of a video or audio file; an empty list removes them", Tags: []string{"files"}, Auth: true,
+			Body: handler.ChaptersRequest{}, Response: handler.ChapterResponse{}, List: true,
+		},
+		"GET /v1/files/:fileId/teaser": {
+			Summary: "Looping animated preview of a video", Tags: []string{"files"},
+			Query:   []openapi.Parameter{{Name: "format", In: "query", Description: "gif or webp", Schema: &openapi.Schema{Type: "string"}}},
+			Content: "image/webp",
+		},
+		"GET /v1/files/:fileId/renditions/:rendition": {
+			Summary: "Download a video rendition, e.g. 720p", Tags: []string{"files"}, Content: "video/mp4",
+		},
+		"PUT /v1/files/:fileId": {
+			Summary: "Replace the content of a file", Tags: []string{"files"}, Auth: true,
+			Query: append([]openapi.Parameter{fieldsQuery, sourceHeader}, checksumHeaders...), Form: fileForm, Response: handler.UploadResponse{},
+			Description: "The current content is kept as a version; the oldest versions beyond the configured limit are pruned. Details left out are carried over.",
+		},
+		"PUT /v1/users/me/avatar": {
+			Summary: "Replace the caller's avatar", Tags: []string{"files"}, Auth: true,
+			Query: append([]openapi.Parameter{fieldsQuery, sourceHeader}, checksumHeaders...), Form: fileForm, Response: handler.UploadResponse{},
+			Description: "Takes a JPEG or PNG image through the avatar pipeline and points the named alias avatar:<user ID> at it, so /a/avatar:<user ID> always serves the current avatar. The previous avatar is deleted, or kept as a version when avatar history is enabled.",
+		},
+		"GET /v1/files/:fileId/versions": {
+			Summary: "List the versions of a file, oldest first, ending with the current one", Tags: []string{"files"}, Auth: true,
+			Response: handler.VersionResponse{}, List: true,
+		},
+		"GET /v1/files/:fileId/versions/:version": {Summary: "Download a version of a file", Tags: []string{"files"}, Auth: true, Content: "application/octet-stream"},
+		"POST /v1/files/:fileId/versions/:version/restore": {
+			Summary: "Make a version of a file its current content again", Tags: []string{"files"}, Auth: true, Response: handler.VersionResponse{},
+			Description: "The content it replaces is kept as a version in turn.",
+		},
+		"GET /v1/files/:fileId/versions/:version/diff/:other": {Summary: "Compare two versions of an image", Tags: []string{"files"}, Content: "image/png"},
+
+		"POST /v1/widgets/tokens": {
+			Summary: "Issue an upload widget token", Tags: []string{"widgets"}, Auth: true,
+			Body: handler.WidgetTokenRequest{}, Status: http.StatusCreated, Response: handler.WidgetTokenResponse{},
+		},
+		"OPTIONS /v1/widgets/upload": {Summary: "CORS preflight of widget uploads", Tags: []string{"widgets"}, Status: http.StatusNoContent},
+		"POST /v1/widgets/upload": {
+			Summary: "Upload a file with a widget token", Tags: []string{"widgets"},
+			Form: fileForm, Response: handler.UploadResponse{},
+		},
+
+		"GET /v1/files/:fileId/annotations": {Summary: "List annotations", Tags: []string{"annotations"}, Auth: true, Response: handler.AnnotationResponse{}, List: true},
+		"POST /v1/files/:fileId/annotations": {
+			Summary: "Annotate a file", Tags: []string{"annotations"}, Auth: true,
+			Body: handler.AnnotationRequest{}, Status: http.StatusCreated, Response: handler.AnnotationResponse{},
+		},
+		"GET /v1/files/:fileId/annotations/:annotationId": {Summary: "Get an annotation", Tags: []string{"annotations"}, Auth: true, Response: handler.AnnotationResponse{}},
+		"PUT /v1/files/:fileId/annotations/:annotationId": {
+			Summary: "Update an annotation", Tags: []string{"annotations"}, Auth: true,
+			Body: handler.AnnotationRequest{}, Response: handler.AnnotationResponse{},
+		},
+		"DELETE /v1/files/:fileId/annotations/:annotationId": {Summary: "Delete an annotation", Tags: []string{"annotations"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /v1/files/:fileId/aliases": {Summary: "List the aliases of a file", Tags: []string{"aliases"}, Auth: true, Response: handler.AliasResponse{}, List: true},
+		"POST /v1/files/:fileId/aliases": {
+			Summary: "Make an old ID redirect to a file", Tags: []string{"aliases"}, Auth: true,
+			Body: handler.AliasRequest{}, Status: http.StatusCreated, Response: handler.AliasResponse{},
+			Description: "The alias must be the ID of a deleted file of the same owner or organization as the file, unless the caller is an admin.",
+		},
+		"GET /v1/aliases/:aliasId": {Summary: "Get an alias", Tags: []string{"aliases"}, Auth: true, Response: handler.AliasResponse{}},
+		"PUT /v1/aliases/:aliasId": {
+			Summary: "Point a named alias such as avatar:user-123 at a file", Tags: []string{"aliases"}, Auth: true,
+			Body: handler.NamedAliasRequest{}, Response: handler.AliasResponse{},
+			Description: "Creates the alias (201), owned by the caller, or repoints it (200), which only its owner may. " +
+				"The file must be one the caller may see. Aliases of old file IDs cannot be repointed.",
+		},
+		"DELETE /v1/aliases/:aliasId": {Summary: "Delete an alias", Tags: []string{"aliases"}, Auth: true, Status: http.StatusNoContent},
+		"GET /a/:name": {
+			Summary: "Follow an alias to its file", Tags: []string{"aliases"}, Status: http.StatusFound,
+			Description: "The query is passed on to the file, e.g. ?size=64. Redirects of named aliases are not cached.",
+		},
+
+		"GET /s/:code": {
+			Summary: "Follow a short link", Tags: []string{"shortlinks"}, Status: http.StatusFound,
+			Description: "Signed links redirect to a signed URL of the file, valid until the link expires or for the longest signature allowed, whichever is sooner.",
+		},
+		"GET /v1/files/:fileId/shortlinks": {
+			Summary: "List the short links of a file", Tags: []string{"shortlinks"}, Auth: true, Response: handler.ShortLinkResponse{}, List: true,
+		},
+		"POST /v1/files/:fileId/shortlinks": {
+			Summary: "Create a short link", Tags: []string{"shortlinks"}, Auth: true,
+			Body: handler.ShortLinkRequest{}, Status: http.StatusCreated, Response: handler.ShortLinkResponse{},
+			Description: "Links with signed set share the file like a signed URL, with a signature minted on every visit; " +
+				"links to files that are not public must be signed. Signed links need a download signing key.",
+		},
+		"DELETE /v1/shortlinks/:code": {Summary: "Delete a short link", Tags: []string{"shortlinks"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /p/:orgId/:slug": {Summary: "Follow a public slug", Tags: []string{"slugs"}, Status: http.StatusFound},
+		"GET /v1/slugs":       {Summary: "List the slugs of the caller's organization", Tags: []string{"slugs"}, Auth: true, Response: handler.SlugResponse{}, List: true},
+		"PUT /v1/slugs/:slug": {
+			Summary: "Point a slug at a file", Tags: []string{"slugs"}, Auth: true,
+			Body: handler.SlugRequest{}, Response: handler.SlugResponse{},
+		},
+		"DELETE /v1/slugs/:slug": {Summary: "Delete a slug", Tags: []string{"slugs"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /p/:orgId/sitemap.xml": {Summary: "Sitemap of an organization's public collections", Tags: []string{"feeds"}, Content: "application/xml", Query: feedQuery},
+		"GET /p/:orgId/feed.xml":    {Summary: "Atom feed of an organization's newest assets", Tags: []string{"feeds"}, Content: "application/atom+xml", Query: feedQuery},
+		"GET /p/:orgId/rss.xml":     {Summary: "RSS feed of an organization's newest assets", Tags: []string{"feeds"}, Content: "application/rss+xml", Query: feedQuery},
+
+		"GET /v1/files/:fileId/hls/:name": {Summary: "HLS playlist or segment of a video", Tags: []string{"hls"}, Content: "application/vnd.apple.mpegurl"},
+		"POST /v1/files/:fileId/hls/sign": {
+			Summary: "Sign the HLS URL of a video", Tags: []string{"hls"}, Auth: true,
+			Body: handler.SignedURLRequest{}, Response: handler.SignedURLResponse{},
+		},
+
+		"GET /v1/files/:fileId/jobs": {Summary: "List the processing jobs of a file", Tags: []string{"jobs"}, Auth: true, Response: handler.JobResponse{}, List: true},
+		"GET /v1/admin/jobs": {
+			Summary: "List processing jobs of all files, by default the failed ones", Tags: []string{"jobs"}, Auth: true, Response: handler.JobResponse{}, List: true,
+			Query: []openapi.Parameter{
+				{Name: "status", In: "query", Description: "pending, running, done or failed (default)", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "kind", In: "query", Description: "Job kind, e.g. scan", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "fileId", In: "query", Schema: &openapi.Schema{Type: "string"}},
+			},
+		},
+		"POST /v1/admin/jobs/:jobId/retry": {
+			Summary: "Retry a failed job", Tags: []string{"jobs"}, Auth: true, Response: handler.JobResponse{},
+			Description: "It runs again with a full set of attempts; its last error is kept until then.",
+		},
+		"DELETE /v1/admin/jobs/:jobId": {Summary: "Purge a failed job", Tags: []string{"jobs"}, Auth: true, Status: http.StatusNoContent},
+		"POST /v1/admin/jobs/retry": {
+			Summary: "Retry failed jobs, optionally of one kind or file", Tags: []string{"jobs"}, Auth: true,
+			Body: handler.JobBatchRequest{}, Response: handler.JobBatchResponse{},
+		},
+		"POST /v1/admin/jobs/purge": {
+			Summary: "Purge failed jobs, optionally of one kind or file", Tags: []string{"jobs"}, Auth: true,
+			Body: handler.JobBatchRequest{}, Response: handler.JobBatchResponse{},
+		},
+
+		"GET /v1/collections/:collectionId/manifest": {
+			Summary: "Signed manifest of a collection", Tags: []string{"collections"}, Auth: true, Response: integrity.Manifest{},
+			Description: "Lists the files of the collection in the organization of the caller, or their own files without one; auditors and admins see all. Signed with MEDIA_MANIFEST_SIGNING_KEY in X-Manifest-Signature; refused with 503 while no key is configured.",
+		},
+		"GET /v1/collections/:collectionId/audit":  {Summary: "Last audit of a collection", Tags: []string{"collections"}, Auth: true, Response: integrity.Report{}},
+		"POST /v1/collections/:collectionId/audit": {Summary: "Audit a collection", Tags: []string{"collections"}, Auth: true, Response: integrity.Report{}},
+
+		"GET /v1/moderation/queue":                  {Summary: "List files awaiting moderation", Tags: []string{"moderation"}, Auth: true},
+		"GET /v1/moderation/files/:fileId/preview":  {Summary: "Preview a quarantined file", Tags: []string{"moderation"}, Auth: true, Content: "application/octet-stream"},
+		"POST /v1/moderation/files/:fileId/flag":    {Summary: "Quarantine a file", Tags: []string{"moderation"}, Auth: true, Status: http.StatusNoContent},
+		"POST /v1/moderation/files/:fileId/approve": {Summary: "Release a quarantined file", Tags: []string{"moderation"}, Auth: true, Status: http.StatusNoContent},
+		"POST /v1/moderation/files/:fileId/reject":  {Summary: "Delete a quarantined file", Tags: []string{"moderation"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /v1/takedowns":               {Summary: "List taken down files", Tags: []string{"takedowns"}, Auth: true, Response: handler.TakedownResponse{}, List: true},
+		"GET /v1/takedowns/files/:fileId": {Summary: "Get the takedown state and history of a file", Tags: []string{"takedowns"}, Auth: true, Response: handler.TakedownResponse{}},
+		"POST /v1/takedowns/files/:fileId": {
+			Summary: "Take down a file under a copyright claim", Tags: []string{"takedowns"}, Auth: true,
+			Body: handler.TakedownRequest{}, Status: http.StatusCreated, Response: handler.TakedownResponse{},
+		},
+		"POST /v1/takedowns/files/:fileId/counter-notice": {
+			Summary: "Record a counter-notice to a takedown", Tags: []string{"takedowns"}, Auth: true,
+			Body: handler.CounterNoticeRequest{}, Response: handler.TakedownResponse{},
+		},
+		"POST /v1/takedowns/files/:fileId/restore": {Summary: "Restore a taken down file", Tags: []string{"takedowns"}, Auth: true, Response: handler.TakedownResponse{}},
+
+		"GET /v1/admin/scrub": {Summary: "Scrubber progress", Tags: []string{"admin"}, Auth: true, Response: integrity.ScrubStatus{}},
+		"GET /v1/admin/gc":    {Summary: "Report of the latest garbage collection", Tags: []string{"admin"}, Auth: true, Response: integrity.GCReport{}},
+		"POST /v1/admin/gc": {
+			Summary: "Collect orphaned blobs now", Tags: []string{"admin"}, Auth: true, Response: integrity.GCReport{},
+			Description: "Removes stored blobs no file metadata refers to and reports files whose blob is missing. Blobs younger than MEDIA_GC_MIN_AGE are kept.",
+			Query: []openapi.Parameter{
+				{Name: "dryRun", In: "query", Description: "Only report orphaned blobs", Schema: &openapi.Schema{Type: "boolean"}},
+			},
+		},
+		"GET /v1/admin/cron": {
+			Summary: "Scheduled tasks with their last and next runs", Tags: []string{"admin"}, Auth: true, Response: cron.Status{}, List: true,
+			Description: "Audit, scrub, gc, availability, expiry, retention and offboarding run on the schedules set by MEDIA_CRON_<TASK>. A run due while the previous one of the same task is still going is skipped.",
+		},
+		"GET /v1/admin/stats": {
+			Summary: "Traffic and processing statistics", Tags: []string{"admin"}, Auth: true, Response: stats.Summary{},
+			Query: []openapi.Parameter{
+				{Name: "window", In: "query", Description: "Duration to summarize, e.g. 1h", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "bucket", In: "query", Description: "Bucket width, e.g. 5m", Schema: &openapi.Schema{Type: "string"}},
+			},
+		},
+		"GET /v1/admin/brandings":        {Summary: "List organization brandings", Tags: []string{"admin"}, Auth: true, Response: handler.BrandingResponse{}, List: true},
+		"GET /v1/admin/brandings/:orgId": {Summary: "Get the branding of an organization", Tags: []string{"admin"}, Auth: true, Response: handler.BrandingResponse{}},
+		"PUT /v1/admin/brandings/:orgId": {
+			Summary: "Set the branding of an organization", Tags: []string{"admin"}, Auth: true,
+			Body: handler.BrandingRequest{}, Response: handler.BrandingResponse{},
+		},
+		"DELETE /v1/admin/brandings/:orgId": {Summary: "Delete the branding of an organization", Tags: []string{"admin"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /v1/admin/feeds":        {Summary: "List organization feeds", Tags: []string{"admin"}, Auth: true, Response: handler.FeedResponse{}, List: true},
+		"GET /v1/admin/feeds/:orgId": {Summary: "Get the feed settings of an organization", Tags: []string{"admin"}, Auth: true, Response: handler.FeedResponse{}},
+		"PUT /v1/admin/feeds/:orgId": {
+			Summary: "Publish the public collections of an organization", Tags: []string{"admin"}, Auth: true,
+			Description: "Serves a sitemap and Atom and RSS feeds of the collections under /p/{orgId}/.",
+			Body:        handler.FeedRequest{}, Response: handler.FeedResponse{},
+		},
+		"DELETE /v1/admin/feeds/:orgId": {Summary: "Stop publishing the feeds of an organization", Tags: []string{"admin"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /v1/admin/quotas":        {Summary: "List organization quotas", Tags: []string{"admin"}, Auth: true, Response: handler.QuotaResponse{}, List: true},
+		"GET /v1/admin/quotas/:orgId": {Summary: "Get the quota of an organization", Tags: []string{"admin"}, Auth: true, Response: handler.QuotaResponse{}},
+		"PUT /v1/admin/quotas/:orgId": {
+			Summary: "Set the storage and file count quota of an organization", Tags: []string{"admin"}, Auth: true,
+			Description: "Limits of 0 fall back to the defaults and negative limits lift them. Uploads over a limit are answered with 403.",
+			Body:        handler.QuotaRequest{}, Response: handler.QuotaResponse{},
+		},
+		"DELETE /v1/admin/quotas/:orgId": {Summary: "Return an organization to the default quota", Tags: []string{"admin"}, Auth: true, Status: http.StatusNoContent},
+		"GET /v1/orgs/:orgId/usage": {
+			Summary: "Report what an organization stores and processed", Tags: []string{"admin"}, Auth: true,
+			Description: "Counts the files and bytes of originals an organization stores, by content type, against its limits, and totals the CPU time and scratch space its processing jobs used, by month and job kind. Organizations on a plan get its limits unless they have a quota of their own; plan is left out without one. readOnly is set once a limit is reached; reaching it sends a media.quota_exceeded event to the organization's and the platform's webhooks.",
+			Response:    handler.UsageResponse{},
+		},
+
+		"GET /v1/admin/orgs": {Summary: "List organizations", Tags: []string{"admin"}, Auth: true, Response: handler.OrgResponse{}, List: true},
+		"POST /v1/admin/orgs": {
+			Summary: "Provision an organization", Tags: []string{"admin"}, Auth: true, Status: http.StatusCreated,
+			Description: "Records the organization with its default collections and sets up its quota, a feed of the collections and a geo rule for each of them, if given.",
+			Body:        handler.OrgRequest{}, Response: handler.OrgResponse{},
+		},
+		"GET /v1/admin/orgs/:orgId": {Summary: "Get an organization", Tags: []string{"admin"}, Auth: true, Response: handler.OrgResponse{}},
+		"POST /v1/admin/orgs/:orgId/offboard": {
+			Summary: "Offboard an organization", Tags: []string{"admin"}, Auth: true, Status: http.StatusAccepted,
+			Description: "Refuses further uploads and exports the files to a ZIP archive with a manifest, stored as a file of the caller. Once the grace period ends the files, slugs, quota, feed, branding and geo rules of the organization are deleted; the archive is kept for another grace period. Defaults to MEDIA_OFFBOARDING_GRACE.",
+			Body:        handler.OffboardRequest{}, Response: handler.OrgResponse{},
+		},
+		"DELETE /v1/admin/orgs/:orgId/offboard": {Summary: "Cancel the offboarding of an organization", Tags: []string{"admin"}, Auth: true, Response: handler.OrgResponse{}},
+		"GET /v1/orgs/:orgId/settings": {
+			Summary: "Get the self-service settings of an organization", Tags: []string{"orgs"}, Auth: true, Response: handler.SettingsResponse{},
+			Description: "For tokens of the organization with the org:admin permission, or files:admin.",
+		},
+		"PATCH /v1/orgs/:orgId/settings": {
+			Summary: "Change the self-service settings of an organization", Tags: []string{"orgs"}, Auth: true,
+			Description: "Settings left out are unchanged. Only the settings MEDIA_ORG_SETTINGS permits may be changed. File events of the organization are delivered to its webhook URLs, which must be https on public addresses and are not redirected, signed with its webhook secret. Uploads of types outside allowedTypes are answered with 415. Uploads that ask for no visibility take defaultVisibility. A logo must be a file of the organization. Changes are recorded in the audit log as org.settings.",
+			Body:        handler.SettingsRequest{}, Response: handler.SettingsResponse{},
+		},
+
+		"GET /v1/admin/geo-rules":               {Summary: "List collection geo rules", Tags: []string{"admin"}, Auth: true, Response: handler.GeoRuleResponse{}, List: true},
+		"GET /v1/admin/geo-rules/:collectionId": {Summary: "Get the geo rule of a collection", Tags: []string{"admin"}, Auth: true, Response: handler.GeoRuleResponse{}},
+		"PUT /v1/admin/geo-rules/:collectionId": {
+			Summary: "Restrict the countries a collection is served to", Tags: []string{"admin"}, Auth: true,
+			Description: "Countries are ISO 3166-1 alpha-2 codes. Downloads from other countries are answered with 451.",
+			Body:        handler.GeoRuleRequest{}, Response: handler.GeoRuleResponse{},
+		},
+		"DELETE /v1/admin/geo-rules/:collectionId": {Summary: "Delete the geo rule of a collection", Tags: []string{"admin"}, Auth: true, Status: http.StatusNoContent},
+
+		"GET /v1/admin/presets":       {Summary: "List transcode presets", Tags: []string{"admin"}, Auth: true, Response: handler.PresetResponse{}, List: true},
+		"GET /v1/admin/presets/:name": {Summary: "Get the latest version of a transcode preset", Tags: []string{"admin"}, Auth: true, Response: handler.PresetResponse{}},
+		"PUT /v1/admin/presets/:name": {
+			Summary: "Store a new version of a transcode preset", Tags: []string{"admin"}, Auth: true,
+			Description: "Sets the codec (h264 or h265), bit rate ladder and audio bit rate videos of the listed collections are transcoded with. Rungs without a bit rate are encoded at constant quality. Existing renditions are not transcoded again; they record the preset version they were made with.",
+			Body:        handler.PresetRequest{}, Response: handler.PresetResponse{},
+		},
+		"DELETE /v1/admin/presets/:name":                {Summary: "Retire a transcode preset", Tags: []string{"admin"}, Auth: true, Status: http.StatusNoContent, Description: "Its collections are transcoded with the defaults again. Earlier versions are kept."},
+		"GET /v1/admin/presets/:name/versions":          {Summary: "List every version of a transcode preset", Tags: []string{"admin"}, Auth: true, Response: handler.PresetResponse{}, List: true},
+		"GET /v1/admin/presets/:name/versions/:version": {Summary: "Get a version of a transcode preset", Tags: []string{"admin"}, Auth: true, Response: handler.PresetResponse{}},
+
+		"GET /v1/admin/licenses/expiring": {
+			Summary: "List files whose license expired or expires soon", Tags: []string{"admin"}, Auth: true, Response: handler.LicenseResponse{}, List: true,
+			Query: []openapi.Parameter{
+				{Name: "within", In: "query", Description: "How far ahead to look, e.g. 720h", Schema: &openapi.Schema{Type: "string"}},
+			},
+		},
+
+		"GET /v1/admin/audit-log": {
+			Summary: "List recorded changes to files, newest first", Tags: []string{"admin"}, Auth: true, Response: handler.AuditEntryResponse{}, List: true,
+			Query: []openapi.Parameter{
+				{Name: "fileId", In: "query", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "actorId", In: "query", Description: "User who made the change", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "action", In: "query", Description: "e.g. file.upload or file.delete", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "since", In: "query", Description: "RFC 3339 time, inclusive", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+				{Name: "until", In: "query", Description: "RFC 3339 time, exclusive", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+				{Name: "limit", In: "query", Description: "At most 1000; 100 by default", Schema: &openapi.Schema{Type: "integer"}},
+			},
+		},
+
+		"POST /v1/admin/prewarm": {
+			Summary: "Prewarm the CDN with files", Tags: []string{"admin"}, Auth: true, Status: http.StatusAccepted,
+			Description: "Fetches the public URLs of the listed files and of the files of a collection, and the variants under them, through every edge of MEDIA_CDN_EDGES and/or has the provider prefetch them via MEDIA_CDN_PREFETCH_URL, in the background. " +
+				"Files that are not public are skipped, as the CDN does not cache them. Answers with the run, whose progress GET /v1/admin/prewarm/{runId} reports; 501 when no CDN is configured.",
+			Body: handler.PrewarmRequest{}, Response: cdn.Run{},
+		},
+		"GET /v1/admin/prewarm":           {Summary: "Recent CDN prewarm runs, newest first", Tags: []string{"admin"}, Auth: true, Response: cdn.Run{}, List: true},
+		"GET /v1/admin/prewarm/:runId":    {Summary: "Progress of a CDN prewarm run", Tags: []string{"admin"}, Auth: true, Response: cdn.Run{}},
+		"DELETE /v1/admin/prewarm/:runId": {Summary: "Cancel a CDN prewarm run", Tags: []string{"admin"}, Auth: true, Response: cdn.Run{}},
+
+		"GET /v1/admin/debug/runtime": {Summary: "Goroutine, heap and GC statistics", Tags: []string{"admin"}, Auth: true, Response: tuning.Stats{}},
+		"GET /v1/admin/debug/pprof/*profile": {
+			Summary: "Go profiler", Description: "net/http/pprof: the index, or the named profile such as heap, goroutine or profile?seconds=30.",
+			Tags: []string{"admin"}, Auth: true, Content: "application/octet-stream",
+		},
+		"POST /v1/admin/debug/pprof/*profile": {Summary: "Look up profiler symbols", Tags: []string{"admin"}, Auth: true, Content: "text/plain"},
+
+		"PUT /v1/replication/files/:fileId": {
+			Summary: "Apply a write to a file replicated by a peer region", Tags: []string{"replication"},
+			Description: "Called by peer regions with MEDIA_REPLICATION on, signed with MEDIA_REPLICATION_SECRET in the X-Media-Replication-* headers instead of a token; 401 otherwise, and for replayed requests. " +
+				"Content that differs from the local one is fetched from the sender first; 502 when it cannot be. " +
+				"Writes older than the local state are ignored; conflicting writes are settled per MEDIA_REPLICATION_CONFLICTS, last writer wins (lww) or by version vectors (vector).",
+			Body: replication.Change{}, Response: replication.Outcome{},
+		},
+		"GET /v1/replication/files/:fileId/content": {
+			Summary: "Content of a file for a peer region replicating it", Tags: []string{"replication"}, Content: "application/octet-stream",
+			Description: "Signed like PUT /v1/replication/files/{fileId}.",
+		},
+		"GET /v1/replication/health": {
+			Summary: "Replication health", Tags: []string{"replication"}, Response: handler.ReplicationStatusResponse{},
+			Description: "Writes waiting for each peer region and the age of the oldest; 503 while any peer lags behind by more than MEDIA_REPLICATION_MAX_LAG. " +
+				"Signed like PUT /v1/replication/files/{fileId}, or with a token of files:admin; 401 otherwise.",
+		},
+		"GET /v1/admin/replication": {Summary: "Replication status of each peer region", Tags: []string{"replication"}, Auth: true, Response: handler.ReplicationStatusResponse{}},
+		"POST /v1/admin/replication/resync": {
+			Summary: "Queue every file for every peer region", Tags: []string{"replication"}, Auth: true, Status: http.StatusAccepted,
+			Description: "For files stored before replication was turned on, or after a peer was replaced. Writes are replicated asynchronously; see GET /v1/admin/replication for progress.",
+		},
+
+		"GET /openapi.json": {Summary: "This document", Tags: []string{"docs"}, Content: "application/json"},
+		"GET /docs":         {Summary: "Swagger UI", Tags: []string{"docs"}, Content: "text/html"},
+	}
+
+	// The unversioned file routes behave like their /v1 counterparts.
+	for key, spec := range specs {
+		method, path, _ := strings.Cut(key, " ")
+		if strings.HasPrefix(path, "/v1/files") {
+			spec.Deprecated = true
+			specs[method+" "+strings.TrimPrefix(path, "/v1")] = spec
+		}
+	}
+	return specs
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/config"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/middleware"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+	"github.com/ondrasimku/media-service-go/internal/widget"
+)
+
+// filesFeature serves uploads and downloads with their renditions, file
+// versions, avatars, the upload widget and the capabilities clients adapt
+// to.
+type filesFeature struct{}
+
+func (filesFeature) Register(router *gin.Engine, deps *Deps) {
+	cfg, logger := deps.Config, deps.Logger
+	uploadHandler := handler.NewUploadHandler(deps.Uploads, deps.Files, deps.Metadata, logger)
+
+	v1 := router.Group("/v1")
+	registerFileRoutes(v1, uploadHandler, deps.Auth, deps.OptionalAuth, deps.HomeRegion, deps.Stats)
+
+	if deps.Enabled("versions") {
+		versionHandler := handler.NewVersionHandler(deps.Files, deps.Uploads, logger)
+		v1.PUT("/files/:fileId", deps.Auth, middleware.GCPauses(), middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.Replace)
+		versionRoutes := v1.Group("/files/:fileId/versions")
+		versionRoutes.Use(deps.Auth)
+		{
+			versionRoutes.GET("", versionHandler.List)
+			versionRoutes.GET("/:version", versionHandler.Get)
+			versionRoutes.POST("/:version/restore", middleware.RequirePermissions([]string{"files:upload"}), versionHandler.Restore)
+			versionRoutes.GET("/:version/diff/:other", versionHandler.Diff)
+		}
+	}
+
+	if deps.Enabled("avatars") {
+		v1.PUT("/users/me/avatar", deps.Auth, middleware.GCPauses(), middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.PutAvatar)
+	}
+
+	if cfg.Widget.Secret != "" && deps.Enabled("widgets") {
+		widgetHandler := handler.NewWidgetHandler(
+			widget.NewIssuer(cfg.Widget.Secret),
+			widget.NewLimiter(cfg.Widget.RatePerMinute, cfg.Widget.Burst),
+			uploadHandler, cfg.Widget.MaxTokenTTL, logger)
+
+		widgetRoutes := v1.Group("/widgets")
+		{
+			widgetRoutes.POST("/tokens", deps.Auth, middleware.RequirePermissions([]string{"files:widget"}), widgetHandler.CreateToken)
+			widgetRoutes.OPTIONS("/upload", widgetHandler.Preflight)
+			widgetRoutes.POST("/upload", middleware.GCPauses(), widgetHandler.Upload)
+		}
+	}
+
+	var routes []string
+	for _, group := range config.RouteGroups {
+		if deps.Enabled(group) && (group != "widgets" || cfg.Widget.Secret != "") {
+			routes = append(routes, group)
+		}
+	}
+	capabilitiesHandler := handler.NewCapabilitiesHandler(deps.Uploads, routes, logger)
+	v1.GET("/capabilities", deps.OptionalAuth, capabilitiesHandler.Get)
+
+	// Unversioned paths are kept as aliases of v1 until the sunset date.
+	if deps.Enabled("legacy") {
+		legacy := router.Group("", middleware.Deprecated("/v1", cfg.API.LegacySunset))
+		registerFileRoutes(legacy, uploadHandler, deps.Auth, deps.OptionalAuth, deps.HomeRegion, deps.Stats)
+	}
+}
+
+func registerFileRoutes(rg *gin.RouterGroup, uploadHandler *handler.UploadHandler, authMiddleware, optionalAuth, homeRegion gin.HandlerFunc, recorder *stats.Recorder) {
+	// Public files are served to anyone; others to callers who may see
+	// them or with a signed URL. Files homed in another region are served
+	// by its deployment, which checks access itself.
+	downloads := rg.Group("/files/:fileId")
+	downloads.Use(homeRegion, optionalAuth, uploadHandler.Authorize)
+	{
+		downloads.GET("", middleware.CountResponses(recorder, stats.Downloads), uploadHandler.GetFile)
+		downloads.GET("/info", uploadHandler.GetFileInfo)
+		downloads.GET("/preview", uploadHandler.GetPreview)
+		downloads.GET("/poster", uploadHandler.GetPoster)
+		downloads.GET("/teaser", uploadHandler.GetTeaser)
+		downloads.GET("/waveform", uploadHandler.GetWaveform)
+		downloads.GET("/normalized", uploadHandler.GetNormalized)
+		downloads.GET("/text", uploadHandler.GetText)
+		downloads.GET("/chapters", uploadHandler.GetChapters)
+		downloads.GET("/renditions/:rendition", middleware.CountResponses(recorder, stats.Downloads), uploadHandler.GetRendition)
+	}
+
+	fileRoutes := rg.Group("/files")
+	fileRoutes.Use(authMiddleware)
+	{
+		fileRoutes.GET("", uploadHandler.ListFiles)
+		fileRoutes.POST("", middleware.GCPauses(), middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.Upload)
+		fileRoutes.DELETE("/:fileId", middleware.RequirePermissions([]string{"files:delete"}), uploadHandler.DeleteFile)
+		fileRoutes.PATCH("/:fileId", middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.UpdateDetails)
+		fileRoutes.PUT("/:fileId/chapters", middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.PutChapters)
+		fileRoutes.POST("/:fileId/copy", middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.Copy)
+		fileRoutes.POST("/:fileId/move", middleware.RequirePermissions([]string{"files:upload"}), uploadHandler.Move)
+		fileRoutes.POST("/:fileId/sign", middleware.RequirePermissions([]string{"files:share"}), uploadHandler.Sign)
+	}
+}
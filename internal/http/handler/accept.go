@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// mediaRange is one entry of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses the media ranges of an Accept header with their
+// quality values; ranges that do not parse are skipped.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+	return ranges
+}
+
+// quality returns the quality value the most specific of ranges matching
+// contentType gives it, and whether any matches. With exact, wildcard
+// ranges do not match.
+func quality(ranges []mediaRange, contentType string, exact bool) (float64, bool) {
+	typ, subtype, _ := strings.Cut(strings.ToLower(contentType), "/")
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case exact:
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q, specificity >= 0
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/cron"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/integrity"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+)
+
+type AdminHandler struct {
+	scrubber  *integrity.Scrubber
+	collector *integrity.Collector
+	scheduler *cron.Scheduler
+	stats     *stats.Recorder
+	logger    *slog.Logger
+}
+
+func NewAdminHandler(scrubber *integrity.Scrubber, collector *integrity.Collector, scheduler *cron.Scheduler, stats *stats.Recorder, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		scrubber:  scrubber,
+		collector: collector,
+		scheduler: scheduler,
+		stats:     stats,
+		logger:    logger,
+	}
+}
+
+// ScrubStatus reports scrubber progress and the blobs it found corrupt.
+func (h *AdminHandler) ScrubStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.scrubber.Status())
+}
+
+// LastGC reports the latest garbage collection.
+func (h *AdminHandler) LastGC(c *gin.Context) {
+	report, ok := h.collector.Last()
+	if !ok {
+		problem.Abort(c, http.StatusNotFound, "No garbage collection yet", "")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// CollectGarbage reconciles storage with metadata now. With ?dryRun=true
+// orphaned blobs are only reported.
+func (h *AdminHandler) CollectGarbage(c *gin.Context) {
+	var dryRun bool
+	if v := c.Query("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			problem.Abort(c, http.StatusBadRequest, "Invalid dryRun", "dryRun must be true or false")
+			return
+		}
+	}
+	if !h.collector.Supported() {
+		problem.Abort(c, http.StatusNotImplemented, "Garbage collection not supported", "The storage backend cannot list its blobs")
+		return
+	}
+
+	report, err := h.collector.Collect(c.Request.Context(), dryRun)
+	if errors.Is(err, integrity.ErrCollecting) {
+		problem.Abort(c, http.StatusConflict, "Garbage collection already running", "")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Garbage collection failed", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Garbage collection failed", "")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// Cron reports the scheduled tasks: their schedules, last and next runs.
+func (h *AdminHandler) Cron(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"items": h.scheduler.Status()})
+}
+
+// Stats summarizes uploads, downloads, server errors and image processing
+// jobs over the last ?window= (default 1h), split into ?bucket= wide
+// buckets (default 5m).
+func (h *AdminHandler) Stats(c *gin.Context) {
+	window, err := durationQuery(c, "window", time.Hour)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid window", err.Error())
+		return
+	}
+	bucket, err := durationQuery(c, "bucket", 5*time.Minute)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid bucket", err.Error())
+		return
+	}
+	if window > h.stats.Retention() {
+		problem.Abort(c, http.StatusBadRequest, "Invalid window", "Statistics are kept for "+h.stats.Retention().String())
+		return
+	}
+	if bucket < stats.Resolution || bucket > window {
+		problem.Abort(c, http.StatusBadRequest, "Invalid bucket", "bucket must be between "+stats.Resolution.String()+" and the window")
+		return
+	}
+
+	c.JSON(http.StatusOK, h.stats.Summarize(time.Now().UTC(), window, bucket))
+}
+
+func durationQuery(c *gin.Context, name string, defaultValue time.Duration) (time.Duration, error) {
+	v := c.Query(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	return d, nil
+}
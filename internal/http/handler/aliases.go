@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+// aliasNamePattern restricts named aliases to keys that are safe in a URL
+// path, such as avatar:user-123. Unlike file IDs they may contain ':'.
+var aliasNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+type AliasHandler struct {
+	metadata metadata.Store
+	aliases  metadata.AliasStore
+	files    *media.FileService
+	storage  storage.Storage
+	audit    *audit.Trail
+	logger   *slog.Logger
+}
+
+func NewAliasHandler(metadata metadata.Store, aliases metadata.AliasStore, files *media.FileService, storage storage.Storage, trail *audit.Trail, logger *slog.Logger) *AliasHandler {
+	return &AliasHandler{
+		metadata: metadata,
+		aliases:  aliases,
+		files:    files,
+		storage:  storage,
+		audit:    trail,
+		logger:   logger,
+	}
+}
+
+type AliasRequest struct {
+	Alias     string `json:"alias"`
+	Permanent *bool  `json:"permanent"`
+	Reason    string `json:"reason"`
+}
+
+// NamedAliasRequest points a named alias at a file.
+type NamedAliasRequest struct {
+	FileID string `json:"fileId"`
+}
+
+type AliasResponse struct {
+	Alias          string     `json:"alias"`
+	FileID         string     `json:"fileId"`
+	Permanent      bool       `json:"permanent"`
+	Named          bool       `json:"named,omitempty"`
+	OwnerID        string     `json:"ownerId,omitempty"`
+	OrgID          string     `json:"orgId,omitempty"`
+	Reason         string     `json:"reason,omitempty"`
+	PreviousFileID string     `json:"previousFileId,omitempty"`
+	CreatedBy      string     `json:"createdBy,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedBy      string     `json:"updatedBy,omitempty"`
+	UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+}
+
+func newAliasResponse(a domain.Alias) AliasResponse {
+	r := AliasResponse{
+		Alias:          a.ID,
+		FileID:         a.TargetID,
+		Permanent:      a.Permanent,
+		Named:          a.Named,
+		OwnerID:        a.OwnerID,
+		OrgID:          a.OrgID,
+		Reason:         a.Reason,
+		PreviousFileID: a.PreviousTargetID,
+		CreatedBy:      a.CreatedBy,
+		CreatedAt:      a.CreatedAt,
+		UpdatedBy:      a.UpdatedBy,
+	}
+	if !a.UpdatedAt.IsZero() {
+		r.UpdatedAt = &a.UpdatedAt
+	}
+	return r
+}
+
+func (h *AliasHandler) List(c *gin.Context) {
+	fileID := c.Param("fileId")
+	_, err := h.files.Info(c.Request.Context(), fileID)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+
+	aliases, err := h.aliases.ListAliases(c.Request.Context(), fileID)
+	if err != nil {
+		h.logger.Error("Failed to list aliases", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list aliases", "")
+		return
+	}
+
+	items := make([]AliasResponse, 0, len(aliases))
+	for _, a := range aliases {
+		items = append(items, newAliasResponse(a))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// Create makes an old ID redirect to a file the caller owns. The alias
+// must not be the ID of a live file, and unless the caller is an admin it
+// must be the ID of a deleted file of the same owner or organization, so
+// that links to files of others cannot be taken over.
+func (h *AliasHandler) Create(c *gin.Context) {
+	ctx := c.Request.Context()
+	fileID := c.Param("fileId")
+	meta, ok := ownedFile(c, h.files, fileID)
+	if !ok {
+		return
+	}
+
+	var req AliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	if !media.ValidFileID(req.Alias) {
+		problem.Abort(c, http.StatusBadRequest, "Invalid alias", "Aliases are up to 128 letters, digits, '.', '_' and '-'")
+		return
+	}
+	if req.Alias == fileID {
+		problem.Abort(c, http.StatusBadRequest, "Invalid alias", "A file cannot be an alias of itself")
+		return
+	}
+	if _, err := h.metadata.Get(ctx, req.Alias); err == nil {
+		problem.Abort(c, http.StatusConflict, "Alias conflicts with an existing file", "")
+		return
+	}
+	if _, err := h.aliases.GetAlias(ctx, req.Alias); err == nil {
+		problem.Abort(c, http.StatusConflict, "Alias already exists", "")
+		return
+	}
+	if authCtx, _ := auth.GetAuthContext(c); !authCtx.HasPermission("files:admin") && !h.formerFile(ctx, req.Alias, meta) {
+		problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Only IDs of deleted files of the same owner or organization may be aliased")
+		return
+	}
+
+	alias := domain.Alias{
+		ID:        req.Alias,
+		TargetID:  fileID,
+		Permanent: req.Permanent == nil || *req.Permanent,
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedBy: callerID(c),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.aliases.PutAlias(ctx, alias); err != nil {
+		h.logger.Error("Failed to store alias", "alias", alias.ID, "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store alias", "")
+		return
+	}
+	h.audit.Record(ctx, domain.AuditAliasCreate, fileID, map[string]string{"alias": alias.ID})
+
+	// Redirects resolve in a single hop, so aliases that pointed at the
+	// old ID are moved over to the new target.
+	if old, err := h.aliases.ListAliases(ctx, req.Alias); err == nil {
+		for _, a := range old {
+			a.TargetID = fileID
+			if err := h.aliases.PutAlias(ctx, a); err != nil {
+				h.logger.Error("Failed to repoint alias", "alias", a.ID, "fileId", fileID, "error", err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusCreated, newAliasResponse(alias))
+}
+
+// formerFile reports whether id is the ID of a deleted file that belonged
+// to the owner or organization of target, by the audit record of its
+// deletion.
+func (h *AliasHandler) formerFile(ctx context.Context, id string, target domain.FileMetadata) bool {
+	entries, err := h.audit.List(ctx, metadata.AuditFilter{FileID: id, Action: domain.AuditDelete}, 1)
+	if err != nil {
+		h.logger.Warn("Failed to read deletion of aliased file", "alias", id, "error", err)
+		return false
+	}
+	if len(entries) == 0 {
+		return false
+	}
+	deleted := entries[0].Details
+	return deleted["ownerId"] == target.OwnerID || target.OrgID != "" && deleted["orgId"] == target.OrgID
+}
+
+func (h *AliasHandler) Get(c *gin.Context) {
+	alias, err := h.aliases.GetAlias(c.Request.Context(), c.Param("aliasId"))
+	if errors.Is(err, metadata.ErrNotFound) {
+		problem.Abort(c, http.StatusNotFound, "Alias not found", "")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read alias", "alias", c.Param("aliasId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to read alias", "")
+		return
+	}
+	c.JSON(http.StatusOK, newAliasResponse(alias))
+}
+
+// Put points a named alias at a file the caller may see, creating the
+// alias if needed. Only the owner of an alias may repoint it, and the
+// avatar alias of a user is theirs. Aliases of old IDs cannot be repointed
+// this way.
+func (h *AliasHandler) Put(c *gin.Context) {
+	ctx := c.Request.Context()
+	name := c.Param("aliasId")
+	if !aliasNamePattern.MatchString(name) {
+		problem.Abort(c, http.StatusBadRequest, "Invalid alias", "Named aliases are up to 128 letters, digits, '.', '_', '-' and ':'")
+		return
+	}
+	authCtx, _ := auth.GetAuthContext(c)
+	if strings.HasPrefix(name, media.AvatarAlias("")) && name != media.AvatarAlias(authCtx.UserID) && !authCtx.HasPermission("files:admin") {
+		problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Avatar aliases belong to their user")
+		return
+	}
+
+	var req NamedAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	meta, err := h.files.Info(ctx, req.FileID)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+	if _, err := h.metadata.Get(ctx, name); err == nil {
+		problem.Abort(c, http.StatusConflict, "Alias conflicts with an existing file", "")
+		return
+	}
+
+	now := time.Now().UTC()
+	caller := authCtx.UserID
+	status := http.StatusOK
+	alias, err := h.aliases.GetAlias(ctx, name)
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		status = http.StatusCreated
+		alias = domain.Alias{ID: name, Named: true, CreatedBy: caller, CreatedAt: now, OwnerID: caller}
+		if authCtx.OrgID != nil {
+			alias.OrgID = *authCtx.OrgID
+		}
+	case err != nil:
+		h.logger.Error("Failed to read alias", "alias", name, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store alias", "")
+		return
+	case !alias.Named:
+		problem.Abort(c, http.StatusConflict, "Alias already exists", "The alias keeps an old file ID resolving and cannot be repointed")
+		return
+	case !ownsAlias(authCtx, alias):
+		problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Only the owner of the alias may repoint it")
+		return
+	case alias.TargetID == meta.ID:
+		c.JSON(http.StatusOK, newAliasResponse(alias))
+		return
+	default:
+		alias.PreviousTargetID = alias.TargetID
+		alias.UpdatedBy = caller
+		alias.UpdatedAt = now
+	}
+	alias.TargetID = meta.ID
+
+	if err := h.aliases.PutAlias(ctx, alias); err != nil {
+		h.logger.Error("Failed to store alias", "alias", name, "fileId", meta.ID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store alias", "")
+		return
+	}
+	details := map[string]string{"alias": name}
+	if alias.PreviousTargetID != "" {
+		details["previousFileId"] = alias.PreviousTargetID
+	}
+	h.audit.Record(ctx, domain.AuditAliasSet, meta.ID, details)
+	c.JSON(status, newAliasResponse(alias))
+}
+
+// Resolve redirects an alias to the file it points at, keeping the query
+// so that e.g. ?size= applies to the file. Named aliases are repointed, so
+// their redirects are temporary and not cached.
+func (h *AliasHandler) Resolve(c *gin.Context) {
+	ctx := c.Request.Context()
+	alias, err := h.aliases.GetAlias(ctx, c.Param("name"))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "Not found", "")
+		return
+	}
+	if meta, err := h.metadata.Get(ctx, alias.TargetID); err != nil || !meta.Servable() {
+		problem.Abort(c, http.StatusNotFound, "Not found", "")
+		return
+	}
+
+	location := h.storage.URL(alias.TargetID)
+	if c.Request.URL.RawQuery != "" {
+		location += "?" + c.Request.URL.RawQuery
+	}
+	status := http.StatusFound
+	if alias.Permanent {
+		status = http.StatusMovedPermanently
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+	c.Redirect(status, location)
+}
+
+// Delete removes a named alias the caller owns, or an alias of an old ID
+// of a file the caller owns. Administrators may delete any alias.
+func (h *AliasHandler) Delete(c *gin.Context) {
+	ctx := c.Request.Context()
+	alias, err := h.aliases.GetAlias(ctx, c.Param("aliasId"))
+	if errors.Is(err, metadata.ErrNotFound) {
+		problem.Abort(c, http.StatusNotFound, "Alias not found", "")
+		return
+	}
+	if err == nil {
+		authCtx, _ := auth.GetAuthContext(c)
+		switch {
+		case authCtx.HasPermission("files:admin"):
+		case alias.Named:
+			if !ownsAlias(authCtx, alias) {
+				problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Only the owner of the alias may delete it")
+				return
+			}
+		default:
+			if _, ok := ownedFile(c, h.files, alias.TargetID); !ok {
+				return
+			}
+		}
+		err = h.aliases.DeleteAlias(ctx, alias.ID)
+	}
+	if errors.Is(err, metadata.ErrNotFound) {
+		problem.Abort(c, http.StatusNotFound, "Alias not found", "")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete alias", "alias", c.Param("aliasId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete alias", "")
+		return
+	}
+	h.audit.Record(ctx, domain.AuditAliasDelete, alias.TargetID, map[string]string{"alias": alias.ID})
+	c.Status(http.StatusNoContent)
+}
+
+// ownsAlias reports whether the caller may repoint or delete a named
+// alias: its owner, or the user of an avatar alias. Other aliases from
+// before owners were recorded are left to admins.
+func ownsAlias(authCtx *auth.AuthContext, alias domain.Alias) bool {
+	if authCtx.HasPermission("files:admin") || alias.ID == media.AvatarAlias(authCtx.UserID) {
+		return true
+	}
+	return alias.OwnerID != "" && alias.OwnerID == authCtx.UserID
+}
+
+// redirectAlias answers a request for an unknown file ID with a redirect to
+// the canonical file if the ID is an alias. It reports whether it did.
+func redirectAlias(c *gin.Context, aliases metadata.AliasStore, fileID string) bool {
+	if aliases == nil {
+		return false
+	}
+	alias, err := aliases.GetAlias(c.Request.Context(), fileID)
+	if err != nil {
+		return false
+	}
+
+	location := *c.Request.URL
+	location.Path = strings.Replace(location.Path, "/files/"+fileID, "/files/"+alias.TargetID, 1)
+	location.RawPath = ""
+
+	status := http.StatusFound
+	if alias.Permanent {
+		status = http.StatusMovedPermanently
+	}
+	c.Redirect(status, location.RequestURI())
+	c.Abort()
+	return true
+}
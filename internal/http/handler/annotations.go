@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+const maxAnnotationLength = 4000
+
+type AnnotationHandler struct {
+	files       *media.FileService
+	annotations metadata.AnnotationStore
+	audit       *audit.Trail
+	logger      *slog.Logger
+}
+
+func NewAnnotationHandler(files *media.FileService, annotations metadata.AnnotationStore, trail *audit.Trail, logger *slog.Logger) *AnnotationHandler {
+	return &AnnotationHandler{
+		files:       files,
+		annotations: annotations,
+		audit:       trail,
+		logger:      logger,
+	}
+}
+
+type RegionPayload struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+type AnnotationRequest struct {
+	Text   string         `json:"text"`
+	Region *RegionPayload `json:"region"`
+}
+
+type AnnotationResponse struct {
+	ID        string         `json:"id"`
+	FileID    string         `json:"fileId"`
+	AuthorID  string         `json:"authorId"`
+	Text      string         `json:"text"`
+	Region    *RegionPayload `json:"region,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+func newAnnotationResponse(a domain.Annotation) AnnotationResponse {
+	resp := AnnotationResponse{
+		ID:        a.ID,
+		FileID:    a.FileID,
+		AuthorID:  a.AuthorID,
+		Text:      a.Text,
+		CreatedAt: a.CreatedAt,
+		UpdatedAt: a.UpdatedAt,
+	}
+	if a.Region != nil {
+		resp.Region = &RegionPayload{X: a.Region.X, Y: a.Region.Y, Width: a.Region.Width, Height: a.Region.Height}
+	}
+	return resp
+}
+
+func (h *AnnotationHandler) List(c *gin.Context) {
+	fileID := c.Param("fileId")
+	if _, ok := h.file(c, false); !ok {
+		return
+	}
+
+	annotations, err := h.annotations.ListAnnotations(c.Request.Context(), fileID)
+	if err != nil {
+		h.logger.Error("Failed to list annotations", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list annotations", "")
+		return
+	}
+
+	items := make([]AnnotationResponse, 0, len(annotations))
+	for _, a := range annotations {
+		items = append(items, newAnnotationResponse(a))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *AnnotationHandler) Create(c *gin.Context) {
+	fileID := c.Param("fileId")
+	if _, ok := h.file(c, true); !ok {
+		return
+	}
+
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	region, ok := validateAnnotation(c, req)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	annotation := domain.Annotation{
+		ID:        uuid.New().String(),
+		FileID:    fileID,
+		AuthorID:  callerID(c),
+		Text:      strings.TrimSpace(req.Text),
+		Region:    region,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := h.annotations.PutAnnotation(c.Request.Context(), annotation); err != nil {
+		h.logger.Error("Failed to store annotation", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store annotation", "")
+		return
+	}
+	h.audit.Record(c.Request.Context(), domain.AuditAnnotationCreate, fileID, map[string]string{"annotationId": annotation.ID})
+
+	c.JSON(http.StatusCreated, newAnnotationResponse(annotation))
+}
+
+func (h *AnnotationHandler) Get(c *gin.Context) {
+	annotation, ok := h.load(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newAnnotationResponse(annotation))
+}
+
+func (h *AnnotationHandler) Update(c *gin.Context) {
+	annotation, ok := h.loadOwned(c, false)
+	if !ok {
+		return
+	}
+
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	region, ok := validateAnnotation(c, req)
+	if !ok {
+		return
+	}
+
+	annotation.Text = strings.TrimSpace(req.Text)
+	annotation.Region = region
+	annotation.UpdatedAt = time.Now().UTC()
+
+	if err := h.annotations.PutAnnotation(c.Request.Context(), annotation); err != nil {
+		h.logger.Error("Failed to update annotation", "annotationId", annotation.ID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store annotation", "")
+		return
+	}
+	h.audit.Record(c.Request.Context(), domain.AuditAnnotationUpdate, annotation.FileID, map[string]string{"annotationId": annotation.ID})
+
+	c.JSON(http.StatusOK, newAnnotationResponse(annotation))
+}
+
+func (h *AnnotationHandler) Delete(c *gin.Context) {
+	annotation, ok := h.loadOwned(c, true)
+	if !ok {
+		return
+	}
+
+	if err := h.annotations.DeleteAnnotation(c.Request.Context(), annotation.ID); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		h.logger.Error("Failed to delete annotation", "annotationId", annotation.ID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete annotation", "")
+		return
+	}
+	h.audit.Record(c.Request.Context(), domain.AuditAnnotationDelete, annotation.FileID, map[string]string{"annotationId": annotation.ID})
+	c.Status(http.StatusNoContent)
+}
+
+// file returns the annotated file if the caller may see it and, to write
+// annotations, is its owner or of its organization. Admins may do both.
+func (h *AnnotationHandler) file(c *gin.Context, write bool) (domain.FileMetadata, bool) {
+	meta, err := h.files.Info(c.Request.Context(), c.Param("fileId"))
+	if abortMedia(c, err) {
+		return domain.FileMetadata{}, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return domain.FileMetadata{}, false
+	}
+	if !write {
+		return meta, true
+	}
+
+	authCtx, _ := auth.GetAuthContext(c)
+	member := meta.OrgID != "" && authCtx.OrgID != nil && *authCtx.OrgID == meta.OrgID
+	if authCtx.UserID != meta.OwnerID && !member && !authCtx.HasPermission("files:admin") {
+		problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Only the owner of the file or members of its organization may annotate it")
+		return domain.FileMetadata{}, false
+	}
+	return meta, true
+}
+
+// load loads the annotation of a file the caller may see.
+func (h *AnnotationHandler) load(c *gin.Context) (domain.Annotation, bool) {
+	if _, ok := h.file(c, false); !ok {
+		return domain.Annotation{}, false
+	}
+	annotation, err := h.annotations.GetAnnotation(c.Request.Context(), c.Param("annotationId"))
+	if err != nil || annotation.FileID != c.Param("fileId") {
+		problem.Abort(c, http.StatusNotFound, "Annotation not found", "")
+		return domain.Annotation{}, false
+	}
+	return annotation, true
+}
+
+// loadOwned loads the annotation and checks that the caller wrote it.
+// Admins may delete any annotation.
+func (h *AnnotationHandler) loadOwned(c *gin.Context, admin bool) (domain.Annotation, bool) {
+	annotation, ok := h.load(c)
+	if !ok {
+		return domain.Annotation{}, false
+	}
+
+	authCtx, _ := auth.GetAuthContext(c)
+	if authCtx == nil || authCtx.UserID != annotation.AuthorID && !(admin && authCtx.HasPermission("files:admin")) {
+		problem.Abort(c, http.StatusForbidden, "Not the annotation author", "")
+		return domain.Annotation{}, false
+	}
+	return annotation, true
+}
+
+func validateAnnotation(c *gin.Context, req AnnotationRequest) (*domain.Region, bool) {
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		problem.Abort(c, http.StatusBadRequest, "Invalid annotation", "text is required")
+		return nil, false
+	}
+	if utf8.RuneCountInString(text) > maxAnnotationLength {
+		problem.Abort(c, http.StatusBadRequest, "Invalid annotation", "text is too long")
+		return nil, false
+	}
+
+	if req.Region == nil {
+		return nil, true
+	}
+	region := &domain.Region{X: req.Region.X, Y: req.Region.Y, Width: req.Region.Width, Height: req.Region.Height}
+	if !region.Valid() {
+		problem.Abort(c, http.StatusBadRequest, "Invalid annotation", "region must be a non-empty rectangle within 0..1 normalized image coordinates")
+		return nil, false
+	}
+	return region, true
+}
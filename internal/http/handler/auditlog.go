@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditLogHandler struct {
+	trail  *audit.Trail
+	logger *slog.Logger
+}
+
+func NewAuditLogHandler(trail *audit.Trail, logger *slog.Logger) *AuditLogHandler {
+	return &AuditLogHandler{
+		trail:  trail,
+		logger: logger,
+	}
+}
+
+type AuditEntryResponse struct {
+	ID       string            `json:"id"`
+	Action   string            `json:"action"`
+	FileID   string            `json:"fileId"`
+	ActorID  string            `json:"actorId,omitempty"`
+	ClientIP string            `json:"clientIp,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	At       time.Time         `json:"at"`
+}
+
+// List returns audit entries matching ?fileId=, ?actorId= and ?action=,
+// recorded from ?since= until before ?until= (RFC 3339), newest first and
+// at most ?limit= of them.
+func (h *AuditLogHandler) List(c *gin.Context) {
+	filter := metadata.AuditFilter{
+		FileID:  c.Query("fileId"),
+		ActorID: c.Query("actorId"),
+		Action:  c.Query("action"),
+	}
+	for _, q := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if v := c.Query(q.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				problem.Abort(c, http.StatusBadRequest, "Invalid "+q.name, "Expected an RFC 3339 time")
+				return
+			}
+			*q.dst = t
+		}
+	}
+	limit := defaultAuditLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			problem.Abort(c, http.StatusBadRequest, "Invalid limit", "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.trail.List(c.Request.Context(), filter, limit)
+	if err != nil {
+		h.logger.Error("Failed to list audit entries", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list audit entries", "")
+		return
+	}
+
+	items := make([]AuditEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, AuditEntryResponse{
+			ID:       e.ID,
+			Action:   e.Action,
+			FileID:   e.FileID,
+			ActorID:  e.ActorID,
+			ClientIP: e.ClientIP,
+			Details:  e.Details,
+			At:       e.At,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
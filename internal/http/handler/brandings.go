@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type BrandingHandler struct {
+	brandings *media.BrandingService
+	logger    *slog.Logger
+}
+
+func NewBrandingHandler(brandings *media.BrandingService, logger *slog.Logger) *BrandingHandler {
+	return &BrandingHandler{
+		brandings: brandings,
+		logger:    logger,
+	}
+}
+
+type BrandingRequest struct {
+	Logo         string   `json:"logo"`
+	LogoPosition string   `json:"logoPosition"`
+	LogoOpacity  *float64 `json:"logoOpacity"`
+	Palette      []string `json:"palette"`
+	Fonts        []string `json:"fonts"`
+}
+
+type BrandingResponse struct {
+	OrgID        string    `json:"orgId"`
+	Logo         string    `json:"logo,omitempty"`
+	LogoPosition string    `json:"logoPosition"`
+	LogoOpacity  float64   `json:"logoOpacity"`
+	Palette      []string  `json:"palette"`
+	Fonts        []string  `json:"fonts"`
+	UpdatedBy    string    `json:"updatedBy,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func newBrandingResponse(b domain.Branding) BrandingResponse {
+	response := BrandingResponse{
+		OrgID:        b.OrgID,
+		Logo:         b.Logo,
+		LogoPosition: b.LogoPosition,
+		LogoOpacity:  b.LogoOpacity,
+		Palette:      b.Palette,
+		Fonts:        b.Fonts,
+		UpdatedBy:    b.UpdatedBy,
+		UpdatedAt:    b.UpdatedAt,
+	}
+	if response.Palette == nil {
+		response.Palette = []string{}
+	}
+	if response.Fonts == nil {
+		response.Fonts = []string{}
+	}
+	return response
+}
+
+func (h *BrandingHandler) List(c *gin.Context) {
+	brandings, err := h.brandings.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list brandings", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list brandings", "")
+		return
+	}
+
+	items := make([]BrandingResponse, 0, len(brandings))
+	for _, b := range brandings {
+		items = append(items, newBrandingResponse(b))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *BrandingHandler) Get(c *gin.Context) {
+	branding, err := h.brandings.Get(c.Request.Context(), c.Param("orgId"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read branding", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read branding", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newBrandingResponse(branding))
+}
+
+// Put replaces the branding of an organization. It applies to the files the
+// organization owns from the next request on.
+func (h *BrandingHandler) Put(c *gin.Context) {
+	var req BrandingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	branding, err := h.brandings.Put(c.Request.Context(), c.Param("orgId"), media.BrandingRequest{
+		Logo:         req.Logo,
+		LogoPosition: req.LogoPosition,
+		LogoOpacity:  req.LogoOpacity,
+		Palette:      req.Palette,
+		Fonts:        req.Fonts,
+		UpdatedBy:    callerID(c),
+	})
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to store branding", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to store branding", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newBrandingResponse(branding))
+}
+
+func (h *BrandingHandler) Delete(c *gin.Context) {
+	if err := h.brandings.Delete(c.Request.Context(), c.Param("orgId")); err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to delete branding", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to delete branding", "")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+// apiVersion is the version of the API served under /v1.
+const apiVersion = "v1"
+
+type CapabilitiesHandler struct {
+	uploads *media.UploadService
+	routes  []string
+	logger  *slog.Logger
+}
+
+// NewCapabilitiesHandler describes the deployment to clients; routes are
+// the optional route groups it serves.
+func NewCapabilitiesHandler(uploads *media.UploadService, routes []string, logger *slog.Logger) *CapabilitiesHandler {
+	return &CapabilitiesHandler{
+		uploads: uploads,
+		routes:  routes,
+		logger:  logger,
+	}
+}
+
+type CapabilitiesResponse struct {
+	APIVersion        string           `json:"apiVersion"`
+	Plan              string           `json:"plan,omitempty"`
+	Uploads           bool             `json:"uploads"` // False once the organization is offboarded
+	AllowedTypes      []string         `json:"allowedTypes"`
+	MaxFileSize       int64            `json:"maxFileSize"`
+	MaxVideoSize      int64            `json:"maxVideoSize"` // 0 when videos are not accepted
+	MaxAudioSize      int64            `json:"maxAudioSize"` // 0 when audio is not accepted
+	Features          FeaturesResponse `json:"features"`
+	ImageFormats      []string         `json:"imageFormats"` // Formats images are converted to, by ?format= or Accept
+	Renditions        []string         `json:"renditions"`   // Default video renditions, e.g. 720p
+	AvatarSizes       []int            `json:"avatarSizes"`
+	Visibilities      []string         `json:"visibilities"`
+	DefaultVisibility string           `json:"defaultVisibility"`
+	Routes            []string         `json:"routes"` // Optional route groups served, e.g. versions or widgets
+}
+
+type FeaturesResponse struct {
+	Video       bool `json:"video"`
+	Audio       bool `json:"audio"`
+	Transcoding bool `json:"transcoding"`
+	HLS         bool `json:"hls"`
+	Posters     bool `json:"posters"`
+	Waveforms   bool `json:"waveforms"`
+	Loudness    bool `json:"loudness"`
+	PDFPreviews bool `json:"pdfPreviews"`
+	OCR         bool `json:"ocr"`
+	Scanning    bool `json:"scanning"`
+}
+
+// Get describes what the caller's organization may upload and what the
+// service makes of it, so that clients can adapt to the deployment.
+// Anonymous callers get the limits of files outside organizations.
+func (h *CapabilitiesHandler) Get(c *gin.Context) {
+	var orgID string
+	if authCtx, ok := auth.GetAuthContext(c); ok && authCtx.OrgID != nil {
+		orgID = *authCtx.OrgID
+	}
+	caps, err := h.uploads.Capabilities(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.Error("Failed to resolve capabilities", "orgId", orgID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to resolve capabilities", "")
+		return
+	}
+
+	resp := CapabilitiesResponse{
+		APIVersion:   apiVersion,
+		Plan:         caps.Plan,
+		Uploads:      caps.Uploads,
+		AllowedTypes: caps.AllowedTypes,
+		MaxFileSize:  caps.MaxFileSize,
+		MaxVideoSize: caps.MaxVideoSize,
+		MaxAudioSize: caps.MaxAudioSize,
+		Features: FeaturesResponse{
+			Video:       caps.Features.Video,
+			Audio:       caps.Features.Audio,
+			Transcoding: caps.Features.Transcoding,
+			HLS:         caps.Features.HLS,
+			Posters:     caps.Features.Posters,
+			Waveforms:   caps.Features.Waveforms,
+			Loudness:    caps.Features.Loudness,
+			PDFPreviews: caps.Features.PDFPreviews,
+			OCR:         caps.Features.OCR,
+			Scanning:    caps.Features.Scanning,
+		},
+		ImageFormats:      make([]string, 0, len(caps.ImageFormats)),
+		Renditions:        make([]string, 0, len(caps.Renditions)),
+		AvatarSizes:       caps.AvatarSizes,
+		DefaultVisibility: string(caps.DefaultVisibility),
+		Routes:            h.routes,
+	}
+	for _, f := range caps.ImageFormats {
+		resp.ImageFormats = append(resp.ImageFormats, string(f))
+	}
+	for _, height := range caps.Renditions {
+		resp.Renditions = append(resp.Renditions, domain.RenditionName(height))
+	}
+	for _, v := range domain.Visibilities {
+		resp.Visibilities = append(resp.Visibilities, string(v))
+	}
+	if resp.AllowedTypes == nil {
+		resp.AllowedTypes = []string{}
+	}
+	if resp.AvatarSizes == nil {
+		resp.AvatarSizes = []int{}
+	}
+	c.Header("Cache-Control", "private, max-age=60")
+	c.Header("Vary", "Authorization")
+	c.JSON(http.StatusOK, resp)
+}
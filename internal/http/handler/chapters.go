@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type ChapterPayload struct {
+	Start float64 `json:"start"` // Seconds
+	Title string  `json:"title"`
+}
+
+type ChaptersRequest struct {
+	Chapters []ChapterPayload `json:"chapters"`
+}
+
+// ChapterResponse is a chapter for players. End is 0 for the last chapter
+// of a file whose duration is not known yet.
+type ChapterResponse struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+}
+
+func newChapterResponses(chapters []media.TimedChapter) []ChapterResponse {
+	if len(chapters) == 0 {
+		return nil
+	}
+	items := make([]ChapterResponse, 0, len(chapters))
+	for _, c := range chapters {
+		items = append(items, ChapterResponse{Start: c.Start, End: c.End, Title: c.Title})
+	}
+	return items
+}
+
+// GetChapters lists the chapters of a video or audio file for players.
+func (h *UploadHandler) GetChapters(c *gin.Context) {
+	fileID := c.Param("fileId")
+	chapters, err := h.files.Chapters(c.Request.Context(), fileID)
+	if err != nil && redirectAlias(c, h.aliases, fileID) {
+		return
+	}
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read chapters", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to read chapters", "")
+		return
+	}
+
+	items := newChapterResponses(chapters)
+	if items == nil {
+		items = []ChapterResponse{}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// PutChapters replaces the chapters of a video or audio file; an empty
+// list removes them. Owners may set the chapters of their own files,
+// administrators of any file.
+func (h *UploadHandler) PutChapters(c *gin.Context) {
+	var req ChaptersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	chapters := make([]domain.Chapter, 0, len(req.Chapters))
+	for _, ch := range req.Chapters {
+		chapters = append(chapters, domain.Chapter{Start: ch.Start, Title: ch.Title})
+	}
+
+	authCtx, _ := auth.GetAuthContext(c)
+	ownerID := authCtx.UserID
+	if authCtx.HasPermission("files:admin") {
+		ownerID = ""
+	}
+	meta, err := h.uploads.SetChapters(c.Request.Context(), c.Param("fileId"), ownerID, chapters)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to update chapters", "fileId", c.Param("fileId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update chapters", "")
+		return
+	}
+
+	items := newChapterResponses(media.TimeChapters(meta))
+	if items == nil {
+		items = []ChapterResponse{}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
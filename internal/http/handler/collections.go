@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/integrity"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type CollectionHandler struct {
+	auditor *integrity.Auditor
+	logger  *slog.Logger
+}
+
+func NewCollectionHandler(auditor *integrity.Auditor, logger *slog.Logger) *CollectionHandler {
+	return &CollectionHandler{
+		auditor: auditor,
+		logger:  logger,
+	}
+}
+
+// Manifest lists the files of a collection with their sizes and checksums.
+// The signature header covers the exact response body. Callers see the
+// files of their organization they may see, as they would download them,
+// or their own without one; auditors and admins all files of the
+// collection.
+func (h *CollectionHandler) Manifest(c *gin.Context) {
+	ctx := c.Request.Context()
+	authCtx, _ := auth.GetAuthContext(c)
+	var include func(domain.FileMetadata) bool
+	if !authCtx.HasPermission("files:admin") && !authCtx.HasPermission("files:audit") {
+		var scope metadata.Filter
+		if authCtx.OrgID != nil {
+			scope.OrgID = *authCtx.OrgID
+		} else {
+			scope.OwnerID = authCtx.UserID
+		}
+		include = func(f domain.FileMetadata) bool {
+			return scope.Match(f) && media.Visible(ctx, f)
+		}
+	}
+
+	manifest, err := h.auditor.Manifest(ctx, c.Param("collectionId"), include)
+	if err != nil {
+		h.abort(c, err, "Failed to build manifest")
+		return
+	}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		h.logger.Error("Failed to encode manifest", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to build manifest", "")
+		return
+	}
+	sig, err := h.auditor.Sign(body)
+	if errors.Is(err, integrity.ErrNoSigningKey) {
+		problem.Abort(c, http.StatusServiceUnavailable, "Manifest signing not configured", "Manifests are only served signed; set MEDIA_MANIFEST_SIGNING_KEY")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to sign manifest", "collection", c.Param("collectionId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to build manifest", "")
+		return
+	}
+	c.Header(integrity.SignatureHeader, "sha256="+sig)
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// LastAudit returns the most recent audit report of a collection.
+func (h *CollectionHandler) LastAudit(c *gin.Context) {
+	report, ok := h.auditor.LastReport(c.Param("collectionId"))
+	if !ok {
+		problem.Abort(c, http.StatusNotFound, "No audit found", "The collection has not been audited yet")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// Audit re-hashes every file of a collection and returns the report.
+func (h *CollectionHandler) Audit(c *gin.Context) {
+	report, err := h.auditor.Audit(c.Request.Context(), c.Param("collectionId"))
+	if err != nil {
+		h.abort(c, err, "Failed to audit collection")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *CollectionHandler) abort(c *gin.Context, err error, title string) {
+	if errors.Is(err, integrity.ErrEmptyCollection) {
+		problem.Abort(c, http.StatusNotFound, "Collection not found", "")
+		return
+	}
+	h.logger.Error(title, "collection", c.Param("collectionId"), "error", err)
+	problem.Abort(c, http.StatusInternalServerError, title, "")
+}
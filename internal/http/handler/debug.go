@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/tuning"
+)
+
+// DebugHandler exposes the Go profiler and runtime statistics for profiling
+// the service in production. Profiles reveal internals, so its routes are
+// only registered for operators.
+type DebugHandler struct{}
+
+func NewDebugHandler() *DebugHandler {
+	return &DebugHandler{}
+}
+
+// Runtime reports goroutines, heap and GC statistics.
+func (h *DebugHandler) Runtime(c *gin.Context) {
+	c.JSON(http.StatusOK, tuning.ReadStats())
+}
+
+// Profile serves net/http/pprof under the route's *profile parameter. The
+// index links to profiles relative to itself, so it works under any
+// prefix ending in a slash.
+func (h *DebugHandler) Profile(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("profile"), "/")
+	switch name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
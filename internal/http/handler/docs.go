@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/openapi"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+)
+
+type DocsHandler struct {
+	build  func() *openapi.Document
+	logger *slog.Logger
+
+	once sync.Once
+	spec []byte
+	err  error
+}
+
+// NewDocsHandler serves the document returned by build. It is built on
+// the first request, once all routes are registered.
+func NewDocsHandler(build func() *openapi.Document, logger *slog.Logger) *DocsHandler {
+	return &DocsHandler{
+		build:  build,
+		logger: logger,
+	}
+}
+
+func (h *DocsHandler) OpenAPI(c *gin.Context) {
+	h.once.Do(func() {
+		h.spec, h.err = json.Marshal(h.build())
+	})
+	if h.err != nil {
+		h.logger.Error("Failed to encode OpenAPI document", "error", h.err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to build API description", "")
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", h.spec)
+}
+
+// swaggerUI loads Swagger UI from a CDN, so the service does not have to
+// ship its assets.
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Media service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func (h *DocsHandler) SwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUI))
+}
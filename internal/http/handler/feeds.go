@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type FeedHandler struct {
+	feeds    *media.FeedService
+	cacheTTL time.Duration
+	logger   *slog.Logger
+}
+
+// NewFeedHandler serves feeds letting clients cache them for cacheTTL, the
+// time the service caches them for.
+func NewFeedHandler(feeds *media.FeedService, cacheTTL time.Duration, logger *slog.Logger) *FeedHandler {
+	return &FeedHandler{
+		feeds:    feeds,
+		cacheTTL: cacheTTL,
+		logger:   logger,
+	}
+}
+
+type FeedRequest struct {
+	Enabled     *bool    `json:"enabled"`
+	Title       string   `json:"title"`
+	Collections []string `json:"collections"`
+}
+
+type FeedResponse struct {
+	OrgID       string    `json:"orgId"`
+	Enabled     bool      `json:"enabled"`
+	Title       string    `json:"title,omitempty"`
+	Collections []string  `json:"collections"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func newFeedResponse(f domain.Feed) FeedResponse {
+	return FeedResponse{
+		OrgID:       f.OrgID,
+		Enabled:     f.Enabled,
+		Title:       f.Title,
+		Collections: f.Collections,
+		UpdatedBy:   f.UpdatedBy,
+		UpdatedAt:   f.UpdatedAt,
+	}
+}
+
+func (h *FeedHandler) List(c *gin.Context) {
+	feeds, err := h.feeds.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list feeds", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list feeds", "")
+		return
+	}
+
+	items := make([]FeedResponse, 0, len(feeds))
+	for _, f := range feeds {
+		items = append(items, newFeedResponse(f))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *FeedHandler) Get(c *gin.Context) {
+	f, err := h.feeds.Get(c.Request.Context(), c.Param("orgId"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read feed", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read feed", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newFeedResponse(f))
+}
+
+// Put replaces the feed settings of an organization: whether its feeds are
+// published and which of its collections they cover.
+func (h *FeedHandler) Put(c *gin.Context) {
+	var req FeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	f, err := h.feeds.Put(c.Request.Context(), c.Param("orgId"), media.FeedRequest{
+		Enabled:     req.Enabled,
+		Title:       req.Title,
+		Collections: req.Collections,
+		UpdatedBy:   callerID(c),
+	})
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to store feed", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to store feed", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newFeedResponse(f))
+}
+
+func (h *FeedHandler) Delete(c *gin.Context) {
+	if err := h.feeds.Delete(c.Request.Context(), c.Param("orgId")); err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to delete feed", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to delete feed", "")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Sitemap lists the published assets of an organization for crawlers.
+func (h *FeedHandler) Sitemap(c *gin.Context) {
+	h.serve(c, media.FeedSitemap)
+}
+
+// Atom serves the newest assets of an organization as an Atom feed.
+func (h *FeedHandler) Atom(c *gin.Context) {
+	h.serve(c, media.FeedAtom)
+}
+
+// RSS serves the newest assets of an organization as an RSS feed.
+func (h *FeedHandler) RSS(c *gin.Context) {
+	h.serve(c, media.FeedRSS)
+}
+
+// serve renders a feed of the organization, narrowed to one of its public
+// collections by ?collection=. Conditional requests are answered from the
+// time of the newest change.
+func (h *FeedHandler) serve(c *gin.Context, format media.FeedFormat) {
+	orgID := c.Param("orgId")
+	rendered, err := h.feeds.Render(c.Request.Context(), orgID, format, c.Query("collection"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to render feed", "orgId", orgID, "format", format, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to render feed", "")
+		}
+		return
+	}
+
+	c.Header("Content-Type", rendered.ContentType)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.cacheTTL.Seconds())))
+	http.ServeContent(c.Writer, c.Request, "", rendered.ModTime, bytes.NewReader(rendered.Body))
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type GeoRuleHandler struct {
+	geo    *media.GeoService
+	logger *slog.Logger
+}
+
+func NewGeoRuleHandler(geo *media.GeoService, logger *slog.Logger) *GeoRuleHandler {
+	return &GeoRuleHandler{
+		geo:    geo,
+		logger: logger,
+	}
+}
+
+type GeoRuleRequest struct {
+	Allowed []string `json:"allowed"`
+	Blocked []string `json:"blocked"`
+}
+
+type GeoRuleResponse struct {
+	Collection string    `json:"collection"`
+	Allowed    []string  `json:"allowed"`
+	Blocked    []string  `json:"blocked"`
+	UpdatedBy  string    `json:"updatedBy,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func newGeoRuleResponse(r domain.GeoRule) GeoRuleResponse {
+	response := GeoRuleResponse{
+		Collection: r.Collection,
+		Allowed:    r.Allowed,
+		Blocked:    r.Blocked,
+		UpdatedBy:  r.UpdatedBy,
+		UpdatedAt:  r.UpdatedAt,
+	}
+	if response.Allowed == nil {
+		response.Allowed = []string{}
+	}
+	if response.Blocked == nil {
+		response.Blocked = []string{}
+	}
+	return response
+}
+
+func (h *GeoRuleHandler) List(c *gin.Context) {
+	rules, err := h.geo.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list geo rules", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list geo rules", "")
+		return
+	}
+
+	items := make([]GeoRuleResponse, 0, len(rules))
+	for _, r := range rules {
+		items = append(items, newGeoRuleResponse(r))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *GeoRuleHandler) Get(c *gin.Context) {
+	rule, err := h.geo.Get(c.Request.Context(), c.Param("collectionId"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read geo rule", "collection", c.Param("collectionId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read geo rule", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newGeoRuleResponse(rule))
+}
+
+// Put replaces the geo rule of a collection. Downloads of its files from
+// other countries are answered with 451 from the next request on.
+func (h *GeoRuleHandler) Put(c *gin.Context) {
+	var req GeoRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	rule, err := h.geo.Put(c.Request.Context(), c.Param("collectionId"), media.GeoRuleRequest{
+		Allowed:   req.Allowed,
+		Blocked:   req.Blocked,
+		UpdatedBy: callerID(c),
+	})
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to store geo rule", "collection", c.Param("collectionId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to store geo rule", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newGeoRuleResponse(rule))
+}
+
+func (h *GeoRuleHandler) Delete(c *gin.Context) {
+	if err := h.geo.Delete(c.Request.Context(), c.Param("collectionId")); err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to delete geo rule", "collection", c.Param("collectionId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to delete geo rule", "")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -1,15 +1,41 @@
 package handler
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-type HealthHandler struct{}
+// readyTimeout bounds all readiness checks of a probe together.
+const readyTimeout = 2 * time.Second
 
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+type HealthHandler struct {
+	checks map[string]Check
+}
+
+// NewHealthHandler serves liveness, and readiness of the dependencies
+// behind checks, keyed by the name to report them under.
+func NewHealthHandler(checks map[string]Check) *HealthHandler {
+	return &HealthHandler{
+		checks: checks,
+	}
+}
+
+type DependencyStatus struct {
+	Status  string  `json:"status"` // "ok" or "unavailable"
+	Latency float64 `json:"latencyMs"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
 }
 
 func (h *HealthHandler) Health(c *gin.Context) {
@@ -17,3 +43,43 @@ func (h *HealthHandler) Health(c *gin.Context) {
 		"status": "ok",
 	})
 }
+
+// Ready runs all checks concurrently and answers 503 if any fails, so that
+// the pod is taken out of rotation until its dependencies recover.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		resp = ReadinessResponse{Status: "ok", Checks: make(map[string]DependencyStatus, len(h.checks))}
+	)
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			status := DependencyStatus{Status: "ok", Latency: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				status.Status, status.Error = "unavailable", err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[name] = status
+			if err != nil {
+				resp.Status = "unavailable"
+			}
+		}()
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if resp.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, resp)
+}
@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+	"github.com/ondrasimku/media-service-go/internal/signedurl"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+// hlsNamePattern matches the files of a video's HLS packaging: the master
+// playlist, rendition playlists ("720p.m3u8"), segments ("720p_00000.ts")
+// and the chapters the master playlist points to.
+var hlsNamePattern = regexp.MustCompile(`^(master\.m3u8|chapters\.json|[0-9]+p\.m3u8|[0-9]+p_[0-9]+\.ts)$`)
+
+// hlsChapters names the chapters of a video in its HLS packaging.
+const hlsChapters = "chapters.json"
+
+// HLSHandler serves transcoded videos for adaptive streaming. With stream
+// signing configured, every request must carry a signature for the video's HLS
+// path, which playlists pass on to the URLs they list.
+type HLSHandler struct {
+	storage  storage.Storage
+	metadata metadata.Store
+	aliases  metadata.AliasStore
+	files    *media.FileService
+	logger   *slog.Logger
+}
+
+func NewHLSHandler(storage storage.Storage, metadata metadata.Store, aliases metadata.AliasStore, files *media.FileService, logger *slog.Logger) *HLSHandler {
+	return &HLSHandler{
+		storage:  storage,
+		metadata: metadata,
+		aliases:  aliases,
+		files:    files,
+		logger:   logger,
+	}
+}
+
+type SignedURLRequest struct {
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type SignedURLResponse struct {
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Sign issues a URL of the master playlist that grants access to the
+// stream until it expires. Without a signer the plain URL is returned.
+func (h *HLSHandler) Sign(c *gin.Context) {
+	var req SignedURLRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+
+	fileID := c.Param("fileId")
+	streamURL, expiresAt, err := h.files.StreamURL(c.Request.Context(), fileID, expiresAt)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to sign stream URL", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to sign URL", "")
+		return
+	}
+
+	response := SignedURLResponse{URL: streamURL}
+	if !expiresAt.IsZero() {
+		response.ExpiresAt = &expiresAt
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Serve serves the master playlist, a rendition playlist or a segment.
+func (h *HLSHandler) Serve(c *gin.Context) {
+	fileID := c.Param("fileId")
+	name := c.Param("name")
+	ctx := c.Request.Context()
+
+	meta, err := h.metadata.Get(ctx, fileID)
+	if err != nil && redirectAlias(c, h.aliases, fileID) {
+		return
+	}
+	if err != nil || !meta.Servable() {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+
+	// A signed stream is open to its holder whatever the visibility of
+	// the video.
+	ctx, err = h.files.AuthorizeStream(ctx, meta.ID, c.Request.URL.Query())
+	switch {
+	case errors.Is(err, signedurl.ErrExpired):
+		problem.Abort(c, http.StatusForbidden, "Signed URL expired", "")
+		return
+	case err != nil:
+		problem.Abort(c, http.StatusForbidden, "Invalid signature", "A signed URL is required to stream this video")
+		return
+	}
+	if abortMedia(c, h.files.CheckAccess(ctx, meta)) {
+		return
+	}
+
+	if !media.HasStream(meta) {
+		problem.Abort(c, http.StatusNotFound, "Stream not available", "The video has not been packaged for HLS")
+		return
+	}
+	if !hlsNamePattern.MatchString(name) {
+		problem.Abort(c, http.StatusNotFound, "Stream file not found", "")
+		return
+	}
+
+	// Signatures are passed on verbatim so relative URLs in playlists
+	// stay valid.
+	var query string
+	if h.files.StreamsSigned() {
+		query = url.Values{
+			signedurl.ExpiresParam:   {c.Query(signedurl.ExpiresParam)},
+			signedurl.SignatureParam: {c.Query(signedurl.SignatureParam)},
+		}.Encode()
+	}
+
+	switch name {
+	case media.MasterPlaylist:
+		c.Data(http.StatusOK, video.HLSPlaylist, buildMasterPlaylist(meta, query))
+		return
+	case hlsChapters:
+		if len(meta.Chapters) == 0 {
+			problem.Abort(c, http.StatusNotFound, "Stream file not found", "")
+			return
+		}
+		c.JSON(http.StatusOK, newHLSChapters(media.TimeChapters(meta)))
+		return
+	}
+
+	file, info, err := h.storage.OpenDerivative(ctx, meta.ID, domain.HLSDerivative(name))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "Stream file not found", "")
+		return
+	}
+	defer file.Close()
+
+	if strings.HasSuffix(name, ".ts") {
+		c.Header("Content-Type", video.HLSSegment)
+		serveContent(c, info.CreatedAt, file)
+		return
+	}
+
+	playlist, err := appendQuery(file, query)
+	if err != nil {
+		h.logger.Error("Failed to read HLS playlist", "fileId", meta.ID, "name", name, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to read file", "")
+		return
+	}
+	c.Data(http.StatusOK, video.HLSPlaylist, playlist)
+}
+
+// buildMasterPlaylist lists the renditions of a video with their average
+// bit rate, which players use to pick one for the available bandwidth, and
+// points players that show chapters to them.
+func buildMasterPlaylist(meta domain.FileMetadata, query string) []byte {
+	v := meta.Video
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	if len(meta.Chapters) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-SESSION-DATA:DATA-ID=\"com.apple.hls.chapters\",URI=\"%s\"\n", withQuery(hlsChapters, query))
+	}
+	for _, r := range v.Transcode.Renditions {
+		bandwidth := r.Size * 8
+		if v.Duration > 0 {
+			bandwidth = int64(float64(bandwidth) / v.Duration)
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth)
+		if r.Width > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", r.Width, r.Height)
+		}
+		b.WriteString("\n" + withQuery(domain.RenditionName(r.Height)+".m3u8", query) + "\n")
+	}
+	return b.Bytes()
+}
+
+// hlsChapter is a chapter in the JSON format HLS players read from the
+// com.apple.hls.chapters session data.
+type hlsChapter struct {
+	Chapter   int              `json:"chapter"`
+	StartTime float64          `json:"start-time"`
+	Duration  float64          `json:"duration,omitempty"`
+	Titles    []hlsChapterName `json:"titles"`
+}
+
+type hlsChapterName struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+func newHLSChapters(chapters []media.TimedChapter) []hlsChapter {
+	out := make([]hlsChapter, 0, len(chapters))
+	for i, c := range chapters {
+		ch := hlsChapter{Chapter: i + 1, StartTime: c.Start, Titles: []hlsChapterName{{Language: "und", Title: c.Title}}}
+		if c.End > c.Start {
+			ch.Duration = c.End - c.Start
+		}
+		out = append(out, ch)
+	}
+	return out
+}
+
+// appendQuery adds query to every URL line of a media playlist.
+func appendQuery(playlist io.Reader, query string) ([]byte, error) {
+	var b bytes.Buffer
+	scanner := bufio.NewScanner(playlist)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = withQuery(line, query)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.Bytes(), scanner.Err()
+}
+
+func withQuery(uri, query string) string {
+	if query == "" {
+		return uri
+	}
+	return uri + "?" + query
+}
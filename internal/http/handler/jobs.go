@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/jobs"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+type JobHandler struct {
+	files  metadata.Store
+	jobs   jobs.Queue
+	logger *slog.Logger
+}
+
+// NewJobHandler reports the background jobs of files. queue may be nil
+// when jobs run inline, in which case files have none.
+func NewJobHandler(files metadata.Store, queue jobs.Queue, logger *slog.Logger) *JobHandler {
+	return &JobHandler{
+		files:  files,
+		jobs:   queue,
+		logger: logger,
+	}
+}
+
+type JobResponse struct {
+	ID       string            `json:"id"`
+	FileID   string            `json:"fileId"`
+	Kind     string            `json:"kind"`
+	Params   map[string]string `json:"params,omitempty"`
+	Status   domain.JobStatus  `json:"status"`
+	Attempts int               `json:"attempts"`
+	Error    string            `json:"error,omitempty"`
+	RunAt    *time.Time        `json:"runAt,omitempty"`
+	Priority int               `json:"priority"`
+
+	// Processing used by all attempts so far.
+	CPUSeconds   float64 `json:"cpuSeconds"`
+	ScratchBytes int64   `json:"scratchBytes"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func newJobResponse(j domain.Job) JobResponse {
+	r := JobResponse{
+		ID:        j.ID,
+		FileID:    j.FileID,
+		Kind:      j.Kind,
+		Params:    j.Params,
+		Status:    j.Status,
+		Attempts:  j.Attempts,
+		Error:     j.Error,
+		Priority:  j.Priority,
+		CreatedAt: j.CreatedAt,
+
+		CPUSeconds:   j.CPUSeconds,
+		ScratchBytes: j.ScratchBytes,
+		UpdatedAt:    j.UpdatedAt,
+	}
+	if !j.Finished() && !j.RunAt.IsZero() {
+		r.RunAt = &j.RunAt
+	}
+	return r
+}
+
+// List returns the background jobs of a file, oldest first.
+func (h *JobHandler) List(c *gin.Context) {
+	fileID := c.Param("fileId")
+	if _, err := h.files.Get(c.Request.Context(), fileID); err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+
+	items := []JobResponse{}
+	if h.jobs != nil {
+		list, err := h.jobs.Jobs(c.Request.Context(), fileID)
+		if err != nil {
+			h.logger.Error("Failed to list jobs", "fileId", fileID, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to list jobs", "")
+			return
+		}
+		for _, j := range list {
+			items = append(items, newJobResponse(j))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// JobBatchRequest selects the failed jobs a bulk retry or purge applies
+// to; an empty selection is every failed job.
+type JobBatchRequest struct {
+	Kind   string `json:"kind,omitempty"`
+	FileID string `json:"fileId,omitempty"`
+}
+
+type JobBatchResponse struct {
+	Count int `json:"count"`
+}
+
+// ListAll returns the jobs of all files, oldest first. Without a status
+// query it returns the failed ones, which are not retried any more.
+func (h *JobHandler) ListAll(c *gin.Context) {
+	status := domain.JobStatus(c.DefaultQuery("status", string(domain.JobFailed)))
+	switch status {
+	case domain.JobPending, domain.JobRunning, domain.JobDone, domain.JobFailed:
+	default:
+		problem.Abort(c, http.StatusBadRequest, "Invalid status", "status must be pending, running, done or failed")
+		return
+	}
+
+	items := []JobResponse{}
+	if h.jobs != nil {
+		list, err := h.jobs.List(c.Request.Context(), metadata.JobFilter{
+			FileID: c.Query("fileId"),
+			Kind:   c.Query("kind"),
+			Status: status,
+		})
+		if err != nil {
+			h.logger.Error("Failed to list jobs", "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to list jobs", "")
+			return
+		}
+		for _, j := range list {
+			items = append(items, newJobResponse(j))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// Retry queues a failed job to run again with a full set of attempts.
+func (h *JobHandler) Retry(c *gin.Context) {
+	if h.jobs == nil {
+		problem.Abort(c, http.StatusNotFound, "Job not found", "")
+		return
+	}
+	job, err := h.jobs.Retry(c.Request.Context(), c.Param("jobId"))
+	if err != nil {
+		h.abortJob(c, "Failed to retry job", err)
+		return
+	}
+	c.JSON(http.StatusOK, newJobResponse(job))
+}
+
+// Purge deletes a failed job.
+func (h *JobHandler) Purge(c *gin.Context) {
+	if h.jobs == nil {
+		problem.Abort(c, http.StatusNotFound, "Job not found", "")
+		return
+	}
+	if err := h.jobs.Purge(c.Request.Context(), c.Param("jobId")); err != nil {
+		h.abortJob(c, "Failed to purge job", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RetryAll retries the failed jobs selected by the request body.
+func (h *JobHandler) RetryAll(c *gin.Context) {
+	h.batch(c, "retry", func(j domain.Job) error {
+		_, err := h.jobs.Retry(c.Request.Context(), j.ID)
+		return err
+	})
+}
+
+// PurgeAll deletes the failed jobs selected by the request body.
+func (h *JobHandler) PurgeAll(c *gin.Context) {
+	h.batch(c, "purge", func(j domain.Job) error {
+		return h.jobs.Purge(c.Request.Context(), j.ID)
+	})
+}
+
+func (h *JobHandler) batch(c *gin.Context, action string, apply func(domain.Job) error) {
+	var req JobBatchRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+	}
+	if h.jobs == nil {
+		c.JSON(http.StatusOK, JobBatchResponse{})
+		return
+	}
+
+	list, err := h.jobs.List(c.Request.Context(), metadata.JobFilter{FileID: req.FileID, Kind: req.Kind, Status: domain.JobFailed})
+	if err != nil {
+		h.logger.Error("Failed to list failed jobs", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list jobs", "")
+		return
+	}
+	var count int
+	for _, j := range list {
+		// Jobs retried or purged meanwhile are skipped.
+		if err := apply(j); err != nil {
+			if !errors.Is(err, jobs.ErrNotFailed) && !errors.Is(err, metadata.ErrNotFound) {
+				h.logger.Warn("Failed to "+action+" job", "jobId", j.ID, "error", err)
+			}
+			continue
+		}
+		count++
+	}
+	h.logger.Info("Failed jobs handled in bulk", "action", action, "kind", req.Kind, "fileId", req.FileID, "count", count)
+	c.JSON(http.StatusOK, JobBatchResponse{Count: count})
+}
+
+func (h *JobHandler) abortJob(c *gin.Context, failure string, err error) {
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		problem.Abort(c, http.StatusNotFound, "Job not found", "")
+	case errors.Is(err, jobs.ErrNotFailed):
+		problem.Abort(c, http.StatusConflict, "Job has not failed", "Only failed jobs can be retried or purged")
+	default:
+		h.logger.Error(failure, "jobId", c.Param("jobId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, failure, "")
+	}
+}
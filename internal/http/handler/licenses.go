@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type LicenseHandler struct {
+	files  *media.FileService
+	window time.Duration
+	logger *slog.Logger
+}
+
+// NewLicenseHandler reports on licensed media, looking window ahead unless
+// a request asks otherwise.
+func NewLicenseHandler(files *media.FileService, window time.Duration, logger *slog.Logger) *LicenseHandler {
+	return &LicenseHandler{
+		files:  files,
+		window: window,
+		logger: logger,
+	}
+}
+
+type LicenseResponse struct {
+	FileID       string    `json:"fileId"`
+	URL          string    `json:"url"`
+	OriginalName string    `json:"originalName"`
+	Collection   string    `json:"collection,omitempty"`
+	OwnerID      string    `json:"ownerId,omitempty"`
+	OrgID        string    `json:"orgId,omitempty"`
+	License      string    `json:"license,omitempty"`
+	RightsHolder string    `json:"rightsHolder,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Expired      bool      `json:"expired"`
+}
+
+// Expiring lists the files whose license expires within ?within= (default
+// from the configuration), including those already expired, soonest first,
+// for the content team to renew or replace them.
+func (h *LicenseHandler) Expiring(c *gin.Context) {
+	within, err := durationQuery(c, "within", h.window)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid within", err.Error())
+		return
+	}
+
+	files, err := h.files.ExpiringLicenses(c.Request.Context(), within)
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to list expiring licenses", "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to list expiring licenses", "")
+		}
+		return
+	}
+
+	now := time.Now()
+	items := make([]LicenseResponse, 0, len(files))
+	for _, meta := range files {
+		items = append(items, LicenseResponse{
+			FileID:       meta.ID,
+			URL:          h.files.URL(meta.ID),
+			OriginalName: meta.OriginalName,
+			Collection:   meta.Collection,
+			OwnerID:      meta.OwnerID,
+			OrgID:        meta.OrgID,
+			License:      meta.License,
+			RightsHolder: meta.RightsHolder,
+			ExpiresAt:    *meta.LicenseExpiresAt,
+			Expired:      meta.LicenseExpired(now),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/moderation"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+type ModerationHandler struct {
+	queue   *moderation.Queue
+	storage storage.Storage
+	logger  *slog.Logger
+}
+
+func NewModerationHandler(queue *moderation.Queue, storage storage.Storage, logger *slog.Logger) *ModerationHandler {
+	return &ModerationHandler{
+		queue:   queue,
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+type QueueItem struct {
+	FileID       string    `json:"fileId"`
+	OriginalName string    `json:"originalName,omitempty"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	OwnerID      string    `json:"ownerId,omitempty"`
+	OrgID        string    `json:"orgId,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	FlaggedBy    string    `json:"flaggedBy,omitempty"`
+	FlaggedAt    time.Time `json:"flaggedAt"`
+	PreviewURL   string    `json:"previewUrl"`
+}
+
+type moderationRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *ModerationHandler) Queue(c *gin.Context) {
+	pending, err := h.queue.Pending(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list moderation queue", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list moderation queue", "")
+		return
+	}
+
+	items := make([]QueueItem, 0, len(pending))
+	for _, meta := range pending {
+		item := QueueItem{
+			FileID:       meta.ID,
+			OriginalName: meta.OriginalName,
+			ContentType:  meta.ContentType,
+			Size:         meta.Size,
+			OwnerID:      meta.OwnerID,
+			OrgID:        meta.OrgID,
+			PreviewURL:   "/v1/moderation/files/" + meta.ID + "/preview",
+		}
+		if meta.Quarantine != nil {
+			item.Reason = meta.Quarantine.Reason
+			item.FlaggedBy = meta.Quarantine.FlaggedBy
+			item.FlaggedAt = meta.Quarantine.FlaggedAt
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": len(items)})
+}
+
+// Preview serves a quarantined file's content to moderators.
+func (h *ModerationHandler) Preview(c *gin.Context) {
+	fileID := c.Param("fileId")
+
+	file, info, err := h.storage.Open(c.Request.Context(), fileID)
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+	defer file.Close()
+
+	contentType := info.ContentType
+	if contentType == "" || contentType == mediatype.OctetStream {
+		contentType = mediatype.Sniff(file)
+	}
+
+	if contentType == mediatype.SVG {
+		setSVGHeaders(c)
+	}
+	c.Header("Cache-Control", "no-store")
+	serveReader(c, info.Size, contentType, file)
+}
+
+func (h *ModerationHandler) Flag(c *gin.Context) {
+	var req moderationRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
+	fileID := c.Param("fileId")
+	if err := h.queue.Flag(c.Request.Context(), fileID, req.Reason, callerID(c)); err != nil {
+		h.abortModeration(c, fileID, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ModerationHandler) Approve(c *gin.Context) {
+	fileID := c.Param("fileId")
+	if _, err := h.queue.Approve(c.Request.Context(), fileID, callerID(c)); err != nil {
+		h.abortModeration(c, fileID, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ModerationHandler) Reject(c *gin.Context) {
+	var req moderationRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
+	fileID := c.Param("fileId")
+	if err := h.queue.Reject(c.Request.Context(), fileID, req.Reason, callerID(c)); err != nil {
+		h.abortModeration(c, fileID, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ModerationHandler) abortModeration(c *gin.Context, fileID string, err error) {
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+	case errors.Is(err, moderation.ErrNotQuarantined):
+		problem.Abort(c, http.StatusConflict, "File is not quarantined", "")
+	case errors.Is(err, moderation.ErrTakenDown):
+		problem.Abort(c, http.StatusConflict, "File is taken down", "Taken down files are released by restoring them")
+	default:
+		h.logger.Error("Moderation action failed", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Moderation action failed", "")
+	}
+}
+
+func callerID(c *gin.Context) string {
+	if authCtx, ok := auth.GetAuthContext(c); ok {
+		return authCtx.UserID
+	}
+	return ""
+}
+
+// bindOptionalJSON decodes a JSON body into v, accepting an empty body. It
+// writes a 400 and returns false on malformed input.
+func bindOptionalJSON(c *gin.Context, v any) bool {
+	if err := c.ShouldBindJSON(v); err != nil && !errors.Is(err, io.EOF) {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type OrgHandler struct {
+	orgs   *media.OrgService
+	logger *slog.Logger
+}
+
+func NewOrgHandler(orgs *media.OrgService, logger *slog.Logger) *OrgHandler {
+	return &OrgHandler{
+		orgs:   orgs,
+		logger: logger,
+	}
+}
+
+// OrgRequest provisions an organization. Quota and geoRule are optional;
+// geoRule applies to each of the collections.
+type OrgRequest struct {
+	ID          string          `json:"id" binding:"required"`
+	Name        string          `json:"name"`
+	Collections []string        `json:"collections"`
+	Quota       *QuotaRequest   `json:"quota"`
+	Feed        bool            `json:"feed"`
+	GeoRule     *GeoRuleRequest `json:"geoRule"`
+}
+
+// OffboardRequest optionally overrides the grace period before the files
+// of an organization are deleted, as a duration such as "720h".
+type OffboardRequest struct {
+	GracePeriod string `json:"gracePeriod"`
+}
+
+type OrgResponse struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name,omitempty"`
+	Status      string               `json:"status"`
+	Collections []string             `json:"collections"`
+	CreatedBy   string               `json:"createdBy,omitempty"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	Offboarding *OffboardingResponse `json:"offboarding,omitempty"`
+}
+
+type OffboardingResponse struct {
+	RequestedBy  string     `json:"requestedBy,omitempty"`
+	RequestedAt  time.Time  `json:"requestedAt"`
+	DeleteAt     time.Time  `json:"deleteAt"`
+	ExportFileID string     `json:"exportFileId,omitempty"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+}
+
+func newOrgResponse(org domain.Org) OrgResponse {
+	resp := OrgResponse{
+		ID:          org.ID,
+		Name:        org.Name,
+		Status:      string(org.Status),
+		Collections: org.Collections,
+		CreatedBy:   org.CreatedBy,
+		CreatedAt:   org.CreatedAt,
+	}
+	if resp.Collections == nil {
+		resp.Collections = []string{}
+	}
+	if o := org.Offboarding; o != nil {
+		resp.Offboarding = &OffboardingResponse{
+			RequestedBy:  o.RequestedBy,
+			RequestedAt:  o.RequestedAt,
+			DeleteAt:     o.DeleteAt,
+			ExportFileID: o.ExportFileID,
+			DeletedAt:    o.DeletedAt,
+		}
+	}
+	return resp
+}
+
+func (h *OrgHandler) List(c *gin.Context) {
+	orgs, err := h.orgs.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list organizations", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list organizations", "")
+		return
+	}
+
+	items := make([]OrgResponse, 0, len(orgs))
+	for _, org := range orgs {
+		items = append(items, newOrgResponse(org))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *OrgHandler) Get(c *gin.Context) {
+	org, err := h.orgs.Get(c.Request.Context(), c.Param("orgId"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read organization", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read organization", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newOrgResponse(org))
+}
+
+// Create provisions an organization with its default collections, quota,
+// feed and geo rules.
+func (h *OrgHandler) Create(c *gin.Context) {
+	var req OrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	provision := media.OrgRequest{
+		ID:          req.ID,
+		Name:        req.Name,
+		Collections: req.Collections,
+		Feed:        req.Feed,
+		CreatedBy:   callerID(c),
+	}
+	if req.Quota != nil {
+		provision.Quota = &media.QuotaRequest{MaxBytes: req.Quota.MaxBytes, MaxFiles: req.Quota.MaxFiles}
+	}
+	if req.GeoRule != nil {
+		provision.GeoRule = &media.GeoRuleRequest{Allowed: req.GeoRule.Allowed, Blocked: req.GeoRule.Blocked}
+	}
+	org, err := h.orgs.Provision(c.Request.Context(), provision)
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to provision organization", "orgId", req.ID, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to provision organization", "")
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, newOrgResponse(org))
+}
+
+// Offboard stops the uploads of an organization, exports its files and
+// schedules their deletion once the grace period ends.
+func (h *OrgHandler) Offboard(c *gin.Context) {
+	var req OffboardRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	var grace time.Duration
+	if req.GracePeriod != "" {
+		var err error
+		if grace, err = time.ParseDuration(req.GracePeriod); err != nil || grace <= 0 {
+			problem.Abort(c, http.StatusBadRequest, "Invalid grace period", "gracePeriod must be a positive duration such as 720h")
+			return
+		}
+	}
+
+	org, err := h.orgs.Offboard(c.Request.Context(), c.Param("orgId"), grace, callerID(c))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to offboard organization", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to offboard organization", "")
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, newOrgResponse(org))
+}
+
+// CancelOffboarding restores an organization whose files are not deleted
+// yet.
+func (h *OrgHandler) CancelOffboarding(c *gin.Context) {
+	org, err := h.orgs.CancelOffboarding(c.Request.Context(), c.Param("orgId"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to cancel offboarding", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to cancel offboarding", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newOrgResponse(org))
+}
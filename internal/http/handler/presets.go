@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type PresetHandler struct {
+	presets *media.PresetService
+	logger  *slog.Logger
+}
+
+func NewPresetHandler(presets *media.PresetService, logger *slog.Logger) *PresetHandler {
+	return &PresetHandler{
+		presets: presets,
+		logger:  logger,
+	}
+}
+
+type LadderRungPayload struct {
+	Height  int `json:"height"`
+	Bitrate int `json:"bitrate"` // kbit/s; 0 for constant quality
+}
+
+type PresetRequest struct {
+	Codec        string              `json:"codec"`
+	Ladder       []LadderRungPayload `json:"ladder"`
+	AudioBitrate int                 `json:"audioBitrate"`
+	Collections  []string            `json:"collections"`
+}
+
+type PresetResponse struct {
+	Name         string              `json:"name"`
+	Version      int                 `json:"version"`
+	Codec        string              `json:"codec"`
+	Ladder       []LadderRungPayload `json:"ladder"`
+	AudioBitrate int                 `json:"audioBitrate"`
+	Collections  []string            `json:"collections"`
+	Deleted      bool                `json:"deleted,omitempty"`
+	UpdatedBy    string              `json:"updatedBy,omitempty"`
+	UpdatedAt    time.Time           `json:"updatedAt"`
+}
+
+func newPresetResponse(p domain.TranscodePreset) PresetResponse {
+	response := PresetResponse{
+		Name:         p.Name,
+		Version:      p.Version,
+		Codec:        p.Codec,
+		Ladder:       make([]LadderRungPayload, 0, len(p.Ladder)),
+		AudioBitrate: p.AudioBitrate,
+		Collections:  p.Collections,
+		Deleted:      p.Deleted,
+		UpdatedBy:    p.UpdatedBy,
+		UpdatedAt:    p.UpdatedAt,
+	}
+	for _, r := range p.Ladder {
+		response.Ladder = append(response.Ladder, LadderRungPayload{Height: r.Height, Bitrate: r.Bitrate})
+	}
+	if response.Collections == nil {
+		response.Collections = []string{}
+	}
+	return response
+}
+
+func newPresetResponses(presets []domain.TranscodePreset) []PresetResponse {
+	items := make([]PresetResponse, 0, len(presets))
+	for _, p := range presets {
+		items = append(items, newPresetResponse(p))
+	}
+	return items
+}
+
+func (h *PresetHandler) List(c *gin.Context) {
+	presets, err := h.presets.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list presets", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list presets", "")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": newPresetResponses(presets)})
+}
+
+func (h *PresetHandler) Get(c *gin.Context) {
+	p, err := h.presets.Get(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read preset", "preset", c.Param("name"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read preset", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newPresetResponse(p))
+}
+
+// Put stores a new version of a preset. Videos transcoded afterwards in its
+// collections are encoded with it; existing renditions are left as they are.
+func (h *PresetHandler) Put(c *gin.Context) {
+	var req PresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	ladder := make([]domain.LadderRung, 0, len(req.Ladder))
+	for _, r := range req.Ladder {
+		ladder = append(ladder, domain.LadderRung{Height: r.Height, Bitrate: r.Bitrate})
+	}
+	p, err := h.presets.Put(c.Request.Context(), c.Param("name"), media.PresetRequest{
+		Codec:        req.Codec,
+		Ladder:       ladder,
+		AudioBitrate: req.AudioBitrate,
+		Collections:  req.Collections,
+		UpdatedBy:    callerID(c),
+	})
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to store preset", "preset", c.Param("name"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to store preset", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newPresetResponse(p))
+}
+
+func (h *PresetHandler) Delete(c *gin.Context) {
+	if err := h.presets.Delete(c.Request.Context(), c.Param("name"), callerID(c)); err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to delete preset", "preset", c.Param("name"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to delete preset", "")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Versions lists every version of a preset, oldest first.
+func (h *PresetHandler) Versions(c *gin.Context) {
+	versions, err := h.presets.Versions(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to list preset versions", "preset", c.Param("name"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to list preset versions", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": newPresetResponses(versions)})
+}
+
+// Version returns a version of a preset, as recorded on the renditions
+// made with it.
+func (h *PresetHandler) Version(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		problem.Abort(c, http.StatusBadRequest, "Invalid version", "The version must be a positive integer")
+		return
+	}
+	p, err := h.presets.Version(c.Request.Context(), c.Param("name"), version)
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read preset version", "preset", c.Param("name"), "version", version, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read preset version", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newPresetResponse(p))
+}
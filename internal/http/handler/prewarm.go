@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/cdn"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+)
+
+// maxPrewarmFiles bounds the files listed in one prewarm request.
+const maxPrewarmFiles = 10000
+
+type PrewarmHandler struct {
+	prewarmer *cdn.Prewarmer
+	logger    *slog.Logger
+}
+
+// NewPrewarmHandler starts and reports prewarm runs of the CDN.
+func NewPrewarmHandler(prewarmer *cdn.Prewarmer, logger *slog.Logger) *PrewarmHandler {
+	return &PrewarmHandler{
+		prewarmer: prewarmer,
+		logger:    logger,
+	}
+}
+
+// PrewarmRequest names the files to prewarm: the listed ones and those of
+// a collection. Variants are paths under the URL of each file warmed as
+// well, e.g. preview or renditions/720p.
+type PrewarmRequest struct {
+	FileIDs    []string `json:"fileIds,omitempty"`
+	Collection string   `json:"collection,omitempty"`
+	Variants   []string `json:"variants,omitempty"`
+}
+
+// Start prewarms the files of the request in the background and answers
+// with the run reporting its progress.
+func (h *PrewarmHandler) Start(c *gin.Context) {
+	if !h.prewarmer.Enabled() {
+		problem.Abort(c, http.StatusNotImplemented, "CDN prewarming not configured", "Set MEDIA_CDN_EDGES or MEDIA_CDN_PREFETCH_URL")
+		return
+	}
+	var req PrewarmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	if len(req.FileIDs) > maxPrewarmFiles {
+		problem.Abort(c, http.StatusBadRequest, "Too many files", "Prewarm at most 10000 files at once, or a collection")
+		return
+	}
+
+	run, err := h.prewarmer.Start(c.Request.Context(), cdn.Request{
+		FileIDs:    req.FileIDs,
+		Collection: req.Collection,
+		Variants:   req.Variants,
+	})
+	if errors.Is(err, cdn.ErrNothingToPrewarm) {
+		problem.Abort(c, http.StatusBadRequest, "Nothing to prewarm", "Name files with fileIds or a collection with files")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to start prewarm", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to start prewarm", "")
+		return
+	}
+	c.JSON(http.StatusAccepted, run)
+}
+
+// List returns the recent prewarm runs, newest first.
+func (h *PrewarmHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, h.prewarmer.Runs())
+}
+
+// Get reports the progress of a prewarm run.
+func (h *PrewarmHandler) Get(c *gin.Context) {
+	run, err := h.prewarmer.Get(c.Param("runId"))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "Prewarm run not found", "")
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// Cancel stops a prewarm run.
+func (h *PrewarmHandler) Cancel(c *gin.Context) {
+	run, err := h.prewarmer.Cancel(c.Param("runId"))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "Prewarm run not found", "")
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
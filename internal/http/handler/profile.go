@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+)
+
+// Response profiles a client can request with
+// `Accept: application/json; profile="full"`. The profile may also be given
+// as a URI whose last path segment is the profile name.
+const (
+	ProfileMinimal = "minimal"
+	ProfileFull    = "full"
+)
+
+// renderJSON writes v as JSON, trimming it to what the client asked for.
+// Keys listed in extended are only returned with the full profile; a
+// `fields=` query parameter selects an explicit subset of keys instead.
+func renderJSON(c *gin.Context, status int, v any, extended ...string) {
+	c.Header("Vary", "Accept")
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to encode response", "")
+		return
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		// Not an object, nothing to select from.
+		c.Data(status, "application/json; charset=utf-8", data)
+		return
+	}
+
+	if fields := c.Query("fields"); fields != "" {
+		selected := make(map[string]any)
+		for _, f := range strings.Split(fields, ",") {
+			f = strings.TrimSpace(f)
+			if val, ok := body[f]; ok {
+				selected[f] = val
+			}
+		}
+		c.JSON(status, selected)
+		return
+	}
+
+	if requestedProfile(c) != ProfileFull {
+		for _, key := range extended {
+			delete(body, key)
+		}
+	}
+	c.JSON(status, body)
+}
+
+func requestedProfile(c *gin.Context) string {
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		profile := params["profile"]
+		if profile == "" {
+			continue
+		}
+		if i := strings.LastIndex(profile, "/"); i >= 0 {
+			profile = profile[i+1:]
+		}
+		return profile
+	}
+	return ProfileMinimal
+}
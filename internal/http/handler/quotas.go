@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type QuotaHandler struct {
+	quotas *media.QuotaService
+	logger *slog.Logger
+}
+
+func NewQuotaHandler(quotas *media.QuotaService, logger *slog.Logger) *QuotaHandler {
+	return &QuotaHandler{
+		quotas: quotas,
+		logger: logger,
+	}
+}
+
+type QuotaRequest struct {
+	MaxBytes int64 `json:"maxBytes"`
+	MaxFiles int64 `json:"maxFiles"`
+}
+
+type QuotaResponse struct {
+	OrgID     string    `json:"orgId"`
+	MaxBytes  int64     `json:"maxBytes"`
+	MaxFiles  int64     `json:"maxFiles"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func newQuotaResponse(q domain.Quota) QuotaResponse {
+	return QuotaResponse{
+		OrgID:     q.OrgID,
+		MaxBytes:  q.MaxBytes,
+		MaxFiles:  q.MaxFiles,
+		UpdatedBy: q.UpdatedBy,
+		UpdatedAt: q.UpdatedAt,
+	}
+}
+
+// UsageResponse reports what an organization stores and the processing it
+// used. Limits of 0 are not enforced.
+type UsageResponse struct {
+	OrgID      string                       `json:"orgId"`
+	Files      int64                        `json:"files"`
+	Bytes      int64                        `json:"bytes"`
+	MaxFiles   int64                        `json:"maxFiles"`
+	MaxBytes   int64                        `json:"maxBytes"`
+	ByType     map[string]TypeUsageResponse `json:"byType"`
+	Processing []ProcessingUsageResponse    `json:"processing"`
+	Plan       *PlanResponse                `json:"plan,omitempty"`
+	ReadOnly   bool                         `json:"readOnly"` // Limit reached; uploads are refused
+}
+
+// PlanResponse is the plan an organization is on. Zero limits are those
+// of the service.
+type PlanResponse struct {
+	Name          string   `json:"name"`
+	MaxFileSize   int64    `json:"maxFileSize"`
+	Features      []string `json:"features"` // Features the plan includes
+	RatePerMinute int      `json:"ratePerMinute"`
+	Burst         int      `json:"burst"`
+}
+
+type TypeUsageResponse struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ProcessingUsageResponse totals the processing jobs of one kind ran in a
+// month, e.g. "2026-01".
+type ProcessingUsageResponse struct {
+	Month        string  `json:"month"`
+	Kind         string  `json:"kind"`
+	Jobs         int64   `json:"jobs"`
+	CPUSeconds   float64 `json:"cpuSeconds"`
+	ScratchBytes int64   `json:"scratchBytes"`
+}
+
+func (h *QuotaHandler) List(c *gin.Context) {
+	quotas, err := h.quotas.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list quotas", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list quotas", "")
+		return
+	}
+
+	items := make([]QuotaResponse, 0, len(quotas))
+	for _, q := range quotas {
+		items = append(items, newQuotaResponse(q))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *QuotaHandler) Get(c *gin.Context) {
+	q, err := h.quotas.Get(c.Request.Context(), c.Param("orgId"))
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read quota", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read quota", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newQuotaResponse(q))
+}
+
+// Put replaces the quota of an organization. Limits of 0 fall back to the
+// defaults and negative limits lift them.
+func (h *QuotaHandler) Put(c *gin.Context) {
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	q, err := h.quotas.Put(c.Request.Context(), c.Param("orgId"), media.QuotaRequest{
+		MaxBytes:  req.MaxBytes,
+		MaxFiles:  req.MaxFiles,
+		UpdatedBy: callerID(c),
+	})
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to store quota", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to store quota", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newQuotaResponse(q))
+}
+
+func (h *QuotaHandler) Delete(c *gin.Context) {
+	if err := h.quotas.Delete(c.Request.Context(), c.Param("orgId")); err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to delete quota", "orgId", c.Param("orgId"), "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to delete quota", "")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Usage reports what an organization stores against its limits, for
+// billing.
+func (h *QuotaHandler) Usage(c *gin.Context) {
+	orgID := c.Param("orgId")
+	usage, err := h.quotas.Usage(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.Error("Failed to count usage", "orgId", orgID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to count usage", "")
+		return
+	}
+	processed, err := h.quotas.Processing(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.Error("Failed to list processing usage", "orgId", orgID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to count usage", "")
+		return
+	}
+
+	byType := make(map[string]TypeUsageResponse, len(usage.ByType))
+	for contentType, t := range usage.ByType {
+		byType[contentType] = TypeUsageResponse{Files: t.Files, Bytes: t.Bytes}
+	}
+	processing := make([]ProcessingUsageResponse, 0, len(processed))
+	for _, p := range processed {
+		processing = append(processing, ProcessingUsageResponse{
+			Month:        p.Month,
+			Kind:         p.Kind,
+			Jobs:         p.Jobs,
+			CPUSeconds:   p.CPUSeconds,
+			ScratchBytes: p.ScratchBytes,
+		})
+	}
+	response := UsageResponse{
+		OrgID:      usage.OrgID,
+		Files:      usage.Files,
+		Bytes:      usage.Bytes,
+		MaxFiles:   usage.Limits.MaxFiles,
+		MaxBytes:   usage.Limits.MaxBytes,
+		ByType:     byType,
+		Processing: processing,
+		ReadOnly:   usage.ReadOnly(),
+	}
+	if p := usage.Plan; p.Name != "" {
+		response.Plan = &PlanResponse{
+			Name:          p.Name,
+			MaxFileSize:   p.MaxFileSize,
+			Features:      p.Features,
+			RatePerMinute: p.RatePerMinute,
+			Burst:         p.Burst,
+		}
+		if p.Features == nil {
+			response.Plan.Features = plans.Features
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/replication"
+)
+
+// maxChangeSize bounds the body of a replicated write, which holds the
+// metadata of one file.
+const maxChangeSize = 4 << 20
+
+type ReplicationHandler struct {
+	replicas *replication.Replicator
+	logger   *slog.Logger
+}
+
+// NewReplicationHandler serves the writes and content peer regions
+// replicate, and reports how replicating to them fares.
+func NewReplicationHandler(replicas *replication.Replicator, logger *slog.Logger) *ReplicationHandler {
+	return &ReplicationHandler{
+		replicas: replicas,
+		logger:   logger,
+	}
+}
+
+type PeerStatusResponse struct {
+	Region      string     `json:"region"`
+	URL         string     `json:"url"`
+	Pending     int        `json:"pending"`
+	LagSeconds  float64    `json:"lagSeconds"`
+	Healthy     bool       `json:"healthy"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	RetryAt     *time.Time `json:"retryAt,omitempty"`
+}
+
+type ReplicationStatusResponse struct {
+	Status string               `json:"status"` // "ok", or "lagging" when a peer is unhealthy
+	Peers  []PeerStatusResponse `json:"peers"`
+}
+
+func newReplicationStatusResponse(statuses []replication.PeerStatus) ReplicationStatusResponse {
+	resp := ReplicationStatusResponse{Status: "ok", Peers: make([]PeerStatusResponse, 0, len(statuses))}
+	for _, s := range statuses {
+		peer := PeerStatusResponse{
+			Region:     s.Region,
+			URL:        s.URL,
+			Pending:    s.Pending,
+			LagSeconds: s.Lag.Seconds(),
+			Healthy:    s.Healthy,
+			LastError:  s.LastError,
+		}
+		if !s.LastSuccess.IsZero() {
+			peer.LastSuccess = &s.LastSuccess
+		}
+		if !s.LastErrorAt.IsZero() {
+			peer.LastErrorAt = &s.LastErrorAt
+		}
+		if !s.RetryAt.IsZero() {
+			peer.RetryAt = &s.RetryAt
+		}
+		if !s.Healthy {
+			resp.Status = "lagging"
+		}
+		resp.Peers = append(resp.Peers, peer)
+	}
+	return resp
+}
+
+// Apply applies a write to a file replicated by a peer region.
+func (h *ReplicationHandler) Apply(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxChangeSize))
+	if err != nil {
+		problem.Abort(c, http.StatusRequestEntityTooLarge, "Replicated write too large", "")
+		return
+	}
+	region, err := h.replicas.Verify(c.Request, body)
+	if err != nil {
+		h.abortReplication(c, err)
+		return
+	}
+	var change replication.Change
+	if err := json.Unmarshal(body, &change); err != nil || change.FileID != c.Param("fileId") {
+		problem.Abort(c, http.StatusBadRequest, "Invalid replicated write", "")
+		return
+	}
+
+	outcome, err := h.replicas.Apply(c.Request.Context(), region, change)
+	if err != nil {
+		h.abortReplication(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, outcome)
+}
+
+// Content serves the content of a file to a peer region replicating it.
+func (h *ReplicationHandler) Content(c *gin.Context) {
+	if _, err := h.replicas.Verify(c.Request, nil); err != nil {
+		h.abortReplication(c, err)
+		return
+	}
+	file, info, err := h.replicas.Content(c.Request.Context(), c.Param("fileId"))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+	defer file.Close()
+
+	c.Header("Cache-Control", "no-store")
+	serveReader(c, info.Size, info.ContentType, file)
+}
+
+// Health reports how replicating to each peer fares, answering 503 while
+// any lags behind by more than the maximum. It is for peers, which sign
+// the request, and administrators.
+func (h *ReplicationHandler) Health(c *gin.Context) {
+	if _, err := h.replicas.Verify(c.Request, nil); err != nil {
+		if authCtx, ok := auth.GetAuthContext(c); !ok || !authCtx.HasPermission("files:admin") {
+			h.abortReplication(c, err)
+			return
+		}
+	}
+	statuses, err := h.replicas.Status(c.Request.Context())
+	if err != nil {
+		h.abortReplication(c, err)
+		return
+	}
+	resp := newReplicationStatusResponse(statuses)
+	code := http.StatusOK
+	if resp.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, resp)
+}
+
+// Status reports how replicating to each peer fares.
+func (h *ReplicationHandler) Status(c *gin.Context) {
+	statuses, err := h.replicas.Status(c.Request.Context())
+	if err != nil {
+		h.abortReplication(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newReplicationStatusResponse(statuses))
+}
+
+// Resync queues every file for every peer region.
+func (h *ReplicationHandler) Resync(c *gin.Context) {
+	queued, err := h.replicas.Resync(c.Request.Context())
+	if err != nil {
+		h.abortReplication(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"queued": queued})
+}
+
+func (h *ReplicationHandler) abortReplication(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, replication.ErrUnauthorized):
+		problem.Abort(c, http.StatusUnauthorized, "Unauthorized", err.Error())
+	case errors.Is(err, replication.ErrInvalid):
+		problem.Abort(c, http.StatusBadRequest, "Invalid replicated write", "")
+	case errors.Is(err, replication.ErrPeerUnavailable):
+		h.logger.Warn("Failed to fetch replicated content", "error", err)
+		problem.Abort(c, http.StatusBadGateway, "Peer region unavailable", err.Error())
+	default:
+		h.logger.Error("Replication failed", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Replication failed", "")
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+// SettingsHandler serves the self-service settings of an organization to
+// its admins: tokens of the organization with the org:admin permission.
+// Platform admins with files:admin may manage any organization.
+type SettingsHandler struct {
+	settings *media.SettingsService
+	logger   *slog.Logger
+}
+
+func NewSettingsHandler(settings *media.SettingsService, logger *slog.Logger) *SettingsHandler {
+	return &SettingsHandler{
+		settings: settings,
+		logger:   logger,
+	}
+}
+
+// SettingsRequest changes the settings present; others are left as they
+// are. Empty lists clear a setting.
+type SettingsRequest struct {
+	WebhookURLs  *[]string        `json:"webhookUrls"`
+	AllowedTypes *[]string        `json:"allowedTypes"`
+	Branding     *BrandingRequest `json:"branding"`
+
+	// DefaultVisibility of uploads: public, org or private; "" for the
+	// default of the service.
+	DefaultVisibility *string `json:"defaultVisibility"`
+}
+
+type SettingsResponse struct {
+	OrgID             string            `json:"orgId"`
+	WebhookURLs       []string          `json:"webhookUrls"`
+	WebhookSecret     string            `json:"webhookSecret,omitempty"`     // Key of the X-Media-Signature HMAC of deliveries
+	AllowedTypes      []string          `json:"allowedTypes"`                // Empty for all the service accepts
+	DefaultVisibility string            `json:"defaultVisibility,omitempty"` // Empty for the default of the service
+	Branding          *BrandingResponse `json:"branding,omitempty"`
+	Permitted         []string          `json:"permitted"` // Settings the organization may change
+	UpdatedBy         string            `json:"updatedBy,omitempty"`
+	UpdatedAt         *time.Time        `json:"updatedAt,omitempty"`
+}
+
+func newSettingsResponse(s media.Settings) SettingsResponse {
+	resp := SettingsResponse{
+		OrgID:             s.OrgID,
+		WebhookURLs:       s.WebhookURLs,
+		WebhookSecret:     s.WebhookSecret,
+		AllowedTypes:      s.AllowedTypes,
+		DefaultVisibility: string(s.DefaultVisibility),
+		Permitted:         s.Permitted,
+		UpdatedBy:         s.UpdatedBy,
+	}
+	if !s.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &s.UpdatedAt
+	}
+	if s.Branding != nil {
+		branding := newBrandingResponse(*s.Branding)
+		resp.Branding = &branding
+	}
+	for _, list := range []*[]string{&resp.WebhookURLs, &resp.AllowedTypes, &resp.Permitted} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return resp
+}
+
+func (h *SettingsHandler) Get(c *gin.Context) {
+	orgID, ok := orgAdmin(c)
+	if !ok {
+		return
+	}
+	settings, err := h.settings.Get(c.Request.Context(), orgID)
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to read organization settings", "orgId", orgID, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read organization settings", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newSettingsResponse(settings))
+}
+
+func (h *SettingsHandler) Update(c *gin.Context) {
+	orgID, ok := orgAdmin(c)
+	if !ok {
+		return
+	}
+	var req SettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	update := media.SettingsRequest{
+		WebhookURLs:  req.WebhookURLs,
+		AllowedTypes: req.AllowedTypes,
+		UpdatedBy:    callerID(c),
+	}
+	if req.DefaultVisibility != nil {
+		visibility := domain.Visibility(*req.DefaultVisibility)
+		update.DefaultVisibility = &visibility
+	}
+	if b := req.Branding; b != nil {
+		update.Branding = &media.BrandingRequest{
+			Logo:         b.Logo,
+			LogoPosition: b.LogoPosition,
+			LogoOpacity:  b.LogoOpacity,
+			Palette:      b.Palette,
+			Fonts:        b.Fonts,
+		}
+	}
+	settings, err := h.settings.Put(c.Request.Context(), orgID, update)
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to update organization settings", "orgId", orgID, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to update organization settings", "")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, newSettingsResponse(settings))
+}
+
+// orgAdmin returns the organization of the request if the caller
+// administers it, and aborts the request otherwise.
+func orgAdmin(c *gin.Context) (string, bool) {
+	orgID := c.Param("orgId")
+	authCtx, _ := auth.GetAuthContext(c)
+	if authCtx.HasPermission("files:admin") {
+		return orgID, true
+	}
+	if authCtx.HasPermission("org:admin") && authCtx.OrgID != nil && *authCtx.OrgID == orgID {
+		return orgID, true
+	}
+	problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Only admins of the organization may manage its settings")
+	return "", false
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+const (
+	shortCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+	shortCodeLength   = 7
+)
+
+var shortCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+// shortLinkParams are the query parameters a short link may pin on its
+// target.
+var shortLinkParams = map[string]bool{"size": true, "format": true}
+
+type ShortLinkHandler struct {
+	files      *media.FileService
+	links      metadata.ShortLinkStore
+	publicBase string
+	audit      *audit.Trail
+	logger     *slog.Logger
+}
+
+func NewShortLinkHandler(files *media.FileService, links metadata.ShortLinkStore, publicBaseURL string, trail *audit.Trail, logger *slog.Logger) *ShortLinkHandler {
+	return &ShortLinkHandler{
+		files:      files,
+		links:      links,
+		publicBase: strings.TrimRight(publicBaseURL, "/"),
+		audit:      trail,
+		logger:     logger,
+	}
+}
+
+type ShortLinkRequest struct {
+	Code      string            `json:"code"`
+	Params    map[string]string `json:"params"`
+	Signed    bool              `json:"signed"`
+	ExpiresAt *time.Time        `json:"expiresAt"`
+}
+
+type ShortLinkResponse struct {
+	Code      string            `json:"code"`
+	ShortURL  string            `json:"shortUrl"`
+	FileID    string            `json:"fileId"`
+	Params    map[string]string `json:"params,omitempty"`
+	Signed    bool              `json:"signed,omitempty"`
+	Hits      int64             `json:"hits"`
+	ExpiresAt *time.Time        `json:"expiresAt,omitempty"`
+	CreatedBy string            `json:"createdBy,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+func (h *ShortLinkHandler) newResponse(l domain.ShortLink) ShortLinkResponse {
+	var params map[string]string
+	if values, err := url.ParseQuery(l.Query); err == nil && len(values) > 0 {
+		params = make(map[string]string, len(values))
+		for k := range values {
+			params[k] = values.Get(k)
+		}
+	}
+	return ShortLinkResponse{
+		Code:      l.Code,
+		ShortURL:  h.publicBase + "/s/" + l.Code,
+		FileID:    l.FileID,
+		Params:    params,
+		Signed:    l.Signed,
+		Hits:      l.Hits,
+		ExpiresAt: l.ExpiresAt,
+		CreatedBy: l.CreatedBy,
+		CreatedAt: l.CreatedAt,
+	}
+}
+
+func (h *ShortLinkHandler) Create(c *gin.Context) {
+	ctx := c.Request.Context()
+	fileID := c.Param("fileId")
+	meta, ok := ownedFile(c, h.files, fileID)
+	if !ok {
+		return
+	}
+
+	var req ShortLinkRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	if !req.Signed && !meta.Public() {
+		problem.Abort(c, http.StatusBadRequest, "Invalid short link", "Links to files that are not public must be signed")
+		return
+	}
+
+	query := url.Values{}
+	for k, v := range req.Params {
+		if !shortLinkParams[k] {
+			problem.Abort(c, http.StatusBadRequest, "Invalid short link", "Unsupported parameter "+k)
+			return
+		}
+		query.Set(k, v)
+	}
+
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		problem.Abort(c, http.StatusBadRequest, "Invalid short link", "expiresAt must be in the future")
+		return
+	}
+
+	link := domain.ShortLink{
+		FileID:    fileID,
+		Query:     query.Encode(),
+		Signed:    req.Signed,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: callerID(c),
+		CreatedAt: now,
+	}
+
+	if req.Code != "" {
+		if !shortCodePattern.MatchString(req.Code) {
+			problem.Abort(c, http.StatusBadRequest, "Invalid short link", "Codes are 3 to 32 letters, digits, '_' and '-'")
+			return
+		}
+		if _, err := h.links.GetShortLink(ctx, req.Code); err == nil {
+			problem.Abort(c, http.StatusConflict, "Short link code already exists", "")
+			return
+		}
+		link.Code = req.Code
+	} else {
+		code, err := h.freeCode(c)
+		if err != nil {
+			h.logger.Error("Failed to generate short link code", "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to create short link", "")
+			return
+		}
+		link.Code = code
+	}
+
+	// Refuse links that could not be followed, e.g. signed ones without
+	// a signing key.
+	if _, err := h.files.LinkURL(link); err != nil {
+		abortMedia(c, err)
+		return
+	}
+	if err := h.links.PutShortLink(ctx, link); err != nil {
+		h.logger.Error("Failed to store short link", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create short link", "")
+		return
+	}
+	h.audit.Record(ctx, domain.AuditShortLinkCreate, fileID, map[string]string{"code": link.Code})
+	c.JSON(http.StatusCreated, h.newResponse(link))
+}
+
+func (h *ShortLinkHandler) List(c *gin.Context) {
+	fileID := c.Param("fileId")
+	if _, ok := ownedFile(c, h.files, fileID); !ok {
+		return
+	}
+	links, err := h.links.ListShortLinks(c.Request.Context(), fileID)
+	if err != nil {
+		h.logger.Error("Failed to list short links", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list short links", "")
+		return
+	}
+
+	items := make([]ShortLinkResponse, 0, len(links))
+	for _, l := range links {
+		items = append(items, h.newResponse(l))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// Delete removes a short link of a file the caller owns. Administrators
+// may also delete links whose file is gone.
+func (h *ShortLinkHandler) Delete(c *gin.Context) {
+	ctx := c.Request.Context()
+	link, err := h.links.GetShortLink(ctx, c.Param("code"))
+	if errors.Is(err, metadata.ErrNotFound) {
+		problem.Abort(c, http.StatusNotFound, "Short link not found", "")
+		return
+	}
+	if err == nil {
+		authCtx, _ := auth.GetAuthContext(c)
+		if !authCtx.HasPermission("files:admin") {
+			if _, ok := ownedFile(c, h.files, link.FileID); !ok {
+				return
+			}
+		}
+		err = h.links.DeleteShortLink(ctx, link.Code)
+	}
+	if errors.Is(err, metadata.ErrNotFound) {
+		problem.Abort(c, http.StatusNotFound, "Short link not found", "")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete short link", "code", c.Param("code"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete short link", "")
+		return
+	}
+	h.audit.Record(ctx, domain.AuditShortLinkDelete, link.FileID, map[string]string{"code": link.Code})
+	c.Status(http.StatusNoContent)
+}
+
+// Resolve redirects a short code to its file, signed until the link
+// expires for signed links, and counts the hit. Redirects are temporary so
+// expiry and deletion take effect for clients that followed the link
+// before.
+func (h *ShortLinkHandler) Resolve(c *gin.Context) {
+	ctx := c.Request.Context()
+	link, err := h.links.GetShortLink(ctx, c.Param("code"))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "Short link not found", "")
+		return
+	}
+	if link.Expired(time.Now()) {
+		problem.Abort(c, http.StatusGone, "Short link expired", "")
+		return
+	}
+
+	target, err := h.files.LinkURL(link)
+	if err != nil {
+		h.logger.Error("Failed to resolve short link", "code", link.Code, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to resolve short link", "")
+		return
+	}
+	if _, err := h.links.RecordShortLinkHit(ctx, link.Code); err != nil {
+		h.logger.Warn("Failed to count short link hit", "code", link.Code, "error", err)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target)
+}
+
+// freeCode generates a random code not used by another link.
+func (h *ShortLinkHandler) freeCode(c *gin.Context) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomCode(shortCodeLength)
+		if err != nil {
+			return "", err
+		}
+		if _, err := h.links.GetShortLink(c.Request.Context(), code); errors.Is(err, metadata.ErrNotFound) {
+			return code, nil
+		}
+	}
+	return "", errors.New("no free code found")
+}
+
+func randomCode(n int) (string, error) {
+	alphabet := big.NewInt(int64(len(shortCodeAlphabet)))
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, alphabet)
+		if err != nil {
+			return "", err
+		}
+		b[i] = shortCodeAlphabet[idx.Int64()]
+	}
+	return string(b), nil
+}
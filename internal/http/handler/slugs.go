@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+// slugPattern restricts slugs to lower-case file names that are safe in a
+// URL path without escaping.
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,127}$`)
+
+// reservedSlugs are names kept for pages the service may publish for an
+// organization under /p/.
+var reservedSlugs = map[string]bool{
+	"index.html":  true,
+	"robots.txt":  true,
+	"favicon.ico": true,
+	"sitemap.xml": true,
+	"feed.xml":    true,
+	"rss.xml":     true,
+	"feed.json":   true,
+}
+
+type SlugHandler struct {
+	files      metadata.Store
+	slugs      metadata.SlugStore
+	storage    storage.Storage
+	publicBase string
+	audit      *audit.Trail
+	logger     *slog.Logger
+}
+
+func NewSlugHandler(files metadata.Store, slugs metadata.SlugStore, storage storage.Storage, publicBaseURL string, trail *audit.Trail, logger *slog.Logger) *SlugHandler {
+	return &SlugHandler{
+		files:      files,
+		slugs:      slugs,
+		storage:    storage,
+		publicBase: strings.TrimRight(publicBaseURL, "/"),
+		audit:      trail,
+		logger:     logger,
+	}
+}
+
+type SlugRequest struct {
+	FileID string `json:"fileId"`
+}
+
+type SlugResponse struct {
+	Slug           string    `json:"slug"`
+	URL            string    `json:"url"`
+	FileID         string    `json:"fileId"`
+	PreviousFileID string    `json:"previousFileId,omitempty"`
+	CreatedBy      string    `json:"createdBy,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedBy      string    `json:"updatedBy,omitempty"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+func (h *SlugHandler) newResponse(s domain.Slug) SlugResponse {
+	return SlugResponse{
+		Slug:           s.Name,
+		URL:            h.publicBase + "/p/" + s.OrgID + "/" + s.Name,
+		FileID:         s.FileID,
+		PreviousFileID: s.PreviousFileID,
+		CreatedBy:      s.CreatedBy,
+		CreatedAt:      s.CreatedAt,
+		UpdatedBy:      s.UpdatedBy,
+		UpdatedAt:      s.UpdatedAt,
+	}
+}
+
+// callerOrg returns the organization of the caller. Slugs are unique per
+// organization, so callers without one are refused.
+func callerOrg(c *gin.Context) (string, bool) {
+	if authCtx, ok := auth.GetAuthContext(c); ok && authCtx.OrgID != nil {
+		return *authCtx.OrgID, true
+	}
+	problem.Abort(c, http.StatusForbidden, "Slugs belong to an organization", "The token carries no organization")
+	return "", false
+}
+
+func (h *SlugHandler) List(c *gin.Context) {
+	orgID, ok := callerOrg(c)
+	if !ok {
+		return
+	}
+
+	slugs, err := h.slugs.ListSlugs(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.Error("Failed to list slugs", "orgId", orgID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list slugs", "")
+		return
+	}
+
+	items := make([]SlugResponse, 0, len(slugs))
+	for _, s := range slugs {
+		items = append(items, h.newResponse(s))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// Put points a slug at a file of the caller's organization, creating the
+// slug if needed. Repointing an existing slug to a re-uploaded file keeps
+// its published URL.
+func (h *SlugHandler) Put(c *gin.Context) {
+	ctx := c.Request.Context()
+	orgID, ok := callerOrg(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("slug")
+	if !slugPattern.MatchString(name) {
+		problem.Abort(c, http.StatusBadRequest, "Invalid slug", "Slugs are up to 128 lower-case letters, digits, '.', '_' and '-'")
+		return
+	}
+	if reservedSlugs[name] {
+		problem.Abort(c, http.StatusConflict, "Slug is reserved", "")
+		return
+	}
+
+	var req SlugRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	meta, err := h.files.Get(ctx, req.FileID)
+	if err != nil || !meta.Servable() || meta.OrgID != orgID {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return
+	}
+
+	now := time.Now().UTC()
+	caller := callerID(c)
+	status := http.StatusOK
+	slug, err := h.slugs.GetSlug(ctx, orgID, name)
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		status = http.StatusCreated
+		slug = domain.Slug{OrgID: orgID, Name: name, CreatedBy: caller, CreatedAt: now}
+	case err != nil:
+		h.logger.Error("Failed to read slug", "orgId", orgID, "slug", name, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store slug", "")
+		return
+	case slug.FileID == meta.ID:
+		c.JSON(http.StatusOK, h.newResponse(slug))
+		return
+	default:
+		slug.PreviousFileID = slug.FileID
+	}
+	slug.FileID = meta.ID
+	slug.UpdatedBy = caller
+	slug.UpdatedAt = now
+
+	if err := h.slugs.PutSlug(ctx, slug); err != nil {
+		h.logger.Error("Failed to store slug", "orgId", orgID, "slug", name, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to store slug", "")
+		return
+	}
+	details := map[string]string{"slug": name}
+	if slug.PreviousFileID != "" {
+		details["previousFileId"] = slug.PreviousFileID
+	}
+	h.audit.Record(ctx, domain.AuditSlugSet, meta.ID, details)
+	c.JSON(status, h.newResponse(slug))
+}
+
+func (h *SlugHandler) Delete(c *gin.Context) {
+	ctx := c.Request.Context()
+	orgID, ok := callerOrg(c)
+	if !ok {
+		return
+	}
+
+	slug, err := h.slugs.GetSlug(ctx, orgID, c.Param("slug"))
+	if err == nil {
+		err = h.slugs.DeleteSlug(ctx, orgID, slug.Name)
+	}
+	if errors.Is(err, metadata.ErrNotFound) {
+		problem.Abort(c, http.StatusNotFound, "Slug not found", "")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete slug", "orgId", orgID, "slug", c.Param("slug"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete slug", "")
+		return
+	}
+	h.audit.Record(ctx, domain.AuditSlugDelete, slug.FileID, map[string]string{"slug": slug.Name})
+	c.Status(http.StatusNoContent)
+}
+
+// Resolve redirects a public slug to the file it currently points at. As
+// slugs are repointed, redirects are temporary and not cached.
+func (h *SlugHandler) Resolve(c *gin.Context) {
+	ctx := c.Request.Context()
+	slug, err := h.slugs.GetSlug(ctx, c.Param("orgId"), c.Param("slug"))
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "Not found", "")
+		return
+	}
+	if meta, err := h.files.Get(ctx, slug.FileID); err != nil || !meta.Servable() {
+		problem.Abort(c, http.StatusNotFound, "Not found", "")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, h.storage.URL(slug.FileID))
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/takedown"
+)
+
+type TakedownHandler struct {
+	desk   *takedown.Desk
+	logger *slog.Logger
+}
+
+func NewTakedownHandler(desk *takedown.Desk, logger *slog.Logger) *TakedownHandler {
+	return &TakedownHandler{
+		desk:   desk,
+		logger: logger,
+	}
+}
+
+type TakedownRequest struct {
+	Claimant string `json:"claimant" binding:"required"`
+	Contact  string `json:"contact" binding:"required"`
+	Work     string `json:"work" binding:"required"`
+	Reason   string `json:"reason"`
+}
+
+type CounterNoticeRequest struct {
+	Statement string `json:"statement" binding:"required"`
+}
+
+type TakedownResponse struct {
+	FileID        string                 `json:"fileId"`
+	OriginalName  string                 `json:"originalName,omitempty"`
+	OwnerID       string                 `json:"ownerId,omitempty"`
+	OrgID         string                 `json:"orgId,omitempty"`
+	Status        domain.FileStatus      `json:"status"`
+	Claim         *ClaimResponse         `json:"claim,omitempty"`
+	CounterNotice *CounterNoticeResponse `json:"counterNotice,omitempty"`
+	History       []AuditEntryResponse   `json:"history,omitempty"`
+}
+
+type ClaimResponse struct {
+	Claimant    string    `json:"claimant"`
+	Contact     string    `json:"contact"`
+	Work        string    `json:"work"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestedBy string    `json:"requestedBy,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+type CounterNoticeResponse struct {
+	Statement string    `json:"statement"`
+	FiledBy   string    `json:"filedBy,omitempty"`
+	FiledAt   time.Time `json:"filedAt"`
+}
+
+// List returns the files currently taken down.
+func (h *TakedownHandler) List(c *gin.Context) {
+	files, err := h.desk.TakenDown(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list takedowns", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list takedowns", "")
+		return
+	}
+
+	items := make([]TakedownResponse, 0, len(files))
+	for _, meta := range files {
+		items = append(items, newTakedownResponse(meta))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": len(items)})
+}
+
+// Get returns the takedown state of a file with its takedown history.
+func (h *TakedownHandler) Get(c *gin.Context) {
+	fileID := c.Param("fileId")
+	meta, history, err := h.desk.Get(c.Request.Context(), fileID)
+	if err != nil {
+		h.abortTakedown(c, fileID, err)
+		return
+	}
+
+	resp := newTakedownResponse(meta)
+	for _, e := range history {
+		resp.History = append(resp.History, AuditEntryResponse{
+			ID:       e.ID,
+			Action:   e.Action,
+			FileID:   e.FileID,
+			ActorID:  e.ActorID,
+			ClientIP: e.ClientIP,
+			Details:  e.Details,
+			At:       e.At,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Create takes a file down under the copyright claim in the body.
+func (h *TakedownHandler) Create(c *gin.Context) {
+	var req TakedownRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	fileID := c.Param("fileId")
+	claim := domain.Takedown{
+		Claimant: strings.TrimSpace(req.Claimant),
+		Contact:  strings.TrimSpace(req.Contact),
+		Work:     strings.TrimSpace(req.Work),
+		Reason:   strings.TrimSpace(req.Reason),
+	}
+	if claim.Claimant == "" || claim.Contact == "" || claim.Work == "" {
+		problem.Abort(c, http.StatusBadRequest, "Incomplete claim", "claimant, contact and work are required")
+		return
+	}
+	meta, err := h.desk.TakeDown(c.Request.Context(), fileID, claim, callerID(c))
+	if err != nil {
+		h.abortTakedown(c, fileID, err)
+		return
+	}
+	c.JSON(http.StatusCreated, newTakedownResponse(meta))
+}
+
+// CounterNotice records the uploader's counter-notice to a takedown.
+func (h *TakedownHandler) CounterNotice(c *gin.Context) {
+	var req CounterNoticeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	fileID := c.Param("fileId")
+	meta, err := h.desk.CounterNotice(c.Request.Context(), fileID, strings.TrimSpace(req.Statement), callerID(c))
+	if err != nil {
+		h.abortTakedown(c, fileID, err)
+		return
+	}
+	c.JSON(http.StatusOK, newTakedownResponse(meta))
+}
+
+// Restore serves a taken down file again.
+func (h *TakedownHandler) Restore(c *gin.Context) {
+	var req moderationRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
+	fileID := c.Param("fileId")
+	meta, err := h.desk.Restore(c.Request.Context(), fileID, req.Reason, callerID(c))
+	if err != nil {
+		h.abortTakedown(c, fileID, err)
+		return
+	}
+	c.JSON(http.StatusOK, newTakedownResponse(meta))
+}
+
+func (h *TakedownHandler) abortTakedown(c *gin.Context, fileID string, err error) {
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+	case errors.Is(err, takedown.ErrTakenDown):
+		problem.Abort(c, http.StatusConflict, "File is already taken down", "")
+	case errors.Is(err, takedown.ErrNotTakenDown):
+		problem.Abort(c, http.StatusConflict, "File is not taken down", "")
+	default:
+		h.logger.Error("Takedown action failed", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Takedown action failed", "")
+	}
+}
+
+func newTakedownResponse(meta domain.FileMetadata) TakedownResponse {
+	resp := TakedownResponse{
+		FileID:       meta.ID,
+		OriginalName: meta.OriginalName,
+		OwnerID:      meta.OwnerID,
+		OrgID:        meta.OrgID,
+		Status:       meta.Status,
+	}
+	if resp.Status == "" {
+		resp.Status = domain.FileStatusActive
+	}
+	if t := meta.Takedown; t != nil {
+		resp.Claim = &ClaimResponse{
+			Claimant:    t.Claimant,
+			Contact:     t.Contact,
+			Work:        t.Work,
+			Reason:      t.Reason,
+			RequestedBy: t.RequestedBy,
+			RequestedAt: t.RequestedAt,
+		}
+		if n := t.CounterNotice; n != nil {
+			resp.CounterNotice = &CounterNoticeResponse{
+				Statement: n.Statement,
+				FiledBy:   n.FiledBy,
+				FiledAt:   n.FiledAt,
+			}
+		}
+	}
+	return resp
+}
@@ -1,167 +1,1250 @@
 package handler
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/checksum"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
 )
 
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details,omitempty"`
-}
+const (
+	defaultFileListLimit = 100
+	maxFileListLimit     = 1000
+)
+
+// customIDPermission allows uploads to choose their own file ID, e.g. to
+// keep identifiers when migrating from another system.
+const customIDPermission = "files:custom_id"
 
+// UploadHandler adapts the media services to HTTP.
 type UploadHandler struct {
-	storage     storage.Storage
-	maxSize     int64
-	allowedMIME map[string]bool
-	logger      *slog.Logger
+	uploads *media.UploadService
+	files   *media.FileService
+	aliases metadata.AliasStore
+	logger  *slog.Logger
 }
 
-func NewUploadHandler(storage storage.Storage, maxSize int64, logger *slog.Logger) *UploadHandler {
-	allowedMIME := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/webp": true,
-	}
-
+func NewUploadHandler(uploads *media.UploadService, files *media.FileService, aliases metadata.AliasStore, logger *slog.Logger) *UploadHandler {
 	return &UploadHandler{
-		storage:     storage,
-		maxSize:     maxSize,
-		allowedMIME: allowedMIME,
-		logger:      logger,
+		uploads: uploads,
+		files:   files,
+		aliases: aliases,
+		logger:  logger,
 	}
 }
 
 type UploadResponse struct {
-	FileID      string `json:"fileId"`
-	URL         string `json:"url"`
-	ContentType string `json:"contentType"`
-	Size        int64  `json:"size"`
+	FileID      string            `json:"fileId"`
+	URL         string            `json:"url"`
+	ContentType string            `json:"contentType"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256,omitempty"` // Of the file as stored, after any processing
+	Status      string            `json:"status"`
+	Visibility  string            `json:"visibility"`
+	Region      string            `json:"region,omitempty"` // Home region, whose deployment stores the file
+	Image       *ImageResponse    `json:"image,omitempty"`
+	Video       *VideoResponse    `json:"video,omitempty"`
+	Audio       *AudioResponse    `json:"audio,omitempty"`
+	Scan        *ScanResponse     `json:"scan,omitempty"`
+	QC          *QCResponse       `json:"qc,omitempty"`
+	Chapters    []ChapterResponse `json:"chapters,omitempty"`
+	PreviewURL  string            `json:"previewUrl,omitempty"`
+	AltText     string            `json:"altText,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Credit      string            `json:"credit,omitempty"`
+	License     string            `json:"license,omitempty"`
+
+	RightsHolder     string     `json:"rightsHolder,omitempty"`
+	LicenseExpiresAt *time.Time `json:"licenseExpiresAt,omitempty"`
+	AvailableFrom    *time.Time `json:"availableFrom,omitempty"`
+	AvailableUntil   *time.Time `json:"availableUntil,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+
+	// Extended fields, only returned with the full response profile.
+	OriginalName string            `json:"originalName,omitempty"`
+	Directory    string            `json:"directory,omitempty"`
+	Collection   string            `json:"collection,omitempty"`
+	Source       string            `json:"source,omitempty"`
+	Checksums    map[string]string `json:"checksums,omitempty"`
+	Multihashes  []string          `json:"multihashes,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt" openapi:"optional"`
+
+	// Derived lists the assets generated from the content, and
+	// Relationships the files the file is tied to.
+	Derived       []DerivedAssetResponse `json:"derived,omitempty"`
+	Relationships *RelationshipsResponse `json:"relationships,omitempty"`
+
+	// Provenance is only returned to administrators.
+	Provenance *ProvenanceResponse `json:"provenance,omitempty"`
+}
+
+// DerivedAssetResponse is an asset generated from the content of a file:
+// a preview, poster, teaser, waveform, normalized copy, recognized text,
+// sized image or video rendition, or HLS playlist.
+type DerivedAssetResponse struct {
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+// RelationshipsResponse ties a file to others: the file it was copied
+// from and its superseded versions.
+type RelationshipsResponse struct {
+	CopiedFrom  string `json:"copiedFrom,omitempty"`
+	Versions    int    `json:"versions,omitempty"`
+	VersionsURL string `json:"versionsUrl,omitempty"`
+}
+
+// newDerivedAssetResponses lists the derived assets of meta that can be
+// requested; text is recognized on first request.
+func newDerivedAssetResponses(meta domain.FileMetadata, fileURL, previewURL string) []DerivedAssetResponse {
+	var assets []DerivedAssetResponse
+	add := func(kind, url string) {
+		assets = append(assets, DerivedAssetResponse{Kind: kind, URL: url})
+	}
+	if previewURL != "" {
+		add("preview", previewURL)
+	}
+	if img := meta.Image; img != nil {
+		for _, size := range img.Renditions {
+			add("size", fileURL+"?size="+strconv.Itoa(size))
+		}
+		if imaging.Decodable(meta.ContentType) {
+			add("text", fileURL+"/text")
+		}
+	}
+	if v := meta.Video; v != nil {
+		add("poster", fileURL+"/poster")
+		add("teaser", fileURL+"/teaser")
+		if job := v.Transcode; job != nil {
+			for _, r := range job.Renditions {
+				add("rendition", fileURL+"/renditions/"+domain.RenditionName(r.Height))
+			}
+			if job.HLS {
+				add("hls", fileURL+"/hls/"+media.MasterPlaylist)
+			}
+		}
+	}
+	if a := meta.Audio; a != nil {
+		add("waveform", fileURL+"/waveform")
+		if a.Loudness != nil {
+			add("normalized", fileURL+"/normalized")
+		}
+	}
+	return assets
+}
+
+func newRelationshipsResponse(meta domain.FileMetadata, fileURL string) *RelationshipsResponse {
+	var relationships RelationshipsResponse
+	if p := meta.Provenance; p != nil && p.Channel == domain.ChannelCopy {
+		relationships.CopiedFrom = p.From
+	}
+	if len(meta.Versions) > 0 {
+		relationships.Versions = len(meta.Versions)
+		relationships.VersionsURL = fileURL + "/versions"
+	}
+	if relationships == (RelationshipsResponse{}) {
+		return nil
+	}
+	return &relationships
+}
+
+type ProvenanceResponse struct {
+	Channel  string            `json:"channel"`
+	ActorID  string            `json:"actorId,omitempty"`
+	ClientIP string            `json:"clientIp,omitempty"`
+	Client   string            `json:"client,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	From     string            `json:"from,omitempty"` // The file copied, or the region replicated from
+	At       time.Time         `json:"at"`
+}
+
+func newProvenanceResponse(p *domain.Provenance) *ProvenanceResponse {
+	if p == nil {
+		return nil
+	}
+	return &ProvenanceResponse{
+		Channel:  p.Channel,
+		ActorID:  p.ActorID,
+		ClientIP: p.ClientIP,
+		Client:   p.Client,
+		Headers:  p.Headers,
+		From:     p.From,
+		At:       p.At,
+	}
+}
+
+type ImageResponse struct {
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Orientation int               `json:"orientation"`
+	Exif        map[string]string `json:"exif,omitempty"`
+	Colors      *ColorsResponse   `json:"colors,omitempty"`
+	Sizes       []int             `json:"sizes,omitempty"`
+	Frames      int               `json:"frames,omitempty"`
+}
+
+type VideoResponse struct {
+	Duration   float64            `json:"duration"`
+	Width      int                `json:"width"`
+	Height     int                `json:"height"`
+	VideoCodec string             `json:"videoCodec"`
+	AudioCodec string             `json:"audioCodec,omitempty"`
+	PosterURL  string             `json:"posterUrl"`
+	TeaserURL  string             `json:"teaserUrl"`
+	Transcode  *TranscodeResponse `json:"transcode,omitempty"`
+}
+
+type AudioResponse struct {
+	Duration    float64           `json:"duration"`
+	WaveformURL string            `json:"waveformUrl"`
+	Loudness    *LoudnessResponse `json:"loudness,omitempty"`
+}
+
+// LoudnessResponse is the measured loudness of an audio file and the URL
+// of its normalized copy.
+type LoudnessResponse struct {
+	IntegratedLUFS float64 `json:"integratedLufs"`
+	TruePeak       float64 `json:"truePeak"`
+	Range          float64 `json:"range"`
+	TargetLUFS     float64 `json:"targetLufs"`
+	NormalizedURL  string  `json:"normalizedUrl"`
+}
+
+func newAudioResponse(a *domain.AudioMetadata, fileURL string) *AudioResponse {
+	if a == nil {
+		return nil
+	}
+	response := &AudioResponse{Duration: a.Duration, WaveformURL: fileURL + "/waveform"}
+	if l := a.Loudness; l != nil {
+		response.Loudness = &LoudnessResponse{
+			IntegratedLUFS: l.Integrated,
+			TruePeak:       l.TruePeak,
+			Range:          l.Range,
+			TargetLUFS:     l.Target,
+			NormalizedURL:  fileURL + "/normalized",
+		}
+	}
+	return response
+}
+
+// ScanResponse is the outcome of the malware scan of a file: "pending",
+// "clean", "infected" or "failed".
+type ScanResponse struct {
+	Status    string     `json:"status"`
+	Signature string     `json:"signature,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ScannedAt *time.Time `json:"scannedAt,omitempty"`
+}
+
+func newScanResponse(scan *domain.Scan) *ScanResponse {
+	if scan == nil {
+		return nil
+	}
+	response := &ScanResponse{Status: string(scan.Status), Signature: scan.Signature, Error: scan.Error}
+	if !scan.ScannedAt.IsZero() {
+		response.ScannedAt = &scan.ScannedAt
+	}
+	return response
+}
+
+// QCResponse is the outcome of the quality checks of a delivered video or
+// audio file: "pending", "passed", "failed" or "error".
+type QCResponse struct {
+	Status    string              `json:"status"`
+	Findings  []QCFindingResponse `json:"findings,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	CheckedAt *time.Time          `json:"checkedAt,omitempty"`
+}
+
+type QCFindingResponse struct {
+	Check  string  `json:"check"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Detail string  `json:"detail"`
+}
+
+func newQCResponse(check *domain.QualityCheck) *QCResponse {
+	if check == nil {
+		return nil
+	}
+	response := &QCResponse{Status: string(check.Status), Error: check.Error}
+	for _, f := range check.Findings {
+		response.Findings = append(response.Findings, QCFindingResponse{Check: f.Check, Start: f.Start, End: f.End, Detail: f.Detail})
+	}
+	if !check.CheckedAt.IsZero() {
+		response.CheckedAt = &check.CheckedAt
+	}
+	return response
+}
+
+type TranscodeResponse struct {
+	Status     string              `json:"status"`
+	Error      string              `json:"error,omitempty"`
+	UpdatedAt  time.Time           `json:"updatedAt"`
+	Renditions []RenditionResponse `json:"renditions,omitempty"`
+	HLSURL     string              `json:"hlsUrl,omitempty"`
+
+	// Preset and PresetVersion name the transcode preset the renditions
+	// were encoded with; empty for the defaults.
+	Preset        string `json:"preset,omitempty"`
+	PresetVersion int    `json:"presetVersion,omitempty"`
+
+	// Processing the job used, once it finished.
+	CPUSeconds   float64 `json:"cpuSeconds,omitempty"`
+	ScratchBytes int64   `json:"scratchBytes,omitempty"`
+}
+
+type RenditionResponse struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+	URL    string `json:"url"`
+}
+
+// newVideoResponse describes v; poster, teaser and rendition URLs are built from
+// the URL of the original file.
+func newVideoResponse(v *domain.VideoMetadata, fileURL string) *VideoResponse {
+	if v == nil {
+		return nil
+	}
+	return &VideoResponse{
+		Duration:   v.Duration,
+		Width:      v.Width,
+		Height:     v.Height,
+		VideoCodec: v.VideoCodec,
+		AudioCodec: v.AudioCodec,
+		PosterURL:  fileURL + "/poster",
+		TeaserURL:  fileURL + "/teaser",
+		Transcode:  newTranscodeResponse(v.Transcode, fileURL),
+	}
+}
+
+func newTranscodeResponse(job *domain.TranscodeJob, fileURL string) *TranscodeResponse {
+	if job == nil {
+		return nil
+	}
+	renditions := make([]RenditionResponse, 0, len(job.Renditions))
+	for _, r := range job.Renditions {
+		renditions = append(renditions, RenditionResponse{
+			Width:  r.Width,
+			Height: r.Height,
+			Size:   r.Size,
+			URL:    fileURL + "/renditions/" + domain.RenditionName(r.Height),
+		})
+	}
+	response := &TranscodeResponse{
+		Status:     string(job.Status),
+		Error:      job.Error,
+		UpdatedAt:  job.UpdatedAt,
+		Renditions: renditions,
+
+		Preset:        job.Preset,
+		PresetVersion: job.PresetVersion,
+
+		CPUSeconds:   job.CPUSeconds,
+		ScratchBytes: job.ScratchBytes,
+	}
+	if job.HLS {
+		response.HLSURL = fileURL + "/hls/" + media.MasterPlaylist
+	}
+	return response
+}
+
+type ColorsResponse struct {
+	Dominant string   `json:"dominant"`
+	Average  string   `json:"average"`
+	Palette  []string `json:"palette"`
+}
+
+var uploadResponseExtended = []string{"originalName", "directory", "collection", "source", "checksums", "multihashes", "createdAt", "derived", "relationships"}
+
+func newImageResponse(img *domain.ImageMetadata) *ImageResponse {
+	if img == nil {
+		return nil
+	}
+	return &ImageResponse{
+		Width:       img.Width,
+		Height:      img.Height,
+		Orientation: img.Orientation,
+		Exif:        img.Exif,
+		Colors:      newColorsResponse(img.Colors),
+		Sizes:       img.Renditions,
+		Frames:      img.Frames,
+	}
+}
+
+func newColorsResponse(colors *domain.ImageColors) *ColorsResponse {
+	if colors == nil {
+		return nil
+	}
+	return &ColorsResponse{
+		Dominant: colors.Dominant,
+		Average:  colors.Average,
+		Palette:  colors.Palette,
+	}
+}
+
+// UploadSourceHeader declares where an upload comes from, e.g.
+// mobile-camera or scanner, selecting the processing preset configured for
+// that source.
+const UploadSourceHeader = "X-Upload-Source"
+
+// ChecksumHeader carries the SHA-256 of an uploaded file in hex or base64.
+// The sha-256 member of a Content-Digest header (RFC 9530) is honored as
+// well; both describe the file, not the multipart body around it.
+const ChecksumHeader = "X-Checksum-SHA256"
+
+// uploadParams says who an upload belongs to and where it is filed. The
+// regular endpoint takes them from the token and form; constrained
+// endpoints such as the upload widget fix them.
+type uploadParams struct {
+	ownerID    string
+	orgID      string
+	collection string
+	fileID     string
+
+	// replace is the file whose content the upload replaces.
+	replace string
+
+	// avatar makes the upload the avatar of its owner.
+	avatar bool
+
+	// channel is the provenance channel of the upload; empty for HTTP.
+	channel string
+}
+
+// provenanceHeaders are the request headers kept in the provenance of
+// uploaded files. Credentials are never kept.
+var provenanceHeaders = []string{"Origin", "Referer", "X-Forwarded-For", "Forwarded", UploadSourceHeader, ChecksumHeader, "Content-Digest", "Content-Length"}
+
+func newProvenance(c *gin.Context, channel string) domain.Provenance {
+	p := domain.Provenance{
+		Channel: cmp.Or(channel, domain.ChannelHTTP),
+		Client:  c.Request.UserAgent(),
+		Headers: make(map[string]string),
+	}
+	for _, name := range provenanceHeaders {
+		if value := c.GetHeader(name); value != "" {
+			p.Headers[name] = value
+		}
+	}
+	return p
 }
 
 func (h *UploadHandler) Upload(c *gin.Context) {
+	p := uploadParams{
+		collection: c.PostForm("collection"),
+		fileID:     c.PostForm("fileId"),
+	}
+	authCtx, _ := auth.GetAuthContext(c)
+	if authCtx != nil {
+		p.ownerID = authCtx.UserID
+		if authCtx.OrgID != nil {
+			p.orgID = *authCtx.OrgID
+		}
+	}
+	if p.fileID != "" && (authCtx == nil || !authCtx.HasPermission(customIDPermission)) {
+		problem.AbortWith(c, problem.Problem{
+			Status:     http.StatusForbidden,
+			Title:      "Insufficient permissions",
+			Detail:     "Choosing a file ID requires the " + customIDPermission + " permission",
+			Extensions: map[string]any{"required": []string{customIDPermission}},
+		})
+		return
+	}
+
+	h.upload(c, p)
+}
+
+// PutAvatar uploads a new avatar of the caller, replacing the previous
+// one. It is served through the named alias avatar:<user ID>.
+func (h *UploadHandler) PutAvatar(c *gin.Context) {
+	p := uploadParams{avatar: true}
+	if authCtx, ok := auth.GetAuthContext(c); ok {
+		p.ownerID = authCtx.UserID
+		if authCtx.OrgID != nil {
+			p.orgID = *authCtx.OrgID
+		}
+	}
+
+	h.upload(c, p)
+}
+
+// Replace uploads new content for an existing file, keeping the current
+// content as a version. Owners may replace their own files, administrators
+// any file.
+func (h *UploadHandler) Replace(c *gin.Context) {
+	p := uploadParams{
+		collection: c.PostForm("collection"),
+		replace:    c.Param("fileId"),
+	}
+	authCtx, _ := auth.GetAuthContext(c)
+	if !authCtx.HasPermission("files:admin") {
+		p.ownerID = authCtx.UserID
+	}
+
+	h.upload(c, p)
+}
+
+func (h *UploadHandler) upload(c *gin.Context, p uploadParams) {
 	file, err := c.FormFile("file")
 	if err != nil {
 		h.logger.Warn("Failed to get file from form", "error", err)
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: "No file provided",
-		})
+		problem.Abort(c, http.StatusBadRequest, "No file provided", "")
 		return
 	}
-
-	if file.Size > h.maxSize {
-		h.logger.Warn("File too large", "size", file.Size, "max", h.maxSize)
-		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
-			Error: "File too large",
-		})
+	crop, err := parseCrop(c)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid crop", err.Error())
+		return
+	}
+	digest, err := parseDigest(c)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid checksum", err.Error())
 		return
 	}
+	ttl, err := parseTTL(c.PostForm("ttl"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid TTL", err.Error())
+		return
+	}
+	visibility, err := media.ParseVisibility(c.PostForm("visibility"))
+	if abortMedia(c, err) {
+		return
+	}
+	var licenseExpiresAt, availableFrom, availableUntil *time.Time
+	for _, f := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"licenseExpiresAt", &licenseExpiresAt},
+		{"availableFrom", &availableFrom},
+		{"availableUntil", &availableUntil},
+	} {
+		if value := c.PostForm(f.name); value != "" {
+			if *f.dst, err = parseTime(value); err != nil {
+				problem.Abort(c, http.StatusBadRequest, "Invalid "+f.name, err.Error())
+				return
+			}
+		}
+	}
 
 	src, err := file.Open()
 	if err != nil {
 		h.logger.Error("Failed to open uploaded file", "error", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: "Failed to process file",
-		})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to process file", "")
 		return
 	}
 	defer src.Close()
 
-	contentType := file.Header.Get("Content-Type")
-	if contentType == "" {
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		switch ext {
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		case ".webp":
-			contentType = "image/webp"
+	req := media.UploadRequest{
+		Content:     src,
+		Size:        file.Size,
+		Filename:    file.Filename,
+		ContentType: file.Header.Get("Content-Type"),
+		OwnerID:     p.ownerID,
+		OrgID:       p.orgID,
+		Collection:  p.collection,
+		FileID:      p.fileID,
+		Crop:        crop,
+		Source:      c.GetHeader(UploadSourceHeader),
+		SHA256:      digest,
+		TTL:         ttl,
+		Visibility:  visibility,
+		Provenance:  newProvenance(c, p.channel),
+		Details: media.Details{
+			AltText:     c.PostForm("altText"),
+			Description: c.PostForm("description"),
+			Credit:      c.PostForm("credit"),
+			License:     c.PostForm("license"),
+
+			RightsHolder:     c.PostForm("rightsHolder"),
+			LicenseExpiresAt: licenseExpiresAt,
+
+			AvailableFrom:  availableFrom,
+			AvailableUntil: availableUntil,
+		},
+	}
+	var meta domain.FileMetadata
+	switch {
+	case p.avatar:
+		meta, err = h.uploads.SetAvatar(c.Request.Context(), req)
+	case p.replace != "":
+		meta, err = h.uploads.Replace(c.Request.Context(), p.replace, req)
+	default:
+		meta, err = h.uploads.Upload(c.Request.Context(), req)
+	}
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to save file", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to save file", "")
+		return
+	}
+
+	renderJSON(c, http.StatusOK, h.newUploadResponse(meta), uploadResponseExtended...)
+}
+
+func (h *UploadHandler) newUploadResponse(meta domain.FileMetadata) UploadResponse {
+	url := h.files.URL(meta.ID)
+	previewURL := h.files.PreviewURL(meta)
+	return UploadResponse{
+		FileID:      meta.ID,
+		URL:         url,
+		ContentType: meta.ContentType,
+		Size:        meta.Size,
+		SHA256:      meta.Checksums[checksum.SHA256],
+		Status:      string(meta.Status),
+		Visibility:  string(cmp.Or(meta.Visibility, domain.VisibilityPublic)),
+		Region:      meta.Region,
+		Image:       newImageResponse(meta.Image),
+		Video:       newVideoResponse(meta.Video, url),
+		Audio:       newAudioResponse(meta.Audio, url),
+		Scan:        newScanResponse(meta.Scan),
+		QC:          newQCResponse(meta.QC),
+		Chapters:    newChapterResponses(media.TimeChapters(meta)),
+		PreviewURL:  previewURL,
+		AltText:     meta.AltText,
+		Description: meta.Description,
+		Credit:      meta.Credit,
+		License:     meta.License,
+
+		RightsHolder:     meta.RightsHolder,
+		LicenseExpiresAt: meta.LicenseExpiresAt,
+		AvailableFrom:    meta.AvailableFrom,
+		AvailableUntil:   meta.AvailableUntil,
+		ExpiresAt:        meta.ExpiresAt,
+
+		OriginalName: meta.OriginalName,
+		Directory:    meta.Directory,
+		Collection:   meta.Collection,
+		Source:       meta.Source,
+		Checksums:    meta.Checksums,
+		Multihashes:  checksum.Multihashes(meta.Checksums),
+		CreatedAt:    meta.CreatedAt,
+
+		Derived:       newDerivedAssetResponses(meta, url, previewURL),
+		Relationships: newRelationshipsResponse(meta, url),
+	}
+}
+
+type DetailsRequest struct {
+	AltText      *string `json:"altText"`
+	Description  *string `json:"description"`
+	Credit       *string `json:"credit"`
+	License      *string `json:"license"`
+	RightsHolder *string `json:"rightsHolder"`
+
+	// LicenseExpiresAt and the bounds of the availability window are RFC
+	// 3339 times or dates; "" removes them.
+	LicenseExpiresAt *string `json:"licenseExpiresAt"`
+	AvailableFrom    *string `json:"availableFrom"`
+	AvailableUntil   *string `json:"availableUntil"`
+
+	// Visibility is public, org or private; "" keeps it.
+	Visibility string `json:"visibility"`
+}
+
+// UpdateDetails changes the alt text, description, credit, license,
+// availability or visibility of a file. Fields left out of the body are kept; owners may
+// update their own files, administrators any file.
+func (h *UploadHandler) UpdateDetails(c *gin.Context) {
+	var req DetailsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	update := media.DetailsUpdate{
+		AltText:      req.AltText,
+		Description:  req.Description,
+		Credit:       req.Credit,
+		License:      req.License,
+		RightsHolder: req.RightsHolder,
+		Visibility:   domain.Visibility(req.Visibility),
+	}
+	for _, f := range []struct {
+		name  string
+		value *string
+		dst   **time.Time
+		clear *bool
+	}{
+		{"licenseExpiresAt", req.LicenseExpiresAt, &update.LicenseExpiresAt, &update.ClearLicenseExpiry},
+		{"availableFrom", req.AvailableFrom, &update.AvailableFrom, &update.ClearAvailableFrom},
+		{"availableUntil", req.AvailableUntil, &update.AvailableUntil, &update.ClearAvailableUntil},
+	} {
+		switch {
+		case f.value == nil:
+		case *f.value == "":
+			*f.clear = true
 		default:
-			contentType = "application/octet-stream"
+			t, err := parseTime(*f.value)
+			if err != nil {
+				problem.Abort(c, http.StatusBadRequest, "Invalid "+f.name, err.Error())
+				return
+			}
+			*f.dst = t
 		}
 	}
 
-	if !h.allowedMIME[contentType] {
-		h.logger.Warn("Unsupported MIME type", "contentType", contentType)
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Unsupported file type",
-			Details: "Allowed types: image/jpeg, image/png, image/webp",
-		})
+	authCtx, _ := auth.GetAuthContext(c)
+	ownerID := authCtx.UserID
+	if authCtx.HasPermission("files:admin") {
+		ownerID = ""
+	}
+	meta, err := h.uploads.Describe(c.Request.Context(), c.Param("fileId"), ownerID, update)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to update file details", "fileId", c.Param("fileId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update file", "")
 		return
 	}
 
-	limitedReader := io.LimitReader(src, h.maxSize+1)
+	renderJSON(c, http.StatusOK, h.newUploadResponse(meta), uploadResponseExtended...)
+}
 
-	ctx := c.Request.Context()
-	fileInfo, err := h.storage.Save(ctx, limitedReader, storage.SaveOptions{
-		Directory:    "avatars",
-		ContentType:  contentType,
-		OriginalName: file.Filename,
+type CopyRequest struct {
+	Directory string `json:"directory" binding:"required"`
+	FileID    string `json:"fileId"` // ID of the copy; generated when empty
+}
+
+type MoveRequest struct {
+	Directory string `json:"directory" binding:"required"`
+}
+
+// Copy stores a copy of a file in another storage directory, such as a
+// permanent one for a file uploaded to tmp.
+func (h *UploadHandler) Copy(c *gin.Context) {
+	var req CopyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	authCtx, _ := auth.GetAuthContext(c)
+	ownerID := authCtx.UserID
+	if authCtx.HasPermission("files:admin") {
+		ownerID = ""
+	}
+	meta, err := h.uploads.Copy(c.Request.Context(), c.Param("fileId"), media.CopyRequest{
+		Directory: req.Directory,
+		FileID:    req.FileID,
+		OwnerID:   ownerID,
 	})
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to copy file", "fileId", c.Param("fileId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to copy file", "")
+		return
+	}
+
+	renderJSON(c, http.StatusCreated, h.newUploadResponse(meta), uploadResponseExtended...)
+}
 
+// Move puts a file in another storage directory. Its ID and URLs stay the
+// same.
+func (h *UploadHandler) Move(c *gin.Context) {
+	var req MoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	authCtx, _ := auth.GetAuthContext(c)
+	ownerID := authCtx.UserID
+	if authCtx.HasPermission("files:admin") {
+		ownerID = ""
+	}
+	meta, err := h.uploads.Move(c.Request.Context(), c.Param("fileId"), req.Directory, ownerID)
+	if abortMedia(c, err) {
+		return
+	}
 	if err != nil {
-		h.logger.Error("Failed to save file", "error", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: "Failed to save file",
-		})
+		h.logger.Error("Failed to move file", "fileId", c.Param("fileId"), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to move file", "")
 		return
 	}
 
-	response := UploadResponse{
-		FileID:      fileInfo.ID,
-		URL:         fileInfo.URL,
-		ContentType: fileInfo.ContentType,
-		Size:        fileInfo.Size,
+	renderJSON(c, http.StatusOK, h.newUploadResponse(meta), uploadResponseExtended...)
+}
+
+// ListFiles returns the caller's files matching ?orgId= and ?collection=,
+// newest first and at most ?limit= of them. Admins may list those of any
+// user with ?ownerId=, or all files without it.
+func (h *UploadHandler) ListFiles(c *gin.Context) {
+	filter := metadata.Filter{
+		OwnerID:    c.Query("ownerId"),
+		OrgID:      c.Query("orgId"),
+		Collection: c.Query("collection"),
+	}
+	limit := defaultFileListLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFileListLimit {
+			problem.Abort(c, http.StatusBadRequest, "Invalid limit", "limit must be between 1 and "+strconv.Itoa(maxFileListLimit))
+			return
+		}
+		limit = n
 	}
 
-	h.logger.Info("File uploaded successfully", "fileId", fileInfo.ID, "size", fileInfo.Size)
-	c.JSON(http.StatusOK, response)
+	files, err := h.files.List(c.Request.Context(), filter)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to list files", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to list files", "")
+		return
+	}
+
+	items := make([]UploadResponse, 0, min(len(files), limit))
+	for _, meta := range files[:min(len(files), limit)] {
+		items = append(items, h.newUploadResponse(meta))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// DeleteFile removes a file. Owners may delete their own files, admins any
+// file.
+func (h *UploadHandler) DeleteFile(c *gin.Context) {
+	authCtx, _ := auth.GetAuthContext(c)
+	ownerID := authCtx.UserID
+	if authCtx.HasPermission("files:admin") {
+		ownerID = ""
+	}
+	fileID := c.Param("fileId")
+	err := h.files.Delete(c.Request.Context(), fileID, ownerID)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete file", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete file", "")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// parseTime parses an RFC 3339 time, or a date, which stands for its
+// start in UTC.
+func parseTime(value string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		var dateErr error
+		if t, dateErr = time.Parse(time.DateOnly, value); dateErr != nil {
+			return nil, fmt.Errorf("expected an RFC 3339 time or a date, got %q", value)
+		}
+	}
+	return &t, nil
+}
+
+// GetFileInfo returns the stored metadata of a file without its content.
+func (h *UploadHandler) GetFileInfo(c *gin.Context) {
+	fileID := c.Param("fileId")
+
+	meta, err := h.files.Info(c.Request.Context(), fileID)
+	if err != nil && redirectAlias(c, h.aliases, fileID) {
+		return
+	}
+	if err != nil {
+		abortMedia(c, err)
+		return
+	}
+	resp := h.newUploadResponse(meta)
+	if authCtx, ok := auth.GetAuthContext(c); ok && authCtx.HasPermission("files:admin") {
+		resp.Provenance = newProvenanceResponse(meta.Provenance)
+	}
+	renderJSON(c, http.StatusOK, resp, uploadResponseExtended...)
 }
 
 func (h *UploadHandler) GetFile(c *gin.Context) {
 	fileID := c.Param("fileId")
 	if fileID == "" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: "File ID is required",
-		})
+		problem.Abort(c, http.StatusBadRequest, "File ID is required", "")
 		return
 	}
 
+	var size int
+	if sizeParam := c.Query("size"); sizeParam != "" {
+		// Sizes that are not numbers are no avatar size either; Open
+		// refuses them with the list of sizes there are.
+		var err error
+		if size, err = strconv.Atoi(sizeParam); err != nil || size <= 0 {
+			size = -1
+		}
+	}
+
+	var progressive bool
+	if v := c.Query("progressive"); v != "" {
+		var err error
+		if progressive, err = strconv.ParseBool(v); err != nil {
+			problem.Abort(c, http.StatusBadRequest, "Invalid progressive", "progressive must be true or false")
+			return
+		}
+	}
+
 	ctx := c.Request.Context()
-	file, fileInfo, err := h.storage.Open(ctx, fileID)
+	content, err := h.files.Open(ctx, fileID, size, progressive)
+	if errors.Is(err, media.ErrNotFound) && redirectAlias(c, h.aliases, fileID) {
+		return
+	}
+	if abortMedia(c, err) {
+		return
+	}
 	if err != nil {
-		h.logger.Warn("File not found", "fileId", fileID, "error", err)
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Error: "File not found",
-		})
+		h.logger.Error("Failed to read file", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to read file", "")
 		return
 	}
-	defer file.Close()
+	defer content.Close()
 
-	contentType := fileInfo.ContentType
-	if contentType == "" || contentType == "application/octet-stream" {
-		ext := strings.ToLower(filepath.Ext(fileInfo.Path))
-		switch ext {
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		case ".webp":
-			contentType = "image/webp"
-		default:
-			contentType = "application/octet-stream"
+	if format, ok := h.negotiateFormat(c, content.ContentType); ok {
+		if converted, err := h.files.Convert(ctx, content, format); err == nil {
+			defer converted.Close()
+			serveReader(c, converted.Size, converted.ContentType, converted)
+			return
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to read file", "")
+			return
 		}
 	}
 
+	if content.ContentType == mediatype.SVG {
+		setSVGHeaders(c)
+	}
+	serveReader(c, content.Size, content.ContentType, content)
+}
+
+// svgPolicy keeps an SVG opened directly in the browser from running script
+// or loading anything, as a second line of defence behind the sanitizer.
+const svgPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
+func setSVGHeaders(c *gin.Context) {
+	c.Header("Content-Security-Policy", svgPolicy)
+	c.Header("X-Content-Type-Options", "nosniff")
+}
+
+// serveReader responds with size bytes of r. Unlike DataFromReader, it
+// copies through a pooled buffer rather than a new one per response.
+func serveReader(c *gin.Context, size int64, contentType string, r io.Reader) {
 	c.Header("Content-Type", contentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size))
-	c.DataFromReader(http.StatusOK, fileInfo.Size, contentType, file, nil)
+	c.Header("Content-Length", strconv.FormatInt(size, 10))
+	c.Status(http.StatusOK)
+	if _, err := bufpool.Copy(c.Writer, r); err != nil {
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
+
+// serveContent is http.ServeContent copying through a pooled buffer.
+func serveContent(c *gin.Context, modtime time.Time, content io.ReadSeeker) {
+	http.ServeContent(pooledWriter{c.Writer}, c.Request, "", modtime, content)
+}
+
+// pooledWriter gives a response writer a ReadFrom method, which io.Copy
+// prefers over allocating a buffer.
+type pooledWriter struct {
+	http.ResponseWriter
+}
+
+func (w pooledWriter) ReadFrom(r io.Reader) (int64, error) {
+	return bufpool.Copy(w.ResponseWriter, r)
+}
+
+// GetPreview serves a PNG of the first page of a PDF document.
+func (h *UploadHandler) GetPreview(c *gin.Context) {
+	h.serveDerivative(c, h.files.Preview, "Failed to render preview")
+}
+
+// GetNormalized serves the loudness-normalized copy of an audio file.
+func (h *UploadHandler) GetNormalized(c *gin.Context) {
+	h.serveDerivative(c, h.files.Normalized, "Failed to normalize audio")
+}
+
+// GetWaveform serves the waveform peaks of an audio file as JSON.
+func (h *UploadHandler) GetWaveform(c *gin.Context) {
+	h.serveDerivative(c, h.files.Waveform, "Failed to compute waveform")
+}
+
+// GetText serves the text recognized in a scanned image.
+func (h *UploadHandler) GetText(c *gin.Context) {
+	h.serveDerivative(c, h.files.Text, "Failed to recognize text")
+}
+
+// GetPoster serves a JPEG frame of a video.
+func (h *UploadHandler) GetPoster(c *gin.Context) {
+	h.serveDerivative(c, h.files.Poster, "Failed to extract poster")
+}
+
+// serveDerivative serves what open returns for the requested file, which
+// is generated on first request. failure is the title of the response when
+// that fails.
+func (h *UploadHandler) serveDerivative(c *gin.Context, open func(context.Context, string) (*media.Content, error), failure string) {
+	fileID := c.Param("fileId")
+
+	content, err := open(c.Request.Context(), fileID)
+	if errors.Is(err, media.ErrNotFound) && redirectAlias(c, h.aliases, fileID) {
+		return
+	}
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error(failure, "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, failure, "")
+		return
+	}
+	defer content.Close()
+	serveReader(c, content.Size, content.ContentType, content)
+}
+
+// GetTeaser serves the looping animated preview of a video, as GIF or WebP
+// by ?format=.
+func (h *UploadHandler) GetTeaser(c *gin.Context) {
+	format := c.Query("format")
+	h.serveDerivative(c, func(ctx context.Context, id string) (*media.Content, error) {
+		return h.files.Teaser(ctx, id, format)
+	}, "Failed to make teaser")
+}
+
+// GetRendition serves a transcoded MP4 rendition of a video, named by its
+// height as in "720p". Range requests are supported so players can seek.
+func (h *UploadHandler) GetRendition(c *gin.Context) {
+	fileID := c.Param("fileId")
+
+	rendition, err := h.files.Rendition(c.Request.Context(), fileID, c.Param("rendition"))
+	if errors.Is(err, media.ErrNotFound) && redirectAlias(c, h.aliases, fileID) {
+		return
+	}
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read video rendition", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to read file", "")
+		return
+	}
+	defer rendition.Close()
+
+	c.Header("Content-Type", rendition.ContentType)
+	serveContent(c, rendition.ModTime, rendition)
+}
+
+// abortMedia answers a request the media services refused, with the status
+// matching the kind of refusal, or one whose processing the governor did
+// not admit. It returns false for other errors, including nil.
+func abortMedia(c *gin.Context, err error) bool {
+	var refused *media.Error
+	if !errors.As(err, &refused) {
+		return abortAdmission(c, err)
+	}
+
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, media.ErrNotFound), errors.Is(err, media.ErrUnavailable):
+		status = http.StatusNotFound
+	case errors.Is(err, media.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, media.ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, media.ErrForbidden), errors.Is(err, media.ErrQuotaExceeded):
+		status = http.StatusForbidden
+	case errors.Is(err, media.ErrUnsupported):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, media.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, media.ErrUnprocessable):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, media.ErrRestricted):
+		status = http.StatusUnavailableForLegalReasons
+	}
+	problem.Abort(c, status, refused.Title, refused.Detail)
+	return true
+}
+
+// ownedFile loads a file the caller may see and owns. Administrators may
+// act on any file they can see. It writes the error response and returns
+// false otherwise.
+func ownedFile(c *gin.Context, files *media.FileService, fileID string) (domain.FileMetadata, bool) {
+	meta, err := files.Info(c.Request.Context(), fileID)
+	if abortMedia(c, err) {
+		return domain.FileMetadata{}, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusNotFound, "File not found", "")
+		return domain.FileMetadata{}, false
+	}
+	authCtx, _ := auth.GetAuthContext(c)
+	if authCtx.UserID != meta.OwnerID && !authCtx.HasPermission("files:admin") {
+		problem.Abort(c, http.StatusForbidden, "Insufficient permissions", "Only the owner of the file may manage its addresses")
+		return domain.FileMetadata{}, false
+	}
+	return meta, true
+}
+
+// abortAdmission answers a request whose processing job the governor did
+// not admit: 429 with Retry-After when the node is busy, 422 when the job
+// could never run. Requests over the rate limit of a plan are answered
+// with 429 too. It returns false for other errors.
+func abortAdmission(c *gin.Context, err error) bool {
+	var (
+		saturated *governor.SaturatedError
+		limited   *plans.RateLimitedError
+	)
+	switch {
+	case errors.As(err, &saturated):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(saturated.RetryAfter.Seconds()))))
+		problem.Abort(c, http.StatusTooManyRequests, "Server busy", "Processing capacity is exhausted, retry later")
+	case errors.As(err, &limited):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		problem.Abort(c, http.StatusTooManyRequests, "Rate limit exceeded", "The "+limited.Plan+" plan of the organization allows no more requests for now")
+	case errors.Is(err, governor.ErrTooLarge):
+		problem.Abort(c, http.StatusUnprocessableEntity, "Too large to process", err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		problem.Abort(c, http.StatusServiceUnavailable, "Request cancelled", "")
+	default:
+		return false
+	}
+	return true
+}
+
+// negotiateFormat picks a converted format to serve an image in: an explicit
+// format= query parameter wins, otherwise the format Accept lists with the
+// highest quality value, unless it prefers the original. Converted formats
+// must be listed by name, as wildcards already accept the original; they
+// win ties with it, being smaller.
+func (h *UploadHandler) negotiateFormat(c *gin.Context, contentType string) (imaging.Format, bool) {
+	if !h.files.Converts(contentType) {
+		return "", false
+	}
+	c.Header("Vary", "Accept")
+
+	if f := c.Query("format"); f != "" {
+		format, ok := imaging.ParseFormat(f)
+		return format, ok && h.files.ConvertsTo(format)
+	}
+
+	ranges := parseAccept(c.GetHeader("Accept"))
+	best, bestQ := imaging.Format(""), 0.0
+	if q, ok := quality(ranges, contentType, false); ok {
+		bestQ = q
+	} else if len(ranges) == 0 {
+		bestQ = 1
+	}
+	for _, format := range []imaging.Format{imaging.FormatAVIF, imaging.FormatWebP} {
+		if !h.files.ConvertsTo(format) {
+			continue
+		}
+		if q, ok := quality(ranges, format.ContentType(), true); ok && q > 0 && q >= bestQ && (best == "" || q > bestQ) {
+			best, bestQ = format, q
+		}
+	}
+	return best, best != ""
+}
+
+// parseCrop reads the optional cropX, cropY, cropWidth and cropHeight form
+// fields. They must be given all together.
+func parseCrop(c *gin.Context) (*image.Rectangle, error) {
+	fields := []string{"cropX", "cropY", "cropWidth", "cropHeight"}
+	values := make([]int, len(fields))
+	given := 0
+	for i, field := range fields {
+		v := c.PostForm(field)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer", field)
+		}
+		values[i] = n
+		given++
+	}
+	if given == 0 {
+		return nil, nil
+	}
+	if given != len(fields) {
+		return nil, fmt.Errorf("cropX, cropY, cropWidth and cropHeight must be given together")
+	}
+	r := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+	if r.Empty() {
+		return nil, fmt.Errorf("crop must not be empty")
+	}
+	return &r, nil
+}
+
+// parseTTL reads a TTL given as a duration such as "24h" or in seconds;
+// "" is no TTL.
+func parseTTL(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds > 0 && seconds <= math.MaxInt64/int64(time.Second) {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("ttl must be a positive duration such as 24h or a number of seconds")
+	}
+	return ttl, nil
+}
+
+// parseDigest returns the SHA-256 the client declared for the uploaded
+// file as hex, or "" if it declared none.
+func parseDigest(c *gin.Context) (string, error) {
+	if v := c.GetHeader(ChecksumHeader); v != "" {
+		return decodeSHA256(v)
+	}
+	for _, member := range strings.Split(c.GetHeader("Content-Digest"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || strings.TrimSpace(name) != "sha-256" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return "", fmt.Errorf("the sha-256 digest must be a byte sequence, :base64:")
+		}
+		return decodeSHA256(value[1 : len(value)-1])
+	}
+	// Other algorithms in Content-Digest are not checked.
+	return "", nil
+}
+
+func decodeSHA256(v string) (string, error) {
+	if len(v) == hex.EncodedLen(sha256.Size) {
+		if _, err := hex.DecodeString(v); err == nil {
+			return strings.ToLower(v), nil
+		}
+	}
+	if raw, err := base64.StdEncoding.DecodeString(v); err == nil && len(raw) == sha256.Size {
+		return hex.EncodeToString(raw), nil
+	}
+	return "", fmt.Errorf("expected a SHA-256 digest in hex or base64")
 }
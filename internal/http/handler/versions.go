@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bytes"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+)
+
+type VersionHandler struct {
+	files   *media.FileService
+	uploads *media.UploadService
+	logger  *slog.Logger
+}
+
+func NewVersionHandler(files *media.FileService, uploads *media.UploadService, logger *slog.Logger) *VersionHandler {
+	return &VersionHandler{
+		files:   files,
+		uploads: uploads,
+		logger:  logger,
+	}
+}
+
+type VersionResponse struct {
+	Version      int               `json:"version"`
+	Current      bool              `json:"current"`
+	URL          string            `json:"url"`
+	OriginalName string            `json:"originalName"`
+	ContentType  string            `json:"contentType"`
+	Size         int64             `json:"size"`
+	Checksums    map[string]string `json:"checksums,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+}
+
+// List returns the kept versions of a file, oldest first, followed by
+// the current one.
+func (h *VersionHandler) List(c *gin.Context) {
+	meta, err := h.files.Info(c.Request.Context(), c.Param("fileId"))
+	if err != nil {
+		abortMedia(c, err)
+		return
+	}
+
+	url := h.files.URL(meta.ID)
+	items := make([]VersionResponse, 0, len(meta.Versions)+1)
+	for _, v := range meta.Versions {
+		items = append(items, VersionResponse{
+			Version:      v.Number,
+			URL:          url + "/versions/" + strconv.Itoa(v.Number),
+			OriginalName: v.OriginalName,
+			ContentType:  v.ContentType,
+			Size:         v.Size,
+			Checksums:    v.Checksums,
+			CreatedAt:    v.CreatedAt,
+		})
+	}
+	items = append(items, h.currentVersion(meta))
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *VersionHandler) currentVersion(meta domain.FileMetadata) VersionResponse {
+	return VersionResponse{
+		Version:      meta.CurrentVersion(),
+		Current:      true,
+		URL:          h.files.URL(meta.ID),
+		OriginalName: meta.OriginalName,
+		ContentType:  meta.ContentType,
+		Size:         meta.Size,
+		Checksums:    meta.Checksums,
+		CreatedAt:    meta.CreatedAt,
+	}
+}
+
+// Get serves the content of a version of a file.
+func (h *VersionHandler) Get(c *gin.Context) {
+	fileID := c.Param("fileId")
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		problem.Abort(c, http.StatusBadRequest, "Invalid version", "The version must be a positive integer")
+		return
+	}
+
+	content, err := h.files.OpenVersion(c.Request.Context(), fileID, number)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read file version", "fileId", fileID, "version", number, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to read file", "")
+		return
+	}
+	defer content.Close()
+
+	if content.ContentType == mediatype.SVG {
+		setSVGHeaders(c)
+	}
+	serveReader(c, content.Size, content.ContentType, content)
+}
+
+// Restore makes a version of a file its current content again and returns
+// the new current version. Owners may restore their own files,
+// administrators any file.
+func (h *VersionHandler) Restore(c *gin.Context) {
+	fileID := c.Param("fileId")
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		problem.Abort(c, http.StatusBadRequest, "Invalid version", "The version must be a positive integer")
+		return
+	}
+
+	authCtx, _ := auth.GetAuthContext(c)
+	ownerID := authCtx.UserID
+	if authCtx.HasPermission("files:admin") {
+		ownerID = ""
+	}
+	meta, err := h.uploads.RestoreVersion(c.Request.Context(), fileID, number, ownerID)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to restore file version", "fileId", fileID, "version", number, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to restore version", "")
+		return
+	}
+
+	h.logger.Info("File version restored", "fileId", fileID, "version", number, "current", meta.CurrentVersion())
+	c.JSON(http.StatusOK, h.currentVersion(meta))
+}
+
+type DiffResponse struct {
+	FileID       string  `json:"fileId"`
+	From         int     `json:"from"`
+	To           int     `json:"to"`
+	Score        float64 `json:"score"`
+	ChangedRatio float64 `json:"changedRatio"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+}
+
+// Diff compares two versions of an image. It returns a PNG highlighting the
+// changed pixels with the scores in X-Diff-* headers, or only the scores as
+// JSON when called with format=json.
+func (h *VersionHandler) Diff(c *gin.Context) {
+	ctx := c.Request.Context()
+	fileID := c.Param("fileId")
+
+	// The versions compared are delivered like downloads of the file.
+	meta, err := h.files.Info(ctx, fileID)
+	if err == nil {
+		err = h.files.CheckAccess(ctx, meta)
+	}
+	if err != nil {
+		abortMedia(c, err)
+		return
+	}
+
+	from, errA := strconv.Atoi(c.Param("version"))
+	to, errB := strconv.Atoi(c.Param("other"))
+	if errA != nil || errB != nil || !kept(meta, from) || !kept(meta, to) {
+		problem.Abort(c, http.StatusNotFound, "Version not found", "")
+		return
+	}
+
+	result, err := h.files.DiffVersions(ctx, meta, from, to, h.uploads.ImageLimits())
+	if err != nil {
+		if !abortMedia(c, err) {
+			h.logger.Error("Failed to diff versions", "fileId", fileID, "from", from, "to", to, "error", err)
+			problem.Abort(c, http.StatusInternalServerError, "Failed to render diff", "")
+		}
+		return
+	}
+	bounds := result.Image.Bounds()
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, DiffResponse{
+			FileID:       fileID,
+			From:         from,
+			To:           to,
+			Score:        result.Score,
+			ChangedRatio: result.ChangedRatio,
+			Width:        bounds.Dx(),
+			Height:       bounds.Dy(),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, result.Image); err != nil {
+		h.logger.Error("Failed to encode diff image", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to render diff", "")
+		return
+	}
+
+	c.Header("X-Diff-Score", strconv.FormatFloat(result.Score, 'f', 6, 64))
+	c.Header("X-Diff-Changed-Ratio", strconv.FormatFloat(result.ChangedRatio, 'f', 6, 64))
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// kept reports whether a file has the given version, current or kept.
+func kept(meta domain.FileMetadata, number int) bool {
+	_, ok := meta.Version(number)
+	return ok || number == meta.CurrentVersion()
+}
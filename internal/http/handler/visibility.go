@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+)
+
+// Authorize lets requests for a file carrying a signature issued by Sign
+// through to it, whatever its visibility. Requests with an invalid or
+// expired signature are refused.
+func (h *UploadHandler) Authorize(c *gin.Context) {
+	ctx, err := h.files.Authorize(c.Request.Context(), c.Param("fileId"), c.Request.URL.Query())
+	if abortMedia(c, err) {
+		return
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// Sign issues a URL of a file that grants access to it and its
+// derivatives until it expires, for sharing files that are not public.
+func (h *UploadHandler) Sign(c *gin.Context) {
+	var req SignedURLRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+
+	fileID := c.Param("fileId")
+	fileURL, expiresAt, err := h.files.SignedURL(c.Request.Context(), fileID, expiresAt)
+	if abortMedia(c, err) {
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to sign file URL", "fileId", fileID, "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to sign URL", "")
+		return
+	}
+	c.JSON(http.StatusOK, SignedURLResponse{URL: fileURL, ExpiresAt: &expiresAt})
+}
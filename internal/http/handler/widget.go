@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+	"github.com/ondrasimku/media-service-go/internal/widget"
+)
+
+const widgetTokenHeader = "X-Widget-Token"
+
+// WidgetHandler serves the upload endpoint for widgets embedded on
+// third-party sites. Browsers there hold no JWT; they present a widget
+// token bound to their origin instead.
+type WidgetHandler struct {
+	issuer      *widget.Issuer
+	limiter     *widget.Limiter
+	uploads     *UploadHandler
+	maxTokenTTL time.Duration
+	logger      *slog.Logger
+}
+
+func NewWidgetHandler(issuer *widget.Issuer, limiter *widget.Limiter, uploads *UploadHandler, maxTokenTTL time.Duration, logger *slog.Logger) *WidgetHandler {
+	return &WidgetHandler{
+		issuer:      issuer,
+		limiter:     limiter,
+		uploads:     uploads,
+		maxTokenTTL: maxTokenTTL,
+		logger:      logger,
+	}
+}
+
+type WidgetTokenRequest struct {
+	Origin     string     `json:"origin" binding:"required"`
+	Collection string     `json:"collection"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+}
+
+type WidgetTokenResponse struct {
+	Token      string    `json:"token"`
+	Origin     string    `json:"origin"`
+	Collection string    `json:"collection,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// CreateToken issues a widget token for the caller's account. Uploads made
+// with it belong to the caller and go to the requested collection.
+func (h *WidgetHandler) CreateToken(c *gin.Context) {
+	var req WidgetTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	origin, err := widget.NormalizeOrigin(req.Origin)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid widget token", err.Error())
+		return
+	}
+	if req.Collection != "" && !media.ValidCollection(req.Collection) {
+		problem.Abort(c, http.StatusBadRequest, "Invalid collection", "Collection names are 1-64 lowercase letters, digits, '.', '_' and '-'")
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(h.maxTokenTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) || req.ExpiresAt.After(expiresAt) {
+			problem.Abort(c, http.StatusBadRequest, "Invalid widget token", "expiresAt must be in the future and at most "+h.maxTokenTTL.String()+" away")
+			return
+		}
+		expiresAt = req.ExpiresAt.UTC()
+	}
+
+	authCtx, _ := auth.GetAuthContext(c)
+	t := widget.Token{
+		OwnerID:    authCtx.UserID,
+		Origin:     origin,
+		Collection: req.Collection,
+		ExpiresAt:  expiresAt,
+	}
+	if authCtx.OrgID != nil {
+		t.OrgID = *authCtx.OrgID
+	}
+
+	token, err := h.issuer.Sign(t)
+	if err != nil {
+		h.logger.Error("Failed to sign widget token", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create widget token", "")
+		return
+	}
+	c.JSON(http.StatusCreated, WidgetTokenResponse{
+		Token:      token,
+		Origin:     t.Origin,
+		Collection: t.Collection,
+		ExpiresAt:  t.ExpiresAt,
+	})
+}
+
+// Preflight answers CORS preflight requests for the upload endpoint. The
+// token is not sent with preflights, so any origin is let through here and
+// checked against the token on the actual request.
+func (h *WidgetHandler) Preflight(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		problem.Abort(c, http.StatusBadRequest, "Missing Origin header", "")
+		return
+	}
+	c.Header("Vary", "Origin")
+	c.Header("Access-Control-Allow-Origin", origin)
+	c.Header("Access-Control-Allow-Methods", http.MethodPost)
+	c.Header("Access-Control-Allow-Headers", widgetTokenHeader+", "+UploadSourceHeader+", "+ChecksumHeader+", Content-Digest")
+	c.Header("Access-Control-Max-Age", "600")
+	c.Status(http.StatusNoContent)
+}
+
+// Upload accepts a file from a widget. The request must come from the
+// origin the token was issued for, is rate limited per origin and is filed
+// into the token's collection; callers cannot pick a file ID.
+func (h *WidgetHandler) Upload(c *gin.Context) {
+	c.Header("Vary", "Origin")
+
+	raw := c.GetHeader(widgetTokenHeader)
+	if raw == "" {
+		raw = c.PostForm("token")
+	}
+	if raw == "" {
+		problem.Abort(c, http.StatusUnauthorized, "Missing widget token", "")
+		return
+	}
+	t, err := h.issuer.Verify(raw, time.Now())
+	if errors.Is(err, widget.ErrExpiredToken) {
+		problem.Abort(c, http.StatusUnauthorized, "Widget token expired", "")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusUnauthorized, "Invalid widget token", "")
+		return
+	}
+
+	if c.GetHeader("Origin") != t.Origin {
+		problem.Abort(c, http.StatusForbidden, "Origin not allowed", "This token is bound to "+t.Origin)
+		return
+	}
+	c.Header("Access-Control-Allow-Origin", t.Origin)
+
+	if ok, wait := h.limiter.Allow(t.Origin, time.Now()); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		problem.Abort(c, http.StatusTooManyRequests, "Too many uploads", "Upload rate limit for "+t.Origin+" exceeded")
+		return
+	}
+
+	h.uploads.upload(c, uploadParams{
+		ownerID:    t.OwnerID,
+		orgID:      t.OrgID,
+		collection: t.Collection,
+		channel:    domain.ChannelWidget,
+	})
+}
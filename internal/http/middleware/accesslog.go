@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AccessLog logs every request once it has been served, under the request
+// ID that is echoed in the X-Request-ID header. Server errors are logged at
+// error level, client errors at warning level.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := problem.RequestID(c)
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("requestId", requestID),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("clientIp", c.ClientIP()),
+		}
+		if authCtx, ok := auth.GetAuthContext(c); ok {
+			attrs = append(attrs, slog.String("userId", authCtx.UserID))
+		}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			attrs = append(attrs, slog.String("traceId", span.TraceID().String()))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.Request.Context(), level, "Request served", attrs...)
+	}
+}
+
+// Recover turns panics in handlers into 500 responses, logging them with
+// the request ID.
+func Recover(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logger.Error("Handler panicked", "requestId", problem.RequestID(c), "path", c.Request.URL.Path,
+			"error", err, "stack", string(debug.Stack()))
+		problem.Abort(c, http.StatusInternalServerError, "Internal server error", "")
+	})
+}
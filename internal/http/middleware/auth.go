@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+)
+
+// Auth verifies the bearer token of every request, refusing requests
+// without a valid one.
+func Auth(jwksClient *auth.JWKSClient, config auth.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := auth.BearerToken(c.GetHeader("Authorization"))
+		if err != nil {
+			problem.Abort(c, http.StatusUnauthorized, "Missing or invalid authorization header", "")
+			return
+		}
+
+		authContext, err := auth.VerifyToken(c.Request.Context(), token, jwksClient, config)
+		if errors.Is(err, auth.ErrIntrospectionUnavailable) {
+			problem.Abort(c, http.StatusServiceUnavailable, "Token introspection unavailable", "")
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusUnauthorized, "Invalid token", err.Error())
+			return
+		}
+
+		auth.SetAuthContext(c, authContext)
+		c.Next()
+	}
+}
+
+// OptionalAuth verifies the bearer token of requests that carry one, like
+// Auth, and lets requests without one through anonymously.
+func OptionalAuth(jwksClient *auth.JWKSClient, config auth.Config) gin.HandlerFunc {
+	required := Auth(jwksClient, config)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		required(c)
+	}
+}
+
+// RequirePermissions refuses tokens lacking any of requiredPermissions,
+// including those granted by the OAuth scopes of the token.
+func RequirePermissions(requiredPermissions []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authCtx, ok := auth.GetAuthContext(c)
+		if !ok {
+			problem.Abort(c, http.StatusUnauthorized, "Not authenticated", "")
+			return
+		}
+
+		if len(authCtx.MissingPermissions(requiredPermissions)) > 0 {
+			problem.AbortWith(c, problem.Problem{
+				Status: http.StatusForbidden,
+				Title:  "Insufficient permissions",
+				Extensions: map[string]any{
+					"required": requiredPermissions,
+					"has":      authCtx.Permissions,
+				},
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScopes refuses tokens lacking any of requiredScopes with 403 and
+// an RFC 6750 insufficient_scope challenge.
+func RequireScopes(requiredScopes []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authCtx, ok := auth.GetAuthContext(c)
+		if !ok {
+			problem.Abort(c, http.StatusUnauthorized, "Not authenticated", "")
+			return
+		}
+
+		if len(authCtx.MissingScopes(requiredScopes)) > 0 {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(requiredScopes, " ")))
+			problem.AbortWith(c, problem.Problem{
+				Status: http.StatusForbidden,
+				Title:  "Insufficient scope",
+				Extensions: map[string]any{
+					"required": requiredScopes,
+					"has":      authCtx.Scopes,
+				},
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
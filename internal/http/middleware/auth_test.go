@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+)
+
+func TestRequireScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		token     *auth.AuthContext
+		required  []string
+		want      int
+		challenge string
+	}{
+		{"anonymous", nil, []string{"media.admin"}, http.StatusUnauthorized, ""},
+		{"no scopes required", &auth.AuthContext{UserID: "u"}, nil, http.StatusOK, ""},
+		{"granted", &auth.AuthContext{UserID: "u", Scopes: []string{"openid", "media.admin"}}, []string{"media.admin"}, http.StatusOK, ""},
+		{"granted all", &auth.AuthContext{UserID: "u", Scopes: []string{"media.read", "media.admin"}}, []string{"media.admin", "media.read"}, http.StatusOK, ""},
+		{"missing one", &auth.AuthContext{UserID: "u", Scopes: []string{"media.read"}}, []string{"media.admin", "media.read"}, http.StatusForbidden, `Bearer error="insufficient_scope", scope="media.admin media.read"`},
+		{"permission is no scope", &auth.AuthContext{UserID: "u", Permissions: []string{"media.admin"}}, []string{"media.admin"}, http.StatusForbidden, `Bearer error="insufficient_scope", scope="media.admin"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", func(c *gin.Context) {
+				if tt.token != nil {
+					auth.SetAuthContext(c, tt.token)
+				}
+			}, RequireScopes(tt.required), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Fatalf("WWW-Authenticate = %q, want %q", got, tt.challenge)
+			}
+		})
+	}
+}
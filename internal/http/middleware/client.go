@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/geoip"
+)
+
+// Client records the client of a request in its context for the rules
+// that depend on where it comes from. countryHeader names a header a CDN
+// sets to the client's country, e.g. CF-IPCountry; it is ignored when
+// empty.
+func Client(countryHeader string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var client geoip.Client
+		client.Addr, _ = netip.ParseAddr(c.ClientIP())
+		if countryHeader != "" {
+			if country := strings.ToUpper(c.GetHeader(countryHeader)); geoip.ValidCountry(country) {
+				client.Country = country
+			}
+		}
+		c.Request = c.Request.WithContext(geoip.WithClient(c.Request.Context(), client))
+		c.Next()
+	}
+}
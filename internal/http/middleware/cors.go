@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSPolicy is what browsers on other origins may do with the API.
+type CORSPolicy struct {
+	// AllowedOrigins are the origins served, "*" for any; an entry may
+	// start with a wildcard subdomain, e.g. https://*.example.com.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string // "*" allows whatever headers a preflight asks for
+	ExposedHeaders   []string
+	MaxAge           time.Duration // How long browsers may cache a preflight
+	AllowCredentials bool
+}
+
+// CORS answers preflight requests and marks responses to the allowed
+// origins as readable by them. Requests from other origins pass through
+// untouched, so routes with their own preflight, such as the widget ones,
+// still answer those. It does nothing without allowed origins.
+func CORS(policy CORSPolicy) gin.HandlerFunc {
+	methods := strings.Join(policy.AllowedMethods, ", ")
+	headers := strings.Join(policy.AllowedHeaders, ", ")
+	exposed := strings.Join(policy.ExposedHeaders, ", ")
+	anyHeader := slices.Contains(policy.AllowedHeaders, "*")
+	maxAge := strconv.Itoa(int(policy.MaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if len(policy.AllowedOrigins) == 0 || origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !policy.allows(origin) {
+			c.Next()
+			return
+		}
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !preflight {
+			c.Header("Access-Control-Allow-Origin", policy.allowOrigin(origin))
+			if policy.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+			if exposed != "" {
+				c.Header("Access-Control-Expose-Headers", exposed)
+			}
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		method := c.GetHeader("Access-Control-Request-Method")
+		if !slices.Contains(policy.AllowedMethods, method) {
+			// Without the allow headers the browser refuses the request.
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", policy.allowOrigin(origin))
+		if policy.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		c.Header("Access-Control-Allow-Methods", methods)
+		if requested := c.GetHeader("Access-Control-Request-Headers"); anyHeader && requested != "" {
+			c.Header("Access-Control-Allow-Headers", requested)
+		} else if headers != "" {
+			c.Header("Access-Control-Allow-Headers", headers)
+		}
+		if policy.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func (p CORSPolicy) allows(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin is the Access-Control-Allow-Origin of a response to origin:
+// the origin itself, unless any is allowed without credentials.
+func (p CORSPolicy) allowOrigin(origin string) string {
+	if slices.Contains(p.AllowedOrigins, "*") && !p.AllowCredentials {
+		return "*"
+	}
+	return origin
+}
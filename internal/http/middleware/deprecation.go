@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks responses from legacy (unversioned) routes with the
+// Deprecation and Sunset headers and links to the versioned successor.
+// A zero sunset omits the Sunset header.
+func Deprecated(successorPrefix string, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", successorPrefix, c.Request.URL.Path))
+		c.Next()
+	}
+}
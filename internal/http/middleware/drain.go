@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/drain"
+)
+
+// TrackInFlight tracks requests with tracker, counting the bytes of their
+// bodies and responses, so that a shutdown can report on the ones it cut
+// off. Requests whose client went away count as aborted.
+func TrackInFlight(tracker *drain.Tracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := tracker.Begin(drain.KindRequest, c.Param("fileId"))
+		defer func() { op.End(c.Request.Context().Err() != nil) }()
+
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = countingBody{ReadCloser: c.Request.Body, op: op}
+		}
+		c.Writer = countingWriter{ResponseWriter: c.Writer, op: op}
+		c.Next()
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	op *drain.Op
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.op.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	gin.ResponseWriter
+	op *drain.Op
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.op.Add(int64(n))
+	return n, err
+}
+
+func (w countingWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.op.Add(int64(n))
+	return n, err
+}
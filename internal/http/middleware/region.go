@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/region"
+)
+
+var regionForwarded = metrics.NewCounter("media_region_forwarded_total",
+	"Downloads of files homed in another region sent to the deployment of that region, by region and mode.", "region", "mode")
+
+// HomeRegion sends requests for the file named by the fileId parameter to
+// the deployment of its home region, as told by home, when that is another
+// region with a peer: the client is redirected there, or the request is
+// proxied, as regions is configured. Other files are served here, as are
+// requests a peer already proxied.
+func HomeRegion(regions *region.Router, home func(ctx context.Context, fileID string) string, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if regions == nil || c.GetHeader(region.ForwardedHeader) != "" {
+			c.Next()
+			return
+		}
+		fileID := c.Param("fileId")
+		homeRegion := home(c.Request.Context(), fileID)
+		peer, ok := regions.Peer(homeRegion)
+		if !ok {
+			c.Next()
+			return
+		}
+		regionForwarded.Inc(homeRegion, regions.Mode())
+
+		if regions.Mode() == region.ModeRedirect {
+			target := peer.JoinPath(c.Request.URL.Path)
+			target.RawQuery = c.Request.URL.RawQuery
+			c.Redirect(http.StatusTemporaryRedirect, target.String())
+			c.Abort()
+			return
+		}
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(peer)
+				pr.SetXForwarded()
+				pr.Out.Header.Set(region.ForwardedHeader, regions.Local())
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logger.Warn("Failed to proxy request to home region", "fileId", fileID, "region", homeRegion, "error", err)
+				problem.Abort(c, http.StatusBadGateway, "Home region unavailable", fmt.Sprintf("The file is stored in region %s, which could not be reached", homeRegion))
+			},
+		}
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/tuning"
+)
+
+var uploadGCPauses = metrics.NewHistogram("media_upload_gc_pause_seconds",
+	"Time uploads were stalled by GC pauses while in flight, by upload size.",
+	[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5}, "size")
+
+// GCPauses records how long the program was stopped for GC while each
+// request of the wrapped routes was in flight, labelled by the size of its
+// body, so the effect of GC tuning on large uploads can be watched.
+func GCPauses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := tuning.PauseTime()
+		c.Next()
+		uploadGCPauses.Observe((tuning.PauseTime() - start).Seconds(), sizeClass(c.Request.ContentLength))
+	}
+}
+
+func sizeClass(n int64) string {
+	switch {
+	case n < 0:
+		return "unknown"
+	case n < 1<<20:
+		return "small"
+	case n < 64<<20:
+		return "medium"
+	default:
+		return "large"
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+)
+
+// CountErrors records responses with a 5xx status as stats.Errors.
+func CountErrors(rec *stats.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			rec.Record(stats.Errors, 0)
+		}
+	}
+}
+
+// CountResponses records successful responses of the wrapped routes as
+// events of kind, with the response body size as their bytes.
+func CountResponses(rec *stats.Recorder, kind stats.Kind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if status := c.Writer.Status(); status >= 200 && status < 300 {
+			rec.Record(kind, int64(max(c.Writer.Size(), 0)))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package openapi describes the HTTP API as an OpenAPI 3 document. The
+// document is built from the routes registered on the router and schemas
+// are derived from the request and response types by reflection, so it
+// follows the handlers as they change.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by lower-case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Spec documents an operation. Body and Response are values of the types
+// the handler binds and renders; their schemas are derived from them.
+type Spec struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+
+	// Auth marks operations that require a bearer token.
+	Auth bool
+
+	Query []Parameter
+
+	// Body is the JSON request body; Form a multipart/form-data one.
+	Body any
+	Form *Schema
+
+	// Status is the status of a successful response, 200 if zero.
+	Status int
+
+	// Response is the JSON body of a successful response, wrapped in
+	// {"items": [...]} when List is set. Content is the type of a
+	// non-JSON body, such as a file download.
+	Response any
+	List     bool
+	Content  string
+}
+
+// ProblemSchema describes the RFC 7807 problem details every error is
+// rendered as.
+var ProblemSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"type":     {Type: "string", Format: "uri"},
+		"title":    {Type: "string"},
+		"status":   {Type: "integer"},
+		"detail":   {Type: "string"},
+		"instance": {Type: "string"},
+	},
+	Required:             []string{"type", "title", "status"},
+	AdditionalProperties: &Schema{},
+}
+
+var pathParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// Build describes routes. Routes without a spec are listed with their path
+// parameters and an untyped response.
+func Build(info Info, routes gin.RoutesInfo, specs map[string]Spec) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: map[string]*Schema{"Problem": ProblemSchema},
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+	schemas := newSchemas(doc.Components.Schemas)
+	operationIDs := make(map[string]int)
+
+	for _, route := range routes {
+		spec := specs[route.Method+" "+route.Path]
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+
+		op := &Operation{
+			OperationID: operationID(route, operationIDs),
+			Summary:     spec.Summary,
+			Description: spec.Description,
+			Tags:        spec.Tags,
+			Deprecated:  spec.Deprecated,
+			Parameters:  spec.Query,
+			Responses:   make(map[string]Response),
+		}
+		for _, m := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			op.Parameters = append([]Parameter{{Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"}}}, op.Parameters...)
+		}
+		if spec.Auth {
+			op.Security = []map[string][]string{{"bearer": {}}}
+		}
+
+		switch {
+		case spec.Body != nil:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+				"application/json": {Schema: schemas.of(spec.Body)},
+			}}
+		case spec.Form != nil:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+				"multipart/form-data": {Schema: spec.Form},
+			}}
+		}
+
+		status := spec.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := Response{Description: http.StatusText(status)}
+		switch {
+		case spec.Response != nil && spec.List:
+			success.Content = map[string]MediaType{"application/json": {Schema: &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{"items": {Type: "array", Items: schemas.of(spec.Response)}},
+			}}}
+		case spec.Response != nil:
+			success.Content = map[string]MediaType{"application/json": {Schema: schemas.of(spec.Response)}}
+		case spec.Content != "":
+			success.Content = map[string]MediaType{spec.Content: {Schema: &Schema{Type: "string", Format: "binary"}}}
+		}
+		op.Responses[fmt.Sprint(status)] = success
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     map[string]MediaType{"application/problem+json": {Schema: &Schema{Ref: "#/components/schemas/Problem"}}},
+		}
+
+		item := doc.Paths[path]
+		if item == nil {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+// operationID names an operation after its handler method, e.g.
+// "UploadHandler.GetFile", numbering handlers served on several routes.
+func operationID(route gin.RouteInfo, used map[string]int) string {
+	name := route.Handler[strings.LastIndex(route.Handler, "/")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	if i := strings.Index(name, "."); i >= 0 && strings.Count(name, ".") > 1 {
+		name = name[i+1:]
+	}
+
+	used[name]++
+	if n := used[name]; n > 1 {
+		return fmt.Sprintf("%s%d", name, n)
+	}
+	return name
+}
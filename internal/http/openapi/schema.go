@@ -0,0 +1,105 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemas derives schemas from Go types the way encoding/json renders them.
+// Named structs are added to the components and referenced. Fields are
+// required unless they are pointers, omitempty or tagged
+// `openapi:"optional"` for fields the handler may leave out.
+type schemas struct {
+	components map[string]*Schema
+}
+
+func newSchemas(components map[string]*Schema) *schemas {
+	return &schemas{components: components}
+}
+
+func (s *schemas) of(v any) *Schema {
+	return s.typeSchema(reflect.TypeOf(v))
+}
+
+func (s *schemas) typeSchema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		schema := s.typeSchema(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = &Schema{} // Placeholder for recursive types
+			s.components[t.Name()] = s.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return s.structSchema(t)
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.typeSchema(t.Elem())}
+	}
+	return &Schema{}
+}
+
+func (s *schemas) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = s.typeSchema(field.Type)
+
+		optional := strings.Contains(opts, "omitempty") || field.Type.Kind() == reflect.Pointer || field.Tag.Get("openapi") == "optional"
+		if !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
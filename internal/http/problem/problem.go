@@ -0,0 +1,162 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	ContentType = "application/problem+json"
+
+	// RequestIDHeader is echoed back on every response and used as the
+	// problem instance so errors can be correlated with server logs.
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+
+	requestIDKey = "requestId"
+	legacyKey    = "problemLegacy"
+	typeBaseKey  = "problemTypeBase"
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	body := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		body[k] = v
+	}
+	body["type"] = p.Type
+	body["title"] = p.Title
+	body["status"] = p.Status
+	if p.Detail != "" {
+		body["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		body["instance"] = p.Instance
+	}
+	return json.Marshal(body)
+}
+
+// Middleware configures how errors are rendered for the request. When legacy
+// is true errors keep the original {"error": ..., "details": ...} shape.
+func Middleware(typeBase string, legacy bool) gin.HandlerFunc {
+	typeBase = strings.TrimRight(typeBase, "/")
+	return func(c *gin.Context) {
+		c.Set(legacyKey, legacy)
+		c.Set(typeBaseKey, typeBase)
+		RequestID(c)
+		c.Next()
+	}
+}
+
+// RequestID returns the ID of the current request, taking it from the
+// incoming X-Request-ID header or generating a new one. IDs that could not
+// be logged safely are replaced.
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(requestIDKey); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if !validRequestID(id) {
+		id = uuid.New().String()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// validRequestID accepts up to 128 letters, digits and the separators
+// of UUIDs and trace IDs, so the ID is safe in headers, logs and URNs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		case b == '-', b == '_', b == '.', b == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Abort writes an error response and aborts the handler chain.
+func Abort(c *gin.Context, status int, title, detail string) {
+	AbortWith(c, Problem{Status: status, Title: title, Detail: detail})
+}
+
+// AbortWith writes p, filling in type, instance and status defaults.
+func AbortWith(c *gin.Context, p Problem) {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	if c.GetBool(legacyKey) {
+		body := gin.H{"error": p.Title}
+		if p.Detail != "" {
+			body["details"] = p.Detail
+		}
+		for k, v := range p.Extensions {
+			body[k] = v
+		}
+		body["requestId"] = RequestID(c)
+		c.AbortWithStatusJSON(p.Status, body)
+		return
+	}
+
+	if p.Type == "" {
+		p.Type = typeURI(c.GetString(typeBaseKey), p.Title)
+	}
+	if p.Instance == "" {
+		p.Instance = "urn:request:" + RequestID(c)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		c.AbortWithStatus(p.Status)
+		return
+	}
+	c.Data(p.Status, ContentType, data)
+	c.Abort()
+}
+
+func typeURI(base, title string) string {
+	if base == "" {
+		return "about:blank"
+	}
+	return base + "/" + slug(title)
+}
+
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
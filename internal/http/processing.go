@@ -0,0 +1,30 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/middleware"
+)
+
+// processingFeature reports on the background processing of files and
+// lets operators retry or purge failed jobs.
+type processingFeature struct{}
+
+func (processingFeature) Register(router *gin.Engine, deps *Deps) {
+	if !deps.Enabled("jobs") {
+		return
+	}
+
+	jobHandler := handler.NewJobHandler(deps.Metadata, deps.Jobs, deps.Logger)
+	router.GET("/v1/files/:fileId/jobs", deps.Auth, jobHandler.List)
+
+	jobRoutes := router.Group("/v1/admin/jobs")
+	jobRoutes.Use(deps.Auth, middleware.RequirePermissions([]string{"files:admin"}))
+	{
+		jobRoutes.GET("", jobHandler.ListAll)
+		jobRoutes.POST("/retry", jobHandler.RetryAll)
+		jobRoutes.POST("/purge", jobHandler.PurgeAll)
+		jobRoutes.POST("/:jobId/retry", jobHandler.Retry)
+		jobRoutes.DELETE("/:jobId", jobHandler.Purge)
+	}
+}
@@ -0,0 +1,36 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/middleware"
+)
+
+// replicationFeature serves the writes and content peer regions replicate,
+// the replication health and, to operators, the replication status and
+// resyncing. It is only registered when replication is on.
+type replicationFeature struct{}
+
+func (replicationFeature) Register(router *gin.Engine, deps *Deps) {
+	if deps.Replicas == nil {
+		return
+	}
+
+	replicationHandler := handler.NewReplicationHandler(deps.Replicas, deps.Logger)
+	// Peers sign their requests with the replication secret instead of
+	// authenticating with a token; administrators may also check the
+	// health.
+	replicationRoutes := router.Group("/v1/replication")
+	{
+		replicationRoutes.PUT("/files/:fileId", replicationHandler.Apply)
+		replicationRoutes.GET("/files/:fileId/content", replicationHandler.Content)
+		replicationRoutes.GET("/health", deps.OptionalAuth, replicationHandler.Health)
+	}
+
+	adminRoutes := router.Group("/v1/admin/replication")
+	adminRoutes.Use(deps.Auth, middleware.RequirePermissions([]string{"files:admin"}))
+	{
+		adminRoutes.GET("", replicationHandler.Status)
+		adminRoutes.POST("/resync", replicationHandler.Resync)
+	}
+}
@@ -2,38 +2,151 @@ package http
 
 import (
 	"log/slog"
+	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/audit"
 	"github.com/ondrasimku/media-service-go/internal/auth"
 	"github.com/ondrasimku/media-service-go/internal/config"
+	"github.com/ondrasimku/media-service-go/internal/cron"
+	"github.com/ondrasimku/media-service-go/internal/drain"
+	"github.com/ondrasimku/media-service-go/internal/events"
 	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/middleware"
+	"github.com/ondrasimku/media-service-go/internal/http/problem"
+	"github.com/ondrasimku/media-service-go/internal/integrity"
+	"github.com/ondrasimku/media-service-go/internal/jobs"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/region"
+	"github.com/ondrasimku/media-service-go/internal/replication"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+	"github.com/ondrasimku/media-service-go/internal/stats"
 	"github.com/ondrasimku/media-service-go/internal/storage"
 )
 
-func NewRouter(storage storage.Storage, maxFileSize int64, cfg *config.Config, logger *slog.Logger) *gin.Engine {
-	router := gin.Default()
+// Feature registers the routes of one area of the service. Features build
+// their own handlers from Deps, so they can be added, removed and tested
+// without touching each other.
+type Feature interface {
+	Register(router *gin.Engine, deps *Deps)
+}
 
-	healthHandler := handler.NewHealthHandler()
-	uploadHandler := handler.NewUploadHandler(storage, maxFileSize, logger)
+// features are registered in order.
+var features = []Feature{
+	filesFeature{},
+	annotationsFeature{},
+	sharingFeature{},
+	processingFeature{},
+	adminFeature{},
+	replicationFeature{},
+	docsFeature{}, // Describes the routes registered before it
+}
 
-	router.GET("/healthz", healthHandler.Health)
+// Deps are the services shared by features.
+type Deps struct {
+	Storage   storage.Storage
+	Metadata  metadata.Backend
+	Auditor   *integrity.Auditor
+	Scrubber  *integrity.Scrubber
+	Collector *integrity.Collector
+	Cron      *cron.Scheduler
+	Jobs      jobs.Queue      // May be nil
+	Events    *events.Emitter // May be nil
+	Stats     *stats.Recorder
+	Uploads   *media.UploadService
+	Files     *media.FileService
+	Brandings *media.BrandingService
+	Feeds     *media.FeedService
+	Quotas    *media.QuotaService
+	Geo       *media.GeoService
+	Orgs      *media.OrgService
+	Settings  *media.SettingsService
+	Presets   *media.PresetService
+	Audit     *audit.Trail
+	Replicas  *replication.Replicator // Nil when replication is off
+	Config    *config.Config
+	Logger    *slog.Logger
+
+	JWKS         *auth.JWKSClient
+	Introspector *auth.Introspector // Nil without token introspection
+	InFlight     *drain.Tracker
+
+	// The middleware below is built by NewRouter.
 
-	// authorize later
-	router.GET("/files/:fileId", uploadHandler.GetFile)
+	// Auth authenticates requests with a bearer token.
+	Auth gin.HandlerFunc
+
+	// OptionalAuth authenticates requests that carry a bearer token and
+	// lets the others through anonymously.
+	OptionalAuth gin.HandlerFunc
+
+	// HomeRegion sends downloads of files homed in another region to the
+	// deployment of that region, unless files are replicated.
+	HomeRegion gin.HandlerFunc
+}
+
+// Enabled reports whether an optional route group is to be registered, so
+// deployments expose only the features they use.
+func (d *Deps) Enabled(group string) bool {
+	return !slices.Contains(d.Config.API.DisabledRoutes, group)
+}
 
-	jwksClient := auth.NewJWKSClient(cfg.Auth.JWKSUrl, cfg.Auth.JWKSCacheTTL)
-	authMiddleware := auth.AuthMiddleware(jwksClient, auth.Config{
+// NewRouter registers the features on a new router, building the shared
+// middleware of deps from its configuration.
+func NewRouter(deps Deps) *gin.Engine {
+	cfg, logger := deps.Config, deps.Logger
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.Use(middleware.TrackInFlight(deps.InFlight))
+	router.Use(middleware.Trace())
+	router.Use(problem.Middleware(cfg.Errors.TypeBaseURL, cfg.Errors.Legacy))
+	router.Use(middleware.AccessLog(logger))
+	router.Use(middleware.Recover(logger))
+	router.Use(middleware.CountErrors(deps.Stats))
+	router.Use(middleware.Client(cfg.Geo.CountryHeader))
+	router.Use(middleware.CORS(middleware.CORSPolicy(cfg.CORS)))
+	router.NoRoute(func(c *gin.Context) {
+		problem.Abort(c, http.StatusNotFound, "Not found", "")
+	})
+	router.NoMethod(func(c *gin.Context) {
+		problem.Abort(c, http.StatusMethodNotAllowed, "Method not allowed", "")
+	})
+
+	healthHandler := handler.NewHealthHandler(map[string]handler.Check{
+		"storage":  deps.Storage.Ping,
+		"metadata": deps.Metadata.Ping,
+		"jwks":     deps.JWKS.Check,
+	})
+	router.GET("/healthz", healthHandler.Health)
+	router.GET("/readyz", healthHandler.Ready)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Replicated files are served by every region.
+	var regions *region.Router
+	if cfg.Region.Name != "" && !cfg.Replication.Enabled {
+		regions = region.NewRouter(cfg.Region.Name, cfg.Region.Peers, cfg.Region.Mode)
+	}
+	authConfig := auth.Config{
 		JWKSUrl:      cfg.Auth.JWKSUrl,
 		Issuer:       cfg.Auth.Issuer,
 		Audience:     cfg.Auth.Audience,
 		JWKSCacheTTL: cfg.Auth.JWKSCacheTTL,
-	})
+		Algorithms:   cfg.Auth.Algorithms,
 
-	fileRoutes := router.Group("/files")
-	fileRoutes.Use(authMiddleware)
-	{
-		fileRoutes.POST("", auth.RequirePermissions([]string{"files:upload"}), uploadHandler.Upload)
-		//fileRoutes.GET("/:fileId", auth.RequirePermissions([]string{}), uploadHandler.GetFile)
+		Leeway:            cfg.Auth.Leeway,
+		RequireExpiration: cfg.Auth.RequireExpiration,
+		RequireNotBefore:  cfg.Auth.RequireNotBefore,
+		ScopePermissions:  cfg.Auth.ScopePermissions,
+		AdminRole:         cfg.Auth.AdminRole,
+		Introspector:      deps.Introspector,
+	}
+	deps.Auth = middleware.Auth(deps.JWKS, authConfig)
+	deps.OptionalAuth = middleware.OptionalAuth(deps.JWKS, authConfig)
+	deps.HomeRegion = middleware.HomeRegion(regions, deps.Files.HomeRegion, logger)
+	for _, f := range features {
+		f.Register(router, &deps)
 	}
 
 	return router
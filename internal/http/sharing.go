@@ -0,0 +1,76 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ondrasimku/media-service-go/internal/http/handler"
+	"github.com/ondrasimku/media-service-go/internal/http/middleware"
+)
+
+// sharingFeature gives files further addresses: aliases of old IDs and
+// named aliases, short links, public slugs, the sitemaps and feeds of
+// organizations and signed HLS streams.
+type sharingFeature struct{}
+
+func (sharingFeature) Register(router *gin.Engine, deps *Deps) {
+	cfg, logger := deps.Config, deps.Logger
+	v1 := router.Group("/v1")
+
+	if deps.Enabled("aliases") {
+		aliasHandler := handler.NewAliasHandler(deps.Metadata, deps.Metadata, deps.Files, deps.Storage, deps.Audit, logger)
+		router.GET("/a/:name", aliasHandler.Resolve)
+
+		aliasRoutes := v1.Group("")
+		aliasRoutes.Use(deps.Auth)
+		{
+			aliasRoutes.GET("/files/:fileId/aliases", aliasHandler.List)
+			aliasRoutes.POST("/files/:fileId/aliases", middleware.RequirePermissions([]string{"files:alias"}), aliasHandler.Create)
+			aliasRoutes.GET("/aliases/:aliasId", aliasHandler.Get)
+			aliasRoutes.PUT("/aliases/:aliasId", middleware.RequirePermissions([]string{"files:alias"}), aliasHandler.Put)
+			aliasRoutes.DELETE("/aliases/:aliasId", middleware.RequirePermissions([]string{"files:alias"}), aliasHandler.Delete)
+		}
+	}
+
+	if deps.Enabled("shortlinks") {
+		shortLinkHandler := handler.NewShortLinkHandler(deps.Files, deps.Metadata, cfg.PublicBaseURL, deps.Audit, logger)
+		router.GET("/s/:code", shortLinkHandler.Resolve)
+
+		shortLinkRoutes := v1.Group("")
+		shortLinkRoutes.Use(deps.Auth)
+		{
+			shortLinkRoutes.GET("/files/:fileId/shortlinks", shortLinkHandler.List)
+			shortLinkRoutes.POST("/files/:fileId/shortlinks", middleware.RequirePermissions([]string{"files:share"}), shortLinkHandler.Create)
+			shortLinkRoutes.DELETE("/shortlinks/:code", middleware.RequirePermissions([]string{"files:share"}), shortLinkHandler.Delete)
+		}
+	}
+
+	if deps.Enabled("slugs") {
+		slugHandler := handler.NewSlugHandler(deps.Metadata, deps.Metadata, deps.Storage, cfg.PublicBaseURL, deps.Audit, logger)
+		router.GET("/p/:orgId/:slug", slugHandler.Resolve)
+
+		slugRoutes := v1.Group("/slugs")
+		slugRoutes.Use(deps.Auth)
+		{
+			slugRoutes.GET("", slugHandler.List)
+			slugRoutes.PUT("/:slug", middleware.RequirePermissions([]string{"files:share"}), slugHandler.Put)
+			slugRoutes.DELETE("/:slug", middleware.RequirePermissions([]string{"files:share"}), slugHandler.Delete)
+		}
+	}
+
+	// Sitemaps and feeds share the /p/ namespace of an organization with
+	// its slugs, which cannot take their names.
+	if deps.Enabled("feeds") {
+		feedHandler := handler.NewFeedHandler(deps.Feeds, cfg.Feeds.CacheTTL, logger)
+		router.GET("/p/:orgId/sitemap.xml", feedHandler.Sitemap)
+		router.GET("/p/:orgId/feed.xml", feedHandler.Atom)
+		router.GET("/p/:orgId/rss.xml", feedHandler.RSS)
+	}
+
+	// Streams are authorized by signed URLs, if at all, so players need
+	// no credentials; those of files that are not public also to callers
+	// who may see them.
+	if deps.Enabled("hls") {
+		hlsHandler := handler.NewHLSHandler(deps.Storage, deps.Metadata, deps.Metadata, deps.Files, logger)
+		v1.GET("/files/:fileId/hls/:name", deps.HomeRegion, deps.OptionalAuth, hlsHandler.Serve)
+		v1.POST("/files/:fileId/hls/sign", deps.Auth, middleware.RequirePermissions([]string{"files:share"}), hlsHandler.Sign)
+	}
+}
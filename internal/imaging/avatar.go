@@ -0,0 +1,80 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"sort"
+)
+
+// Avatars is the output of the avatar pipeline: a square image no larger
+// than the biggest preset, plus one rendition of exactly each preset size.
+type Avatars struct {
+	Image      []byte
+	Size       int
+	Renditions map[int][]byte
+}
+
+// MakeAvatars decodes a JPEG or PNG image, applies its EXIF orientation,
+// crops it to a square and scales it to each of sizes. crop selects the
+// region in displayed (oriented) pixel coordinates; when nil the largest
+// centered square is used. A non-square crop is narrowed to its centered
+// square. The main image is the square scaled down to the largest size,
+// or left as is if it is smaller.
+func MakeAvatars(data []byte, contentType string, crop *image.Rectangle, sizes []int) (Avatars, error) {
+	if !Decodable(contentType) {
+		return Avatars{}, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	if len(sizes) == 0 {
+		return Avatars{}, fmt.Errorf("no avatar sizes configured")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Avatars{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if tiff := exifPayload(data, contentType); tiff != nil {
+		img = ApplyOrientation(img, exifOrientation(tiff))
+	}
+
+	b := img.Bounds()
+	region := image.Rect(0, 0, b.Dx(), b.Dy())
+	if crop != nil {
+		if crop.Empty() || !crop.In(region) {
+			return Avatars{}, fmt.Errorf("crop %v is outside the %dx%d image", *crop, b.Dx(), b.Dy())
+		}
+		region = *crop
+	}
+	square := centerSquare(region)
+
+	cropped := image.NewRGBA(image.Rect(0, 0, square.Dx(), square.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, b.Min.Add(square.Min), draw.Src)
+
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+
+	avatars := Avatars{Renditions: make(map[int][]byte, len(sorted))}
+	for _, size := range sorted {
+		out, err := encode(Resize(cropped, size, size), contentType)
+		if err != nil {
+			return Avatars{}, err
+		}
+		avatars.Renditions[size] = out
+	}
+
+	avatars.Size = min(sorted[len(sorted)-1], square.Dx())
+	if avatars.Size == sorted[len(sorted)-1] {
+		avatars.Image = avatars.Renditions[avatars.Size]
+	} else if avatars.Image, err = encode(cropped, contentType); err != nil {
+		return Avatars{}, err
+	}
+	return avatars, nil
+}
+
+func centerSquare(r image.Rectangle) image.Rectangle {
+	side := min(r.Dx(), r.Dy())
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
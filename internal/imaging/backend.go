@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+)
+
+// Backend performs the operations that decode an image in full. The pure Go
+// backend is always available; others are compiled in with build tags
+// (e.g. -tags vips) and register themselves in backends.
+type Backend interface {
+	Name() string
+
+	// Cost estimates the peak memory in bytes the backend needs to decode
+	// and process the image described by info.
+	Cost(info ImageInfo) int64
+
+	Verify(data []byte, contentType string) error
+	ExtractColors(data []byte, contentType string, n int) (Colors, error)
+	MakeAvatars(data []byte, contentType string, crop *image.Rectangle, sizes []int) (Avatars, error)
+}
+
+var backends = map[string]func() (Backend, error){
+	"go": func() (Backend, error) { return goBackend{}, nil },
+}
+
+// NewBackend returns the backend registered under name.
+func NewBackend(name string) (Backend, error) {
+	newBackend, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown imaging backend %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return newBackend()
+}
+
+// goBackend decodes with the standard library. Images are held fully
+// decoded: the avatar pipeline keeps up to three RGBA copies (decoded,
+// oriented, cropped) alive at once, and verifying a GIF holds every frame.
+type goBackend struct{}
+
+func (goBackend) Name() string { return "go" }
+
+func (goBackend) Cost(info ImageInfo) int64 {
+	pixels := int64(info.Width) * int64(info.Height)
+	return max(3*4*pixels, int64(info.Frames)*pixels)
+}
+
+func (goBackend) Verify(data []byte, contentType string) error {
+	return Verify(data, contentType)
+}
+
+func (goBackend) ExtractColors(data []byte, contentType string, n int) (Colors, error) {
+	return ExtractColors(data, contentType, n)
+}
+
+func (goBackend) MakeAvatars(data []byte, contentType string, crop *image.Rectangle, sizes []int) (Avatars, error) {
+	return MakeAvatars(data, contentType, crop, sizes)
+}
@@ -0,0 +1,158 @@
+//go:build vips
+
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"sort"
+
+	"github.com/h2non/bimg"
+)
+
+func init() {
+	backends["vips"] = func() (Backend, error) {
+		// Results are never reused, so libvips' operation cache would only
+		// hold on to memory between requests.
+		bimg.VipsCacheSetMax(0)
+		bimg.VipsCacheSetMaxMem(0)
+		return vipsBackend{}, nil
+	}
+}
+
+// vipsBackend processes images with libvips, which decodes in strips and
+// shrinks JPEGs while loading them, so it needs a fraction of the memory of
+// the Go backend for large images. Images are rotated upright by their EXIF
+// orientation before cropping, like the Go backend does.
+type vipsBackend struct{}
+
+func (vipsBackend) Name() string { return "vips" }
+
+// Cost assumes one decoded copy, as cropping and rotating need random
+// access to the pixels. GIFs are still verified in Go.
+func (vipsBackend) Cost(info ImageInfo) int64 {
+	pixels := int64(info.Width) * int64(info.Height)
+	return max(4*pixels, int64(info.Frames)*pixels)
+}
+
+// Verify decodes and re-encodes the image, which fails on truncated or
+// corrupt pixel data. libvips only loads the first frame of a GIF, so
+// GIFs are verified in Go.
+func (vipsBackend) Verify(data []byte, contentType string) error {
+	if !Decodable(contentType) {
+		return Verify(data, contentType)
+	}
+	if _, err := bimg.NewImage(data).Process(bimg.Options{Type: vipsType(contentType)}); err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	return nil
+}
+
+// ExtractColors shrinks the image to the sampling grid in libvips and
+// analyzes the small result in Go.
+func (vipsBackend) ExtractColors(data []byte, contentType string, n int) (Colors, error) {
+	if !Decodable(contentType) {
+		return Colors{}, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	small, err := bimg.NewImage(data).Process(bimg.Options{
+		Width:  paletteSamples,
+		Height: paletteSamples,
+		Type:   bimg.PNG,
+	})
+	if err != nil {
+		return Colors{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	img, err := png.Decode(bytes.NewReader(small))
+	if err != nil {
+		return Colors{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return colorsOf(img, n), nil
+}
+
+func (vipsBackend) MakeAvatars(data []byte, contentType string, crop *image.Rectangle, sizes []int) (Avatars, error) {
+	if !Decodable(contentType) {
+		return Avatars{}, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	if len(sizes) == 0 {
+		return Avatars{}, fmt.Errorf("no avatar sizes configured")
+	}
+
+	size, err := bimg.NewImage(data).Size()
+	if err != nil {
+		return Avatars{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	width, height := size.Width, size.Height
+	if tiff := exifPayload(data, contentType); tiff != nil && exifOrientation(tiff) >= 5 {
+		width, height = height, width
+	}
+
+	region := image.Rect(0, 0, width, height)
+	if crop != nil {
+		if crop.Empty() || !crop.In(region) {
+			return Avatars{}, fmt.Errorf("crop %v is outside the %dx%d image", *crop, width, height)
+		}
+		region = *crop
+	}
+	square := centerSquare(region)
+
+	// bimg extracts after resizing, so the square is cut out in a pass of
+	// its own, losslessly, and scaled from there.
+	cropped, err := bimg.NewImage(data).Process(bimg.Options{
+		Top:           square.Min.Y,
+		Left:          square.Min.X,
+		AreaWidth:     square.Dx(),
+		AreaHeight:    square.Dy(),
+		Type:          bimg.PNG,
+		StripMetadata: true,
+	})
+	if err != nil {
+		return Avatars{}, fmt.Errorf("failed to crop image: %w", err)
+	}
+
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+
+	avatars := Avatars{Renditions: make(map[int][]byte, len(sorted))}
+	for _, size := range sorted {
+		out, err := vipsEncode(cropped, contentType, size)
+		if err != nil {
+			return Avatars{}, err
+		}
+		avatars.Renditions[size] = out
+	}
+
+	avatars.Size = min(sorted[len(sorted)-1], square.Dx())
+	if avatars.Size == sorted[len(sorted)-1] {
+		avatars.Image = avatars.Renditions[avatars.Size]
+	} else if avatars.Image, err = vipsEncode(cropped, contentType, 0); err != nil {
+		return Avatars{}, err
+	}
+	return avatars, nil
+}
+
+// vipsEncode scales a square image to size (unless size is 0) and encodes
+// it like encode does: PNG, or JPEG for any other content type.
+func vipsEncode(square []byte, contentType string, size int) ([]byte, error) {
+	out, err := bimg.NewImage(square).Process(bimg.Options{
+		Width:         size,
+		Height:        size,
+		Force:         size > 0,
+		Enlarge:       true,
+		Type:          vipsType(contentType),
+		Quality:       92,
+		StripMetadata: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return out, nil
+}
+
+func vipsType(contentType string) bimg.ImageType {
+	if contentType == "image/png" {
+		return bimg.PNG
+	}
+	return bimg.JPEG
+}
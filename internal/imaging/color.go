@@ -0,0 +1,98 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"sort"
+)
+
+// paletteSamples bounds how many pixels are inspected per axis, so palette
+// extraction costs the same for small and large images.
+const paletteSamples = 96
+
+// Colors summarizes the colors of an image as #rrggbb strings.
+type Colors struct {
+	Dominant string
+	Average  string
+	Palette  []string
+}
+
+// ExtractColors decodes a JPEG or PNG image and returns its average color
+// and up to n most common colors, most common first. Pixels are sampled on a
+// grid and bucketed to 4 bits per channel; each palette entry is the mean of
+// its bucket. Fully transparent pixels are ignored.
+func ExtractColors(data []byte, contentType string, n int) (Colors, error) {
+	if !Decodable(contentType) {
+		return Colors{}, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Colors{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return colorsOf(img, n), nil
+}
+
+type colorBucket struct {
+	r, g, b, count uint64
+}
+
+func colorsOf(img image.Image, n int) Colors {
+	bounds := img.Bounds()
+	stepX := max(1, bounds.Dx()/paletteSamples)
+	stepY := max(1, bounds.Dy()/paletteSamples)
+
+	buckets := make(map[uint16]*colorBucket)
+	var total colorBucket
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a == 0 {
+				continue
+			}
+			// Un-premultiply and reduce to 8 bits per channel.
+			r8, g8, b8 := uint64(r*0xff/a), uint64(g*0xff/a), uint64(b*0xff/a)
+
+			key := uint16(r8>>4)<<8 | uint16(g8>>4)<<4 | uint16(b8>>4)
+			bucket := buckets[key]
+			if bucket == nil {
+				bucket = &colorBucket{}
+				buckets[key] = bucket
+			}
+			bucket.add(r8, g8, b8)
+			total.add(r8, g8, b8)
+		}
+	}
+	if total.count == 0 {
+		return Colors{}
+	}
+
+	sorted := make([]*colorBucket, 0, len(buckets))
+	for _, b := range buckets {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].hex() < sorted[j].hex()
+	})
+
+	colors := Colors{Average: total.hex()}
+	for _, b := range sorted[:min(n, len(sorted))] {
+		colors.Palette = append(colors.Palette, b.hex())
+	}
+	colors.Dominant = sorted[0].hex()
+	return colors
+}
+
+func (b *colorBucket) add(r, g, bl uint64) {
+	b.r += r
+	b.g += g
+	b.b += bl
+	b.count++
+}
+
+func (b *colorBucket) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", b.r/b.count, b.g/b.count, b.b/b.count)
+}
@@ -0,0 +1,24 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// CompressJPEG re-encodes a JPEG photo at quality, rotated upright as its
+// EXIF orientation says. Metadata is dropped with the re-encode. Photos
+// that were already compressed harder may come out larger; callers keep
+// the smaller of the two.
+func CompressJPEG(data []byte, orientation, quality int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, ApplyOrientation(img, orientation), &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
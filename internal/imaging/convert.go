@@ -0,0 +1,149 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+type Format string
+
+const (
+	FormatWebP Format = "webp"
+	FormatAVIF Format = "avif"
+)
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+// ParseFormat maps a format name or MIME type to a Format.
+func ParseFormat(s string) (Format, bool) {
+	switch s {
+	case "webp", "image/webp":
+		return FormatWebP, true
+	case "avif", "image/avif":
+		return FormatAVIF, true
+	}
+	return "", false
+}
+
+type ConverterConfig struct {
+	CWebPPath   string
+	AVIFEncPath string
+	WebPQuality int
+	AVIFQuality int
+}
+
+// Converter re-encodes JPEG/PNG images into modern formats using the
+// libwebp and libavif command line encoders. Formats whose encoder is not
+// installed are reported as unsupported.
+type Converter struct {
+	tools map[Format]func(ctx context.Context, in, out string) *exec.Cmd
+}
+
+func NewConverter(cfg ConverterConfig) *Converter {
+	c := &Converter{tools: make(map[Format]func(ctx context.Context, in, out string) *exec.Cmd)}
+
+	if path, err := exec.LookPath(cfg.CWebPPath); err == nil {
+		q := strconv.Itoa(cfg.WebPQuality)
+		c.tools[FormatWebP] = func(ctx context.Context, in, out string) *exec.Cmd {
+			return exec.CommandContext(ctx, path, "-quiet", "-metadata", "none", "-q", q, in, "-o", out)
+		}
+	}
+	if path, err := exec.LookPath(cfg.AVIFEncPath); err == nil {
+		q := strconv.Itoa(cfg.AVIFQuality)
+		c.tools[FormatAVIF] = func(ctx context.Context, in, out string) *exec.Cmd {
+			return exec.CommandContext(ctx, path, "-q", q, in, out)
+		}
+	}
+
+	return c
+}
+
+// Cost estimates the memory an encoder needs for an image of the given
+// size: the decoded input plus the encoder's working buffers.
+func (c *Converter) Cost(width, height int) int64 {
+	return 2 * 4 * int64(width) * int64(height)
+}
+
+func (c *Converter) Supports(f Format) bool {
+	_, ok := c.tools[f]
+	return ok
+}
+
+// CanConvert reports whether images of the given content type are accepted
+// as conversion input.
+func CanConvert(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// Convert encodes src (of srcContentType) into format f and writes the
+// result to dst.
+func (c *Converter) Convert(ctx context.Context, src io.Reader, srcContentType string, f Format, dst io.Writer) error {
+	tool, ok := c.tools[f]
+	if !ok {
+		return fmt.Errorf("conversion to %s not supported", f)
+	}
+	if !CanConvert(srcContentType) {
+		return fmt.Errorf("cannot convert %s", srcContentType)
+	}
+
+	workDir, err := os.MkdirTemp("", "media-convert-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	ext := ".jpg"
+	if srcContentType == "image/png" {
+		ext = ".png"
+	}
+	in := filepath.Join(workDir, "in"+ext)
+	out := filepath.Join(workDir, "out."+string(f))
+
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	cmd := tool(ctx, in, out)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("%s encoder failed: %w: %s", f, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	result, err := os.Open(out)
+	if err != nil {
+		return fmt.Errorf("failed to open encoder output: %w", err)
+	}
+	defer result.Close()
+
+	if _, err := bufpool.Copy(dst, result); err != nil {
+		return fmt.Errorf("failed to copy encoder output: %w", err)
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = bufpool.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
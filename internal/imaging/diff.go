@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// DiffResult summarizes how two images differ.
+type DiffResult struct {
+	// Score is the mean absolute per-channel difference, from 0 (identical)
+	// to 1 (inverted).
+	Score float64
+	// ChangedRatio is the share of pixels whose difference exceeds the
+	// noise threshold.
+	ChangedRatio float64
+	// Image shows the base image dimmed to grayscale with changed pixels
+	// highlighted in red, brighter for larger differences.
+	Image *image.RGBA
+}
+
+const diffThreshold = 0.04
+
+// DiffCost estimates the memory comparing images of sizes base and b
+// takes: both decoded, at up to 8 bytes a pixel, and converted to RGBA,
+// and the result image at the size of base.
+func DiffCost(base, b image.Point) int64 {
+	pixels := func(p image.Point) int64 { return int64(p.X) * int64(p.Y) }
+	return 12*(pixels(base)+pixels(b)) + 4*pixels(base)
+}
+
+// Diff compares b against base. If the sizes differ, b is sampled to the
+// dimensions of base.
+func Diff(base, b image.Image) DiffResult {
+	bounds := base.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	src := toRGBA(base)
+	other := toRGBA(b)
+	ob := other.Bounds()
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	var total float64
+	var changed int
+
+	for y := 0; y < h; y++ {
+		oy := y * ob.Dy() / h
+		for x := 0; x < w; x++ {
+			ox := x * ob.Dx() / w
+
+			i := src.PixOffset(x, y)
+			j := other.PixOffset(ox, oy)
+			p := src.Pix[i : i+4]
+			q := other.Pix[j : j+4]
+
+			d := (absDiff(p[0], q[0]) + absDiff(p[1], q[1]) + absDiff(p[2], q[2])) / (3 * 255)
+			total += d
+
+			gray := uint8((299*uint32(p[0]) + 587*uint32(p[1]) + 114*uint32(p[2])) / 1000 / 3)
+			if d > diffThreshold {
+				changed++
+				out.SetRGBA(x, y, color.RGBA{R: uint8(128 + d*127), G: gray / 2, B: gray / 2, A: 255})
+			} else {
+				out.SetRGBA(x, y, color.RGBA{R: gray, G: gray, B: gray, A: 255})
+			}
+		}
+	}
+
+	pixels := float64(w * h)
+	if pixels == 0 {
+		return DiffResult{Image: out}
+	}
+	return DiffResult{
+		Score:        total / pixels,
+		ChangedRatio: float64(changed) / pixels,
+		Image:        out,
+	}
+}
+
+func absDiff(a, b uint8) float64 {
+	if a > b {
+		return float64(a - b)
+	}
+	return float64(b - a)
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,180 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagOrientation = 0x0112
+	tagExifIFD     = 0x8769
+)
+
+var errInvalidExif = errors.New("invalid exif data")
+
+// exifFields lists the EXIF tags copied into file metadata. GPS position,
+// serial numbers and owner names are deliberately left out.
+var exifFields = map[uint16]string{
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8827: "ISOSpeedRatings",
+	0x9003: "DateTimeOriginal",
+	0x920A: "FocalLength",
+	0xA434: "LensModel",
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // raw value, either inline or at the referenced offset
+}
+
+func newTIFFReader(tiff []byte) (*tiffReader, int, error) {
+	if len(tiff) < 8 {
+		return nil, 0, errInvalidExif
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errInvalidExif
+	}
+
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil, 0, errInvalidExif
+	}
+	return &tiffReader{data: tiff, order: order}, int(order.Uint32(tiff[4:])), nil
+}
+
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+func (r *tiffReader) entries(offset int) ([]ifdEntry, error) {
+	if offset < 0 || len(r.data) < offset+2 {
+		return nil, errInvalidExif
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+
+	entries := make([]ifdEntry, 0, count)
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		if len(r.data) < pos+12 {
+			return nil, errInvalidExif
+		}
+
+		e := ifdEntry{
+			tag:   r.order.Uint16(r.data[pos:]),
+			typ:   r.order.Uint16(r.data[pos+2:]),
+			count: r.order.Uint32(r.data[pos+4:]),
+		}
+		size := typeSizes[e.typ] * int(e.count)
+		if size <= 0 || size > len(r.data) {
+			continue
+		}
+		if size <= 4 {
+			e.value = r.data[pos+8 : pos+8+size]
+		} else {
+			valueOffset := int(r.order.Uint32(r.data[pos+8:]))
+			if valueOffset < 0 || valueOffset+size > len(r.data) {
+				continue
+			}
+			e.value = r.data[valueOffset : valueOffset+size]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (r *tiffReader) uint(e ifdEntry) (uint32, bool) {
+	switch e.typ {
+	case 3:
+		return uint32(r.order.Uint16(e.value)), true
+	case 4:
+		return r.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+func (r *tiffReader) format(e ifdEntry) string {
+	switch e.typ {
+	case 2:
+		return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+	case 3, 4:
+		v, _ := r.uint(e)
+		return strconv.FormatUint(uint64(v), 10)
+	case 5:
+		num, den := r.order.Uint32(e.value), r.order.Uint32(e.value[4:])
+		if den == 0 {
+			return ""
+		}
+		if num < den && num != 0 && den%num == 0 {
+			return fmt.Sprintf("1/%d", den/num)
+		}
+		return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+	}
+	return ""
+}
+
+// parseExif reads the orientation and the whitelisted fields from a
+// TIFF-structured EXIF payload.
+func parseExif(tiff []byte) (int, map[string]string) {
+	orientation := 1
+	fields := make(map[string]string)
+
+	r, ifd0, err := newTIFFReader(tiff)
+	if err != nil {
+		return orientation, fields
+	}
+
+	offsets := []int{ifd0}
+	for len(offsets) > 0 {
+		entries, err := r.entries(offsets[0])
+		offsets = offsets[1:]
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			switch e.tag {
+			case tagOrientation:
+				if v, ok := r.uint(e); ok && v >= 1 && v <= 8 {
+					orientation = int(v)
+				}
+			case tagExifIFD:
+				if v, ok := r.uint(e); ok && int(v) != ifd0 {
+					offsets = append(offsets, int(v))
+				}
+			default:
+				if name, ok := exifFields[e.tag]; ok {
+					if v := r.format(e); v != "" {
+						fields[name] = v
+					}
+				}
+			}
+		}
+	}
+	return orientation, fields
+}
+
+// exifOrientation reads the orientation tag (1-8) from a TIFF-structured
+// EXIF payload. It returns 1 (upright) when the tag is absent.
+func exifOrientation(tiff []byte) int {
+	orientation, _ := parseExif(tiff)
+	return orientation
+}
@@ -0,0 +1,146 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image/gif"
+)
+
+const (
+	gifExtension  = 0x21
+	gifImage      = 0x2C
+	gifTrailer    = 0x3B
+	gifComment    = 0xFE
+	gifAppExt     = 0xFF
+	gifHeaderSize = 13
+)
+
+// gifBlock is a top-level block of a GIF stream, [start, end) in the file.
+type gifBlock struct {
+	start, end int
+	kind       byte
+	label      byte   // extension label, for extension blocks
+	appID      string // application identifier, for application extensions
+}
+
+// gifBlocks walks the block structure of a GIF without decompressing any
+// image data, so it is cheap even for huge animations.
+func gifBlocks(data []byte) ([]gifBlock, error) {
+	if len(data) < gifHeaderSize || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errMalformed
+	}
+	i := gifHeaderSize + colorTableSize(data[10])
+
+	var blocks []gifBlock
+	for i < len(data) {
+		b := gifBlock{start: i, kind: data[i]}
+		switch b.kind {
+		case gifTrailer:
+			b.end = i + 1
+			return append(blocks, b), nil
+		case gifExtension:
+			if i+2 > len(data) {
+				return nil, errMalformed
+			}
+			b.label = data[i+1]
+			if b.label == gifAppExt && i+3 <= len(data) && int(data[i+2]) >= 11 && i+14 <= len(data) {
+				b.appID = string(data[i+3 : i+14])
+			}
+			end, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			b.end = end
+		case gifImage:
+			if i+10 > len(data) {
+				return nil, errMalformed
+			}
+			// Descriptor, optional local color table, LZW minimum code size.
+			j := i + 10 + colorTableSize(data[i+9]) + 1
+			end, err := skipSubBlocks(data, j)
+			if err != nil {
+				return nil, err
+			}
+			b.end = end
+		default:
+			return nil, errMalformed
+		}
+		blocks = append(blocks, b)
+		i = b.end
+	}
+	// Tolerate a missing trailer, as decoders do.
+	if len(blocks) == 0 {
+		return nil, errMalformed
+	}
+	return blocks, nil
+}
+
+func colorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << ((packed & 0x07) + 1)
+}
+
+// skipSubBlocks returns the offset after the data sub-block chain at i.
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errMalformed
+		}
+		n := int(data[i])
+		i++
+		if n == 0 {
+			return i, nil
+		}
+		i += n
+	}
+}
+
+// gifFrames counts the frames of a GIF.
+func gifFrames(data []byte) (int, error) {
+	blocks, err := gifBlocks(data)
+	if err != nil {
+		return 0, err
+	}
+	frames := 0
+	for _, b := range blocks {
+		if b.kind == gifImage {
+			frames++
+		}
+	}
+	return frames, nil
+}
+
+// stripGIF drops comment extensions and application extensions other than
+// the looping ones (which would otherwise carry XMP metadata). Frames are
+// copied byte for byte, so animations are preserved exactly.
+func stripGIF(data []byte) ([]byte, error) {
+	blocks, err := gifBlocks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:blocks[0].start])
+	for _, b := range blocks {
+		if b.kind == gifExtension {
+			if b.label == gifComment {
+				continue
+			}
+			if b.label == gifAppExt && b.appID != "NETSCAPE2.0" && b.appID != "ANIMEXTS1.0" {
+				continue
+			}
+		}
+		out.Write(data[b.start:b.end])
+	}
+	return out.Bytes(), nil
+}
+
+// verifyGIF decodes every frame of a GIF.
+func verifyGIF(data []byte) error {
+	if _, err := gif.DecodeAll(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	return nil
+}
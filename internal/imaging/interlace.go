@@ -0,0 +1,94 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// Interlacer rewrites JPEGs as progressive and PNGs as Adam7-interlaced, so
+// that browsers on slow connections draw a coarse image first and refine
+// it. Both rewrites are lossless: jpegtran rearranges the existing DCT
+// coefficients and optipng only reorders the scanlines. Types whose tool
+// is not installed are reported as unsupported.
+type Interlacer struct {
+	tools map[string]func(ctx context.Context, in, out string) *exec.Cmd
+}
+
+// NewInterlacer looks up jpegtran and optipng at the given paths.
+func NewInterlacer(jpegtranPath, optipngPath string) *Interlacer {
+	i := &Interlacer{tools: make(map[string]func(ctx context.Context, in, out string) *exec.Cmd)}
+
+	if path, err := exec.LookPath(jpegtranPath); err == nil {
+		i.tools["image/jpeg"] = func(ctx context.Context, in, out string) *exec.Cmd {
+			return exec.CommandContext(ctx, path, "-progressive", "-optimize", "-copy", "none", "-outfile", out, in)
+		}
+	}
+	if path, err := exec.LookPath(optipngPath); err == nil {
+		i.tools["image/png"] = func(ctx context.Context, in, out string) *exec.Cmd {
+			return exec.CommandContext(ctx, path, "-quiet", "-o1", "-i1", "-strip", "all", "-out", out, in)
+		}
+	}
+
+	return i
+}
+
+// Cost estimates the memory the tools need for an image of the given size:
+// one decoded copy of it.
+func (i *Interlacer) Cost(width, height int) int64 {
+	return 4 * int64(width) * int64(height)
+}
+
+func (i *Interlacer) Supports(contentType string) bool {
+	if i == nil {
+		return false
+	}
+	_, ok := i.tools[contentType]
+	return ok
+}
+
+// Interlace writes the progressive or interlaced form of the contentType
+// image read from src to dst.
+func (i *Interlacer) Interlace(ctx context.Context, src io.Reader, contentType string, dst io.Writer) error {
+	tool, ok := i.tools[contentType]
+	if !ok {
+		return fmt.Errorf("interlacing %s not supported", contentType)
+	}
+
+	workDir, err := os.MkdirTemp("", "media-interlace-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	out := filepath.Join(workDir, "out")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	cmd := tool(ctx, in, out)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", filepath.Base(cmd.Path), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	result, err := os.Open(out)
+	if err != nil {
+		return fmt.Errorf("failed to open interlaced image: %w", err)
+	}
+	defer result.Close()
+
+	if _, err := bufpool.Copy(dst, result); err != nil {
+		return fmt.Errorf("failed to copy interlaced image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package imaging
+
+import "fmt"
+
+// Limits bounds the dimensions of accepted images. Checking them against
+// the header before any full decode keeps small but hugely dimensioned
+// files (decompression bombs) from exhausting memory. Zero values disable
+// the respective check.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+
+	// Animation limits: the number of frames and the pixels of all frames
+	// together, i.e. what a full decode holds in memory.
+	MaxFrames      int
+	MaxTotalPixels int64
+}
+
+// Check returns an error describing the first limit info exceeds.
+func (l Limits) Check(info ImageInfo) error {
+	if l.MaxWidth > 0 && info.Width > l.MaxWidth {
+		return fmt.Errorf("width %d exceeds the limit of %d pixels", info.Width, l.MaxWidth)
+	}
+	if l.MaxHeight > 0 && info.Height > l.MaxHeight {
+		return fmt.Errorf("height %d exceeds the limit of %d pixels", info.Height, l.MaxHeight)
+	}
+	if pixels := int64(info.Width) * int64(info.Height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return fmt.Errorf("%d pixels exceed the limit of %d", pixels, l.MaxPixels)
+	}
+	if l.MaxFrames > 0 && info.Frames > l.MaxFrames {
+		return fmt.Errorf("%d frames exceed the limit of %d", info.Frames, l.MaxFrames)
+	}
+	if total := int64(info.Frames) * int64(info.Width) * int64(info.Height); l.MaxTotalPixels > 0 && total > l.MaxTotalPixels {
+		return fmt.Errorf("%d pixels across all frames exceed the limit of %d", total, l.MaxTotalPixels)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// TextRecognizer extracts the text of scanned images using tesseract. When
+// the tool is not installed recognition is reported as unsupported.
+type TextRecognizer struct {
+	path      string
+	languages string
+}
+
+// NewTextRecognizer looks up tesseract at path. languages are the
+// tesseract language codes to recognize, joined by '+', e.g. "eng+deu".
+func NewTextRecognizer(path, languages string) *TextRecognizer {
+	r := &TextRecognizer{languages: languages}
+	if p, err := exec.LookPath(path); err == nil {
+		r.path = p
+	}
+	return r
+}
+
+// Cost estimates the memory tesseract needs for a page scanned at 300 DPI,
+// with headroom for its models.
+func (r *TextRecognizer) Cost() int64 {
+	return 256 << 20
+}
+
+func (r *TextRecognizer) Supported() bool {
+	return r != nil && r.path != ""
+}
+
+// Recognize writes the plain text found in the image read from src to dst.
+func (r *TextRecognizer) Recognize(ctx context.Context, src io.Reader, dst io.Writer) error {
+	if !r.Supported() {
+		return fmt.Errorf("text recognition not supported")
+	}
+
+	workDir, err := os.MkdirTemp("", "media-ocr-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	args := []string{in, "stdout"}
+	if r.languages != "" {
+		args = append(args, "-l", r.languages)
+	}
+	cmd := exec.CommandContext(ctx, r.path, args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("tesseract failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// ApplyOrientation returns img transformed so that it displays upright,
+// given an EXIF orientation value (1-8).
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = b.Dx()-1-x, y
+			case 3: // rotated 180
+				dx, dy = b.Dx()-1-x, b.Dy()-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, b.Dy()-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = b.Dy()-1-y, x
+			case 7: // transversed
+				dx, dy = b.Dy()-1-y, b.Dx()-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, b.Dx()-1-x
+			}
+			i := src.PixOffset(x, y)
+			j := dst.PixOffset(dx, dy)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,82 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// PDFRenderer rasterizes the first page of PDF documents to PNG using
+// poppler's pdftoppm. When the tool is not installed previews are reported
+// as unsupported.
+type PDFRenderer struct {
+	path  string
+	width int
+}
+
+// NewPDFRenderer looks up pdftoppm at path. Previews are scaled to fit
+// width pixels on their longest side.
+func NewPDFRenderer(path string, width int) *PDFRenderer {
+	r := &PDFRenderer{width: width}
+	if p, err := exec.LookPath(path); err == nil {
+		r.path = p
+	}
+	return r
+}
+
+// Cost estimates the memory pdftoppm needs for a page: the raster of a
+// portrait page at the preview width, with headroom for the document.
+func (r *PDFRenderer) Cost() int64 {
+	return 3 * 4 * int64(r.width) * int64(r.width)
+}
+
+func (r *PDFRenderer) Supported() bool {
+	return r != nil && r.path != ""
+}
+
+// RenderFirstPage writes a PNG of the first page of the PDF read from src
+// to dst.
+func (r *PDFRenderer) RenderFirstPage(ctx context.Context, src io.Reader, dst io.Writer) error {
+	if !r.Supported() {
+		return fmt.Errorf("pdf previews not supported")
+	}
+
+	workDir, err := os.MkdirTemp("", "media-preview-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in.pdf")
+	out := filepath.Join(workDir, "page")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, r.path, "-png", "-f", "1", "-l", "1", "-singlefile",
+		"-scale-to", strconv.Itoa(r.width), in, out)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("pdftoppm failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	result, err := os.Open(out + ".png")
+	if err != nil {
+		return fmt.Errorf("failed to open preview: %w", err)
+	}
+	defer result.Close()
+
+	if _, err := bufpool.Copy(dst, result); err != nil {
+		return fmt.Errorf("failed to copy preview: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ImageInfo describes an image as it will be displayed.
+type ImageInfo struct {
+	Width       int
+	Height      int
+	Orientation int
+	Exif        map[string]string
+	Frames      int // Number of frames; 1 for still images
+}
+
+// Probe reads dimensions and EXIF fields from an encoded image without
+// decoding its pixels. Width and height account for EXIF rotation.
+func Probe(data []byte, contentType string) (ImageInfo, error) {
+	var (
+		width, height int
+		err           error
+	)
+	switch contentType {
+	case "image/webp":
+		width, height, err = webpDimensions(data)
+	case "image/svg+xml":
+		width, height, err = svgDimensions(data)
+	default:
+		var cfg image.Config
+		cfg, _, err = image.DecodeConfig(bytes.NewReader(data))
+		width, height = cfg.Width, cfg.Height
+	}
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	info := ImageInfo{Width: width, Height: height, Orientation: 1, Frames: 1}
+	if contentType == "image/gif" {
+		if info.Frames, err = gifFrames(data); err != nil {
+			return ImageInfo{}, fmt.Errorf("failed to read image header: %w", err)
+		}
+	}
+	if tiff := exifPayload(data, contentType); tiff != nil {
+		info.Orientation, info.Exif = parseExif(tiff)
+	}
+	if info.Orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return info, nil
+}
+
+// exifPayload locates the TIFF-structured EXIF block inside an image.
+func exifPayload(data []byte, contentType string) []byte {
+	switch contentType {
+	case "image/jpeg":
+		i := 2
+		for i+4 <= len(data) && data[i] == 0xFF {
+			marker := data[i+1]
+			if marker == 0xDA || marker == 0xD9 {
+				return nil
+			}
+			length := int(binary.BigEndian.Uint16(data[i+2:]))
+			end := i + 2 + length
+			if length < 2 || end > len(data) {
+				return nil
+			}
+			segment := data[i+4 : end]
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment[6:]
+			}
+			i = end
+		}
+	case "image/png":
+		i := len(pngSignature)
+		for i+8 <= len(data) {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			if i+12+length > len(data) {
+				return nil
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+length]
+			}
+			i += 12 + length
+		}
+	case "image/webp":
+		i := 12
+		for i+8 <= len(data) {
+			size := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if i+8+size > len(data) {
+				return nil
+			}
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:i+8+size], []byte("Exif\x00\x00"))
+			}
+			i += 8 + size + size%2
+		}
+	}
+	return nil
+}
+
+func webpDimensions(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errMalformed
+	}
+
+	switch string(data[12:16]) {
+	case "VP8X":
+		w := int(data[24]) | int(data[25])<<8 | int(data[26])<<16
+		h := int(data[27]) | int(data[28])<<8 | int(data[29])<<16
+		return w + 1, h + 1, nil
+	case "VP8 ":
+		if data[23] != 0x9d || data[24] != 0x01 || data[25] != 0x2a {
+			return 0, 0, errMalformed
+		}
+		w := int(binary.LittleEndian.Uint16(data[26:]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(data[28:]) & 0x3fff)
+		return w, h, nil
+	case "VP8L":
+		if data[20] != 0x2f {
+			return 0, 0, errMalformed
+		}
+		bits := binary.LittleEndian.Uint32(data[21:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	}
+	return 0, 0, errMalformed
+}
+
+// Decodable reports whether images of the given content type can be
+// decoded, edited and re-encoded. GIFs are excluded so animations are
+// always passed through rather than flattened to their first frame.
+func Decodable(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// Verify fully decodes JPEG, PNG and GIF images (every frame) to make sure
+// the pixel data is intact, not just the header. Other formats are
+// accepted as is.
+func Verify(data []byte, contentType string) error {
+	if contentType == "image/gif" {
+		return verifyGIF(data)
+	}
+	if !Decodable(contentType) {
+		return nil
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	return nil
+}
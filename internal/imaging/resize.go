@@ -0,0 +1,92 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Resize scales img to w×h. Each destination pixel is the area-weighted
+// average of the source pixels it covers, which avoids the aliasing of
+// nearest-neighbour sampling when shrinking.
+func Resize(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 || b.Dx() == 0 || b.Dy() == 0 {
+		return dst
+	}
+
+	sx := float64(b.Dx()) / float64(w)
+	sy := float64(b.Dy()) / float64(h)
+	for y := 0; y < h; y++ {
+		y0, y1 := float64(y)*sy, float64(y+1)*sy
+		for x := 0; x < w; x++ {
+			x0, x1 := float64(x)*sx, float64(x+1)*sx
+
+			var r, g, bl, a, total float64
+			for py := int(y0); float64(py) < y1 && py < b.Dy(); py++ {
+				wy := overlap(y0, y1, py)
+				for px := int(x0); float64(px) < x1 && px < b.Dx(); px++ {
+					weight := wy * overlap(x0, x1, px)
+					c := src.RGBAAt(px, py)
+					r += float64(c.R) * weight
+					g += float64(c.G) * weight
+					bl += float64(c.B) * weight
+					a += float64(c.A) * weight
+					total += weight
+				}
+			}
+			if total > 0 {
+				dst.SetRGBA(x, y, color.RGBA{
+					R: uint8(r/total + 0.5),
+					G: uint8(g/total + 0.5),
+					B: uint8(bl/total + 0.5),
+					A: uint8(a/total + 0.5),
+				})
+			}
+		}
+	}
+	return dst
+}
+
+// Fit scales a JPEG or PNG image down so that neither side exceeds
+// maxSide, keeping its aspect ratio, and applies its EXIF orientation.
+// Images that already fit are returned as they are.
+func Fit(data []byte, contentType string, maxSide int) ([]byte, error) {
+	if !Decodable(contentType) {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if cfg.Width <= maxSide && cfg.Height <= maxSide {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if tiff := exifPayload(data, contentType); tiff != nil {
+		img = ApplyOrientation(img, exifOrientation(tiff))
+	}
+	b := img.Bounds()
+	w, h := maxSide, maxSide
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*maxSide/b.Dx())
+	} else {
+		w = max(1, b.Dx()*maxSide/b.Dy())
+	}
+	return encode(Resize(img, w, h), contentType)
+}
+
+// overlap returns how much of pixel p lies within [lo, hi).
+func overlap(lo, hi float64, p int) float64 {
+	return min(hi, float64(p+1)) - max(lo, float64(p))
+}
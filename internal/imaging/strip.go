@@ -0,0 +1,201 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+var errMalformed = errors.New("malformed image")
+
+type StripOptions struct {
+	// PreserveOrientation bakes the EXIF orientation into the pixels before
+	// the tag is removed, so the image keeps displaying upright. WebP images
+	// are stripped without rotation since they cannot be re-encoded.
+	PreserveOrientation bool
+}
+
+// StripMetadata removes EXIF (including GPS and camera serials), XMP, IPTC
+// and textual metadata from an encoded image. Content types it does not
+// understand are returned unchanged.
+func StripMetadata(data []byte, contentType string, opts StripOptions) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data, opts)
+	case "image/png":
+		return stripPNG(data, opts)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte, opts StripOptions) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 1
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		// Skip fill bytes.
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+
+		// Standalone markers carry no length.
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		// Start of scan / end of image: the rest is entropy-coded data.
+		if marker == 0xDA || marker == 0xD9 {
+			out.Write(data[i:])
+			break
+		}
+
+		if i+4 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+		segment := data[i+4 : end]
+
+		switch marker {
+		case 0xE1: // APP1: EXIF or XMP
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(segment[6:])
+			}
+		case 0xED, 0xFE: // APP13 (IPTC/Photoshop), COM
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	if opts.PreserveOrientation && orientation > 1 {
+		return reencode(out.Bytes(), orientation, "image/jpeg")
+	}
+	return out.Bytes(), nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func stripPNG(data []byte, opts StripOptions) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	orientation := 1
+
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		chunkType := string(data[i+4 : i+8])
+
+		switch chunkType {
+		case "eXIf":
+			orientation = exifOrientation(data[i+8 : i+8+length])
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	if opts.PreserveOrientation && orientation > 1 {
+		return reencode(out.Bytes(), orientation, "image/png")
+	}
+	return out.Bytes(), nil
+}
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	i := 12
+	for i+8 <= len(data) {
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			end = len(data)
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
+
+// reencode decodes an image, rotates it upright and encodes it again.
+func reencode(data []byte, orientation int, contentType string) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return encode(ApplyOrientation(img, orientation), contentType)
+}
+
+// encode writes img as PNG, or as JPEG for any other content type.
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
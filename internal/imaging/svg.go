@@ -0,0 +1,422 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+	xmlNamespace   = "http://www.w3.org/XML/1998/namespace"
+)
+
+// svgElements are the SVG elements kept by SanitizeSVG. Anything else,
+// including every element outside the SVG namespace, is removed together
+// with its content.
+var svgElements = setOf(
+	"svg", "g", "defs", "desc", "title", "symbol", "use", "switch", "a", "view", "style",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+	"text", "tspan", "textPath",
+	"linearGradient", "radialGradient", "stop", "pattern", "clipPath", "mask", "marker",
+	"filter", "feBlend", "feColorMatrix", "feComponentTransfer", "feComposite",
+	"feConvolveMatrix", "feDiffuseLighting", "feDisplacementMap", "feDistantLight",
+	"feDropShadow", "feFlood", "feFuncA", "feFuncB", "feFuncG", "feFuncR",
+	"feGaussianBlur", "feImage", "feMerge", "feMergeNode", "feMorphology", "feOffset",
+	"fePointLight", "feSpecularLighting", "feSpotLight", "feTile", "feTurbulence",
+	"animate", "animateMotion", "animateTransform", "set", "mpath",
+)
+
+// svgAttributes are the unprefixed attributes kept by SanitizeSVG. Links
+// are limited to xlink:href and href, which must point into the document.
+var svgAttributes = setOf(
+	// Core and styling.
+	"id", "class", "style", "lang", "tabindex", "systemLanguage", "href",
+	// Geometry and layout.
+	"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "fx", "fy", "fr",
+	"width", "height", "d", "points", "pathLength", "transform", "transform-origin",
+	"viewBox", "preserveAspectRatio", "version", "baseProfile",
+	// Presentation.
+	"alignment-baseline", "baseline-shift", "clip", "clip-path", "clip-rule", "color",
+	"color-interpolation", "color-interpolation-filters", "color-rendering",
+	"direction", "display", "dominant-baseline", "fill", "fill-opacity", "fill-rule",
+	"filter", "flood-color", "flood-opacity", "font-family", "font-size",
+	"font-size-adjust", "font-stretch", "font-style", "font-variant", "font-weight",
+	"image-rendering", "letter-spacing", "lighting-color", "marker-start",
+	"marker-mid", "marker-end", "mask", "opacity", "overflow", "paint-order",
+	"pointer-events", "shape-rendering", "stop-color", "stop-opacity", "stroke",
+	"stroke-dasharray", "stroke-dashoffset", "stroke-linecap", "stroke-linejoin",
+	"stroke-miterlimit", "stroke-opacity", "stroke-width", "text-anchor",
+	"text-decoration", "text-rendering", "unicode-bidi", "vector-effect",
+	"visibility", "word-spacing", "writing-mode",
+	// Gradients, patterns, clipping, masking and markers.
+	"gradientUnits", "gradientTransform", "spreadMethod", "offset",
+	"patternUnits", "patternContentUnits", "patternTransform",
+	"clipPathUnits", "maskUnits", "maskContentUnits",
+	"markerWidth", "markerHeight", "markerUnits", "refX", "refY", "orient",
+	// Text.
+	"dx", "dy", "rotate", "textLength", "lengthAdjust", "startOffset", "method",
+	"spacing", "side",
+	// Filters.
+	"filterUnits", "primitiveUnits", "in", "in2", "result", "stdDeviation", "mode",
+	"operator", "k1", "k2", "k3", "k4", "type", "values", "tableValues", "slope",
+	"intercept", "amplitude", "exponent", "radius", "scale", "xChannelSelector",
+	"yChannelSelector", "baseFrequency", "numOctaves", "seed", "stitchTiles",
+	"surfaceScale", "diffuseConstant", "specularConstant", "specularExponent",
+	"kernelMatrix", "kernelUnitLength", "order", "divisor", "bias", "targetX",
+	"targetY", "edgeMode", "preserveAlpha", "azimuth", "elevation", "pointsAtX",
+	"pointsAtY", "pointsAtZ", "limitingConeAngle",
+	// Animation.
+	"attributeName", "attributeType", "begin", "dur", "end", "min", "max", "restart",
+	"repeatCount", "repeatDur", "calcMode", "keyTimes", "keySplines", "keyPoints",
+	"from", "to", "by", "additive", "accumulate", "path",
+)
+
+// svgAnimationElements can rewrite attributes of other elements, so they
+// are dropped unless they target an attribute that is kept and not a link.
+var svgAnimationElements = setOf("set", "animate", "animateMotion", "animateTransform")
+
+func setOf(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return set
+}
+
+var (
+	cssComment = regexp.MustCompile(`(?s)/\*.*?(\*/|$)`)
+	cssImport  = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssURL     = regexp.MustCompile(`(?i)url\(\s*(['"]?)\s*([^'")]*?)\s*(['"]?)\s*\)`)
+	cssScript  = regexp.MustCompile(`(?i)expression\s*\(|j\s*a\s*v\s*a\s*s\s*c\s*r\s*i\s*p\s*t\s*:|-moz-binding|behavior\s*:`)
+
+	// These image functions load a bare string as a URL, which the url()
+	// pattern cannot see. Renaming them makes the declaration invalid.
+	cssImageFunc = regexp.MustCompile(`(?i)(^|[^\w-])(?:-webkit-)?(?:image-set|image|cross-fade)\s*\(`)
+
+	// Unlike xml.EscapeText, this keeps line breaks in text readable.
+	svgTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// svgFrame is an open element of the input with the namespace prefixes
+// bound in its scope.
+type svgFrame struct {
+	name       xml.Name
+	namespaces map[string]string
+}
+
+// SanitizeSVG rewrites an SVG document keeping only an allowlist of inert
+// SVG elements and attributes: scripts, elements of other namespaces,
+// event handlers, comments, processing instructions and DOCTYPE
+// declarations are removed, and links or CSS url() references that leave
+// the document are dropped. Only same-document "#fragment" references
+// survive. The output declares the SVG namespace as the default and uses
+// no other prefixes than xlink and xml.
+func SanitizeSVG(data []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+
+	var (
+		out   bytes.Buffer
+		stack []svgFrame
+		skip  int // depth inside a dropped element
+		root  bool
+	)
+	out.WriteString(xml.Header)
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse svg: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			namespaces := map[string]string{"xml": xmlNamespace}
+			if len(stack) > 0 {
+				namespaces = stack[len(stack)-1].namespaces
+			}
+			namespaces = bindNamespaces(namespaces, t.Attr)
+			stack = append(stack, svgFrame{name: t.Name, namespaces: namespaces})
+
+			keep := skip == 0 && svgElement(t, namespaces)
+			if len(stack) == 1 {
+				if root || !keep || t.Name.Local != "svg" {
+					return nil, fmt.Errorf("failed to parse svg: root element is not <svg>")
+				}
+				root = true
+			}
+			if !keep {
+				skip++
+				continue
+			}
+			writeSVGStart(&out, t, namespaces, len(stack) == 1)
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1].name != t.Name {
+				return nil, fmt.Errorf("failed to parse svg: unexpected </%s>", qualifiedName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+			if skip > 0 {
+				skip--
+				continue
+			}
+			out.WriteString("</" + t.Name.Local + ">")
+		case xml.CharData:
+			if skip > 0 || len(stack) == 0 {
+				continue
+			}
+			text := string(t)
+			if stack[len(stack)-1].name.Local == "style" {
+				text = sanitizeCSS(text)
+			}
+			svgTextEscaper.WriteString(&out, text)
+		}
+		// Comments, processing instructions and directives are dropped.
+	}
+	if !root || len(stack) != 0 {
+		return nil, fmt.Errorf("failed to parse svg: document is incomplete")
+	}
+	return out.Bytes(), nil
+}
+
+// bindNamespaces returns the prefixes in scope of an element with attrs,
+// copying parent only if the element declares namespaces of its own. The
+// default namespace is bound to "".
+func bindNamespaces(parent map[string]string, attrs []xml.Attr) map[string]string {
+	scope, copied := parent, false
+	for _, a := range attrs {
+		var prefix string
+		switch {
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+		case a.Name.Space == "xmlns":
+			prefix = a.Name.Local
+		default:
+			continue
+		}
+		if !copied {
+			scope = make(map[string]string, len(parent)+1)
+			for k, v := range parent {
+				scope[k] = v
+			}
+			copied = true
+		}
+		scope[prefix] = strings.TrimSpace(a.Value)
+	}
+	return scope
+}
+
+// resolveNamespace returns the namespace bound to prefix. Unprefixed
+// elements of a document that declares no default namespace are taken as
+// SVG, as browsers rendering the file inline do.
+func resolveNamespace(namespaces map[string]string, prefix string) string {
+	ns, ok := namespaces[prefix]
+	if !ok && prefix == "" {
+		return svgNamespace
+	}
+	return ns
+}
+
+func svgElement(t xml.StartElement, namespaces map[string]string) bool {
+	if resolveNamespace(namespaces, t.Name.Space) != svgNamespace || !svgElements[t.Name.Local] {
+		return false
+	}
+	if svgAnimationElements[t.Name.Local] {
+		for _, a := range t.Attr {
+			if a.Name.Space == "" && a.Name.Local == "attributeName" {
+				target := strings.TrimSpace(a.Value)
+				if target == "href" || !svgAttributes[target] {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// svgAttribute returns the name under which a is written, or false if it
+// is dropped.
+func svgAttribute(a xml.Attr, namespaces map[string]string) (string, bool) {
+	switch {
+	case a.Name.Space == "":
+		return a.Name.Local, svgAttributes[a.Name.Local]
+	case a.Name.Space == "xmlns":
+		return "", false
+	}
+	switch resolveNamespace(namespaces, a.Name.Space) {
+	case xlinkNamespace:
+		return "xlink:" + a.Name.Local, a.Name.Local == "href" || a.Name.Local == "title"
+	case xmlNamespace:
+		return "xml:" + a.Name.Local, a.Name.Local == "space" || a.Name.Local == "lang"
+	}
+	return "", false
+}
+
+func writeSVGStart(out *bytes.Buffer, t xml.StartElement, namespaces map[string]string, root bool) {
+	out.WriteString("<" + t.Name.Local)
+	if root {
+		out.WriteString(` xmlns="` + svgNamespace + `" xmlns:xlink="` + xlinkNamespace + `"`)
+	}
+	written := make(map[string]bool, len(t.Attr))
+	for _, a := range t.Attr {
+		name, ok := svgAttribute(a, namespaces)
+		if !ok || written[name] {
+			continue
+		}
+		value := a.Value
+		if a.Name.Local == "href" {
+			if !strings.HasPrefix(strings.TrimSpace(value), "#") {
+				continue
+			}
+		} else {
+			value = sanitizeCSS(value)
+		}
+		written[name] = true
+		out.WriteString(" " + name + `="`)
+		xml.EscapeText(out, []byte(value))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+}
+
+// sanitizeCSS removes @import rules and script-capable constructs and
+// neutralizes url() references to anything but a fragment of the document.
+// Escapes and comments are resolved first, so that e.g. u\72l( cannot
+// hide a url() from the patterns; the result contains no backslashes.
+func sanitizeCSS(s string) string {
+	if strings.ContainsRune(s, '\\') {
+		s = unescapeCSS(s)
+	}
+	s = cssComment.ReplaceAllString(s, "")
+	s = cssImport.ReplaceAllString(s, "")
+	s = cssScript.ReplaceAllString(s, "")
+	s = cssImageFunc.ReplaceAllString(s, "${1}invalid(")
+	return cssURL.ReplaceAllStringFunc(s, func(m string) string {
+		ref := cssURL.FindStringSubmatch(m)[2]
+		if strings.HasPrefix(ref, "#") {
+			return m
+		}
+		return "none"
+	})
+}
+
+// unescapeCSS replaces CSS escapes with the characters they stand for: a
+// backslash followed by up to six hex digits and an optional whitespace, or
+// by any other character. Escaped line breaks and a trailing backslash are
+// removed.
+func unescapeCSS(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			break
+		}
+		j := i
+		for j < len(s) && j-i < 6 && isHexDigit(s[j]) {
+			j++
+		}
+		if j == i {
+			if s[i] == '\n' || s[i] == '\f' {
+				continue
+			}
+			if s[i] == '\r' {
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+				continue
+			}
+			// Any other character, which may be the first byte of a
+			// multi-byte one, stands for itself.
+			b.WriteByte(s[i])
+			continue
+		}
+		r, _ := strconv.ParseUint(s[i:j], 16, 32)
+		if r == 0 || r > utf8.MaxRune || utf16.IsSurrogate(rune(r)) {
+			r = utf8.RuneError
+		}
+		b.WriteRune(rune(r))
+		if j < len(s) && (s[j] == ' ' || s[j] == '\t' || s[j] == '\n' || s[j] == '\f') {
+			j++
+		} else if j < len(s) && s[j] == '\r' {
+			j++
+			if j < len(s) && s[j] == '\n' {
+				j++
+			}
+		}
+		i = j - 1
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// svgDimensions reads the size of an SVG from the width and height of its
+// root element, falling back to the viewBox. Relative units such as
+// percentages give no size, which is reported as 0x0.
+func svgDimensions(data []byte) (int, int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse svg: %w", err)
+		}
+		t, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !strings.EqualFold(t.Name.Local, "svg") {
+			return 0, 0, fmt.Errorf("failed to parse svg: root element is not <svg>")
+		}
+
+		var width, height float64
+		var viewBox []string
+		for _, a := range t.Attr {
+			switch a.Name.Local {
+			case "width":
+				width = svgLength(a.Value)
+			case "height":
+				height = svgLength(a.Value)
+			case "viewBox":
+				viewBox = strings.Fields(strings.ReplaceAll(a.Value, ",", " "))
+			}
+		}
+		if (width == 0 || height == 0) && len(viewBox) == 4 {
+			width = svgLength(viewBox[2])
+			height = svgLength(viewBox[3])
+		}
+		return int(width + 0.5), int(height + 0.5), nil
+	}
+}
+
+func svgLength(s string) float64 {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return f
+}
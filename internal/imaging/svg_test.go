@@ -0,0 +1,160 @@
+package imaging
+
+import (
+	"strings"
+	"testing"
+)
+
+const svgOpen = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="10" height="10">`
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		drop  []string // must not appear in the output
+		keep  []string // must appear in the output
+	}{
+		{
+			name:  "script",
+			input: svgOpen + `<script>alert(1)</script><SCRIPT>alert(2)</SCRIPT><rect width="1"/></svg>`,
+			drop:  []string{"script", "SCRIPT", "alert"},
+			keep:  []string{`<rect width="1">`},
+		},
+		{
+			name:  "event handlers",
+			input: svgOpen + `<rect onload="alert(1)" onclick="alert(2)" fill="red"/></svg>`,
+			drop:  []string{"onload", "onclick", "alert"},
+			keep:  []string{`<rect fill="red">`},
+		},
+		{
+			name:  "XHTML form with an entity in javascript:",
+			input: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:h="http://www.w3.org/1999/xhtml"><h:form action="java&#9;script:alert(1)"><h:button formaction="javascript:alert(2)">go</h:button></h:form></svg>`,
+			drop:  []string{"form", "button", "action", "script", "alert", "go"},
+		},
+		{
+			name:  "default namespace switched to XHTML",
+			input: svgOpen + `<g xmlns="http://www.w3.org/1999/xhtml"><iframe src="https://evil.test/"/><a href="javascript:alert(1)">x</a></g></svg>`,
+			drop:  []string{"iframe", "evil", "alert", "<g"},
+		},
+		{
+			name:  "foreignObject",
+			input: svgOpen + `<foreignObject><body xmlns="http://www.w3.org/1999/xhtml"><img src="https://evil.test/x.png"/></body></foreignObject></svg>`,
+			drop:  []string{"foreignObject", "body", "img", "evil"},
+		},
+		{
+			name:  "unknown SVG elements",
+			input: svgOpen + `<handler>alert(1)</handler><listener event="load"/><image href="https://evil.test/x.png"/></svg>`,
+			drop:  []string{"handler", "listener", "image", "evil", "alert"},
+		},
+		{
+			name:  "external links",
+			input: svgOpen + `<a xlink:href="javascript:alert(1)"><use href="https://evil.test/x.svg#a"/><use xlink:href=" #ok "/></a></svg>`,
+			drop:  []string{"javascript", "evil"},
+			keep:  []string{`<a>`, `<use xlink:href=" #ok ">`},
+		},
+		{
+			name:  "xlink under another prefix",
+			input: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:l="http://www.w3.org/1999/xlink"><use l:href="https://evil.test/x.svg#a"/><use l:href="#ok"/></svg>`,
+			drop:  []string{"evil", "l:href"},
+			keep:  []string{`<use xlink:href="#ok">`},
+		},
+		{
+			name:  "feImage",
+			input: svgOpen + `<filter id="f"><feImage href="https://evil.test/x.png"/></filter></svg>`,
+			drop:  []string{"evil"},
+			keep:  []string{"<feImage>"},
+		},
+		{
+			name:  "set with a padded attributeName",
+			input: svgOpen + `<a><set attributeName=" xlink:href " to="javascript:alert(1)"/><set attributeName="href" to="javascript:alert(2)"/></a></svg>`,
+			drop:  []string{"<set", "javascript", "alert"},
+		},
+		{
+			name:  "animate of an event handler",
+			input: svgOpen + `<rect><animate attributeName="onbegin" values="alert(1)"/><animate attributeName="fill" values="red;blue" dur="1s"/></rect></svg>`,
+			drop:  []string{"onbegin", "alert"},
+			keep:  []string{`<animate attributeName="fill" values="red;blue" dur="1s">`},
+		},
+		{
+			name:  "CSS image-set",
+			input: svgOpen + `<style>rect{background:image-set("https://evil.test/x.png" 1x)} g{background:-webkit-image-set('https://evil.test/y.png' 1x)}</style></svg>`,
+			drop:  []string{"image-set("},
+		},
+		{
+			name:  "CSS image and cross-fade",
+			input: svgOpen + `<rect style="background:image('https://evil.test/x.png');mask:cross-fade('https://evil.test/y.png', 'https://evil.test/z.png')"/></svg>`,
+			drop:  []string{"image(", "cross-fade("},
+		},
+		{
+			name:  "CSS import and url",
+			input: svgOpen + `<style><![CDATA[@import "https://evil.test/a.css"; rect{fill:url(https://evil.test/b.svg#p)} circle{fill:url(#grad)}]]></style></svg>`,
+			drop:  []string{"@import", "a.css", "b.svg"},
+			keep:  []string{"url(#grad)"},
+		},
+		{
+			name:  "CSS escapes",
+			input: svgOpen + `<style>@im\70ort "https://evil.test/a.css";</style><rect style="fill:u\72l(https://evil.test/b.svg)"/></svg>`,
+			drop:  []string{"evil"},
+		},
+		{
+			name:  "CSS javascript with whitespace",
+			input: svgOpen + `<rect style="fill:url(java&#9;script:alert(1))"/></svg>`,
+			drop:  []string{"alert"},
+		},
+		{
+			name:  "prefixed SVG",
+			input: `<s:svg xmlns:s="http://www.w3.org/2000/svg"><s:rect width="1"/><s:script>alert(1)</s:script></s:svg>`,
+			drop:  []string{"<s:", "script"},
+			keep:  []string{`<rect width="1">`},
+		},
+		{
+			name:  "no namespace declaration",
+			input: `<svg viewBox="0 0 1 1"><!-- comment --><title>a &lt; b</title><path d="M0 0h1"/></svg>`,
+			drop:  []string{"comment"},
+			keep:  []string{`<svg xmlns="http://www.w3.org/2000/svg"`, `<title>a &lt; b</title>`, `<path d="M0 0h1">`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := SanitizeSVG([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("SanitizeSVG: %v", err)
+			}
+			got := string(out)
+			for _, s := range tt.drop {
+				if strings.Contains(got, s) {
+					t.Errorf("output contains %q:\n%s", s, got)
+				}
+			}
+			for _, s := range tt.keep {
+				if !strings.Contains(got, s) {
+					t.Errorf("output lacks %q:\n%s", s, got)
+				}
+			}
+
+			// The output is itself a document the sanitizer leaves as is.
+			again, err := SanitizeSVG(out)
+			if err != nil {
+				t.Fatalf("SanitizeSVG of the output: %v", err)
+			}
+			if string(again) != got {
+				t.Errorf("output changes when sanitized again:\n%s\n%s", got, again)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGRejects(t *testing.T) {
+	for _, input := range []string{
+		`<html><body/></html>`,
+		`<svg xmlns="http://www.w3.org/1999/xhtml"/>`,
+		`<x:svg xmlns:x="urn:other"/>`,
+		`<svg xmlns="http://www.w3.org/2000/svg"><rect>`,
+		`<svg xmlns="http://www.w3.org/2000/svg"/><svg xmlns="http://www.w3.org/2000/svg"/>`,
+		`<!DOCTYPE svg [<!ENTITY x "y">]><svg xmlns="http://www.w3.org/2000/svg">&x;</svg>`,
+	} {
+		if _, err := SanitizeSVG([]byte(input)); err == nil {
+			t.Errorf("SanitizeSVG(%q) succeeded", input)
+		}
+	}
+}
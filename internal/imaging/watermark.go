@@ -0,0 +1,138 @@
+package imaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+)
+
+// Position is the corner (or center) a watermark is anchored to.
+type Position string
+
+const (
+	PositionTopLeft     Position = "top-left"
+	PositionTopRight    Position = "top-right"
+	PositionBottomLeft  Position = "bottom-left"
+	PositionBottomRight Position = "bottom-right"
+	PositionCenter      Position = "center"
+)
+
+func ParsePosition(s string) (Position, bool) {
+	switch p := Position(s); p {
+	case PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight, PositionCenter:
+		return p, true
+	}
+	return "", false
+}
+
+// watermarkMaxShare limits the watermark to this fraction of the image
+// width; larger marks are scaled down.
+const watermarkMaxShare = 0.25
+
+// Watermark is a PNG overlay blended onto served images.
+type Watermark struct {
+	img      image.Image
+	position Position
+	opacity  float64
+	key      string
+}
+
+// LoadWatermark reads the PNG at path. opacity is clamped to 0..1.
+func LoadWatermark(path string, position Position, opacity float64) (*Watermark, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watermark: %w", err)
+	}
+	return NewWatermark(data, position, opacity)
+}
+
+// NewWatermark builds a watermark from PNG data, e.g. a logo stored in the
+// service. opacity is clamped to 0..1.
+func NewWatermark(data []byte, position Position, opacity float64) (*Watermark, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode watermark: %w", err)
+	}
+	opacity = min(max(opacity, 0), 1)
+
+	sum := sha256.New()
+	sum.Write(data)
+	fmt.Fprintf(sum, "|%s|%g", position, opacity)
+
+	return &Watermark{
+		img:      img,
+		position: position,
+		opacity:  opacity,
+		key:      hex.EncodeToString(sum.Sum(nil))[:12],
+	}, nil
+}
+
+// Key identifies the watermark image and settings, so cached renditions
+// are not reused after the watermark changes.
+func (w *Watermark) Key() string {
+	return w.key
+}
+
+// Cost estimates the memory Apply needs for an image of the given size:
+// the decoded source, its upright copy and the output canvas.
+func (w *Watermark) Cost(width, height int) int64 {
+	return 3 * 4 * int64(width) * int64(height)
+}
+
+// Apply decodes a JPEG or PNG image, blends the watermark onto it and
+// re-encodes it in the same format.
+func (w *Watermark) Apply(data []byte, contentType string) ([]byte, error) {
+	if !Decodable(contentType) {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	// The output carries no EXIF, so bake in the rotation first.
+	if tiff := exifPayload(data, contentType); tiff != nil {
+		src = ApplyOrientation(src, exifOrientation(tiff))
+	}
+
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	mark := w.img
+	mb := mark.Bounds()
+	if maxW := int(float64(b.Dx()) * watermarkMaxShare); mb.Dx() > maxW && maxW > 0 {
+		h := max(1, mb.Dy()*maxW/mb.Dx())
+		mark = Resize(mark, maxW, h)
+		mb = mark.Bounds()
+	}
+
+	margin := max(b.Dx(), b.Dy()) / 50
+	rect := w.place(dst.Bounds(), mb.Dx(), mb.Dy(), margin)
+	mask := image.NewUniform(color.Alpha{A: uint8(w.opacity*255 + 0.5)})
+	draw.DrawMask(dst, rect, mark, mb.Min, mask, image.Point{}, draw.Over)
+
+	return encode(dst, contentType)
+}
+
+func (w *Watermark) place(bounds image.Rectangle, mw, mh, margin int) image.Rectangle {
+	var x, y int
+	switch w.position {
+	case PositionTopLeft:
+		x, y = margin, margin
+	case PositionTopRight:
+		x, y = bounds.Dx()-mw-margin, margin
+	case PositionBottomLeft:
+		x, y = margin, bounds.Dy()-mh-margin
+	case PositionCenter:
+		x, y = (bounds.Dx()-mw)/2, (bounds.Dy()-mh)/2
+	default:
+		x, y = bounds.Dx()-mw-margin, bounds.Dy()-mh-margin
+	}
+	return image.Rect(x, y, x+mw, y+mh)
+}
@@ -0,0 +1,199 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+var (
+	gcOrphans = metrics.NewCounter("media_gc_orphaned_blobs_total",
+		"Blobs without metadata found by the garbage collector.", "action")
+	gcReclaimed = metrics.NewCounter("media_gc_reclaimed_bytes_total",
+		"Bytes freed by removing blobs without metadata.")
+	gcMissing = metrics.NewGauge("media_gc_missing_blobs",
+		"Files whose metadata has no blob, as of the last collection.")
+	lastGC = metrics.NewGauge("media_gc_last_run_timestamp_seconds",
+		"Unix time of the last completed garbage collection.")
+)
+
+// ErrCollecting is returned by Collect while another collection runs.
+var ErrCollecting = errors.New("garbage collection already running")
+
+// Lister is implemented by storage backends that can enumerate the
+// originals they store.
+type Lister interface {
+	List(ctx context.Context, fn func(storage.FileInfo) error) error
+}
+
+type GCConfig struct {
+	// MinAge protects recent blobs, whose metadata may not be stored yet
+	// while their upload is processed.
+	MinAge time.Duration
+
+	// DryRun reports orphaned blobs without removing them.
+	DryRun bool
+}
+
+type OrphanedBlob struct {
+	FileID    string    `json:"fileId"`
+	Directory string    `json:"directory"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	Removed   bool      `json:"removed"`
+}
+
+// GCReport is the outcome of a collection. Missing lists the files whose
+// metadata has no blob; they are left for operators to restore or delete.
+type GCReport struct {
+	StartedAt      time.Time      `json:"startedAt"`
+	FinishedAt     time.Time      `json:"finishedAt"`
+	DryRun         bool           `json:"dryRun"`
+	Blobs          int            `json:"blobs"`
+	Records        int            `json:"records"`
+	Orphaned       []OrphanedBlob `json:"orphaned"`
+	ReclaimedBytes int64          `json:"reclaimedBytes"`
+	Missing        []string       `json:"missing"`
+}
+
+// Collector reconciles storage with metadata: blobs no file refers to,
+// left behind by failed uploads or deletes, are removed, and files whose
+// blob is gone are reported.
+type Collector struct {
+	storage  storage.Storage
+	lister   Lister
+	metadata metadata.Store
+	cfg      GCConfig
+	logger   *slog.Logger
+
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *GCReport
+}
+
+// NewCollector collects the blobs of s as enumerated by lister, usually
+// the backend s wraps. lister may be nil, in which case collecting is not
+// supported.
+func NewCollector(s storage.Storage, lister Lister, metadata metadata.Store, cfg GCConfig, logger *slog.Logger) *Collector {
+	return &Collector{
+		storage:  s,
+		lister:   lister,
+		metadata: metadata,
+		cfg:      cfg,
+		logger:   logger,
+	}
+}
+
+func (c *Collector) Supported() bool {
+	return c.lister != nil
+}
+
+// Collect reconciles storage with metadata once. With dryRun set, or the
+// collector configured for dry runs, orphaned blobs are only reported.
+func (c *Collector) Collect(ctx context.Context, dryRun bool) (GCReport, error) {
+	if !c.Supported() {
+		return GCReport{}, errors.New("storage backend cannot list its blobs")
+	}
+	if !c.running.TryLock() {
+		return GCReport{}, ErrCollecting
+	}
+	defer c.running.Unlock()
+	report := GCReport{
+		StartedAt: time.Now().UTC(),
+		DryRun:    dryRun || c.cfg.DryRun,
+		Orphaned:  []OrphanedBlob{},
+		Missing:   []string{},
+	}
+
+	// Metadata is listed first: a blob saved after this is younger than
+	// MinAge, so an upload in flight is never taken for an orphan.
+	files, err := c.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return GCReport{}, err
+	}
+	known := make(map[string]bool, len(files))
+	for _, f := range files {
+		known[f.ID] = false
+	}
+	report.Records = len(files)
+
+	var orphans []OrphanedBlob
+	cutoff := report.StartedAt.Add(-c.cfg.MinAge)
+	err = c.lister.List(ctx, func(info storage.FileInfo) error {
+		report.Blobs++
+		if _, ok := known[info.ID]; ok {
+			known[info.ID] = true
+			return nil
+		}
+		if info.CreatedAt.After(cutoff) {
+			return nil
+		}
+		orphans = append(orphans, OrphanedBlob{FileID: info.ID, Directory: info.Directory, Size: info.Size, CreatedAt: info.CreatedAt})
+		return nil
+	})
+	if err != nil {
+		return GCReport{}, err
+	}
+
+	if len(files) == 0 && len(orphans) > 0 && !report.DryRun {
+		// More likely a misconfigured metadata store than an empty one.
+		c.logger.Warn("No file metadata found, orphaned blobs are kept", "orphaned", len(orphans))
+		report.DryRun = true
+	}
+	for i := range orphans {
+		o := &orphans[i]
+		if !report.DryRun {
+			// A file stored since it was listed is not an orphan.
+			if _, err := c.metadata.Get(ctx, o.FileID); !errors.Is(err, metadata.ErrNotFound) {
+				continue
+			}
+			if err := c.storage.Delete(ctx, o.FileID); err != nil {
+				c.logger.Warn("Failed to remove orphaned blob", "fileId", o.FileID, "error", err)
+				continue
+			}
+			o.Removed = true
+			report.ReclaimedBytes += o.Size
+			gcReclaimed.Add(float64(o.Size))
+			gcOrphans.Inc("removed")
+		} else {
+			gcOrphans.Inc("found")
+		}
+		report.Orphaned = append(report.Orphaned, *o)
+	}
+
+	for _, f := range files {
+		if !known[f.ID] {
+			report.Missing = append(report.Missing, f.ID)
+		}
+	}
+	if len(report.Missing) > 0 {
+		c.logger.Warn("Files have no blob", "count", len(report.Missing))
+	}
+	gcMissing.Set(float64(len(report.Missing)))
+
+	report.FinishedAt = time.Now().UTC()
+	lastGC.Set(float64(report.FinishedAt.Unix()))
+	c.logger.Info("Garbage collection finished", "blobs", report.Blobs, "orphaned", len(report.Orphaned),
+		"reclaimedBytes", report.ReclaimedBytes, "missing", len(report.Missing), "dryRun", report.DryRun)
+
+	c.mu.Lock()
+	c.last = &report
+	c.mu.Unlock()
+	return report, nil
+}
+
+// Last returns the report of the latest collection, if any ran.
+func (c *Collector) Last() (GCReport, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return GCReport{}, false
+	}
+	return *c.last, true
+}
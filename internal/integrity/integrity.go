@@ -0,0 +1,285 @@
+package integrity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/checksum"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+const SignatureHeader = "X-Manifest-Signature"
+
+var (
+	ErrEmptyCollection = errors.New("collection has no files")
+	// ErrNoSigningKey is returned for manifests requested while no signing
+	// key is configured, rather than serving them unsigned.
+	ErrNoSigningKey = errors.New("no manifest signing key configured")
+)
+
+// Issue kinds reported by an audit.
+const (
+	IssueMissing          = "missing"
+	IssueSizeMismatch     = "size_mismatch"
+	IssueChecksumMismatch = "checksum_mismatch"
+	IssueNoChecksum       = "no_checksum"
+)
+
+var (
+	auditFailures = metrics.NewCounter("media_integrity_failures_total",
+		"Files that failed an integrity audit.", "issue")
+	lastAudit = metrics.NewGauge("media_integrity_last_audit_timestamp_seconds",
+		"Unix time of the last completed collection audit.")
+)
+
+type ManifestEntry struct {
+	FileID      string            `json:"fileId"`
+	Size        int64             `json:"size"`
+	Checksums   map[string]string `json:"checksums,omitempty"`
+	AltText     string            `json:"altText,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Credit      string            `json:"credit,omitempty"`
+	License     string            `json:"license,omitempty"`
+
+	RightsHolder     string     `json:"rightsHolder,omitempty"`
+	LicenseExpiresAt *time.Time `json:"licenseExpiresAt,omitempty"`
+	AvailableFrom    *time.Time `json:"availableFrom,omitempty"`
+	AvailableUntil   *time.Time `json:"availableUntil,omitempty"`
+}
+
+type Manifest struct {
+	Collection  string          `json:"collection"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Files       []ManifestEntry `json:"files"`
+}
+
+type Issue struct {
+	FileID    string `json:"fileId"`
+	Issue     string `json:"issue"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Expected  string `json:"expected,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+}
+
+type Report struct {
+	Collection string    `json:"collection"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Checked    int       `json:"checked"`
+	Issues     []Issue   `json:"issues"`
+}
+
+func (r Report) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Auditor builds collection manifests and re-hashes stored blobs against
+// the checksums recorded at upload time, so archival collections can be
+// checked for bit rot.
+type Auditor struct {
+	storage  storage.Storage
+	metadata metadata.Store
+	key      []byte
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	reports map[string]Report
+}
+
+func NewAuditor(storage storage.Storage, metadata metadata.Store, signingKey string, logger *slog.Logger) *Auditor {
+	return &Auditor{
+		storage:  storage,
+		metadata: metadata,
+		key:      []byte(signingKey),
+		logger:   logger,
+		reports:  make(map[string]Report),
+	}
+}
+
+// Manifest lists the files of collection include reports true for, which
+// limits it to the files a caller may see; nil includes all of them.
+// Collections without such files are empty.
+func (a *Auditor) Manifest(ctx context.Context, collection string, include func(domain.FileMetadata) bool) (Manifest, error) {
+	files, err := a.files(ctx, collection)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if include != nil {
+		files = slices.DeleteFunc(files, func(f domain.FileMetadata) bool { return !include(f) })
+	}
+	if len(files) == 0 {
+		return Manifest{}, ErrEmptyCollection
+	}
+
+	m := Manifest{
+		Collection:  collection,
+		GeneratedAt: time.Now().UTC(),
+		Files:       make([]ManifestEntry, 0, len(files)),
+	}
+	for _, f := range files {
+		m.Files = append(m.Files, ManifestEntry{
+			FileID:      f.ID,
+			Size:        f.Size,
+			Checksums:   f.Checksums,
+			AltText:     f.AltText,
+			Description: f.Description,
+			Credit:      f.Credit,
+			License:     f.License,
+
+			RightsHolder:     f.RightsHolder,
+			LicenseExpiresAt: f.LicenseExpiresAt,
+			AvailableFrom:    f.AvailableFrom,
+			AvailableUntil:   f.AvailableUntil,
+		})
+	}
+	return m, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body, or ErrNoSigningKey if no key
+// is configured.
+func (a *Auditor) Sign(body []byte) (string, error) {
+	if len(a.key) == 0 {
+		return "", ErrNoSigningKey
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Audit re-reads every file of collection from storage and compares size
+// and checksum with the recorded metadata.
+func (a *Auditor) Audit(ctx context.Context, collection string) (Report, error) {
+	files, err := a.files(ctx, collection)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Collection: collection, StartedAt: time.Now().UTC(), Issues: []Issue{}}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return Report{}, err
+		}
+		if issue, ok := verify(ctx, a.storage, f); !ok {
+			report.Issues = append(report.Issues, issue)
+			auditFailures.Inc(issue.Issue)
+		}
+		report.Checked++
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	a.mu.Lock()
+	a.reports[collection] = report
+	a.mu.Unlock()
+	lastAudit.Set(float64(report.FinishedAt.Unix()))
+
+	if !report.OK() {
+		a.logger.Warn("Collection failed integrity audit", "collection", collection, "issues", len(report.Issues))
+	}
+	return report, nil
+}
+
+// LastReport returns the most recent audit of collection, if any.
+func (a *Auditor) LastReport(collection string) (Report, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.reports[collection]
+	return r, ok
+}
+
+// AuditAll audits every collection once. Collections failing their audit
+// are logged and do not stop the others.
+func (a *Auditor) AuditAll(ctx context.Context) error {
+	files, err := a.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return fmt.Errorf("failed to list files for integrity audit: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if f.Collection == "" || seen[f.Collection] {
+			continue
+		}
+		seen[f.Collection] = true
+		if _, err := a.Audit(ctx, f.Collection); err != nil {
+			a.logger.Error("Integrity audit failed", "collection", f.Collection, "error", err)
+		}
+	}
+	return nil
+}
+
+func (a *Auditor) files(ctx context.Context, collection string) ([]domain.FileMetadata, error) {
+	files, err := a.metadata.List(ctx, metadata.Filter{Collection: collection})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrEmptyCollection
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files, nil
+}
+
+// verify re-reads the blob of f and compares it with the recorded size and
+// checksums.
+func verify(ctx context.Context, st storage.Storage, f domain.FileMetadata) (Issue, bool) {
+	rc, _, err := st.Open(ctx, f.ID)
+	if err != nil {
+		return Issue{FileID: f.ID, Issue: IssueMissing}, false
+	}
+	defer rc.Close()
+
+	h := recordedHasher(f)
+	size, err := bufpool.Copy(h, rc)
+	if err != nil {
+		return Issue{FileID: f.ID, Issue: IssueMissing, Actual: err.Error()}, false
+	}
+	return compare(f, size, h.Sums())
+}
+
+// recordedHasher hashes with every supported algorithm f has a checksum
+// recorded for.
+func recordedHasher(f domain.FileMetadata) *checksum.Multi {
+	algorithms := make([]string, 0, len(f.Checksums))
+	for a := range f.Checksums {
+		if checksum.Supported(a) {
+			algorithms = append(algorithms, a)
+		}
+	}
+	h, _ := checksum.NewMulti(algorithms)
+	return h
+}
+
+// compare checks size and sums, computed with recordedHasher, against what
+// was recorded for f.
+func compare(f domain.FileMetadata, size int64, sums map[string]string) (Issue, bool) {
+	if size != f.Size {
+		return Issue{FileID: f.ID, Issue: IssueSizeMismatch, Expected: fmt.Sprint(f.Size), Actual: fmt.Sprint(size)}, false
+	}
+	if len(sums) == 0 {
+		return Issue{FileID: f.ID, Issue: IssueNoChecksum}, false
+	}
+
+	algorithms := make([]string, 0, len(sums))
+	for a := range sums {
+		algorithms = append(algorithms, a)
+	}
+	sort.Strings(algorithms)
+	for _, a := range algorithms {
+		if expected := f.Checksums[a]; sums[a] != expected {
+			return Issue{FileID: f.ID, Issue: IssueChecksumMismatch, Algorithm: a, Expected: expected, Actual: sums[a]}, false
+		}
+	}
+	return Issue{}, true
+}
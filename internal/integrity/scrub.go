@@ -0,0 +1,259 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/checksum"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+var (
+	scrubChecked = metrics.NewCounter("media_scrub_checked_total",
+		"Blobs verified by the background scrubber.", "result")
+	scrubBytes = metrics.NewCounter("media_scrub_bytes_read_total",
+		"Bytes re-read by the background scrubber.")
+)
+
+// Restorer is implemented by storage backends that can overwrite the
+// content of a blob, or write it anew in directory if it is missing. A
+// read error from r leaves the blob as it was.
+type Restorer interface {
+	Restore(ctx context.Context, id, directory string, r io.Reader) error
+}
+
+type ScrubConfig struct {
+	// BytesPerSecond throttles reads so scrubbing does not compete with
+	// serving traffic. Zero means unthrottled.
+	BytesPerSecond int64
+}
+
+type CorruptFile struct {
+	Issue
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+type ScrubStatus struct {
+	Passes     int           `json:"passes"`
+	LastPassAt *time.Time    `json:"lastPassAt,omitempty"`
+	Checked    int           `json:"checked"`
+	Repaired   int           `json:"repaired"`
+	Corrupt    []CorruptFile `json:"corrupt"`
+}
+
+// Scrubber slowly re-reads every stored blob and compares it with the
+// checksum recorded at upload. Corrupt blobs are restored from the replica
+// when it holds an intact copy and are otherwise reported until fixed.
+type Scrubber struct {
+	storage  storage.Storage
+	replica  storage.Storage
+	metadata metadata.Store
+	cfg      ScrubConfig
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	status  ScrubStatus
+	corrupt map[string]CorruptFile
+}
+
+// NewScrubber creates a scrubber for primary. replica may be nil, in which
+// case corrupt blobs are only reported.
+func NewScrubber(primary, replica storage.Storage, metadata metadata.Store, cfg ScrubConfig, logger *slog.Logger) *Scrubber {
+	s := &Scrubber{
+		storage:  primary,
+		replica:  replica,
+		metadata: metadata,
+		cfg:      cfg,
+		logger:   logger,
+		corrupt:  make(map[string]CorruptFile),
+	}
+
+	metrics.NewGaugeFunc("media_scrub_corrupt_files", "Blobs currently known to be corrupt.", func() float64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return float64(len(s.corrupt))
+	})
+
+	return s
+}
+
+// Pass verifies every stored blob once.
+func (s *Scrubber) Pass(ctx context.Context) error {
+	files, err := s.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return err
+	}
+
+	checked := 0
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.Check(ctx, f)
+		checked++
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	s.status.Passes++
+	s.status.LastPassAt = &now
+	s.status.Checked = checked
+	s.mu.Unlock()
+	return nil
+}
+
+// Check verifies a single blob, repairing it from the replica if possible,
+// and returns whether it is intact afterwards.
+func (s *Scrubber) Check(ctx context.Context, f domain.FileMetadata) bool {
+	issue, ok := s.verify(ctx, f)
+	if ok {
+		scrubChecked.Inc("ok")
+		s.clear(f.ID)
+		return true
+	}
+	if issue.Issue == IssueNoChecksum {
+		scrubChecked.Inc("unverifiable")
+		return true
+	}
+
+	if s.repair(ctx, f) {
+		scrubChecked.Inc("repaired")
+		s.logger.Warn("Repaired corrupt blob from replica", "fileId", f.ID, "issue", issue.Issue)
+		s.mu.Lock()
+		s.status.Repaired++
+		s.mu.Unlock()
+		s.clear(f.ID)
+		return true
+	}
+
+	scrubChecked.Inc("corrupt")
+	s.logger.Error("Corrupt blob detected", "fileId", f.ID, "issue", issue.Issue)
+	s.mu.Lock()
+	if _, known := s.corrupt[f.ID]; !known {
+		s.corrupt[f.ID] = CorruptFile{Issue: issue, DetectedAt: time.Now().UTC()}
+	}
+	s.mu.Unlock()
+	return false
+}
+
+func (s *Scrubber) Status() ScrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Corrupt = make([]CorruptFile, 0, len(s.corrupt))
+	for _, c := range s.corrupt {
+		status.Corrupt = append(status.Corrupt, c)
+	}
+	sort.Slice(status.Corrupt, func(i, j int) bool {
+		return status.Corrupt[i].DetectedAt.Before(status.Corrupt[j].DetectedAt)
+	})
+	return status
+}
+
+func (s *Scrubber) clear(fileID string) {
+	s.mu.Lock()
+	delete(s.corrupt, fileID)
+	s.mu.Unlock()
+}
+
+func (s *Scrubber) verify(ctx context.Context, f domain.FileMetadata) (Issue, bool) {
+	rc, _, err := s.storage.Open(ctx, f.ID)
+	if err != nil {
+		return Issue{FileID: f.ID, Issue: IssueMissing}, false
+	}
+	defer rc.Close()
+
+	h := recordedHasher(f)
+	size, err := bufpool.Copy(h, &throttledReader{ctx: ctx, r: rc, rate: s.cfg.BytesPerSecond})
+	scrubBytes.Add(float64(size))
+	if err != nil {
+		return Issue{FileID: f.ID, Issue: IssueMissing, Actual: err.Error()}, false
+	}
+	return compare(f, size, h.Sums())
+}
+
+// repair copies the replica's blob over the primary if the replica's copy
+// matches the recorded checksum.
+func (s *Scrubber) repair(ctx context.Context, f domain.FileMetadata) bool {
+	restorer, ok := s.storage.(Restorer)
+	if !ok || s.replica == nil {
+		return false
+	}
+
+	rc, _, err := s.replica.Open(ctx, f.ID)
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+
+	// The copy is checked as it streams; one that does not match fails the
+	// read at its end, before it replaces anything.
+	err = restorer.Restore(ctx, f.ID, f.Directory, &verifyingReader{r: rc, f: f, h: recordedHasher(f)})
+	if errors.Is(err, errReplicaCorrupt) {
+		s.logger.Warn("Replica copy is not intact either", "fileId", f.ID)
+		return false
+	}
+	if err != nil {
+		s.logger.Error("Failed to restore blob from replica", "fileId", f.ID, "error", err)
+		return false
+	}
+	return true
+}
+
+var errReplicaCorrupt = errors.New("replica copy does not match the recorded checksums")
+
+// verifyingReader hashes what is read from r and fails at its end if it
+// does not match the recorded size and checksums of f.
+type verifyingReader struct {
+	r    io.Reader
+	f    domain.FileMetadata
+	h    *checksum.Multi
+	size int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	v.size += int64(n)
+	if err == io.EOF {
+		if _, ok := compare(v.f, v.size, v.h.Sums()); !ok {
+			return n, errReplicaCorrupt
+		}
+	}
+	return n, err
+}
+
+// throttledReader limits reads to rate bytes per second.
+type throttledReader struct {
+	ctx  context.Context
+	r    io.Reader
+	rate int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.rate <= 0 {
+		return t.r.Read(p)
+	}
+	if int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		select {
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		case <-time.After(time.Duration(n) * time.Second / time.Duration(t.rate)):
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+// forgetWorkers is how long workers not seen are kept in the store, for
+// operators to tell which went away.
+const forgetWorkers = time.Hour
+
+// owns reports whether the jobs of a file run on this worker.
+func (p *Pool) owns(fileID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ring == nil || p.ring.Owner(fileID) == p.cfg.Worker
+}
+
+// alive reports whether a worker is on the ring.
+func (p *Pool) alive(worker string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.live[worker]
+}
+
+// membership announces this worker every heartbeat and adopts the jobs of
+// its files, until ctx is done; it then leaves the ring, so that the other
+// workers take over its share at their next heartbeat.
+func (p *Pool) membership(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.store.DeleteWorker(context.WithoutCancel(ctx), p.cfg.Worker); err != nil {
+				p.logger.Warn("Failed to leave the job workers", "worker", p.cfg.Worker, "error", err)
+			}
+			return
+		case now := <-ticker.C:
+			p.heartbeat(ctx, now.UTC())
+			p.adopt(ctx)
+		}
+	}
+}
+
+// heartbeat announces this worker and rebuilds the ring from the workers
+// seen within three heartbeats.
+func (p *Pool) heartbeat(ctx context.Context, now time.Time) {
+	if p.started.IsZero() {
+		p.started = now
+	}
+	err := p.store.PutWorker(ctx, domain.Worker{ID: p.cfg.Worker, StartedAt: p.started, SeenAt: now})
+	if err != nil {
+		p.logger.Warn("Failed to announce job worker", "worker", p.cfg.Worker, "error", err)
+	}
+	workers, err := p.store.ListWorkers(ctx)
+	if err != nil {
+		p.logger.Error("Failed to list job workers", "error", err)
+		return
+	}
+
+	live := map[string]bool{p.cfg.Worker: true}
+	for _, w := range workers {
+		switch seen := now.Sub(w.SeenAt); {
+		case seen < 3*p.cfg.Heartbeat:
+			live[w.ID] = true
+		case seen > forgetWorkers:
+			p.store.DeleteWorker(ctx, w.ID)
+		}
+	}
+	ids := make([]string, 0, len(live))
+	for id := range live {
+		ids = append(ids, id)
+	}
+	ring := NewRing(ids)
+
+	p.mu.Lock()
+	changed := p.ring == nil || !slices.Equal(p.ring.Workers(), ring.Workers())
+	if changed {
+		p.ring, p.live = ring, live
+	}
+	p.mu.Unlock()
+	if changed {
+		workersGauge.Set(float64(len(ids)))
+		p.logger.Info("Job workers changed", "worker", p.cfg.Worker, "workers", ring.Workers())
+	}
+}
+
+// adopt schedules the unfinished jobs of the files of this worker that are
+// not queued here: those left by a previous run and, with affinity, those
+// enqueued by other workers or left by workers that are gone. Jobs running
+// on another worker are left to finish there, so a rebalanced file moves
+// once its current job is done.
+func (p *Pool) adopt(ctx context.Context) {
+	var jobs []domain.Job
+	for _, status := range []domain.JobStatus{domain.JobPending, domain.JobRunning} {
+		list, err := p.store.ListJobs(ctx, metadata.JobFilter{Status: status})
+		if err != nil {
+			p.logger.Error("Failed to list unfinished jobs", "error", err)
+			return
+		}
+		jobs = append(jobs, list...)
+	}
+
+	p.mu.Lock()
+	queued := make(map[string]bool, len(p.pending)+len(p.running))
+	for _, s := range p.pending {
+		queued[s.id] = true
+	}
+	for id := range p.running {
+		queued[id] = true
+	}
+	p.mu.Unlock()
+
+	for _, job := range jobs {
+		if queued[job.ID] || !p.owns(job.FileID) {
+			continue
+		}
+		if job.Status == domain.JobRunning && job.Worker != p.cfg.Worker && p.alive(job.Worker) {
+			continue
+		}
+		if p.cfg.Worker != "" && job.Worker != p.cfg.Worker {
+			adoptedTotal.Inc()
+		}
+		p.schedule(job.ID, job.RunAt, job.Priority)
+	}
+}
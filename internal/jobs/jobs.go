@@ -0,0 +1,540 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/drain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ondrasimku/media-service-go/internal/jobs")
+
+var (
+	attemptsTotal = metrics.NewCounter("media_job_attempts_total",
+		"Finished background job attempts.", "kind", "result")
+	attemptDuration = metrics.NewHistogram("media_job_duration_seconds",
+		"Time taken by background job attempts.",
+		[]float64{0.1, 0.5, 1, 5, 15, 60, 300}, "kind")
+	workersGauge = metrics.NewGauge("media_job_workers",
+		"Workers sharing the job queue, as seen by this one.")
+	adoptedTotal = metrics.NewCounter("media_job_adopted_total",
+		"Jobs taken over by this worker: enqueued by another, or left by one that is gone.")
+)
+
+// Handler does the work of a job. Failures are retried with backoff
+// unless the error is wrapped with Permanent.
+type Handler func(ctx context.Context, job domain.Job) error
+
+// Queue runs background jobs for files. Pool runs them in process; the
+// interface leaves room for a broker such as Redis or NATS once several
+// nodes share the work.
+type Queue interface {
+	// Register sets the handler for jobs of kind. Handlers must be
+	// registered before Run.
+	Register(kind string, handler Handler)
+
+	// Enqueue records a job and schedules it to run as soon as possible.
+	Enqueue(ctx context.Context, fileID, kind string, params map[string]string) (domain.Job, error)
+
+	// Jobs lists the jobs of a file, oldest first.
+	Jobs(ctx context.Context, fileID string) ([]domain.Job, error)
+
+	// List lists the jobs matching filter, oldest first.
+	List(ctx context.Context, filter metadata.JobFilter) ([]domain.Job, error)
+
+	// Retry queues a failed job to run again with a full set of attempts.
+	Retry(ctx context.Context, id string) (domain.Job, error)
+
+	// Purge deletes a failed job.
+	Purge(ctx context.Context, id string) error
+
+	// Depth is the number of jobs waiting to run, including retries not
+	// yet due.
+	Depth() int
+
+	// Run works on jobs until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+// ErrNotFailed is returned when retrying or purging a job that has not
+// failed. Unknown jobs are reported with metadata.ErrNotFound.
+var ErrNotFailed = errors.New("job has not failed")
+
+// Permanent marks err as not worth retrying, e.g. because the file is gone.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+type Config struct {
+	// Workers is the number of jobs run at the same time.
+	Workers int
+
+	// MaxAttempts is how often a failing job runs before it is marked
+	// failed.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It doubles with every
+	// further attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retention is how long finished jobs are kept; zero keeps them.
+	Retention time.Duration
+
+	// Metering bills the processing of jobs to the organizations owning
+	// their files. Optional.
+	Metering *metering.Recorder
+
+	// Priorities ranks jobs by kind, so that jobs holding up fresh uploads
+	// run ahead of bulk work; unlisted kinds have priority 0.
+	Priorities map[string]int
+
+	// TierPriorities adds to the priority of jobs on files of
+	// organizations in a tier, as returned by Tier. Files is used to look
+	// up the organization of a file and may be nil without tiers.
+	Tier           func(ctx context.Context, orgID string) string
+	TierPriorities map[string]int
+	Files          metadata.Store
+
+	// Aging raises the priority of a waiting job by one for every Aging it
+	// is overdue, so that low priority jobs are not starved by a steady
+	// stream of higher ones. Zero runs jobs by priority alone.
+	Aging time.Duration
+
+	// Worker identifies this replica among those sharing the job store.
+	// When set, the jobs of a file run on the worker its ID hashes to, so
+	// that work on the same source reuses the scratch cache of one worker.
+	// Workers announce themselves every Heartbeat and are taken for gone
+	// after three missed ones; their share then moves to the others.
+	Worker    string
+	Heartbeat time.Duration
+
+	// Drain accounts for the jobs cut off by a shutdown. Optional.
+	Drain *drain.Tracker
+}
+
+// Pool is a Queue running jobs on a pool of goroutines. Jobs are persisted
+// in the metadata store, so jobs interrupted by a restart, and retries
+// that were waiting, are picked up again by Run.
+type Pool struct {
+	store  metadata.JobStore
+	cfg    Config
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	pending  []scheduled // Ordered by runAt
+	running  map[string]bool
+	ring     *Ring           // Nil without affinity
+	live     map[string]bool // Workers on the ring
+	started  time.Time
+	wake     chan struct{}
+}
+
+type scheduled struct {
+	id       string
+	runAt    time.Time
+	priority int
+}
+
+func NewPool(store metadata.JobStore, cfg Config, logger *slog.Logger) *Pool {
+	p := &Pool{
+		store:    store,
+		cfg:      cfg,
+		logger:   logger,
+		handlers: make(map[string]Handler),
+		running:  make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+
+	metrics.NewGaugeFunc("media_job_queue_depth", "Background jobs waiting to run, including retries not yet due.", func() float64 {
+		return float64(p.Depth())
+	})
+
+	return p
+}
+
+// Enabled reports whether the pool runs jobs at all. A nil pool is
+// disabled.
+func (p *Pool) Enabled() bool {
+	return p != nil && p.cfg.Workers > 0
+}
+
+func (p *Pool) Register(kind string, handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[kind] = handler
+}
+
+func (p *Pool) Enqueue(ctx context.Context, fileID, kind string, params map[string]string) (domain.Job, error) {
+	now := time.Now().UTC()
+	job := domain.Job{
+		ID:        uuid.New().String(),
+		FileID:    fileID,
+		Kind:      kind,
+		Params:    params,
+		Status:    domain.JobPending,
+		RunAt:     now,
+		Priority:  p.priority(ctx, fileID, kind),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		job.Trace = carrier
+	}
+	if err := p.store.PutJob(ctx, job); err != nil {
+		return domain.Job{}, fmt.Errorf("failed to store job: %w", err)
+	}
+	// Jobs of files owned by another worker are adopted by it.
+	if p.owns(job.FileID) {
+		p.schedule(job.ID, job.RunAt, job.Priority)
+	}
+	return job, nil
+}
+
+// priority is the priority of a new job of kind on a file: that of its
+// kind plus that of the tier of the organization owning the file.
+func (p *Pool) priority(ctx context.Context, fileID, kind string) int {
+	priority := p.cfg.Priorities[kind]
+	if len(p.cfg.TierPriorities) == 0 || p.cfg.Tier == nil || p.cfg.Files == nil {
+		return priority
+	}
+	meta, err := p.cfg.Files.Get(ctx, fileID)
+	if err != nil || meta.OrgID == "" {
+		return priority
+	}
+	return priority + p.cfg.TierPriorities[p.cfg.Tier(ctx, meta.OrgID)]
+}
+
+func (p *Pool) Jobs(ctx context.Context, fileID string) ([]domain.Job, error) {
+	return p.store.ListJobs(ctx, metadata.JobFilter{FileID: fileID})
+}
+
+func (p *Pool) List(ctx context.Context, filter metadata.JobFilter) ([]domain.Job, error) {
+	return p.store.ListJobs(ctx, filter)
+}
+
+// Retry requeues a failed job. Its last error is kept until it runs again.
+func (p *Pool) Retry(ctx context.Context, id string) (domain.Job, error) {
+	job, err := p.failed(ctx, id)
+	if err != nil {
+		return domain.Job{}, err
+	}
+	now := time.Now().UTC()
+	job.Status = domain.JobPending
+	job.Attempts = 0
+	job.RunAt = now
+	job.UpdatedAt = now
+	if err := p.store.PutJob(ctx, job); err != nil {
+		return domain.Job{}, fmt.Errorf("failed to store job: %w", err)
+	}
+	if p.owns(job.FileID) {
+		p.schedule(job.ID, job.RunAt, job.Priority)
+	}
+	p.logger.Info("Failed job requeued", "jobId", job.ID, "kind", job.Kind, "fileId", job.FileID)
+	return job, nil
+}
+
+func (p *Pool) Purge(ctx context.Context, id string) error {
+	job, err := p.failed(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := p.store.DeleteJob(ctx, job.ID); err != nil {
+		return err
+	}
+	p.logger.Info("Failed job purged", "jobId", job.ID, "kind", job.Kind, "fileId", job.FileID, "error", job.Error)
+	return nil
+}
+
+func (p *Pool) failed(ctx context.Context, id string) (domain.Job, error) {
+	job, err := p.store.GetJob(ctx, id)
+	if err != nil {
+		return domain.Job{}, err
+	}
+	if job.Status != domain.JobFailed {
+		return domain.Job{}, ErrNotFailed
+	}
+	return job, nil
+}
+
+func (p *Pool) Depth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// Run requeues jobs left unfinished by a previous run and works on queued
+// jobs until ctx is cancelled. Finished jobs are pruned hourly. With
+// affinity, the worker announces itself every heartbeat and adopts the
+// jobs of its files, until it leaves the ring on return.
+func (p *Pool) Run(ctx context.Context) {
+	if !p.Enabled() {
+		return
+	}
+
+	var wg sync.WaitGroup
+	if p.cfg.Worker != "" {
+		p.heartbeat(ctx, time.Now().UTC())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.membership(ctx)
+		}()
+	}
+	p.adopt(ctx)
+
+	for range p.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	if p.cfg.Retention > 0 {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			p.prune(ctx, time.Now().Add(-p.cfg.Retention))
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// schedule adds a job to the pending list, after jobs due at the same time.
+func (p *Pool) schedule(id string, runAt time.Time, priority int) {
+	p.mu.Lock()
+	i := len(p.pending)
+	for i > 0 && p.pending[i-1].runAt.After(runAt) {
+		i--
+	}
+	p.pending = slices.Insert(p.pending, i, scheduled{id: id, runAt: runAt, priority: priority})
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		id, wait := p.next(time.Now())
+		if id == "" {
+			// A nil channel never fires: nothing is pending.
+			var due <-chan time.Time
+			if wait > 0 {
+				due = time.After(wait)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wake:
+			case <-due:
+			}
+			continue
+		}
+		// Wake another worker in case more jobs are due.
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+		p.run(ctx, id)
+	}
+}
+
+// next takes the job due at now with the highest priority, aged by how
+// long it is overdue; of equals, the one due first. Otherwise it returns
+// how long until the first pending job is due, or zero when none is
+// pending.
+func (p *Pool) next(now time.Time) (string, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		return "", 0
+	}
+	if wait := p.pending[0].runAt.Sub(now); wait > 0 {
+		return "", wait
+	}
+
+	best, bestPriority := 0, 0
+	for i, s := range p.pending {
+		if s.runAt.After(now) {
+			break
+		}
+		priority := s.priority
+		if p.cfg.Aging > 0 {
+			priority += int(now.Sub(s.runAt) / p.cfg.Aging)
+		}
+		if i == 0 || priority > bestPriority {
+			best, bestPriority = i, priority
+		}
+	}
+	id := p.pending[best].id
+	p.pending = slices.Delete(p.pending, best, best+1)
+	p.running[id] = true
+	return id, 0
+}
+
+func (p *Pool) run(ctx context.Context, id string) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, id)
+		p.mu.Unlock()
+	}()
+
+	job, err := p.store.GetJob(ctx, id)
+	if err != nil || job.Finished() {
+		// Pruned while queued.
+		return
+	}
+	if !p.owns(job.FileID) || (job.Status == domain.JobRunning && job.Worker != p.cfg.Worker && p.alive(job.Worker)) {
+		// Moved to another worker while queued, which adopts it.
+		return
+	}
+
+	p.mu.Lock()
+	handler, ok := p.handlers[job.Kind]
+	p.mu.Unlock()
+
+	job.Status = domain.JobRunning
+	job.Worker = p.cfg.Worker
+	job.Attempts++
+	job.UpdatedAt = time.Now().UTC()
+	if err := p.store.PutJob(ctx, job); err != nil {
+		p.logger.Error("Failed to start job", "jobId", job.ID, "kind", job.Kind, "error", err)
+		p.schedule(job.ID, time.Now().Add(p.backoff(job.Attempts)), job.Priority)
+		return
+	}
+
+	op := p.cfg.Drain.Begin(drain.KindJob, job.FileID)
+	spanCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(job.Trace))
+	spanCtx, span := tracer.Start(spanCtx, "job "+job.Kind, trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("media.job_id", job.ID),
+			attribute.String("media.job_kind", job.Kind),
+			attribute.String("media.file_id", job.FileID),
+			attribute.Int("media.job_attempt", job.Attempts),
+		))
+	spanCtx, meter := metering.Start(spanCtx)
+	start := time.Now()
+	if ok {
+		err = handler(spanCtx, job)
+	} else {
+		err = Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	// Failed attempts are billed too; the work was done all the same.
+	usage := meter.Usage()
+	job.CPUSeconds += usage.CPU.Seconds()
+	job.ScratchBytes += usage.Scratch
+	p.cfg.Metering.RecordFile(ctx, job.FileID, job.Kind, usage)
+	op.Add(usage.Scratch)
+	op.End(ctx.Err() != nil)
+	if ctx.Err() != nil {
+		// Shutting down; the job stays running and is retried on start.
+		return
+	}
+	attemptDuration.Observe(time.Since(start).Seconds(), job.Kind)
+	p.finish(ctx, job, err)
+}
+
+// finish records the outcome of an attempt: done unless err is set, in
+// which case the job is retried while it has attempts left.
+func (p *Pool) finish(ctx context.Context, job domain.Job, err error) {
+	now := time.Now().UTC()
+	job.UpdatedAt = now
+	job.RunAt = time.Time{}
+
+	var permanent *permanentError
+	switch {
+	case err == nil:
+		attemptsTotal.Inc(job.Kind, "done")
+		job.Status = domain.JobDone
+		job.Error = ""
+	case errors.As(err, &permanent) || job.Attempts >= p.cfg.MaxAttempts:
+		attemptsTotal.Inc(job.Kind, "failed")
+		p.logger.Error("Job failed", "jobId", job.ID, "kind", job.Kind, "fileId", job.FileID, "attempts", job.Attempts, "error", err)
+		job.Status = domain.JobFailed
+		job.Error = err.Error()
+	default:
+		attemptsTotal.Inc(job.Kind, "retried")
+		delay := p.backoff(job.Attempts)
+		p.logger.Warn("Job failed, retrying", "jobId", job.ID, "kind", job.Kind, "fileId", job.FileID, "attempt", job.Attempts, "retryIn", delay, "error", err)
+		job.Status = domain.JobPending
+		job.Error = err.Error()
+		job.RunAt = now.Add(delay)
+	}
+
+	if err := p.store.PutJob(ctx, job); err != nil {
+		p.logger.Error("Failed to record job result", "jobId", job.ID, "kind", job.Kind, "error", err)
+	}
+	if job.Status == domain.JobPending && p.owns(job.FileID) {
+		p.schedule(job.ID, job.RunAt, job.Priority)
+	}
+}
+
+// backoff is the delay after the given failed attempt.
+func (p *Pool) backoff(attempt int) time.Duration {
+	delay := p.cfg.Backoff
+	for ; attempt > 1; attempt-- {
+		delay *= 2
+		if p.cfg.MaxBackoff > 0 && delay >= p.cfg.MaxBackoff {
+			return p.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// prune deletes jobs finished before cutoff.
+func (p *Pool) prune(ctx context.Context, cutoff time.Time) {
+	jobs, err := p.store.ListJobs(ctx, metadata.JobFilter{})
+	if err != nil {
+		p.logger.Error("Failed to list jobs for pruning", "error", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Finished() && job.UpdatedAt.Before(cutoff) {
+			if err := p.store.DeleteJob(ctx, job.ID); err != nil {
+				p.logger.Warn("Failed to prune job", "jobId", job.ID, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"strconv"
+)
+
+// ringReplicas is the number of points each worker has on the ring, which
+// evens out the share of files each one gets.
+const ringReplicas = 128
+
+// Ring assigns files to the workers sharing the job queue by consistent
+// hashing: adding or removing a worker moves only the files of its share,
+// so the others keep the scratch caches of theirs.
+type Ring struct {
+	workers []string
+	points  []ringPoint // Sorted by hash
+}
+
+type ringPoint struct {
+	hash   uint64
+	worker string
+}
+
+// NewRing places workers on the ring.
+func NewRing(workers []string) *Ring {
+	r := &Ring{workers: slices.Sorted(slices.Values(workers))}
+	r.workers = slices.Compact(r.workers)
+	for _, w := range r.workers {
+		for i := range ringReplicas {
+			r.points = append(r.points, ringPoint{hash: ringHash(w + "#" + strconv.Itoa(i)), worker: w})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.worker, b.worker))
+	})
+	return r
+}
+
+// Owner returns the worker the jobs of a file run on: the one of the first
+// point at or after the hash of the file. It returns "" for an empty ring.
+func (r *Ring) Owner(fileID string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(fileID)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int {
+		return cmp.Compare(p.hash, h)
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].worker
+}
+
+// Workers returns the workers on the ring, sorted.
+func (r *Ring) Workers() []string {
+	return slices.Clone(r.workers)
+}
+
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
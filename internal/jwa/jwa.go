@@ -0,0 +1,16 @@
+// Package jwa names the JSON Web Algorithms (RFC 7518) tokens may be
+// signed with. It is shared by the verification of tokens and the
+// configuration that picks among them.
+package jwa
+
+// Supported are the signing algorithms tokens may be verified with: RSA
+// with PKCS #1 v1.5 and PSS padding, ECDSA and Ed25519.
+var Supported = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// Default are the RSA algorithms accepted unless configured otherwise.
+var Default = []string{"RS256", "RS384", "RS512"}
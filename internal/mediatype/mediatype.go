@@ -0,0 +1,144 @@
+package mediatype
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	OctetStream = "application/octet-stream"
+	SVG         = "image/svg+xml"
+	PDF         = "application/pdf"
+)
+
+// SniffLen is the number of leading bytes Detect looks at.
+const SniffLen = 512
+
+// Detect determines the media type from the leading bytes of a file,
+// ignoring whatever the client claimed.
+func Detect(head []byte) string {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+	contentType := http.DetectContentType(head)
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	// The standard sniffer has no SVG signature and calls it XML or text.
+	if (contentType == "text/xml" || contentType == "text/plain") && isSVG(head) {
+		return SVG
+	}
+	// It only recognizes MP3s by an ID3 tag, not by the first frame.
+	if contentType == OctetStream && isMPEGAudio(head) {
+		return "audio/mpeg"
+	}
+	if canonical, ok := aliases[contentType]; ok {
+		return canonical
+	}
+	return contentType
+}
+
+// aliases maps alternative names of media types, from the standard sniffer
+// and from clients, to the name the service uses.
+var aliases = map[string]string{
+	"audio/mp3":       "audio/mpeg",
+	"application/ogg": "audio/ogg",
+	"audio/wave":      "audio/wav",
+	"audio/x-wav":     "audio/wav",
+	"audio/vnd.wave":  "audio/wav",
+}
+
+// isMPEGAudio reports whether head starts with an MPEG audio frame header:
+// the frame sync, a valid layer, bit rate and sample rate.
+func isMPEGAudio(head []byte) bool {
+	return len(head) >= 3 &&
+		head[0] == 0xFF && head[1]&0xE0 == 0xE0 &&
+		head[1]&0x06 != 0 &&
+		head[2]&0xF0 != 0xF0 &&
+		head[2]&0x0C != 0x0C
+}
+
+// isSVG reports whether an XML document's root element is <svg>, skipping
+// the XML declaration, comments and a DOCTYPE.
+func isSVG(head []byte) bool {
+	s := strings.TrimPrefix(string(head), "\ufeff")
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		switch {
+		case strings.HasPrefix(s, "<?"):
+			i := strings.Index(s, "?>")
+			if i < 0 {
+				return false
+			}
+			s = s[i+2:]
+		case strings.HasPrefix(s, "<!--"):
+			i := strings.Index(s, "-->")
+			if i < 0 {
+				return false
+			}
+			s = s[i+3:]
+		case strings.HasPrefix(s, "<!"):
+			i := strings.IndexByte(s, '>')
+			if i < 0 {
+				return false
+			}
+			s = s[i+1:]
+		default:
+			return strings.HasPrefix(s, "<svg") && len(s) > 4 && strings.ContainsRune(" \t\r\n>/", rune(s[4]))
+		}
+	}
+}
+
+// Sniff detects the media type from the first bytes of r and rewinds it.
+func Sniff(r io.ReadSeeker) string {
+	head := make([]byte, SniffLen)
+	n, _ := io.ReadFull(r, head)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return OctetStream
+	}
+	return Detect(head[:n])
+}
+
+// FromExtension maps a file name's extension to a media type.
+func FromExtension(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	case ".svg":
+		return SVG
+	case ".pdf":
+		return PDF
+	case ".mp4":
+		return "video/mp4"
+	case ".webm":
+		return "video/webm"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".ogg", ".oga", ".opus":
+		return "audio/ogg"
+	case ".wav":
+		return "audio/wav"
+	}
+	return OctetStream
+}
+
+// Normalize strips parameters and lowercases a Content-Type value, and
+// maps alternative names to the canonical one.
+func Normalize(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if canonical, ok := aliases[contentType]; ok {
+		return canonical
+	}
+	return contentType
+}
@@ -0,0 +1,623 @@
+package jsonfile
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+// Store is a metadata.Store backed by JSON documents on the local disk,
+// suitable for single-node deployments.
+type Store struct {
+	files       *table[domain.FileMetadata]
+	annotations *table[domain.Annotation]
+	aliases     *table[domain.Alias]
+	shortLinks  *table[domain.ShortLink]
+	slugs       *table[domain.Slug]
+	brandings   *table[domain.Branding]
+	feeds       *table[domain.Feed]
+	quotas      *table[domain.Quota]
+	orgs        *table[domain.Org]
+	orgSettings *table[domain.OrgSettings]
+	geoRules    *table[domain.GeoRule]
+	presets     *table[domain.TranscodePreset]
+	usage       *table[domain.ProcessingUsage]
+	jobs        *table[domain.Job]
+	workers     *table[domain.Worker]
+	audit       *table[domain.AuditEntry]
+	replication *table[domain.ReplicationTask]
+	tombstones  *table[domain.Tombstone]
+}
+
+func NewStore(dir string) (*Store, error) {
+	files, err := openTable[domain.FileMetadata](filepath.Join(dir, "files"))
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := openTable[domain.Annotation](filepath.Join(dir, "annotations"))
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := openTable[domain.Alias](filepath.Join(dir, "aliases"))
+	if err != nil {
+		return nil, err
+	}
+
+	shortLinks, err := openTable[domain.ShortLink](filepath.Join(dir, "shortlinks"))
+	if err != nil {
+		return nil, err
+	}
+
+	slugs, err := openTable[domain.Slug](filepath.Join(dir, "slugs"))
+	if err != nil {
+		return nil, err
+	}
+
+	brandings, err := openTable[domain.Branding](filepath.Join(dir, "brandings"))
+	if err != nil {
+		return nil, err
+	}
+
+	feeds, err := openTable[domain.Feed](filepath.Join(dir, "feeds"))
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, err := openTable[domain.Quota](filepath.Join(dir, "quotas"))
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, err := openTable[domain.Org](filepath.Join(dir, "orgs"))
+	if err != nil {
+		return nil, err
+	}
+
+	orgSettings, err := openTable[domain.OrgSettings](filepath.Join(dir, "orgsettings"))
+	if err != nil {
+		return nil, err
+	}
+
+	geoRules, err := openTable[domain.GeoRule](filepath.Join(dir, "georules"))
+	if err != nil {
+		return nil, err
+	}
+
+	presets, err := openTable[domain.TranscodePreset](filepath.Join(dir, "presets"))
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := openTable[domain.ProcessingUsage](filepath.Join(dir, "usage"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := openTable[domain.Job](filepath.Join(dir, "jobs"))
+	if err != nil {
+		return nil, err
+	}
+
+	workers, err := openTable[domain.Worker](filepath.Join(dir, "workers"))
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := openTable[domain.AuditEntry](filepath.Join(dir, "audit"))
+	if err != nil {
+		return nil, err
+	}
+
+	replication, err := openTable[domain.ReplicationTask](filepath.Join(dir, "replication"))
+	if err != nil {
+		return nil, err
+	}
+
+	tombstones, err := openTable[domain.Tombstone](filepath.Join(dir, "tombstones"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		files:       files,
+		annotations: annotations,
+		aliases:     aliases,
+		shortLinks:  shortLinks,
+		slugs:       slugs,
+		brandings:   brandings,
+		feeds:       feeds,
+		quotas:      quotas,
+		orgs:        orgs,
+		orgSettings: orgSettings,
+		geoRules:    geoRules,
+		presets:     presets,
+		usage:       usage,
+		jobs:        jobs,
+		workers:     workers,
+		audit:       audit,
+		replication: replication,
+		tombstones:  tombstones,
+	}, nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (domain.FileMetadata, error) {
+	meta, ok := s.files.get(id)
+	if !ok {
+		return domain.FileMetadata{}, metadata.ErrNotFound
+	}
+	return meta, nil
+}
+
+func (s *Store) Put(ctx context.Context, meta domain.FileMetadata) error {
+	return s.files.put(meta.ID, meta)
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if !s.files.delete(id) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) List(ctx context.Context, filter metadata.Filter) ([]domain.FileMetadata, error) {
+	files := s.files.list(filter.Match)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.Before(files[j].CreatedAt)
+	})
+	return files, nil
+}
+
+func (s *Store) GetAnnotation(ctx context.Context, id string) (domain.Annotation, error) {
+	annotation, ok := s.annotations.get(id)
+	if !ok {
+		return domain.Annotation{}, metadata.ErrNotFound
+	}
+	return annotation, nil
+}
+
+func (s *Store) PutAnnotation(ctx context.Context, annotation domain.Annotation) error {
+	return s.annotations.put(annotation.ID, annotation)
+}
+
+func (s *Store) DeleteAnnotation(ctx context.Context, id string) error {
+	if !s.annotations.delete(id) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListAnnotations(ctx context.Context, fileID string) ([]domain.Annotation, error) {
+	annotations := s.annotations.list(func(a domain.Annotation) bool {
+		return a.FileID == fileID
+	})
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].CreatedAt.Before(annotations[j].CreatedAt)
+	})
+	return annotations, nil
+}
+
+func (s *Store) GetAlias(ctx context.Context, id string) (domain.Alias, error) {
+	alias, ok := s.aliases.get(id)
+	if !ok {
+		return domain.Alias{}, metadata.ErrNotFound
+	}
+	return alias, nil
+}
+
+func (s *Store) PutAlias(ctx context.Context, alias domain.Alias) error {
+	return s.aliases.put(alias.ID, alias)
+}
+
+func (s *Store) DeleteAlias(ctx context.Context, id string) error {
+	if !s.aliases.delete(id) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListAliases(ctx context.Context, targetID string) ([]domain.Alias, error) {
+	aliases := s.aliases.list(func(a domain.Alias) bool {
+		return a.TargetID == targetID
+	})
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].CreatedAt.Before(aliases[j].CreatedAt)
+	})
+	return aliases, nil
+}
+
+func (s *Store) GetShortLink(ctx context.Context, code string) (domain.ShortLink, error) {
+	link, ok := s.shortLinks.get(code)
+	if !ok {
+		return domain.ShortLink{}, metadata.ErrNotFound
+	}
+	return link, nil
+}
+
+func (s *Store) PutShortLink(ctx context.Context, link domain.ShortLink) error {
+	return s.shortLinks.put(link.Code, link)
+}
+
+func (s *Store) DeleteShortLink(ctx context.Context, code string) error {
+	if !s.shortLinks.delete(code) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListShortLinks(ctx context.Context, fileID string) ([]domain.ShortLink, error) {
+	links := s.shortLinks.list(func(l domain.ShortLink) bool {
+		return l.FileID == fileID
+	})
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+func (s *Store) RecordShortLinkHit(ctx context.Context, code string) (domain.ShortLink, error) {
+	link, ok, err := s.shortLinks.update(code, func(l *domain.ShortLink) {
+		l.Hits++
+	})
+	if err != nil {
+		return domain.ShortLink{}, err
+	}
+	if !ok {
+		return domain.ShortLink{}, metadata.ErrNotFound
+	}
+	return link, nil
+}
+
+// slugKey names the record of a slug. The organization is encoded as it
+// may contain characters that are not safe in file names.
+func slugKey(orgID, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(orgID)) + "~" + name
+}
+
+func (s *Store) GetSlug(ctx context.Context, orgID, name string) (domain.Slug, error) {
+	slug, ok := s.slugs.get(slugKey(orgID, name))
+	if !ok {
+		return domain.Slug{}, metadata.ErrNotFound
+	}
+	return slug, nil
+}
+
+func (s *Store) PutSlug(ctx context.Context, slug domain.Slug) error {
+	return s.slugs.put(slugKey(slug.OrgID, slug.Name), slug)
+}
+
+func (s *Store) DeleteSlug(ctx context.Context, orgID, name string) error {
+	if !s.slugs.delete(slugKey(orgID, name)) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListSlugs(ctx context.Context, orgID string) ([]domain.Slug, error) {
+	slugs := s.slugs.list(func(slug domain.Slug) bool {
+		return slug.OrgID == orgID
+	})
+	sort.Slice(slugs, func(i, j int) bool {
+		return slugs[i].Name < slugs[j].Name
+	})
+	return slugs, nil
+}
+
+func (s *Store) GetBranding(ctx context.Context, orgID string) (domain.Branding, error) {
+	branding, ok := s.brandings.get(orgID)
+	if !ok {
+		return domain.Branding{}, metadata.ErrNotFound
+	}
+	return branding, nil
+}
+
+func (s *Store) PutBranding(ctx context.Context, branding domain.Branding) error {
+	return s.brandings.put(branding.OrgID, branding)
+}
+
+func (s *Store) DeleteBranding(ctx context.Context, orgID string) error {
+	if !s.brandings.delete(orgID) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListBrandings(ctx context.Context) ([]domain.Branding, error) {
+	brandings := s.brandings.list(nil)
+	sort.Slice(brandings, func(i, j int) bool {
+		return brandings[i].OrgID < brandings[j].OrgID
+	})
+	return brandings, nil
+}
+
+func (s *Store) GetFeed(ctx context.Context, orgID string) (domain.Feed, error) {
+	feed, ok := s.feeds.get(orgID)
+	if !ok {
+		return domain.Feed{}, metadata.ErrNotFound
+	}
+	return feed, nil
+}
+
+func (s *Store) PutFeed(ctx context.Context, feed domain.Feed) error {
+	return s.feeds.put(feed.OrgID, feed)
+}
+
+func (s *Store) DeleteFeed(ctx context.Context, orgID string) error {
+	if !s.feeds.delete(orgID) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListFeeds(ctx context.Context) ([]domain.Feed, error) {
+	feeds := s.feeds.list(nil)
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].OrgID < feeds[j].OrgID
+	})
+	return feeds, nil
+}
+
+func (s *Store) GetQuota(ctx context.Context, orgID string) (domain.Quota, error) {
+	quota, ok := s.quotas.get(orgID)
+	if !ok {
+		return domain.Quota{}, metadata.ErrNotFound
+	}
+	return quota, nil
+}
+
+func (s *Store) PutQuota(ctx context.Context, quota domain.Quota) error {
+	return s.quotas.put(quota.OrgID, quota)
+}
+
+func (s *Store) DeleteQuota(ctx context.Context, orgID string) error {
+	if !s.quotas.delete(orgID) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListQuotas(ctx context.Context) ([]domain.Quota, error) {
+	quotas := s.quotas.list(nil)
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].OrgID < quotas[j].OrgID
+	})
+	return quotas, nil
+}
+
+func (s *Store) GetOrg(ctx context.Context, id string) (domain.Org, error) {
+	org, ok := s.orgs.get(id)
+	if !ok {
+		return domain.Org{}, metadata.ErrNotFound
+	}
+	return org, nil
+}
+
+func (s *Store) PutOrg(ctx context.Context, org domain.Org) error {
+	return s.orgs.put(org.ID, org)
+}
+
+func (s *Store) ListOrgs(ctx context.Context) ([]domain.Org, error) {
+	orgs := s.orgs.list(nil)
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].ID < orgs[j].ID
+	})
+	return orgs, nil
+}
+
+func (s *Store) GetOrgSettings(ctx context.Context, orgID string) (domain.OrgSettings, error) {
+	settings, ok := s.orgSettings.get(orgID)
+	if !ok {
+		return domain.OrgSettings{}, metadata.ErrNotFound
+	}
+	return settings, nil
+}
+
+func (s *Store) PutOrgSettings(ctx context.Context, settings domain.OrgSettings) error {
+	return s.orgSettings.put(settings.OrgID, settings)
+}
+
+func (s *Store) DeleteOrgSettings(ctx context.Context, orgID string) error {
+	if !s.orgSettings.delete(orgID) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) GetGeoRule(ctx context.Context, collection string) (domain.GeoRule, error) {
+	rule, ok := s.geoRules.get(collection)
+	if !ok {
+		return domain.GeoRule{}, metadata.ErrNotFound
+	}
+	return rule, nil
+}
+
+func (s *Store) PutGeoRule(ctx context.Context, rule domain.GeoRule) error {
+	return s.geoRules.put(rule.Collection, rule)
+}
+
+func (s *Store) DeleteGeoRule(ctx context.Context, collection string) error {
+	if !s.geoRules.delete(collection) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListGeoRules(ctx context.Context) ([]domain.GeoRule, error) {
+	rules := s.geoRules.list(nil)
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Collection < rules[j].Collection
+	})
+	return rules, nil
+}
+
+// presetKey names the record of a preset version.
+func presetKey(name string, version int) string {
+	return fmt.Sprintf("%s@%d", name, version)
+}
+
+func (s *Store) GetPreset(ctx context.Context, name string, version int) (domain.TranscodePreset, error) {
+	preset, ok := s.presets.get(presetKey(name, version))
+	if !ok {
+		return domain.TranscodePreset{}, metadata.ErrNotFound
+	}
+	return preset, nil
+}
+
+func (s *Store) PutPreset(ctx context.Context, preset domain.TranscodePreset) error {
+	return s.presets.put(presetKey(preset.Name, preset.Version), preset)
+}
+
+func (s *Store) ListPresets(ctx context.Context) ([]domain.TranscodePreset, error) {
+	presets := s.presets.list(nil)
+	sort.Slice(presets, func(i, j int) bool {
+		if presets[i].Name != presets[j].Name {
+			return presets[i].Name < presets[j].Name
+		}
+		return presets[i].Version < presets[j].Version
+	})
+	return presets, nil
+}
+
+// usageKey names the record of the totals of an organization, month and
+// kind, encoding the organization like slugKey.
+func usageKey(orgID, month, kind string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(orgID)) + "~" + month + "~" + kind
+}
+
+func (s *Store) AddProcessingUsage(ctx context.Context, usage domain.ProcessingUsage) error {
+	_, err := s.usage.upsert(usageKey(usage.OrgID, usage.Month, usage.Kind), func(u *domain.ProcessingUsage) {
+		u.OrgID, u.Month, u.Kind = usage.OrgID, usage.Month, usage.Kind
+		u.Jobs += usage.Jobs
+		u.CPUSeconds += usage.CPUSeconds
+		u.ScratchBytes += usage.ScratchBytes
+		u.UpdatedAt = usage.UpdatedAt
+	})
+	return err
+}
+
+func (s *Store) ListProcessingUsage(ctx context.Context, orgID string) ([]domain.ProcessingUsage, error) {
+	usage := s.usage.list(func(u domain.ProcessingUsage) bool { return u.OrgID == orgID })
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Month != usage[j].Month {
+			return usage[i].Month < usage[j].Month
+		}
+		return usage[i].Kind < usage[j].Kind
+	})
+	return usage, nil
+}
+
+func (s *Store) GetJob(ctx context.Context, id string) (domain.Job, error) {
+	job, ok := s.jobs.get(id)
+	if !ok {
+		return domain.Job{}, metadata.ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *Store) PutJob(ctx context.Context, job domain.Job) error {
+	return s.jobs.put(job.ID, job)
+}
+
+func (s *Store) DeleteJob(ctx context.Context, id string) error {
+	if !s.jobs.delete(id) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListJobs(ctx context.Context, filter metadata.JobFilter) ([]domain.Job, error) {
+	jobs := s.jobs.list(filter.Match)
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+func (s *Store) PutWorker(ctx context.Context, worker domain.Worker) error {
+	return s.workers.put(worker.ID, worker)
+}
+
+func (s *Store) ListWorkers(ctx context.Context) ([]domain.Worker, error) {
+	workers := s.workers.list(func(domain.Worker) bool { return true })
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+	return workers, nil
+}
+
+func (s *Store) DeleteWorker(ctx context.Context, id string) error {
+	if !s.workers.delete(id) {
+		return metadata.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) AppendAudit(ctx context.Context, entry domain.AuditEntry) error {
+	if _, ok := s.audit.get(entry.ID); ok {
+		return fmt.Errorf("audit entry %s already recorded", entry.ID)
+	}
+	return s.audit.put(entry.ID, entry)
+}
+
+func (s *Store) ListAudit(ctx context.Context, filter metadata.AuditFilter) ([]domain.AuditEntry, error) {
+	entries := s.audit.list(filter.Match)
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].At.Equal(entries[j].At) {
+			return entries[i].At.Before(entries[j].At)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+func replicationKey(region, fileID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(region)) + "~" + fileID
+}
+
+func (s *Store) QueueReplication(ctx context.Context, task domain.ReplicationTask) error {
+	_, err := s.replication.upsert(replicationKey(task.Region, task.FileID), func(t *domain.ReplicationTask) {
+		queuedAt := t.QueuedAt
+		*t = task
+		if !queuedAt.IsZero() {
+			t.QueuedAt = queuedAt
+		}
+	})
+	return err
+}
+
+func (s *Store) CompleteReplication(ctx context.Context, region, fileID string, revision domain.Revision) error {
+	s.replication.deleteIf(replicationKey(region, fileID), func(t domain.ReplicationTask) bool {
+		return t.Revision.Equal(revision)
+	})
+	return nil
+}
+
+func (s *Store) ListReplication(ctx context.Context, region string) ([]domain.ReplicationTask, error) {
+	tasks := s.replication.list(func(t domain.ReplicationTask) bool { return t.Region == region })
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].QueuedAt.Before(tasks[j].QueuedAt)
+	})
+	return tasks, nil
+}
+
+func (s *Store) GetTombstone(ctx context.Context, fileID string) (domain.Tombstone, error) {
+	tombstone, ok := s.tombstones.get(fileID)
+	if !ok {
+		return domain.Tombstone{}, metadata.ErrNotFound
+	}
+	return tombstone, nil
+}
+
+func (s *Store) PutTombstone(ctx context.Context, tombstone domain.Tombstone) error {
+	return s.tombstones.put(tombstone.FileID, tombstone)
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.files.ping()
+}
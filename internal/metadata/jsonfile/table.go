@@ -0,0 +1,178 @@
+package jsonfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// table keeps one JSON document per record in a directory and serves reads
+// from an in-memory copy loaded at startup.
+type table[T any] struct {
+	dir  string
+	mu   sync.RWMutex
+	rows map[string]T
+}
+
+func openTable[T any](dir string) (*table[T], error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	t := &table[T]{dir: dir, rows: make(map[string]T, len(entries))}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		var row T
+		if err := json.Unmarshal(data, &row); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		t.rows[strings.TrimSuffix(name, ".json")] = row
+	}
+
+	return t, nil
+}
+
+func (t *table[T]) get(key string) (T, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	row, ok := t.rows[key]
+	return row, ok
+}
+
+func (t *table[T]) put(key string, row T) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.write(key, row)
+}
+
+// update applies fn to the stored row under the write lock and persists the
+// result, so concurrent read-modify-write cycles are not lost.
+func (t *table[T]) update(key string, fn func(*T)) (T, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	row, ok := t.rows[key]
+	if !ok {
+		return row, false, nil
+	}
+	fn(&row)
+	return row, true, t.write(key, row)
+}
+
+// upsert is update for rows that may not exist yet; fn then starts from
+// the zero value.
+func (t *table[T]) upsert(key string, fn func(*T)) (T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	row := t.rows[key]
+	fn(&row)
+	return row, t.write(key, row)
+}
+
+// write persists row atomically. The caller must hold the write lock.
+func (t *table[T]) write(key string, row T) error {
+	data, err := json.MarshalIndent(row, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(t.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create record file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), t.path(key)); err != nil {
+		return fmt.Errorf("failed to store record: %w", err)
+	}
+
+	t.rows[key] = row
+	return nil
+}
+
+// ping checks that records can be written to the directory of the table.
+func (t *table[T]) ping() error {
+	tmp, err := os.CreateTemp(t.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write([]byte("{}"))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write probe file: %w", err)
+	}
+	return nil
+}
+
+func (t *table[T]) delete(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.rows[key]; !ok {
+		return false
+	}
+	os.Remove(t.path(key))
+	delete(t.rows, key)
+	return true
+}
+
+// deleteIf deletes the row if match reports true for it, under the write
+// lock, so a row replaced meanwhile is kept.
+func (t *table[T]) deleteIf(key string, match func(T) bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	row, ok := t.rows[key]
+	if !ok || !match(row) {
+		return false
+	}
+	os.Remove(t.path(key))
+	delete(t.rows, key)
+	return true
+}
+
+func (t *table[T]) list(match func(T) bool) []T {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var rows []T
+	for _, row := range t.rows {
+		if match == nil || match(row) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func (t *table[T]) path(key string) string {
+	return filepath.Join(t.dir, filepath.Base(key)+".json")
+}
@@ -0,0 +1,241 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+)
+
+var ErrNotFound = errors.New("metadata not found")
+
+type Filter struct {
+	Status     domain.FileStatus
+	OwnerID    string
+	OrgID      string
+	Collection string
+}
+
+func (f Filter) Match(m domain.FileMetadata) bool {
+	if f.Status != "" && m.Status != f.Status {
+		return false
+	}
+	if f.OwnerID != "" && m.OwnerID != f.OwnerID {
+		return false
+	}
+	if f.OrgID != "" && m.OrgID != f.OrgID {
+		return false
+	}
+	if f.Collection != "" && m.Collection != f.Collection {
+		return false
+	}
+	return true
+}
+
+// Store persists file metadata alongside the blobs kept in storage.Storage.
+type Store interface {
+	Get(ctx context.Context, id string) (domain.FileMetadata, error)
+	Put(ctx context.Context, meta domain.FileMetadata) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, filter Filter) ([]domain.FileMetadata, error)
+}
+
+type AnnotationStore interface {
+	GetAnnotation(ctx context.Context, id string) (domain.Annotation, error)
+	PutAnnotation(ctx context.Context, annotation domain.Annotation) error
+	DeleteAnnotation(ctx context.Context, id string) error
+	ListAnnotations(ctx context.Context, fileID string) ([]domain.Annotation, error)
+}
+
+type AliasStore interface {
+	GetAlias(ctx context.Context, id string) (domain.Alias, error)
+	PutAlias(ctx context.Context, alias domain.Alias) error
+	DeleteAlias(ctx context.Context, id string) error
+	ListAliases(ctx context.Context, targetID string) ([]domain.Alias, error)
+}
+
+type ShortLinkStore interface {
+	GetShortLink(ctx context.Context, code string) (domain.ShortLink, error)
+	PutShortLink(ctx context.Context, link domain.ShortLink) error
+	DeleteShortLink(ctx context.Context, code string) error
+	ListShortLinks(ctx context.Context, fileID string) ([]domain.ShortLink, error)
+
+	// RecordShortLinkHit increments the hit counter of a link and returns
+	// the updated link.
+	RecordShortLinkHit(ctx context.Context, code string) (domain.ShortLink, error)
+}
+
+// SlugStore keeps slugs by organization and name.
+type SlugStore interface {
+	GetSlug(ctx context.Context, orgID, name string) (domain.Slug, error)
+	PutSlug(ctx context.Context, slug domain.Slug) error
+	DeleteSlug(ctx context.Context, orgID, name string) error
+	ListSlugs(ctx context.Context, orgID string) ([]domain.Slug, error)
+}
+
+type BrandingStore interface {
+	GetBranding(ctx context.Context, orgID string) (domain.Branding, error)
+	PutBranding(ctx context.Context, branding domain.Branding) error
+	DeleteBranding(ctx context.Context, orgID string) error
+	ListBrandings(ctx context.Context) ([]domain.Branding, error)
+}
+
+type FeedStore interface {
+	GetFeed(ctx context.Context, orgID string) (domain.Feed, error)
+	PutFeed(ctx context.Context, feed domain.Feed) error
+	DeleteFeed(ctx context.Context, orgID string) error
+	ListFeeds(ctx context.Context) ([]domain.Feed, error)
+}
+
+type QuotaStore interface {
+	GetQuota(ctx context.Context, orgID string) (domain.Quota, error)
+	PutQuota(ctx context.Context, quota domain.Quota) error
+	DeleteQuota(ctx context.Context, orgID string) error
+	ListQuotas(ctx context.Context) ([]domain.Quota, error)
+}
+
+// OrgStore keeps the organizations provisioned through the service and
+// the settings their admins change.
+type OrgStore interface {
+	GetOrg(ctx context.Context, id string) (domain.Org, error)
+	PutOrg(ctx context.Context, org domain.Org) error
+	ListOrgs(ctx context.Context) ([]domain.Org, error)
+
+	GetOrgSettings(ctx context.Context, orgID string) (domain.OrgSettings, error)
+	PutOrgSettings(ctx context.Context, settings domain.OrgSettings) error
+	DeleteOrgSettings(ctx context.Context, orgID string) error
+}
+
+type GeoRuleStore interface {
+	GetGeoRule(ctx context.Context, collection string) (domain.GeoRule, error)
+	PutGeoRule(ctx context.Context, rule domain.GeoRule) error
+	DeleteGeoRule(ctx context.Context, collection string) error
+	ListGeoRules(ctx context.Context) ([]domain.GeoRule, error)
+}
+
+// PresetStore keeps every version of every transcode preset. Versions are
+// only added, never changed.
+type PresetStore interface {
+	GetPreset(ctx context.Context, name string, version int) (domain.TranscodePreset, error)
+	PutPreset(ctx context.Context, preset domain.TranscodePreset) error
+	// ListPresets returns all versions, ordered by name and version.
+	ListPresets(ctx context.Context) ([]domain.TranscodePreset, error)
+}
+
+// MeteringStore keeps the processing usage of organizations.
+type MeteringStore interface {
+	// AddProcessingUsage adds usage to the totals of its organization,
+	// month and kind, starting them if need be.
+	AddProcessingUsage(ctx context.Context, usage domain.ProcessingUsage) error
+	// ListProcessingUsage returns the totals of an organization, ordered by
+	// month and kind.
+	ListProcessingUsage(ctx context.Context, orgID string) ([]domain.ProcessingUsage, error)
+}
+
+type JobFilter struct {
+	FileID string
+	Kind   string
+	Status domain.JobStatus
+}
+
+func (f JobFilter) Match(j domain.Job) bool {
+	if f.FileID != "" && j.FileID != f.FileID {
+		return false
+	}
+	if f.Kind != "" && j.Kind != f.Kind {
+		return false
+	}
+	if f.Status != "" && j.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+type JobStore interface {
+	GetJob(ctx context.Context, id string) (domain.Job, error)
+	PutJob(ctx context.Context, job domain.Job) error
+	DeleteJob(ctx context.Context, id string) error
+	ListJobs(ctx context.Context, filter JobFilter) ([]domain.Job, error)
+
+	// PutWorker announces a replica working on the queue, ListWorkers
+	// returns those announced and DeleteWorker removes one leaving.
+	PutWorker(ctx context.Context, worker domain.Worker) error
+	ListWorkers(ctx context.Context) ([]domain.Worker, error)
+	DeleteWorker(ctx context.Context, id string) error
+}
+
+type AuditFilter struct {
+	FileID  string
+	ActorID string
+	Action  string
+	Since   time.Time // Inclusive; zero for no lower bound
+	Until   time.Time // Exclusive; zero for no upper bound
+}
+
+func (f AuditFilter) Match(e domain.AuditEntry) bool {
+	if f.FileID != "" && e.FileID != f.FileID {
+		return false
+	}
+	if f.ActorID != "" && e.ActorID != f.ActorID {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && e.At.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.At.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditStore keeps the audit trail. It can only be appended to.
+type AuditStore interface {
+	AppendAudit(ctx context.Context, entry domain.AuditEntry) error
+
+	// ListAudit returns the matching entries, oldest first.
+	ListAudit(ctx context.Context, filter AuditFilter) ([]domain.AuditEntry, error)
+}
+
+// ReplicationStore keeps the writes to files waiting to be replicated to
+// peer regions and the revisions of deleted files.
+type ReplicationStore interface {
+	// QueueReplication records a write to be replicated to task.Region,
+	// replacing the write to the same file waiting for the region but
+	// keeping its QueuedAt.
+	QueueReplication(ctx context.Context, task domain.ReplicationTask) error
+	// CompleteReplication removes the write of revision to a file waiting
+	// for a region. A write queued since it was listed stays.
+	CompleteReplication(ctx context.Context, region, fileID string, revision domain.Revision) error
+	// ListReplication returns the writes waiting for a region, oldest
+	// first.
+	ListReplication(ctx context.Context, region string) ([]domain.ReplicationTask, error)
+
+	GetTombstone(ctx context.Context, fileID string) (domain.Tombstone, error)
+	PutTombstone(ctx context.Context, tombstone domain.Tombstone) error
+}
+
+// Backend groups the record kinds a metadata implementation provides.
+type Backend interface {
+	Store
+	AnnotationStore
+	AliasStore
+	ShortLinkStore
+	SlugStore
+	BrandingStore
+	FeedStore
+	QuotaStore
+	OrgStore
+	GeoRuleStore
+	PresetStore
+	MeteringStore
+	JobStore
+	AuditStore
+	ReplicationStore
+
+	// Ping checks that the backend is reachable and accepts writes.
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,162 @@
+// Package metering accounts the processing resources spent on files: the
+// CPU time of the external tools run on them and the scratch space their
+// work directories took, so that organizations can be billed for them.
+// In-process work, such as decoding images, is not metered.
+package metering
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var (
+	cpuSeconds = metrics.NewCounter("media_processing_cpu_seconds_total",
+		"CPU time spent processing files, by organization and job kind.", "org", "kind")
+	scratchBytes = metrics.NewCounter("media_processing_scratch_bytes_total",
+		"Scratch space taken while processing files, by organization and job kind.", "org", "kind")
+	meteredJobs = metrics.NewCounter("media_processing_jobs_total",
+		"Metered processing jobs, by organization and job kind.", "org", "kind")
+)
+
+// Usage is what processing consumed.
+type Usage struct {
+	CPU     time.Duration
+	Scratch int64 // Bytes
+}
+
+func (u Usage) IsZero() bool {
+	return u.CPU == 0 && u.Scratch == 0
+}
+
+// Meter adds up the usage of the processing done under a context.
+type Meter struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+type meterKey struct{}
+
+// Start returns a context whose processing is metered by the returned
+// meter rather than by one ctx already carries.
+func Start(ctx context.Context) (context.Context, *Meter) {
+	m := &Meter{}
+	return context.WithValue(ctx, meterKey{}, m), m
+}
+
+func (m *Meter) Usage() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+func add(ctx context.Context, u Usage) {
+	m, ok := ctx.Value(meterKey{}).(*Meter)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	m.usage.CPU += u.CPU
+	m.usage.Scratch += u.Scratch
+	m.mu.Unlock()
+}
+
+// Run runs cmd and meters the CPU time it took, whether it failed or not.
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	err := cmd.Run()
+	Record(ctx, cmd)
+	return err
+}
+
+// Record meters the CPU time of cmd once it has been waited for.
+func Record(ctx context.Context, cmd *exec.Cmd) {
+	if cmd.ProcessState == nil {
+		return
+	}
+	add(ctx, Usage{CPU: cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()})
+}
+
+// RemoveScratch removes a scratch file or work directory and meters the
+// bytes it held.
+func RemoveScratch(ctx context.Context, path string) {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	os.RemoveAll(path)
+	add(ctx, Usage{Scratch: size})
+}
+
+// Recorder bills usage to organizations: it keeps monthly totals per
+// organization and job kind and exports them as metrics. A nil Recorder
+// drops usage.
+type Recorder struct {
+	files  metadata.Store
+	store  metadata.MeteringStore
+	logger *slog.Logger
+}
+
+func NewRecorder(files metadata.Store, store metadata.MeteringStore, logger *slog.Logger) *Recorder {
+	return &Recorder{
+		files:  files,
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Record bills usage of a job of kind to orgID. Usage of files owned by no
+// organization is only exported.
+func (r *Recorder) Record(ctx context.Context, orgID, kind string, u Usage) {
+	if r == nil || u.IsZero() {
+		// Jobs that ran no tools cost next to nothing.
+		return
+	}
+	meteredJobs.Inc(orgID, kind)
+	cpuSeconds.Add(u.CPU.Seconds(), orgID, kind)
+	scratchBytes.Add(float64(u.Scratch), orgID, kind)
+	if orgID == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+	err := r.store.AddProcessingUsage(ctx, domain.ProcessingUsage{
+		OrgID:        orgID,
+		Month:        now.Format(domain.UsageMonth),
+		Kind:         kind,
+		Jobs:         1,
+		CPUSeconds:   u.CPU.Seconds(),
+		ScratchBytes: u.Scratch,
+		UpdatedAt:    now,
+	})
+	if err != nil {
+		r.logger.Error("Failed to record processing usage", "orgId", orgID, "kind", kind, "error", err)
+	}
+}
+
+// RecordFile bills usage of a job of kind on a file to the organization
+// owning it.
+func (r *Recorder) RecordFile(ctx context.Context, fileID, kind string, u Usage) {
+	if r == nil {
+		return
+	}
+	meta, err := r.files.Get(ctx, fileID)
+	if err != nil {
+		// Deleted since; the usage is billed to nobody.
+		r.logger.Warn("Failed to look up file for processing usage", "fileId", fileID, "kind", kind, "error", err)
+	}
+	r.Record(ctx, meta.OrgID, kind, u)
+}
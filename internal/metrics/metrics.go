@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A small Prometheus text-format registry. Metrics register themselves on
+// the package-level registry when created and are exposed by Handler.
+
+type collector interface {
+	write(b *strings.Builder)
+}
+
+var (
+	mu         sync.Mutex
+	collectors []collector
+)
+
+func register(c collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors = append(collectors, c)
+}
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(render()))
+	})
+}
+
+// Push puts all registered metrics to a Prometheus Pushgateway URL naming
+// the group, e.g. http://pushgateway:9091/metrics/job/media-service, for
+// the last values of a process that is not scraped again.
+func Push(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, strings.NewReader(render()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("pushgateway answered %s", resp.Status)
+	}
+	return nil
+}
+
+func render() string {
+	mu.Lock()
+	cs := append([]collector(nil), collectors...)
+	mu.Unlock()
+
+	var b strings.Builder
+	for _, c := range cs {
+		c.write(&b)
+	}
+	return b.String()
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) header(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+func (d desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, l := range d.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, l+"="+strconv.Quote(v))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series is a set of float values keyed by label values.
+type series struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func newSeries(kind, name, help string, labels []string) *series {
+	return &series{
+		desc:   desc{name: name, help: help, kind: kind, labels: labels},
+		values: make(map[string]float64),
+		labels: make(map[string][]string),
+	}
+}
+
+func (s *series) add(v float64, labelValues []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(labelValues)
+	s.values[k] += v
+	s.labels[k] = labelValues
+}
+
+func (s *series) set(v float64, labelValues []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(labelValues)
+	s.values[k] = v
+	s.labels[k] = labelValues
+}
+
+func (s *series) write(b *strings.Builder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.header(b)
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", s.name, s.labelString(s.labels[k]), formatFloat(s.values[k]))
+	}
+}
+
+type Counter struct{ s *series }
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{s: newSeries("counter", name, help, labels)}
+	register(c.s)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.s.add(1, labelValues) }
+
+func (c *Counter) Add(v float64, labelValues ...string) { c.s.add(v, labelValues) }
+
+type Gauge struct{ s *series }
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{s: newSeries("gauge", name, help, labels)}
+	register(g.s)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) { g.s.set(v, labelValues) }
+
+func (g *Gauge) Add(v float64, labelValues ...string) { g.s.add(v, labelValues) }
+
+// gaugeFunc is a gauge whose value is computed at scrape time.
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func (g *gaugeFunc) write(b *strings.Builder) {
+	g.header(b)
+	fmt.Fprintf(b, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// NewGaugeFunc registers a gauge that calls fn on every scrape.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&gaugeFunc{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := key(labelValues)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(b)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, h.labelString(s.labels, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, h.labelString(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, h.labelString(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, h.labelString(s.labels), s.count)
+	}
+}
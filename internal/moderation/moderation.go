@@ -0,0 +1,170 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/webhook"
+)
+
+var (
+	ErrNotQuarantined = errors.New("file is not quarantined")
+	ErrTakenDown      = errors.New("file is taken down")
+)
+
+var (
+	reviewLatency = metrics.NewHistogram("media_moderation_review_latency_seconds",
+		"Time between a file being quarantined and a moderator decision.",
+		[]float64{60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 72 * 3600}, "decision")
+	decisions = metrics.NewCounter("media_moderation_decisions_total",
+		"Moderator decisions on quarantined files.", "decision")
+)
+
+// Remover deletes files with everything referring to them, announcing
+// why; media.FileService is one.
+type Remover interface {
+	Remove(ctx context.Context, meta domain.FileMetadata, reason string) error
+}
+
+// Queue manages files withheld from serving until a moderator approves
+// them. Quarantined files stay in storage; rejection deletes them and
+// notifies the uploader.
+type Queue struct {
+	files    Remover
+	metadata metadata.Store
+	notifier *webhook.Notifier
+	audit    *audit.Trail
+	logger   *slog.Logger
+}
+
+func NewQueue(files Remover, metadata metadata.Store, notifier *webhook.Notifier, trail *audit.Trail, logger *slog.Logger) *Queue {
+	q := &Queue{
+		files:    files,
+		metadata: metadata,
+		notifier: notifier,
+		audit:    trail,
+		logger:   logger,
+	}
+
+	metrics.NewGaugeFunc("media_moderation_queue_depth", "Files currently awaiting moderation.", func() float64 {
+		pending, err := q.Pending(context.Background())
+		if err != nil {
+			return 0
+		}
+		return float64(len(pending))
+	})
+
+	return q
+}
+
+// Quarantine marks meta as pending review. It does not persist meta, so it
+// can be applied before a new upload's metadata is first stored. Files
+// taken down are left as they are: approving them would lift the takedown,
+// which only restoring them through the takedown desk may do.
+func Quarantine(meta *domain.FileMetadata, reason, flaggedBy string) error {
+	if meta.Status == domain.FileStatusTakenDown {
+		return ErrTakenDown
+	}
+	meta.Status = domain.FileStatusQuarantined
+	meta.Quarantine = &domain.Quarantine{
+		Reason:    reason,
+		FlaggedBy: flaggedBy,
+		FlaggedAt: time.Now().UTC(),
+	}
+	return nil
+}
+
+func (q *Queue) Pending(ctx context.Context) ([]domain.FileMetadata, error) {
+	return q.metadata.List(ctx, metadata.Filter{Status: domain.FileStatusQuarantined})
+}
+
+// Flag moves an existing file into the review queue. Files taken down are
+// refused with ErrTakenDown.
+func (q *Queue) Flag(ctx context.Context, fileID, reason, flaggedBy string) error {
+	meta, err := q.metadata.Get(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if meta.Status == domain.FileStatusQuarantined {
+		return nil
+	}
+
+	if err := Quarantine(&meta, reason, flaggedBy); err != nil {
+		return err
+	}
+	if err := q.metadata.Put(ctx, meta); err != nil {
+		return fmt.Errorf("failed to quarantine file: %w", err)
+	}
+
+	q.logger.Info("File quarantined", "fileId", fileID, "reason", reason, "flaggedBy", flaggedBy)
+	q.audit.Record(ctx, domain.AuditFlag, fileID, map[string]string{"reason": reason})
+	return nil
+}
+
+func (q *Queue) Approve(ctx context.Context, fileID, moderatorID string) (domain.FileMetadata, error) {
+	meta, err := q.quarantined(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	flaggedAt := meta.Quarantine.FlaggedAt
+	meta.Status = domain.FileStatusActive
+	meta.Quarantine = nil
+	if err := q.metadata.Put(ctx, meta); err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to approve file: %w", err)
+	}
+
+	q.recordDecision("approved", flaggedAt)
+	q.logger.Info("Quarantined file approved", "fileId", fileID, "moderator", moderatorID)
+	q.audit.Record(ctx, domain.AuditApprove, fileID, nil)
+	return meta, nil
+}
+
+func (q *Queue) Reject(ctx context.Context, fileID, reason, moderatorID string) error {
+	meta, err := q.quarantined(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	// The file stays quarantined if it cannot be removed in full.
+	if err := q.files.Remove(ctx, meta, "rejected"); err != nil {
+		return fmt.Errorf("failed to delete rejected file: %w", err)
+	}
+
+	q.recordDecision("rejected", meta.Quarantine.FlaggedAt)
+	q.logger.Info("Quarantined file rejected", "fileId", fileID, "moderator", moderatorID, "reason", reason)
+	q.audit.Record(ctx, domain.AuditReject, fileID, map[string]string{"reason": reason, "originalName": meta.OriginalName, "ownerId": meta.OwnerID})
+
+	q.notifier.Notify(webhook.Event{
+		Type:   "file.rejected",
+		FileID: fileID,
+		UserID: meta.OwnerID,
+		OrgID:  meta.OrgID,
+		Reason: reason,
+		Data:   map[string]any{"originalName": meta.OriginalName},
+	})
+	return nil
+}
+
+func (q *Queue) quarantined(ctx context.Context, fileID string) (domain.FileMetadata, error) {
+	meta, err := q.metadata.Get(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if meta.Status != domain.FileStatusQuarantined || meta.Quarantine == nil {
+		return domain.FileMetadata{}, ErrNotQuarantined
+	}
+	return meta, nil
+}
+
+func (q *Queue) recordDecision(decision string, flaggedAt time.Time) {
+	decisions.Inc(decision)
+	reviewLatency.Observe(time.Since(flaggedAt).Seconds(), decision)
+}
@@ -0,0 +1,115 @@
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entitlements asks an external entitlement service for the plan of an
+// organization: GET <url>/<orgId> answered with {"plan": "pro"}, or 404
+// for organizations it does not know. Answers are cached. Entitlements
+// without a URL are disabled.
+type Entitlements struct {
+	url        string
+	httpClient *http.Client
+	cache      *cache
+}
+
+func NewEntitlements(baseURL string, cacheTTL time.Duration) *Entitlements {
+	return &Entitlements{
+		url:        strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		cache:      &cache{ttl: cacheTTL, entries: make(map[string]cached)},
+	}
+}
+
+func (e *Entitlements) Enabled() bool {
+	return e != nil && e.url != ""
+}
+
+// Plan returns the name of the plan of an organization, or "" if the
+// service does not know it.
+func (e *Entitlements) Plan(ctx context.Context, orgID string) (string, error) {
+	now := time.Now()
+	if plan, ok := e.cache.get(orgID, now); ok {
+		return plan, nil
+	}
+	plan, err := e.fetch(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	e.cache.put(orgID, plan, now)
+	return plan, nil
+}
+
+func (e *Entitlements) fetch(ctx context.Context, orgID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url+"/"+url.PathEscape(orgID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("entitlement service returned %s", resp.Status)
+	}
+	var body struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid entitlement response: %w", err)
+	}
+	if body.Plan == "" {
+		return "", errors.New("entitlement response names no plan")
+	}
+	return body.Plan, nil
+}
+
+// cache holds the plans the entitlement service named for a while.
+type cache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cached
+}
+
+type cached struct {
+	plan    string
+	expires time.Time
+}
+
+func (c *cache) get(orgID string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[orgID]
+	if !ok || now.After(e.expires) {
+		return "", false
+	}
+	return e.plan, true
+}
+
+func (c *cache) put(orgID, plan string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[orgID] = cached{plan: plan, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,54 @@
+package plans
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var rateLimited = metrics.NewCounter("media_plan_rate_limited_total",
+	"Requests rejected by the rate limit of an organization's plan.")
+
+// Limiter is a token bucket per organization. Unlike the widget limiter,
+// the rate is that of the plan of each organization, given with every
+// request, so that a change of plan takes effect at once.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a request from key's bucket, which holds up to burst
+// requests and refills at perMinute. When the bucket is empty it returns
+// false and how long until the next request would be allowed.
+func (l *Limiter) Allow(key string, perMinute, burst int, now time.Time) (bool, time.Duration) {
+	rate, size := float64(perMinute)/60, float64(max(burst, 1))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: size, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(size, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		rateLimited.Inc()
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
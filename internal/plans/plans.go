@@ -0,0 +1,128 @@
+// Package plans resolves the plan of an organization, such as free, pro or
+// enterprise, and the limits and features that come with it. Plans are
+// assigned in the configuration or by an external entitlement service.
+package plans
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Features a plan may include. Plans listing no features include all.
+const (
+	FeatureVideo = "video" // Video uploads, transcoding and streaming
+	FeatureAudio = "audio" // Audio uploads and their processing
+	FeatureOCR   = "ocr"   // Text recognition in scanned images
+)
+
+// Features are the features plans may list.
+var Features = []string{FeatureAudio, FeatureOCR, FeatureVideo}
+
+// Plan is what an organization on it may store and do. Zero limits fall
+// back to the defaults of the service.
+type Plan struct {
+	Name        string
+	MaxBytes    int64    // Bytes of originals stored
+	MaxFiles    int64    // Files stored
+	MaxFileSize int64    // Size of a single upload
+	Features    []string // Nil for all features
+
+	RatePerMinute int // Uploads and downloads of the organization's files per minute; 0 is unlimited
+	Burst         int // Requests allowed at once above the rate
+}
+
+// Allows reports whether the plan includes feature.
+func (p Plan) Allows(feature string) bool {
+	return p.Features == nil || slices.Contains(p.Features, feature)
+}
+
+// Resolver finds the plan of an organization: the one assigned to it in
+// the configuration, else the one the entitlement service names, else the
+// default plan. A nil Resolver resolves every organization to the zero
+// Plan, which limits nothing.
+type Resolver struct {
+	plans        map[string]Plan
+	assigned     map[string]string
+	fallback     string
+	entitlements *Entitlements
+	limiter      *Limiter
+	logger       *slog.Logger
+}
+
+// NewResolver resolves organizations to plans. assigned maps organization
+// IDs to plan names and fallback names the plan of the others; either may
+// name a plan missing from plans, which limits nothing. entitlements may
+// be nil.
+func NewResolver(plans map[string]Plan, assigned map[string]string, fallback string, entitlements *Entitlements, logger *slog.Logger) *Resolver {
+	return &Resolver{
+		plans:        plans,
+		assigned:     assigned,
+		fallback:     fallback,
+		entitlements: entitlements,
+		limiter:      NewLimiter(),
+		logger:       logger,
+	}
+}
+
+// Plan returns the plan of an organization. Files outside organizations
+// are under the default plan, as are organizations while the entitlement
+// service fails.
+func (r *Resolver) Plan(ctx context.Context, orgID string) Plan {
+	if r == nil {
+		return Plan{}
+	}
+	name := r.fallback
+	if assigned, ok := r.assigned[orgID]; ok {
+		name = assigned
+	} else if orgID != "" && r.entitlements.Enabled() {
+		entitled, err := r.entitlements.Plan(ctx, orgID)
+		if err != nil {
+			r.logger.Warn("Failed to resolve plan, using the default", "orgId", orgID, "plan", r.fallback, "error", err)
+		}
+		if entitled != "" {
+			name = entitled
+		}
+	}
+	plan, ok := r.plans[name]
+	if !ok {
+		return Plan{Name: name}
+	}
+	return plan
+}
+
+// Tier returns the name of the plan of an organization. It ranks the
+// processing jobs of organizations by plan.
+func (r *Resolver) Tier(ctx context.Context, orgID string) string {
+	return r.Plan(ctx, orgID).Name
+}
+
+// Allow takes a request of an organization from the rate limit of its
+// plan. It returns a *RateLimitedError when the organization is over it.
+// Files outside organizations are not limited.
+func (r *Resolver) Allow(ctx context.Context, orgID string) error {
+	if orgID == "" {
+		return nil
+	}
+	plan := r.Plan(ctx, orgID)
+	if plan.RatePerMinute <= 0 {
+		return nil
+	}
+	if ok, wait := r.limiter.Allow(orgID, plan.RatePerMinute, plan.Burst, time.Now()); !ok {
+		return &RateLimitedError{Plan: plan.Name, RetryAfter: wait}
+	}
+	return nil
+}
+
+// RateLimitedError is returned for requests of an organization over the
+// rate limit of its plan.
+type RateLimitedError struct {
+	Plan       string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit of the %s plan exceeded, retry in %s", e.Plan, e.RetryAfter)
+}
@@ -0,0 +1,255 @@
+// Package qc runs automated quality checks over video and audio with
+// ffmpeg: silence at the start or end, black stretches and streams that
+// fail to decode.
+package qc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// Checks that can fail.
+const (
+	LeadingSilence  = "leading_silence"
+	TrailingSilence = "trailing_silence"
+	BlackFrames     = "black_frames"
+	CorruptStream   = "corrupt_stream"
+)
+
+// maxErrors bounds the decoder errors kept in a corrupt stream finding.
+const maxErrors = 5
+
+// Thresholds decide what fails a check.
+type Thresholds struct {
+	MaxSilence   time.Duration // Leading or trailing silence at least this long fails
+	SilenceNoise float64       // dBFS below which audio counts as silent
+	MaxBlack     time.Duration // Black stretches at least this long fail
+}
+
+// Finding is a failed check. Start and End are in seconds; both are 0 for
+// findings about the whole file.
+type Finding struct {
+	Check  string
+	Start  float64
+	End    float64
+	Detail string
+}
+
+// Checker decodes files with ffmpeg to check them. When the tool is not
+// installed checking is reported as unsupported.
+type Checker struct {
+	path       string
+	thresholds Thresholds
+}
+
+// NewChecker looks up ffmpeg at path.
+func NewChecker(path string, thresholds Thresholds) *Checker {
+	c := &Checker{thresholds: thresholds}
+	if p, err := exec.LookPath(path); err == nil {
+		c.path = p
+	}
+	return c
+}
+
+func (c *Checker) Supported() bool {
+	return c != nil && c.path != ""
+}
+
+// Cost estimates the memory a check holds: a single decoder thread and the
+// frames the detection filters look at.
+func (c *Checker) Cost() int64 {
+	return 128 << 20
+}
+
+// Check decodes the file read from src in full and returns the checks it
+// fails; none means it passed. Black frames are only looked for in video.
+func (c *Checker) Check(ctx context.Context, src io.Reader, video bool) ([]Finding, error) {
+	if !c.Supported() {
+		return nil, fmt.Errorf("quality checks not supported")
+	}
+
+	workDir, err := os.MkdirTemp("", "media-qc-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return nil, err
+	}
+
+	// The level prefix tells decoder errors apart from the detectors'
+	// reports, which are logged at info.
+	args := []string{"-nostdin", "-hide_banner", "-nostats", "-loglevel", "level+info",
+		"-threads", "1",
+		"-i", in,
+		"-map", "0:a:0?",
+		"-af", fmt.Sprintf("silencedetect=noise=%gdB:d=%g", c.thresholds.SilenceNoise, c.thresholds.MaxSilence.Seconds()),
+	}
+	if video {
+		args = append(args, "-map", "0:v:0?",
+			"-vf", fmt.Sprintf("blackdetect=d=%g:pix_th=0.10", c.thresholds.MaxBlack.Seconds()))
+	}
+	cmd := exec.CommandContext(ctx, c.path, append(args, "-f", "null", "-")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	runErr := metering.Run(ctx, cmd)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	findings := c.parse(&stderr)
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		// ffmpeg gives up on files it cannot read at all.
+		findings = append(findings, Finding{Check: CorruptStream, Detail: "the file could not be decoded: " + lastLine(stderr.Bytes())})
+	} else if runErr != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", runErr)
+	}
+	return findings, nil
+}
+
+// parse reads the findings from ffmpeg's log.
+func (c *Checker) parse(log io.Reader) []Finding {
+	var (
+		findings     []Finding
+		errs         []string
+		duration     float64
+		silenceStart = -1.0
+	)
+	scanner := bufio.NewScanner(log)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, "[error]") || strings.Contains(line, "[fatal]"):
+			if len(errs) < maxErrors {
+				errs = append(errs, message(line))
+			}
+		case strings.Contains(line, "Duration: "):
+			if d, ok := parseClock(field(line, "Duration: ")); ok && duration == 0 {
+				duration = d
+			}
+		case strings.Contains(line, "silence_start:"):
+			silenceStart, _ = strconv.ParseFloat(field(line, "silence_start:"), 64)
+		case strings.Contains(line, "silence_end:"):
+			end, _ := strconv.ParseFloat(field(line, "silence_end:"), 64)
+			if f, ok := c.silence(silenceStart, end, duration); ok {
+				findings = append(findings, f)
+			}
+			silenceStart = -1
+		case strings.Contains(line, "black_start:"):
+			start, _ := strconv.ParseFloat(field(line, "black_start:"), 64)
+			end, _ := strconv.ParseFloat(field(line, "black_end:"), 64)
+			findings = append(findings, Finding{
+				Check:  BlackFrames,
+				Start:  start,
+				End:    end,
+				Detail: fmt.Sprintf("%.1fs of black", end-start),
+			})
+		}
+	}
+	// Silence running to the end is not always closed by a silence_end.
+	if silenceStart >= 0 && duration > 0 {
+		if f, ok := c.silence(silenceStart, duration, duration); ok {
+			findings = append(findings, f)
+		}
+	}
+	if len(errs) > 0 {
+		findings = append(findings, Finding{Check: CorruptStream, Detail: strings.Join(errs, "; ")})
+	}
+	return findings
+}
+
+// silenceSlack is how close to the start or end silence must reach to
+// count as leading or trailing.
+const silenceSlack = 0.05
+
+// silence reports a silent stretch that starts or ends the file. Silence
+// in between is left alone, as pauses are part of most recordings.
+func (c *Checker) silence(start, end, duration float64) (Finding, bool) {
+	if start < 0 || end-start < c.thresholds.MaxSilence.Seconds() {
+		return Finding{}, false
+	}
+	detail := fmt.Sprintf("%.1fs of silence", end-start)
+	switch {
+	case start <= silenceSlack:
+		return Finding{Check: LeadingSilence, Start: start, End: end, Detail: detail}, true
+	case duration > 0 && end >= duration-silenceSlack:
+		return Finding{Check: TrailingSilence, Start: start, End: end, Detail: detail}, true
+	}
+	return Finding{}, false
+}
+
+// field returns the value following key in a log line, up to the next
+// space or comma.
+func field(line, key string) string {
+	_, rest, _ := strings.Cut(line, key)
+	rest = strings.TrimSpace(rest)
+	if i := strings.IndexAny(rest, " ,|"); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest
+}
+
+// parseClock parses an HH:MM:SS.ss duration into seconds.
+func parseClock(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	var seconds float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, true
+}
+
+// message strips the level and context prefixes off a log line.
+func message(line string) string {
+	for strings.HasPrefix(line, "[") {
+		i := strings.IndexByte(line, ']')
+		if i < 0 {
+			break
+		}
+		line = strings.TrimSpace(line[i+1:])
+	}
+	return line
+}
+
+func lastLine(log []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	return message(lines[len(lines)-1])
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = bufpool.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Package region knows the region a deployment runs in and the
+// deployments of the other regions, its peers, which serve the files
+// homed in their region.
+package region
+
+import "net/url"
+
+// Modes of sending a download of a file homed in another region to the
+// peer of that region: redirect the client there, or fetch the file from
+// it and pass it on.
+const (
+	ModeRedirect = "redirect"
+	ModeProxy    = "proxy"
+)
+
+var Modes = []string{ModeProxy, ModeRedirect}
+
+// ForwardedHeader names the region of the deployment that proxied a
+// request. Forwarded requests are served where they arrive rather than
+// sent on again, so that peers that disagree on the home of a file do not
+// pass a request back and forth.
+const ForwardedHeader = "X-Media-Forwarded-From"
+
+// Router finds the peer serving the files of a region. A nil Router, as
+// in deployments outside a multi-region setup, serves every file itself.
+type Router struct {
+	local string
+	peers map[string]*url.URL
+	mode  string
+}
+
+// NewRouter places the deployment in region local, with peers the base
+// URLs of the deployments of other regions by region. mode is one of
+// Modes, ModeRedirect if empty.
+func NewRouter(local string, peers map[string]*url.URL, mode string) *Router {
+	if mode == "" {
+		mode = ModeRedirect
+	}
+	return &Router{local: local, peers: peers, mode: mode}
+}
+
+// Local returns the region of the deployment, recorded as the home of the
+// files uploaded to it; "" for a nil Router.
+func (r *Router) Local() string {
+	if r == nil {
+		return ""
+	}
+	return r.local
+}
+
+func (r *Router) Mode() string {
+	return r.mode
+}
+
+// Peer returns the base URL of the deployment serving the files homed in
+// region. It returns false for files served here: those homed in this
+// region, in none, or in one without a peer.
+func (r *Router) Peer(region string) (*url.URL, bool) {
+	if r == nil || region == "" || region == r.local {
+		return nil, false
+	}
+	peer, ok := r.peers[region]
+	return peer, ok
+}
@@ -0,0 +1,235 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/checksum"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+var (
+	applied = metrics.NewCounter("media_replication_applied_total",
+		"Writes replicated from peer regions, by region and outcome: applied, or ignored as older than the local write.", "region", "outcome")
+	conflicts = metrics.NewCounter("media_replication_conflicts_total",
+		"Replicated writes that conflicted with a local write, by the rule that settled them.", "rule")
+)
+
+// Change is a write to a file replicated from the region that made it.
+// File is the metadata written, nil for deletions.
+type Change struct {
+	FileID   string               `json:"fileId"`
+	Deleted  bool                 `json:"deleted"`
+	Revision domain.Revision      `json:"revision"`
+	File     *domain.FileMetadata `json:"file,omitempty"`
+}
+
+// Outcome is what became of a replicated write.
+type Outcome struct {
+	Applied  bool `json:"applied"`  // The write replaced the local state of the file
+	Conflict bool `json:"conflict"` // It was made without seeing the local write
+}
+
+// Apply applies a write replicated from region to the file, unless the
+// local state of the file follows it. The content of written files is
+// fetched from region when it differs from the local one, before the
+// metadata is stored. Conflicts are settled by the configured rule and
+// the outcome replicated to all peers, so that every region settles on it.
+func (r *Replicator) Apply(ctx context.Context, region string, change Change) (Outcome, error) {
+	if change.FileID == "" || (!change.Deleted && (change.File == nil || change.File.ID != change.FileID)) {
+		return Outcome{}, ErrInvalid
+	}
+
+	var local *domain.FileMetadata
+	var current domain.Revision
+	if meta, err := r.backend.Get(ctx, change.FileID); err == nil {
+		local = &meta
+		if meta.Revision != nil {
+			current = *meta.Revision
+		}
+	} else if tombstone, err := r.backend.GetTombstone(ctx, change.FileID); err == nil {
+		current = tombstone.Revision
+	} else if !errors.Is(err, metadata.ErrNotFound) {
+		return Outcome{}, err
+	}
+
+	wins, conflict := r.wins(change.Revision, current)
+	if conflict {
+		conflicts.Inc(r.conflicts)
+		r.logger.Warn("Replicated write conflicts with a local one", "fileId", change.FileID, "region", region, "wins", wins)
+	}
+	revision := change.Revision
+	if conflict {
+		// The outcome follows both writes, so no region takes either for
+		// newer.
+		revision = change.Revision.Merge(current)
+	}
+
+	switch {
+	case !wins && !conflict:
+		applied.Inc(region, "ignored")
+		return Outcome{}, nil
+	case !wins:
+		// The local write stays, under a revision following the other.
+		if err := r.keep(ctx, change.FileID, local, revision); err != nil {
+			return Outcome{}, err
+		}
+	case change.Deleted:
+		if err := r.delete(ctx, change.FileID, local, revision); err != nil {
+			return Outcome{}, err
+		}
+	default:
+		meta := *change.File
+		meta.Revision = &revision
+		if meta.Provenance == nil {
+			// Written before provenance was recorded; all this region knows
+			// is that the file came from region.
+			meta.Provenance = &domain.Provenance{Channel: domain.ChannelReplication, From: region, At: time.Now().UTC()}
+		}
+		if err := r.write(ctx, region, meta, local); err != nil {
+			return Outcome{}, err
+		}
+	}
+
+	if conflict {
+		deleted := change.Deleted
+		if !wins {
+			deleted = local == nil
+		}
+		r.queue(ctx, change.FileID, deleted, revision, "")
+	}
+	if wins {
+		applied.Inc(region, "applied")
+	}
+	return Outcome{Applied: wins, Conflict: conflict}, nil
+}
+
+// wins reports whether a replicated write of incoming replaces the local
+// state of current, and whether the two conflict.
+func (r *Replicator) wins(incoming, current domain.Revision) (bool, bool) {
+	if r.conflicts == LastWriterWins {
+		return incoming.Later(current), false
+	}
+	switch incoming.Compare(current) {
+	case domain.RevisionAfter:
+		return true, false
+	case domain.RevisionConcurrent:
+		return incoming.Later(current), true
+	}
+	return false, false
+}
+
+// keep stores the local state of a file under revision.
+func (r *Replicator) keep(ctx context.Context, fileID string, local *domain.FileMetadata, revision domain.Revision) error {
+	if local == nil {
+		return r.backend.PutTombstone(ctx, domain.Tombstone{FileID: fileID, Revision: revision})
+	}
+	meta := *local
+	meta.Revision = &revision
+	return r.backend.Put(ctx, meta)
+}
+
+func (r *Replicator) delete(ctx context.Context, fileID string, local *domain.FileMetadata, revision domain.Revision) error {
+	if local != nil {
+		if err := r.storage.Delete(ctx, fileID); err != nil {
+			r.logger.Warn("Failed to delete replicated file from storage", "fileId", fileID, "error", err)
+		}
+		if err := r.backend.Delete(ctx, fileID); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return err
+		}
+	}
+	return r.backend.PutTombstone(ctx, domain.Tombstone{FileID: fileID, Revision: revision})
+}
+
+// write stores meta, with its content fetched from region unless the
+// local content is the same.
+func (r *Replicator) write(ctx context.Context, region string, meta domain.FileMetadata, local *domain.FileMetadata) error {
+	if local != nil && local.Directory != meta.Directory {
+		info, err := r.storage.Move(ctx, meta.ID, meta.Directory)
+		if err != nil {
+			return fmt.Errorf("failed to move replicated file: %w", err)
+		}
+		local.Path, local.Directory = info.Path, info.Directory
+	}
+
+	sum := meta.Checksums[checksum.SHA256]
+	if local != nil && sum != "" && local.Checksums[checksum.SHA256] == sum {
+		meta.Path = local.Path
+		return r.backend.Put(ctx, meta)
+	}
+
+	body, err := r.fetch(ctx, region, meta.ID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	opts := storage.SaveOptions{
+		ID:           meta.ID,
+		Directory:    meta.Directory,
+		ContentType:  meta.ContentType,
+		OriginalName: meta.OriginalName,
+	}
+	// Content may also be stored without metadata, left by a write that
+	// failed half way.
+	exists := false
+	if rc, _, err := r.storage.Open(ctx, meta.ID); err == nil {
+		rc.Close()
+		exists = true
+	}
+	var info storage.FileInfo
+	if exists {
+		// Derivatives of the previous content are generated anew.
+		info, err = r.storage.Replace(ctx, body, opts, func(string) bool { return false })
+	} else {
+		info, err = r.storage.Save(ctx, body, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store replicated content: %w", err)
+	}
+	if stored := info.Checksums[checksum.SHA256]; sum != "" && stored != "" && stored != sum {
+		// The sender changed the file meanwhile; its next write brings the
+		// new content along.
+		if local == nil {
+			r.storage.Delete(ctx, meta.ID)
+		}
+		return fmt.Errorf("%w: content of %s changed while replicated", ErrPeerUnavailable, meta.ID)
+	}
+	meta.Path = info.Path
+	return r.backend.Put(ctx, meta)
+}
+
+// fetch opens the content of a file at the peer of region.
+func (r *Replicator) fetch(ctx context.Context, region, fileID string) (io.ReadCloser, error) {
+	p, ok := r.peers[region]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url.JoinPath("/v1/replication/files", url.PathEscape(fileID), "content").String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.sign(req, nil)
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPeerUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: content of %s answered with %s", ErrPeerUnavailable, fileID, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Content opens the content of a file for a peer fetching it.
+func (r *Replicator) Content(ctx context.Context, fileID string) (io.ReadSeekCloser, storage.FileInfo, error) {
+	return r.storage.Open(ctx, fileID)
+}
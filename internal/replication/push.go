@@ -0,0 +1,241 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var (
+	sent = metrics.NewCounter("media_replication_sent_total",
+		"Writes sent to peer regions, by region and result: ok, rejected by the peer, or failed to reach it.", "region", "result")
+	pending = metrics.NewGauge("media_replication_pending",
+		"Writes waiting to be replicated, by peer region.", "region")
+	lag = metrics.NewGauge("media_replication_lag_seconds",
+		"Age of the oldest write waiting to be replicated, by peer region.", "region")
+)
+
+const (
+	// sendTimeout bounds a write sent to a peer, including the peer
+	// fetching its content.
+	sendTimeout = 10 * time.Minute
+
+	// maxBackoff is the longest a peer that cannot be reached is left
+	// alone before the next attempt.
+	maxBackoff = 5 * time.Minute
+)
+
+// peer is the deployment of another region and how replicating to it
+// fares.
+type peer struct {
+	region string
+	url    *url.URL
+	wake   chan struct{}
+
+	mu          sync.Mutex
+	failures    int
+	retryAt     time.Time
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// notify wakes the sender of the peer without waiting for it.
+func (p *peer) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *peer) succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures, p.retryAt, p.lastSuccess = 0, time.Time{}, time.Now().UTC()
+}
+
+// failed backs off from a peer that cannot be reached, doubling the wait
+// with every failure in a row.
+func (p *peer) failed(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures++
+	p.retryAt = time.Now().Add(min(time.Second<<min(p.failures, 16), maxBackoff))
+	p.lastError, p.lastErrorAt = err.Error(), time.Now().UTC()
+}
+
+func (p *peer) due() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !time.Now().Before(p.retryAt)
+}
+
+// Run sends the waiting writes to every peer until ctx is done: as soon as
+// they are queued, and every interval for the ones that failed.
+func (r *Replicator) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range r.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(r.interval)
+			defer ticker.Stop()
+			for {
+				if p.due() {
+					r.push(ctx, p)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				case <-p.wake:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// push sends the writes waiting for a peer, oldest first, until one fails
+// to reach it.
+func (r *Replicator) push(ctx context.Context, p *peer) {
+	tasks, err := r.backend.ListReplication(ctx, p.region)
+	if err != nil {
+		r.logger.Error("Failed to list writes to replicate", "region", p.region, "error", err)
+		return
+	}
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		err := r.send(ctx, p, task)
+		var rejected *rejectedError
+		switch {
+		case errors.As(err, &rejected):
+			// Retried with the next round, without holding up the others.
+			sent.Inc(p.region, "rejected")
+			r.logger.Warn("Peer region rejected replicated write", "fileId", task.FileID, "region", p.region, "error", err)
+			continue
+		case err != nil:
+			sent.Inc(p.region, "failed")
+			p.failed(err)
+			r.logger.Warn("Failed to replicate write", "fileId", task.FileID, "region", p.region, "error", err)
+			return
+		}
+		sent.Inc(p.region, "ok")
+		p.succeeded()
+		if err := r.backend.CompleteReplication(ctx, p.region, task.FileID, task.Revision); err != nil {
+			r.logger.Error("Failed to complete replicated write", "fileId", task.FileID, "region", p.region, "error", err)
+		}
+	}
+}
+
+// rejectedError is a write a peer refused, as opposed to one that did not
+// reach it.
+type rejectedError struct {
+	status string
+	detail string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("peer answered %s: %s", e.status, e.detail)
+}
+
+func (r *Replicator) send(ctx context.Context, p *peer, task domain.ReplicationTask) error {
+	change := Change{FileID: task.FileID, Deleted: task.Deleted, Revision: task.Revision}
+	if !task.Deleted {
+		meta, err := r.backend.Get(ctx, task.FileID)
+		if errors.Is(err, metadata.ErrNotFound) {
+			// Deleted since; the deletion is queued in its place.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		change.File = &meta
+		if meta.Revision != nil {
+			change.Revision = *meta.Revision
+		}
+	}
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.url.JoinPath("/v1/replication/files", url.PathEscape(task.FileID)).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r.sign(req, body)
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusNotFound:
+		// Unavailable, or not set up for replication with this region yet.
+		return fmt.Errorf("peer answered %s", resp.Status)
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return &rejectedError{status: resp.Status, detail: string(detail)}
+}
+
+// PeerStatus is how replicating to a peer region fares.
+type PeerStatus struct {
+	Region      string
+	URL         string
+	Pending     int           // Writes waiting
+	Lag         time.Duration // Age of the oldest write waiting; 0 when none are
+	Healthy     bool          // Lag within the maximum
+	LastSuccess time.Time     // Of a write sent; zero if none was since the start
+	LastError   string
+	LastErrorAt time.Time
+	RetryAt     time.Time // When writes are next sent to a peer that could not be reached
+}
+
+// Status reports how replicating to each peer fares, sorted by region.
+func (r *Replicator) Status(ctx context.Context) ([]PeerStatus, error) {
+	statuses := make([]PeerStatus, 0, len(r.peers))
+	for _, region := range r.Peers() {
+		p := r.peers[region]
+		tasks, err := r.backend.ListReplication(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		status := PeerStatus{Region: region, URL: p.url.String(), Pending: len(tasks)}
+		if len(tasks) > 0 {
+			status.Lag = time.Since(tasks[0].QueuedAt)
+		}
+		status.Healthy = r.maxLag <= 0 || status.Lag <= r.maxLag
+
+		p.mu.Lock()
+		status.LastSuccess, status.LastError, status.LastErrorAt = p.lastSuccess, p.lastError, p.lastErrorAt
+		if p.failures > 0 {
+			status.RetryAt = p.retryAt
+		}
+		p.mu.Unlock()
+
+		pending.Set(float64(status.Pending), region)
+		lag.Set(status.Lag.Seconds(), region)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
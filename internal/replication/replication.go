@@ -0,0 +1,202 @@
+// Package replication copies files asynchronously to the deployments of
+// the other regions, so that each region can serve every file while
+// another is down. Writes to file metadata are stamped with a revision and
+// queued for every peer; a peer receiving a write fetches the content it
+// lacks from the sender and settles writes that conflict by the configured
+// rule.
+package replication
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+// Rules for writes to a file made in two regions without either seeing
+// the other: the later write by the clock wins, or the version vectors of
+// the writes tell the conflicts from writes made one after the other,
+// which the later write wins by the clock only then.
+const (
+	LastWriterWins = "lww"
+	VersionVector  = "vector"
+)
+
+var ConflictRules = []string{LastWriterWins, VersionVector}
+
+type Config struct {
+	Region    string              // Region of this deployment
+	Peers     map[string]*url.URL // Base URLs of the deployments of the other regions, by region
+	Secret    string              // Shared by all regions; signs their requests to each other
+	Conflicts string              // One of ConflictRules
+	Interval  time.Duration       // How often waiting writes are sent when not woken earlier
+	MaxLag    time.Duration       // Age of the oldest waiting write beyond which a peer is unhealthy
+}
+
+// Replicator replicates the files of this region to its peers and applies
+// the writes they replicate to it.
+type Replicator struct {
+	backend    metadata.Backend // Not recording writes
+	storage    storage.Storage
+	region     string
+	peers      map[string]*peer
+	secret     []byte
+	nonces     *nonceCache
+	conflicts  string
+	interval   time.Duration
+	maxLag     time.Duration
+	httpClient *http.Client
+	logger     *slog.Logger
+}
+
+func NewReplicator(backend metadata.Backend, storage storage.Storage, cfg Config, logger *slog.Logger) *Replicator {
+	r := &Replicator{
+		backend:    backend,
+		storage:    storage,
+		region:     cfg.Region,
+		peers:      make(map[string]*peer, len(cfg.Peers)),
+		secret:     []byte(cfg.Secret),
+		nonces:     newNonceCache(),
+		conflicts:  cfg.Conflicts,
+		interval:   cfg.Interval,
+		maxLag:     cfg.MaxLag,
+		httpClient: &http.Client{},
+		logger:     logger,
+	}
+	for region, base := range cfg.Peers {
+		r.peers[region] = &peer{region: region, url: base, wake: make(chan struct{}, 1)}
+	}
+	return r
+}
+
+// Store returns the metadata backend with the writes to files recorded for
+// replication. Services write through it; the writes replicated from
+// peers are applied to the backend directly.
+func (r *Replicator) Store() metadata.Backend {
+	return &recordingStore{Backend: r.backend, r: r}
+}
+
+// recordingStore stamps the writes to files made in this region with their
+// revision and queues them for every peer.
+type recordingStore struct {
+	metadata.Backend
+	r *Replicator
+}
+
+func (s *recordingStore) Put(ctx context.Context, meta domain.FileMetadata) error {
+	// The revision follows what is stored, even if meta was read before a
+	// write replicated meanwhile, as it replaces that.
+	var stored domain.Revision
+	if prev, err := s.Backend.Get(ctx, meta.ID); err == nil && prev.Revision != nil {
+		stored = *prev.Revision
+	}
+	if meta.Revision != nil {
+		stored = stored.Merge(*meta.Revision)
+	}
+	revision := s.r.next(stored)
+	meta.Revision = &revision
+	if err := s.Backend.Put(ctx, meta); err != nil {
+		return err
+	}
+	s.r.queue(ctx, meta.ID, false, revision, "")
+	return nil
+}
+
+func (s *recordingStore) Delete(ctx context.Context, id string) error {
+	var stored domain.Revision
+	if prev, err := s.Backend.Get(ctx, id); err == nil && prev.Revision != nil {
+		stored = *prev.Revision
+	}
+	if err := s.Backend.Delete(ctx, id); err != nil {
+		return err
+	}
+	revision := s.r.next(stored)
+	if err := s.Backend.PutTombstone(ctx, domain.Tombstone{FileID: id, Revision: revision}); err != nil {
+		s.r.logger.Error("Failed to record deleted file for replication", "fileId", id, "error", err)
+	}
+	s.r.queue(ctx, id, true, revision, "")
+	return nil
+}
+
+// next returns the revision of a write in this region following prev.
+func (r *Replicator) next(prev domain.Revision) domain.Revision {
+	next := prev.Merge(domain.Revision{})
+	next.Vector[r.region]++
+	next.ModifiedAt = time.Now().UTC()
+	next.ModifiedIn = r.region
+	return next
+}
+
+// queue records a write to a file for every peer but except, and wakes
+// their senders.
+func (r *Replicator) queue(ctx context.Context, fileID string, deleted bool, revision domain.Revision, except string) {
+	now := time.Now().UTC()
+	for region, p := range r.peers {
+		if region == except {
+			continue
+		}
+		err := r.backend.QueueReplication(ctx, domain.ReplicationTask{
+			Region:   region,
+			FileID:   fileID,
+			Deleted:  deleted,
+			Revision: revision,
+			QueuedAt: now,
+		})
+		if err != nil {
+			r.logger.Error("Failed to queue write for replication", "fileId", fileID, "region", region, "error", err)
+			continue
+		}
+		p.notify()
+	}
+}
+
+// Resync queues every file for every peer, as files stored before
+// replication was turned on, or while a peer was replaced, are not
+// replicated otherwise. It returns the number of files queued.
+func (r *Replicator) Resync(ctx context.Context) (int, error) {
+	files, err := r.backend.List(ctx, metadata.Filter{})
+	if err != nil {
+		return 0, err
+	}
+	for _, meta := range files {
+		revision := meta.Revision
+		if revision == nil {
+			// Stamp the file so that peers can order later writes to it.
+			next := r.next(domain.Revision{})
+			meta.Revision = &next
+			if err := r.backend.Put(ctx, meta); err != nil {
+				return 0, err
+			}
+			revision = &next
+		}
+		r.queue(ctx, meta.ID, false, *revision, "")
+	}
+	return len(files), nil
+}
+
+// Peers returns the regions replicated to, sorted.
+func (r *Replicator) Peers() []string {
+	regions := make([]string, 0, len(r.peers))
+	for region := range r.peers {
+		regions = append(regions, region)
+	}
+	slices.Sort(regions)
+	return regions
+}
+
+var (
+	// ErrUnauthorized is returned for requests not signed by a peer.
+	ErrUnauthorized = errors.New("request not signed with the replication secret")
+	// ErrInvalid is returned for replicated writes that cannot be applied.
+	ErrInvalid = errors.New("invalid replicated write")
+	// ErrPeerUnavailable is returned when the content of a replicated
+	// write cannot be fetched from its sender.
+	ErrPeerUnavailable = errors.New("peer region unavailable")
+)
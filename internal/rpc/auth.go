@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authenticator verifies the bearer token every call carries in its
+// "authorization" metadata, as the HTTP API does with the header.
+type authenticator struct {
+	jwks   *auth.JWKSClient
+	config auth.Config
+}
+
+func (a authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid authorization metadata")
+	}
+
+	authContext, err := auth.VerifyToken(ctx, strings.TrimPrefix(values[0], "Bearer "), a.jwks, a.config)
+	if errors.Is(err, auth.ErrIntrospectionUnavailable) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token: "+err.Error())
+	}
+	return auth.NewContext(ctx, authContext), nil
+}
+
+func (a authenticator) unary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a authenticator) stream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// caller returns the verified token of the call. The interceptors reject
+// calls without one, so it is always set in handlers.
+func caller(ctx context.Context) *auth.AuthContext {
+	authContext, _ := auth.FromContext(ctx)
+	return authContext
+}
+
+// userAgent returns the user agent the peer of the call sent.
+func userAgent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("user-agent"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// requirePermissions fails with PermissionDenied unless the caller holds
+// all of permissions.
+func requirePermissions(ctx context.Context, permissions ...string) error {
+	authContext := caller(ctx)
+	for _, permission := range permissions {
+		if !authContext.HasPermission(permission) {
+			return status.Errorf(codes.PermissionDenied, "requires the %s permission", permission)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/ondrasimku/media-service-go/internal/drain"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// inFlight tracks calls like HTTP requests, counting the size of the
+// messages they exchange.
+type inFlight struct {
+	tracker *drain.Tracker
+}
+
+// fileRequest is a request naming the file it is about.
+type fileRequest interface {
+	GetFileId() string
+}
+
+func (f inFlight) unary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var fileID string
+	if r, ok := req.(fileRequest); ok {
+		fileID = r.GetFileId()
+	}
+	op := f.tracker.Begin(drain.KindRequest, fileID)
+	op.Add(messageSize(req))
+	resp, err := handler(ctx, req)
+	op.Add(messageSize(resp))
+	op.End(ctx.Err() != nil)
+	return resp, err
+}
+
+func (f inFlight) stream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	op := f.tracker.Begin(drain.KindRequest, "")
+	err := handler(srv, countingStream{ServerStream: ss, op: op})
+	op.End(ss.Context().Err() != nil)
+	return err
+}
+
+type countingStream struct {
+	grpc.ServerStream
+	op *drain.Op
+}
+
+func (s countingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.op.Add(messageSize(m))
+	}
+	return err
+}
+
+func (s countingStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.op.Add(messageSize(m))
+	}
+	return err
+}
+
+func messageSize(m any) int64 {
+	if msg, ok := m.(proto.Message); ok {
+		return int64(proto.Size(msg))
+	}
+	return 0
+}
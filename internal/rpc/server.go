@@ -0,0 +1,317 @@
+// Package rpc serves the gRPC API defined in api/media/v1 for internal
+// services. It is a thin adapter over the media services, like the HTTP
+// handlers, and authenticates calls with the same bearer tokens.
+package rpc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	mediav1 "github.com/ondrasimku/media-service-go/api/media/v1"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/drain"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/service/media"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// customIDPermission allows uploads to choose their own file ID.
+const customIDPermission = "files:custom_id"
+
+type Server struct {
+	mediav1.UnimplementedMediaServiceServer
+
+	uploads *media.UploadService
+	files   *media.FileService
+	logger  *slog.Logger
+}
+
+// NewServer returns a gRPC server with the media service registered.
+// Calls are authenticated against the JWKS and tracked by tracker like
+// HTTP requests.
+func NewServer(uploads *media.UploadService, files *media.FileService, jwks *auth.JWKSClient, authConfig auth.Config, tracker *drain.Tracker, logger *slog.Logger) *grpc.Server {
+	authn := authenticator{jwks: jwks, config: authConfig}
+	tracked := inFlight{tracker: tracker}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(tracked.unary, authn.unary),
+		grpc.ChainStreamInterceptor(tracked.stream, authn.stream),
+	)
+	mediav1.RegisterMediaServiceServer(server, &Server{
+		uploads: uploads,
+		files:   files,
+		logger:  logger,
+	})
+	return server
+}
+
+// Upload spools the streamed content to a temporary file, as the HTTP API
+// does with multipart uploads, and stores it once the client is done.
+func (s *Server) Upload(stream mediav1.MediaService_UploadServer) error {
+	ctx := stream.Context()
+	if err := requirePermissions(ctx, "files:upload"); err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	params := first.GetMetadata()
+	if params == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the upload metadata")
+	}
+	if params.GetFileId() != "" {
+		if err := requirePermissions(ctx, customIDPermission); err != nil {
+			return err
+		}
+	}
+
+	spool, err := os.CreateTemp("", "media-upload-")
+	if err != nil {
+		return s.status(err, "failed to process file")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	var size int64
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if msg.GetMetadata() != nil {
+			return status.Error(codes.InvalidArgument, "only the first message may carry the upload metadata")
+		}
+		chunk := msg.GetChunk()
+		if size += int64(len(chunk)); size > s.uploads.MaxSize() {
+			return status.Error(codes.ResourceExhausted, "file too large")
+		}
+		if _, err := spool.Write(chunk); err != nil {
+			return s.status(err, "failed to process file")
+		}
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return s.status(err, "failed to process file")
+	}
+
+	visibility, err := media.ParseVisibility(params.GetVisibility())
+	if err != nil {
+		return s.status(err, "failed to save file")
+	}
+
+	authContext := caller(ctx)
+	req := media.UploadRequest{
+		Content:     spool,
+		Size:        size,
+		Filename:    params.GetFilename(),
+		ContentType: params.GetContentType(),
+		OwnerID:     authContext.UserID,
+		Collection:  params.GetCollection(),
+		FileID:      params.GetFileId(),
+		Visibility:  visibility,
+		Provenance:  domain.Provenance{Channel: domain.ChannelGRPC, Client: userAgent(ctx)},
+		Details: media.Details{
+			AltText:     params.GetAltText(),
+			Description: params.GetDescription(),
+			Credit:      params.GetCredit(),
+			License:     params.GetLicense(),
+
+			RightsHolder: params.GetRightsHolder(),
+		},
+	}
+	for _, f := range []struct {
+		src *timestamppb.Timestamp
+		dst **time.Time
+	}{
+		{params.GetLicenseExpiresAt(), &req.Details.LicenseExpiresAt},
+		{params.GetAvailableFrom(), &req.Details.AvailableFrom},
+		{params.GetAvailableUntil(), &req.Details.AvailableUntil},
+	} {
+		if f.src != nil {
+			t := f.src.AsTime()
+			*f.dst = &t
+		}
+	}
+	if authContext.OrgID != nil {
+		req.OrgID = *authContext.OrgID
+	}
+	meta, err := s.uploads.Upload(ctx, req)
+	if err != nil {
+		return s.status(err, "failed to save file")
+	}
+	return stream.SendAndClose(s.newFile(meta))
+}
+
+func (s *Server) GetFileInfo(ctx context.Context, req *mediav1.GetFileInfoRequest) (*mediav1.File, error) {
+	meta, err := s.files.Info(ctx, req.GetFileId())
+	if err != nil {
+		return nil, s.status(err, "failed to read file")
+	}
+	return s.newFile(meta), nil
+}
+
+// Delete removes a file. Callers may only delete their own files unless
+// they are administrators.
+func (s *Server) Delete(ctx context.Context, req *mediav1.DeleteRequest) (*mediav1.DeleteResponse, error) {
+	if err := requirePermissions(ctx, "files:delete"); err != nil {
+		return nil, err
+	}
+
+	ownerID := caller(ctx).UserID
+	if caller(ctx).HasPermission("files:admin") {
+		ownerID = ""
+	}
+	if err := s.files.Delete(ctx, req.GetFileId(), ownerID); err != nil {
+		return nil, s.status(err, "failed to delete file")
+	}
+	return &mediav1.DeleteResponse{}, nil
+}
+
+func (s *Server) PresignURL(ctx context.Context, req *mediav1.PresignURLRequest) (*mediav1.PresignURLResponse, error) {
+	if err := requirePermissions(ctx, "files:share"); err != nil {
+		return nil, err
+	}
+
+	var expiresAt time.Time
+	if req.GetExpiresAt() != nil {
+		expiresAt = req.GetExpiresAt().AsTime()
+	}
+	// Videos get a URL of their stream, if they have one, and files
+	// without one of their download.
+	var (
+		url string
+		err error
+	)
+	if !req.GetDownload() {
+		url, expiresAt, err = s.files.StreamURL(ctx, req.GetFileId(), expiresAt)
+	}
+	if req.GetDownload() || errors.Is(err, media.ErrUnavailable) {
+		url, expiresAt, err = s.files.SignedURL(ctx, req.GetFileId(), expiresAt)
+	}
+	if err != nil {
+		return nil, s.status(err, "failed to sign URL")
+	}
+
+	response := &mediav1.PresignURLResponse{Url: url}
+	if !expiresAt.IsZero() {
+		response.ExpiresAt = timestamppb.New(expiresAt)
+	}
+	return response, nil
+}
+
+// status converts an error of the media services into a gRPC status.
+// Errors that are not the caller's fault are logged and reported as
+// failure.
+func (s *Server) status(err error, failure string) error {
+	var (
+		refused   *media.Error
+		saturated *governor.SaturatedError
+		limited   *plans.RateLimitedError
+	)
+	switch {
+	case errors.As(err, &refused):
+		return status.Error(statusCode(err), refused.Error())
+	case errors.As(err, &saturated):
+		return status.Error(codes.ResourceExhausted, fmt.Sprintf("processing capacity is exhausted, retry in %s", saturated.RetryAfter))
+	case errors.Is(err, governor.ErrTooLarge), errors.As(err, &limited):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "call cancelled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	}
+	s.logger.Error("gRPC call failed", "failure", failure, "error", err)
+	return status.Error(codes.Internal, failure)
+}
+
+func statusCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, media.ErrNotFound), errors.Is(err, media.ErrUnavailable):
+		return codes.NotFound
+	case errors.Is(err, media.ErrConflict):
+		return codes.AlreadyExists
+	case errors.Is(err, media.ErrUnauthorized):
+		return codes.Unauthenticated
+	case errors.Is(err, media.ErrForbidden):
+		return codes.PermissionDenied
+	case errors.Is(err, media.ErrTooLarge), errors.Is(err, media.ErrQuotaExceeded):
+		return codes.ResourceExhausted
+	case errors.Is(err, media.ErrUnprocessable), errors.Is(err, media.ErrRestricted):
+		return codes.FailedPrecondition
+	}
+	return codes.InvalidArgument
+}
+
+func (s *Server) newFile(meta domain.FileMetadata) *mediav1.File {
+	file := &mediav1.File{
+		Id:           meta.ID,
+		Url:          s.files.URL(meta.ID),
+		ContentType:  meta.ContentType,
+		Size:         meta.Size,
+		Status:       string(meta.Status),
+		OriginalName: meta.OriginalName,
+		Directory:    meta.Directory,
+		Collection:   meta.Collection,
+		Checksums:    meta.Checksums,
+		OwnerId:      meta.OwnerID,
+		OrgId:        meta.OrgID,
+		CreatedAt:    timestamppb.New(meta.CreatedAt),
+		PreviewUrl:   s.files.PreviewURL(meta),
+		AltText:      meta.AltText,
+		Description:  meta.Description,
+		Credit:       meta.Credit,
+		License:      meta.License,
+		RightsHolder: meta.RightsHolder,
+		Visibility:   string(cmp.Or(meta.Visibility, domain.VisibilityPublic)),
+	}
+	if meta.LicenseExpiresAt != nil {
+		file.LicenseExpiresAt = timestamppb.New(*meta.LicenseExpiresAt)
+	}
+	if meta.AvailableFrom != nil {
+		file.AvailableFrom = timestamppb.New(*meta.AvailableFrom)
+	}
+	if meta.AvailableUntil != nil {
+		file.AvailableUntil = timestamppb.New(*meta.AvailableUntil)
+	}
+	if img := meta.Image; img != nil {
+		file.Image = &mediav1.Image{
+			Width:       int32(img.Width),
+			Height:      int32(img.Height),
+			Orientation: int32(img.Orientation),
+			Frames:      int32(img.Frames),
+		}
+		for _, size := range img.Renditions {
+			file.Image.Sizes = append(file.Image.Sizes, int32(size))
+		}
+	}
+	if v := meta.Video; v != nil {
+		file.Video = &mediav1.Video{
+			Duration:   v.Duration,
+			Width:      int32(v.Width),
+			Height:     int32(v.Height),
+			VideoCodec: v.VideoCodec,
+			AudioCodec: v.AudioCodec,
+		}
+		if v.Transcode != nil {
+			file.Video.TranscodeStatus = string(v.Transcode.Status)
+		}
+	}
+	if a := meta.Audio; a != nil {
+		file.Audio = &mediav1.Audio{Duration: a.Duration}
+	}
+	return file
+}
@@ -0,0 +1,153 @@
+// Package scan checks uploads for malware with ClamAV's clamd daemon.
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+)
+
+// chunkSize is the size of the INSTREAM chunks sent to clamd.
+const chunkSize = bufpool.Small
+
+// Result is the verdict of a scan.
+type Result struct {
+	Infected  bool
+	Signature string // Name of the malware found, if any
+}
+
+// Clamd scans streams with a clamd daemon reached over TCP or a unix
+// socket. A Clamd without an address is disabled.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd connects to clamd at address: "unix:///path/to/clamd.sock",
+// "tcp://host:port" or "host:port". Each scan may take up to timeout.
+func NewClamd(address string, timeout time.Duration) *Clamd {
+	c := &Clamd{network: "tcp", address: address, timeout: timeout}
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		c.network, c.address = "unix", path
+	} else if hostPort, ok := strings.CutPrefix(address, "tcp://"); ok {
+		c.address = hostPort
+	}
+	return c
+}
+
+func (c *Clamd) Enabled() bool {
+	return c != nil && c.address != ""
+}
+
+// Ping checks that clamd answers.
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and returns its verdict.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	if !c.Enabled() {
+		return Result{}, fmt.Errorf("malware scanning not configured")
+	}
+	reply, err := c.command(ctx, "zINSTREAM\x00", r)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Replies are "stream: OK", "stream: <signature> FOUND" or
+	// "<message> ERROR".
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return Result{Infected: true, Signature: signature}, nil
+	}
+	return Result{}, fmt.Errorf("clamd failed: %s", strings.TrimSuffix(reply, " ERROR"))
+}
+
+// command sends a null-terminated command, followed by the content of
+// stream as INSTREAM chunks if it is not nil, and reads the reply.
+func (c *Clamd) command(ctx context.Context, cmd string, stream io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString(cmd); err != nil {
+		return "", fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	if stream != nil {
+		if err := writeChunks(w, stream); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// writeChunks writes r as length-prefixed chunks, terminated by an empty
+// one.
+func writeChunks(w io.Writer, r io.Reader) error {
+	buf := bufpool.Get(chunkSize)
+	defer bufpool.Put(buf)
+	chunk := *buf
+
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, werr := w.Write(size[:]); werr != nil {
+				return fmt.Errorf("failed to send to clamd: %w", werr)
+			}
+			if _, werr := w.Write(chunk[:n]); werr != nil {
+				return fmt.Errorf("failed to send to clamd: %w", werr)
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := w.Write(size[:]); err != nil {
+		return fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	return nil
+}
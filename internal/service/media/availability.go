@@ -0,0 +1,76 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var availabilityChanges = metrics.NewCounter("media_availability_changes_total",
+	"Files entering or leaving their availability window.", "availability")
+
+// checkAvailability refuses files outside their availability window.
+func checkAvailability(meta domain.FileMetadata) error {
+	switch meta.AvailabilityAt(time.Now()) {
+	case domain.FileEmbargoed:
+		return refuse(ErrRestricted, "Embargoed", "This file is available from "+meta.AvailableFrom.UTC().Format(time.RFC3339))
+	case domain.FileWithdrawn:
+		return refuse(ErrRestricted, "No longer available", "This file was available until "+meta.AvailableUntil.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// AnnounceAvailability publishes an event for every file whose window
+// opened or closed since it was last announced. Downloads follow the window
+// to the second regardless; the events lag by up to the time between two
+// announcements.
+func (s *FileService) AnnounceAvailability(ctx context.Context) error {
+	files, err := s.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return fmt.Errorf("failed to list files for availability: %w", err)
+	}
+	now := time.Now()
+	for _, f := range files {
+		if announced(f) == f.AvailabilityAt(now) {
+			continue
+		}
+		// Read again so details changed since listing are not lost.
+		meta, err := s.metadata.Get(ctx, f.ID)
+		if err != nil {
+			continue
+		}
+		previous, availability := announced(meta), meta.AvailabilityAt(now)
+		if previous == availability {
+			continue
+		}
+		meta.Availability = availability
+		if err := s.metadata.Put(ctx, meta); err != nil {
+			s.logger.Error("Failed to record availability", "fileId", meta.ID, "error", err)
+			continue
+		}
+
+		availabilityChanges.Inc(string(availability))
+		s.logger.Info("File availability changed", "fileId", meta.ID, "availability", availability, "previous", previous)
+		s.events.Emit(events.Event{
+			Type:   events.AvailabilityChanged,
+			FileID: meta.ID,
+			File:   events.NewFile(meta),
+			Data:   map[string]any{"availability": availability, "previous": previous},
+		})
+	}
+	return nil
+}
+
+// announced is the availability of a file as last announced. Files that
+// never had a window have always been available.
+func announced(meta domain.FileMetadata) domain.Availability {
+	if meta.Availability == "" {
+		return domain.FileAvailable
+	}
+	return meta.Availability
+}
@@ -0,0 +1,91 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+// AvatarAlias is the named alias of the current avatar of a user.
+func AvatarAlias(userID string) string {
+	return "avatar:" + userID
+}
+
+// SetAvatar makes an uploaded image the avatar of req.OwnerID. The avatar
+// alias of the user is repointed to the new file in one step, so clients
+// following it never miss an avatar, and the previous avatar is deleted.
+// With AvatarHistory the previous avatar is replaced in place instead and
+// kept as a version of it.
+func (s *UploadService) SetAvatar(ctx context.Context, req UploadRequest) (domain.FileMetadata, error) {
+	if req.OwnerID == "" {
+		return domain.FileMetadata{}, refuse(ErrForbidden, "Avatars belong to a user", "The token carries no user")
+	}
+	if !imaging.Decodable(mediatype.Sniff(req.Content)) {
+		return domain.FileMetadata{}, refuse(ErrUnsupported, "Unsupported avatar type", "Avatars are JPEG or PNG images")
+	}
+
+	req.avatar = true
+
+	// Avatars are shown next to their user to everyone, unless asked not
+	// to be.
+	if req.Visibility == "" {
+		req.Visibility = domain.VisibilityPublic
+	}
+
+	name := AvatarAlias(req.OwnerID)
+	alias, err := s.aliases.GetAlias(ctx, name)
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return domain.FileMetadata{}, fmt.Errorf("failed to read avatar alias: %w", err)
+	}
+	var current *domain.FileMetadata
+	if err == nil {
+		if meta, err := s.files.metadata.Get(ctx, alias.TargetID); err == nil && meta.Servable() && meta.OwnerID == req.OwnerID {
+			current = &meta
+		}
+	}
+	if current != nil && s.avatarHistory {
+		return s.Replace(ctx, current.ID, req)
+	}
+
+	meta, err := s.Upload(ctx, req)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	now := time.Now().UTC()
+	if alias.ID == "" {
+		alias = domain.Alias{ID: name, Named: true, CreatedBy: req.OwnerID, CreatedAt: now}
+	} else {
+		alias.PreviousTargetID = alias.TargetID
+		alias.UpdatedBy = req.OwnerID
+		alias.UpdatedAt = now
+	}
+	alias.TargetID = meta.ID
+	alias.OwnerID = req.OwnerID
+	alias.OrgID = req.OrgID
+	if err := s.aliases.PutAlias(ctx, alias); err != nil {
+		// The previous avatar stays current.
+		if err := s.files.Delete(ctx, meta.ID, ""); err != nil {
+			s.logger.Error("Failed to delete unused avatar", "fileId", meta.ID, "error", err)
+		}
+		return domain.FileMetadata{}, fmt.Errorf("failed to store avatar alias: %w", err)
+	}
+	details := map[string]string{"alias": name}
+	if alias.PreviousTargetID != "" {
+		details["previousFileId"] = alias.PreviousTargetID
+	}
+	s.files.audit.Record(ctx, domain.AuditAliasSet, meta.ID, details)
+
+	if current != nil {
+		if err := s.files.Delete(ctx, current.ID, req.OwnerID); err != nil {
+			s.logger.Warn("Failed to delete previous avatar", "fileId", current.ID, "ownerId", req.OwnerID, "error", err)
+		}
+	}
+	return meta, nil
+}
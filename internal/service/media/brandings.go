@@ -0,0 +1,216 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+const (
+	// maxLogoSize bounds the PNG decoded for a logo watermark.
+	maxLogoSize = 1 << 20
+
+	maxPaletteColors = 16
+	maxFonts         = 8
+	maxFontName      = 64
+
+	defaultLogoOpacity = 0.5
+)
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// BrandingRequest sets an organization's branding. Unset fields fall back
+// to their defaults: no logo, the bottom-right corner, half opacity.
+type BrandingRequest struct {
+	Logo         string
+	LogoPosition string
+	LogoOpacity  *float64
+	Palette      []string
+	Fonts        []string
+	UpdatedBy    string
+}
+
+// BrandingService keeps the branding of organizations and provides the
+// watermarks built from their logos.
+type BrandingService struct {
+	storage   storage.Storage
+	files     metadata.Store
+	brandings metadata.BrandingStore
+	logger    *slog.Logger
+
+	mu         sync.Mutex
+	watermarks map[string]logoWatermark // By org ID
+}
+
+type logoWatermark struct {
+	updatedAt time.Time
+	watermark *imaging.Watermark
+}
+
+func NewBrandingService(storage storage.Storage, files metadata.Store, brandings metadata.BrandingStore, logger *slog.Logger) *BrandingService {
+	return &BrandingService{
+		storage:    storage,
+		files:      files,
+		brandings:  brandings,
+		logger:     logger,
+		watermarks: make(map[string]logoWatermark),
+	}
+}
+
+func (s *BrandingService) Get(ctx context.Context, orgID string) (domain.Branding, error) {
+	branding, err := s.brandings.GetBranding(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.Branding{}, refuse(ErrNotFound, "Branding not found", "")
+	}
+	return branding, err
+}
+
+func (s *BrandingService) List(ctx context.Context) ([]domain.Branding, error) {
+	return s.brandings.ListBrandings(ctx)
+}
+
+// Put replaces the branding of an organization. The logo must be a stored
+// PNG image.
+func (s *BrandingService) Put(ctx context.Context, orgID string, req BrandingRequest) (domain.Branding, error) {
+	if !ValidFileID(orgID) {
+		return domain.Branding{}, refuse(ErrInvalid, "Invalid organization ID", "Organization IDs are up to 128 letters, digits, '.', '_' and '-'")
+	}
+
+	branding := domain.Branding{
+		OrgID:        orgID,
+		Logo:         req.Logo,
+		LogoPosition: string(imaging.PositionBottomRight),
+		LogoOpacity:  defaultLogoOpacity,
+		UpdatedBy:    req.UpdatedBy,
+		UpdatedAt:    time.Now().UTC(),
+	}
+	if req.LogoPosition != "" {
+		if _, ok := imaging.ParsePosition(req.LogoPosition); !ok {
+			return domain.Branding{}, refuse(ErrInvalid, "Invalid logo position", "Use top-left, top-right, bottom-left, bottom-right or center")
+		}
+		branding.LogoPosition = req.LogoPosition
+	}
+	if req.LogoOpacity != nil {
+		if *req.LogoOpacity < 0 || *req.LogoOpacity > 1 {
+			return domain.Branding{}, refuse(ErrInvalid, "Invalid logo opacity", "logoOpacity must be between 0 and 1")
+		}
+		branding.LogoOpacity = *req.LogoOpacity
+	}
+
+	if len(req.Palette) > maxPaletteColors {
+		return domain.Branding{}, refuse(ErrInvalid, "Invalid palette", fmt.Sprintf("A palette has at most %d colors", maxPaletteColors))
+	}
+	for _, c := range req.Palette {
+		if !hexColorPattern.MatchString(c) {
+			return domain.Branding{}, refuse(ErrInvalid, "Invalid palette", fmt.Sprintf("%q is not a #rrggbb color", c))
+		}
+		branding.Palette = append(branding.Palette, strings.ToLower(c))
+	}
+
+	if len(req.Fonts) > maxFonts {
+		return domain.Branding{}, refuse(ErrInvalid, "Invalid fonts", fmt.Sprintf("At most %d fonts may be listed", maxFonts))
+	}
+	for _, font := range req.Fonts {
+		font = strings.TrimSpace(font)
+		if font == "" || len(font) > maxFontName {
+			return domain.Branding{}, refuse(ErrInvalid, "Invalid fonts", fmt.Sprintf("Font names are 1 to %d characters", maxFontName))
+		}
+		branding.Fonts = append(branding.Fonts, font)
+	}
+
+	if branding.Logo != "" {
+		if _, err := s.loadLogo(ctx, branding); err != nil {
+			return domain.Branding{}, err
+		}
+	}
+
+	if err := s.brandings.PutBranding(ctx, branding); err != nil {
+		return domain.Branding{}, err
+	}
+	s.logger.Info("Branding updated", "orgId", orgID, "updatedBy", req.UpdatedBy)
+	return branding, nil
+}
+
+func (s *BrandingService) Delete(ctx context.Context, orgID string) error {
+	err := s.brandings.DeleteBranding(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return refuse(ErrNotFound, "Branding not found", "")
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.watermarks, orgID)
+	s.mu.Unlock()
+	s.logger.Info("Branding deleted", "orgId", orgID)
+	return nil
+}
+
+// Watermark returns the watermark built from the logo of an organization,
+// or nil if it has none. A logo that fails to load is logged and treated
+// as missing, so files are served with the default watermark instead.
+func (s *BrandingService) Watermark(ctx context.Context, orgID string) *imaging.Watermark {
+	branding, err := s.brandings.GetBranding(ctx, orgID)
+	if err != nil || branding.Logo == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	cached, ok := s.watermarks[orgID]
+	s.mu.Unlock()
+	if ok && cached.updatedAt.Equal(branding.UpdatedAt) {
+		return cached.watermark
+	}
+
+	watermark, err := s.loadLogo(ctx, branding)
+	if err != nil {
+		s.logger.Warn("Failed to load branding logo", "orgId", orgID, "logo", branding.Logo, "error", err)
+		return nil
+	}
+	s.mu.Lock()
+	s.watermarks[orgID] = logoWatermark{updatedAt: branding.UpdatedAt, watermark: watermark}
+	s.mu.Unlock()
+	return watermark
+}
+
+func (s *BrandingService) loadLogo(ctx context.Context, branding domain.Branding) (*imaging.Watermark, error) {
+	meta, err := s.files.Get(ctx, branding.Logo)
+	if err != nil || !meta.Servable() {
+		return nil, refuse(ErrInvalid, "Invalid logo", "The logo must be the ID of a stored file")
+	}
+	if meta.ContentType != "image/png" {
+		return nil, refuse(ErrInvalid, "Invalid logo", "The logo must be a PNG image")
+	}
+	if meta.Size > maxLogoSize {
+		return nil, refuse(ErrInvalid, "Invalid logo", fmt.Sprintf("The logo must not exceed %d bytes", maxLogoSize))
+	}
+
+	file, _, err := s.storage.Open(ctx, meta.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open logo: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxLogoSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logo: %w", err)
+	}
+
+	position, _ := imaging.ParsePosition(branding.LogoPosition)
+	watermark, err := imaging.NewWatermark(data, position, branding.LogoOpacity)
+	if err != nil {
+		return nil, refuse(ErrInvalid, "Invalid logo", err.Error())
+	}
+	return watermark, nil
+}
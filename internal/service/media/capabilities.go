@@ -0,0 +1,130 @@
+package media
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+// Capabilities are what an organization may upload and what the service
+// makes of its files, as the deployment, the plan and the settings of the
+// organization allow.
+type Capabilities struct {
+	Plan    string // Empty outside organizations and without plans
+	Uploads bool   // False once the organization is offboarded
+
+	// AllowedTypes are the content types accepted, sorted; the size
+	// limits apply to all types but video and audio, which have their
+	// own. A limit of 0 means the type is not accepted.
+	AllowedTypes []string
+	MaxFileSize  int64
+	MaxVideoSize int64
+	MaxAudioSize int64
+
+	Features     CapabilityFeatures
+	ImageFormats []imaging.Format // Formats images are converted to on request
+	Renditions   []int            // Default heights of video renditions
+	AvatarSizes  []int
+
+	DefaultVisibility domain.Visibility
+}
+
+// CapabilityFeatures are the processing features enabled.
+type CapabilityFeatures struct {
+	Video       bool
+	Audio       bool
+	Transcoding bool
+	HLS         bool
+	Posters     bool // Poster frames and teasers of videos
+	Waveforms   bool
+	Loudness    bool
+	PDFPreviews bool
+	OCR         bool
+	Scanning    bool // Malware scanning of uploads
+}
+
+// Capabilities returns the capabilities of an organization; "" for callers
+// outside one.
+func (s *UploadService) Capabilities(ctx context.Context, orgID string) (Capabilities, error) {
+	plan := s.files.plans.Plan(ctx, orgID)
+	caps := Capabilities{
+		Plan:        plan.Name,
+		Uploads:     true,
+		MaxFileSize: s.maxSize,
+		Features: CapabilityFeatures{
+			Video:       s.maxVideoSize > 0 && plan.Allows(plans.FeatureVideo),
+			Audio:       s.maxAudioSize > 0 && plan.Allows(plans.FeatureAudio),
+			PDFPreviews: s.files.previews.Supported(),
+			OCR:         s.files.recognizer.Supported() && plan.Allows(plans.FeatureOCR),
+			Scanning:    s.scanner.Enabled(),
+		},
+		AvatarSizes:       slices.Clone(s.avatarSizes),
+		DefaultVisibility: s.visibility,
+	}
+	if caps.Features.Video {
+		caps.MaxVideoSize = s.maxVideoSize
+		caps.Features.Transcoding = s.transcodes.Enabled()
+		caps.Features.HLS = s.transcodes.HLS()
+		caps.Features.Posters = s.files.posters.Supported()
+		caps.Renditions = s.transcodes.Heights()
+	}
+	if caps.Features.Audio {
+		caps.MaxAudioSize = s.maxAudioSize
+		caps.Features.Waveforms = s.files.waveforms.Supported()
+		caps.Features.Loudness = s.files.normalizer.Supported()
+	}
+	if plan.MaxFileSize > 0 {
+		caps.MaxFileSize = min(caps.MaxFileSize, plan.MaxFileSize)
+		if caps.MaxVideoSize > 0 {
+			caps.MaxVideoSize = min(caps.MaxVideoSize, plan.MaxFileSize)
+		}
+		if caps.MaxAudioSize > 0 {
+			caps.MaxAudioSize = min(caps.MaxAudioSize, plan.MaxFileSize)
+		}
+	}
+	for _, f := range []imaging.Format{imaging.FormatAVIF, imaging.FormatWebP} {
+		if s.files.ConvertsTo(f) {
+			caps.ImageFormats = append(caps.ImageFormats, f)
+		}
+	}
+
+	var allowed []string
+	if s.orgs != nil && orgID != "" {
+		org, err := s.orgs.GetOrg(ctx, orgID)
+		switch {
+		case errors.Is(err, metadata.ErrNotFound):
+		case err != nil:
+			return Capabilities{}, err
+		case org.Status != domain.OrgActive:
+			caps.Uploads = false
+		}
+		settings, err := s.orgs.GetOrgSettings(ctx, orgID)
+		switch {
+		case errors.Is(err, metadata.ErrNotFound):
+		case err != nil:
+			return Capabilities{}, err
+		default:
+			allowed = settings.AllowedTypes
+			caps.DefaultVisibility = cmp.Or(settings.DefaultVisibility, caps.DefaultVisibility)
+		}
+	}
+	for t := range s.allowedMIME {
+		switch {
+		case len(allowed) > 0 && !slices.Contains(allowed, t):
+		case video.Supported(t) && !caps.Features.Video:
+		case audio.Supported(t) && !caps.Features.Audio:
+		default:
+			caps.AllowedTypes = append(caps.AllowedTypes, t)
+		}
+	}
+	slices.Sort(caps.AllowedTypes)
+	return caps, nil
+}
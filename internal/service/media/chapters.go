@@ -0,0 +1,124 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+const (
+	maxChapters           = 500
+	maxChapterTitleLength = 200
+)
+
+// TimedChapter is a chapter with the time it ends at, in seconds. End is 0
+// for the last chapter of a file whose duration is not known.
+type TimedChapter struct {
+	Start float64
+	End   float64
+	Title string
+}
+
+// Duration returns the length of a video or audio file in seconds, 0 if
+// it is not known.
+func Duration(meta domain.FileMetadata) float64 {
+	switch {
+	case meta.Video != nil:
+		return meta.Video.Duration
+	case meta.Audio != nil:
+		return meta.Audio.Duration
+	}
+	return 0
+}
+
+// TimeChapters works out when each chapter of a file ends.
+func TimeChapters(meta domain.FileMetadata) []TimedChapter {
+	timed := make([]TimedChapter, len(meta.Chapters))
+	for i, c := range meta.Chapters {
+		timed[i] = TimedChapter{Start: c.Start, Title: c.Title}
+		if i+1 < len(meta.Chapters) {
+			timed[i].End = meta.Chapters[i+1].Start
+		} else {
+			timed[i].End = Duration(meta)
+		}
+	}
+	return timed
+}
+
+// Chapters returns the chapters of a file whose content may be delivered.
+func (s *FileService) Chapters(ctx context.Context, id string) ([]TimedChapter, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !video.Supported(meta.ContentType) && !audio.Supported(meta.ContentType) {
+		return nil, refuse(ErrUnavailable, "Chapters not available", "Only video and audio files have chapters")
+	}
+	return TimeChapters(meta), nil
+}
+
+// SetChapters replaces the chapters of a video or audio file; an empty
+// list removes them. With ownerID set, only a file owned by it is updated.
+func (s *UploadService) SetChapters(ctx context.Context, id, ownerID string, chapters []domain.Chapter) (domain.FileMetadata, error) {
+	meta, err := s.files.metadata.Get(ctx, id)
+	if errors.Is(err, metadata.ErrNotFound) || err == nil && !meta.Servable() {
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if ownerID != "" && meta.OwnerID != ownerID {
+		return domain.FileMetadata{}, refuse(ErrForbidden, "Insufficient permissions", "Only the owner of the file may change it")
+	}
+	if !video.Supported(meta.ContentType) && !audio.Supported(meta.ContentType) {
+		return domain.FileMetadata{}, refuse(ErrInvalid, "Invalid chapters", "Only video and audio files have chapters")
+	}
+
+	if chapters, err = checkChapters(chapters, Duration(meta)); err != nil {
+		return domain.FileMetadata{}, err
+	}
+	meta.Chapters = chapters
+	if err := s.files.metadata.Put(ctx, meta); err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to store metadata: %w", err)
+	}
+	s.files.audit.Record(ctx, domain.AuditUpdate, id, map[string]string{"fields": "chapters"})
+	return meta, nil
+}
+
+// checkChapters validates chapters against a file of duration seconds, 0
+// if unknown, and returns them with their titles trimmed.
+func checkChapters(chapters []domain.Chapter, duration float64) ([]domain.Chapter, error) {
+	invalid := func(format string, args ...any) error {
+		return refuse(ErrInvalid, "Invalid chapters", fmt.Sprintf(format, args...))
+	}
+	if len(chapters) > maxChapters {
+		return nil, invalid("A file has at most %d chapters", maxChapters)
+	}
+
+	checked := make([]domain.Chapter, 0, len(chapters))
+	for i, c := range chapters {
+		c.Title = strings.TrimSpace(c.Title)
+		switch {
+		case c.Title == "":
+			return nil, invalid("Chapter %d has no title", i+1)
+		case utf8.RuneCountInString(c.Title) > maxChapterTitleLength:
+			return nil, invalid("Chapter %d has a title over %d characters", i+1, maxChapterTitleLength)
+		case math.IsNaN(c.Start) || math.IsInf(c.Start, 0) || c.Start < 0:
+			return nil, invalid("Chapter %d must start at a non-negative time", i+1)
+		case duration > 0 && c.Start >= duration:
+			return nil, invalid("Chapter %d starts at %gs, after the file ends at %gs", i+1, c.Start, duration)
+		case i > 0 && c.Start <= checked[i-1].Start:
+			return nil, invalid("Chapter %d must start after chapter %d", i+1, i)
+		}
+		checked = append(checked, c)
+	}
+	return checked, nil
+}
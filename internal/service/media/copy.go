@@ -0,0 +1,148 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+var errUnknownDirectory = refuse(ErrInvalid, "Unknown directory", "Files can only be copied and moved to the configured storage directories")
+
+// CopyRequest asks for a copy of a file in Directory. The copy gets
+// FileID, or a generated ID. With OwnerID set, only a file owned by it is
+// copied.
+type CopyRequest struct {
+	Directory string
+	FileID    string
+	OwnerID   string
+}
+
+// Copy stores a copy of a file, with its details and derivatives, as a new
+// file in another directory, which it takes its TTL from. The content is
+// copied by the storage backend. Superseded versions are not copied.
+func (s *UploadService) Copy(ctx context.Context, fileID string, req CopyRequest) (domain.FileMetadata, error) {
+	src, err := s.movable(ctx, fileID, req.OwnerID, "copy")
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if err := s.checkOrg(ctx, src.OrgID, src.ContentType); err != nil {
+		return domain.FileMetadata{}, err
+	}
+	usage, err := s.quotas.Check(ctx, src.OrgID, src.Size)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	info, err := s.files.storage.Copy(ctx, src.ID, storage.SaveOptions{
+		ID:           req.FileID,
+		Directory:    req.Directory,
+		ContentType:  src.ContentType,
+		OriginalName: src.OriginalName,
+	}, func(name string) bool {
+		for _, v := range src.Versions {
+			if name == domain.VersionDerivative(v.Number) {
+				return false
+			}
+		}
+		return true
+	})
+	switch {
+	case errors.Is(err, storage.ErrUnknownDirectory):
+		return domain.FileMetadata{}, errUnknownDirectory
+	case errors.Is(err, storage.ErrExists):
+		return domain.FileMetadata{}, refuse(ErrConflict, "File ID already exists", "")
+	case err != nil:
+		return domain.FileMetadata{}, fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	meta := src
+	meta.ID, meta.Path, meta.Directory = info.ID, info.Path, info.Directory
+	meta.CreatedAt, meta.Region = info.CreatedAt, s.files.region
+	meta.ExpiresAt = s.expiry(0, info.Directory, info.CreatedAt)
+	meta.Versions = nil
+	provenance := s.provenance(ctx, domain.Provenance{Channel: domain.ChannelCopy, From: src.ID})
+	meta.Provenance = &provenance
+	if err := s.files.metadata.Put(ctx, meta); err != nil {
+		s.files.storage.Delete(ctx, meta.ID)
+		return domain.FileMetadata{}, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+
+	s.files.audit.Record(ctx, domain.AuditCopy, meta.ID, map[string]string{
+		"channel":    domain.ChannelCopy,
+		"copiedFrom": src.ID,
+		"directory":  meta.Directory,
+		"size":       strconv.FormatInt(meta.Size, 10),
+		"ownerId":    meta.OwnerID,
+	})
+	s.events.Emit(events.Event{Type: events.Created, FileID: meta.ID, File: events.NewFile(meta), Data: map[string]any{"copiedFrom": src.ID}})
+	s.quotas.Observe(usage, meta)
+	if meta.Video != nil && meta.Video.Transcode != nil && meta.Video.Transcode.Status == domain.TranscodePending {
+		s.transcodes.Enqueue(meta.ID)
+	}
+	return meta, nil
+}
+
+// Move puts a file in another directory, which it takes its TTL from,
+// counted from the move. Its ID, and so its URLs, stay the same. With
+// ownerID set, only a file owned by it is moved.
+func (s *UploadService) Move(ctx context.Context, fileID, directory, ownerID string) (domain.FileMetadata, error) {
+	meta, err := s.movable(ctx, fileID, ownerID, "move")
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if meta.Directory == directory {
+		return meta, nil
+	}
+
+	previous := meta.Directory
+	info, err := s.files.storage.Move(ctx, meta.ID, directory)
+	if errors.Is(err, storage.ErrUnknownDirectory) {
+		return domain.FileMetadata{}, errUnknownDirectory
+	}
+	if err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to move file: %w", err)
+	}
+
+	meta.Path, meta.Directory = info.Path, info.Directory
+	meta.ExpiresAt = s.expiry(0, info.Directory, time.Now().UTC())
+	if err := s.files.metadata.Put(ctx, meta); err != nil {
+		if _, err := s.files.storage.Move(ctx, meta.ID, previous); err != nil {
+			s.logger.Error("Failed to move file back", "fileId", meta.ID, "directory", previous, "error", err)
+		}
+		return domain.FileMetadata{}, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+
+	s.files.audit.Record(ctx, domain.AuditMove, meta.ID, map[string]string{"directory": meta.Directory, "previous": previous})
+	s.events.Emit(events.Event{
+		Type:   events.Moved,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"directory": meta.Directory, "previous": previous},
+	})
+	return meta, nil
+}
+
+// movable returns a servable file the owner, if set, may copy or move.
+func (s *UploadService) movable(ctx context.Context, fileID, ownerID, action string) (domain.FileMetadata, error) {
+	meta, err := s.files.metadata.Get(ctx, fileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if !meta.Servable() {
+		return domain.FileMetadata{}, unservable(meta)
+	}
+	if ownerID != "" && meta.OwnerID != ownerID {
+		return domain.FileMetadata{}, refuse(ErrForbidden, "Insufficient permissions", "Only the owner of the file may "+action+" it")
+	}
+	return meta, nil
+}
@@ -0,0 +1,92 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var degradedFallbacks = metrics.NewCounter("media_degraded_fallbacks_total",
+	"Image variants skipped for the original while processing was backlogged.", "variant")
+
+// errDegraded is returned for variants skipped while degraded; callers
+// fall back to what they would serve had the variant failed.
+var errDegraded = errors.New("processing backlogged")
+
+// Backlog is a processing queue whose depth degrades serving.
+type Backlog interface {
+	Depth() int
+}
+
+// DegradeConfig sets when serving degrades to originals so that responses
+// do not wait behind a processing backlog.
+type DegradeConfig struct {
+	// Serving degrades while Jobs holds more than MaxJobs jobs or
+	// Transcodes more than MaxTranscodes videos. Either may be nil; a
+	// zero limit never degrades.
+	Jobs          Backlog
+	MaxJobs       int
+	Transcodes    Backlog
+	MaxTranscodes int
+
+	// MaxDimension caps the sides of originals served in place of image
+	// variants; larger ones are downscaled on demand.
+	MaxDimension int
+}
+
+// Degraded reports whether processing is backlogged. While it is, image
+// variants not cached yet are skipped and the original is served instead.
+func (s *FileService) Degraded() bool {
+	var jobs, transcodes int
+	if s.degrade.Jobs != nil {
+		jobs = s.degrade.Jobs.Depth()
+	}
+	if s.degrade.Transcodes != nil {
+		transcodes = s.degrade.Transcodes.Depth()
+	}
+	degraded := s.degrade.MaxJobs > 0 && jobs > s.degrade.MaxJobs ||
+		s.degrade.MaxTranscodes > 0 && transcodes > s.degrade.MaxTranscodes
+
+	if s.degraded.Swap(degraded) != degraded {
+		if degraded {
+			s.logger.Warn("Processing backlogged, serving originals in place of variants", "jobs", jobs, "transcodes", transcodes)
+		} else {
+			s.logger.Info("Processing caught up, serving variants again", "jobs", jobs, "transcodes", transcodes)
+		}
+	}
+	return degraded
+}
+
+// fallback returns the image of content downscaled to MaxDimension, to be
+// served in place of a variant skipped while degraded. It returns
+// errDegraded when content is to be served as it is.
+func (s *FileService) fallback(ctx context.Context, content *Content, variant string) (*Content, error) {
+	degradedFallbacks.Inc(variant)
+	maxSide := s.degrade.MaxDimension
+	if maxSide <= 0 || !imaging.Decodable(content.ContentType) || content.dims.X <= maxSide && content.dims.Y <= maxSide {
+		return nil, errDegraded
+	}
+
+	pixels := int64(content.dims.X) * int64(content.dims.Y)
+	release, err := s.governor.Admit(ctx, "downscale", governor.Cost{Memory: 3 * 4 * pixels, CPU: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	data, err = imaging.Fit(data, content.ContentType, maxSide)
+	if err != nil {
+		s.logger.Warn("Image downscaling failed", "fileId", content.fileID, "error", err)
+		return nil, err
+	}
+	return bytesContent(data, content.ContentType), nil
+}
@@ -0,0 +1,202 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+// Lengths of the descriptive fields in characters.
+const (
+	maxAltText      = 1000
+	maxDescription  = 5000
+	maxCredit       = 300
+	maxLicense      = 300
+	maxRightsHolder = 300
+)
+
+// Details are the descriptive fields of a file: alt text and a longer
+// description for people who cannot see it, the credit and license to
+// show wherever it is reused, for licensed media who holds the rights
+// and when the license expires, and the window the file is served in.
+type Details struct {
+	AltText          string
+	Description      string
+	Credit           string
+	License          string
+	RightsHolder     string
+	LicenseExpiresAt *time.Time
+
+	AvailableFrom  *time.Time
+	AvailableUntil *time.Time
+}
+
+// DetailsUpdate changes the fields of Details that are set. The Clear
+// fields remove the license expiry and the bounds of the availability
+// window.
+type DetailsUpdate struct {
+	AltText            *string
+	Description        *string
+	Credit             *string
+	License            *string
+	RightsHolder       *string
+	LicenseExpiresAt   *time.Time
+	ClearLicenseExpiry bool
+
+	AvailableFrom       *time.Time
+	AvailableUntil      *time.Time
+	ClearAvailableFrom  bool
+	ClearAvailableUntil bool
+
+	// Visibility is who the file is served to; empty leaves it as it is.
+	Visibility domain.Visibility
+}
+
+// checkDetails normalizes the details of a file and checks them against the
+// upload policy.
+func (s *UploadService) checkDetails(d Details, collection, contentType string) (Details, error) {
+	fields := []struct {
+		name  string
+		value *string
+		max   int
+	}{
+		{"altText", &d.AltText, maxAltText},
+		{"description", &d.Description, maxDescription},
+		{"credit", &d.Credit, maxCredit},
+		{"license", &d.License, maxLicense},
+		{"rightsHolder", &d.RightsHolder, maxRightsHolder},
+	}
+	for _, f := range fields {
+		*f.value = strings.TrimSpace(*f.value)
+		if n := utf8.RuneCountInString(*f.value); n > f.max {
+			return Details{}, refuse(ErrInvalid, "Invalid "+f.name, fmt.Sprintf("%s is limited to %d characters", f.name, f.max))
+		}
+	}
+
+	for _, t := range []**time.Time{&d.LicenseExpiresAt, &d.AvailableFrom, &d.AvailableUntil} {
+		if *t != nil {
+			utc := (*t).UTC()
+			*t = &utc
+		}
+	}
+	if d.AvailableFrom != nil && d.AvailableUntil != nil && !d.AvailableUntil.After(*d.AvailableFrom) {
+		return Details{}, refuse(ErrInvalid, "Invalid availability window", "availableUntil must be after availableFrom")
+	}
+
+	if d.AltText == "" && s.altRequired[collection] && strings.HasPrefix(contentType, "image/") {
+		return Details{}, refuse(ErrInvalid, "Alt text required", fmt.Sprintf("Images in the %s collection must have alt text", collection))
+	}
+	return d, nil
+}
+
+// Describe updates the details of a file under the same rules as at
+// upload. With ownerID set, only a file owned by it is updated.
+func (s *UploadService) Describe(ctx context.Context, id, ownerID string, update DetailsUpdate) (domain.FileMetadata, error) {
+	meta, err := s.files.metadata.Get(ctx, id)
+	if errors.Is(err, metadata.ErrNotFound) || err == nil && !meta.Servable() {
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if ownerID != "" && meta.OwnerID != ownerID {
+		return domain.FileMetadata{}, refuse(ErrForbidden, "Insufficient permissions", "Only the owner of the file may change it")
+	}
+
+	d := Details{
+		AltText:          meta.AltText,
+		Description:      meta.Description,
+		Credit:           meta.Credit,
+		License:          meta.License,
+		RightsHolder:     meta.RightsHolder,
+		LicenseExpiresAt: meta.LicenseExpiresAt,
+
+		AvailableFrom:  meta.AvailableFrom,
+		AvailableUntil: meta.AvailableUntil,
+	}
+	before := d
+	for _, f := range []struct{ dst, src *string }{
+		{&d.AltText, update.AltText},
+		{&d.Description, update.Description},
+		{&d.Credit, update.Credit},
+		{&d.License, update.License},
+		{&d.RightsHolder, update.RightsHolder},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	for _, f := range []struct {
+		dst   **time.Time
+		src   *time.Time
+		clear bool
+	}{
+		{&d.LicenseExpiresAt, update.LicenseExpiresAt, update.ClearLicenseExpiry},
+		{&d.AvailableFrom, update.AvailableFrom, update.ClearAvailableFrom},
+		{&d.AvailableUntil, update.AvailableUntil, update.ClearAvailableUntil},
+	} {
+		switch {
+		case f.clear:
+			*f.dst = nil
+		case f.src != nil:
+			*f.dst = f.src
+		}
+	}
+	if d, err = s.checkDetails(d, meta.Collection, meta.ContentType); err != nil {
+		return domain.FileMetadata{}, err
+	}
+	changed := changedDetails(before, d)
+	if v := update.Visibility; v != "" && v != meta.Visibility {
+		if !slices.Contains(domain.Visibilities, v) {
+			return domain.FileMetadata{}, errInvalidVisibility
+		}
+		meta.Visibility = v
+		changed = append(changed, "visibility")
+	}
+
+	meta.AltText, meta.Description, meta.Credit, meta.License = d.AltText, d.Description, d.Credit, d.License
+	meta.RightsHolder, meta.LicenseExpiresAt = d.RightsHolder, d.LicenseExpiresAt
+	meta.AvailableFrom, meta.AvailableUntil = d.AvailableFrom, d.AvailableUntil
+	if err := s.files.metadata.Put(ctx, meta); err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to store metadata: %w", err)
+	}
+	s.files.audit.Record(ctx, domain.AuditUpdate, id, map[string]string{"fields": strings.Join(changed, ",")})
+	return meta, nil
+}
+
+// changedDetails names the fields that differ between a and b.
+func changedDetails(a, b Details) []string {
+	var changed []string
+	for _, f := range []struct {
+		name  string
+		equal bool
+	}{
+		{"altText", a.AltText == b.AltText},
+		{"description", a.Description == b.Description},
+		{"credit", a.Credit == b.Credit},
+		{"license", a.License == b.License},
+		{"rightsHolder", a.RightsHolder == b.RightsHolder},
+		{"licenseExpiresAt", equalTime(a.LicenseExpiresAt, b.LicenseExpiresAt)},
+		{"availableFrom", equalTime(a.AvailableFrom, b.AvailableFrom)},
+		{"availableUntil", equalTime(a.AvailableUntil, b.AvailableUntil)},
+	} {
+		if !f.equal {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
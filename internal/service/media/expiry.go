@@ -0,0 +1,66 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var expiredFiles = metrics.NewCounter("media_expired_files_total",
+	"Files deleted when their TTL ran out.")
+
+// checkTTL refuses TTLs an upload may not ask for.
+func (s *UploadService) checkTTL(ttl time.Duration) error {
+	switch {
+	case ttl < 0:
+		return refuse(ErrInvalid, "Invalid TTL", "The TTL must be positive")
+	case s.maxTTL > 0 && ttl > s.maxTTL:
+		return refuse(ErrInvalid, "Invalid TTL", "Files expire after at most "+s.maxTTL.String())
+	}
+	return nil
+}
+
+// expiry returns when a file stored in directory at created expires: after
+// the TTL asked for, or else the directory's default. Nil if it does not.
+func (s *UploadService) expiry(ttl time.Duration, directory string, created time.Time) *time.Time {
+	if ttl == 0 {
+		ttl = s.directoryTTLs[directory]
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := created.Add(ttl)
+	return &expiresAt
+}
+
+// Expire deletes the files whose TTL ran out. Expired files are not served
+// in the meantime.
+func (s *FileService) Expire(ctx context.Context) error {
+	files, err := s.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return fmt.Errorf("failed to list files for expiry: %w", err)
+	}
+	now := time.Now()
+	for _, f := range files {
+		if !f.Expired(now) {
+			continue
+		}
+		// Read again in case the expiry was lifted since listing.
+		meta, err := s.metadata.Get(ctx, f.ID)
+		if err != nil || !meta.Expired(now) {
+			continue
+		}
+		if err := s.Remove(ctx, meta, "expired"); err != nil {
+			if !errors.Is(err, metadata.ErrNotFound) {
+				s.logger.Error("Failed to delete expired file", "fileId", meta.ID, "error", err)
+			}
+			continue
+		}
+		expiredFiles.Inc()
+	}
+	return nil
+}
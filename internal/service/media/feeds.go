@@ -0,0 +1,323 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/feed"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+)
+
+const (
+	maxFeedCollections = 32
+	maxFeedTitle       = 200
+
+	// maxFeedEntries bounds Atom and RSS feeds to the newest assets.
+	// Sitemaps list every published asset.
+	maxFeedEntries = 50
+)
+
+// FeedFormat is a rendering of an organization's feed.
+type FeedFormat string
+
+const (
+	FeedSitemap FeedFormat = "sitemap.xml"
+	FeedAtom    FeedFormat = "feed.xml"
+	FeedRSS     FeedFormat = "rss.xml"
+)
+
+var feedContentTypes = map[FeedFormat]string{
+	FeedSitemap: "application/xml; charset=utf-8",
+	FeedAtom:    "application/atom+xml; charset=utf-8",
+	FeedRSS:     "application/rss+xml; charset=utf-8",
+}
+
+// FeedRequest sets the feed of an organization. Feeds are enabled unless
+// Enabled says otherwise.
+type FeedRequest struct {
+	Enabled     *bool
+	Title       string
+	Collections []string
+	UpdatedBy   string
+}
+
+// RenderedFeed is a feed document ready to be served. ModTime is when the
+// feed or its newest asset last changed.
+type RenderedFeed struct {
+	Body        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// FeedService keeps the feed settings of organizations and renders their
+// public collections as sitemaps and feeds. Rendered documents are cached
+// for the configured TTL, as crawlers and feed readers poll them often.
+type FeedService struct {
+	feeds      metadata.FeedStore
+	files      metadata.Store
+	slugs      metadata.SlugStore
+	audits     metadata.AuditStore
+	publicBase string
+	cacheTTL   time.Duration
+	logger     *slog.Logger
+
+	mu    sync.Mutex
+	cache map[string]cachedFeed // By org ID, format and collection
+}
+
+type cachedFeed struct {
+	feed    RenderedFeed
+	expires time.Time
+}
+
+func NewFeedService(feeds metadata.FeedStore, files metadata.Store, slugs metadata.SlugStore, audits metadata.AuditStore, publicBaseURL string, cacheTTL time.Duration, logger *slog.Logger) *FeedService {
+	return &FeedService{
+		feeds:      feeds,
+		files:      files,
+		slugs:      slugs,
+		audits:     audits,
+		publicBase: strings.TrimRight(publicBaseURL, "/"),
+		cacheTTL:   cacheTTL,
+		logger:     logger,
+		cache:      make(map[string]cachedFeed),
+	}
+}
+
+func (s *FeedService) Get(ctx context.Context, orgID string) (domain.Feed, error) {
+	f, err := s.feeds.GetFeed(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.Feed{}, refuse(ErrNotFound, "Feed not found", "")
+	}
+	return f, err
+}
+
+func (s *FeedService) List(ctx context.Context) ([]domain.Feed, error) {
+	return s.feeds.ListFeeds(ctx)
+}
+
+// Put replaces the feed settings of an organization. Cached documents of
+// the organization are dropped.
+func (s *FeedService) Put(ctx context.Context, orgID string, req FeedRequest) (domain.Feed, error) {
+	if !ValidFileID(orgID) {
+		return domain.Feed{}, refuse(ErrInvalid, "Invalid organization ID", "Organization IDs are up to 128 letters, digits, '.', '_' and '-'")
+	}
+	title := strings.TrimSpace(req.Title)
+	if len(title) > maxFeedTitle {
+		return domain.Feed{}, refuse(ErrInvalid, "Invalid feed", fmt.Sprintf("title is at most %d bytes", maxFeedTitle))
+	}
+	if len(req.Collections) == 0 || len(req.Collections) > maxFeedCollections {
+		return domain.Feed{}, refuse(ErrInvalid, "Invalid feed", fmt.Sprintf("List 1 to %d public collections", maxFeedCollections))
+	}
+	for _, c := range req.Collections {
+		if !ValidCollection(c) {
+			return domain.Feed{}, refuse(ErrInvalid, "Invalid collection", fmt.Sprintf("%q is not a collection ID", c))
+		}
+	}
+	collections := slices.Clone(req.Collections)
+	slices.Sort(collections)
+
+	f := domain.Feed{
+		OrgID:       orgID,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		Title:       title,
+		Collections: slices.Compact(collections),
+		UpdatedBy:   req.UpdatedBy,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	if err := s.feeds.PutFeed(ctx, f); err != nil {
+		return domain.Feed{}, err
+	}
+	s.forget(orgID)
+	s.logger.Info("Feed updated", "orgId", orgID, "enabled", f.Enabled, "collections", f.Collections, "updatedBy", req.UpdatedBy)
+	return f, nil
+}
+
+func (s *FeedService) Delete(ctx context.Context, orgID string) error {
+	err := s.feeds.DeleteFeed(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return refuse(ErrNotFound, "Feed not found", "")
+	}
+	if err != nil {
+		return err
+	}
+	s.forget(orgID)
+	s.logger.Info("Feed deleted", "orgId", orgID)
+	return nil
+}
+
+// Render returns the document of an organization's feed in format, of one
+// of its public collections or, with collection empty, of all of them.
+// Organizations without an enabled feed have none.
+func (s *FeedService) Render(ctx context.Context, orgID string, format FeedFormat, collection string) (RenderedFeed, error) {
+	contentType, ok := feedContentTypes[format]
+	if !ok {
+		return RenderedFeed{}, refuse(ErrNotFound, "Feed not found", "")
+	}
+
+	key := orgID + "\x00" + string(format) + "\x00" + collection
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.feed, nil
+	}
+
+	f, err := s.feeds.GetFeed(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) || (err == nil && !f.Enabled) {
+		return RenderedFeed{}, refuse(ErrNotFound, "Feed not found", "")
+	}
+	if err != nil {
+		return RenderedFeed{}, fmt.Errorf("failed to read feed: %w", err)
+	}
+	collections := f.Collections
+	if collection != "" {
+		if !slices.Contains(f.Collections, collection) {
+			return RenderedFeed{}, refuse(ErrNotFound, "Feed not found", "")
+		}
+		collections = []string{collection}
+	}
+
+	entries, err := s.entries(ctx, orgID, collections, now)
+	if err != nil {
+		return RenderedFeed{}, err
+	}
+
+	self := s.publicBase + "/p/" + orgID + "/" + string(format)
+	if collection != "" {
+		self += "?collection=" + url.QueryEscape(collection)
+	}
+	ch := feed.Channel{
+		Title:   f.Title,
+		SelfURL: self,
+		Link:    s.publicBase + "/p/" + orgID + "/",
+		Updated: f.UpdatedAt,
+		Entries: entries,
+	}
+	if ch.Title == "" {
+		ch.Title = orgID
+	}
+	if len(entries) > 0 && entries[0].Updated.After(ch.Updated) {
+		ch.Updated = entries[0].Updated
+	}
+
+	var body []byte
+	switch format {
+	case FeedSitemap:
+		body, err = feed.Sitemap(entries)
+	case FeedAtom:
+		ch.Entries = entries[:min(len(entries), maxFeedEntries)]
+		body, err = feed.Atom(ch)
+	case FeedRSS:
+		ch.Entries = entries[:min(len(entries), maxFeedEntries)]
+		body, err = feed.RSS(ch)
+	}
+	if err != nil {
+		return RenderedFeed{}, fmt.Errorf("failed to render %s: %w", format, err)
+	}
+
+	rendered := RenderedFeed{Body: body, ContentType: contentType, ModTime: ch.Updated}
+	s.mu.Lock()
+	for k, c := range s.cache {
+		if !now.Before(c.expires) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = cachedFeed{feed: rendered, expires: now.Add(s.cacheTTL)}
+	s.mu.Unlock()
+	return rendered, nil
+}
+
+// entries lists the published files of the collections, most recently
+// published or updated first. Files are listed under their slug when they
+// have one.
+func (s *FeedService) entries(ctx context.Context, orgID string, collections []string, now time.Time) ([]feed.Entry, error) {
+	var files []domain.FileMetadata
+	for _, c := range collections {
+		list, err := s.files.List(ctx, metadata.Filter{OrgID: orgID, Collection: c})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		files = append(files, list...)
+	}
+
+	// Details edited after upload count as updates.
+	updates, err := s.audits.ListAudit(ctx, metadata.AuditFilter{Action: domain.AuditUpdate})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit trail: %w", err)
+	}
+	updated := make(map[string]time.Time)
+	for _, e := range updates {
+		updated[e.FileID] = e.At
+	}
+
+	slugs, err := s.slugs.ListSlugs(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list slugs: %w", err)
+	}
+	slugURLs := make(map[string]string, len(slugs))
+	for _, slug := range slugs {
+		if _, ok := slugURLs[slug.FileID]; !ok {
+			slugURLs[slug.FileID] = s.publicBase + "/p/" + orgID + "/" + slug.Name
+		}
+	}
+
+	entries := make([]feed.Entry, 0, len(files))
+	for _, meta := range files {
+		if !meta.Servable() || meta.Expired(now) || !meta.Public() || meta.AvailabilityAt(now) != domain.FileAvailable || meta.LicenseExpired(now) {
+			continue
+		}
+		// Embargoed files are published when their window opens.
+		published := meta.CreatedAt
+		if meta.AvailableFrom != nil && meta.AvailableFrom.After(published) {
+			published = *meta.AvailableFrom
+		}
+		entry := feed.Entry{
+			Title:       meta.OriginalName,
+			Summary:     meta.Description,
+			URL:         s.publicBase + "/v1/files/" + meta.ID,
+			ContentType: meta.ContentType,
+			Size:        meta.Size,
+			Author:      meta.Credit,
+			Published:   published,
+			Updated:     published,
+		}
+		if u, ok := slugURLs[meta.ID]; ok {
+			entry.URL = u
+		}
+		if entry.Title == "" {
+			entry.Title = meta.ID
+		}
+		if entry.Summary == "" {
+			entry.Summary = meta.AltText
+		}
+		if at, ok := updated[meta.ID]; ok && at.After(entry.Updated) {
+			entry.Updated = at
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Updated.After(entries[j].Updated)
+	})
+	return entries, nil
+}
+
+// forget drops the cached documents of an organization.
+func (s *FeedService) forget(orgID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.cache {
+		if strings.HasPrefix(key, orgID+"\x00") {
+			delete(s.cache, key)
+		}
+	}
+}
@@ -0,0 +1,1035 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/signedurl"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+type FileConfig struct {
+	// Watermark is blended onto JPEG and PNG images served from
+	// WatermarkDirs. Stored originals are left untouched.
+	Watermark     *imaging.Watermark
+	WatermarkDirs []string
+
+	// Brandings replaces Watermark with the logo of the organization that
+	// owns a file, if it has one; may be nil.
+	Brandings *BrandingService
+
+	// Converter serves images in other formats; may be nil.
+	Converter *imaging.Converter
+
+	// Previews renders the first page of PDF documents; may be nil.
+	Previews *imaging.PDFRenderer
+
+	// Waveforms computes the waveform peaks of audio files; may be nil.
+	Waveforms *audio.PeakExtractor
+
+	// Normalizer makes loudness-normalized copies of audio files; may be
+	// nil, in which case none are made.
+	Normalizer *audio.Normalizer
+
+	// Recognizer extracts the text of scanned images; may be nil.
+	Recognizer *imaging.TextRecognizer
+
+	// Interlacer serves generated variants of images as progressive JPEGs
+	// and interlaced PNGs, for files in ProgressiveCollections or when
+	// asked to; may be nil.
+	Interlacer             *imaging.Interlacer
+	ProgressiveCollections []string
+
+	// Posters extracts the poster frame of videos the transcoding queue
+	// has not got to yet, taken PosterOffset into the video; may be nil.
+	Posters      *video.Transcoder
+	PosterOffset time.Duration
+
+	// TeaserFormat is the format teasers are served in unless another is
+	// asked for, "webp" if empty; Teaser shapes those made on request.
+	TeaserFormat string
+	Teaser       video.TeaserOptions
+
+	// Governor admits conversions, watermarking and the generation of
+	// derivatives when the node has capacity for them; may be nil.
+	Governor *governor.Governor
+
+	// Degrade skips image variants for originals while processing is
+	// backlogged.
+	Degrade DegradeConfig
+
+	// StreamSigner signs the HLS URLs issued by StreamURL, which are
+	// valid for at most StreamURLTTL; may be nil, in which case streams
+	// are public.
+	StreamSigner *signedurl.Signer
+	StreamURLTTL time.Duration
+
+	// DownloadSigner signs the file URLs issued by SignedURL, which grant
+	// access to files of any visibility for at most DownloadURLTTL; may be
+	// nil, in which case none are issued.
+	DownloadSigner *signedurl.Signer
+	DownloadURLTTL time.Duration
+
+	// Plans rate limits the downloads of the files of organizations and
+	// decides the processing of their uploads; may be nil.
+	Plans *plans.Resolver
+
+	// Stats receives processing events; may be nil.
+	Stats *stats.Recorder
+
+	// Events publishes file events to the event broker; may be nil.
+	Events *events.Emitter
+
+	// Geo restricts the countries files are delivered to; may be nil.
+	Geo *GeoService
+
+	// LicenseExpiry is what happens to downloads of files whose license
+	// expired; LicenseBlock if empty.
+	LicenseExpiry LicenseAction
+
+	// Audit records changes to files; may be nil.
+	Audit *audit.Trail
+
+	// Retention is how long files are kept by storage directory, with
+	// RetentionDefault for other directories. RetentionForever exempts a
+	// directory from the default; files of directories without a rule
+	// are kept forever.
+	Retention map[string]time.Duration
+
+	// Region is the region of the deployment, recorded as the home region
+	// of the files stored by it; empty outside multi-region setups.
+	Region string
+
+	// Annotations are deleted with the files they are on; may be nil.
+	Annotations metadata.AnnotationStore
+
+	// Aliases and ShortLinks to a file are deleted with it; may be nil.
+	Aliases    metadata.AliasStore
+	ShortLinks metadata.ShortLinkStore
+}
+
+// FileService looks up stored files and serves them with their
+// derivatives, generating and caching derivatives on first request.
+type FileService struct {
+	storage        storage.Storage
+	metadata       metadata.Store
+	watermark      *imaging.Watermark
+	watermarkDirs  map[string]bool
+	brandings      *BrandingService
+	converter      *imaging.Converter
+	previews       *imaging.PDFRenderer
+	waveforms      *audio.PeakExtractor
+	normalizer     *audio.Normalizer
+	recognizer     *imaging.TextRecognizer
+	interlacer     *imaging.Interlacer
+	progressive    map[string]bool
+	posters        *video.Transcoder
+	posterOffset   time.Duration
+	teaserFormat   string
+	teaser         video.TeaserOptions
+	governor       *governor.Governor
+	degrade        DegradeConfig
+	degraded       atomic.Bool
+	streamSigner   *signedurl.Signer
+	streamURLTTL   time.Duration
+	downloadSigner *signedurl.Signer
+	downloadURLTTL time.Duration
+	plans          *plans.Resolver
+	stats          *stats.Recorder
+	events         *events.Emitter
+	geo            *GeoService
+	licenseExpiry  LicenseAction
+	audit          *audit.Trail
+	retain         map[string]time.Duration
+	region         string
+	annotations    metadata.AnnotationStore
+	aliases        metadata.AliasStore
+	shortLinks     metadata.ShortLinkStore
+	logger         *slog.Logger
+}
+
+func NewFileService(storage storage.Storage, metadata metadata.Store, cfg FileConfig, logger *slog.Logger) *FileService {
+	watermarkDirs := make(map[string]bool, len(cfg.WatermarkDirs))
+	for _, dir := range cfg.WatermarkDirs {
+		watermarkDirs[dir] = true
+	}
+	progressive := make(map[string]bool, len(cfg.ProgressiveCollections))
+	for _, collection := range cfg.ProgressiveCollections {
+		progressive[collection] = true
+	}
+	teaserFormat := cfg.TeaserFormat
+	if teaserFormat == "" {
+		teaserFormat = video.TeaserWebP
+	}
+	licenseExpiry := cfg.LicenseExpiry
+	if licenseExpiry == "" {
+		licenseExpiry = LicenseBlock
+	}
+
+	s := &FileService{
+		storage:        storage,
+		metadata:       metadata,
+		watermark:      cfg.Watermark,
+		watermarkDirs:  watermarkDirs,
+		brandings:      cfg.Brandings,
+		converter:      cfg.Converter,
+		previews:       cfg.Previews,
+		waveforms:      cfg.Waveforms,
+		normalizer:     cfg.Normalizer,
+		recognizer:     cfg.Recognizer,
+		interlacer:     cfg.Interlacer,
+		progressive:    progressive,
+		posters:        cfg.Posters,
+		posterOffset:   cfg.PosterOffset,
+		teaserFormat:   teaserFormat,
+		teaser:         cfg.Teaser,
+		governor:       cfg.Governor,
+		degrade:        cfg.Degrade,
+		streamSigner:   cfg.StreamSigner,
+		streamURLTTL:   cfg.StreamURLTTL,
+		plans:          cfg.Plans,
+		downloadSigner: cfg.DownloadSigner,
+		downloadURLTTL: cfg.DownloadURLTTL,
+		stats:          cfg.Stats,
+		events:         cfg.Events,
+		geo:            cfg.Geo,
+		licenseExpiry:  licenseExpiry,
+		audit:          cfg.Audit,
+		retain:         cfg.Retention,
+		region:         cfg.Region,
+		annotations:    cfg.Annotations,
+		aliases:        cfg.Aliases,
+		shortLinks:     cfg.ShortLinks,
+		logger:         logger,
+	}
+
+	metrics.NewGaugeFunc("media_degraded", "Whether serving is degraded to originals by a processing backlog.", func() float64 {
+		if s.Degraded() {
+			return 1
+		}
+		return 0
+	})
+
+	return s
+}
+
+// URL returns the public URL of a file.
+func (s *FileService) URL(id string) string {
+	return s.storage.URL(id)
+}
+
+// PreviewURL returns the URL of the preview of a file, or "" if it has none.
+func (s *FileService) PreviewURL(meta domain.FileMetadata) string {
+	if meta.ContentType != mediatype.PDF {
+		return ""
+	}
+	return s.storage.URL(meta.ID) + "/preview"
+}
+
+// HomeRegion returns the region whose deployment stores a file, or "" if
+// it is unknown.
+func (s *FileService) HomeRegion(ctx context.Context, id string) string {
+	meta, err := s.metadata.Get(ctx, id)
+	if err != nil {
+		return ""
+	}
+	return meta.Region
+}
+
+// Info returns the metadata of a file that may be served to the caller of
+// ctx.
+func (s *FileService) Info(ctx context.Context, id string) (domain.FileMetadata, error) {
+	meta, err := s.metadata.Get(ctx, id)
+	if err != nil {
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	if !meta.Servable() {
+		return domain.FileMetadata{}, unservable(meta)
+	}
+	if meta.Expired(time.Now()) {
+		// Gone as far as clients are concerned, even before the expirer
+		// gets to it.
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	if err := checkVisibility(ctx, meta); err != nil {
+		return domain.FileMetadata{}, err
+	}
+	s.auditAdminAccess(ctx, meta)
+	return meta, nil
+}
+
+// List returns the files of the caller of ctx matching filter, newest
+// first. Admins may list the files of any user or organization, which is
+// recorded in the audit trail; filter.OwnerID of other callers must be
+// empty or their own.
+func (s *FileService) List(ctx context.Context, filter metadata.Filter) ([]domain.FileMetadata, error) {
+	caller, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, refuse(ErrUnauthorized, "Authentication required", "")
+	}
+	admin := caller.HasPermission("files:admin")
+	switch {
+	case filter.OwnerID == "" && !admin:
+		filter.OwnerID = caller.UserID
+	case filter.OwnerID != caller.UserID && !admin:
+		return nil, refuse(ErrForbidden, "Insufficient permissions", "Only admins may list the files of other users")
+	}
+
+	files, err := s.metadata.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	files = slices.DeleteFunc(files, func(f domain.FileMetadata) bool { return f.Expired(now) })
+	slices.SortFunc(files, func(a, b domain.FileMetadata) int { return b.CreatedAt.Compare(a.CreatedAt) })
+
+	if filter.OwnerID != caller.UserID {
+		s.audit.Record(ctx, domain.AuditAdminAccess, "", map[string]string{
+			"access":     "list",
+			"ownerId":    filter.OwnerID,
+			"orgId":      filter.OrgID,
+			"collection": filter.Collection,
+			"files":      strconv.Itoa(len(files)),
+		})
+	}
+	return files, nil
+}
+
+// CheckAccess decides whether the content of a file may be delivered to
+// the client of ctx, under the expiry, visibility, availability window and
+// license of the file, the geo rule of its collection and the rate limit
+// of the plan of its organization.
+func (s *FileService) CheckAccess(ctx context.Context, meta domain.FileMetadata) error {
+	if meta.Expired(time.Now()) {
+		return errFileNotFound
+	}
+	if err := checkVisibility(ctx, meta); err != nil {
+		return err
+	}
+	if err := checkAvailability(meta); err != nil {
+		return err
+	}
+	if err := s.checkLicense(meta); err != nil {
+		return err
+	}
+	if err := s.geo.Check(ctx, meta); err != nil {
+		return err
+	}
+	return s.plans.Allow(ctx, meta.OrgID)
+}
+
+// deliverable returns the metadata of a file whose content may be
+// delivered.
+func (s *FileService) deliverable(ctx context.Context, id string) (domain.FileMetadata, error) {
+	meta, err := s.Info(ctx, id)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if err := s.CheckAccess(ctx, meta); err != nil {
+		return domain.FileMetadata{}, err
+	}
+	return meta, nil
+}
+
+// Delete removes a file with its derivatives. With ownerID set, only a
+// file owned by it is deleted.
+func (s *FileService) Delete(ctx context.Context, id, ownerID string) error {
+	meta, err := s.metadata.Get(ctx, id)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return errFileNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if ownerID != "" && meta.OwnerID != ownerID {
+		return refuse(ErrForbidden, "Insufficient permissions", "Only the owner of the file may delete it")
+	}
+	if caller, ok := auth.FromContext(ctx); ok && caller.UserID != meta.OwnerID {
+		s.audit.Record(ctx, domain.AuditAdminAccess, meta.ID, map[string]string{"access": "delete", "ownerId": meta.OwnerID, "orgId": meta.OrgID})
+	}
+	return s.Remove(ctx, meta, "deleted")
+}
+
+// Remove deletes a file with its derivatives and what refers to it, and
+// announces why. Unlike Delete it checks nothing about the caller. The
+// metadata is kept when the content cannot be deleted, so that the file
+// stays subject to its access checks until removed.
+func (s *FileService) Remove(ctx context.Context, meta domain.FileMetadata, reason string) error {
+	if err := s.storage.Delete(ctx, meta.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to delete file from storage: %w", err)
+	}
+	if err := s.metadata.Delete(ctx, meta.ID); err != nil {
+		return fmt.Errorf("failed to delete file metadata: %w", err)
+	}
+	s.deleteAnnotations(ctx, meta.ID)
+	s.deleteReferences(ctx, meta.ID)
+
+	s.logger.Info("File deleted", "fileId", meta.ID, "reason", reason)
+	s.audit.Record(ctx, domain.AuditDelete, meta.ID, map[string]string{"originalName": meta.OriginalName, "ownerId": meta.OwnerID, "orgId": meta.OrgID, "reason": reason})
+	s.events.Emit(events.Event{
+		Type:   events.Deleted,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"reason": reason},
+	})
+	return nil
+}
+
+// deleteAnnotations deletes the annotations on a deleted file.
+func (s *FileService) deleteAnnotations(ctx context.Context, fileID string) {
+	if s.annotations == nil {
+		return
+	}
+	annotations, err := s.annotations.ListAnnotations(ctx, fileID)
+	if err != nil {
+		s.logger.Warn("Failed to list annotations of deleted file", "fileId", fileID, "error", err)
+		return
+	}
+	for _, a := range annotations {
+		if err := s.annotations.DeleteAnnotation(ctx, a.ID); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			s.logger.Warn("Failed to delete annotation of deleted file", "fileId", fileID, "annotationId", a.ID, "error", err)
+		}
+	}
+}
+
+// deleteReferences deletes the aliases and short links to a deleted file.
+func (s *FileService) deleteReferences(ctx context.Context, fileID string) {
+	if s.aliases != nil {
+		aliases, err := s.aliases.ListAliases(ctx, fileID)
+		if err != nil {
+			s.logger.Warn("Failed to list aliases of deleted file", "fileId", fileID, "error", err)
+		}
+		for _, a := range aliases {
+			if err := s.aliases.DeleteAlias(ctx, a.ID); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				s.logger.Warn("Failed to delete alias of deleted file", "fileId", fileID, "alias", a.ID, "error", err)
+			}
+		}
+	}
+	if s.shortLinks != nil {
+		links, err := s.shortLinks.ListShortLinks(ctx, fileID)
+		if err != nil {
+			s.logger.Warn("Failed to list short links of deleted file", "fileId", fileID, "error", err)
+		}
+		for _, l := range links {
+			if err := s.shortLinks.DeleteShortLink(ctx, l.Code); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				s.logger.Warn("Failed to delete short link of deleted file", "fileId", fileID, "code", l.Code, "error", err)
+			}
+		}
+	}
+}
+
+// Open opens a file for serving. A non-zero size selects one of its avatar
+// renditions. Images in watermarked directories come with the watermark
+// applied, or the logo of the organization owning them. Such generated
+// variants are progressive or interlaced if asked to be or if the file is
+// in a progressive collection.
+func (s *FileService) Open(ctx context.Context, id string, size int, progressive bool) (*Content, error) {
+	// Content without a metadata record, left behind by a failed delete,
+	// has no owner or visibility to check and is not served.
+	meta, err := s.metadata.Get(ctx, id)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil, errFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file metadata: %w", err)
+	}
+	if !meta.Servable() {
+		return nil, unservable(meta)
+	}
+	if err := s.CheckAccess(ctx, meta); err != nil {
+		return nil, err
+	}
+
+	file, fileInfo, err := s.storage.Open(ctx, id)
+	if err != nil {
+		s.logger.Warn("File not found", "fileId", id, "error", err)
+		return nil, errFileNotFound
+	}
+	content := &Content{ReadSeeker: file, Size: fileInfo.Size, ModTime: fileInfo.CreatedAt, fileID: id, closers: []io.Closer{file}}
+
+	s.auditAdminAccess(ctx, meta)
+	contentType, orgID, collection := meta.ContentType, meta.OrgID, meta.Collection
+	var renditions []int
+	if meta.Image != nil {
+		renditions = meta.Image.Renditions
+		content.dims = image.Pt(meta.Image.Width, meta.Image.Height)
+	}
+	if contentType == "" || contentType == mediatype.OctetStream {
+		contentType = mediatype.FromExtension(fileInfo.Path)
+	}
+	if contentType == mediatype.OctetStream {
+		contentType = mediatype.Sniff(file)
+	}
+	content.ContentType = contentType
+
+	if size != 0 {
+		if !slices.Contains(renditions, size) {
+			content.Close()
+			return nil, refuse(ErrInvalid, "Invalid size", fmt.Sprintf("Available sizes: %v", renditions))
+		}
+		rendition, info, err := s.storage.OpenDerivative(ctx, id, avatarDerivative(size))
+		if err != nil {
+			content.Close()
+			return nil, fmt.Errorf("avatar rendition %d missing: %w", size, err)
+		}
+		content.closers = append(content.closers, rendition)
+		content.ReadSeeker, content.Size, content.dims = rendition, info.Size, image.Pt(size, size)
+		content.variant = avatarDerivative(size) + "."
+	}
+
+	if mark := s.watermarkFor(ctx, fileInfo.Directory, contentType, orgID); mark != nil {
+		data, err := s.watermarked(ctx, mark, id, content.variant, content, contentType, content.dims)
+		if err != nil {
+			content.Close()
+			return nil, fmt.Errorf("failed to watermark image: %w", err)
+		}
+		content.ReadSeeker, content.Size = bytes.NewReader(data), int64(len(data))
+		content.variant += watermarkDerivative(mark) + "."
+	}
+
+	if content.variant != "" && (progressive || s.progressive[collection]) && s.interlacer.Supports(contentType) {
+		// Progressive output only helps perceived loading, so the plain
+		// variant is served if it cannot be had.
+		data, err := s.interlaced(ctx, id, content.variant, content, contentType, content.dims)
+		if err == nil {
+			content.ReadSeeker, content.Size = bytes.NewReader(data), int64(len(data))
+			content.variant += progressiveDerivative + "."
+		} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+			content.Close()
+			return nil, fmt.Errorf("failed to rewind image: %w", err)
+		}
+	}
+	return content, nil
+}
+
+// progressiveDerivative names the progressive or interlaced form of a
+// variant.
+const progressiveDerivative = "progressive"
+
+// interlaced returns the progressive or interlaced form of a generated
+// variant, caching it as a derivative on first request.
+func (s *FileService) interlaced(ctx context.Context, fileID, variant string, src io.Reader, contentType string, dims image.Point) ([]byte, error) {
+	name := variant + progressiveDerivative
+	if cached, _, err := s.storage.OpenDerivative(ctx, fileID, name); err == nil {
+		defer cached.Close()
+		return io.ReadAll(cached)
+	}
+	if s.Degraded() {
+		degradedFallbacks.Inc("progressive")
+		return nil, errDegraded
+	}
+
+	release, err := s.governor.Admit(ctx, "interlace", governor.Cost{Memory: s.interlacer.Cost(dims.X, dims.Y), CPU: 1})
+	if err != nil {
+		s.logger.Warn("Image interlacing not admitted", "fileId", fileID, "error", err)
+		return nil, err
+	}
+	defer release()
+
+	var buf bytes.Buffer
+	if err := s.interlacer.Interlace(ctx, src, contentType, &buf); err != nil {
+		s.logger.Error("Image interlacing failed", "fileId", fileID, "variant", variant, "error", err)
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	s.stats.Record(stats.Jobs, int64(len(data)))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, name, bytes.NewReader(data), contentType); err != nil {
+		s.logger.Warn("Failed to cache interlaced image", "fileId", fileID, "variant", variant, "error", err)
+	}
+	return data, nil
+}
+
+// Converts reports whether files of contentType can be served in another
+// format.
+func (s *FileService) Converts(contentType string) bool {
+	return s.converter != nil && imaging.CanConvert(contentType)
+}
+
+// ConvertsTo reports whether images can be served as format.
+func (s *FileService) ConvertsTo(format imaging.Format) bool {
+	return s.converter != nil && s.converter.Supports(format)
+}
+
+// Convert returns content as format, converting and caching it as a
+// derivative on first request. content is left to the caller to close. An
+// error, including the node being too busy to convert, means the caller
+// should fall back to serving content as it is. While degraded, images not
+// converted yet are returned as they are, downscaled if they are large.
+func (s *FileService) Convert(ctx context.Context, content *Content, format imaging.Format) (*Content, error) {
+	fileID := content.fileID
+	name := content.variant + "format." + string(format)
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, fileID, name); err == nil {
+		return derivativeContent(cached, info, format.ContentType()), nil
+	}
+	if s.Degraded() {
+		return s.fallback(ctx, content, "convert")
+	}
+
+	release, err := s.governor.Admit(ctx, "convert", governor.Cost{Memory: s.converter.Cost(content.dims.X, content.dims.Y), CPU: 1})
+	if err != nil {
+		s.logger.Warn("Image conversion not admitted", "fileId", fileID, "format", format, "error", err)
+		return nil, err
+	}
+	defer release()
+
+	var buf bytes.Buffer
+	if err := s.converter.Convert(ctx, content, content.ContentType, format, &buf); err != nil {
+		s.logger.Error("Image conversion failed", "fileId", fileID, "format", format, "error", err)
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	s.stats.Record(stats.Jobs, int64(len(data)))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, name, bytes.NewReader(data), format.ContentType()); err != nil {
+		s.logger.Warn("Failed to cache converted image", "fileId", fileID, "format", format, "error", err)
+	}
+	return bytesContent(data, format.ContentType()), nil
+}
+
+// previewDerivative names the first-page preview of a PDF.
+const previewDerivative = "preview"
+
+// Preview returns a PNG of the first page of a PDF document, rendering and
+// caching it if that did not happen at upload.
+func (s *FileService) Preview(ctx context.Context, id string) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if meta.ContentType != mediatype.PDF {
+		return nil, refuse(ErrUnavailable, "Preview not available", "Previews are only generated for PDF documents")
+	}
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, id, previewDerivative); err == nil {
+		return derivativeContent(cached, info, "image/png"), nil
+	}
+	if !s.previews.Supported() {
+		return nil, refuse(ErrUnavailable, "Preview not available", "PDF rendering is not configured")
+	}
+
+	file, _, err := s.storage.Open(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open document: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+
+	preview, err := s.preview(ctx, id, data)
+	if err != nil {
+		return nil, err
+	}
+	return bytesContent(preview, "image/png"), nil
+}
+
+// preview renders the first page of a PDF and caches it as a derivative.
+func (s *FileService) preview(ctx context.Context, fileID string, data []byte) ([]byte, error) {
+	release, err := s.governor.Admit(ctx, "preview", governor.Cost{Memory: s.previews.Cost(), CPU: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var buf bytes.Buffer
+	if err := s.previews.RenderFirstPage(ctx, bytes.NewReader(data), &buf); err != nil {
+		return nil, err
+	}
+	s.stats.Record(stats.Jobs, int64(buf.Len()))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, previewDerivative, bytes.NewReader(buf.Bytes()), "image/png"); err != nil {
+		s.logger.Warn("Failed to cache PDF preview", "fileId", fileID, "error", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Rendition opens a transcoded MP4 rendition of a video, named by its
+// height as in "720p".
+func (s *FileService) Rendition(ctx context.Context, id, name string) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var job *domain.TranscodeJob
+	if meta.Video != nil {
+		job = meta.Video.Transcode
+	}
+	if job == nil || job.Status != domain.TranscodeDone {
+		return nil, refuse(ErrUnavailable, "Rendition not available", "The video has not been transcoded")
+	}
+	var names []string
+	for _, r := range job.Renditions {
+		names = append(names, domain.RenditionName(r.Height))
+	}
+	i := slices.Index(names, name)
+	if i < 0 {
+		return nil, refuse(ErrUnavailable, "Rendition not available", "Available renditions: "+strings.Join(names, ", "))
+	}
+
+	rendition, info, err := s.storage.OpenDerivative(ctx, id, domain.RenditionDerivative(job.Renditions[i].Height))
+	if err != nil {
+		return nil, fmt.Errorf("video rendition %s missing: %w", name, err)
+	}
+	return derivativeContent(rendition, info, video.MP4), nil
+}
+
+// Waveform returns the waveform peaks of an audio file as JSON, computing
+// and caching them if that did not happen at upload.
+func (s *FileService) Waveform(ctx context.Context, id string) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Audio == nil {
+		return nil, refuse(ErrUnavailable, "Waveform not available", "Waveforms are only computed for audio files")
+	}
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, id, domain.WaveformDerivative); err == nil {
+		return derivativeContent(cached, info, "application/json"), nil
+	}
+	if !s.waveforms.Supported() {
+		return nil, refuse(ErrUnavailable, "Waveform not available", "Audio decoding is not configured")
+	}
+
+	_, waveform, err := s.waveform(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return bytesContent(waveform, "application/json"), nil
+}
+
+// waveform computes the waveform of an audio file and caches its JSON as a
+// derivative.
+func (s *FileService) waveform(ctx context.Context, fileID string) (audio.Waveform, []byte, error) {
+	release, err := s.governor.Admit(ctx, "waveform", governor.Cost{Memory: s.waveforms.Cost(), CPU: 1})
+	if err != nil {
+		return audio.Waveform{}, nil, err
+	}
+	defer release()
+
+	src, _, err := s.storage.Open(ctx, fileID)
+	if err != nil {
+		return audio.Waveform{}, nil, fmt.Errorf("failed to open audio: %w", err)
+	}
+	defer src.Close()
+
+	waveform, err := s.waveforms.Peaks(ctx, src)
+	if err != nil {
+		return audio.Waveform{}, nil, err
+	}
+	data, err := json.Marshal(waveform)
+	if err != nil {
+		return audio.Waveform{}, nil, fmt.Errorf("failed to encode waveform: %w", err)
+	}
+	s.stats.Record(stats.Jobs, int64(len(data)))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, domain.WaveformDerivative, bytes.NewReader(data), "application/json"); err != nil {
+		s.logger.Warn("Failed to cache waveform", "fileId", fileID, "error", err)
+	}
+	return waveform, data, nil
+}
+
+// Normalized returns the loudness-normalized copy of an audio file, making
+// and caching it if that did not happen after upload.
+func (s *FileService) Normalized(ctx context.Context, id string) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Audio == nil {
+		return nil, refuse(ErrUnavailable, "Normalized audio not available", "Only audio files are normalized")
+	}
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, id, domain.NormalizedDerivative); err == nil {
+		return derivativeContent(cached, info, meta.ContentType), nil
+	}
+	if !s.normalizer.Supported() {
+		return nil, refuse(ErrUnavailable, "Normalized audio not available", "Loudness normalization is not enabled")
+	}
+
+	_, data, err := s.normalize(ctx, meta.ID, meta.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	return bytesContent(data, meta.ContentType), nil
+}
+
+// normalize makes the loudness-normalized copy of an audio file and caches
+// it as a derivative. It returns the measured loudness with the copy.
+func (s *FileService) normalize(ctx context.Context, fileID, contentType string) (domain.Loudness, []byte, error) {
+	release, err := s.governor.Admit(ctx, "normalize", governor.Cost{Memory: s.normalizer.Cost(), CPU: 1})
+	if err != nil {
+		return domain.Loudness{}, nil, err
+	}
+	defer release()
+
+	src, _, err := s.storage.Open(ctx, fileID)
+	if err != nil {
+		return domain.Loudness{}, nil, fmt.Errorf("failed to open audio: %w", err)
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	measured, err := s.normalizer.Normalize(ctx, src, contentType, &buf)
+	if err != nil {
+		return domain.Loudness{}, nil, err
+	}
+	s.stats.Record(stats.Jobs, int64(buf.Len()))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, domain.NormalizedDerivative, bytes.NewReader(buf.Bytes()), contentType); err != nil {
+		s.logger.Warn("Failed to cache normalized audio", "fileId", fileID, "error", err)
+	}
+	loudness := domain.Loudness{
+		Integrated: measured.Integrated,
+		TruePeak:   measured.TruePeak,
+		Range:      measured.Range,
+		Target:     s.normalizer.Target().Integrated,
+	}
+	return loudness, buf.Bytes(), nil
+}
+
+// Text returns the plain text recognized in an image, recognizing and
+// caching it if that did not happen at upload.
+func (s *FileService) Text(ctx context.Context, id string) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !imaging.Decodable(meta.ContentType) {
+		return nil, refuse(ErrUnavailable, "Text not available", "Text is only recognized in JPEG and PNG images")
+	}
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, id, domain.TextDerivative); err == nil {
+		return derivativeContent(cached, info, textContentType), nil
+	}
+	if !s.recognizer.Supported() {
+		return nil, refuse(ErrUnavailable, "Text not available", "Text recognition is not configured")
+	}
+	if err := s.checkPlan(ctx, meta.OrgID, plans.FeatureOCR); err != nil {
+		return nil, err
+	}
+
+	text, err := s.text(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return bytesContent(text, textContentType), nil
+}
+
+const textContentType = "text/plain; charset=utf-8"
+
+// text recognizes the text of an image and caches it as a derivative.
+func (s *FileService) text(ctx context.Context, fileID string) ([]byte, error) {
+	release, err := s.governor.Admit(ctx, "ocr", governor.Cost{Memory: s.recognizer.Cost(), CPU: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	src, _, err := s.storage.Open(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := s.recognizer.Recognize(ctx, src, &buf); err != nil {
+		return nil, err
+	}
+	s.stats.Record(stats.Jobs, int64(buf.Len()))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, domain.TextDerivative, bytes.NewReader(buf.Bytes()), textContentType); err != nil {
+		s.logger.Warn("Failed to cache recognized text", "fileId", fileID, "error", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Poster returns a JPEG frame of a video, extracting and caching it if the
+// transcoding queue has not done so yet.
+func (s *FileService) Poster(ctx context.Context, id string) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Video == nil {
+		return nil, refuse(ErrUnavailable, "Poster not available", "Posters are only extracted from videos")
+	}
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, id, domain.PosterDerivative); err == nil {
+		return derivativeContent(cached, info, "image/jpeg"), nil
+	}
+	if !s.posters.Supported() {
+		return nil, refuse(ErrUnavailable, "Poster not available", "Video processing is not configured")
+	}
+
+	poster, err := s.poster(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+	return bytesContent(poster, "image/jpeg"), nil
+}
+
+// poster extracts the poster frame of a video and caches it as a
+// derivative.
+func (s *FileService) poster(ctx context.Context, meta domain.FileMetadata) ([]byte, error) {
+	release, err := s.governor.Admit(ctx, "poster", governor.Cost{
+		Memory: s.posters.PosterMemory(meta.Video.Width, meta.Video.Height),
+		CPU:    1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	src, _, err := s.storage.Open(ctx, meta.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video: %w", err)
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := s.posters.Poster(ctx, src, s.posterOffset, meta.Video.Duration, &buf); err != nil {
+		return nil, err
+	}
+	s.stats.Record(stats.Jobs, int64(buf.Len()))
+	if _, err := s.storage.SaveDerivative(ctx, meta.ID, domain.PosterDerivative, bytes.NewReader(buf.Bytes()), "image/jpeg"); err != nil {
+		s.logger.Warn("Failed to cache video poster", "fileId", meta.ID, "error", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Teaser returns the looping animated preview of a video in format, or in
+// the default format if format is empty, making and caching it if the
+// transcoding queue has not done so yet.
+func (s *FileService) Teaser(ctx context.Context, id, format string) (*Content, error) {
+	if format == "" {
+		format = s.teaserFormat
+	}
+	contentType := video.TeaserContentType(format)
+	if contentType == "" {
+		return nil, refuse(ErrInvalid, "Invalid format", "Teasers are made as gif or webp")
+	}
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Video == nil {
+		return nil, refuse(ErrUnavailable, "Teaser not available", "Teasers are only made of videos")
+	}
+
+	if cached, info, err := s.storage.OpenDerivative(ctx, id, domain.TeaserDerivative(format)); err == nil {
+		return derivativeContent(cached, info, contentType), nil
+	}
+	if !s.posters.Supported() {
+		return nil, refuse(ErrUnavailable, "Teaser not available", "Video processing is not configured")
+	}
+
+	release, err := s.governor.Admit(ctx, "teaser", governor.Cost{
+		Memory: s.posters.TeaserMemory(meta.Video.Width, meta.Video.Height, s.teaser),
+		CPU:    1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	src, _, err := s.storage.Open(ctx, meta.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video: %w", err)
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := s.posters.Teaser(ctx, src, format, s.teaser, meta.Video.Duration, &buf); err != nil {
+		return nil, err
+	}
+	s.stats.Record(stats.Jobs, int64(buf.Len()))
+	if _, err := s.storage.SaveDerivative(ctx, meta.ID, domain.TeaserDerivative(format), bytes.NewReader(buf.Bytes()), contentType); err != nil {
+		s.logger.Warn("Failed to cache video teaser", "fileId", meta.ID, "format", format, "error", err)
+	}
+	return bytesContent(buf.Bytes(), contentType), nil
+}
+
+// watermarkFor returns the watermark for an image in directory, or nil if
+// it is served as stored.
+func (s *FileService) watermarkFor(ctx context.Context, directory, contentType, orgID string) *imaging.Watermark {
+	if !s.watermarkDirs[directory] || !imaging.Decodable(contentType) {
+		return nil
+	}
+	if s.brandings != nil && orgID != "" {
+		if logo := s.brandings.Watermark(ctx, orgID); logo != nil {
+			return logo
+		}
+	}
+	return s.watermark
+}
+
+func avatarDerivative(size int) string {
+	return fmt.Sprintf("avatar-%d", size)
+}
+
+func watermarkDerivative(w *imaging.Watermark) string {
+	return "watermark-" + w.Key()
+}
+
+// watermarked returns the source with mark applied, caching the result as
+// a derivative named after variant.
+func (s *FileService) watermarked(ctx context.Context, mark *imaging.Watermark, fileID, variant string, original io.Reader, contentType string, dims image.Point) ([]byte, error) {
+	name := variant + watermarkDerivative(mark)
+	if cached, _, err := s.storage.OpenDerivative(ctx, fileID, name); err == nil {
+		defer cached.Close()
+		return io.ReadAll(cached)
+	}
+
+	release, err := s.governor.Admit(ctx, "watermark", governor.Cost{Memory: mark.Cost(dims.X, dims.Y), CPU: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	data, err := io.ReadAll(original)
+	if err != nil {
+		return nil, err
+	}
+	data, err = mark.Apply(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	s.stats.Record(stats.Jobs, int64(len(data)))
+	if _, err := s.storage.SaveDerivative(ctx, fileID, name, bytes.NewReader(data), contentType); err != nil {
+		s.logger.Warn("Failed to cache watermarked image", "fileId", fileID, "error", err)
+	}
+	return data, nil
+}
+
+func derivativeContent(r io.ReadSeekCloser, info storage.FileInfo, contentType string) *Content {
+	return &Content{ReadSeeker: r, ContentType: contentType, Size: info.Size, ModTime: info.CreatedAt, closers: []io.Closer{r}}
+}
+
+func bytesContent(data []byte, contentType string) *Content {
+	return &Content{ReadSeeker: bytes.NewReader(data), ContentType: contentType, Size: int64(len(data))}
+}
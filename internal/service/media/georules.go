@@ -0,0 +1,161 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/geoip"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+const maxGeoRuleCountries = 250
+
+var geoBlocked = metrics.NewCounter("media_geo_blocked_total",
+	"Downloads refused by geo rules, by client country.", "country")
+
+// GeoRuleRequest sets the countries the files of a collection are served
+// to, as ISO 3166-1 alpha-2 codes.
+type GeoRuleRequest struct {
+	Allowed   []string
+	Blocked   []string
+	UpdatedBy string
+}
+
+// GeoService keeps the geo rules of collections and enforces them on
+// downloads, locating clients by their address.
+type GeoService struct {
+	rules   metadata.GeoRuleStore
+	locator *geoip.Locator
+	logger  *slog.Logger
+}
+
+// NewGeoService enforces rules with locator, which may be nil when clients
+// are located by a header set by a CDN instead.
+func NewGeoService(rules metadata.GeoRuleStore, locator *geoip.Locator, logger *slog.Logger) *GeoService {
+	return &GeoService{
+		rules:   rules,
+		locator: locator,
+		logger:  logger,
+	}
+}
+
+func (s *GeoService) Get(ctx context.Context, collection string) (domain.GeoRule, error) {
+	rule, err := s.rules.GetGeoRule(ctx, collection)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.GeoRule{}, refuse(ErrNotFound, "Geo rule not found", "")
+	}
+	return rule, err
+}
+
+func (s *GeoService) List(ctx context.Context) ([]domain.GeoRule, error) {
+	return s.rules.ListGeoRules(ctx)
+}
+
+// Put replaces the geo rule of a collection. It applies to downloads from
+// the next request on.
+func (s *GeoService) Put(ctx context.Context, collection string, req GeoRuleRequest) (domain.GeoRule, error) {
+	if !ValidCollection(collection) {
+		return domain.GeoRule{}, refuse(ErrInvalid, "Invalid collection", "Collection IDs are lowercase letters, digits, '.', '_' and '-'")
+	}
+	allowed, err := countryList("allowed", req.Allowed)
+	if err != nil {
+		return domain.GeoRule{}, err
+	}
+	blocked, err := countryList("blocked", req.Blocked)
+	if err != nil {
+		return domain.GeoRule{}, err
+	}
+	if len(allowed) == 0 && len(blocked) == 0 {
+		return domain.GeoRule{}, refuse(ErrInvalid, "Invalid geo rule", "Allow or block at least one country, or delete the rule")
+	}
+
+	rule := domain.GeoRule{
+		Collection: collection,
+		Allowed:    allowed,
+		Blocked:    blocked,
+		UpdatedBy:  req.UpdatedBy,
+		UpdatedAt:  time.Now().UTC(),
+	}
+	if err := s.rules.PutGeoRule(ctx, rule); err != nil {
+		return domain.GeoRule{}, err
+	}
+	s.logger.Info("Geo rule updated", "collection", collection, "allowed", allowed, "blocked", blocked, "updatedBy", req.UpdatedBy)
+	return rule, nil
+}
+
+func (s *GeoService) Delete(ctx context.Context, collection string) error {
+	err := s.rules.DeleteGeoRule(ctx, collection)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return refuse(ErrNotFound, "Geo rule not found", "")
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Geo rule deleted", "collection", collection)
+	return nil
+}
+
+// countryList normalizes a list of country codes to sorted upper case
+// without duplicates.
+func countryList(field string, codes []string) ([]string, error) {
+	if len(codes) > maxGeoRuleCountries {
+		return nil, refuse(ErrInvalid, "Invalid "+field+" countries", fmt.Sprintf("At most %d countries may be listed", maxGeoRuleCountries))
+	}
+	var countries []string
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !geoip.ValidCountry(code) {
+			return nil, refuse(ErrInvalid, "Invalid "+field+" countries", fmt.Sprintf("%q is not an ISO 3166-1 alpha-2 country code", code))
+		}
+		countries = append(countries, code)
+	}
+	slices.Sort(countries)
+	return slices.Compact(countries), nil
+}
+
+// Check decides whether a file may be delivered to the client of ctx under
+// the geo rule of its collection. Refusals are logged for audit. Work not
+// done for a client, such as background jobs and gRPC calls from other
+// services, is not restricted.
+func (s *GeoService) Check(ctx context.Context, meta domain.FileMetadata) error {
+	if s == nil || meta.Collection == "" {
+		return nil
+	}
+	client, ok := geoip.ClientFrom(ctx)
+	if !ok {
+		return nil
+	}
+	rule, err := s.rules.GetGeoRule(ctx, meta.Collection)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read geo rule: %w", err)
+	}
+
+	country := client.Country
+	if country == "" {
+		if country, err = s.locator.Country(client.Addr); err != nil {
+			s.logger.Warn("Failed to locate client", "clientIp", client.Addr, "error", err)
+		}
+	}
+	if rule.Permits(country) {
+		return nil
+	}
+
+	label := country
+	if label == "" {
+		label = "unknown"
+	}
+	geoBlocked.Inc(label)
+	s.logger.Warn("Download blocked by geo rule", "fileId", meta.ID, "collection", meta.Collection,
+		"country", label, "clientIp", client.Addr)
+	return refuse(ErrRestricted, "Unavailable in your country", "This file is not available where you are")
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+// LicenseAction is what happens to downloads of a file whose license
+// expired.
+type LicenseAction string
+
+const (
+	// LicenseBlock refuses the download.
+	LicenseBlock LicenseAction = "block"
+	// LicenseFlag serves the file and records the use of it.
+	LicenseFlag LicenseAction = "flag"
+)
+
+var expiredDownloads = metrics.NewCounter("media_expired_license_downloads_total",
+	"Downloads of files whose license expired.", "action")
+
+// checkLicense decides whether a file may be delivered as far as its
+// license is concerned. Files whose license expired are refused, or
+// served and logged when expiry only flags them.
+func (s *FileService) checkLicense(meta domain.FileMetadata) error {
+	if !meta.LicenseExpired(time.Now()) {
+		return nil
+	}
+
+	expiredDownloads.Inc(string(s.licenseExpiry))
+	expiredOn := meta.LicenseExpiresAt.UTC().Format(time.DateOnly)
+	if s.licenseExpiry == LicenseFlag {
+		s.logger.Warn("Serving file with expired license", "fileId", meta.ID, "license", meta.License,
+			"rightsHolder", meta.RightsHolder, "expiredOn", expiredOn)
+		return nil
+	}
+	return refuse(ErrRestricted, "License expired", "The license of this file expired on "+expiredOn)
+}
+
+// ExpiringLicenses lists the files whose license expires within the given
+// time from now, including those already expired, soonest first.
+func (s *FileService) ExpiringLicenses(ctx context.Context, within time.Duration) ([]domain.FileMetadata, error) {
+	if within < 0 {
+		return nil, refuse(ErrInvalid, "Invalid window", "within must not be negative")
+	}
+	files, err := s.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	deadline := time.Now().Add(within)
+	expiring := slices.DeleteFunc(files, func(m domain.FileMetadata) bool {
+		return !m.LicenseExpired(deadline)
+	})
+	slices.SortFunc(expiring, func(a, b domain.FileMetadata) int {
+		return cmp.Or(a.LicenseExpiresAt.Compare(*b.LicenseExpiresAt), cmp.Compare(a.ID, b.ID))
+	})
+	return expiring, nil
+}
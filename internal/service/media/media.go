@@ -0,0 +1,104 @@
+// Package media holds the business logic of uploading and serving files:
+// validation, upload policy, the processing pipelines and the coordination
+// of storage and metadata. It knows nothing of HTTP, so every way files get
+// in or out of the service goes through the same rules.
+package media
+
+import (
+	"errors"
+	"image"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+)
+
+// Kinds of refused requests. Errors returned by the services wrap one of
+// them, so callers can map them to the status codes of their protocol.
+var (
+	ErrNotFound      = errors.New("file not found")
+	ErrUnavailable   = errors.New("not available for this file")
+	ErrInvalid       = errors.New("invalid request")
+	ErrConflict      = errors.New("file ID already exists")
+	ErrForbidden     = errors.New("not allowed for this caller")
+	ErrUnauthorized  = errors.New("credentials required")
+	ErrUnsupported   = errors.New("unsupported file type")
+	ErrTooLarge      = errors.New("file too large")
+	ErrUnprocessable = errors.New("file cannot be processed")
+	ErrRestricted    = errors.New("unavailable for legal reasons")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Error is a request the services refused, described fit for showing the
+// client. Kind is one of the Err values above.
+type Error struct {
+	Kind   error
+	Title  string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return e.Title
+	}
+	return e.Title + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func refuse(kind error, title, detail string) error {
+	return &Error{Kind: kind, Title: title, Detail: detail}
+}
+
+var errFileNotFound = refuse(ErrNotFound, "File not found", "")
+
+// unservable is the error for a file withheld from serving: taken down
+// files are unavailable for legal reasons, the others not found.
+func unservable(meta domain.FileMetadata) error {
+	if meta.Status == domain.FileStatusTakenDown {
+		return refuse(ErrRestricted, "Taken down", "This file was taken down in response to a copyright claim")
+	}
+	return errFileNotFound
+}
+
+// collectionPattern restricts collection IDs to URL-safe slugs.
+var collectionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// fileIDPattern restricts caller-supplied file IDs to URL- and path-safe
+// keys.
+var fileIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ValidCollection reports whether id may name a collection.
+func ValidCollection(id string) bool {
+	return collectionPattern.MatchString(id)
+}
+
+// ValidFileID reports whether id may be chosen as a file ID or alias.
+func ValidFileID(id string) bool {
+	return fileIDPattern.MatchString(id)
+}
+
+// Content is a file or one of its derivatives opened for reading. The
+// caller must close it.
+type Content struct {
+	io.ReadSeeker
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+
+	fileID  string
+	variant string      // Prefix of derivatives generated from this content
+	dims    image.Point // Pixel size of images, zero if unknown
+	closers []io.Closer
+}
+
+func (c *Content) Close() error {
+	var errs []error
+	for _, closer := range c.closers {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,431 @@
+package media
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+const (
+	maxOrgName        = 200
+	maxOrgCollections = 32
+)
+
+var errOrgNotFound = refuse(ErrNotFound, "Organization not found", "")
+
+// OrgRequest provisions an organization: its default collections and the
+// settings to start them with. Settings left nil are not set up.
+type OrgRequest struct {
+	ID          string
+	Name        string
+	Collections []string
+	Quota       *QuotaRequest
+	Feed        bool            // Publish the default collections in a feed titled Name
+	GeoRule     *GeoRuleRequest // Applied to each default collection
+	CreatedBy   string
+}
+
+// OrgService provisions organizations and offboards them: their files are
+// exported to an archive, then deleted with their settings once a grace
+// period ends.
+type OrgService struct {
+	orgs      metadata.OrgStore
+	slugs     metadata.SlugStore
+	files     *FileService
+	quotas    *QuotaService
+	feeds     *FeedService
+	geo       *GeoService
+	brandings *BrandingService
+	grace     time.Duration
+	logger    *slog.Logger
+}
+
+// NewOrgService offboards organizations after grace unless a different
+// grace period is asked for.
+func NewOrgService(orgs metadata.OrgStore, slugs metadata.SlugStore, files *FileService, quotas *QuotaService, feeds *FeedService, geo *GeoService, brandings *BrandingService, grace time.Duration, logger *slog.Logger) *OrgService {
+	return &OrgService{
+		orgs:      orgs,
+		slugs:     slugs,
+		files:     files,
+		quotas:    quotas,
+		feeds:     feeds,
+		geo:       geo,
+		brandings: brandings,
+		grace:     grace,
+		logger:    logger,
+	}
+}
+
+func (s *OrgService) Get(ctx context.Context, id string) (domain.Org, error) {
+	org, err := s.orgs.GetOrg(ctx, id)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.Org{}, errOrgNotFound
+	}
+	return org, err
+}
+
+func (s *OrgService) List(ctx context.Context) ([]domain.Org, error) {
+	return s.orgs.ListOrgs(ctx)
+}
+
+// Provision records a new organization and sets up its quota, feed and the
+// geo rules of its default collections. The record is stored last, so a
+// provisioning that failed halfway can be retried.
+func (s *OrgService) Provision(ctx context.Context, req OrgRequest) (domain.Org, error) {
+	if !ValidFileID(req.ID) {
+		return domain.Org{}, refuse(ErrInvalid, "Invalid organization ID", "Organization IDs are up to 128 letters, digits, '.', '_' and '-'")
+	}
+	name := strings.TrimSpace(req.Name)
+	if len(name) > maxOrgName {
+		return domain.Org{}, refuse(ErrInvalid, "Invalid name", fmt.Sprintf("name is at most %d bytes", maxOrgName))
+	}
+	if len(req.Collections) > maxOrgCollections {
+		return domain.Org{}, refuse(ErrInvalid, "Too many collections", fmt.Sprintf("Organizations start with at most %d collections", maxOrgCollections))
+	}
+	for _, c := range req.Collections {
+		if !ValidCollection(c) {
+			return domain.Org{}, refuse(ErrInvalid, "Invalid collection", fmt.Sprintf("%q is not a collection ID", c))
+		}
+	}
+	collections := slices.Clone(req.Collections)
+	slices.Sort(collections)
+	collections = slices.Compact(collections)
+	if req.Feed && len(collections) == 0 {
+		return domain.Org{}, refuse(ErrInvalid, "Invalid feed", "A feed publishes the default collections; list at least one")
+	}
+
+	_, err := s.orgs.GetOrg(ctx, req.ID)
+	if err == nil {
+		return domain.Org{}, refuse(ErrConflict, "Organization already exists", "")
+	}
+	if !errors.Is(err, metadata.ErrNotFound) {
+		return domain.Org{}, err
+	}
+
+	if req.Quota != nil {
+		quota := *req.Quota
+		quota.UpdatedBy = req.CreatedBy
+		if _, err := s.quotas.Put(ctx, req.ID, quota); err != nil {
+			return domain.Org{}, err
+		}
+	}
+	if req.GeoRule != nil {
+		rule := *req.GeoRule
+		rule.UpdatedBy = req.CreatedBy
+		for _, c := range collections {
+			if _, err := s.geo.Put(ctx, c, rule); err != nil {
+				return domain.Org{}, err
+			}
+		}
+	}
+	if req.Feed {
+		if _, err := s.feeds.Put(ctx, req.ID, FeedRequest{Title: name, Collections: collections, UpdatedBy: req.CreatedBy}); err != nil {
+			return domain.Org{}, err
+		}
+	}
+
+	org := domain.Org{
+		ID:          req.ID,
+		Name:        name,
+		Status:      domain.OrgActive,
+		Collections: collections,
+		CreatedBy:   req.CreatedBy,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := s.orgs.PutOrg(ctx, org); err != nil {
+		return domain.Org{}, err
+	}
+	s.logger.Info("Organization provisioned", "orgId", org.ID, "collections", org.Collections, "createdBy", org.CreatedBy)
+	return org, nil
+}
+
+// Offboard stops the uploads of an organization and exports its files to
+// an archive, which is kept until one grace period after the files are
+// deleted. A grace of zero uses the default. Organizations set up before
+// provisioning was recorded can be offboarded if they store files.
+func (s *OrgService) Offboard(ctx context.Context, id string, grace time.Duration, requestedBy string) (domain.Org, error) {
+	if grace <= 0 {
+		grace = s.grace
+	}
+	org, err := s.orgs.GetOrg(ctx, id)
+	if errors.Is(err, metadata.ErrNotFound) {
+		files, err := s.files.metadata.List(ctx, metadata.Filter{OrgID: id})
+		if err != nil {
+			return domain.Org{}, err
+		}
+		if len(files) == 0 {
+			return domain.Org{}, errOrgNotFound
+		}
+		org = domain.Org{ID: id, Status: domain.OrgActive, CreatedAt: files[0].CreatedAt}
+	} else if err != nil {
+		return domain.Org{}, err
+	}
+	if org.Status != domain.OrgActive {
+		return domain.Org{}, refuse(ErrConflict, "Organization already offboarded", "The organization is "+string(org.Status))
+	}
+
+	// Uploads stop before the export lists the files.
+	now := time.Now().UTC()
+	org.Status = domain.OrgOffboarding
+	org.Offboarding = &domain.Offboarding{
+		RequestedBy: requestedBy,
+		RequestedAt: now,
+		DeleteAt:    now.Add(grace),
+	}
+	if err := s.orgs.PutOrg(ctx, org); err != nil {
+		return domain.Org{}, err
+	}
+
+	archive, err := s.export(ctx, org, requestedBy, org.Offboarding.DeleteAt.Add(grace))
+	if err != nil {
+		return domain.Org{}, fmt.Errorf("failed to export files: %w", err)
+	}
+	org.Offboarding.ExportFileID = archive.ID
+	if err := s.orgs.PutOrg(ctx, org); err != nil {
+		s.files.Remove(ctx, archive, "export failed")
+		return domain.Org{}, err
+	}
+	s.logger.Info("Organization offboarding", "orgId", org.ID, "deleteAt", org.Offboarding.DeleteAt, "exportFileId", archive.ID, "requestedBy", requestedBy)
+	return org, nil
+}
+
+// CancelOffboarding lets an organization being offboarded upload again.
+// Its export archive is deleted.
+func (s *OrgService) CancelOffboarding(ctx context.Context, id string) (domain.Org, error) {
+	org, err := s.Get(ctx, id)
+	if err != nil {
+		return domain.Org{}, err
+	}
+	if org.Status != domain.OrgOffboarding {
+		return domain.Org{}, refuse(ErrConflict, "Organization not being offboarded", "The organization is "+string(org.Status))
+	}
+	exportID := org.Offboarding.ExportFileID
+	org.Status, org.Offboarding = domain.OrgActive, nil
+	if err := s.orgs.PutOrg(ctx, org); err != nil {
+		return domain.Org{}, err
+	}
+	if archive, err := s.files.metadata.Get(ctx, exportID); err == nil {
+		if err := s.files.Remove(ctx, archive, "offboarding cancelled"); err != nil {
+			s.logger.Warn("Failed to delete export archive", "orgId", id, "fileId", exportID, "error", err)
+		}
+	}
+	s.logger.Info("Organization offboarding cancelled", "orgId", id)
+	return org, nil
+}
+
+// PurgeOffboarded deletes the files and settings of organizations whose
+// grace period ended. Their records are kept, marked offboarded.
+func (s *OrgService) PurgeOffboarded(ctx context.Context) error {
+	orgs, err := s.orgs.ListOrgs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list organizations: %w", err)
+	}
+	now := time.Now()
+	for _, org := range orgs {
+		if org.Status != domain.OrgOffboarding || now.Before(org.Offboarding.DeleteAt) {
+			continue
+		}
+		if err := s.purge(ctx, org); err != nil {
+			s.logger.Error("Failed to purge offboarded organization", "orgId", org.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+func (s *OrgService) purge(ctx context.Context, org domain.Org) error {
+	files, err := s.files.metadata.List(ctx, metadata.Filter{OrgID: org.ID})
+	if err != nil {
+		return err
+	}
+	for _, meta := range files {
+		if err := s.files.Remove(ctx, meta, "offboarded"); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return err
+		}
+	}
+
+	slugs, err := s.slugs.ListSlugs(ctx, org.ID)
+	if err != nil {
+		return err
+	}
+	for _, slug := range slugs {
+		if err := s.slugs.DeleteSlug(ctx, org.ID, slug.Name); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return err
+		}
+	}
+	for _, del := range []func(context.Context, string) error{s.quotas.Delete, s.feeds.Delete, s.brandings.Delete} {
+		if err := del(ctx, org.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	for _, c := range org.Collections {
+		if err := s.geo.Delete(ctx, c); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	if err := s.orgs.DeleteOrgSettings(ctx, org.ID); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return err
+	}
+
+	deletedAt := time.Now().UTC()
+	org.Status = domain.OrgOffboarded
+	org.Offboarding.DeletedAt = &deletedAt
+	if err := s.orgs.PutOrg(ctx, org); err != nil {
+		return err
+	}
+	s.logger.Info("Organization offboarded", "orgId", org.ID, "files", len(files))
+	return nil
+}
+
+// exportEntry describes a file in the manifest of an export archive.
+type exportEntry struct {
+	ID           string            `json:"id"`
+	Path         string            `json:"path,omitempty"` // Empty if the content was missing from storage
+	OriginalName string            `json:"originalName"`
+	ContentType  string            `json:"contentType"`
+	Size         int64             `json:"size"`
+	Collection   string            `json:"collection,omitempty"`
+	OwnerID      string            `json:"ownerId,omitempty"`
+	Checksums    map[string]string `json:"checksums,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+}
+
+// export stores a ZIP archive of the files of org, with a manifest.json
+// describing them, as a file of its own owned by the requester and outside
+// the organization, so it outlives the purge. It expires at keepUntil.
+func (s *OrgService) export(ctx context.Context, org domain.Org, ownerID string, keepUntil time.Time) (domain.FileMetadata, error) {
+	files, err := s.files.metadata.List(ctx, metadata.Filter{OrgID: org.ID})
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.writeArchive(ctx, pw, files))
+	}()
+	name := org.ID + "-export.zip"
+	info, err := s.files.storage.Save(ctx, pr, storage.SaveOptions{
+		Directory:    "files",
+		ContentType:  "application/zip",
+		OriginalName: name,
+	})
+	pr.CloseWithError(err)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	meta := domain.FileMetadata{
+		ID:           info.ID,
+		OriginalName: name,
+		ContentType:  "application/zip",
+		Size:         info.Size,
+		Path:         info.Path,
+		Directory:    info.Directory,
+		Checksums:    info.Checksums,
+		OwnerID:      ownerID,
+		Status:       domain.FileStatusActive,
+		CreatedAt:    info.CreatedAt,
+		ExpiresAt:    &keepUntil,
+		Region:       s.files.region,
+	}
+	if err := s.files.metadata.Put(ctx, meta); err != nil {
+		s.files.storage.Delete(ctx, info.ID)
+		return domain.FileMetadata{}, err
+	}
+	s.files.audit.Record(ctx, domain.AuditUpload, meta.ID, map[string]string{
+		"originalName": meta.OriginalName,
+		"contentType":  meta.ContentType,
+		"ownerId":      meta.OwnerID,
+		"exportOf":     org.ID,
+	})
+	return meta, nil
+}
+
+// writeArchive writes the content of files to w as a ZIP archive. Media is
+// compressed already, so it is stored as is.
+func (s *OrgService) writeArchive(ctx context.Context, w io.Writer, files []domain.FileMetadata) error {
+	zw := zip.NewWriter(w)
+	manifest := make([]exportEntry, 0, len(files))
+	for _, f := range files {
+		entry := exportEntry{
+			ID:           f.ID,
+			OriginalName: f.OriginalName,
+			ContentType:  f.ContentType,
+			Size:         f.Size,
+			Collection:   f.Collection,
+			OwnerID:      f.OwnerID,
+			Checksums:    f.Checksums,
+			CreatedAt:    f.CreatedAt,
+		}
+		r, _, err := s.files.storage.Open(ctx, f.ID)
+		if err != nil {
+			s.logger.Warn("File missing from storage, exporting its metadata only", "fileId", f.ID, "error", err)
+			manifest = append(manifest, entry)
+			continue
+		}
+		name := path.Base(f.OriginalName)
+		if name == "." || name == "/" {
+			name = f.ID
+		}
+		entry.Path = "files/" + f.ID + "/" + name
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: entry.Path, Method: zip.Store, Modified: f.CreatedAt})
+		if err == nil {
+			_, err = io.Copy(fw, r)
+		}
+		r.Close()
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, entry)
+	}
+
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// checkOrg refuses uploads to organizations being offboarded and uploads
+// of types the organization does not accept.
+func (s *UploadService) checkOrg(ctx context.Context, orgID, contentType string) error {
+	if s.orgs == nil || orgID == "" {
+		return nil
+	}
+	org, err := s.orgs.GetOrg(ctx, orgID)
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+	case err != nil:
+		return err
+	case org.Status != domain.OrgActive:
+		return refuse(ErrForbidden, "Organization offboarded", "The organization no longer accepts uploads")
+	}
+
+	settings, err := s.orgs.GetOrgSettings(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(settings.AllowedTypes) > 0 && !slices.Contains(settings.AllowedTypes, contentType) {
+		return refuse(ErrUnsupported, "Unsupported file type", "Allowed types: "+strings.Join(settings.AllowedTypes, ", "))
+	}
+	return nil
+}
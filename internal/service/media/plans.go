@@ -0,0 +1,44 @@
+package media
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+func notInPlan(plan plans.Plan, feature string) error {
+	return refuse(ErrForbidden, "Not included in plan", fmt.Sprintf("The %s plan of the organization does not include %s", plan.Name, feature))
+}
+
+// checkPlan refuses an upload of size bytes of contentType that the plan
+// of the organization does not allow, or that takes it over the rate limit
+// of its plan. It returns the plan, which also decides the processing of
+// the upload.
+func (s *UploadService) checkPlan(ctx context.Context, orgID, contentType string, size int64) (plans.Plan, error) {
+	if err := s.files.plans.Allow(ctx, orgID); err != nil {
+		return plans.Plan{}, err
+	}
+	plan := s.files.plans.Plan(ctx, orgID)
+	if plan.MaxFileSize > 0 && size > plan.MaxFileSize {
+		return plans.Plan{}, refuse(ErrTooLarge, "File too large", fmt.Sprintf("The %s plan allows files of up to %d bytes", plan.Name, plan.MaxFileSize))
+	}
+	switch {
+	case video.Supported(contentType) && !plan.Allows(plans.FeatureVideo):
+		return plans.Plan{}, notInPlan(plan, plans.FeatureVideo)
+	case audio.Supported(contentType) && !plan.Allows(plans.FeatureAudio):
+		return plans.Plan{}, notInPlan(plan, plans.FeatureAudio)
+	}
+	return plan, nil
+}
+
+// checkPlan refuses to generate a derivative needing feature for a file of
+// an organization whose plan does not include it.
+func (s *FileService) checkPlan(ctx context.Context, orgID, feature string) error {
+	if plan := s.plans.Plan(ctx, orgID); !plan.Allows(feature) {
+		return notInPlan(plan, feature)
+	}
+	return nil
+}
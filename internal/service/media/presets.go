@@ -0,0 +1,233 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+const (
+	maxLadderRungs      = 10
+	maxRungHeight       = 4320
+	maxRungBitrate      = 100_000 // kbit/s
+	maxAudioBitrate     = 512     // kbit/s
+	defaultAudioBitrate = 128
+)
+
+// PresetRequest sets the encoding settings of a transcode preset.
+type PresetRequest struct {
+	Codec        string // video.CodecH264 when empty
+	Ladder       []domain.LadderRung
+	AudioBitrate int // kbit/s; 0 for 128
+	Collections  []string
+	UpdatedBy    string
+}
+
+// PresetService keeps the versioned transcode presets that collections
+// are encoded with. Presets apply to videos transcoded after they change;
+// renditions made before keep recording the version they were made with.
+type PresetService struct {
+	presets metadata.PresetStore
+	logger  *slog.Logger
+}
+
+func NewPresetService(presets metadata.PresetStore, logger *slog.Logger) *PresetService {
+	return &PresetService{
+		presets: presets,
+		logger:  logger,
+	}
+}
+
+// List returns the latest version of every preset still in use.
+func (s *PresetService) List(ctx context.Context) ([]domain.TranscodePreset, error) {
+	all, err := s.presets.ListPresets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var presets []domain.TranscodePreset
+	for i, p := range all {
+		if (i+1 == len(all) || all[i+1].Name != p.Name) && !p.Deleted {
+			presets = append(presets, p)
+		}
+	}
+	return presets, nil
+}
+
+// Get returns the latest version of a preset still in use.
+func (s *PresetService) Get(ctx context.Context, name string) (domain.TranscodePreset, error) {
+	preset, err := s.latest(ctx, name)
+	if err != nil {
+		return domain.TranscodePreset{}, err
+	}
+	if preset.Deleted {
+		return domain.TranscodePreset{}, refuse(ErrNotFound, "Preset not found", "")
+	}
+	return preset, nil
+}
+
+// Versions returns every version of a preset, oldest first, including the
+// version that deleted it.
+func (s *PresetService) Versions(ctx context.Context, name string) ([]domain.TranscodePreset, error) {
+	all, err := s.presets.ListPresets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var versions []domain.TranscodePreset
+	for _, p := range all {
+		if p.Name == name {
+			versions = append(versions, p)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, refuse(ErrNotFound, "Preset not found", "")
+	}
+	return versions, nil
+}
+
+// Version returns a version of a preset, even one since changed or
+// deleted, to trace renditions to their settings.
+func (s *PresetService) Version(ctx context.Context, name string, version int) (domain.TranscodePreset, error) {
+	preset, err := s.presets.GetPreset(ctx, name, version)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.TranscodePreset{}, refuse(ErrNotFound, "Preset version not found", "")
+	}
+	return preset, err
+}
+
+// Put stores the settings as a new version of a preset, creating it if
+// need be. A collection is encoded with at most one preset.
+func (s *PresetService) Put(ctx context.Context, name string, req PresetRequest) (domain.TranscodePreset, error) {
+	if !ValidCollection(name) {
+		return domain.TranscodePreset{}, refuse(ErrInvalid, "Invalid preset name", "Preset names are lowercase letters, digits, '.', '_' and '-'")
+	}
+	preset, err := s.checkPreset(ctx, name, req)
+	if err != nil {
+		return domain.TranscodePreset{}, err
+	}
+
+	previous, err := s.latest(ctx, name)
+	switch {
+	case err == nil:
+		preset.Version = previous.Version + 1
+	case errors.Is(err, ErrNotFound):
+		preset.Version = 1
+	default:
+		return domain.TranscodePreset{}, err
+	}
+	preset.UpdatedBy, preset.UpdatedAt = req.UpdatedBy, time.Now().UTC()
+	if err := s.presets.PutPreset(ctx, preset); err != nil {
+		return domain.TranscodePreset{}, err
+	}
+	s.logger.Info("Transcode preset updated", "preset", name, "version", preset.Version, "codec", preset.Codec, "rungs", len(preset.Ladder), "updatedBy", req.UpdatedBy)
+	return preset, nil
+}
+
+// Delete retires a preset: its collections go back to the configured
+// defaults, while its versions are kept for the renditions made with them.
+func (s *PresetService) Delete(ctx context.Context, name, deletedBy string) error {
+	preset, err := s.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	preset.Version++
+	preset.Collections = nil
+	preset.Deleted = true
+	preset.UpdatedBy, preset.UpdatedAt = deletedBy, time.Now().UTC()
+	if err := s.presets.PutPreset(ctx, preset); err != nil {
+		return err
+	}
+	s.logger.Info("Transcode preset deleted", "preset", name, "version", preset.Version, "deletedBy", deletedBy)
+	return nil
+}
+
+// CollectionPreset returns the preset the videos of a collection are
+// transcoded with, if there is one.
+func (s *PresetService) CollectionPreset(ctx context.Context, collection string) (domain.TranscodePreset, bool, error) {
+	if collection == "" {
+		return domain.TranscodePreset{}, false, nil
+	}
+	presets, err := s.List(ctx)
+	if err != nil {
+		return domain.TranscodePreset{}, false, err
+	}
+	for _, p := range presets {
+		if slices.Contains(p.Collections, collection) {
+			return p, true, nil
+		}
+	}
+	return domain.TranscodePreset{}, false, nil
+}
+
+// latest returns the latest version of a preset, deleted or not.
+func (s *PresetService) latest(ctx context.Context, name string) (domain.TranscodePreset, error) {
+	versions, err := s.Versions(ctx, name)
+	if err != nil {
+		return domain.TranscodePreset{}, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// checkPreset validates the settings of a preset and returns them with the
+// ladder sorted and the defaults filled in.
+func (s *PresetService) checkPreset(ctx context.Context, name string, req PresetRequest) (domain.TranscodePreset, error) {
+	invalid := func(format string, args ...any) error {
+		return refuse(ErrInvalid, "Invalid preset", fmt.Sprintf(format, args...))
+	}
+
+	preset := domain.TranscodePreset{Name: name, Codec: req.Codec, AudioBitrate: req.AudioBitrate}
+	if preset.Codec == "" {
+		preset.Codec = video.CodecH264
+	}
+	if !video.SupportedCodec(preset.Codec) {
+		return domain.TranscodePreset{}, invalid("Unsupported codec %q, expected %s or %s", req.Codec, video.CodecH264, video.CodecH265)
+	}
+	if preset.AudioBitrate == 0 {
+		preset.AudioBitrate = defaultAudioBitrate
+	}
+	if preset.AudioBitrate < 0 || preset.AudioBitrate > maxAudioBitrate {
+		return domain.TranscodePreset{}, invalid("The audio bit rate must be between 1 and %d kbit/s", maxAudioBitrate)
+	}
+
+	if len(req.Ladder) == 0 || len(req.Ladder) > maxLadderRungs {
+		return domain.TranscodePreset{}, invalid("The ladder must have between 1 and %d rungs", maxLadderRungs)
+	}
+	for _, r := range req.Ladder {
+		switch {
+		case r.Height < 2 || r.Height > maxRungHeight || r.Height%2 != 0:
+			return domain.TranscodePreset{}, invalid("Rung height %d must be even and between 2 and %d", r.Height, maxRungHeight)
+		case r.Bitrate < 0 || r.Bitrate > maxRungBitrate:
+			return domain.TranscodePreset{}, invalid("Rung bit rate %d must be between 0 and %d kbit/s", r.Bitrate, maxRungBitrate)
+		case slices.ContainsFunc(preset.Ladder, func(l domain.LadderRung) bool { return l.Height == r.Height }):
+			return domain.TranscodePreset{}, invalid("Rung height %d is listed twice", r.Height)
+		}
+		preset.Ladder = append(preset.Ladder, r)
+	}
+	slices.SortFunc(preset.Ladder, func(a, b domain.LadderRung) int { return a.Height - b.Height })
+
+	others, err := s.List(ctx)
+	if err != nil {
+		return domain.TranscodePreset{}, err
+	}
+	for _, collection := range req.Collections {
+		if !ValidCollection(collection) {
+			return domain.TranscodePreset{}, invalid("%q is not a collection ID", collection)
+		}
+		for _, other := range others {
+			if other.Name != name && slices.Contains(other.Collections, collection) {
+				return domain.TranscodePreset{}, refuse(ErrConflict, "Collection has a preset", fmt.Sprintf("The %s collection is encoded with the %s preset", collection, other.Name))
+			}
+		}
+		if !slices.Contains(preset.Collections, collection) {
+			preset.Collections = append(preset.Collections, collection)
+		}
+	}
+	slices.Sort(preset.Collections)
+	return preset, nil
+}
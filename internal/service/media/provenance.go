@@ -0,0 +1,37 @@
+package media
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+)
+
+// provenance completes p, as declared by the channel of a request, with the
+// caller and address of ctx and the current time.
+func (s *UploadService) provenance(ctx context.Context, p domain.Provenance) domain.Provenance {
+	if caller, ok := auth.FromContext(ctx); ok {
+		p.ActorID = caller.UserID
+	}
+	p.ClientIP = audit.ClientIP(ctx)
+	p.Headers = maps.Clone(p.Headers)
+	p.At = time.Now().UTC()
+	return p
+}
+
+// provenanceDetails are the audit details recording p; headers are kept
+// under header.<name>.
+func provenanceDetails(p domain.Provenance) map[string]string {
+	details := map[string]string{
+		"channel": p.Channel,
+		"client":  p.Client,
+		"from":    p.From,
+	}
+	for name, value := range p.Headers {
+		details["header."+name] = value
+	}
+	return details
+}
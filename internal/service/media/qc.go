@@ -0,0 +1,172 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/jobs"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/moderation"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+// QC policies: what happens to files failing a quality check.
+const (
+	QCRecord = "record" // Only record the findings
+	QCFlag   = "flag"   // Quarantine the file for review
+	QCReject = "reject" // Refuse the upload
+)
+
+// qcName is who failing files are flagged by.
+const qcName = "qc"
+
+// qcApplies reports whether an upload is a delivery whose quality is
+// checked.
+func (s *UploadService) qcApplies(meta domain.FileMetadata) bool {
+	return s.qc.Supported() && s.qcCollections[meta.Collection] &&
+		(video.Supported(meta.ContentType) || audio.Supported(meta.ContentType))
+}
+
+// checkUpload checks the quality of a new upload before its metadata is
+// first stored. Under the reject policy, and without a job queue, the
+// checks run in place; otherwise they are left pending for a QC job.
+// Failing uploads are refused under the reject policy.
+func (s *UploadService) checkUpload(ctx context.Context, meta *domain.FileMetadata) error {
+	if s.qcPolicy != QCReject && s.jobs != nil {
+		meta.QC = &domain.QualityCheck{Status: domain.QCPending}
+		return nil
+	}
+	result, err := s.checkQuality(ctx, *meta)
+	if err != nil {
+		if s.qcPolicy == QCReject {
+			return err
+		}
+		s.logger.Warn("Failed to check file quality", "fileId", meta.ID, "error", err)
+	}
+	if result.Status == domain.QCFailed && s.qcPolicy == QCReject {
+		s.logger.Warn("Upload failed quality checks", "fileId", meta.ID, "checks", qcChecks(result.Findings))
+		return refuse(ErrUnprocessable, "Quality check failed", qcSummary(result.Findings))
+	}
+	s.applyQC(meta, result)
+	return nil
+}
+
+// checkQuality runs the quality checks over the stored file. The result
+// records a failure to run them as well as the error.
+func (s *UploadService) checkQuality(ctx context.Context, meta domain.FileMetadata) (domain.QualityCheck, error) {
+	failed := func(err error) (domain.QualityCheck, error) {
+		return domain.QualityCheck{Status: domain.QCError, Error: err.Error(), CheckedAt: time.Now().UTC()}, err
+	}
+
+	release, err := s.files.governor.Admit(ctx, "qc", governor.Cost{Memory: s.qc.Cost(), CPU: 1})
+	if err != nil {
+		return failed(err)
+	}
+	defer release()
+
+	r, _, err := s.files.storage.Open(ctx, meta.ID)
+	if err != nil {
+		return failed(fmt.Errorf("failed to open file: %w", err))
+	}
+	defer r.Close()
+
+	found, err := s.qc.Check(ctx, r, video.Supported(meta.ContentType))
+	if err != nil {
+		return failed(err)
+	}
+	result := domain.QualityCheck{Status: domain.QCPassed, CheckedAt: time.Now().UTC()}
+	for _, f := range found {
+		result.Findings = append(result.Findings, domain.QCFinding{Check: f.Check, Start: f.Start, End: f.End, Detail: f.Detail})
+	}
+	if len(result.Findings) > 0 {
+		result.Status = domain.QCFailed
+	}
+	return result, nil
+}
+
+// applyQC records the result on meta and quarantines failing files under
+// the flag policy, unless they are withheld already.
+func (s *UploadService) applyQC(meta *domain.FileMetadata, result domain.QualityCheck) {
+	meta.QC = &result
+	if result.Status == domain.QCFailed && s.qcPolicy == QCFlag && meta.Servable() {
+		moderation.Quarantine(meta, "quality check failed: "+qcChecks(result.Findings), qcName)
+		s.logger.Warn("File failed quality checks", "fileId", meta.ID, "checks", qcChecks(result.Findings))
+	}
+}
+
+// qcChecks lists the checks failed, each once.
+func qcChecks(findings []domain.QCFinding) string {
+	var checks []string
+	for _, f := range findings {
+		if !slices.Contains(checks, f.Check) {
+			checks = append(checks, f.Check)
+		}
+	}
+	return strings.Join(checks, ", ")
+}
+
+func qcSummary(findings []domain.QCFinding) string {
+	parts := make([]string, 0, len(findings))
+	for _, f := range findings {
+		if f.End > 0 {
+			parts = append(parts, fmt.Sprintf("%s at %.1fs-%.1fs: %s", f.Check, f.Start, f.End, f.Detail))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: %s", f.Check, f.Detail))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// QCJob checks the quality of an uploaded delivery. It handles jobs of kind
+// domain.JobQC.
+func (s *UploadService) QCJob(ctx context.Context, job domain.Job) error {
+	store := s.files.metadata
+	meta, err := store.Get(ctx, job.FileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if !s.qc.Supported() {
+		return jobs.Permanent(fmt.Errorf("quality checks are not supported"))
+	}
+	if meta.QC != nil && (meta.QC.Status == domain.QCPassed || meta.QC.Status == domain.QCFailed) {
+		return nil
+	}
+
+	result, checkErr := s.checkQuality(ctx, meta)
+
+	// Read again so changes made while checking are kept.
+	meta, err = store.Get(ctx, job.FileID)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	wasQuarantined := !meta.Servable()
+	s.applyQC(&meta, result)
+	if err := store.Put(ctx, meta); err != nil {
+		return err
+	}
+	if checkErr != nil {
+		// Kept as an error unless a retry succeeds.
+		return checkErr
+	}
+	if !wasQuarantined && !meta.Servable() {
+		s.files.audit.Record(ctx, domain.AuditFlag, meta.ID, map[string]string{"reason": meta.Quarantine.Reason})
+	}
+	s.events.Emit(events.Event{
+		Type:   events.Processed,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"kind": domain.JobQC, "status": domain.JobDone, "qc": string(result.Status), "checks": qcChecks(result.Findings)},
+	})
+	return nil
+}
@@ -0,0 +1,342 @@
+package media
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/webhook"
+)
+
+// QuotaLimits bound what an organization stores; zero is no limit.
+type QuotaLimits struct {
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// QuotaRequest sets the quota of an organization. A zero limit falls back
+// to the default; a negative one lifts it.
+type QuotaRequest struct {
+	MaxBytes  int64
+	MaxFiles  int64
+	UpdatedBy string
+}
+
+// Usage is what an organization stores against the limits that apply to
+// it. Bytes counts originals; derivatives are the service's to keep.
+type Usage struct {
+	OrgID  string
+	Files  int64
+	Bytes  int64
+	Limits QuotaLimits
+	Plan   plans.Plan
+
+	// ByType breaks the usage down by content type.
+	ByType map[string]TypeUsage
+}
+
+type TypeUsage struct {
+	Files int64
+	Bytes int64
+}
+
+// ReadOnly reports whether the organization has reached one of its limits,
+// which stops its uploads until it is back under.
+func (u Usage) ReadOnly() bool {
+	return reached(u.Files, u.Limits.MaxFiles) || reached(u.Bytes, u.Limits.MaxBytes)
+}
+
+func reached(used, limit int64) bool {
+	return limit > 0 && used >= limit
+}
+
+// QuotaService enforces the storage and file count quotas of
+// organizations and reports their usage. Usage is counted from the file
+// metadata on every check, so it is exact but only checked before an
+// upload is stored: uploads running concurrently may together overshoot.
+//
+// An organization that reaches a limit is read-only: its files are served
+// as before, but its uploads are refused until it deletes files or its
+// quota is raised. An upload begun under the storage limit may take it
+// over by the grace percentage, so that it is not refused just short of it.
+type QuotaService struct {
+	quotas   metadata.QuotaStore
+	files    metadata.Store
+	metering metadata.MeteringStore
+	defaults QuotaLimits
+	plans    *plans.Resolver
+	warnAt   []int
+	grace    int
+	events   *events.Emitter
+	notifier *webhook.Notifier
+	logger   *slog.Logger
+}
+
+// NewQuotaService applies the limits of their plan, else defaults, to
+// organizations without a quota of their own. plans may be nil. Uploads
+// crossing one of the warnAt percentages of a limit emit a quota warning
+// event, and those reaching it a quota exceeded event, also sent to
+// notifier.
+func NewQuotaService(quotas metadata.QuotaStore, files metadata.Store, metering metadata.MeteringStore, defaults QuotaLimits, plans *plans.Resolver, warnAt []int, grace int, events *events.Emitter, notifier *webhook.Notifier, logger *slog.Logger) *QuotaService {
+	warnAt = slices.Clone(warnAt)
+	slices.Sort(warnAt)
+	return &QuotaService{
+		quotas:   quotas,
+		files:    files,
+		metering: metering,
+		defaults: defaults,
+		plans:    plans,
+		warnAt:   slices.Compact(warnAt),
+		grace:    grace,
+		events:   events,
+		notifier: notifier,
+		logger:   logger,
+	}
+}
+
+func (s *QuotaService) Get(ctx context.Context, orgID string) (domain.Quota, error) {
+	quota, err := s.quotas.GetQuota(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.Quota{}, refuse(ErrNotFound, "Quota not found", "")
+	}
+	return quota, err
+}
+
+func (s *QuotaService) List(ctx context.Context) ([]domain.Quota, error) {
+	return s.quotas.ListQuotas(ctx)
+}
+
+// Put replaces the quota of an organization.
+func (s *QuotaService) Put(ctx context.Context, orgID string, req QuotaRequest) (domain.Quota, error) {
+	if !ValidFileID(orgID) {
+		return domain.Quota{}, refuse(ErrInvalid, "Invalid organization ID", "Organization IDs are up to 128 letters, digits, '.', '_' and '-'")
+	}
+	quota := domain.Quota{
+		OrgID:     orgID,
+		MaxBytes:  req.MaxBytes,
+		MaxFiles:  req.MaxFiles,
+		UpdatedBy: req.UpdatedBy,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if err := s.quotas.PutQuota(ctx, quota); err != nil {
+		return domain.Quota{}, err
+	}
+	s.logger.Info("Quota updated", "orgId", orgID, "maxBytes", quota.MaxBytes, "maxFiles", quota.MaxFiles, "updatedBy", req.UpdatedBy)
+	return quota, nil
+}
+
+// Delete returns an organization to the default limits.
+func (s *QuotaService) Delete(ctx context.Context, orgID string) error {
+	err := s.quotas.DeleteQuota(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return refuse(ErrNotFound, "Quota not found", "")
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Quota deleted", "orgId", orgID)
+	return nil
+}
+
+// Limits returns the limits that apply to an organization: its quota,
+// else those of its plan, else the defaults.
+func (s *QuotaService) Limits(ctx context.Context, orgID string) (QuotaLimits, error) {
+	limits := s.defaults
+	plan := s.plans.Plan(ctx, orgID)
+	limits.MaxBytes = cmp.Or(plan.MaxBytes, limits.MaxBytes)
+	limits.MaxFiles = cmp.Or(plan.MaxFiles, limits.MaxFiles)
+	quota, err := s.quotas.GetQuota(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return limits, nil
+	}
+	if err != nil {
+		return QuotaLimits{}, fmt.Errorf("failed to read quota: %w", err)
+	}
+	limits.MaxBytes = override(limits.MaxBytes, quota.MaxBytes)
+	limits.MaxFiles = override(limits.MaxFiles, quota.MaxFiles)
+	return limits, nil
+}
+
+func override(limit, quota int64) int64 {
+	switch {
+	case quota < 0:
+		return 0
+	case quota > 0:
+		return quota
+	}
+	return limit
+}
+
+// Usage counts what an organization stores, including files withheld from
+// serving, as they take up space all the same.
+func (s *QuotaService) Usage(ctx context.Context, orgID string) (Usage, error) {
+	limits, err := s.Limits(ctx, orgID)
+	if err != nil {
+		return Usage{}, err
+	}
+	files, err := s.files.List(ctx, metadata.Filter{OrgID: orgID})
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	usage := Usage{OrgID: orgID, Limits: limits, Plan: s.plans.Plan(ctx, orgID), ByType: make(map[string]TypeUsage)}
+	for _, meta := range files {
+		usage.Files++
+		usage.Bytes += meta.Size
+		t := usage.ByType[meta.ContentType]
+		t.Files++
+		t.Bytes += meta.Size
+		usage.ByType[meta.ContentType] = t
+	}
+	return usage, nil
+}
+
+// Processing returns the processing an organization was billed for, by
+// month and job kind.
+func (s *QuotaService) Processing(ctx context.Context, orgID string) ([]domain.ProcessingUsage, error) {
+	usage, err := s.metering.ListProcessingUsage(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processing usage: %w", err)
+	}
+	return usage, nil
+}
+
+// Check refuses an upload of size bytes to a read-only organization, or
+// one that would take it over its storage quota by more than the grace. It
+// returns the usage before the upload, to be passed to Observe once the
+// upload is stored. Uploads outside organizations are not limited.
+func (s *QuotaService) Check(ctx context.Context, orgID string, size int64) (Usage, error) {
+	if s == nil || orgID == "" {
+		return Usage{}, nil
+	}
+	usage, err := s.Usage(ctx, orgID)
+	if err != nil {
+		return Usage{}, err
+	}
+	if usage.ReadOnly() {
+		return Usage{}, readOnly(usage)
+	}
+	if limit := usage.Limits.MaxBytes; limit > 0 && usage.Bytes+size > s.withGrace(limit) {
+		return Usage{}, refuse(ErrQuotaExceeded, "Quota exceeded", fmt.Sprintf("The organization stores %d of its %d bytes; the file needs %d more. Upload a smaller file, delete files or raise the quota.", usage.Bytes, limit, size))
+	}
+	return usage, nil
+}
+
+// CheckReplace refuses to replace content of replaced bytes with size
+// bytes if the organization is read-only or that would take it over its
+// storage quota by more than the grace. Replacements that do not grow the
+// content are always allowed; the number of files does not change.
+func (s *QuotaService) CheckReplace(ctx context.Context, orgID string, size, replaced int64) error {
+	if s == nil || orgID == "" || size <= replaced {
+		return nil
+	}
+	usage, err := s.Usage(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if usage.ReadOnly() {
+		return readOnly(usage)
+	}
+	if limit := usage.Limits.MaxBytes; limit > 0 && usage.Bytes-replaced+size > s.withGrace(limit) {
+		return refuse(ErrQuotaExceeded, "Quota exceeded", fmt.Sprintf("The organization stores %d of its %d bytes; the new content needs %d more. Upload smaller content, delete files or raise the quota.", usage.Bytes, limit, size-replaced))
+	}
+	return nil
+}
+
+// withGrace returns how far an upload may take usage over limit.
+func (s *QuotaService) withGrace(limit int64) int64 {
+	return limit + limit*int64(s.grace)/100
+}
+
+// readOnly refuses an upload of an organization that reached one of its
+// limits, telling how to get out of that state.
+func readOnly(usage Usage) error {
+	var used []string
+	if limit := usage.Limits.MaxBytes; limit > 0 {
+		used = append(used, fmt.Sprintf("%d of its %d bytes", usage.Bytes, limit))
+	}
+	if limit := usage.Limits.MaxFiles; limit > 0 {
+		used = append(used, fmt.Sprintf("%d of its %d files", usage.Files, limit))
+	}
+	return refuse(ErrQuotaExceeded, "Organization is read-only", fmt.Sprintf("The organization stores %s. Its files are still served; delete files or raise the quota to upload again.", strings.Join(used, " and ")))
+}
+
+// Observe emits a quota warning for each limit the stored file took its
+// organization past one of the configured percentages of, and a quota
+// exceeded event for each limit it took it to.
+func (s *QuotaService) Observe(before Usage, meta domain.FileMetadata) {
+	if s == nil || meta.OrgID == "" {
+		return
+	}
+	s.warn(meta, "bytes", before.Bytes, before.Bytes+meta.Size, before.Limits.MaxBytes)
+	s.warn(meta, "files", before.Files, before.Files+1, before.Limits.MaxFiles)
+	s.exceed(meta, "bytes", before.Bytes, before.Bytes+meta.Size, before.Limits.MaxBytes)
+	s.exceed(meta, "files", before.Files, before.Files+1, before.Limits.MaxFiles)
+}
+
+// exceed announces that an organization turned read-only, on its own
+// webhooks and the platform's.
+func (s *QuotaService) exceed(meta domain.FileMetadata, resource string, before, after, limit int64) {
+	if reached(before, limit) || !reached(after, limit) {
+		return
+	}
+	data := map[string]any{
+		"orgId":    meta.OrgID,
+		"resource": resource,
+		"used":     after,
+		"limit":    limit,
+	}
+	s.logger.Warn("Organization quota exceeded, uploads stopped", "orgId", meta.OrgID, "resource", resource, "used", after, "limit", limit)
+	s.events.Emit(events.Event{
+		Type:   events.QuotaExceeded,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   data,
+	})
+	s.notifier.Notify(webhook.Event{
+		Type:   events.QuotaExceeded,
+		FileID: meta.ID,
+		OrgID:  meta.OrgID,
+		Data:   data,
+	})
+}
+
+func (s *QuotaService) warn(meta domain.FileMetadata, resource string, before, after, limit int64) {
+	if limit <= 0 {
+		return
+	}
+	// Only the highest percentage crossed is announced.
+	crossed := 0
+	for _, percent := range s.warnAt {
+		threshold := limit * int64(percent) / 100
+		if before < threshold && after >= threshold {
+			crossed = percent
+		}
+	}
+	if crossed == 0 {
+		return
+	}
+
+	s.logger.Warn("Organization quota nearly used", "orgId", meta.OrgID, "resource", resource, "percent", crossed, "used", after, "limit", limit)
+	s.events.Emit(events.Event{
+		Type:   events.QuotaWarning,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data: map[string]any{
+			"orgId":    meta.OrgID,
+			"resource": resource,
+			"percent":  crossed,
+			"used":     after,
+			"limit":    limit,
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var (
+	retentionFiles = metrics.NewCounter("media_retention_purged_files_total",
+		"Files deleted by the retention rule of their directory.", "directory")
+	retentionBytes = metrics.NewCounter("media_retention_purged_bytes_total",
+		"Bytes of originals deleted by the retention rule of their directory.", "directory")
+)
+
+// RetentionDefault keys the retention rule of directories without one of
+// their own.
+const RetentionDefault = "*"
+
+// RetentionForever keeps the files of a directory whatever the default.
+const RetentionForever time.Duration = -1
+
+// retention returns how long files stored in directory are kept; zero or
+// less keeps them forever.
+func (s *FileService) retention(directory string) time.Duration {
+	if keep, ok := s.retain[directory]; ok {
+		return keep
+	}
+	return s.retain[RetentionDefault]
+}
+
+// Retains reports whether any directory has a retention rule.
+func (s *FileService) Retains() bool {
+	return len(s.retain) > 0
+}
+
+// PurgeRetained deletes the files kept longer than the retention rule of
+// their directory.
+func (s *FileService) PurgeRetained(ctx context.Context) error {
+	files, err := s.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		return fmt.Errorf("failed to list files for retention: %w", err)
+	}
+	now := time.Now()
+	var purged int
+	for _, meta := range files {
+		keep := s.retention(meta.Directory)
+		if keep <= 0 || now.Sub(meta.CreatedAt) < keep {
+			continue
+		}
+		if err := s.Remove(ctx, meta, "retention"); err != nil {
+			if !errors.Is(err, metadata.ErrNotFound) {
+				s.logger.Error("Failed to delete file past retention", "fileId", meta.ID, "directory", meta.Directory, "error", err)
+			}
+			continue
+		}
+		s.logger.Info("File purged by retention", "fileId", meta.ID, "directory", meta.Directory, "createdAt", meta.CreatedAt, "retention", keep)
+		retentionFiles.Inc(meta.Directory)
+		retentionBytes.Add(float64(meta.Size), meta.Directory)
+		purged++
+	}
+	if purged > 0 {
+		s.logger.Info("Retention run finished", "purged", purged)
+	}
+	return nil
+}
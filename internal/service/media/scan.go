@@ -0,0 +1,135 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/checksum"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/jobs"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/moderation"
+)
+
+// scannerName is who infected files are flagged by.
+const scannerName = "clamav"
+
+// scanUpload scans a new upload before its metadata is first stored.
+// Uploads over the synchronous size limit are left pending for a scan job,
+// as are uploads the scan failed for; without a job queue every upload is
+// scanned in place. Content scanned recently for another upload is not
+// scanned again.
+func (s *UploadService) scanUpload(ctx context.Context, meta *domain.FileMetadata) {
+	if result, ok := s.cachedScan(*meta); ok {
+		s.applyScan(meta, result)
+		return
+	}
+	if s.jobs != nil && meta.Size > s.scanSyncMaxSize {
+		meta.Scan = &domain.Scan{Status: domain.ScanPending}
+		return
+	}
+	result := s.scan(ctx, meta.ID)
+	s.validations.scanned(meta.Checksums[checksum.SHA256], result, meta.ID)
+	if result.Status == domain.ScanFailed && s.jobs != nil {
+		s.logger.Warn("Failed to scan file, queueing a retry", "fileId", meta.ID, "error", result.Error)
+		result = domain.Scan{Status: domain.ScanPending}
+	}
+	s.applyScan(meta, result)
+}
+
+// scan streams the stored file to the scanner. Failures are reported in
+// the result rather than as an error.
+func (s *UploadService) scan(ctx context.Context, id string) domain.Scan {
+	r, _, err := s.files.storage.Open(ctx, id)
+	if err != nil {
+		return domain.Scan{Status: domain.ScanFailed, Error: fmt.Sprintf("failed to open file: %v", err), ScannedAt: time.Now().UTC()}
+	}
+	defer r.Close()
+
+	result, err := s.scanner.Scan(ctx, r)
+	if err != nil {
+		return domain.Scan{Status: domain.ScanFailed, Error: err.Error(), ScannedAt: time.Now().UTC()}
+	}
+	if result.Infected {
+		return domain.Scan{Status: domain.ScanInfected, Signature: result.Signature, ScannedAt: time.Now().UTC()}
+	}
+	return domain.Scan{Status: domain.ScanClean, ScannedAt: time.Now().UTC()}
+}
+
+// cachedScan returns the scan of an earlier upload of the same content as
+// meta, if there was one recently.
+func (s *UploadService) cachedScan(meta domain.FileMetadata) (domain.Scan, bool) {
+	v, ok := s.validations.get(meta.Checksums[checksum.SHA256])
+	if !ok || v.scan == nil || v.scannedBy == meta.ID {
+		return domain.Scan{}, false
+	}
+	result := *v.scan
+	result.ReusedFrom = v.scannedBy
+	validationReused.Inc("scan")
+	return result, true
+}
+
+// applyScan records the result on meta and quarantines infected files.
+func (s *UploadService) applyScan(meta *domain.FileMetadata, result domain.Scan) {
+	meta.Scan = &result
+	if result.Status == domain.ScanInfected {
+		// A file taken down meanwhile stays withheld as it is.
+		if err := moderation.Quarantine(meta, "malware: "+result.Signature, scannerName); err != nil {
+			s.logger.Warn("Malware found in file taken down", "fileId", meta.ID, "signature", result.Signature)
+			return
+		}
+		s.logger.Warn("Malware found in upload", "fileId", meta.ID, "signature", result.Signature)
+	}
+}
+
+// ScanJob scans an upload that was too large to scan synchronously, or
+// whose synchronous scan failed. It handles jobs of kind domain.JobScan.
+func (s *UploadService) ScanJob(ctx context.Context, job domain.Job) error {
+	store := s.files.metadata
+	meta, err := store.Get(ctx, job.FileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if !s.scanner.Enabled() {
+		return jobs.Permanent(fmt.Errorf("malware scanning is not configured"))
+	}
+	if meta.Scan != nil && (meta.Scan.Status == domain.ScanClean || meta.Scan.Status == domain.ScanInfected) {
+		return nil
+	}
+
+	result, ok := s.cachedScan(meta)
+	if !ok {
+		result = s.scan(ctx, job.FileID)
+		s.validations.scanned(meta.Checksums[checksum.SHA256], result, meta.ID)
+	}
+
+	// Read again so changes made while scanning are kept.
+	meta, err = store.Get(ctx, job.FileID)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	s.applyScan(&meta, result)
+	if err := store.Put(ctx, meta); err != nil {
+		return err
+	}
+	if result.Status == domain.ScanFailed {
+		// Kept as failed unless a retry succeeds.
+		return errors.New(result.Error)
+	}
+	if result.Status == domain.ScanInfected {
+		s.files.audit.Record(ctx, domain.AuditFlag, meta.ID, map[string]string{"reason": meta.Quarantine.Reason})
+	}
+	s.events.Emit(events.Event{
+		Type:   events.Processed,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"kind": domain.JobScan, "status": domain.JobDone, "scan": string(result.Status), "signature": result.Signature},
+	})
+	return nil
+}
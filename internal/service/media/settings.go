@@ -0,0 +1,278 @@
+package media
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/webhook"
+)
+
+// The settings organizations may be permitted to change themselves.
+const (
+	SettingWebhooks   = "webhooks"
+	SettingTypes      = "types"
+	SettingBranding   = "branding"
+	SettingVisibility = "visibility"
+)
+
+const maxWebhooks = 5
+
+// Settings are the self-service settings of an organization.
+type Settings struct {
+	domain.OrgSettings
+	Branding  *domain.Branding // Nil if the organization has none
+	Permitted []string         // What the organization may change
+}
+
+// SettingsRequest changes the settings of an organization. Nil fields are
+// left as they are; empty lists clear them.
+type SettingsRequest struct {
+	WebhookURLs  *[]string
+	AllowedTypes *[]string
+	Branding     *BrandingRequest // Replaces the branding
+	UpdatedBy    string
+
+	// DefaultVisibility of uploads; "" for the default of the service.
+	DefaultVisibility *domain.Visibility
+}
+
+// SettingsService lets organization admins change the settings the
+// platform permits them to, and delivers file events to the webhooks they
+// set.
+type SettingsService struct {
+	settings  metadata.OrgStore
+	files     metadata.Store
+	brandings *BrandingService
+	uploads   *UploadService
+	permitted []string
+	audit     *audit.Trail
+	logger    *slog.Logger
+}
+
+// NewSettingsService permits organizations to change the settings named
+// in permitted: SettingWebhooks, SettingTypes, SettingBranding and
+// SettingVisibility.
+func NewSettingsService(settings metadata.OrgStore, files metadata.Store, brandings *BrandingService, uploads *UploadService, permitted []string, trail *audit.Trail, logger *slog.Logger) *SettingsService {
+	permitted = slices.Clone(permitted)
+	slices.Sort(permitted)
+	return &SettingsService{
+		settings:  settings,
+		files:     files,
+		brandings: brandings,
+		uploads:   uploads,
+		permitted: slices.Compact(permitted),
+		audit:     trail,
+		logger:    logger,
+	}
+}
+
+func (s *SettingsService) Get(ctx context.Context, orgID string) (Settings, error) {
+	if !ValidFileID(orgID) {
+		return Settings{}, refuse(ErrInvalid, "Invalid organization ID", "Organization IDs are up to 128 letters, digits, '.', '_' and '-'")
+	}
+	settings, err := s.settings.GetOrgSettings(ctx, orgID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		settings = domain.OrgSettings{OrgID: orgID}
+	} else if err != nil {
+		return Settings{}, err
+	}
+
+	result := Settings{OrgSettings: settings, Permitted: s.permitted}
+	branding, err := s.brandings.Get(ctx, orgID)
+	switch {
+	case err == nil:
+		result.Branding = &branding
+	case !errors.Is(err, ErrNotFound):
+		return Settings{}, err
+	}
+	return result, nil
+}
+
+// Put validates and applies a change to the settings of an organization
+// and records it in the audit log. Changing a setting the platform does
+// not permit is refused.
+func (s *SettingsService) Put(ctx context.Context, orgID string, req SettingsRequest) (Settings, error) {
+	current, err := s.Get(ctx, orgID)
+	if err != nil {
+		return Settings{}, err
+	}
+	settings := current.OrgSettings
+	changed := map[string]string{"orgId": orgID}
+
+	if req.WebhookURLs != nil {
+		if err := s.checkPermitted(SettingWebhooks); err != nil {
+			return Settings{}, err
+		}
+		urls, err := webhookURLs(*req.WebhookURLs)
+		if err != nil {
+			return Settings{}, err
+		}
+		settings.WebhookURLs = urls
+		if len(urls) > 0 && settings.WebhookSecret == "" {
+			settings.WebhookSecret = newWebhookSecret()
+		}
+		changed["webhookUrls"] = strings.Join(urls, " ")
+	}
+	if req.AllowedTypes != nil {
+		if err := s.checkPermitted(SettingTypes); err != nil {
+			return Settings{}, err
+		}
+		types := make([]string, 0, len(*req.AllowedTypes))
+		for _, t := range *req.AllowedTypes {
+			t = mediatype.Normalize(t)
+			if !s.uploads.Accepts(t) {
+				return Settings{}, refuse(ErrInvalid, "Invalid allowed types", fmt.Sprintf("%q is not a type the service accepts", t))
+			}
+			types = append(types, t)
+		}
+		slices.Sort(types)
+		settings.AllowedTypes = slices.Compact(types)
+		changed["allowedTypes"] = strings.Join(settings.AllowedTypes, " ")
+	}
+	if req.DefaultVisibility != nil {
+		if err := s.checkPermitted(SettingVisibility); err != nil {
+			return Settings{}, err
+		}
+		visibility, err := ParseVisibility(string(*req.DefaultVisibility))
+		if err != nil {
+			return Settings{}, err
+		}
+		settings.DefaultVisibility = visibility
+		changed["defaultVisibility"] = string(visibility)
+	}
+	if req.Branding != nil {
+		if err := s.checkPermitted(SettingBranding); err != nil {
+			return Settings{}, err
+		}
+		if logo := req.Branding.Logo; logo != "" {
+			if meta, err := s.files.Get(ctx, logo); err != nil || meta.OrgID != orgID {
+				return Settings{}, refuse(ErrInvalid, "Invalid logo", "The logo must be the ID of a file of the organization")
+			}
+		}
+	}
+
+	// The branding is applied first: it is the only change that can still
+	// be refused, for a logo that does not load.
+	if req.Branding != nil {
+		branding := *req.Branding
+		branding.UpdatedBy = req.UpdatedBy
+		b, err := s.brandings.Put(ctx, orgID, branding)
+		if err != nil {
+			return Settings{}, err
+		}
+		current.Branding = &b
+		changed["branding"] = "updated"
+	}
+	if req.WebhookURLs != nil || req.AllowedTypes != nil || req.DefaultVisibility != nil {
+		settings.UpdatedBy = req.UpdatedBy
+		settings.UpdatedAt = time.Now().UTC()
+		if err := s.settings.PutOrgSettings(ctx, settings); err != nil {
+			return Settings{}, err
+		}
+		current.OrgSettings = settings
+	}
+
+	keys := slices.Sorted(maps.Keys(changed))
+	changed["changed"] = strings.Join(slices.DeleteFunc(keys, func(k string) bool { return k == "orgId" }), ",")
+	s.audit.Record(ctx, domain.AuditOrgSettings, "", changed)
+	s.logger.Info("Organization settings updated", "orgId", orgID, "changed", changed["changed"], "updatedBy", req.UpdatedBy)
+	return current, nil
+}
+
+func (s *SettingsService) checkPermitted(setting string) error {
+	if !slices.Contains(s.permitted, setting) {
+		return refuse(ErrForbidden, "Setting not permitted", "Organizations may not change their "+setting+" setting")
+	}
+	return nil
+}
+
+// webhookURLs validates the webhook endpoints of an organization. They
+// must be absolute https URLs of hosts on the internet; the addresses
+// names resolve to are checked on delivery.
+func webhookURLs(urls []string) ([]string, error) {
+	if len(urls) > maxWebhooks {
+		return nil, refuse(ErrInvalid, "Too many webhooks", fmt.Sprintf("Organizations have at most %d webhooks", maxWebhooks))
+	}
+	valid := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+			return nil, refuse(ErrInvalid, "Invalid webhook URL", fmt.Sprintf("%q is not an https URL", raw))
+		}
+		if !publicHost(u.Hostname()) {
+			return nil, refuse(ErrInvalid, "Invalid webhook URL", fmt.Sprintf("%q is not on the internet", raw))
+		}
+		valid = append(valid, u.String())
+	}
+	slices.Sort(valid)
+	return slices.Compact(valid), nil
+}
+
+// publicHost reports whether host may be on the internet: a name other
+// than localhost, or a public address.
+func publicHost(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return webhook.PublicAddress(addr)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Deliver posts an event to the webhooks of the organization it concerns,
+// signed with the organization's secret. It is an events.Emitter hook.
+func (s *SettingsService) Deliver(event events.Event) {
+	var orgID string
+	if event.File != nil {
+		orgID = event.File.OrgID
+	} else {
+		orgID, _ = event.Data["orgId"].(string)
+	}
+	if orgID == "" {
+		return
+	}
+	settings, err := s.settings.GetOrgSettings(context.Background(), orgID)
+	if err != nil {
+		if !errors.Is(err, metadata.ErrNotFound) {
+			s.logger.Warn("Failed to read organization webhooks", "orgId", orgID, "type", event.Type, "error", err)
+		}
+		return
+	}
+
+	data := maps.Clone(event.Data)
+	if event.File != nil {
+		if data == nil {
+			data = make(map[string]any, 1)
+		}
+		data["file"] = event.File
+	}
+	for _, u := range settings.WebhookURLs {
+		webhook.NewTenantNotifier(u, settings.WebhookSecret, s.logger).Notify(webhook.Event{
+			Type:       event.Type,
+			FileID:     event.FileID,
+			OrgID:      orgID,
+			Data:       data,
+			OccurredAt: event.OccurredAt,
+		})
+	}
+}
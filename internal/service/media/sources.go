@@ -0,0 +1,37 @@
+package media
+
+import "strings"
+
+// SourcePreset tunes the processing of uploads from one kind of source,
+// as declared by the client, e.g. photos from phone cameras or scans.
+type SourcePreset struct {
+	// JPEGQuality re-encodes JPEG photos at this quality when that makes
+	// them smaller; 0 leaves them as they are.
+	JPEGQuality int
+
+	// KeepMetadata stores images with their metadata, for exports whose
+	// credits and color profiles must survive.
+	KeepMetadata bool
+
+	// OCR recognizes the text of images after they are stored.
+	OCR bool
+}
+
+// sourcePreset builds the named preset. Unknown names get default
+// processing.
+func sourcePreset(name string, jpegQuality int) SourcePreset {
+	switch name {
+	case "compress":
+		return SourcePreset{JPEGQuality: jpegQuality}
+	case "ocr":
+		return SourcePreset{OCR: true}
+	case "original":
+		return SourcePreset{KeepMetadata: true}
+	}
+	return SourcePreset{}
+}
+
+// NormalizeSource canonicalizes an upload source hint.
+func NormalizeSource(source string) string {
+	return strings.ToLower(strings.TrimSpace(source))
+}
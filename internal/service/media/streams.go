@@ -0,0 +1,69 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+)
+
+// MasterPlaylist names the HLS master playlist of a video, which is built
+// on request from its renditions.
+const MasterPlaylist = "master.m3u8"
+
+// HasStream reports whether a video has been packaged for HLS.
+func HasStream(meta domain.FileMetadata) bool {
+	return meta.Video != nil && meta.Video.Transcode != nil && meta.Video.Transcode.HLS
+}
+
+// StreamScope is the path prefix a stream signature grants access to.
+func StreamScope(fileID string) string {
+	return "/files/" + fileID + "/hls/"
+}
+
+// StreamURL returns the URL of a video's HLS master playlist, signed to
+// grant access until expiresAt, or for as long as allowed when it is zero.
+// The returned expiry is zero when streams are not signed, in which case
+// the plain URL is returned.
+func (s *FileService) StreamURL(ctx context.Context, id string, expiresAt time.Time) (string, time.Time, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !HasStream(meta) {
+		return "", time.Time{}, refuse(ErrUnavailable, "Stream not available", "The video has not been packaged for HLS")
+	}
+
+	playlistURL := s.storage.URL(meta.ID) + "/hls/" + MasterPlaylist
+	if !s.streamSigner.Enabled() {
+		return playlistURL, time.Time{}, nil
+	}
+
+	now := time.Now().UTC()
+	latest := now.Add(s.streamURLTTL).Truncate(time.Second)
+	switch {
+	case expiresAt.IsZero():
+		expiresAt = latest
+	case !expiresAt.After(now) || expiresAt.After(latest):
+		return "", time.Time{}, refuse(ErrInvalid, "Invalid expiry", fmt.Sprintf("expiresAt must be in the future and within %s", s.streamURLTTL))
+	default:
+		expiresAt = expiresAt.UTC()
+	}
+	query := s.streamSigner.Sign(StreamScope(meta.ID), expiresAt)
+	return playlistURL + "?" + query.Encode(), expiresAt, nil
+}
+
+// StreamsSigned reports whether requests for streams must carry a
+// signature issued by StreamURL.
+func (s *FileService) StreamsSigned() bool {
+	return s.streamSigner.Enabled()
+}
+
+// VerifyStream checks the signature in the query of a request for a file of
+// a video's stream. It returns signedurl.ErrInvalid or ErrExpired when it
+// does not grant access.
+func (s *FileService) VerifyStream(fileID string, query url.Values) error {
+	return s.streamSigner.Verify(StreamScope(fileID), query, time.Now())
+}
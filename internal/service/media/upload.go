@@ -0,0 +1,951 @@
+package media
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audio"
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/jobs"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+	"github.com/ondrasimku/media-service-go/internal/moderation"
+	"github.com/ondrasimku/media-service-go/internal/plans"
+	"github.com/ondrasimku/media-service-go/internal/qc"
+	"github.com/ondrasimku/media-service-go/internal/scan"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/transcode"
+	"github.com/ondrasimku/media-service-go/internal/video"
+)
+
+type UploadConfig struct {
+	MaxSize int64
+
+	// StripMetadata removes EXIF/XMP/IPTC data before images are persisted.
+	StripMetadata       bool
+	PreserveOrientation bool
+
+	// ReviewUploads quarantines every new upload until a moderator
+	// approves it.
+	ReviewUploads bool
+
+	// VerifyDecode fully decodes JPEG and PNG uploads to reject files that
+	// only have a valid header.
+	VerifyDecode bool
+
+	// ExtractColors records the dominant and average colors of JPEG and
+	// PNG uploads.
+	ExtractColors bool
+
+	// AltTextCollections are the collections whose images must be
+	// uploaded with alt text.
+	AltTextCollections []string
+
+	// SourcePresets maps the upload sources clients may declare to the
+	// presets their uploads are processed with: "compress" re-encodes
+	// JPEG photos at CompressQuality, "ocr" recognizes the text of
+	// images and "original" keeps image metadata. Other sources get
+	// default processing.
+	SourcePresets   map[string]string
+	CompressQuality int
+
+	// AvatarSizes enables the avatar pipeline: JPEG and PNG avatars set
+	// through SetAvatar are oriented, cropped to a square and stored at
+	// each of these sizes. Other uploads are not.
+	AvatarSizes []int
+
+	// AvatarHistory keeps previous avatars set through SetAvatar as
+	// versions of the current one instead of deleting them.
+	AvatarHistory bool
+
+	// Limits caps image dimensions. It is checked against the header
+	// before any full decode.
+	Limits imaging.Limits
+
+	// Backend decodes images for verification, color extraction and the
+	// avatar pipeline.
+	Backend imaging.Backend
+
+	// MaxVideoSize is the size limit for uploads of VideoTypes, which
+	// replaces MaxSize for them. Zero disables video uploads.
+	MaxVideoSize int64
+	VideoTypes   []string
+
+	// MaxAudioSize is the size limit for uploads of AudioTypes, which
+	// replaces MaxSize for them. Zero disables audio uploads.
+	MaxAudioSize int64
+	AudioTypes   []string
+
+	// Jobs runs follow-up work of uploads, such as waveforms, in the
+	// background; may be nil, in which case it is done during the upload.
+	Jobs jobs.Queue
+
+	// Transcodes converts video uploads into MP4 renditions in the
+	// background; may be nil.
+	Transcodes *transcode.Queue
+
+	// Events publishes file events to the event broker; may be nil.
+	Events *events.Emitter
+
+	// Quotas limits what organizations store; may be nil.
+	Quotas *QuotaService
+
+	// Orgs refuses uploads to organizations being offboarded and holds
+	// their default visibility; may be nil.
+	Orgs metadata.OrgStore
+
+	// DefaultVisibility is the visibility of uploads that ask for none,
+	// unless their organization set its own; empty for public.
+	DefaultVisibility domain.Visibility
+
+	// Scanner checks uploads for malware and quarantines infected ones;
+	// disabled when nil. Uploads up to ScanSyncMaxSize are scanned before
+	// the upload returns, larger ones by a job.
+	Scanner         *scan.Clamd
+	ScanSyncMaxSize int64
+
+	// ValidationCacheTTL is how long the sniffed type and malware scan of
+	// an uploaded content are reused for uploads of the same content, by
+	// SHA-256; 0 sniffs and scans every upload.
+	ValidationCacheTTL time.Duration
+
+	// QC checks the quality of video and audio delivered to QCCollections;
+	// disabled when nil. QCPolicy is QCRecord, QCFlag or QCReject.
+	QC            *qc.Checker
+	QCCollections []string
+	QCPolicy      string
+
+	// DirectoryTTLs are the TTLs of uploads stored in each directory that
+	// ask for none. MaxTTL caps the TTL uploads may ask for; 0 is no cap.
+	DirectoryTTLs map[string]time.Duration
+	MaxTTL        time.Duration
+
+	// MaxVersions is how many superseded contents are kept per file when
+	// its content is replaced; older ones are pruned. 0 keeps all.
+	MaxVersions int
+
+	// Metering bills the processing of uploads to their organizations;
+	// may be nil.
+	Metering *metering.Recorder
+}
+
+// UploadService accepts new files: it checks them against the upload
+// policy, runs them through the processing pipeline of their type, stores
+// them and queues their follow-up work. Previews, waveforms and the like
+// are generated by the FileService it is built on.
+type UploadService struct {
+	files           *FileService
+	aliases         metadata.AliasStore
+	maxSize         int64
+	maxVideoSize    int64
+	maxAudioSize    int64
+	allowedMIME     map[string]bool
+	stripOpts       *imaging.StripOptions
+	reviewUploads   bool
+	verifyDecode    bool
+	limits          imaging.Limits
+	backend         imaging.Backend
+	extractColors   bool
+	avatarSizes     []int
+	avatarHistory   bool
+	altRequired     map[string]bool         // By collection
+	presets         map[string]SourcePreset // By source
+	jobs            jobs.Queue
+	transcodes      *transcode.Queue
+	events          *events.Emitter
+	quotas          *QuotaService
+	orgs            metadata.OrgStore
+	visibility      domain.Visibility
+	scanner         *scan.Clamd
+	scanSyncMaxSize int64
+	validations     *validationCache
+	qc              *qc.Checker
+	qcCollections   map[string]bool
+	qcPolicy        string
+	directoryTTLs   map[string]time.Duration
+	maxTTL          time.Duration
+	maxVersions     int
+	metering        *metering.Recorder
+	logger          *slog.Logger
+}
+
+func NewUploadService(files *FileService, aliases metadata.AliasStore, cfg UploadConfig, logger *slog.Logger) *UploadService {
+	allowedMIME := map[string]bool{
+		"image/jpeg":  true,
+		"image/png":   true,
+		"image/webp":  true,
+		"image/gif":   true,
+		mediatype.SVG: true,
+		mediatype.PDF: true,
+	}
+
+	if cfg.MaxVideoSize > 0 {
+		for _, t := range cfg.VideoTypes {
+			if video.Supported(t) {
+				allowedMIME[t] = true
+			}
+		}
+	}
+	if cfg.MaxAudioSize > 0 {
+		for _, t := range cfg.AudioTypes {
+			if t = mediatype.Normalize(t); audio.Supported(t) {
+				allowedMIME[t] = true
+			}
+		}
+	}
+
+	altRequired := make(map[string]bool, len(cfg.AltTextCollections))
+	for _, collection := range cfg.AltTextCollections {
+		altRequired[collection] = true
+	}
+
+	presets := make(map[string]SourcePreset, len(cfg.SourcePresets))
+	for source, name := range cfg.SourcePresets {
+		presets[NormalizeSource(source)] = sourcePreset(name, cfg.CompressQuality)
+	}
+
+	qcCollections := make(map[string]bool, len(cfg.QCCollections))
+	for _, collection := range cfg.QCCollections {
+		qcCollections[collection] = true
+	}
+
+	var stripOpts *imaging.StripOptions
+	if cfg.StripMetadata {
+		stripOpts = &imaging.StripOptions{PreserveOrientation: cfg.PreserveOrientation}
+	}
+
+	return &UploadService{
+		files:           files,
+		aliases:         aliases,
+		maxSize:         cfg.MaxSize,
+		maxVideoSize:    cfg.MaxVideoSize,
+		maxAudioSize:    cfg.MaxAudioSize,
+		allowedMIME:     allowedMIME,
+		stripOpts:       stripOpts,
+		reviewUploads:   cfg.ReviewUploads,
+		verifyDecode:    cfg.VerifyDecode,
+		limits:          cfg.Limits,
+		backend:         cfg.Backend,
+		extractColors:   cfg.ExtractColors,
+		avatarSizes:     cfg.AvatarSizes,
+		avatarHistory:   cfg.AvatarHistory,
+		altRequired:     altRequired,
+		presets:         presets,
+		jobs:            cfg.Jobs,
+		transcodes:      cfg.Transcodes,
+		events:          cfg.Events,
+		quotas:          cfg.Quotas,
+		orgs:            cfg.Orgs,
+		visibility:      cmp.Or(cfg.DefaultVisibility, domain.VisibilityPublic),
+		scanner:         cfg.Scanner,
+		scanSyncMaxSize: cfg.ScanSyncMaxSize,
+		validations:     newValidationCache(cfg.ValidationCacheTTL),
+		qc:              cfg.QC,
+		directoryTTLs:   cfg.DirectoryTTLs,
+		maxTTL:          cfg.MaxTTL,
+		maxVersions:     cfg.MaxVersions,
+		metering:        cfg.Metering,
+		qcCollections:   qcCollections,
+		qcPolicy:        cfg.QCPolicy,
+		logger:          logger,
+	}
+}
+
+// MaxSize is the size of the largest upload any type may have, for
+// callers that want to stop reading what is bound to be refused.
+func (s *UploadService) MaxSize() int64 {
+	return max(s.maxSize, s.maxVideoSize, s.maxAudioSize)
+}
+
+// ImageLimits bounds the dimensions of the images uploads may have, and of
+// those decoded on request, such as versions compared.
+func (s *UploadService) ImageLimits() imaging.Limits {
+	return s.limits
+}
+
+// UploadRequest is a file to be uploaded and who it belongs to.
+type UploadRequest struct {
+	// Content is read from the start; video uploads are probed in place,
+	// so it must also support ReadAt.
+	Content  io.ReadSeeker
+	Size     int64
+	Filename string
+
+	// ContentType is the type the client declared, if any. It is only
+	// used to catch mismatches; the stored type is what the content
+	// itself looks like.
+	ContentType string
+
+	OwnerID    string
+	OrgID      string
+	Collection string
+
+	// FileID is the ID to store the file under; empty to generate one.
+	// Whether the caller may choose it is up to the caller.
+	FileID string
+
+	// Crop is the square to cut avatars from; nil for the center.
+	Crop *image.Rectangle
+
+	// Source is where the client says the file comes from, such as
+	// "mobile-camera"; it selects the processing preset. Sources without
+	// a preset are processed as usual.
+	Source string
+
+	// SHA256 is the hex digest the client computed of Content. Uploads
+	// that do not match it are refused; empty skips the check.
+	SHA256 string
+
+	// TTL is how long the file is kept before it is deleted; 0 for the
+	// default of the directory it is stored in.
+	TTL time.Duration
+
+	// Visibility is who the file is served to; empty for the default.
+	Visibility domain.Visibility
+
+	Details Details
+
+	// Provenance is the channel, client and headers of the request making
+	// the upload; the caller, their address and the time are filled in.
+	Provenance domain.Provenance
+
+	// replaces is the file whose content the upload replaces, see Replace.
+	replaces *domain.FileMetadata
+
+	// avatar runs the upload through the avatar pipeline, see SetAvatar.
+	avatar bool
+}
+
+// Upload validates, processes and stores a new file and returns its
+// metadata.
+func (s *UploadService) Upload(ctx context.Context, req UploadRequest) (domain.FileMetadata, error) {
+	// The exact limit depends on the type, which is only known once the
+	// content has been sniffed; this rejects what no limit would allow.
+	if limit := s.MaxSize(); req.Size > limit {
+		s.logger.Warn("File too large", "size", req.Size, "max", limit)
+		return domain.FileMetadata{}, refuse(ErrTooLarge, "File too large", "")
+	}
+
+	if req.Collection != "" && !ValidCollection(req.Collection) {
+		return domain.FileMetadata{}, refuse(ErrInvalid, "Invalid collection", "Collection IDs are lowercase letters, digits, '.', '_' and '-'")
+	}
+	if err := s.checkTTL(req.TTL); err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	// Refused uploads are billed too, for whatever was run on them.
+	ctx, meter := metering.Start(ctx)
+	defer func() {
+		s.metering.Record(ctx, req.OrgID, "upload", meter.Usage())
+	}()
+
+	if req.FileID != "" {
+		if !ValidFileID(req.FileID) {
+			return domain.FileMetadata{}, refuse(ErrInvalid, "Invalid file ID", "File IDs are up to 128 letters, digits, '.', '_' and '-'")
+		}
+		if _, err := s.files.metadata.Get(ctx, req.FileID); err == nil {
+			return domain.FileMetadata{}, refuse(ErrConflict, "File ID already exists", "")
+		}
+		if _, err := s.aliases.GetAlias(ctx, req.FileID); err == nil {
+			return domain.FileMetadata{}, refuse(ErrConflict, "File ID already exists", "The ID is in use as an alias")
+		}
+	}
+
+	src := req.Content
+	if req.SHA256 != "" {
+		if err := s.verifySHA256(src, req.SHA256); err != nil {
+			return domain.FileMetadata{}, err
+		}
+	}
+
+	// The digest is only known when given, and then checked above.
+	uploadSum := strings.ToLower(req.SHA256)
+	var contentType, sniffedBy string
+	if v, ok := s.validations.get(uploadSum); ok && v.contentType != "" {
+		contentType, sniffedBy = v.contentType, v.sniffedBy
+		validationReused.Inc("sniff")
+	} else {
+		contentType = mediatype.Sniff(src)
+	}
+	if !s.allowedMIME[contentType] {
+		s.logger.Warn("Unsupported MIME type", "contentType", contentType, "filename", req.Filename)
+		return domain.FileMetadata{}, refuse(ErrUnsupported, "Unsupported file type", "Allowed types: "+s.allowedList())
+	}
+
+	declared := mediatype.Normalize(req.ContentType)
+	if declared == "" || declared == mediatype.OctetStream {
+		declared = mediatype.FromExtension(req.Filename)
+	}
+	if declared != mediatype.OctetStream && declared != contentType {
+		s.logger.Warn("Content type mismatch", "declared", declared, "detected", contentType, "filename", req.Filename)
+		return domain.FileMetadata{}, refuse(ErrInvalid, "Content type mismatch", fmt.Sprintf("File content is %s but was declared as %s", contentType, declared))
+	}
+
+	details, err := s.checkDetails(req.Details, req.Collection, contentType)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	if err := s.checkOrg(ctx, req.OrgID, contentType); err != nil {
+		return domain.FileMetadata{}, err
+	}
+	plan, err := s.checkPlan(ctx, req.OrgID, contentType, req.Size)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	visibility, err := s.visibilityOf(ctx, req)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	var usage Usage
+	if req.replaces != nil {
+		err = s.quotas.CheckReplace(ctx, req.OrgID, req.Size, req.replaces.Size)
+	} else {
+		usage, err = s.quotas.Check(ctx, req.OrgID, req.Size)
+	}
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	source := NormalizeSource(req.Source)
+	preset, ok := s.presets[source]
+	if !ok {
+		source = ""
+	}
+
+	var (
+		body      io.Reader
+		data      []byte
+		directory = "files"
+		img       *processedImage
+		vid       *video.Info
+	)
+	if video.Supported(contentType) {
+		// Videos are streamed to storage from the spooled upload rather
+		// than read into memory.
+		if req.Size > s.maxVideoSize {
+			s.logger.Warn("Video too large", "size", req.Size, "max", s.maxVideoSize)
+			return domain.FileMetadata{}, refuse(ErrTooLarge, "File too large", "")
+		}
+		readerAt, ok := src.(io.ReaderAt)
+		if !ok {
+			return domain.FileMetadata{}, errors.New("video content does not support ReadAt")
+		}
+		info, err := video.Probe(readerAt, req.Size, contentType)
+		if err != nil {
+			s.logger.Warn("Failed to read video header", "contentType", contentType, "error", err)
+			return domain.FileMetadata{}, refuse(ErrInvalid, "Invalid video", "The uploaded video could not be parsed")
+		}
+		vid, body = &info, src
+	} else if audio.Supported(contentType) {
+		if req.Size > s.maxAudioSize {
+			s.logger.Warn("Audio too large", "size", req.Size, "max", s.maxAudioSize)
+			return domain.FileMetadata{}, refuse(ErrTooLarge, "File too large", "")
+		}
+		body = src
+	} else {
+		var err error
+		data, err = io.ReadAll(io.LimitReader(src, s.maxSize+1))
+		if err != nil {
+			return domain.FileMetadata{}, fmt.Errorf("failed to read uploaded file: %w", err)
+		}
+		if int64(len(data)) > s.maxSize {
+			return domain.FileMetadata{}, refuse(ErrTooLarge, "File too large", "")
+		}
+
+		// Documents are stored as uploaded; images go through the imaging
+		// pipeline first.
+		if contentType != mediatype.PDF {
+			if img, err = s.processImage(ctx, data, contentType, req.Crop, preset, req.avatar); err != nil {
+				return domain.FileMetadata{}, err
+			}
+			data, directory = img.data, "avatars"
+		}
+		body = bytes.NewReader(data)
+	}
+
+	store := s.files.storage
+	opts := storage.SaveOptions{
+		ID:           req.FileID,
+		Directory:    directory,
+		ContentType:  contentType,
+		OriginalName: req.Filename,
+	}
+	var (
+		fileInfo storage.FileInfo
+		versions []domain.FileVersion
+	)
+	if req.replaces != nil {
+		fileInfo, versions, err = s.replace(ctx, *req.replaces, body, opts)
+	} else {
+		fileInfo, err = store.Save(ctx, body, opts)
+	}
+	if errors.Is(err, storage.ErrExists) {
+		return domain.FileMetadata{}, refuse(ErrConflict, "File ID already exists", "")
+	}
+	if err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to save file: %w", err)
+	}
+	if sniffedBy == "" {
+		s.validations.sniffed(uploadSum, contentType, fileInfo.ID)
+	}
+
+	var renditions []int
+	if img != nil && img.avatars != nil {
+		for size, rendition := range img.avatars.Renditions {
+			if _, err := store.SaveDerivative(ctx, fileInfo.ID, avatarDerivative(size), bytes.NewReader(rendition), contentType); err != nil {
+				s.logger.Warn("Failed to store avatar rendition", "fileId", fileInfo.ID, "size", size, "error", err)
+				continue
+			}
+			renditions = append(renditions, size)
+		}
+		sort.Ints(renditions)
+	}
+
+	meta := domain.FileMetadata{
+		ID:           fileInfo.ID,
+		OriginalName: req.Filename,
+		ContentType:  fileInfo.ContentType,
+		Size:         fileInfo.Size,
+		Path:         fileInfo.Path,
+		Directory:    fileInfo.Directory,
+		Collection:   req.Collection,
+		Checksums:    fileInfo.Checksums,
+		OwnerID:      req.OwnerID,
+		OrgID:        req.OrgID,
+		Status:       domain.FileStatusActive,
+		CreatedAt:    fileInfo.CreatedAt,
+		Visibility:   visibility,
+		Region:       s.files.region,
+		Source:       source,
+		AltText:      details.AltText,
+		Description:  details.Description,
+		Credit:       details.Credit,
+		License:      details.License,
+	}
+	meta.RightsHolder, meta.LicenseExpiresAt = details.RightsHolder, details.LicenseExpiresAt
+	meta.AvailableFrom, meta.AvailableUntil = details.AvailableFrom, details.AvailableUntil
+	if meta.AvailableFrom != nil || meta.AvailableUntil != nil {
+		meta.Availability = meta.AvailabilityAt(time.Now())
+	}
+	meta.ExpiresAt = s.expiry(req.TTL, fileInfo.Directory, fileInfo.CreatedAt)
+	meta.Versions = versions
+	provenance := s.provenance(ctx, req.Provenance)
+	meta.Provenance = &provenance
+	if req.replaces != nil && req.replaces.Provenance != nil {
+		meta.Provenance = req.replaces.Provenance
+	}
+	if vid != nil {
+		meta.Video = &domain.VideoMetadata{
+			Duration:   vid.Duration,
+			Width:      vid.Width,
+			Height:     vid.Height,
+			VideoCodec: vid.VideoCodec,
+			AudioCodec: vid.AudioCodec,
+		}
+		if s.transcodes.Enabled() {
+			meta.Video.Transcode = &domain.TranscodeJob{Status: domain.TranscodePending, UpdatedAt: time.Now().UTC()}
+		}
+	}
+	if img != nil {
+		meta.Image = &domain.ImageMetadata{
+			Width:       img.info.Width,
+			Height:      img.info.Height,
+			Orientation: img.info.Orientation,
+			Exif:        img.info.Exif,
+			Colors:      img.colors,
+			Renditions:  renditions,
+			Frames:      img.info.Frames,
+		}
+	}
+	if s.qcApplies(meta) {
+		if err := s.checkUpload(ctx, &meta); err != nil {
+			s.discard(ctx, fileInfo.ID, req.replaces)
+			return domain.FileMetadata{}, err
+		}
+	}
+	if audio.Supported(contentType) {
+		meta.Audio = &domain.AudioMetadata{}
+		if s.files.waveforms.Supported() && s.jobs == nil {
+			// Waveform computes it again on request if this fails.
+			if waveform, _, err := s.files.waveform(ctx, fileInfo.ID); err != nil {
+				s.logger.Warn("Failed to compute waveform", "fileId", fileInfo.ID, "error", err)
+			} else {
+				meta.Audio.Duration = waveform.Duration
+			}
+		}
+		if s.files.normalizer.Supported() && s.jobs == nil {
+			// Normalized makes the copy again on request if this fails.
+			if loudness, _, err := s.files.normalize(ctx, fileInfo.ID, contentType); err != nil {
+				s.logger.Warn("Failed to normalize loudness", "fileId", fileInfo.ID, "error", err)
+			} else {
+				meta.Audio.Loudness = &loudness
+			}
+		}
+	}
+	if contentType == mediatype.PDF && s.files.previews.Supported() {
+		// Preview renders it again on request if this fails.
+		if _, err := s.files.preview(ctx, fileInfo.ID, data); err != nil {
+			s.logger.Warn("Failed to render PDF preview", "fileId", fileInfo.ID, "error", err)
+		}
+	}
+	if s.reviewUploads {
+		// New content is never taken down, so it is always quarantined.
+		moderation.Quarantine(&meta, "pending review", meta.OwnerID)
+	}
+	if s.scanner.Enabled() {
+		s.scanUpload(ctx, &meta)
+	}
+
+	if err := s.files.metadata.Put(ctx, meta); err != nil {
+		s.discard(ctx, fileInfo.ID, req.replaces)
+		return domain.FileMetadata{}, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+	s.files.stats.Record(stats.Uploads, fileInfo.Size)
+	entry := map[string]string{
+		"originalName": meta.OriginalName,
+		"contentType":  meta.ContentType,
+		"size":         strconv.FormatInt(meta.Size, 10),
+		"collection":   meta.Collection,
+		"ownerId":      meta.OwnerID,
+		"status":       string(meta.Status),
+		"source":       meta.Source,
+	}
+	// Provenance of checks taken from earlier uploads of the same content.
+	entry["contentTypeFrom"] = sniffedBy
+	if meta.Scan != nil {
+		entry["scanFrom"] = meta.Scan.ReusedFrom
+	}
+	maps.Copy(entry, provenanceDetails(provenance))
+	if req.replaces != nil {
+		version := meta.CurrentVersion()
+		entry["version"] = strconv.Itoa(version)
+		s.files.audit.Record(ctx, domain.AuditReplace, meta.ID, entry)
+		s.events.Emit(events.Event{Type: events.Replaced, FileID: meta.ID, File: events.NewFile(meta), Data: map[string]any{"version": version}})
+		s.prune(ctx, *req.replaces, meta)
+	} else {
+		s.files.audit.Record(ctx, domain.AuditUpload, meta.ID, entry)
+		s.events.Emit(events.Event{Type: events.Created, FileID: meta.ID, File: events.NewFile(meta)})
+		s.quotas.Observe(usage, meta)
+	}
+	if meta.Scan != nil {
+		switch meta.Scan.Status {
+		case domain.ScanInfected:
+			s.files.audit.Record(ctx, domain.AuditFlag, meta.ID, map[string]string{"reason": meta.Quarantine.Reason})
+		case domain.ScanPending:
+			// The file stays pending, and served, if this fails.
+			if _, err := s.jobs.Enqueue(ctx, meta.ID, domain.JobScan, nil); err != nil {
+				s.logger.Warn("Failed to queue malware scan", "fileId", meta.ID, "error", err)
+			}
+		}
+	}
+	if meta.QC != nil {
+		switch {
+		case meta.QC.Status == domain.QCPending:
+			// The file stays pending if this fails.
+			if _, err := s.jobs.Enqueue(ctx, meta.ID, domain.JobQC, nil); err != nil {
+				s.logger.Warn("Failed to queue quality checks", "fileId", meta.ID, "error", err)
+			}
+		case meta.Quarantine != nil && meta.Quarantine.FlaggedBy == qcName:
+			s.files.audit.Record(ctx, domain.AuditFlag, meta.ID, map[string]string{"reason": meta.Quarantine.Reason})
+		}
+	}
+	if meta.Video != nil && meta.Video.Transcode != nil {
+		s.transcodes.Enqueue(meta.ID)
+	}
+	if meta.Audio != nil && s.files.waveforms.Supported() && s.jobs != nil {
+		// Waveform computes it on request if this fails.
+		if _, err := s.jobs.Enqueue(ctx, meta.ID, domain.JobWaveform, nil); err != nil {
+			s.logger.Warn("Failed to queue waveform", "fileId", meta.ID, "error", err)
+		}
+	}
+	if meta.Audio != nil && s.files.normalizer.Supported() && s.jobs != nil {
+		// Normalized makes the copy on request if this fails.
+		if _, err := s.jobs.Enqueue(ctx, meta.ID, domain.JobLoudness, nil); err != nil {
+			s.logger.Warn("Failed to queue loudness normalization", "fileId", meta.ID, "error", err)
+		}
+	}
+
+	if preset.OCR && plan.Allows(plans.FeatureOCR) && meta.Image != nil && imaging.Decodable(contentType) && s.files.recognizer.Supported() {
+		if s.jobs != nil {
+			// Text recognizes it on request if this fails.
+			if _, err := s.jobs.Enqueue(ctx, meta.ID, domain.JobOCR, nil); err != nil {
+				s.logger.Warn("Failed to queue text recognition", "fileId", meta.ID, "error", err)
+			}
+		} else if _, err := s.files.text(ctx, meta.ID); err != nil {
+			s.logger.Warn("Failed to recognize text", "fileId", meta.ID, "error", err)
+		}
+	}
+
+	s.logger.Info("File uploaded successfully", "fileId", fileInfo.ID, "size", fileInfo.Size)
+	return meta, nil
+}
+
+// verifySHA256 refuses content whose SHA-256 is not digest. The digest is
+// checked against the content as uploaded, before any processing changes
+// it, and content is rewound afterwards.
+func (s *UploadService) verifySHA256(content io.ReadSeeker, digest string) error {
+	h := sha256.New()
+	if _, err := bufpool.Copy(h, content); err != nil {
+		return fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind uploaded file: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, digest) {
+		s.logger.Warn("Checksum mismatch", "declared", digest, "computed", sum)
+		return refuse(ErrInvalid, "Checksum mismatch", fmt.Sprintf("The file has SHA-256 %s but %s was declared", sum, digest))
+	}
+	return nil
+}
+
+// processedImage is an image upload after validation and the imaging
+// pipeline.
+type processedImage struct {
+	data    []byte
+	info    imaging.ImageInfo
+	colors  *domain.ImageColors
+	avatars *imaging.Avatars
+}
+
+// processImage validates an image upload and applies sanitizing, metadata
+// stripping, the compression of the source preset or the avatar pipeline.
+func (s *UploadService) processImage(ctx context.Context, data []byte, contentType string, crop *image.Rectangle, preset SourcePreset, avatar bool) (*processedImage, error) {
+	invalid := refuse(ErrInvalid, "Invalid image", "The uploaded image could not be parsed")
+
+	// SVGs can carry script; only the sanitized document is ever stored.
+	if contentType == mediatype.SVG {
+		sanitized, err := imaging.SanitizeSVG(data)
+		if err != nil {
+			s.logger.Warn("Failed to sanitize SVG", "error", err)
+			return nil, invalid
+		}
+		data = sanitized
+	}
+
+	// Probe before stripping so the EXIF fields we keep are still present.
+	imageInfo, err := imaging.Probe(data, contentType)
+	if err != nil {
+		s.logger.Warn("Failed to read image header", "contentType", contentType, "error", err)
+		return nil, invalid
+	}
+	if err := s.limits.Check(imageInfo); err != nil {
+		s.logger.Warn("Image dimensions over limit", "width", imageInfo.Width, "height", imageInfo.Height, "error", err)
+		return nil, refuse(ErrUnprocessable, "Image too large", err.Error())
+	}
+
+	// Everything below may hold the decoded image in memory.
+	if imaging.Decodable(contentType) || contentType == "image/gif" {
+		release, err := s.files.governor.Admit(ctx, "image", governor.Cost{Memory: s.backend.Cost(imageInfo), CPU: 1})
+		if err != nil {
+			s.logger.Warn("Image processing not admitted", "width", imageInfo.Width, "height", imageInfo.Height, "backend", s.backend.Name(), "error", err)
+			return nil, err
+		}
+		defer release()
+	}
+
+	if s.verifyDecode {
+		if err := s.backend.Verify(data, contentType); err != nil {
+			s.logger.Warn("Failed to decode image", "contentType", contentType, "error", err)
+			return nil, invalid
+		}
+	}
+
+	var colors *domain.ImageColors
+	if s.extractColors && imaging.Decodable(contentType) {
+		if c, err := s.backend.ExtractColors(data, contentType, 5); err == nil {
+			colors = &domain.ImageColors{Dominant: c.Dominant, Average: c.Average, Palette: c.Palette}
+		} else {
+			s.logger.Warn("Failed to extract image colors", "contentType", contentType, "error", err)
+		}
+	}
+
+	if crop != nil && !crop.In(image.Rect(0, 0, imageInfo.Width, imageInfo.Height)) {
+		return nil, refuse(ErrInvalid, "Invalid crop", fmt.Sprintf("crop must lie within the %dx%d image", imageInfo.Width, imageInfo.Height))
+	}
+	avatar = avatar && len(s.avatarSizes) > 0 && imaging.Decodable(contentType)
+	if crop != nil && !avatar {
+		return nil, refuse(ErrInvalid, "Invalid crop", fmt.Sprintf("cropping is only supported for JPEG and PNG avatars, not %s uploads", contentType))
+	}
+
+	// The avatar pipeline re-encodes the image, which drops its metadata
+	// as well, so stripping is only needed when it does not run.
+	var avatars *imaging.Avatars
+	if avatar {
+		set, err := s.backend.MakeAvatars(data, contentType, crop, s.avatarSizes)
+		if err != nil {
+			s.logger.Warn("Failed to process avatar", "contentType", contentType, "error", err)
+			return nil, invalid
+		}
+		avatars = &set
+		s.files.stats.Record(stats.Jobs, int64(len(set.Image)))
+		data = set.Image
+		imageInfo.Width, imageInfo.Height, imageInfo.Orientation = set.Size, set.Size, 1
+	} else if compressed, ok := s.compress(data, contentType, imageInfo.Orientation, preset); ok {
+		data = compressed
+		imageInfo.Orientation = 1
+	} else if s.stripOpts != nil && !preset.KeepMetadata {
+		data, err = imaging.StripMetadata(data, contentType, *s.stripOpts)
+		if err != nil {
+			s.logger.Warn("Failed to strip image metadata", "contentType", contentType, "error", err)
+			return nil, invalid
+		}
+	}
+
+	return &processedImage{data: data, info: imageInfo, colors: colors, avatars: avatars}, nil
+}
+
+// compress re-encodes a JPEG photo as the preset says. It reports whether
+// that made the photo smaller; otherwise the photo is kept as is.
+func (s *UploadService) compress(data []byte, contentType string, orientation int, preset SourcePreset) ([]byte, bool) {
+	if preset.JPEGQuality == 0 || contentType != "image/jpeg" {
+		return nil, false
+	}
+	compressed, err := imaging.CompressJPEG(data, orientation, preset.JPEGQuality)
+	if err != nil {
+		s.logger.Warn("Failed to compress image", "contentType", contentType, "error", err)
+		return nil, false
+	}
+	if len(compressed) >= len(data) {
+		return nil, false
+	}
+	s.files.stats.Record(stats.Jobs, int64(len(compressed)))
+	return compressed, true
+}
+
+// WaveformJob computes the waveform of an uploaded audio file and records
+// its duration. It handles jobs of kind domain.JobWaveform.
+func (s *UploadService) WaveformJob(ctx context.Context, job domain.Job) error {
+	store := s.files.metadata
+	meta, err := store.Get(ctx, job.FileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if meta.Audio == nil || !s.files.waveforms.Supported() {
+		return jobs.Permanent(fmt.Errorf("waveforms are not computed for this file"))
+	}
+
+	waveform, _, err := s.files.waveform(ctx, job.FileID)
+	if err != nil {
+		return err
+	}
+
+	// Read again so changes made while decoding are kept.
+	meta, err = store.Get(ctx, job.FileID)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	meta.Audio.Duration = waveform.Duration
+	if err := store.Put(ctx, meta); err != nil {
+		return err
+	}
+	s.events.Emit(events.Event{
+		Type:   events.Processed,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"kind": domain.JobWaveform, "status": domain.JobDone, "duration": waveform.Duration},
+	})
+	return nil
+}
+
+// LoudnessJob makes the loudness-normalized copy of an uploaded audio file
+// and records the measured loudness. It handles jobs of kind
+// domain.JobLoudness.
+func (s *UploadService) LoudnessJob(ctx context.Context, job domain.Job) error {
+	store := s.files.metadata
+	meta, err := store.Get(ctx, job.FileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if meta.Audio == nil || !s.files.normalizer.Supported() {
+		return jobs.Permanent(fmt.Errorf("loudness is not normalized for this file"))
+	}
+
+	loudness, _, err := s.files.normalize(ctx, job.FileID, meta.ContentType)
+	if err != nil {
+		return err
+	}
+
+	// Read again so changes made while normalizing are kept.
+	meta, err = store.Get(ctx, job.FileID)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	meta.Audio.Loudness = &loudness
+	if err := store.Put(ctx, meta); err != nil {
+		return err
+	}
+	s.events.Emit(events.Event{
+		Type:   events.Processed,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"kind": domain.JobLoudness, "status": domain.JobDone, "integratedLufs": loudness.Integrated, "targetLufs": loudness.Target},
+	})
+	return nil
+}
+
+// Accepts reports whether uploads of contentType are accepted at all.
+func (s *UploadService) Accepts(contentType string) bool {
+	return s.allowedMIME[contentType]
+}
+
+func (s *UploadService) allowedList() string {
+	types := make([]string, 0, len(s.allowedMIME))
+	for t := range s.allowedMIME {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// OCRJob recognizes the text of an uploaded image and caches it. It
+// handles jobs of kind domain.JobOCR.
+func (s *UploadService) OCRJob(ctx context.Context, job domain.Job) error {
+	meta, err := s.files.metadata.Get(ctx, job.FileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if !imaging.Decodable(meta.ContentType) || !s.files.recognizer.Supported() {
+		return jobs.Permanent(fmt.Errorf("text is not recognized for this file"))
+	}
+
+	text, err := s.files.text(ctx, job.FileID)
+	if err != nil {
+		return err
+	}
+	s.events.Emit(events.Event{
+		Type:   events.Processed,
+		FileID: meta.ID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"kind": domain.JobOCR, "status": domain.JobDone, "length": len(text)},
+	})
+	return nil
+}
@@ -0,0 +1,98 @@
+package media
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var validationReused = metrics.NewCounter("media_upload_validation_reused_total",
+	"Uploads whose content type or malware scan was taken from an earlier upload of the same content, by check: sniff or scan.", "check")
+
+// maxValidated bounds the contents remembered before expired ones are
+// dropped.
+const maxValidated = 50000
+
+// validated is what was found out about a content by uploading it.
+type validated struct {
+	contentType string // As sniffed from the upload; empty if not known
+	sniffedBy   string // The upload it was sniffed for
+	scan        *domain.Scan
+	scannedBy   string // The upload it was scanned for
+	until       time.Time
+}
+
+// validationCache remembers the sniffed type and malware scan of recently
+// uploaded contents by their SHA-256, so that uploads of the same content,
+// such as template files many users upload, skip them. A cached clean
+// scan misses signatures added since, so the TTL bounds how long it is
+// trusted. A nil cache remembers nothing.
+type validationCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]validated
+}
+
+func newValidationCache(ttl time.Duration) *validationCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &validationCache{ttl: ttl, entries: make(map[string]validated)}
+}
+
+// get returns what is known about the content of sum.
+func (c *validationCache) get(sum string) (validated, bool) {
+	if c == nil || sum == "" {
+		return validated{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[sum]
+	if !ok || !time.Now().Before(v.until) {
+		return validated{}, false
+	}
+	return v, true
+}
+
+// sniffed remembers the type of the content of sum, found by uploading
+// fileID.
+func (c *validationCache) sniffed(sum, contentType, fileID string) {
+	c.update(sum, func(v *validated) { v.contentType, v.sniffedBy = contentType, fileID })
+}
+
+// scanned remembers a conclusive scan of the content of sum, as stored by
+// fileID.
+func (c *validationCache) scanned(sum string, scan domain.Scan, fileID string) {
+	if scan.Status != domain.ScanClean && scan.Status != domain.ScanInfected {
+		return
+	}
+	c.update(sum, func(v *validated) { v.scan, v.scannedBy = &scan, fileID })
+}
+
+func (c *validationCache) update(sum string, set func(*validated)) {
+	if c == nil || sum == "" {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[sum]
+	if !ok || !now.Before(v.until) {
+		if len(c.entries) >= maxValidated {
+			for k, e := range c.entries {
+				if !now.Before(e.until) {
+					delete(c.entries, k)
+				}
+			}
+			if len(c.entries) >= maxValidated {
+				return
+			}
+		}
+		v = validated{until: now.Add(c.ttl)}
+	}
+	set(&v)
+	c.entries[sum] = v
+}
@@ -0,0 +1,295 @@
+package media
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/imaging"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+)
+
+var errVersionNotFound = refuse(ErrNotFound, "Version not found", "")
+
+// Replace stores new content for an existing file, keeping the current
+// content as a version that can be fetched and restored. The file keeps its
+// ID, owner, organization and collection; details not given are carried
+// over. With req.OwnerID set, only a file owned by it is replaced.
+func (s *UploadService) Replace(ctx context.Context, fileID string, req UploadRequest) (domain.FileMetadata, error) {
+	prev, err := s.files.metadata.Get(ctx, fileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if !prev.Servable() {
+		return domain.FileMetadata{}, unservable(prev)
+	}
+	if req.OwnerID != "" && prev.OwnerID != req.OwnerID {
+		return domain.FileMetadata{}, refuse(ErrForbidden, "Insufficient permissions", "Only the owner of the file may replace it")
+	}
+
+	if req.Collection == "" {
+		req.Collection = prev.Collection
+	}
+	if req.Details == (Details{}) {
+		req.Details = Details{
+			AltText:          prev.AltText,
+			Description:      prev.Description,
+			Credit:           prev.Credit,
+			License:          prev.License,
+			RightsHolder:     prev.RightsHolder,
+			LicenseExpiresAt: prev.LicenseExpiresAt,
+
+			AvailableFrom:  prev.AvailableFrom,
+			AvailableUntil: prev.AvailableUntil,
+		}
+	}
+	if req.Visibility == "" {
+		req.Visibility = cmp.Or(prev.Visibility, domain.VisibilityPublic)
+	}
+	req.OwnerID, req.OrgID, req.FileID = prev.OwnerID, prev.OrgID, ""
+	req.replaces = &prev
+	return s.Upload(ctx, req)
+}
+
+// RestoreVersion makes a kept version of a file its live content again.
+// The content it replaces is kept as a version in turn.
+func (s *UploadService) RestoreVersion(ctx context.Context, fileID string, number int, ownerID string) (domain.FileMetadata, error) {
+	prev, err := s.files.metadata.Get(ctx, fileID)
+	if err != nil || !prev.Servable() {
+		return domain.FileMetadata{}, errFileNotFound
+	}
+	version, ok := prev.Version(number)
+	if !ok {
+		return domain.FileMetadata{}, errVersionNotFound
+	}
+
+	r, info, err := s.files.storage.OpenDerivative(ctx, fileID, domain.VersionDerivative(number))
+	if err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("version %d missing from storage: %w", number, err)
+	}
+	defer r.Close()
+
+	return s.Replace(ctx, fileID, UploadRequest{
+		Content:     r,
+		Size:        info.Size,
+		Filename:    version.OriginalName,
+		ContentType: version.ContentType,
+		OwnerID:     ownerID,
+	})
+}
+
+// replace keeps the current content of prev as a version and stores body
+// in its place. It returns the versions the file has afterwards, the
+// oldest beyond MaxVersions dropped; their content is deleted by prune
+// once the new metadata is stored.
+func (s *UploadService) replace(ctx context.Context, prev domain.FileMetadata, body io.Reader, opts storage.SaveOptions) (storage.FileInfo, []domain.FileVersion, error) {
+	store := s.files.storage
+	number := prev.CurrentVersion()
+
+	current, _, err := store.Open(ctx, prev.ID)
+	if err != nil {
+		return storage.FileInfo{}, nil, fmt.Errorf("failed to open current content: %w", err)
+	}
+	_, err = store.SaveDerivative(ctx, prev.ID, domain.VersionDerivative(number), current, prev.ContentType)
+	current.Close()
+	if err != nil {
+		return storage.FileInfo{}, nil, fmt.Errorf("failed to keep current content: %w", err)
+	}
+
+	opts.ID = prev.ID
+	info, err := store.Replace(ctx, body, opts, keepVersions(prev, number))
+	if err != nil {
+		store.DeleteDerivative(ctx, prev.ID, domain.VersionDerivative(number))
+		return storage.FileInfo{}, nil, err
+	}
+
+	versions := append(slices.Clone(prev.Versions), domain.FileVersion{
+		Number:       number,
+		OriginalName: prev.OriginalName,
+		ContentType:  prev.ContentType,
+		Size:         prev.Size,
+		Checksums:    prev.Checksums,
+		CreatedAt:    prev.CreatedAt,
+	})
+	if s.maxVersions > 0 && len(versions) > s.maxVersions {
+		versions = versions[len(versions)-s.maxVersions:]
+	}
+	return info, versions, nil
+}
+
+// keepVersions accepts the derivatives holding the versions of prev and
+// the given new one; all other derivatives were made from the replaced
+// content.
+func keepVersions(prev domain.FileMetadata, number int) func(name string) bool {
+	return func(name string) bool {
+		if name == domain.VersionDerivative(number) {
+			return true
+		}
+		for _, v := range prev.Versions {
+			if name == domain.VersionDerivative(v.Number) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// discard removes the content stored by an upload that failed later on.
+// A replaced file gets its previous content back.
+func (s *UploadService) discard(ctx context.Context, id string, replaces *domain.FileMetadata) {
+	store := s.files.storage
+	if replaces == nil {
+		store.Delete(ctx, id)
+		return
+	}
+
+	number := replaces.CurrentVersion()
+	r, _, err := store.OpenDerivative(ctx, id, domain.VersionDerivative(number))
+	if err == nil {
+		_, err = store.Replace(ctx, r, storage.SaveOptions{
+			ID:           id,
+			Directory:    replaces.Directory,
+			ContentType:  replaces.ContentType,
+			OriginalName: replaces.OriginalName,
+		}, keepVersions(*replaces, 0))
+		r.Close()
+	}
+	if err != nil {
+		s.logger.Error("Failed to restore replaced content", "fileId", id, "version", number, "error", err)
+	}
+}
+
+// prune deletes the content of versions of prev that meta no longer
+// lists.
+func (s *UploadService) prune(ctx context.Context, prev, meta domain.FileMetadata) {
+	for _, v := range prev.Versions {
+		if _, ok := meta.Version(v.Number); ok {
+			continue
+		}
+		if err := s.files.storage.DeleteDerivative(ctx, meta.ID, domain.VersionDerivative(v.Number)); err != nil {
+			s.logger.Warn("Failed to prune file version", "fileId", meta.ID, "version", v.Number, "error", err)
+		}
+	}
+}
+
+var errNotComparable = refuse(ErrUnprocessable, "Version cannot be compared", "Only JPEG and PNG versions can be diffed")
+
+// DiffVersions compares two versions of an image file, which the caller
+// has checked may be delivered; see imaging.Diff. Versions beyond limits
+// are refused before decoding, and decoding waits for the governor like
+// other processing.
+func (s *FileService) DiffVersions(ctx context.Context, meta domain.FileMetadata, from, to int, limits imaging.Limits) (imaging.DiffResult, error) {
+	var (
+		versions [2]io.ReadSeekCloser
+		sizes    [2]image.Point
+	)
+	for i, number := range []int{from, to} {
+		r, err := s.openVersionBlob(ctx, meta, number)
+		if err != nil {
+			return imaging.DiffResult{}, err
+		}
+		defer r.Close()
+
+		cfg, _, err := image.DecodeConfig(r)
+		if err != nil {
+			return imaging.DiffResult{}, errNotComparable
+		}
+		if err := limits.Check(imaging.ImageInfo{Width: cfg.Width, Height: cfg.Height, Frames: 1}); err != nil {
+			return imaging.DiffResult{}, refuse(ErrUnprocessable, "Image too large", err.Error())
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return imaging.DiffResult{}, err
+		}
+		versions[i], sizes[i] = r, image.Pt(cfg.Width, cfg.Height)
+	}
+
+	release, err := s.governor.Admit(ctx, "diff", governor.Cost{Memory: imaging.DiffCost(sizes[0], sizes[1]), CPU: 1})
+	if err != nil {
+		s.logger.Warn("Version diff not admitted", "fileId", meta.ID, "error", err)
+		return imaging.DiffResult{}, err
+	}
+	defer release()
+
+	var images [2]image.Image
+	for i, r := range versions {
+		img, _, err := image.Decode(r)
+		if err != nil {
+			return imaging.DiffResult{}, errNotComparable
+		}
+		images[i] = img
+	}
+	return imaging.Diff(images[0], images[1]), nil
+}
+
+// openVersionBlob opens the stored content of a version of a file: the
+// live blob for the current version, a version derivative for the others.
+func (s *FileService) openVersionBlob(ctx context.Context, meta domain.FileMetadata, number int) (io.ReadSeekCloser, error) {
+	if number == meta.CurrentVersion() {
+		r, _, err := s.storage.Open(ctx, meta.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		return r, nil
+	}
+	if _, ok := meta.Version(number); !ok {
+		return nil, errVersionNotFound
+	}
+	r, _, err := s.storage.OpenDerivative(ctx, meta.ID, domain.VersionDerivative(number))
+	if err != nil {
+		return nil, fmt.Errorf("version %d missing from storage: %w", number, err)
+	}
+	return r, nil
+}
+
+// OpenVersion opens a version of a file for serving. The current version
+// is the live content; versions in watermarked directories are watermarked
+// like it.
+func (s *FileService) OpenVersion(ctx context.Context, id string, number int) (*Content, error) {
+	meta, err := s.deliverable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if number == meta.CurrentVersion() {
+		r, info, err := s.storage.Open(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		return s.versionContent(ctx, meta, derivativeContent(r, info, meta.ContentType), "")
+	}
+
+	version, ok := meta.Version(number)
+	if !ok {
+		return nil, errVersionNotFound
+	}
+	name := domain.VersionDerivative(number)
+	r, info, err := s.storage.OpenDerivative(ctx, id, name)
+	if err != nil {
+		return nil, fmt.Errorf("version %d missing from storage: %w", number, err)
+	}
+	return s.versionContent(ctx, meta, derivativeContent(r, info, version.ContentType), name+".")
+}
+
+func (s *FileService) versionContent(ctx context.Context, meta domain.FileMetadata, content *Content, variant string) (*Content, error) {
+	mark := s.watermarkFor(ctx, meta.Directory, content.ContentType, meta.OrgID)
+	if mark == nil {
+		return content, nil
+	}
+	data, err := s.watermarked(ctx, mark, meta.ID, variant, content, content.ContentType, content.dims)
+	if err != nil {
+		content.Close()
+		return nil, fmt.Errorf("failed to watermark image: %w", err)
+	}
+	content.ReadSeeker, content.Size = bytes.NewReader(data), int64(len(data))
+	return content, nil
+}
@@ -0,0 +1,186 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/signedurl"
+)
+
+var (
+	errInvalidVisibility = refuse(ErrInvalid, "Invalid visibility", "visibility must be public, org or private")
+	errSigningDisabled   = refuse(ErrUnavailable, "Signed URLs not enabled", "No signing key is configured")
+)
+
+// ParseVisibility reads a visibility; "" is none.
+func ParseVisibility(v string) (domain.Visibility, error) {
+	visibility := domain.Visibility(v)
+	if v != "" && !slices.Contains(domain.Visibilities, visibility) {
+		return "", errInvalidVisibility
+	}
+	return visibility, nil
+}
+
+// visibilityOf returns the visibility of an upload: the one it asks for,
+// or the default of its organization, or else of the service.
+func (s *UploadService) visibilityOf(ctx context.Context, req UploadRequest) (domain.Visibility, error) {
+	if req.Visibility != "" {
+		return ParseVisibility(string(req.Visibility))
+	}
+	if s.orgs != nil && req.OrgID != "" {
+		settings, err := s.orgs.GetOrgSettings(ctx, req.OrgID)
+		switch {
+		case err == nil && settings.DefaultVisibility != "":
+			return settings.DefaultVisibility, nil
+		case err != nil && !errors.Is(err, metadata.ErrNotFound):
+			return "", err
+		}
+	}
+	return s.visibility, nil
+}
+
+// FileScope is what a download signature grants access to: a file and
+// its derivatives.
+func FileScope(fileID string) string {
+	return "/files/" + fileID
+}
+
+type grantKey struct{}
+
+// withGrant returns a copy of ctx granting access to a file whatever its
+// visibility, for requests carrying a signature issued for it.
+func withGrant(ctx context.Context, fileID string) context.Context {
+	return context.WithValue(ctx, grantKey{}, fileID)
+}
+
+// checkVisibility refuses files that are not public to callers other than
+// their owner, or for files visible to their organization, callers of it.
+// Admins and requests with a signature for the file may see any file.
+func checkVisibility(ctx context.Context, meta domain.FileMetadata) error {
+	if Visible(ctx, meta) {
+		return nil
+	}
+	caller, ok := auth.FromContext(ctx)
+	if !ok {
+		return refuse(ErrUnauthorized, "Authentication required", "The file is not public; sign in or use a signed URL")
+	}
+	if caller.HasPermission("files:admin") {
+		return nil
+	}
+	return refuse(ErrForbidden, "Insufficient permissions", "The file is "+string(meta.Visibility))
+}
+
+// Visible reports whether the client of ctx may see a file without being
+// an admin.
+func Visible(ctx context.Context, meta domain.FileMetadata) bool {
+	if meta.Public() {
+		return true
+	}
+	if granted, _ := ctx.Value(grantKey{}).(string); granted == meta.ID {
+		return true
+	}
+	caller, ok := auth.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	return caller.UserID == meta.OwnerID ||
+		meta.Visibility == domain.VisibilityOrg && meta.OrgID != "" && caller.OrgID != nil && *caller.OrgID == meta.OrgID
+}
+
+// auditAdminAccess records the access of an admin to a file they may only
+// see as one.
+func (s *FileService) auditAdminAccess(ctx context.Context, meta domain.FileMetadata) {
+	if Visible(ctx, meta) {
+		return
+	}
+	s.audit.Record(ctx, domain.AuditAdminAccess, meta.ID, map[string]string{"access": "read", "ownerId": meta.OwnerID, "orgId": meta.OrgID})
+}
+
+// SignedURL returns the URL of a file, signed to grant access to it and
+// its derivatives until expiresAt, or for as long as allowed when it is
+// zero, whatever its visibility. Only callers who may see the file get one.
+func (s *FileService) SignedURL(ctx context.Context, id string, expiresAt time.Time) (string, time.Time, error) {
+	meta, err := s.Info(ctx, id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !s.downloadSigner.Enabled() {
+		return "", time.Time{}, errSigningDisabled
+	}
+
+	now := time.Now().UTC()
+	latest := now.Add(s.downloadURLTTL).Truncate(time.Second)
+	switch {
+	case expiresAt.IsZero():
+		expiresAt = latest
+	case !expiresAt.After(now) || expiresAt.After(latest):
+		return "", time.Time{}, refuse(ErrInvalid, "Invalid expiry", fmt.Sprintf("expiresAt must be in the future and within %s", s.downloadURLTTL))
+	default:
+		expiresAt = expiresAt.UTC()
+	}
+	query := s.downloadSigner.Sign(FileScope(meta.ID), expiresAt)
+	return s.storage.URL(meta.ID) + "?" + query.Encode(), expiresAt, nil
+}
+
+// LinkURL returns the URL a short link redirects to: its file with the
+// query of the link, and for signed links a signature minted now that
+// lasts until the link expires, or as long as allowed if that is sooner.
+// It does not check the caller, who follows a link anonymously.
+func (s *FileService) LinkURL(link domain.ShortLink) (string, error) {
+	query, err := url.ParseQuery(link.Query)
+	if err != nil {
+		return "", fmt.Errorf("invalid short link query: %w", err)
+	}
+	if link.Signed {
+		if !s.downloadSigner.Enabled() {
+			return "", errSigningDisabled
+		}
+		expiresAt := time.Now().UTC().Add(s.downloadURLTTL).Truncate(time.Second)
+		if link.ExpiresAt != nil && link.ExpiresAt.Before(expiresAt) {
+			expiresAt = link.ExpiresAt.UTC()
+		}
+		for k, v := range s.downloadSigner.Sign(FileScope(link.FileID), expiresAt) {
+			query[k] = v
+		}
+	}
+	target := s.storage.URL(link.FileID)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target, nil
+}
+
+// Authorize returns a copy of ctx granting access to a file if query
+// carries a signature issued for it by SignedURL. Queries without one
+// leave ctx as it is; invalid and expired signatures are refused.
+func (s *FileService) Authorize(ctx context.Context, fileID string, query url.Values) (context.Context, error) {
+	if !query.Has(signedurl.SignatureParam) || !s.downloadSigner.Enabled() {
+		return ctx, nil
+	}
+	switch err := s.downloadSigner.Verify(FileScope(fileID), query, time.Now()); {
+	case errors.Is(err, signedurl.ErrExpired):
+		return ctx, refuse(ErrForbidden, "Signed URL expired", "")
+	case err != nil:
+		return ctx, refuse(ErrForbidden, "Invalid signature", "")
+	}
+	return withGrant(ctx, fileID), nil
+}
+
+// AuthorizeStream returns a copy of ctx granting access to a video whose
+// stream was requested with a valid signature, see VerifyStream.
+func (s *FileService) AuthorizeStream(ctx context.Context, fileID string, query url.Values) (context.Context, error) {
+	if err := s.VerifyStream(fileID, query); err != nil {
+		return ctx, err
+	}
+	if !s.streamSigner.Enabled() {
+		return ctx, nil
+	}
+	return withGrant(ctx, fileID), nil
+}
@@ -0,0 +1,251 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/auth"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata/jsonfile"
+	"github.com/ondrasimku/media-service-go/internal/signedurl"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/storage/local"
+)
+
+// newTestFileService returns a FileService over local storage and a JSON
+// metadata store in temporary directories, signing download URLs.
+func newTestFileService(t *testing.T) (*FileService, *local.LocalStorage, *jsonfile.Store) {
+	t.Helper()
+	store, err := local.NewLocalStorage(t.TempDir(), "https://media.test/v1/files", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := jsonfile.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := NewFileService(store, meta, FileConfig{
+		DownloadSigner: signedurl.NewSigner("test-download-key"),
+		DownloadURLTTL: time.Hour,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return files, store, meta
+}
+
+// putFile stores content with a metadata record, unless meta is nil.
+func putFile(t *testing.T, store storage.Storage, records *jsonfile.Store, id string, meta *domain.FileMetadata) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.Save(ctx, strings.NewReader("content of "+id), storage.SaveOptions{ID: id, Directory: "files", ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil {
+		return
+	}
+	meta.ID, meta.ContentType, meta.CreatedAt = id, "text/plain", time.Now()
+	if err := records.Put(ctx, *meta); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func asUser(userID, orgID string, permissions ...string) context.Context {
+	return auth.NewContext(context.Background(), &auth.AuthContext{UserID: userID, OrgID: &orgID, Permissions: permissions})
+}
+
+func TestOpen(t *testing.T) {
+	files, store, records := newTestFileService(t)
+	past := time.Now().Add(-time.Minute)
+	putFile(t, store, records, "orphan", nil)
+	putFile(t, store, records, "public", &domain.FileMetadata{OwnerID: "alice", Visibility: domain.VisibilityPublic})
+	putFile(t, store, records, "private", &domain.FileMetadata{OwnerID: "alice", OrgID: "acme", Visibility: domain.VisibilityPrivate})
+	putFile(t, store, records, "org", &domain.FileMetadata{OwnerID: "alice", OrgID: "acme", Visibility: domain.VisibilityOrg})
+	putFile(t, store, records, "expired", &domain.FileMetadata{OwnerID: "alice", ExpiresAt: &past})
+	putFile(t, store, records, "takendown", &domain.FileMetadata{OwnerID: "alice", Status: domain.FileStatusTakenDown})
+
+	anonymous := context.Background()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		id   string
+		want error
+	}{
+		{"content without metadata", asUser("alice", "acme", "files:admin"), "orphan", ErrNotFound},
+		{"missing file", anonymous, "missing", ErrNotFound},
+		{"public to anyone", anonymous, "public", nil},
+		{"private to anonymous", anonymous, "private", ErrUnauthorized},
+		{"private to another member", asUser("bob", "acme"), "private", ErrForbidden},
+		{"private to its owner", asUser("alice", "acme"), "private", nil},
+		{"private to an admin", asUser("carol", "other", "files:admin"), "private", nil},
+		{"org to a member", asUser("bob", "acme"), "org", nil},
+		{"org to another organization", asUser("dave", "other"), "org", ErrForbidden},
+		{"expired", asUser("alice", ""), "expired", ErrNotFound},
+		{"taken down", asUser("alice", ""), "takendown", ErrRestricted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := files.Open(tt.ctx, tt.id, 0, false)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("Open(%q) = %v, want %v", tt.id, err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open(%q) = %v", tt.id, err)
+			}
+			defer content.Close()
+			data, err := io.ReadAll(content)
+			if err != nil || string(data) != "content of "+tt.id {
+				t.Fatalf("Open(%q) read %q, %v", tt.id, data, err)
+			}
+		})
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	files, store, records := newTestFileService(t)
+	putFile(t, store, records, "private", &domain.FileMetadata{OwnerID: "alice", Visibility: domain.VisibilityPrivate})
+	putFile(t, store, records, "other", &domain.FileMetadata{OwnerID: "alice", Visibility: domain.VisibilityPrivate})
+	owner := asUser("alice", "")
+
+	if _, _, err := files.SignedURL(asUser("bob", ""), "private", time.Time{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("SignedURL by another user = %v, want %v", err, ErrForbidden)
+	}
+	for _, expiresAt := range []time.Time{time.Now().Add(-time.Second), time.Now().Add(2 * time.Hour)} {
+		if _, _, err := files.SignedURL(owner, "private", expiresAt); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("SignedURL expiring at %v = %v, want %v", expiresAt, err, ErrInvalid)
+		}
+	}
+
+	signed, expiresAt, err := files.SignedURL(owner, "private", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiresAt); d <= 0 || d > time.Hour {
+		t.Fatalf("SignedURL expires in %v, want within an hour", d)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := store.URL("private"); !strings.HasPrefix(signed, want+"?") {
+		t.Fatalf("SignedURL = %q, want the URL of the file %q", signed, want)
+	}
+
+	// The signature grants an anonymous client the file it was issued for.
+	ctx, err := files.Authorize(context.Background(), "private", u.Query())
+	if err != nil {
+		t.Fatalf("Authorize = %v", err)
+	}
+	content, err := files.Open(ctx, "private", 0, false)
+	if err != nil {
+		t.Fatalf("Open with signature = %v", err)
+	}
+	content.Close()
+
+	// But no other file.
+	if _, err := files.Authorize(context.Background(), "other", u.Query()); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Authorize for another file = %v, want %v", err, ErrForbidden)
+	}
+	ctx, _ = files.Authorize(context.Background(), "private", u.Query())
+	if _, err := files.Open(ctx, "other", 0, false); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Open of another file with signature = %v, want %v", err, ErrUnauthorized)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	files, _, _ := newTestFileService(t)
+	signer := signedurl.NewSigner("test-download-key")
+	valid := signer.Sign(FileScope("f"), time.Now().Add(time.Minute))
+
+	tampered := url.Values{}
+	for k, v := range valid {
+		tampered[k] = v
+	}
+	tampered.Set(signedurl.SignatureParam, strings.Repeat("0", len(valid.Get(signedurl.SignatureParam))))
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  error
+		grant bool
+	}{
+		{"no signature", url.Values{}, nil, false},
+		{"valid", valid, nil, true},
+		{"expired", signer.Sign(FileScope("f"), time.Now().Add(-time.Minute)), ErrForbidden, false},
+		{"tampered", tampered, ErrForbidden, false},
+		{"other key", signedurl.NewSigner("other-key").Sign(FileScope("f"), time.Now().Add(time.Minute)), ErrForbidden, false},
+		{"other file", signer.Sign(FileScope("g"), time.Now().Add(time.Minute)), ErrForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := files.Authorize(context.Background(), "f", tt.query)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Authorize = %v, want %v", err, tt.want)
+			}
+			private := domain.FileMetadata{ID: "f", OwnerID: "alice", Visibility: domain.VisibilityPrivate}
+			if got := Visible(ctx, private); got != tt.grant {
+				t.Fatalf("visible after Authorize = %v, want %v", got, tt.grant)
+			}
+		})
+	}
+}
+
+func TestLinkURL(t *testing.T) {
+	files, store, records := newTestFileService(t)
+	putFile(t, store, records, "private", &domain.FileMetadata{OwnerID: "alice", Visibility: domain.VisibilityPrivate})
+
+	plain, err := files.LinkURL(domain.ShortLink{FileID: "private", Query: "size=64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := store.URL("private") + "?size=64"; plain != want {
+		t.Fatalf("LinkURL = %q, want %q", plain, want)
+	}
+
+	soon := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	for _, tt := range []struct {
+		name      string
+		expiresAt *time.Time
+		want      time.Time
+	}{
+		{"without expiry", nil, time.Now().Add(time.Hour)},
+		{"expiring sooner", &soon, soon},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := files.LinkURL(domain.ShortLink{FileID: "private", Query: "size=64", Signed: true, ExpiresAt: tt.expiresAt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := u.Query()
+			if query.Get("size") != "64" {
+				t.Fatalf("LinkURL = %q, want the query of the link", target)
+			}
+			expires, _ := strconv.ParseInt(query.Get(signedurl.ExpiresParam), 10, 64)
+			if d := time.Unix(expires, 0).Sub(tt.want); d < -time.Second || d > time.Second {
+				t.Fatalf("signature expires at %v, want %v", time.Unix(expires, 0), tt.want)
+			}
+
+			// The signature lets an anonymous client open the file.
+			ctx, err := files.Authorize(context.Background(), "private", query)
+			if err != nil {
+				t.Fatalf("Authorize = %v", err)
+			}
+			content, err := files.Open(ctx, "private", 0, false)
+			if err != nil {
+				t.Fatalf("Open with signature = %v", err)
+			}
+			content.Close()
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind is a category of recorded events.
+type Kind int
+
+const (
+	Uploads Kind = iota
+	Downloads
+	Errors
+	Jobs
+	numKinds
+)
+
+// Resolution is the granularity events are kept at; windows and buckets
+// are rounded to it.
+const Resolution = time.Minute
+
+type Counts struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.Count += o.Count
+	c.Bytes += o.Bytes
+}
+
+type slot struct {
+	start  time.Time
+	counts [numKinds]Counts
+}
+
+// Recorder keeps per-minute event counts for a fixed retention period in
+// a ring buffer, so memory use does not grow with traffic.
+type Recorder struct {
+	mu    sync.Mutex
+	slots []slot
+}
+
+func NewRecorder(retention time.Duration) *Recorder {
+	n := max(int(retention/Resolution), 1)
+	return &Recorder{slots: make([]slot, n)}
+}
+
+// Retention is how far back the recorder can answer queries.
+func (r *Recorder) Retention() time.Duration {
+	return time.Duration(len(r.slots)) * Resolution
+}
+
+// Record counts one event of kind carrying bytes of payload. A nil
+// recorder ignores events.
+func (r *Recorder) Record(kind Kind, bytes int64) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.slot(now)
+	s.counts[kind].Count++
+	s.counts[kind].Bytes += bytes
+}
+
+// slot returns the slot for t, clearing it if it still holds an older
+// minute. The caller holds r.mu.
+func (r *Recorder) slot(t time.Time) *slot {
+	start := t.Truncate(Resolution)
+	s := &r.slots[int(start.Unix()/int64(Resolution/time.Second))%len(r.slots)]
+	if !s.start.Equal(start) {
+		*s = slot{start: start}
+	}
+	return s
+}
+
+type Totals struct {
+	Uploads   Counts `json:"uploads"`
+	Downloads Counts `json:"downloads"`
+	Errors    Counts `json:"errors"`
+	Jobs      Counts `json:"jobs"`
+}
+
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Totals
+}
+
+func (t *Totals) add(counts [numKinds]Counts) {
+	t.Uploads.add(counts[Uploads])
+	t.Downloads.add(counts[Downloads])
+	t.Errors.add(counts[Errors])
+	t.Jobs.add(counts[Jobs])
+}
+
+type Summary struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Window  string    `json:"window"`
+	Bucket  string    `json:"bucket"`
+	Totals  Totals    `json:"totals"`
+	Buckets []Bucket  `json:"buckets"`
+}
+
+// Summarize aggregates the events of the last window into buckets of the
+// given width, oldest first. The current, partial minute is included.
+// Both durations are rounded down to whole minutes and window is capped
+// at the retention period.
+func (r *Recorder) Summarize(now time.Time, window, bucket time.Duration) Summary {
+	window = min(max(window.Truncate(Resolution), Resolution), r.Retention())
+	bucket = min(max(bucket.Truncate(Resolution), Resolution), window)
+
+	to := now.Truncate(Resolution).Add(Resolution)
+	from := to.Add(-window)
+	summary := Summary{
+		From:    from,
+		To:      to,
+		Window:  window.String(),
+		Bucket:  bucket.String(),
+		Buckets: make([]Bucket, 0, int((window+bucket-1)/bucket)),
+	}
+	for start := from; start.Before(to); start = start.Add(bucket) {
+		summary.Buckets = append(summary.Buckets, Bucket{Start: start})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.slots {
+		if s.start.Before(from) || !s.start.Before(to) {
+			continue
+		}
+		i := int(s.start.Sub(from) / bucket)
+		summary.Buckets[i].add(s.counts)
+		summary.Totals.add(s.counts)
+	}
+	return summary
+}
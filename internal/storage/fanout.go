@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+)
+
+// fanoutDepth is the number of chunks a slow writer may lag behind the
+// reader.
+const fanoutDepth = 4
+
+// chunk is a block of input shared read-only by all writers. Its buffer
+// returns to the pool once every writer is done with it.
+type chunk struct {
+	buf  *[]byte
+	n    int
+	refs atomic.Int32
+}
+
+func (c *chunk) release() {
+	if c.refs.Add(-1) == 0 {
+		bufpool.Put(c.buf)
+	}
+}
+
+// Fanout copies r to every writer, each running in its own goroutine fed
+// through a bounded queue. Disk writes and checksum computation overlap
+// instead of running one after the other for every block, and memory use
+// stays at a few chunks per writer regardless of the input size. It stops
+// at the first error and returns the number of bytes read.
+func Fanout(r io.Reader, writers ...io.Writer) (int64, error) {
+	queues := make([]chan *chunk, len(writers))
+	errs := make([]error, len(writers))
+	var (
+		failed atomic.Bool
+		wg     sync.WaitGroup
+	)
+	for i, w := range writers {
+		queues[i] = make(chan *chunk, fanoutDepth)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range queues[i] {
+				if errs[i] == nil {
+					if _, err := w.Write((*c.buf)[:c.n]); err != nil {
+						errs[i] = err
+						failed.Store(true)
+					}
+				}
+				c.release()
+			}
+		}()
+	}
+
+	var (
+		total   int64
+		readErr error
+	)
+	for !failed.Load() {
+		buf := bufpool.Get(bufpool.Large)
+		n, err := io.ReadFull(r, *buf)
+		if n > 0 {
+			c := &chunk{buf: buf, n: n}
+			c.refs.Store(int32(len(writers)))
+			for _, q := range queues {
+				q <- c
+			}
+			total += int64(n)
+		} else {
+			bufpool.Put(buf)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+
+	if readErr != nil {
+		return total, readErr
+	}
+	for _, err := range errs {
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -2,21 +2,59 @@ package local
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/checksum"
+	"github.com/ondrasimku/media-service-go/internal/mediatype"
 	"github.com/ondrasimku/media-service-go/internal/storage"
 )
 
+const derivativesDir = "derived"
+
+// originalDirs are the directories originals are stored in by uploads.
+var originalDirs = []string{"avatars", "files"}
+
 type LocalStorage struct {
 	baseDir       string
 	publicBaseURL string
+	checksums     []string
+	dirs          []string // Directories originals are stored in
 }
 
-func NewLocalStorage(baseDir, publicBaseURL string) (*LocalStorage, error) {
+// NewLocalStorage stores files under baseDir and records a checksum with
+// each of the given algorithms on Save. SHA-256 is always recorded, as it
+// is what clients verify uploads and downloads with. Besides the
+// directories uploads are stored in, files can be copied and moved to the
+// given directories.
+func NewLocalStorage(baseDir, publicBaseURL string, checksums, directories []string) (*LocalStorage, error) {
+	if !slices.Contains(checksums, checksum.SHA256) {
+		checksums = append([]string{checksum.SHA256}, checksums...)
+	}
+	for _, a := range checksums {
+		if !checksum.Supported(a) {
+			return nil, fmt.Errorf("unsupported checksum algorithm %q", a)
+		}
+	}
+	dirs := slices.Clone(originalDirs)
+	for _, dir := range directories {
+		if dir != filepath.Base(dir) || strings.HasPrefix(dir, ".") || dir == derivativesDir {
+			return nil, fmt.Errorf("invalid storage directory %q", dir)
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create base directory: %w", err)
 	}
@@ -24,11 +62,21 @@ func NewLocalStorage(baseDir, publicBaseURL string) (*LocalStorage, error) {
 	return &LocalStorage{
 		baseDir:       baseDir,
 		publicBaseURL: publicBaseURL,
+		checksums:     checksums,
+		dirs:          dirs,
 	}, nil
 }
 
 func (s *LocalStorage) Save(ctx context.Context, r io.Reader, opts storage.SaveOptions) (storage.FileInfo, error) {
-	id := uuid.New().String()
+	id := opts.ID
+	if id == "" {
+		id = uuid.New().String()
+	} else if id != filepath.Base(id) || id == "." || id == ".." {
+		return storage.FileInfo{}, fmt.Errorf("invalid file ID %q", id)
+	} else if f, _, err := s.Open(ctx, id); err == nil {
+		f.Close()
+		return storage.FileInfo{}, storage.ErrExists
+	}
 
 	dir := filepath.Join(s.baseDir, opts.Directory)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -36,33 +84,40 @@ func (s *LocalStorage) Save(ctx context.Context, r io.Reader, opts storage.SaveO
 	}
 
 	filePath := filepath.Join(dir, id)
-	file, err := os.Create(filePath)
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return storage.FileInfo{}, storage.ErrExists
+	}
 	if err != nil {
 		return storage.FileInfo{}, fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
 
-	size, err := io.Copy(file, r)
+	hasher, err := checksum.NewMulti(s.checksums)
+	if err != nil {
+		os.Remove(filePath)
+		return storage.FileInfo{}, err
+	}
+	size, err := storage.Fanout(r, append([]io.Writer{file}, hasher.Writers()...)...)
 	if err != nil {
 		os.Remove(filePath)
 		return storage.FileInfo{}, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/files/%s", s.publicBaseURL, id)
-
 	return storage.FileInfo{
 		ID:          id,
 		Path:        filePath,
 		ContentType: opts.ContentType,
 		Size:        size,
-		URL:         url,
+		URL:         s.URL(id),
+		Directory:   opts.Directory,
+		CreatedAt:   time.Now().UTC(),
+		Checksums:   hasher.Sums(),
 	}, nil
 }
 
 func (s *LocalStorage) Open(ctx context.Context, id string) (io.ReadSeekCloser, storage.FileInfo, error) {
-	dirs := []string{"avatars", "files"}
-
-	for _, dir := range dirs {
+	for _, dir := range s.dirs {
 		filePath := filepath.Join(s.baseDir, dir, id)
 		file, err := os.Open(filePath)
 		if err == nil {
@@ -72,23 +127,16 @@ func (s *LocalStorage) Open(ctx context.Context, id string) (io.ReadSeekCloser,
 				continue
 			}
 
-			contentType := "application/octet-stream"
-			ext := filepath.Ext(filePath)
-			switch ext {
-			case ".jpg", ".jpeg":
-				contentType = "image/jpeg"
-			case ".png":
-				contentType = "image/png"
-			case ".webp":
-				contentType = "image/webp"
-			}
+			contentType := mediatype.FromExtension(filePath)
 
 			info := storage.FileInfo{
 				ID:          id,
 				Path:        filePath,
 				ContentType: contentType,
 				Size:        stat.Size(),
-				URL:         fmt.Sprintf("%s/files/%s", s.publicBaseURL, id),
+				URL:         s.URL(id),
+				Directory:   dir,
+				CreatedAt:   stat.ModTime().UTC(),
 			}
 
 			return file, info, nil
@@ -99,14 +147,392 @@ func (s *LocalStorage) Open(ctx context.Context, id string) (io.ReadSeekCloser,
 }
 
 func (s *LocalStorage) Delete(ctx context.Context, id string) error {
-	dirs := []string{"avatars", "files"}
-
-	for _, dir := range dirs {
+	for _, dir := range s.dirs {
 		filePath := filepath.Join(s.baseDir, dir, id)
 		if err := os.Remove(filePath); err == nil {
+			os.RemoveAll(s.derivativeDir(id))
 			return nil
 		}
 	}
 
-	return fmt.Errorf("file not found")
+	return storage.ErrNotFound
+}
+
+// Replace writes the new content next to the old one and renames it into
+// place, so readers see either in full. The old blob is removed if the
+// content moves to another directory.
+func (s *LocalStorage) Replace(ctx context.Context, r io.Reader, opts storage.SaveOptions, keep func(name string) bool) (storage.FileInfo, error) {
+	f, old, err := s.Open(ctx, opts.ID)
+	if err != nil {
+		return storage.FileInfo{}, err
+	}
+	f.Close()
+
+	dir := filepath.Join(s.baseDir, opts.Directory)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher, err := checksum.NewMulti(s.checksums)
+	if err != nil {
+		tmp.Close()
+		return storage.FileInfo{}, err
+	}
+	size, err := storage.Fanout(r, append([]io.Writer{tmp}, hasher.Writers()...)...)
+	if syncErr := tmp.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	filePath := filepath.Join(dir, opts.ID)
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to replace file: %w", err)
+	}
+	if old.Path != filePath {
+		os.Remove(old.Path)
+	}
+
+	entries, _ := os.ReadDir(s.derivativeDir(opts.ID))
+	for _, entry := range entries {
+		// Derivatives being written are left to their writers.
+		if !strings.HasPrefix(entry.Name(), ".tmp-") && !keep(entry.Name()) {
+			os.Remove(filepath.Join(s.derivativeDir(opts.ID), entry.Name()))
+		}
+	}
+
+	return storage.FileInfo{
+		ID:          opts.ID,
+		Path:        filePath,
+		ContentType: opts.ContentType,
+		Size:        size,
+		URL:         s.URL(opts.ID),
+		Directory:   opts.Directory,
+		CreatedAt:   time.Now().UTC(),
+		Checksums:   hasher.Sums(),
+	}, nil
+}
+
+// Copy copies the blob and its derivatives within the file system, which
+// on Linux lets the kernel copy the data, or share it on file systems that
+// support reflinks. Copies are not hard links: their modification time
+// tells the garbage collector they are new.
+func (s *LocalStorage) Copy(ctx context.Context, id string, opts storage.SaveOptions, keep func(name string) bool) (storage.FileInfo, error) {
+	if !slices.Contains(s.dirs, opts.Directory) {
+		return storage.FileInfo{}, storage.ErrUnknownDirectory
+	}
+	f, src, err := s.Open(ctx, id)
+	if err != nil {
+		return storage.FileInfo{}, err
+	}
+	f.Close()
+
+	copyID := opts.ID
+	if copyID == "" {
+		copyID = uuid.New().String()
+	} else if copyID != filepath.Base(copyID) || copyID == "." || copyID == ".." {
+		return storage.FileInfo{}, fmt.Errorf("invalid file ID %q", copyID)
+	} else if f, _, err := s.Open(ctx, copyID); err == nil {
+		f.Close()
+		return storage.FileInfo{}, storage.ErrExists
+	}
+
+	dir := filepath.Join(s.baseDir, opts.Directory)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	filePath := filepath.Join(dir, copyID)
+	err = copyFile(filePath, src.Path, os.O_EXCL)
+	if errors.Is(err, fs.ErrExist) {
+		return storage.FileInfo{}, storage.ErrExists
+	}
+	if err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	entries, _ := os.ReadDir(s.derivativeDir(id))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".tmp-") || !keep(entry.Name()) {
+			continue
+		}
+		if err := s.copyDerivative(copyID, filepath.Join(s.derivativeDir(id), entry.Name()), entry.Name()); err != nil {
+			s.Delete(ctx, copyID)
+			return storage.FileInfo{}, fmt.Errorf("failed to copy derivative %s: %w", entry.Name(), err)
+		}
+	}
+
+	return storage.FileInfo{
+		ID:          copyID,
+		Path:        filePath,
+		ContentType: opts.ContentType,
+		Size:        src.Size,
+		URL:         s.URL(copyID),
+		Directory:   opts.Directory,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// copyDerivative copies src to the derivative name of id through a temp
+// file, like SaveDerivative.
+func (s *LocalStorage) copyDerivative(id, src, name string) error {
+	dir := s.derivativeDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := copyFile(tmp.Name(), src, os.O_TRUNC); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// copyFile copies src to dst, opened with flag in addition to create and
+// write. Copying from one *os.File to another uses copy_file_range where
+// available.
+func copyFile(dst, src string, flag int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if syncErr := out.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && flag&os.O_EXCL != 0 {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// Move renames the blob into the other directory.
+func (s *LocalStorage) Move(ctx context.Context, id, directory string) (storage.FileInfo, error) {
+	if !slices.Contains(s.dirs, directory) {
+		return storage.FileInfo{}, storage.ErrUnknownDirectory
+	}
+	f, info, err := s.Open(ctx, id)
+	if err != nil {
+		return storage.FileInfo{}, err
+	}
+	f.Close()
+	if info.Directory == directory {
+		return info, nil
+	}
+
+	dir := filepath.Join(s.baseDir, directory)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	filePath := filepath.Join(dir, id)
+	if err := os.Rename(info.Path, filePath); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to move file: %w", err)
+	}
+	info.Path, info.Directory = filePath, directory
+	return info, nil
+}
+
+// Restore atomically replaces the content of a blob, e.g. with a
+// known-good copy after corruption was detected. A missing blob is written
+// anew in directory.
+func (s *LocalStorage) Restore(ctx context.Context, id, directory string, r io.Reader) error {
+	var path string
+	if f, info, err := s.Open(ctx, id); err == nil {
+		f.Close()
+		path = info.Path
+	} else {
+		if id != filepath.Base(id) || id == "." || id == ".." {
+			return fmt.Errorf("invalid file ID %q", id)
+		}
+		if !slices.Contains(s.dirs, directory) {
+			return fmt.Errorf("invalid storage directory %q", directory)
+		}
+		dir := filepath.Join(s.baseDir, directory)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		path = filepath.Join(dir, id)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = bufpool.Copy(tmp, r)
+	if syncErr := tmp.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to restore file: %w", err)
+	}
+	return nil
+}
+
+// List passes every stored original to fn, see integrity.Lister.
+// Files being written are skipped.
+func (s *LocalStorage) List(ctx context.Context, fn func(storage.FileInfo) error) error {
+	for _, dir := range s.dirs {
+		entries, err := os.ReadDir(filepath.Join(s.baseDir, dir))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".tmp-") {
+				continue
+			}
+			stat, err := entry.Info()
+			if err != nil {
+				// Removed since it was listed.
+				continue
+			}
+			filePath := filepath.Join(s.baseDir, dir, entry.Name())
+			err = fn(storage.FileInfo{
+				ID:          entry.Name(),
+				Path:        filePath,
+				ContentType: mediatype.FromExtension(filePath),
+				Size:        stat.Size(),
+				URL:         s.URL(entry.Name()),
+				Directory:   dir,
+				CreatedAt:   stat.ModTime().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ping writes and removes a probe file in the base directory.
+func (s *LocalStorage) Ping(ctx context.Context) error {
+	tmp, err := os.CreateTemp(s.baseDir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write([]byte("ping"))
+	if syncErr := tmp.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write probe file: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) SaveDerivative(ctx context.Context, id, name string, r io.Reader, contentType string) (storage.FileInfo, error) {
+	dir := s.derivativeDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temp file and rename so concurrent readers never see a
+	// partially written derivative.
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := bufpool.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	filePath := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to store derivative: %w", err)
+	}
+
+	return storage.FileInfo{
+		ID:          id,
+		Path:        filePath,
+		ContentType: contentType,
+		Size:        size,
+		URL:         s.URL(id),
+		Directory:   derivativesDir,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+func (s *LocalStorage) OpenDerivative(ctx context.Context, id, name string) (io.ReadSeekCloser, storage.FileInfo, error) {
+	filePath := filepath.Join(s.derivativeDir(id), name)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, storage.FileInfo{}, fmt.Errorf("derivative not found")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, storage.FileInfo{}, fmt.Errorf("failed to stat derivative: %w", err)
+	}
+
+	return file, storage.FileInfo{
+		ID:          id,
+		Path:        filePath,
+		ContentType: mime.TypeByExtension(filepath.Ext(name)),
+		Size:        stat.Size(),
+		URL:         s.URL(id),
+		Directory:   derivativesDir,
+		CreatedAt:   stat.ModTime().UTC(),
+	}, nil
+}
+
+func (s *LocalStorage) DeleteDerivative(ctx context.Context, id, name string) error {
+	err := os.Remove(filepath.Join(s.derivativeDir(id), name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete derivative: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(id string) string {
+	return fmt.Sprintf("%s/v1/files/%s", s.publicBaseURL, id)
+}
+
+func (s *LocalStorage) derivativeDir(id string) string {
+	return filepath.Join(s.baseDir, derivativesDir, filepath.Base(id))
 }
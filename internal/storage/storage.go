@@ -2,10 +2,25 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
 )
 
+// ErrExists is returned by Save when a caller-chosen ID is already taken.
+var ErrExists = errors.New("file already exists")
+
+// ErrNotFound is returned by Delete when the file is not stored.
+var ErrNotFound = errors.New("file not found")
+
+// ErrUnknownDirectory is returned by Copy and Move for a directory the
+// backend does not store originals in.
+var ErrUnknownDirectory = errors.New("unknown directory")
+
 type SaveOptions struct {
+	// ID is the ID to store the file under. If empty the backend
+	// generates one.
+	ID           string
 	Directory    string
 	ContentType  string
 	OriginalName string
@@ -17,10 +32,39 @@ type FileInfo struct {
 	ContentType string
 	Size        int64
 	URL         string
+	Directory   string
+	CreatedAt   time.Time
+
+	// Checksums maps an algorithm name (see package checksum) to the hex
+	// digest of the content. Backends fill it in on Save.
+	Checksums map[string]string
 }
 
 type Storage interface {
 	Save(ctx context.Context, r io.Reader, opts SaveOptions) (FileInfo, error)
 	Open(ctx context.Context, id string) (io.ReadSeekCloser, FileInfo, error)
 	Delete(ctx context.Context, id string) error
+	URL(id string) string
+
+	// Replace stores r as the new content of the existing original
+	// opts.ID, like Save would, and removes the derivatives generated
+	// from the old content: all but those keep reports true for.
+	Replace(ctx context.Context, r io.Reader, opts SaveOptions, keep func(name string) bool) (FileInfo, error)
+
+	// Copy stores a copy of the original id as opts.ID in opts.Directory,
+	// together with the derivatives keep reports true for, without passing
+	// the content through the caller. Move puts the original id in another
+	// directory, its derivatives staying with it.
+	Copy(ctx context.Context, id string, opts SaveOptions, keep func(name string) bool) (FileInfo, error)
+	Move(ctx context.Context, id, directory string) (FileInfo, error)
+
+	// Derivatives are files generated from an original (converted formats,
+	// previews, renditions). They are addressed by the original's ID and a
+	// name unique per original, and are removed together with it.
+	SaveDerivative(ctx context.Context, id, name string, r io.Reader, contentType string) (FileInfo, error)
+	OpenDerivative(ctx context.Context, id, name string) (io.ReadSeekCloser, FileInfo, error)
+	DeleteDerivative(ctx context.Context, id, name string) error
+
+	// Ping checks that the backend accepts writes.
+	Ping(ctx context.Context) error
 }
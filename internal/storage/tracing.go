@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ondrasimku/media-service-go/internal/storage")
+
+// restorer matches backends that can overwrite a blob in place, see
+// integrity.Restorer.
+type restorer interface {
+	Restore(ctx context.Context, id, directory string, r io.Reader) error
+}
+
+// Traced wraps s to record a span for every operation. Opened readers are
+// not traced; the span covers finding the blob, and a blob that is not
+// found, such as a derivative not generated yet, is not an error. Backends
+// that can restore blobs still can when wrapped.
+func Traced(s Storage) Storage {
+	t := &traced{Storage: s}
+	if r, ok := s.(restorer); ok {
+		return &tracedRestorer{traced: t, restorer: r}
+	}
+	return t
+}
+
+type traced struct {
+	Storage
+}
+
+func (t *traced) Save(ctx context.Context, r io.Reader, opts SaveOptions) (FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.Save", trace.WithAttributes(
+		attribute.String("media.file_id", opts.ID),
+		attribute.String("media.directory", opts.Directory),
+	))
+	info, err := t.Storage.Save(ctx, r, opts)
+	span.SetAttributes(attribute.String("media.file_id", info.ID), attribute.Int64("media.size", info.Size))
+	endSpan(span, err)
+	return info, err
+}
+
+func (t *traced) Open(ctx context.Context, id string) (io.ReadSeekCloser, FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.Open", trace.WithAttributes(attribute.String("media.file_id", id)))
+	rc, info, err := t.Storage.Open(ctx, id)
+	span.SetAttributes(attribute.Bool("media.found", err == nil))
+	span.End()
+	return rc, info, err
+}
+
+func (t *traced) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "storage.Delete", trace.WithAttributes(attribute.String("media.file_id", id)))
+	err := t.Storage.Delete(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (t *traced) Replace(ctx context.Context, r io.Reader, opts SaveOptions, keep func(name string) bool) (FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.Replace", trace.WithAttributes(
+		attribute.String("media.file_id", opts.ID),
+		attribute.String("media.directory", opts.Directory),
+	))
+	info, err := t.Storage.Replace(ctx, r, opts, keep)
+	span.SetAttributes(attribute.Int64("media.size", info.Size))
+	endSpan(span, err)
+	return info, err
+}
+
+func (t *traced) Copy(ctx context.Context, id string, opts SaveOptions, keep func(name string) bool) (FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.Copy", trace.WithAttributes(
+		attribute.String("media.file_id", id),
+		attribute.String("media.directory", opts.Directory),
+	))
+	info, err := t.Storage.Copy(ctx, id, opts, keep)
+	span.SetAttributes(attribute.String("media.copy_id", info.ID), attribute.Int64("media.size", info.Size))
+	endSpan(span, err)
+	return info, err
+}
+
+func (t *traced) Move(ctx context.Context, id, directory string) (FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.Move", trace.WithAttributes(
+		attribute.String("media.file_id", id),
+		attribute.String("media.directory", directory),
+	))
+	info, err := t.Storage.Move(ctx, id, directory)
+	endSpan(span, err)
+	return info, err
+}
+
+func (t *traced) SaveDerivative(ctx context.Context, id, name string, r io.Reader, contentType string) (FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.SaveDerivative", trace.WithAttributes(
+		attribute.String("media.file_id", id),
+		attribute.String("media.derivative", name),
+	))
+	info, err := t.Storage.SaveDerivative(ctx, id, name, r, contentType)
+	span.SetAttributes(attribute.Int64("media.size", info.Size))
+	endSpan(span, err)
+	return info, err
+}
+
+func (t *traced) OpenDerivative(ctx context.Context, id, name string) (io.ReadSeekCloser, FileInfo, error) {
+	ctx, span := tracer.Start(ctx, "storage.OpenDerivative", trace.WithAttributes(
+		attribute.String("media.file_id", id),
+		attribute.String("media.derivative", name),
+	))
+	rc, info, err := t.Storage.OpenDerivative(ctx, id, name)
+	span.SetAttributes(attribute.Bool("media.found", err == nil))
+	span.End()
+	return rc, info, err
+}
+
+func (t *traced) DeleteDerivative(ctx context.Context, id, name string) error {
+	ctx, span := tracer.Start(ctx, "storage.DeleteDerivative", trace.WithAttributes(
+		attribute.String("media.file_id", id),
+		attribute.String("media.derivative", name),
+	))
+	err := t.Storage.DeleteDerivative(ctx, id, name)
+	endSpan(span, err)
+	return err
+}
+
+type tracedRestorer struct {
+	*traced
+	restorer restorer
+}
+
+func (t *tracedRestorer) Restore(ctx context.Context, id, directory string, r io.Reader) error {
+	ctx, span := tracer.Start(ctx, "storage.Restore", trace.WithAttributes(attribute.String("media.file_id", id)))
+	err := t.restorer.Restore(ctx, id, directory, r)
+	endSpan(span, err)
+	return err
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,193 @@
+package takedown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/audit"
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/webhook"
+)
+
+var (
+	ErrTakenDown    = errors.New("file is already taken down")
+	ErrNotTakenDown = errors.New("file is not taken down")
+)
+
+var actions = metrics.NewCounter("media_takedown_actions_total",
+	"Takedowns, counter-notices and restorations of files.", "action")
+
+// historyActions are the audited actions making up the takedown history
+// of a file.
+var historyActions = []string{domain.AuditTakedown, domain.AuditCounterNotice, domain.AuditRestore}
+
+// Desk handles copyright claims against files. A takedown withholds the
+// file from serving at once; the file stays in storage so that admins can
+// restore it, typically after a counter-notice from the uploader. Owners
+// are notified of both through the webhook.
+type Desk struct {
+	metadata metadata.Store
+	notifier *webhook.Notifier
+	audit    *audit.Trail
+	logger   *slog.Logger
+}
+
+func NewDesk(metadata metadata.Store, notifier *webhook.Notifier, trail *audit.Trail, logger *slog.Logger) *Desk {
+	d := &Desk{
+		metadata: metadata,
+		notifier: notifier,
+		audit:    trail,
+		logger:   logger,
+	}
+
+	metrics.NewGaugeFunc("media_takedowns_active", "Files currently taken down.", func() float64 {
+		files, err := d.TakenDown(context.Background())
+		if err != nil {
+			return 0
+		}
+		return float64(len(files))
+	})
+
+	return d
+}
+
+func (d *Desk) TakenDown(ctx context.Context) ([]domain.FileMetadata, error) {
+	return d.metadata.List(ctx, metadata.Filter{Status: domain.FileStatusTakenDown})
+}
+
+// Get returns a file with the history of takedowns, counter-notices and
+// restorations recorded for it, oldest first.
+func (d *Desk) Get(ctx context.Context, fileID string) (domain.FileMetadata, []domain.AuditEntry, error) {
+	meta, err := d.metadata.Get(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, nil, err
+	}
+	entries, err := d.audit.List(ctx, metadata.AuditFilter{FileID: fileID}, math.MaxInt)
+	if err != nil {
+		return domain.FileMetadata{}, nil, fmt.Errorf("failed to list takedown history: %w", err)
+	}
+	entries = slices.DeleteFunc(entries, func(e domain.AuditEntry) bool {
+		return !slices.Contains(historyActions, e.Action)
+	})
+	slices.Reverse(entries)
+	return meta, entries, nil
+}
+
+// TakeDown withholds a file from serving under claim. RequestedBy and
+// RequestedAt of the claim are filled in.
+func (d *Desk) TakeDown(ctx context.Context, fileID string, claim domain.Takedown, requestedBy string) (domain.FileMetadata, error) {
+	meta, err := d.metadata.Get(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if meta.Status == domain.FileStatusTakenDown {
+		return domain.FileMetadata{}, ErrTakenDown
+	}
+
+	claim.RequestedBy = requestedBy
+	claim.RequestedAt = time.Now().UTC()
+	claim.CounterNotice = nil
+	meta.Status = domain.FileStatusTakenDown
+	meta.Takedown = &claim
+	if err := d.metadata.Put(ctx, meta); err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to take down file: %w", err)
+	}
+
+	actions.Inc("takedown")
+	d.logger.Info("File taken down", "fileId", fileID, "claimant", claim.Claimant, "requestedBy", requestedBy)
+	d.audit.Record(ctx, domain.AuditTakedown, fileID, map[string]string{
+		"claimant": claim.Claimant,
+		"contact":  claim.Contact,
+		"work":     claim.Work,
+		"reason":   claim.Reason,
+	})
+	d.notifier.Notify(webhook.Event{
+		Type:   "file.taken_down",
+		FileID: fileID,
+		UserID: meta.OwnerID,
+		OrgID:  meta.OrgID,
+		Reason: claim.Reason,
+		Data: map[string]any{
+			"originalName": meta.OriginalName,
+			"claimant":     claim.Claimant,
+			"work":         claim.Work,
+		},
+	})
+	return meta, nil
+}
+
+// CounterNotice records the uploader's dispute of the takedown of a file,
+// replacing any earlier one. The file stays taken down until restored.
+func (d *Desk) CounterNotice(ctx context.Context, fileID, statement, filedBy string) (domain.FileMetadata, error) {
+	meta, err := d.takenDown(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	meta.Takedown.CounterNotice = &domain.CounterNotice{
+		Statement: statement,
+		FiledBy:   filedBy,
+		FiledAt:   time.Now().UTC(),
+	}
+	if err := d.metadata.Put(ctx, meta); err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to record counter-notice: %w", err)
+	}
+
+	actions.Inc("counter_notice")
+	d.logger.Info("Takedown counter-notice received", "fileId", fileID, "filedBy", filedBy)
+	d.audit.Record(ctx, domain.AuditCounterNotice, fileID, map[string]string{"statement": statement})
+	return meta, nil
+}
+
+// Restore serves a taken down file again. Files that were awaiting
+// moderation when taken down go back to the moderation queue.
+func (d *Desk) Restore(ctx context.Context, fileID, reason, restoredBy string) (domain.FileMetadata, error) {
+	meta, err := d.takenDown(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+
+	counterNoticed := meta.Takedown.CounterNotice != nil
+	meta.Status = domain.FileStatusActive
+	if meta.Quarantine != nil {
+		meta.Status = domain.FileStatusQuarantined
+	}
+	meta.Takedown = nil
+	if err := d.metadata.Put(ctx, meta); err != nil {
+		return domain.FileMetadata{}, fmt.Errorf("failed to restore file: %w", err)
+	}
+
+	actions.Inc("restore")
+	d.logger.Info("Taken down file restored", "fileId", fileID, "restoredBy", restoredBy, "reason", reason)
+	d.audit.Record(ctx, domain.AuditRestore, fileID, map[string]string{"reason": reason})
+	d.notifier.Notify(webhook.Event{
+		Type:   "file.restored",
+		FileID: fileID,
+		UserID: meta.OwnerID,
+		OrgID:  meta.OrgID,
+		Reason: reason,
+		Data: map[string]any{
+			"originalName":   meta.OriginalName,
+			"counterNoticed": counterNoticed,
+		},
+	})
+	return meta, nil
+}
+
+func (d *Desk) takenDown(ctx context.Context, fileID string) (domain.FileMetadata, error) {
+	meta, err := d.metadata.Get(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if meta.Status != domain.FileStatusTakenDown || meta.Takedown == nil {
+		return domain.FileMetadata{}, ErrNotTakenDown
+	}
+	return meta, nil
+}
@@ -0,0 +1,53 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP, configured with the standard OTEL_EXPORTER_OTLP_* variables,
+// and trace context is propagated in W3C traceparent and tracestate
+// headers. Instrumented packages get their tracers from the global
+// provider, so they record nothing until Setup installs an exporting one.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Setup installs the W3C trace context propagator and, if enabled, a
+// tracer provider exporting spans as serviceName. The returned function
+// flushes buffered spans and stops exporting.
+func Setup(ctx context.Context, enabled bool, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the
+	// defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tracing resource: %w", err)
+	}
+
+	// The sampler follows OTEL_TRACES_SAMPLER, parent-based always-on by
+	// default.
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,477 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/domain"
+	"github.com/ondrasimku/media-service-go/internal/events"
+	"github.com/ondrasimku/media-service-go/internal/governor"
+	"github.com/ondrasimku/media-service-go/internal/metadata"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"github.com/ondrasimku/media-service-go/internal/stats"
+	"github.com/ondrasimku/media-service-go/internal/storage"
+	"github.com/ondrasimku/media-service-go/internal/video"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ondrasimku/media-service-go/internal/transcode")
+
+var (
+	jobsTotal = metrics.NewCounter("media_transcode_jobs_total",
+		"Finished video transcoding jobs.", "result")
+	jobDuration = metrics.NewHistogram("media_transcode_duration_seconds",
+		"Time taken to transcode a video into all of its renditions.",
+		[]float64{5, 15, 30, 60, 120, 300, 600, 1800})
+)
+
+type Config struct {
+	// Heights are the rendition heights in pixels. Heights above the
+	// source are skipped; a source smaller than all of them is transcoded
+	// at its own height.
+	Heights []int
+
+	// Workers is the number of videos transcoded at the same time.
+	Workers int
+
+	// PosterOffset is where in the video the poster frame is taken.
+	PosterOffset time.Duration
+
+	// TeaserFormat is the format of the looping preview made alongside
+	// the poster, shaped by Teaser; empty skips it.
+	TeaserFormat string
+	Teaser       video.TeaserOptions
+
+	// SegmentDuration is the target length of HLS segments; zero skips
+	// HLS packaging.
+	SegmentDuration time.Duration
+
+	// Presets picks the encoding settings of a video by its collection,
+	// overriding Heights; nil encodes every video with the defaults.
+	Presets PresetSource
+
+	// Metering bills transcoding to the organizations owning the videos.
+	// Optional.
+	Metering *metering.Recorder
+}
+
+// PresetSource looks up the transcode preset of a collection.
+type PresetSource interface {
+	CollectionPreset(ctx context.Context, collection string) (domain.TranscodePreset, bool, error)
+}
+
+// Queue transcodes uploaded videos in the background. Job state is kept in
+// the file's metadata, so jobs interrupted by a restart are picked up again
+// by Run.
+type Queue struct {
+	storage    storage.Storage
+	metadata   metadata.Store
+	transcoder *video.Transcoder
+	cfg        Config
+	governor   *governor.Governor
+	stats      *stats.Recorder
+	events     *events.Emitter
+	logger     *slog.Logger
+
+	mu      sync.Mutex
+	pending []string
+	wake    chan struct{}
+}
+
+func NewQueue(storage storage.Storage, metadata metadata.Store, transcoder *video.Transcoder, cfg Config, governor *governor.Governor, stats *stats.Recorder, events *events.Emitter, logger *slog.Logger) *Queue {
+	q := &Queue{
+		storage:    storage,
+		metadata:   metadata,
+		transcoder: transcoder,
+		cfg:        cfg,
+		governor:   governor,
+		stats:      stats,
+		events:     events,
+		logger:     logger,
+		wake:       make(chan struct{}, 1),
+	}
+
+	metrics.NewGaugeFunc("media_transcode_queue_depth", "Videos waiting to be transcoded.", func() float64 {
+		return float64(q.Depth())
+	})
+
+	return q
+}
+
+// Enabled reports whether uploads should be queued at all. A nil queue is
+// disabled.
+func (q *Queue) Enabled() bool {
+	return q != nil && q.transcoder.Supported() && len(q.cfg.Heights) > 0 && q.cfg.Workers > 0
+}
+
+// Heights returns the default rendition heights, which transcode presets
+// of collections may override; nil when the queue is disabled.
+func (q *Queue) Heights() []int {
+	if !q.Enabled() {
+		return nil
+	}
+	return slices.Clone(q.cfg.Heights)
+}
+
+// HLS reports whether renditions are packaged for HLS as well.
+func (q *Queue) HLS() bool {
+	return q.Enabled() && q.cfg.SegmentDuration > 0
+}
+
+// Enqueue schedules a video whose metadata already records a pending job.
+func (q *Queue) Enqueue(fileID string) {
+	q.mu.Lock()
+	q.pending = append(q.pending, fileID)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Depth is the number of videos waiting to be transcoded. A nil queue has
+// none.
+func (q *Queue) Depth() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Run requeues jobs left unfinished by a previous run and transcodes
+// queued videos until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	if !q.Enabled() {
+		return
+	}
+
+	files, err := q.metadata.List(ctx, metadata.Filter{})
+	if err != nil {
+		q.logger.Error("Failed to list unfinished transcoding jobs", "error", err)
+	}
+	for _, f := range files {
+		if job := transcodeJob(f); job != nil && (job.Status == domain.TranscodePending || job.Status == domain.TranscodeProcessing) {
+			q.Enqueue(f.ID)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range q.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		fileID, ok := q.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			}
+			continue
+		}
+		// Wake another worker in case more jobs are waiting.
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+		q.process(ctx, fileID)
+	}
+}
+
+func (q *Queue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return "", false
+	}
+	fileID := q.pending[0]
+	q.pending = q.pending[1:]
+	return fileID, true
+}
+
+func (q *Queue) process(ctx context.Context, fileID string) {
+	meta, err := q.metadata.Get(ctx, fileID)
+	if err != nil {
+		// Deleted while queued.
+		return
+	}
+	if job := transcodeJob(meta); job == nil || job.Status == domain.TranscodeDone {
+		return
+	}
+
+	// The job waits here rather than in the queue, so it stays pending
+	// until there is capacity to run it.
+	release, err := q.governor.Acquire(ctx, "transcode", governor.Cost{
+		Memory: q.transcoder.Memory(meta.Video.Width, meta.Video.Height),
+		CPU:    q.transcoder.Threads(),
+	})
+	if errors.Is(err, governor.ErrTooLarge) {
+		q.finish(ctx, fileID, nil, err)
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer release()
+
+	if _, err := q.update(ctx, fileID, &domain.TranscodeJob{Status: domain.TranscodeProcessing}); err != nil {
+		q.logger.Error("Failed to start transcoding job", "fileId", fileID, "error", err)
+		return
+	}
+
+	spanCtx, span := tracer.Start(ctx, "transcode", trace.WithAttributes(
+		attribute.String("media.file_id", fileID),
+		attribute.Int("media.video_height", meta.Video.Height),
+	))
+	spanCtx, meter := metering.Start(spanCtx)
+	start := time.Now()
+	q.poster(spanCtx, meta)
+	q.teaser(spanCtx, meta)
+	done := &domain.TranscodeJob{Status: domain.TranscodeDone}
+	preset, err := q.preset(spanCtx, meta)
+	if err == nil {
+		done.Preset, done.PresetVersion = preset.Name, preset.Version
+		done.Renditions, err = q.transcode(spanCtx, meta, preset)
+	}
+	if err == nil && q.cfg.SegmentDuration > 0 {
+		err = q.packageHLS(spanCtx, fileID, done.Renditions)
+		done.HLS = err == nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	usage := meter.Usage()
+	done.CPUSeconds, done.ScratchBytes = usage.CPU.Seconds(), usage.Scratch
+	q.cfg.Metering.Record(ctx, meta.OrgID, "transcode", usage)
+	if ctx.Err() != nil {
+		// Shutting down; the job stays processing and is retried on start.
+		return
+	}
+	jobDuration.Observe(time.Since(start).Seconds())
+	q.finish(ctx, fileID, done, err)
+}
+
+// finish records the outcome of a job in the file's metadata: done, unless
+// err is set.
+func (q *Queue) finish(ctx context.Context, fileID string, done *domain.TranscodeJob, err error) {
+	job := done
+	if err != nil {
+		jobsTotal.Inc("failed")
+		q.logger.Error("Video transcoding failed", "fileId", fileID, "error", err)
+		job = &domain.TranscodeJob{Status: domain.TranscodeFailed, Error: err.Error()}
+		if done != nil {
+			job.CPUSeconds, job.ScratchBytes = done.CPUSeconds, done.ScratchBytes
+		}
+	} else {
+		jobsTotal.Inc("done")
+		q.logger.Info("Video transcoded", "fileId", fileID, "renditions", len(job.Renditions), "hls", job.HLS, "preset", job.Preset, "presetVersion", job.PresetVersion)
+	}
+	meta, err := q.update(ctx, fileID, job)
+	if err != nil {
+		q.logger.Error("Failed to record transcoding result", "fileId", fileID, "error", err)
+		return
+	}
+	q.events.Emit(events.Event{
+		Type:   events.Processed,
+		FileID: fileID,
+		File:   events.NewFile(meta),
+		Data:   map[string]any{"kind": "transcode", "status": job.Status, "renditions": len(job.Renditions), "hls": job.HLS, "preset": job.Preset, "presetVersion": job.PresetVersion},
+	})
+}
+
+// preset returns the transcode preset of the video's collection; the zero
+// preset when it has none.
+func (q *Queue) preset(ctx context.Context, meta domain.FileMetadata) (domain.TranscodePreset, error) {
+	if q.cfg.Presets == nil {
+		return domain.TranscodePreset{}, nil
+	}
+	preset, _, err := q.cfg.Presets.CollectionPreset(ctx, meta.Collection)
+	if err != nil {
+		return domain.TranscodePreset{}, fmt.Errorf("failed to look up transcode preset: %w", err)
+	}
+	return preset, nil
+}
+
+func (q *Queue) transcode(ctx context.Context, meta domain.FileMetadata, preset domain.TranscodePreset) ([]domain.VideoRendition, error) {
+	src, _, err := q.storage.Open(ctx, meta.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video: %w", err)
+	}
+	defer src.Close()
+
+	var renditions []domain.VideoRendition
+	enc := video.Encoding{Codec: preset.Codec, AudioBitrate: preset.AudioBitrate}
+	err = q.transcoder.Transcode(ctx, src, q.rungs(meta.Video.Height, preset), enc, func(r video.Rendition, data io.Reader) error {
+		info, err := q.storage.SaveDerivative(ctx, meta.ID, domain.RenditionDerivative(r.Height), data, video.MP4)
+		if err != nil {
+			return fmt.Errorf("failed to store rendition: %w", err)
+		}
+		q.stats.Record(stats.Jobs, info.Size)
+		renditions = append(renditions, domain.VideoRendition{Width: r.Width, Height: r.Height, Size: info.Size})
+		return nil
+	})
+	return renditions, err
+}
+
+// packageHLS splits the stored renditions into HLS segments. The master
+// playlist is not stored; it is built from the job when requested.
+func (q *Queue) packageHLS(ctx context.Context, fileID string, renditions []domain.VideoRendition) error {
+	for _, r := range renditions {
+		src, _, err := q.storage.OpenDerivative(ctx, fileID, domain.RenditionDerivative(r.Height))
+		if err != nil {
+			return fmt.Errorf("failed to open rendition: %w", err)
+		}
+		err = q.transcoder.Segment(ctx, src, domain.RenditionName(r.Height), q.cfg.SegmentDuration, func(name string, data io.Reader) error {
+			info, err := q.storage.SaveDerivative(ctx, fileID, domain.HLSDerivative(name), data, hlsContentType(name))
+			if err != nil {
+				return fmt.Errorf("failed to store HLS %s: %w", name, err)
+			}
+			q.stats.Record(stats.Jobs, info.Size)
+			return nil
+		})
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hlsContentType(name string) string {
+	if strings.HasSuffix(name, ".m3u8") {
+		return video.HLSPlaylist
+	}
+	return video.HLSSegment
+}
+
+// poster extracts the video's poster frame ahead of the renditions, so
+// galleries can show it early. Failures are only logged, as the poster is
+// extracted again when requested.
+func (q *Queue) poster(ctx context.Context, meta domain.FileMetadata) {
+	src, _, err := q.storage.Open(ctx, meta.ID)
+	if err != nil {
+		q.logger.Warn("Failed to open video for poster", "fileId", meta.ID, "error", err)
+		return
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := q.transcoder.Poster(ctx, src, q.cfg.PosterOffset, meta.Video.Duration, &buf); err != nil {
+		q.logger.Warn("Failed to extract video poster", "fileId", meta.ID, "error", err)
+		return
+	}
+	info, err := q.storage.SaveDerivative(ctx, meta.ID, domain.PosterDerivative, &buf, "image/jpeg")
+	if err != nil {
+		q.logger.Warn("Failed to store video poster", "fileId", meta.ID, "error", err)
+		return
+	}
+	q.stats.Record(stats.Jobs, info.Size)
+}
+
+// teaser makes the video's looping preview ahead of the renditions, for
+// the same reasons as its poster.
+func (q *Queue) teaser(ctx context.Context, meta domain.FileMetadata) {
+	if q.cfg.TeaserFormat == "" {
+		return
+	}
+	src, _, err := q.storage.Open(ctx, meta.ID)
+	if err != nil {
+		q.logger.Warn("Failed to open video for teaser", "fileId", meta.ID, "error", err)
+		return
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := q.transcoder.Teaser(ctx, src, q.cfg.TeaserFormat, q.cfg.Teaser, meta.Video.Duration, &buf); err != nil {
+		q.logger.Warn("Failed to make video teaser", "fileId", meta.ID, "error", err)
+		return
+	}
+	info, err := q.storage.SaveDerivative(ctx, meta.ID, domain.TeaserDerivative(q.cfg.TeaserFormat), &buf, video.TeaserContentType(q.cfg.TeaserFormat))
+	if err != nil {
+		q.logger.Warn("Failed to store video teaser", "fileId", meta.ID, "error", err)
+		return
+	}
+	q.stats.Record(stats.Jobs, info.Size)
+}
+
+// rungs picks the rungs of the preset's ladder, or the configured heights
+// without one, that a source of the given height can fill, smallest first.
+func (q *Queue) rungs(source int, preset domain.TranscodePreset) []video.Rung {
+	var ladder []video.Rung
+	if len(preset.Ladder) > 0 {
+		for _, r := range preset.Ladder {
+			ladder = append(ladder, video.Rung{Height: r.Height, Bitrate: r.Bitrate})
+		}
+	} else {
+		for _, h := range q.cfg.Heights {
+			ladder = append(ladder, video.Rung{Height: h})
+		}
+	}
+
+	var rungs []video.Rung
+	for _, r := range ladder {
+		if source == 0 || r.Height <= source {
+			rungs = append(rungs, r)
+		}
+	}
+	if len(rungs) == 0 {
+		// 4:2:0 chroma needs even dimensions. The smallest rung's bit rate
+		// is the closest fit for a source smaller than all of them.
+		rung := video.Rung{Height: max(source&^1, 2)}
+		if len(ladder) > 0 {
+			rung.Bitrate = slices.MinFunc(ladder, func(a, b video.Rung) int { return a.Height - b.Height }).Bitrate
+		}
+		rungs = []video.Rung{rung}
+	}
+	slices.SortFunc(rungs, func(a, b video.Rung) int { return a.Height - b.Height })
+	return slices.CompactFunc(rungs, func(a, b video.Rung) bool { return a.Height == b.Height })
+}
+
+// update records job as the file's transcoding state and returns the
+// updated metadata. The metadata is read again so changes made while
+// transcoding are kept.
+func (q *Queue) update(ctx context.Context, fileID string, job *domain.TranscodeJob) (domain.FileMetadata, error) {
+	meta, err := q.metadata.Get(ctx, fileID)
+	if err != nil {
+		return domain.FileMetadata{}, err
+	}
+	if meta.Video == nil {
+		return domain.FileMetadata{}, fmt.Errorf("file is not a video")
+	}
+	job.UpdatedAt = time.Now().UTC()
+	meta.Video.Transcode = job
+	return meta, q.metadata.Put(ctx, meta)
+}
+
+func transcodeJob(meta domain.FileMetadata) *domain.TranscodeJob {
+	if meta.Video == nil {
+		return nil
+	}
+	return meta.Video.Transcode
+}
@@ -0,0 +1,133 @@
+package tuning
+
+import (
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// Config tunes the Go runtime. Zero values keep the runtime defaults, which
+// honour the GOGC and GOMEMLIMIT environment variables.
+type Config struct {
+	// MaxProcs sets GOMAXPROCS; zero derives it from the container's CPU
+	// quota, as the runtime would otherwise use every core of the host.
+	MaxProcs int
+
+	// GCPercent sets the GC target percentage; negative turns proportional
+	// collection off, leaving MemoryLimit to trigger it.
+	GCPercent int
+
+	// MemoryLimit is the soft memory limit in bytes the collector works to
+	// stay under.
+	MemoryLimit int64
+}
+
+const (
+	gcPauseMetric   = "/cpu/classes/gc/pause:cpu-seconds"
+	gcCyclesMetric  = "/gc/cycles/total:gc-cycles"
+	gcPercentMetric = "/gc/gogc:percent"
+	memLimitMetric  = "/gc/gomemlimit:bytes"
+)
+
+// Apply configures the runtime and registers metrics describing it. It is
+// meant to run once at startup, before work is started.
+func Apply(cfg Config, logger *slog.Logger) {
+	if cfg.MaxProcs > 0 {
+		runtime.GOMAXPROCS(cfg.MaxProcs)
+	} else if _, err := maxprocs.Set(maxprocs.Logger(func(string, ...any) {})); err != nil {
+		logger.Warn("Failed to read container CPU quota", "error", err)
+	}
+	if cfg.GCPercent != 0 {
+		debug.SetGCPercent(cfg.GCPercent)
+	}
+	if cfg.MemoryLimit > 0 {
+		debug.SetMemoryLimit(cfg.MemoryLimit)
+	}
+
+	metrics.NewGaugeFunc("media_runtime_gomaxprocs", "Threads executing Go code at once.", func() float64 {
+		return float64(runtime.GOMAXPROCS(0))
+	})
+	metrics.NewGaugeFunc("media_runtime_gc_percent", "GC target percentage; -1 when proportional collection is off.", func() float64 {
+		// -1 when off, which the runtime reports wrapped around.
+		return float64(int64(read(gcPercentMetric).Uint64()))
+	})
+	metrics.NewGaugeFunc("media_runtime_memory_limit_bytes", "Soft memory limit of the runtime.", func() float64 {
+		return float64(read(memLimitMetric).Uint64())
+	})
+	metrics.NewGaugeFunc("media_runtime_gc_cycles", "Completed GC cycles.", func() float64 {
+		return float64(read(gcCyclesMetric).Uint64())
+	})
+	metrics.NewGaugeFunc("media_runtime_gc_pause_seconds", "Estimated time the program was stopped for GC.", func() float64 {
+		return PauseTime().Seconds()
+	})
+
+	logger.Info("Runtime configured",
+		"gomaxprocs", runtime.GOMAXPROCS(0),
+		"gcPercent", int64(read(gcPercentMetric).Uint64()),
+		"memoryLimit", int64(read(memLimitMetric).Uint64()))
+}
+
+// PauseTime estimates the total time the program has been stopped for GC.
+// The runtime updates it as collections finish, so differences only count
+// pauses of cycles that completed in between.
+func PauseTime() time.Duration {
+	// Pauses are accounted as CPU time of every P stopped.
+	cpu := read(gcPauseMetric).Float64()
+	return time.Duration(cpu / float64(runtime.GOMAXPROCS(0)) * float64(time.Second))
+}
+
+func read(name string) rtmetrics.Value {
+	sample := []rtmetrics.Sample{{Name: name}}
+	rtmetrics.Read(sample)
+	return sample[0].Value
+}
+
+// Stats is a snapshot of the runtime state for operators.
+type Stats struct {
+	GoVersion    string        `json:"goVersion"`
+	GOMAXPROCS   int           `json:"gomaxprocs"`
+	NumCPU       int           `json:"numCpu"`
+	Goroutines   int           `json:"goroutines"`
+	GCPercent    int64         `json:"gcPercent"`
+	MemoryLimit  int64         `json:"memoryLimit"`
+	HeapAlloc    uint64        `json:"heapAlloc"`
+	HeapInuse    uint64        `json:"heapInuse"`
+	HeapIdle     uint64        `json:"heapIdle"`
+	HeapReleased uint64        `json:"heapReleased"`
+	HeapObjects  uint64        `json:"heapObjects"`
+	Sys          uint64        `json:"sys"`
+	TotalAlloc   uint64        `json:"totalAlloc"`
+	NumGC        uint32        `json:"numGc"`
+	GCPauseTotal time.Duration `json:"gcPauseTotalNs"`
+	LastGC       time.Time     `json:"lastGc"`
+}
+
+// ReadStats takes a snapshot of the runtime. It stops the world briefly to
+// read the memory statistics.
+func ReadStats() Stats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return Stats{
+		GoVersion:    runtime.Version(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		Goroutines:   runtime.NumGoroutine(),
+		GCPercent:    int64(read(gcPercentMetric).Uint64()),
+		MemoryLimit:  int64(read(memLimitMetric).Uint64()),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapIdle:     mem.HeapIdle,
+		HeapReleased: mem.HeapReleased,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		TotalAlloc:   mem.TotalAlloc,
+		NumGC:        mem.NumGC,
+		GCPauseTotal: time.Duration(mem.PauseTotalNs),
+		LastGC:       time.Unix(0, int64(mem.LastGC)).UTC(),
+	}
+}
@@ -0,0 +1,95 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+const (
+	HLSPlaylist = "application/vnd.apple.mpegurl"
+	HLSSegment  = "video/mp2t"
+)
+
+// Segment packages an MP4 rendition read from src as an HLS stream of
+// MPEG-TS segments of about segmentDuration each, without re-encoding.
+// Segments are passed to emit as "<name>_00000.ts" and so on, then the
+// media playlist referencing them by those names as "<name>.m3u8".
+func (t *Transcoder) Segment(ctx context.Context, src io.Reader, name string, segmentDuration time.Duration, emit func(name string, r io.Reader) error) error {
+	if !t.Supported() {
+		return fmt.Errorf("video transcoding not supported")
+	}
+
+	workDir, err := os.MkdirTemp("", "media-hls-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	outDir := filepath.Join(workDir, "out")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	playlist := name + ".m3u8"
+	cmd := exec.CommandContext(ctx, t.path, "-nostdin", "-y", "-loglevel", "error",
+		"-i", in,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", strconv.FormatFloat(segmentDuration.Seconds(), 'f', -1, 64),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, name+"_%05d.ts"),
+		filepath.Join(outDir, playlist))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return fmt.Errorf("failed to list segments: %w", err)
+	}
+	var segments []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".ts") {
+			segments = append(segments, e.Name())
+		}
+	}
+	slices.Sort(segments)
+	if len(segments) == 0 {
+		return fmt.Errorf("ffmpeg produced no segments")
+	}
+
+	// The playlist goes last so it never lists a segment that is missing.
+	for _, file := range append(segments, playlist) {
+		if err := emitNamed(filepath.Join(outDir, file), file, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func emitNamed(path, name string, emit func(string, io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+	return emit(name, f)
+}
@@ -0,0 +1,154 @@
+package video
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// maxMoovSize bounds how much of an MP4 is read to find its metadata.
+const maxMoovSize = 64 << 20
+
+type box struct {
+	kind    string
+	payload []byte
+}
+
+// probeMP4 locates the moov box, wherever it is in the file, and reads the
+// movie header and the track headers inside it.
+func probeMP4(r io.ReaderAt, size int64) (Info, error) {
+	moov, err := findMoov(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	for _, b := range boxes(moov) {
+		switch b.kind {
+		case "mvhd":
+			info.Duration = mvhdDuration(b.payload)
+		case "trak":
+			mp4Track(b.payload, &info)
+		}
+	}
+	return info, nil
+}
+
+func findMoov(r io.ReaderAt, size int64) ([]byte, error) {
+	var header [16]byte
+	for off := int64(0); off+8 <= size; {
+		if _, err := r.ReadAt(header[:8], off); err != nil {
+			return nil, errMalformed
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if _, err := r.ReadAt(header[8:16], off+8); err != nil {
+				return nil, errMalformed
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if boxSize < headerLen || off+boxSize > size {
+			return nil, errMalformed
+		}
+		if kind == "moov" {
+			if boxSize > maxMoovSize {
+				return nil, errMalformed
+			}
+			moov := make([]byte, boxSize-headerLen)
+			if _, err := r.ReadAt(moov, off+headerLen); err != nil {
+				return nil, errMalformed
+			}
+			return moov, nil
+		}
+		off += boxSize
+	}
+	return nil, errMalformed
+}
+
+// boxes splits data into its child boxes, stopping at the first one that
+// does not fit.
+func boxes(data []byte) []box {
+	var out []box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		headerLen := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				break
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerLen = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			break
+		}
+		out = append(out, box{kind: kind, payload: data[headerLen:size]})
+		data = data[size:]
+	}
+	return out
+}
+
+func child(data []byte, kind string) []byte {
+	for _, b := range boxes(data) {
+		if b.kind == kind {
+			return b.payload
+		}
+	}
+	return nil
+}
+
+func mvhdDuration(p []byte) float64 {
+	var timescale, duration uint64
+	switch {
+	case len(p) >= 32 && p[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(p[20:]))
+		duration = binary.BigEndian.Uint64(p[24:])
+	case len(p) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(p[12:]))
+		duration = uint64(binary.BigEndian.Uint32(p[16:]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+// mp4Track fills in the codec (and for video the size) of the first video
+// and audio tracks.
+func mp4Track(trak []byte, info *Info) {
+	mdia := child(trak, "mdia")
+	hdlr := child(mdia, "hdlr")
+	if len(hdlr) < 12 {
+		return
+	}
+	stsd := child(child(child(mdia, "minf"), "stbl"), "stsd")
+	if len(stsd) < 16 {
+		return
+	}
+	codec := string(stsd[12:16]) // first sample entry after version, flags, count and size
+
+	switch string(hdlr[8:12]) {
+	case "vide":
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = codec
+		// tkhd ends with the display width and height in 16.16 fixed point.
+		if tkhd := child(trak, "tkhd"); len(tkhd) >= 8 {
+			info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+			info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		}
+	case "soun":
+		if info.AudioCodec == "" {
+			info.AudioCodec = codec
+		}
+	}
+}
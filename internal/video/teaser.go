@@ -0,0 +1,171 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// Teaser formats.
+const (
+	TeaserGIF  = "gif"
+	TeaserWebP = "webp"
+)
+
+// TeaserContentType returns the content type of a teaser format, or "" if
+// format is not one.
+func TeaserContentType(format string) string {
+	switch format {
+	case TeaserGIF:
+		return "image/gif"
+	case TeaserWebP:
+		return "image/webp"
+	}
+	return ""
+}
+
+// TeaserOptions shape the looping previews made by Teaser.
+type TeaserOptions struct {
+	Length time.Duration // How much of the video the teaser shows
+	Width  int           // Width in pixels; the aspect ratio is kept
+	FPS    int
+
+	// Highlight starts the teaser at the strongest scene change of the
+	// video rather than at its start.
+	Highlight bool
+}
+
+// sceneThreshold is the lowest scene score ffmpeg reports as a change.
+const sceneThreshold = 0.3
+
+// TeaserMemory estimates what making a teaser holds: the decoder's
+// reference frames plus the scaled frames buffered for the palette.
+func (t *Transcoder) TeaserMemory(width, height int, opts TeaserOptions) int64 {
+	if width == 0 || height == 0 {
+		width, height = 1920, 1080
+	}
+	frames := int64(opts.Length.Seconds()+1) * int64(max(opts.FPS, 1))
+	scaled := int64(opts.Width) * int64(opts.Width) * int64(height) / int64(width)
+	return 16*int64(width)*int64(height)*3/2 + 4*frames*scaled
+}
+
+// Teaser writes a short looping animation of the video read from src to
+// dst as an animated GIF or WebP. It shows opts.Length of the video from
+// its start or its highlight; videos shorter than that are shown whole.
+// duration is the length of the video in seconds, 0 if unknown.
+func (t *Transcoder) Teaser(ctx context.Context, src io.Reader, format string, opts TeaserOptions, duration float64, dst io.Writer) error {
+	if !t.Supported() {
+		return fmt.Errorf("video transcoding not supported")
+	}
+	if TeaserContentType(format) == "" {
+		return fmt.Errorf("unsupported teaser format %q", format)
+	}
+
+	workDir, err := os.MkdirTemp("", "media-teaser-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	var start time.Duration
+	if opts.Highlight {
+		if start, err = t.highlight(ctx, in); err != nil {
+			return err
+		}
+		// Keep a whole teaser inside the video.
+		if length := time.Duration(duration * float64(time.Second)); length > 0 && start+opts.Length > length {
+			start = max(length-opts.Length, 0)
+		}
+	}
+
+	scale := fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", max(opts.FPS, 1), opts.Width)
+	args := []string{"-nostdin", "-y", "-loglevel", "error",
+		"-threads", "1",
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(opts.Length.Seconds(), 'f', 3, 64),
+		"-i", in,
+		"-map", "0:v:0", "-an",
+	}
+	out := filepath.Join(workDir, "teaser."+format)
+	switch format {
+	case TeaserGIF:
+		// A palette made for the clip keeps GIF banding down.
+		args = append(args,
+			"-filter_complex", scale+",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer",
+			"-loop", "0", "-f", "gif")
+	case TeaserWebP:
+		args = append(args,
+			"-vf", scale,
+			"-c:v", "libwebp", "-q:v", "60", "-compression_level", "4",
+			"-loop", "0", "-f", "webp")
+	}
+	cmd := exec.CommandContext(ctx, t.path, append(args, out)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		return fmt.Errorf("no teaser made: %w", err)
+	}
+	defer f.Close()
+	if _, err := bufpool.Copy(dst, f); err != nil {
+		return fmt.Errorf("failed to write teaser: %w", err)
+	}
+	return nil
+}
+
+// highlight returns where the strongest scene change of the video at path
+// is, or 0 if it has none. Frames are scaled down before scoring, which is
+// enough to tell scenes apart.
+func (t *Transcoder) highlight(ctx context.Context, path string) (time.Duration, error) {
+	cmd := exec.CommandContext(ctx, t.path, "-nostdin", "-loglevel", "error",
+		"-threads", "1",
+		"-i", path,
+		"-map", "0:v:0", "-an",
+		"-vf", fmt.Sprintf("scale=160:-2,select='gt(scene,%g)',metadata=print:file=-", sceneThreshold),
+		"-f", "null", "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return 0, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	// Each selected frame is printed as a "frame:N pts:P pts_time:T" line
+	// followed by its "lavfi.scene_score=S".
+	var at, best, bestScore float64
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "pts_time:"); i >= 0 {
+			if fields := strings.Fields(line[i+len("pts_time:"):]); len(fields) > 0 {
+				at, _ = strconv.ParseFloat(fields[0], 64)
+			}
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "lavfi.scene_score="); ok {
+			if score, err := strconv.ParseFloat(v, 64); err == nil && score > bestScore {
+				best, bestScore = at, score
+			}
+		}
+	}
+	return time.Duration(best * float64(time.Second)), nil
+}
@@ -0,0 +1,262 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/bufpool"
+	"github.com/ondrasimku/media-service-go/internal/metering"
+)
+
+// Rendition describes one output of Transcode.
+type Rendition struct {
+	Width  int
+	Height int
+	Size   int64
+}
+
+// Transcoder normalizes videos into H.264 or H.265 with AAC in MP4 using
+// ffmpeg. When the tool is not installed transcoding is reported as
+// unsupported.
+type Transcoder struct {
+	path    string
+	threads int
+}
+
+// NewTranscoder looks up ffmpeg at path. Each encode uses up to threads
+// CPU threads.
+func NewTranscoder(path string, threads int) *Transcoder {
+	t := &Transcoder{threads: max(threads, 1)}
+	if p, err := exec.LookPath(path); err == nil {
+		t.path = p
+	}
+	return t
+}
+
+func (t *Transcoder) Supported() bool {
+	return t != nil && t.path != ""
+}
+
+func (t *Transcoder) Threads() int {
+	return t.threads
+}
+
+// Memory estimates what an encode of a source of the given size holds:
+// the decoder and x264 lookahead keep a few dozen 4:2:0 frames in flight.
+// Sources of unknown size are assumed to be 1080p.
+func (t *Transcoder) Memory(width, height int) int64 {
+	if width == 0 || height == 0 {
+		width, height = 1920, 1080
+	}
+	return 64 * int64(width) * int64(height) * 3 / 2
+}
+
+// Video codecs renditions can be encoded with.
+const (
+	CodecH264 = "h264"
+	CodecH265 = "h265"
+)
+
+// codecs are the encoder arguments of each codec and the constant quality
+// used for rungs without a bit rate. H.265 is tagged hvc1, which Apple
+// players require.
+var codecs = map[string]struct {
+	args []string
+	crf  string
+}{
+	CodecH264: {[]string{"-c:v", "libx264", "-preset", "veryfast"}, "23"},
+	CodecH265: {[]string{"-c:v", "libx265", "-preset", "fast", "-tag:v", "hvc1"}, "28"},
+}
+
+// SupportedCodec reports whether renditions can be encoded with codec.
+func SupportedCodec(codec string) bool {
+	_, ok := codecs[codec]
+	return ok
+}
+
+// Rung is a rendition of a bit rate ladder: its height in pixels and its
+// video bit rate in kbit/s, 0 to encode at constant quality.
+type Rung struct {
+	Height  int
+	Bitrate int
+}
+
+// Encoding selects how renditions are encoded. The zero value is H.264 at
+// constant quality with 128 kbit/s AAC.
+type Encoding struct {
+	Codec        string // CodecH264 or CodecH265; empty for H.264
+	AudioBitrate int    // kbit/s; 0 for 128
+}
+
+// Transcode encodes the video read from src once per rung, scaled to its
+// height with the aspect ratio kept, and passes each result to emit as
+// soon as it is ready. The output is seekable MP4 with the movie header up
+// front, so players can start before it is downloaded.
+func (t *Transcoder) Transcode(ctx context.Context, src io.Reader, rungs []Rung, enc Encoding, emit func(Rendition, io.Reader) error) error {
+	if !t.Supported() {
+		return fmt.Errorf("video transcoding not supported")
+	}
+	if enc.Codec == "" {
+		enc.Codec = CodecH264
+	}
+	if !SupportedCodec(enc.Codec) {
+		return fmt.Errorf("unsupported video codec %q", enc.Codec)
+	}
+	if enc.AudioBitrate == 0 {
+		enc.AudioBitrate = 128
+	}
+
+	workDir, err := os.MkdirTemp("", "media-transcode-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	for _, rung := range rungs {
+		out := filepath.Join(workDir, strconv.Itoa(rung.Height)+".mp4")
+		if err := t.encode(ctx, in, out, rung, enc); err != nil {
+			return err
+		}
+		if err := emitFile(out, rung.Height, emit); err != nil {
+			return err
+		}
+		metering.RemoveScratch(ctx, out)
+	}
+	return nil
+}
+
+// PosterMemory estimates what extracting a single frame holds: the
+// decoder's reference frames plus an RGB copy for the JPEG encoder.
+func (t *Transcoder) PosterMemory(width, height int) int64 {
+	if width == 0 || height == 0 {
+		width, height = 1920, 1080
+	}
+	return 16*int64(width)*int64(height)*3/2 + 3*int64(width)*int64(height)
+}
+
+// Poster writes the frame shown offset into the video read from src to dst
+// as a JPEG. A video shorter than offset (by duration in seconds, 0 if
+// unknown) gets the frame from its middle instead.
+func (t *Transcoder) Poster(ctx context.Context, src io.Reader, offset time.Duration, duration float64, dst io.Writer) error {
+	if !t.Supported() {
+		return fmt.Errorf("video transcoding not supported")
+	}
+	if length := time.Duration(duration * float64(time.Second)); length > 0 && offset >= length {
+		offset = length / 2
+	}
+
+	workDir, err := os.MkdirTemp("", "media-poster-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer metering.RemoveScratch(ctx, workDir)
+
+	in := filepath.Join(workDir, "in")
+	if err := writeFile(in, src); err != nil {
+		return err
+	}
+
+	out := filepath.Join(workDir, "poster.jpg")
+	cmd := exec.CommandContext(ctx, t.path, "-nostdin", "-y", "-loglevel", "error",
+		"-threads", "1",
+		"-ss", strconv.FormatFloat(offset.Seconds(), 'f', 3, 64),
+		"-i", in,
+		"-map", "0:v:0", "-frames:v", "1",
+		"-c:v", "mjpeg", "-q:v", "3",
+		"-f", "image2",
+		out)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		// ffmpeg exits cleanly when it finds no frame past the offset.
+		return fmt.Errorf("no frame at %s: %w", offset, err)
+	}
+	defer f.Close()
+	if _, err := bufpool.Copy(dst, f); err != nil {
+		return fmt.Errorf("failed to write poster: %w", err)
+	}
+	return nil
+}
+
+func (t *Transcoder) encode(ctx context.Context, in, out string, rung Rung, enc Encoding) error {
+	threads := strconv.Itoa(t.threads)
+	codec := codecs[enc.Codec]
+	args := []string{"-nostdin", "-y", "-loglevel", "error",
+		"-threads", threads, // decoder
+		"-i", in,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", "scale=-2:" + strconv.Itoa(rung.Height),
+	}
+	args = append(args, codec.args...)
+	if rung.Bitrate > 0 {
+		// Capped so segments stay close to the advertised bandwidth.
+		args = append(args, "-b:v", fmt.Sprintf("%dk", rung.Bitrate),
+			"-maxrate", fmt.Sprintf("%dk", rung.Bitrate*3/2), "-bufsize", fmt.Sprintf("%dk", rung.Bitrate*2))
+	} else {
+		args = append(args, "-crf", codec.crf)
+	}
+	args = append(args, "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", enc.AudioBitrate),
+		"-movflags", "+faststart",
+		"-threads", threads, // encoder
+		out)
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := metering.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// emitFile reads the width ffmpeg picked for an encoded rendition back
+// from its header and hands the file to emit.
+func emitFile(path string, height int, emit func(Rendition, io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open rendition: %w", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat rendition: %w", err)
+	}
+	rendition := Rendition{Height: height, Size: stat.Size()}
+	if info, err := Probe(f, stat.Size(), MP4); err == nil {
+		rendition.Width = info.Width
+	}
+	return emit(rendition, f)
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = bufpool.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
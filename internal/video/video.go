@@ -0,0 +1,53 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	MP4  = "video/mp4"
+	WebM = "video/webm"
+)
+
+var errMalformed = errors.New("malformed video container")
+
+// Info describes a video as read from its container headers.
+type Info struct {
+	Duration   float64 // Seconds
+	Width      int
+	Height     int
+	VideoCodec string // Sample entry (MP4) or codec ID (WebM) of the first video track
+	AudioCodec string // Same for the first audio track, empty if there is none
+}
+
+// Supported reports whether Probe understands the container.
+func Supported(contentType string) bool {
+	return contentType == MP4 || contentType == WebM
+}
+
+// Probe reads duration, dimensions and codecs from the container headers
+// of a video of the given size. Only the header structures are read, so
+// it is cheap even for very large files.
+func Probe(r io.ReaderAt, size int64, contentType string) (Info, error) {
+	var (
+		info Info
+		err  error
+	)
+	switch contentType {
+	case MP4:
+		info, err = probeMP4(r, size)
+	case WebM:
+		info, err = probeWebM(r, size)
+	default:
+		return Info{}, fmt.Errorf("unsupported video type %q", contentType)
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to read video header: %w", err)
+	}
+	if info.VideoCodec == "" {
+		return Info{}, fmt.Errorf("failed to read video header: no video track")
+	}
+	return info, nil
+}
@@ -0,0 +1,212 @@
+package video
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// EBML element IDs, with their length marker bits kept as is customary.
+const (
+	ebmlHeader      = 0x1A45DFA3
+	ebmlSegment     = 0x18538067
+	ebmlInfo        = 0x1549A966
+	ebmlTimescale   = 0x2AD7B1
+	ebmlDuration    = 0x4489
+	ebmlTracks      = 0x1654AE6B
+	ebmlTrackEntry  = 0xAE
+	ebmlTrackType   = 0x83
+	ebmlCodecID     = 0x86
+	ebmlVideo       = 0xE0
+	ebmlPixelWidth  = 0xB0
+	ebmlPixelHeight = 0xBA
+	ebmlCluster     = 0x1F43B675
+)
+
+// maxEBMLElement bounds the size of the Info and Tracks elements read into
+// memory.
+const maxEBMLElement = 1 << 20
+
+const unknownSize = math.MaxUint64
+
+// probeWebM walks the top-level elements of the segment until the first
+// cluster, reading the segment info and track list.
+func probeWebM(r io.ReaderAt, size int64) (Info, error) {
+	id, dataSize, off, err := readElementHeader(r, 0, size)
+	if err != nil || id != ebmlHeader || dataSize == unknownSize {
+		return Info{}, errMalformed
+	}
+	off += int64(dataSize)
+
+	id, _, off, err = readElementHeader(r, off, size)
+	if err != nil || id != ebmlSegment {
+		return Info{}, errMalformed
+	}
+
+	var (
+		info      Info
+		timescale uint64 = 1000000
+		duration  float64
+		seen      int
+	)
+	for off < size && seen < 2 {
+		id, dataSize, dataOff, err := readElementHeader(r, off, size)
+		if err != nil || id == ebmlCluster || dataSize == unknownSize {
+			break
+		}
+		if id == ebmlInfo || id == ebmlTracks {
+			if dataSize > maxEBMLElement {
+				return Info{}, errMalformed
+			}
+			data := make([]byte, dataSize)
+			if _, err := r.ReadAt(data, dataOff); err != nil {
+				return Info{}, errMalformed
+			}
+			if id == ebmlInfo {
+				timescale, duration = webmInfo(data, timescale)
+			} else {
+				webmTracks(data, &info)
+			}
+			seen++
+		}
+		off = dataOff + int64(dataSize)
+	}
+
+	// Duration is a float in timescale units of nanoseconds.
+	info.Duration = duration * float64(timescale) / 1e9
+	return info, nil
+}
+
+func webmInfo(data []byte, timescale uint64) (uint64, float64) {
+	var duration float64
+	for _, e := range elements(data) {
+		switch e.id {
+		case ebmlTimescale:
+			timescale = ebmlUint(e.data)
+		case ebmlDuration:
+			switch len(e.data) {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(e.data)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(e.data))
+			}
+		}
+	}
+	return timescale, duration
+}
+
+func webmTracks(data []byte, info *Info) {
+	for _, entry := range elements(data) {
+		if entry.id != ebmlTrackEntry {
+			continue
+		}
+		var (
+			trackType     uint64
+			codec         string
+			width, height int
+		)
+		for _, e := range elements(entry.data) {
+			switch e.id {
+			case ebmlTrackType:
+				trackType = ebmlUint(e.data)
+			case ebmlCodecID:
+				codec = string(e.data)
+			case ebmlVideo:
+				for _, v := range elements(e.data) {
+					switch v.id {
+					case ebmlPixelWidth:
+						width = int(ebmlUint(v.data))
+					case ebmlPixelHeight:
+						height = int(ebmlUint(v.data))
+					}
+				}
+			}
+		}
+		switch {
+		case trackType == 1 && info.VideoCodec == "":
+			info.VideoCodec, info.Width, info.Height = codec, width, height
+		case trackType == 2 && info.AudioCodec == "":
+			info.AudioCodec = codec
+		}
+	}
+}
+
+type element struct {
+	id   uint64
+	data []byte
+}
+
+// elements splits data into its child elements, stopping at the first one
+// that does not fit.
+func elements(data []byte) []element {
+	var out []element
+	for len(data) > 0 {
+		id, n := vint(data, true)
+		if n == 0 {
+			break
+		}
+		size, m := vint(data[n:], false)
+		if m == 0 || size > uint64(len(data)-n-m) {
+			break
+		}
+		start := n + m
+		out = append(out, element{id: id, data: data[start : start+int(size)]})
+		data = data[start+int(size):]
+	}
+	return out
+}
+
+func readElementHeader(r io.ReaderAt, off, size int64) (id, dataSize uint64, dataOff int64, err error) {
+	var buf [12]byte
+	n, err := r.ReadAt(buf[:min(int64(len(buf)), size-off)], off)
+	if n == 0 {
+		return 0, 0, 0, errMalformed
+	}
+	id, idLen := vint(buf[:n], true)
+	if idLen == 0 {
+		return 0, 0, 0, errMalformed
+	}
+	dataSize, sizeLen := vint(buf[idLen:n], false)
+	if sizeLen == 0 {
+		return 0, 0, 0, errMalformed
+	}
+	return id, dataSize, off + int64(idLen+sizeLen), nil
+}
+
+// vint decodes an EBML variable-length integer and returns it with its
+// length, or a zero length if data is truncated. IDs keep their length
+// marker; sizes drop it, and an all-ones size means unknown.
+func vint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || len(data) < n {
+		return 0, 0
+	}
+
+	v := uint64(data[0])
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	allOnes := v == uint64(0xFF>>n)
+	for _, b := range data[1:n] {
+		v = v<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, n
+	}
+	return v, n
+}
+
+func ebmlUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
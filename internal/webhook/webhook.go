@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const SignatureHeader = "X-Media-Signature"
+
+// ErrPrivateAddress is returned for tenant webhooks resolving to an
+// address that is not public.
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which some clouds
+// serve instance metadata from.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// PublicAddress reports whether addr is reachable on the internet, as
+// opposed to loopback, private, link-local or other special addresses of
+// the network the service runs in.
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// refusePrivate is a net.Dialer Control function refusing connections to
+// addresses that are not public. It checks the address dialled, after
+// name resolution, so a name cannot be made to resolve elsewhere later.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !PublicAddress(addr) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// tenantClient delivers to endpoints of tenants. It connects to public
+// addresses only, directly rather than through a proxy, and does not
+// follow redirects.
+var tenantClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: refusePrivate}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type Event struct {
+	Type       string         `json:"type"`
+	FileID     string         `json:"fileId,omitempty"`
+	UserID     string         `json:"userId,omitempty"`
+	OrgID      string         `json:"orgId,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+	OccurredAt time.Time      `json:"occurredAt"`
+}
+
+// Notifier delivers events to a single webhook endpoint. Payloads are signed
+// with HMAC-SHA256 over the body when a secret is configured. Delivery is
+// asynchronous and retried a few times; failures are only logged.
+type Notifier struct {
+	url        string
+	secret     []byte
+	httpClient *http.Client
+	logger     *slog.Logger
+}
+
+func NewNotifier(url, secret string, logger *slog.Logger) *Notifier {
+	return &Notifier{
+		url:        url,
+		secret:     []byte(secret),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		logger:     logger,
+	}
+}
+
+// NewTenantNotifier returns a Notifier for an endpoint registered by a
+// tenant rather than the operator, which must not reach the network the
+// service runs in: see tenantClient.
+func NewTenantNotifier(url, secret string, logger *slog.Logger) *Notifier {
+	n := NewNotifier(url, secret, logger)
+	n.httpClient = tenantClient
+	return n
+}
+
+func (n *Notifier) Notify(event Event) {
+	if n == nil || n.url == "" {
+		return
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	go func() {
+		backoff := time.Second
+		for attempt := 1; attempt <= 3; attempt++ {
+			err := n.send(context.Background(), event)
+			if err == nil {
+				return
+			}
+			n.logger.Warn("Webhook delivery failed", "type", event.Type, "attempt", attempt, "error", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+func (n *Notifier) send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package widget
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ondrasimku/media-service-go/internal/metrics"
+)
+
+var rateLimited = metrics.NewCounter("media_widget_rate_limited_total",
+	"Widget uploads rejected by the per-origin rate limit.")
+
+// Limiter is a token bucket per key (the widget origin). Each bucket holds
+// up to burst requests and refills at rate requests per second.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(perMinute, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a request from key's bucket. When the bucket is empty it
+// returns false and how long until the next request would be allowed.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		l.evict(now)
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		rateLimited.Inc()
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evict drops buckets that have refilled completely, so idle origins do not
+// accumulate. The caller holds l.mu.
+func (l *Limiter) evict(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
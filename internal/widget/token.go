@@ -0,0 +1,91 @@
+package widget
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid widget token")
+	ErrExpiredToken = errors.New("widget token expired")
+)
+
+// Token authorizes an embedded upload widget on one website to upload into
+// a tenant's account. It carries no permissions of its own: uploads made
+// with it are owned by the user that issued it and filed into Collection.
+type Token struct {
+	OwnerID    string    `json:"sub"`
+	OrgID      string    `json:"org,omitempty"`
+	Origin     string    `json:"origin"`
+	Collection string    `json:"collection,omitempty"`
+	ExpiresAt  time.Time `json:"exp"`
+}
+
+// Issuer signs and verifies widget tokens. Tokens are the base64url JSON
+// claims followed by "." and a base64url HMAC-SHA256 over them.
+type Issuer struct {
+	secret []byte
+}
+
+func NewIssuer(secret string) *Issuer {
+	return &Issuer{secret: []byte(secret)}
+}
+
+func (i *Issuer) Sign(t Token) (string, error) {
+	claims, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode widget token: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(i.mac(payload)), nil
+}
+
+// Verify checks the signature and expiry of a token.
+func (i *Issuer) Verify(s string, now time.Time) (Token, error) {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return Token{}, ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, i.mac(payload)) {
+		return Token{}, ErrInvalidToken
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	var t Token
+	if err := json.Unmarshal(claims, &t); err != nil || t.OwnerID == "" || t.Origin == "" {
+		return Token{}, ErrInvalidToken
+	}
+	if !now.Before(t.ExpiresAt) {
+		return Token{}, ErrExpiredToken
+	}
+	return t, nil
+}
+
+func (i *Issuer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, i.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// NormalizeOrigin validates a web origin (scheme://host[:port]) and returns
+// it in the form browsers send in the Origin header.
+func NormalizeOrigin(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("origin must be an http or https URL")
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("origin must not have a path, query or credentials")
+	}
+	return u.Scheme + "://" + strings.ToLower(u.Host), nil
+}